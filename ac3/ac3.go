@@ -0,0 +1,324 @@
+package ac3
+
+import (
+	"fmt"
+	"github.com/lkmio/avformat/bufio"
+)
+
+const (
+	SyncWord = 0x0B77
+
+	// BlockSamples 每个音频块256个采样, AC-3固定6个块
+	BlockSamples = 256
+
+	// E-AC-3的strmtyp
+	StreamTypeIndependent = 0
+	StreamTypeDependent   = 1
+	StreamTypeAC3Convert  = 2 // 由AC-3转换而来的独立子流
+
+	// 最小的头长度, 足够读取bsid
+	HeaderMinSize = 6
+)
+
+var (
+	sampleRates = [3]int{48000, 44100, 32000}
+
+	// frmsizecod/2对应的码率, 单位kbps
+	bitRates = [19]int{32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 448, 512, 576, 640}
+
+	// acmod对应的全频带声道
+	acmodLayouts = [8][]string{
+		{"Ch1", "Ch2"},
+		{"C"},
+		{"L", "R"},
+		{"L", "C", "R"},
+		{"L", "R", "S"},
+		{"L", "C", "R", "S"},
+		{"L", "R", "Ls", "Rs"},
+		{"L", "C", "R", "Ls", "Rs"},
+	}
+
+	numBlocks = [4]int{1, 2, 3, 6}
+)
+
+// Header 同步帧的syncinfo和bsi, 参考ETSI TS 102 366 4.3和E.1.2. E-AC-3只解析到bsmod
+type Header struct {
+	BSID        byte
+	Fscod       byte
+	Fscod2      byte // 仅E-AC-3, fscod为3时有效
+	Frmsizecod  byte // 仅AC-3
+	Bsmod       byte
+	Acmod       byte
+	LfeOn       byte
+	StreamType  byte   // 仅E-AC-3, strmtyp
+	SubstreamID byte   // 仅E-AC-3
+	Chanmap     uint16 // 仅E-AC-3的依赖子流, 0表示与acmod一致
+	NumBlocks   int
+	SampleRate  int
+	FrameSize   int // 同步帧的字节数
+	BitRate     int // kbps, E-AC-3根据帧长计算
+}
+
+// ParseHeader 解析同步帧头, bsid小于等于10按AC-3解析, 11-16按E-AC-3解析
+func ParseHeader(data []byte) (Header, error) {
+	if len(data) < HeaderMinSize {
+		return Header{}, fmt.Errorf("invalid ac3 header size %d", len(data))
+	} else if SyncWord != int(data[0])<<8|int(data[1]) {
+		return Header{}, fmt.Errorf("invalid ac3 sync word %02x%02x", data[0], data[1])
+	}
+
+	// 两种帧头的bsid位置相同
+	bsid := data[5] >> 3
+	if bsid <= 10 {
+		return parseAC3Header(data, bsid)
+	} else if bsid <= 16 {
+		return parseEAC3Header(data)
+	}
+
+	return Header{}, fmt.Errorf("unsupported ac3 bsid %d", bsid)
+}
+
+func parseAC3Header(data []byte, bsid byte) (h Header, err error) {
+	br := &bufio.BitsReader{Data: data, Offset: 32}
+	h.BSID = bsid
+	h.Fscod = byte(br.Read(2))
+	h.Frmsizecod = byte(br.Read(6))
+	if h.Fscod == 3 {
+		return h, fmt.Errorf("invalid ac3 fscod 3")
+	} else if int(h.Frmsizecod) >= 2*len(bitRates) {
+		return h, fmt.Errorf("invalid ac3 frmsizecod %d", h.Frmsizecod)
+	}
+
+	br.Seek(5)
+	h.Bsmod = byte(br.Read(3))
+	h.Acmod = byte(br.Read(3))
+	if h.Acmod&0x1 != 0 && h.Acmod != 0x1 {
+		// cmixlev
+		br.Seek(2)
+	}
+	if h.Acmod&0x4 != 0 {
+		// surmixlev
+		br.Seek(2)
+	}
+	if h.Acmod == 0x2 {
+		// dsurmod
+		br.Seek(2)
+	}
+	h.LfeOn = byte(br.Read(1))
+	if br.Offset > len(data)*8 {
+		return h, fmt.Errorf("invalid ac3 header size %d", len(data))
+	}
+
+	// bsid为9和10时, 采样率为1/2和1/4
+	shift := 0
+	if h.BSID > 8 {
+		shift = int(h.BSID) - 8
+	}
+
+	// 每帧的字数: 码率 * 1536 / 采样率 / 16, 44.1kHz不能整除, frmsizecod的最低位表示多补一个字
+	words := bitRates[h.Frmsizecod>>1] * 96000 / sampleRates[h.Fscod]
+	if h.Fscod == 1 {
+		words += int(h.Frmsizecod & 0x1)
+	}
+
+	h.NumBlocks = 6
+	h.SampleRate = sampleRates[h.Fscod] >> shift
+	h.FrameSize = words * 2
+	h.BitRate = bitRates[h.Frmsizecod>>1] >> shift
+	return
+}
+
+func parseEAC3Header(data []byte) (h Header, err error) {
+	br := &bufio.BitsReader{Data: data, Offset: 16}
+	h.StreamType = byte(br.Read(2))
+	h.SubstreamID = byte(br.Read(3))
+	h.FrameSize = (int(br.Read(11)) + 1) * 2
+	h.Fscod = byte(br.Read(2))
+	if h.StreamType == 3 {
+		return h, fmt.Errorf("invalid eac3 strmtyp 3")
+	}
+
+	var numblkscod int
+	if h.Fscod == 3 {
+		// 采样率减半, 固定6个块
+		h.Fscod2 = byte(br.Read(2))
+		if h.Fscod2 == 3 {
+			return h, fmt.Errorf("invalid eac3 fscod2 3")
+		}
+		numblkscod = 3
+		h.SampleRate = sampleRates[h.Fscod2] / 2
+	} else {
+		numblkscod = int(br.Read(2))
+		h.SampleRate = sampleRates[h.Fscod]
+	}
+
+	h.NumBlocks = numBlocks[numblkscod]
+	h.Acmod = byte(br.Read(3))
+	h.LfeOn = byte(br.Read(1))
+	h.BSID = byte(br.Read(5))
+	// dialnorm
+	br.Seek(5)
+	if compre := br.Read(1); compre != 0 {
+		// compr
+		br.Seek(8)
+	}
+	if h.Acmod == 0 {
+		// dialnorm2
+		br.Seek(5)
+		if compr2e := br.Read(1); compr2e != 0 {
+			br.Seek(8)
+		}
+	}
+	if h.StreamType == StreamTypeDependent {
+		if chanmape := br.Read(1); chanmape != 0 {
+			h.Chanmap = uint16(br.Read(16))
+		}
+	}
+
+	if mixmdate := br.Read(1); mixmdate != 0 {
+		skipMixingMetadata(br, &h, numblkscod)
+	}
+	if infomdate := br.Read(1); infomdate != 0 {
+		h.Bsmod = byte(br.Read(3))
+	}
+
+	if br.Offset > len(data)*8 {
+		return h, fmt.Errorf("invalid eac3 header size %d", len(data))
+	}
+
+	h.BitRate = h.FrameSize * 8 * h.SampleRate / (h.NumBlocks * BlockSamples * 1000)
+	return
+}
+
+// 跳过E-AC-3 bsi中的混音元数据
+func skipMixingMetadata(br *bufio.BitsReader, h *Header, numblkscod int) {
+	if h.Acmod > 0x2 {
+		// dmixmod
+		br.Seek(2)
+	}
+	if h.Acmod&0x1 != 0 && h.Acmod > 0x2 {
+		// ltrtcmixlev, lorocmixlev
+		br.Seek(6)
+	}
+	if h.Acmod&0x4 != 0 {
+		// ltrtsurmixlev, lorosurmixlev
+		br.Seek(6)
+	}
+	if h.LfeOn != 0 {
+		if lfemixlevcode := br.Read(1); lfemixlevcode != 0 {
+			br.Seek(5)
+		}
+	}
+	if h.StreamType != StreamTypeIndependent {
+		return
+	}
+
+	if pgmscle := br.Read(1); pgmscle != 0 {
+		br.Seek(6)
+	}
+	if h.Acmod == 0 {
+		if pgmscl2e := br.Read(1); pgmscl2e != 0 {
+			br.Seek(6)
+		}
+	}
+	if extpgmscle := br.Read(1); extpgmscle != 0 {
+		br.Seek(6)
+	}
+
+	switch mixdef := br.Read(2); mixdef {
+	case 1:
+		// premixcmpsel, drcsrc, premixcmpscl
+		br.Seek(5)
+	case 2:
+		br.Seek(12)
+	case 3:
+		mixdeflen := br.Read(5)
+		br.Seek(8 * (int(mixdeflen) + 2))
+	}
+
+	if h.Acmod < 0x2 {
+		if paninfoe := br.Read(1); paninfoe != 0 {
+			// panmean, paninfo
+			br.Seek(14)
+		}
+		if h.Acmod == 0 {
+			if paninfo2e := br.Read(1); paninfo2e != 0 {
+				br.Seek(14)
+			}
+		}
+	}
+
+	if frmmixcfginfoe := br.Read(1); frmmixcfginfoe != 0 {
+		if numblkscod == 0 {
+			br.Seek(5)
+		} else {
+			for i := 0; i < numBlocks[numblkscod]; i++ {
+				if blkmixcfginfoe := br.Read(1); blkmixcfginfoe != 0 {
+					br.Seek(5)
+				}
+			}
+		}
+	}
+}
+
+// IsEAC3 是否为E-AC-3同步帧
+func (h *Header) IsEAC3() bool {
+	return h.BSID > 10
+}
+
+// Channels 声道数, 包含LFE
+func (h *Header) Channels() int {
+	return len(acmodLayouts[h.Acmod]) + int(h.LfeOn)
+}
+
+// ChannelLayout 按码流中的顺序返回acmod和lfeon对应的声道, 不包含依赖子流扩展的声道
+func (h *Header) ChannelLayout() []string {
+	layout := append([]string{}, acmodLayouts[h.Acmod]...)
+	if h.LfeOn != 0 {
+		layout = append(layout, "LFE")
+	}
+	return layout
+}
+
+// Samples 同步帧的采样数
+func (h *Header) Samples() int {
+	return h.NumBlocks * BlockSamples
+}
+
+// Duration 返回以timebase为单位的同步帧时长
+func (h *Header) Duration(timebase int) int64 {
+	return int64(h.Samples()) * int64(timebase) / int64(h.SampleRate)
+}
+
+// SplitFrames 拆分连续的同步帧. E-AC-3从子流0开始, 到下一个子流0之前的所有子流作为一帧输出, 返回未处理的字节数
+func SplitFrames(data []byte, handler func(frame []byte, header Header)) (int, error) {
+	var err error
+	var first Header
+	start, offset := 0, 0
+	for offset < len(data) {
+		var header Header
+		if header, err = ParseHeader(data[offset:]); err != nil {
+			break
+		} else if offset+header.FrameSize > len(data) {
+			break
+		}
+
+		// 新的访问单元, 输出之前的帧
+		if offset > start && (!header.IsEAC3() || (header.StreamType != StreamTypeDependent && header.SubstreamID == 0)) {
+			handler(data[start:offset], first)
+			start = offset
+		}
+
+		if offset == start {
+			first = header
+		}
+		offset += header.FrameSize
+	}
+
+	if offset > start {
+		handler(data[start:offset], first)
+		start = offset
+	}
+
+	return len(data) - start, err
+}
@@ -0,0 +1,169 @@
+package ac3
+
+import (
+	"bytes"
+	"github.com/lkmio/avformat/bufio"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+func newFrame(size int, write func(bw *bufio.BitsWriter)) []byte {
+	frame := make([]byte, size)
+	bw := &bufio.BitsWriter{Data: frame}
+	bw.Write(16, SyncWord)
+	write(bw)
+	return frame
+}
+
+// 48kHz 384kbps 5.1
+func ac3Frame() []byte {
+	return newFrame(1536, func(bw *bufio.BitsWriter) {
+		bw.Seek(16)     // crc1
+		bw.Write(2, 0)  // fscod
+		bw.Write(6, 28) // frmsizecod
+		bw.Write(5, 8)  // bsid
+		bw.Write(3, 0)  // bsmod
+		bw.Write(3, 7)  // acmod
+		bw.Write(4, 0)  // cmixlev, surmixlev
+		bw.Write(1, 1)  // lfeon
+	})
+}
+
+// 独立子流5.1, 携带混音元数据. 依赖子流扩展Lrs/Rrs
+func eac3Frame() []byte {
+	independent := newFrame(512, func(bw *bufio.BitsWriter) {
+		bw.Write(2, StreamTypeIndependent)
+		bw.Write(3, 0)    // substreamid
+		bw.Write(11, 255) // frmsiz
+		bw.Write(2, 0)    // fscod
+		bw.Write(2, 3)    // numblkscod
+		bw.Write(3, 7)    // acmod
+		bw.Write(1, 1)    // lfeon
+		bw.Write(5, 16)   // bsid
+		bw.Write(5, 31)   // dialnorm
+		bw.Write(1, 0)    // compre
+		bw.Write(1, 1)    // mixmdate
+		bw.Write(2, 0)    // dmixmod
+		bw.Write(12, 0)   // ltrtcmixlev, lorocmixlev, ltrtsurmixlev, lorosurmixlev
+		bw.Write(1, 1)    // lfemixlevcode
+		bw.Write(5, 0)    // lfemixlevcod
+		bw.Write(2, 0)    // pgmscle, extpgmscle
+		bw.Write(2, 3)    // mixdef
+		bw.Write(5, 0)    // mixdeflen
+		bw.Seek(16)       // mixdata
+		bw.Write(1, 0)    // frmmixcfginfoe
+		bw.Write(1, 1)    // infomdate
+		bw.Write(3, 2)    // bsmod
+	})
+
+	dependent := newFrame(256, func(bw *bufio.BitsWriter) {
+		bw.Write(2, StreamTypeDependent)
+		bw.Write(3, 0)
+		bw.Write(11, 127)
+		bw.Write(2, 0)
+		bw.Write(2, 3)
+		bw.Write(3, 2)
+		bw.Write(1, 0)
+		bw.Write(5, 16)
+		bw.Write(5, 31)
+		bw.Write(1, 0)
+		bw.Write(1, 1)     // chanmape
+		bw.Write(16, 1<<9) // chanmap, Lrs/Rrs
+		bw.Write(2, 0)     // mixmdate, infomdate
+	})
+
+	return append(independent, dependent...)
+}
+
+func TestAC3Header(t *testing.T) {
+	frame := ac3Frame()
+	header, err := ParseHeader(frame)
+	if err != nil {
+		panic(err)
+	}
+
+	utils.Assert(!header.IsEAC3() && header.BSID == 8 && header.Acmod == 7 && header.LfeOn == 1)
+	utils.Assert(header.SampleRate == 48000 && header.FrameSize == 1536 && header.BitRate == 384)
+	utils.Assert(header.Channels() == 6 && header.Samples() == 1536 && header.Duration(90000) == 2880)
+	utils.Assert(len(header.ChannelLayout()) == 6 && header.ChannelLayout()[5] == "LFE")
+
+	// 44.1kHz, frmsizecod的最低位多补一个字
+	frame[4] = 0x40 | 29
+	header, err = ParseHeader(frame)
+	utils.Assert(err == nil && header.SampleRate == 44100 && header.FrameSize == 836*2)
+
+	box, err := ParseAC3SpecificBox(NewAC3SpecificBox(&header).Marshal())
+	utils.Assert(err == nil && *box == AC3SpecificBox{Fscod: 1, BSID: 8, Acmod: 7, LfeOn: 1, BitRateCode: 14})
+	utils.Assert(box.SampleRate() == 44100 && box.Channels() == 6)
+
+	_, err = ParseHeader([]byte{0x0B, 0x78, 0, 0, 0, 0x40})
+	utils.Assert(err != nil)
+}
+
+func TestEAC3Header(t *testing.T) {
+	frame := eac3Frame()
+	header, err := ParseHeader(frame)
+	if err != nil {
+		panic(err)
+	}
+
+	utils.Assert(header.IsEAC3() && header.StreamType == StreamTypeIndependent && header.FrameSize == 512)
+	utils.Assert(header.Bsmod == 2 && header.SampleRate == 48000 && header.NumBlocks == 6 && header.Channels() == 6)
+
+	dependent, err := ParseHeader(frame[512:])
+	utils.Assert(err == nil && dependent.StreamType == StreamTypeDependent && dependent.Chanmap == 1<<9)
+
+	// 依赖子流的声道计入dec3
+	box, err := NewEC3SpecificBox(frame)
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(box.DataRate == 192 && len(box.Substreams) == 1 && box.Substreams[0].NumDepSub == 1)
+	utils.Assert(box.Substreams[0].ChanLoc == 0x80 && box.Channels() == 8 && box.SampleRate() == 48000)
+
+	data := box.Marshal()
+	utils.Assert(len(data) == 6)
+	parsed, err := ParseEC3SpecificBox(data)
+	utils.Assert(err == nil && parsed.DataRate == box.DataRate && parsed.Substreams[0] == box.Substreams[0])
+
+	_, err = NewEC3SpecificBox(ac3Frame())
+	utils.Assert(err != nil)
+}
+
+func TestSplitFrames(t *testing.T) {
+	eac3 := eac3Frame()
+	data := bytes.Join([][]byte{eac3, eac3, eac3[:100]}, nil)
+
+	var frames [][]byte
+	remain, err := SplitFrames(data, func(frame []byte, header Header) {
+		utils.Assert(header.StreamType == StreamTypeIndependent)
+		frames = append(frames, frame)
+	})
+	utils.Assert(err == nil && remain == 100 && len(frames) == 2 && bytes.Equal(frames[1], eac3))
+
+	ac3 := ac3Frame()
+	frames = nil
+	remain, err = SplitFrames(append(append([]byte{}, ac3...), ac3...), func(frame []byte, header Header) {
+		frames = append(frames, frame)
+	})
+	utils.Assert(err == nil && remain == 0 && len(frames) == 2 && len(frames[0]) == 1536)
+}
+
+func TestDescriptor(t *testing.T) {
+	header, _ := ParseHeader(ac3Frame())
+	descriptor := NewAC3SpecificBox(&header).Descriptor()
+	data := descriptor.Marshal()
+	utils.Assert(bytes.Equal(data, []byte{DescriptorTagAC3, 3, 0xCF, 0x44, 8}))
+
+	parsed, err := ParseDescriptor(data)
+	utils.Assert(err == nil && !parsed.Enhanced && parsed.ComponentType == 0x44 && parsed.BSID == 8 && !parsed.MainIDFlag)
+
+	// 超过5.1声道
+	box, _ := NewEC3SpecificBox(eac3Frame())
+	data = box.Descriptor().Marshal()
+	parsed, err = ParseDescriptor(data)
+	utils.Assert(err == nil && parsed.Enhanced && parsed.ComponentType == 0x80|0x40|0x08|5 && parsed.BSID == 16)
+
+	_, err = ParseDescriptor([]byte{DescriptorTagAC3, 1, 0x80})
+	utils.Assert(err != nil)
+}
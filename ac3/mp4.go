@@ -0,0 +1,261 @@
+package ac3
+
+import (
+	"fmt"
+	"github.com/lkmio/avformat/bufio"
+)
+
+// chan_loc从高位开始每一位对应的声道数: Lc/Rc, Lrs/Rrs, Cs, Ts, Lsd/Rsd, Lw/Rw, Vhl/Vhr, Vhc, LFE2
+var chanLocChannels = [9]int{2, 2, 1, 1, 2, 2, 2, 1, 1}
+
+/*
+ETSI TS 102 366 F.4
+class AC3SpecificBox {
+	unsigned int(2) fscod;
+	unsigned int(5) bsid;
+	unsigned int(3) bsmod;
+	unsigned int(3) acmod;
+	unsigned int(1) lfeon;
+	unsigned int(5) bit_rate_code;
+	unsigned int(5) reserved = 0;
+}
+*/
+
+// AC3SpecificBox dac3的内容
+type AC3SpecificBox struct {
+	Fscod       byte
+	BSID        byte
+	Bsmod       byte
+	Acmod       byte
+	LfeOn       byte
+	BitRateCode byte // frmsizecod/2
+}
+
+func NewAC3SpecificBox(h *Header) *AC3SpecificBox {
+	return &AC3SpecificBox{
+		Fscod:       h.Fscod,
+		BSID:        h.BSID,
+		Bsmod:       h.Bsmod,
+		Acmod:       h.Acmod,
+		LfeOn:       h.LfeOn,
+		BitRateCode: h.Frmsizecod >> 1,
+	}
+}
+
+func ParseAC3SpecificBox(data []byte) (*AC3SpecificBox, error) {
+	if len(data) < 3 {
+		return nil, fmt.Errorf("invalid dac3 size %d", len(data))
+	}
+
+	br := &bufio.BitsReader{Data: data}
+	b := &AC3SpecificBox{
+		Fscod:       byte(br.Read(2)),
+		BSID:        byte(br.Read(5)),
+		Bsmod:       byte(br.Read(3)),
+		Acmod:       byte(br.Read(3)),
+		LfeOn:       byte(br.Read(1)),
+		BitRateCode: byte(br.Read(5)),
+	}
+
+	if b.Fscod == 3 {
+		return nil, fmt.Errorf("invalid dac3 fscod 3")
+	} else if int(b.BitRateCode) >= len(bitRates) {
+		return nil, fmt.Errorf("invalid dac3 bit rate code %d", b.BitRateCode)
+	}
+	return b, nil
+}
+
+func (b *AC3SpecificBox) Marshal() []byte {
+	data := make([]byte, 3)
+	bw := &bufio.BitsWriter{Data: data}
+	bw.Write(2, uint64(b.Fscod))
+	bw.Write(5, uint64(b.BSID))
+	bw.Write(3, uint64(b.Bsmod))
+	bw.Write(3, uint64(b.Acmod))
+	bw.Write(1, uint64(b.LfeOn))
+	bw.Write(5, uint64(b.BitRateCode))
+	return data
+}
+
+func (b *AC3SpecificBox) SampleRate() int {
+	return sampleRates[b.Fscod]
+}
+
+func (b *AC3SpecificBox) Channels() int {
+	return len(acmodLayouts[b.Acmod]) + int(b.LfeOn)
+}
+
+/*
+ETSI TS 102 366 F.6
+class EC3SpecificBox {
+	unsigned int(13) data_rate;
+	unsigned int(3) num_ind_sub;
+	for (i = 0; i < num_ind_sub + 1; i++) {
+		unsigned int(2) fscod;
+		unsigned int(5) bsid;
+		unsigned int(1) reserved = 0;
+		unsigned int(1) asvc;
+		unsigned int(3) bsmod;
+		unsigned int(3) acmod;
+		unsigned int(1) lfeon;
+		unsigned int(3) reserved = 0;
+		unsigned int(4) num_dep_sub;
+		if (num_dep_sub > 0) {
+			unsigned int(9) chan_loc;
+		} else {
+			unsigned int(1) reserved = 0;
+		}
+	}
+}
+*/
+
+// EC3Substream dec3中的一个独立子流, 以及依赖它的子流
+type EC3Substream struct {
+	Fscod     byte
+	BSID      byte
+	Asvc      byte
+	Bsmod     byte
+	Acmod     byte
+	LfeOn     byte
+	NumDepSub byte
+	ChanLoc   uint16 // 依赖子流额外的声道
+}
+
+// EC3SpecificBox dec3的内容
+type EC3SpecificBox struct {
+	DataRate   uint16 // kbps
+	Substreams []EC3Substream
+}
+
+// NewEC3SpecificBox 根据一个访问单元内的所有子流生成dec3
+func NewEC3SpecificBox(frame []byte) (*EC3SpecificBox, error) {
+	b := &EC3SpecificBox{}
+	var first Header
+	for offset := 0; offset < len(frame); {
+		h, err := ParseHeader(frame[offset:])
+		if err != nil {
+			return nil, err
+		} else if !h.IsEAC3() {
+			return nil, fmt.Errorf("not an eac3 frame, bsid %d", h.BSID)
+		} else if offset+h.FrameSize > len(frame) {
+			return nil, fmt.Errorf("invalid eac3 frame size %d", h.FrameSize)
+		}
+
+		if StreamTypeDependent != h.StreamType {
+			if len(b.Substreams) == 8 {
+				return nil, fmt.Errorf("too many eac3 independent substreams")
+			}
+
+			b.Substreams = append(b.Substreams, EC3Substream{
+				Fscod: h.Fscod,
+				BSID:  h.BSID,
+				Bsmod: h.Bsmod,
+				Acmod: h.Acmod,
+				LfeOn: h.LfeOn,
+			})
+		} else if len(b.Substreams) == 0 {
+			return nil, fmt.Errorf("eac3 dependent substream without independent substream")
+		} else {
+			// chanmap中Lc/Rc到Vhc对应chan_loc的前8位, LFE2对应最后一位
+			s := &b.Substreams[len(b.Substreams)-1]
+			s.NumDepSub++
+			s.ChanLoc |= (h.Chanmap>>2)&0x1FE | (h.Chanmap>>1)&0x1
+		}
+
+		if offset == 0 {
+			first = h
+		}
+		offset += h.FrameSize
+	}
+
+	if len(b.Substreams) == 0 {
+		return nil, fmt.Errorf("empty eac3 frame")
+	}
+
+	b.DataRate = uint16(len(frame) * 8 * first.SampleRate / (first.Samples() * 1000))
+	return b, nil
+}
+
+func ParseEC3SpecificBox(data []byte) (*EC3SpecificBox, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("invalid dec3 size %d", len(data))
+	}
+
+	br := &bufio.BitsReader{Data: data}
+	b := &EC3SpecificBox{DataRate: uint16(br.Read(13))}
+	b.Substreams = make([]EC3Substream, br.Read(3)+1)
+	for i := range b.Substreams {
+		s := &b.Substreams[i]
+		s.Fscod = byte(br.Read(2))
+		s.BSID = byte(br.Read(5))
+		br.Seek(1)
+		s.Asvc = byte(br.Read(1))
+		s.Bsmod = byte(br.Read(3))
+		s.Acmod = byte(br.Read(3))
+		s.LfeOn = byte(br.Read(1))
+		br.Seek(3)
+		s.NumDepSub = byte(br.Read(4))
+		if s.NumDepSub > 0 {
+			s.ChanLoc = uint16(br.Read(9))
+		} else {
+			br.Seek(1)
+		}
+	}
+
+	if br.Offset > len(data)*8 {
+		return nil, fmt.Errorf("invalid dec3 size %d", len(data))
+	}
+	return b, nil
+}
+
+func (b *EC3SpecificBox) Marshal() []byte {
+	size := 2
+	for _, s := range b.Substreams {
+		size += 3
+		if s.NumDepSub > 0 {
+			size++
+		}
+	}
+
+	data := make([]byte, size)
+	bw := &bufio.BitsWriter{Data: data}
+	bw.Write(13, uint64(b.DataRate))
+	bw.Write(3, uint64(len(b.Substreams)-1))
+	for _, s := range b.Substreams {
+		bw.Write(2, uint64(s.Fscod))
+		bw.Write(5, uint64(s.BSID))
+		bw.Seek(1)
+		bw.Write(1, uint64(s.Asvc))
+		bw.Write(3, uint64(s.Bsmod))
+		bw.Write(3, uint64(s.Acmod))
+		bw.Write(1, uint64(s.LfeOn))
+		bw.Seek(3)
+		bw.Write(4, uint64(s.NumDepSub))
+		if s.NumDepSub > 0 {
+			bw.Write(9, uint64(s.ChanLoc))
+		} else {
+			bw.Seek(1)
+		}
+	}
+	return data
+}
+
+// SampleRate 第一个独立子流的采样率, 降采样(fscod为3)时dec3中没有fscod2, 按24kHz处理
+func (b *EC3SpecificBox) SampleRate() int {
+	if b.Substreams[0].Fscod == 3 {
+		return sampleRates[0] / 2
+	}
+	return sampleRates[b.Substreams[0].Fscod]
+}
+
+// Channels 第一个独立子流及其依赖子流的声道数
+func (b *EC3SpecificBox) Channels() int {
+	s := b.Substreams[0]
+	channels := len(acmodLayouts[s.Acmod]) + int(s.LfeOn)
+	for i, n := range chanLocChannels {
+		if s.ChanLoc&(0x100>>i) != 0 {
+			channels += n
+		}
+	}
+	return channels
+}
@@ -0,0 +1,194 @@
+package ac3
+
+import (
+	"fmt"
+)
+
+const (
+	// DVB的descriptor_tag, 参考ETSI EN 300 468 附录D
+	DescriptorTagAC3         = 0x6A
+	DescriptorTagEnhancedAC3 = 0x7A
+)
+
+// Descriptor DVB的AC-3_descriptor和enhanced_AC-3_descriptor, 各个字段只在对应flag为true时存在
+type Descriptor struct {
+	Enhanced          bool
+	ComponentTypeFlag bool
+	BSIDFlag          bool
+	MainIDFlag        bool
+	ASVCFlag          bool
+	MixInfoExists     bool // 仅enhanced
+	ComponentType     byte
+	BSID              byte
+	MainID            byte
+	ASVC              byte
+	Substreams        []byte // 仅enhanced, substream1到substream3的component_type
+	AdditionalInfo    []byte
+}
+
+// ParseDescriptor 从descriptor_tag开始解析
+func ParseDescriptor(data []byte) (*Descriptor, error) {
+	if len(data) < 3 || 2+int(data[1]) > len(data) || data[1] < 1 {
+		return nil, fmt.Errorf("invalid ac3 descriptor size %d", len(data))
+	} else if DescriptorTagAC3 != data[0] && DescriptorTagEnhancedAC3 != data[0] {
+		return nil, fmt.Errorf("invalid ac3 descriptor tag 0x%x", data[0])
+	}
+
+	d := &Descriptor{Enhanced: DescriptorTagEnhancedAC3 == data[0]}
+	flags := data[2]
+	body := data[3 : 2+int(data[1])]
+	d.ComponentTypeFlag = flags&0x80 != 0
+	d.BSIDFlag = flags&0x40 != 0
+	d.MainIDFlag = flags&0x20 != 0
+	d.ASVCFlag = flags&0x10 != 0
+
+	// 依次读取flag为true的字段
+	fields := []struct {
+		flag bool
+		dst  *byte
+	}{
+		{d.ComponentTypeFlag, &d.ComponentType},
+		{d.BSIDFlag, &d.BSID},
+		{d.MainIDFlag, &d.MainID},
+		{d.ASVCFlag, &d.ASVC},
+	}
+
+	for _, field := range fields {
+		if !field.flag {
+			continue
+		} else if len(body) < 1 {
+			return nil, fmt.Errorf("invalid ac3 descriptor size %d", len(data))
+		}
+
+		*field.dst = body[0]
+		body = body[1:]
+	}
+
+	if d.Enhanced {
+		d.MixInfoExists = flags&0x08 != 0
+		for i := 0; i < 3; i++ {
+			if flags&(0x04>>i) == 0 {
+				continue
+			} else if len(body) < 1 {
+				return nil, fmt.Errorf("invalid eac3 descriptor size %d", len(data))
+			}
+
+			d.Substreams = append(d.Substreams, body[0])
+			body = body[1:]
+		}
+	}
+
+	d.AdditionalInfo = body
+	return d, nil
+}
+
+// Marshal 写入包括descriptor_tag和descriptor_length在内的完整描述符
+func (d *Descriptor) Marshal() []byte {
+	tag := byte(DescriptorTagAC3)
+	if d.Enhanced {
+		tag = DescriptorTagEnhancedAC3
+	}
+
+	data := []byte{tag, 0, 0}
+	if d.ComponentTypeFlag {
+		data[2] |= 0x80
+		data = append(data, d.ComponentType)
+	}
+	if d.BSIDFlag {
+		data[2] |= 0x40
+		data = append(data, d.BSID)
+	}
+	if d.MainIDFlag {
+		data[2] |= 0x20
+		data = append(data, d.MainID)
+	}
+	if d.ASVCFlag {
+		data[2] |= 0x10
+		data = append(data, d.ASVC)
+	}
+
+	if d.Enhanced {
+		if d.MixInfoExists {
+			data[2] |= 0x08
+		}
+		for i := 0; i < len(d.Substreams) && i < 3; i++ {
+			data[2] |= 0x04 >> i
+			data = append(data, d.Substreams[i])
+		}
+	} else {
+		// reserved_flags
+		data[2] |= 0x0F
+	}
+
+	data = append(data, d.AdditionalInfo...)
+	data[1] = byte(len(data) - 2)
+	return data
+}
+
+// 生成component_type, 参考EN 300 468 表D.1
+// enhanced(1) full_service(1) service_type(3) number_of_channels(3)
+func componentType(enhanced bool, bsmod, acmod byte, channels int) byte {
+	var t byte
+	if enhanced {
+		t |= 0x80
+	}
+
+	// service_type与bsmod基本对应, bsmod为1(M&E)时service_type为0且不是完整服务
+	service, full := bsmod, true
+	switch bsmod {
+	case 1:
+		service, full = 0, false
+	case 2, 3, 5, 6:
+		service--
+	case 4:
+		// 对白需要与主音频混合
+		service, full = 3, false
+	case 7:
+		// 单声道为画外音, 其他为卡拉OK
+		service = 7
+		if acmod == 1 {
+			service = 6
+		}
+	}
+
+	if full {
+		t |= 0x40
+	}
+	t |= service << 3
+
+	switch {
+	case acmod == 0:
+		t |= 1
+	case acmod == 1:
+		t |= 0
+	case acmod == 2:
+		t |= 2
+	case enhanced && channels > 6:
+		t |= 5
+	default:
+		t |= 4
+	}
+	return t
+}
+
+// Descriptor 生成AC-3_descriptor
+func (b *AC3SpecificBox) Descriptor() *Descriptor {
+	return &Descriptor{
+		ComponentTypeFlag: true,
+		BSIDFlag:          true,
+		ComponentType:     componentType(false, b.Bsmod, b.Acmod, b.Channels()),
+		BSID:              b.BSID,
+	}
+}
+
+// Descriptor 生成enhanced_AC-3_descriptor, 只描述第一个独立子流
+func (b *EC3SpecificBox) Descriptor() *Descriptor {
+	s := b.Substreams[0]
+	return &Descriptor{
+		Enhanced:          true,
+		ComponentTypeFlag: true,
+		BSIDFlag:          true,
+		ComponentType:     componentType(true, s.Bsmod, s.Acmod, b.Channels()),
+		BSID:              s.BSID,
+	}
+}
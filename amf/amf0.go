@@ -0,0 +1,516 @@
+package amf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// AMF0数据类型
+const (
+	AMF0Number      = 0x00
+	AMF0Boolean     = 0x01
+	AMF0String      = 0x02
+	AMF0Object      = 0x03
+	AMF0MovieClip   = 0x04 // 保留
+	AMF0Null        = 0x05
+	AMF0Undefined   = 0x06
+	AMF0Reference   = 0x07
+	AMF0ECMAArray   = 0x08
+	AMF0ObjectEnd   = 0x09
+	AMF0StrictArray = 0x0A
+	AMF0Date        = 0x0B
+	AMF0LongString  = 0x0C
+	AMF0Unsupported = 0x0D
+	AMF0RecordSet   = 0x0E // 保留
+	AMF0XMLDocument = 0x0F
+	AMF0TypedObject = 0x10
+	AMF0AVMPlus     = 0x11 // 切换到AMF3
+)
+
+// Undefined AMF0 undefined, Null使用nil表示
+type Undefined struct{}
+
+type Property struct {
+	Key   string
+	Value interface{}
+}
+
+// Object 保留属性顺序的AMF对象
+type Object []Property
+
+// Get 返回属性值
+func (o Object) Get(key string) (interface{}, bool) {
+	for _, property := range o {
+		if property.Key == key {
+			return property.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+// GetString 返回字符串属性, 类型不匹配返回空字符串
+func (o Object) GetString(key string) string {
+	value, _ := o.Get(key)
+	str, _ := value.(string)
+	return str
+}
+
+// GetNumber 返回数字属性, AMF3的整数也转换为float64, 类型不匹配返回0
+func (o Object) GetNumber(key string) float64 {
+	value, _ := o.Get(key)
+	switch number := value.(type) {
+	case float64:
+		return number
+	case int:
+		return float64(number)
+	default:
+		return 0
+	}
+}
+
+// GetBool 返回布尔属性, 类型不匹配返回false
+func (o Object) GetBool(key string) bool {
+	value, _ := o.Get(key)
+	b, _ := value.(bool)
+	return b
+}
+
+// Set 替换属性值, 不存在则添加
+func (o *Object) Set(key string, value interface{}) {
+	for i, property := range *o {
+		if property.Key == key {
+			(*o)[i].Value = value
+			return
+		}
+	}
+
+	*o = append(*o, Property{key, value})
+}
+
+// ECMAArray 关联数组, 例如onMetaData
+type ECMAArray Object
+
+// TypedObject 带类名的对象
+type TypedObject struct {
+	ClassName string
+	Object    Object
+}
+
+// XMLDocument XML文档, 按字符串保存
+type XMLDocument string
+
+// Encoder AMF0编码, 支持数字/bool/string/nil/Undefined/Object/ECMAArray/TypedObject/XMLDocument/[]interface{}/map[string]interface{}/time.Time.
+// Go的值没有引用语义, 编码时不生成Reference.
+type Encoder struct {
+	buffer []byte
+}
+
+func (e *Encoder) Bytes() []byte {
+	return e.buffer
+}
+
+func (e *Encoder) Reset() {
+	e.buffer = e.buffer[:0]
+}
+
+func (e *Encoder) WriteNumber(value float64) {
+	e.buffer = append(e.buffer, AMF0Number)
+	e.buffer = binary.BigEndian.AppendUint64(e.buffer, math.Float64bits(value))
+}
+
+func (e *Encoder) WriteBoolean(value bool) {
+	var b byte
+	if value {
+		b = 1
+	}
+
+	e.buffer = append(e.buffer, AMF0Boolean, b)
+}
+
+func (e *Encoder) WriteString(value string) {
+	if len(value) > 0xFFFF {
+		e.buffer = append(e.buffer, AMF0LongString)
+		e.buffer = binary.BigEndian.AppendUint32(e.buffer, uint32(len(value)))
+	} else {
+		e.buffer = append(e.buffer, AMF0String)
+		e.buffer = binary.BigEndian.AppendUint16(e.buffer, uint16(len(value)))
+	}
+
+	e.buffer = append(e.buffer, value...)
+}
+
+func (e *Encoder) WriteNull() {
+	e.buffer = append(e.buffer, AMF0Null)
+}
+
+// 属性名不带类型标记
+func (e *Encoder) writeKey(key string) {
+	e.buffer = binary.BigEndian.AppendUint16(e.buffer, uint16(len(key)))
+	e.buffer = append(e.buffer, key...)
+}
+
+func (e *Encoder) writeProperties(properties []Property) error {
+	for _, property := range properties {
+		e.writeKey(property.Key)
+		if err := e.Write(property.Value); err != nil {
+			return err
+		}
+	}
+
+	e.buffer = append(e.buffer, 0x00, 0x00, AMF0ObjectEnd)
+	return nil
+}
+
+func (e *Encoder) WriteObject(object Object) error {
+	e.buffer = append(e.buffer, AMF0Object)
+	return e.writeProperties(object)
+}
+
+func (e *Encoder) WriteECMAArray(array ECMAArray) error {
+	e.buffer = append(e.buffer, AMF0ECMAArray)
+	e.buffer = binary.BigEndian.AppendUint32(e.buffer, uint32(len(array)))
+	return e.writeProperties(array)
+}
+
+func (e *Encoder) WriteTypedObject(object TypedObject) error {
+	e.buffer = append(e.buffer, AMF0TypedObject)
+	e.writeKey(object.ClassName)
+	return e.writeProperties(object.Object)
+}
+
+func (e *Encoder) WriteXMLDocument(value XMLDocument) {
+	e.buffer = append(e.buffer, AMF0XMLDocument)
+	e.buffer = binary.BigEndian.AppendUint32(e.buffer, uint32(len(value)))
+	e.buffer = append(e.buffer, value...)
+}
+
+// WriteAMF3 切换到AMF3编码一个值
+func (e *Encoder) WriteAMF3(value interface{}) error {
+	encoder := Encoder3{buffer: append(e.buffer, AMF0AVMPlus)}
+	if err := encoder.Write(value); err != nil {
+		return err
+	}
+
+	e.buffer = encoder.buffer
+	return nil
+}
+
+func (e *Encoder) WriteStrictArray(array []interface{}) error {
+	e.buffer = append(e.buffer, AMF0StrictArray)
+	e.buffer = binary.BigEndian.AppendUint32(e.buffer, uint32(len(array)))
+	for _, value := range array {
+		if err := e.Write(value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteDate 毫秒时间戳+2字节时区(固定为0)
+func (e *Encoder) WriteDate(value time.Time) {
+	e.buffer = append(e.buffer, AMF0Date)
+	e.buffer = binary.BigEndian.AppendUint64(e.buffer, math.Float64bits(float64(value.UnixMilli())))
+	e.buffer = append(e.buffer, 0x00, 0x00)
+}
+
+// Write 根据Go类型编码
+func (e *Encoder) Write(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		e.WriteNull()
+	case Undefined:
+		e.buffer = append(e.buffer, AMF0Undefined)
+	case bool:
+		e.WriteBoolean(v)
+	case string:
+		e.WriteString(v)
+	case Object:
+		return e.WriteObject(v)
+	case ECMAArray:
+		return e.WriteECMAArray(v)
+	case TypedObject:
+		return e.WriteTypedObject(v)
+	case XMLDocument:
+		e.WriteXMLDocument(v)
+	case []interface{}:
+		return e.WriteStrictArray(v)
+	case map[string]interface{}:
+		return e.WriteObject(mapToObject(v))
+	case time.Time:
+		e.WriteDate(v)
+	default:
+		number, ok := toFloat64(value)
+		if !ok {
+			return fmt.Errorf("unsupported amf0 type %T", value)
+		}
+		e.WriteNumber(number)
+	}
+
+	return nil
+}
+
+// Encode 依次编码多个值, 例如RTMP命令
+func Encode(values ...interface{}) ([]byte, error) {
+	encoder := Encoder{}
+	for _, value := range values {
+		if err := encoder.Write(value); err != nil {
+			return nil, err
+		}
+	}
+
+	return encoder.Bytes(), nil
+}
+
+// Decoder AMF0解码, Number解码为float64, Null为nil, Object为保留顺序的Object.
+// Reference解码为之前解码的对象.
+type Decoder struct {
+	data    []byte
+	offset  int
+	objects []interface{} // 引用表
+}
+
+// More 是否还有未解码的数据
+func (d *Decoder) More() bool {
+	return d.offset < len(d.data)
+}
+
+func (d *Decoder) Offset() int {
+	return d.offset
+}
+
+func (d *Decoder) read(n int) ([]byte, error) {
+	if len(d.data)-d.offset < n {
+		return nil, fmt.Errorf("amf0 data too short")
+	}
+
+	bytes := d.data[d.offset : d.offset+n]
+	d.offset += n
+	return bytes, nil
+}
+
+func (d *Decoder) readString(lengthSize int) (string, error) {
+	bytes, err := d.read(lengthSize)
+	if err != nil {
+		return "", err
+	}
+
+	var length int
+	if lengthSize == 2 {
+		length = int(binary.BigEndian.Uint16(bytes))
+	} else {
+		length = int(binary.BigEndian.Uint32(bytes))
+	}
+
+	if bytes, err = d.read(length); err != nil {
+		return "", err
+	}
+
+	return string(bytes), nil
+}
+
+func (d *Decoder) readProperties() ([]Property, error) {
+	var properties []Property
+	for {
+		key, err := d.readString(2)
+		if err != nil {
+			return nil, err
+		}
+
+		// 空属性名+ObjectEnd结束
+		if key == "" && d.offset < len(d.data) && d.data[d.offset] == AMF0ObjectEnd {
+			d.offset++
+			return properties, nil
+		}
+
+		value, err := d.Read()
+		if err != nil {
+			return nil, err
+		}
+
+		properties = append(properties, Property{key, value})
+	}
+}
+
+// Read 解码一个值
+func (d *Decoder) Read() (interface{}, error) {
+	marker, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch marker[0] {
+	case AMF0Number:
+		bytes, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(bytes)), nil
+	case AMF0Boolean:
+		bytes, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		return bytes[0] != 0, nil
+	case AMF0String:
+		return d.readString(2)
+	case AMF0LongString:
+		return d.readString(4)
+	case AMF0Object:
+		// 先占位, 对象内部可以引用自身
+		index := len(d.objects)
+		d.objects = append(d.objects, nil)
+		properties, err := d.readProperties()
+		d.objects[index] = Object(properties)
+		return Object(properties), err
+	case AMF0TypedObject:
+		className, err := d.readString(2)
+		if err != nil {
+			return nil, err
+		}
+
+		index := len(d.objects)
+		d.objects = append(d.objects, nil)
+		properties, err := d.readProperties()
+		d.objects[index] = TypedObject{className, properties}
+		return d.objects[index], err
+	case AMF0Reference:
+		bytes, err := d.read(2)
+		if err != nil {
+			return nil, err
+		}
+
+		index := int(binary.BigEndian.Uint16(bytes))
+		if index >= len(d.objects) {
+			return nil, fmt.Errorf("invalid amf0 reference %d", index)
+		}
+		return d.objects[index], nil
+	case AMF0XMLDocument:
+		str, err := d.readString(4)
+		return XMLDocument(str), err
+	case AMF0Unsupported:
+		return Undefined{}, nil
+	case AMF0AVMPlus:
+		decoder := Decoder3{data: d.data, offset: d.offset}
+		value, err := decoder.Read()
+		d.offset = decoder.offset
+		return value, err
+	case AMF0Null:
+		return nil, nil
+	case AMF0Undefined:
+		return Undefined{}, nil
+	case AMF0ECMAArray:
+		// 数量不可靠, 以ObjectEnd为准
+		if _, err = d.read(4); err != nil {
+			return nil, err
+		}
+
+		index := len(d.objects)
+		d.objects = append(d.objects, nil)
+		properties, err := d.readProperties()
+		d.objects[index] = ECMAArray(properties)
+		return ECMAArray(properties), err
+	case AMF0StrictArray:
+		bytes, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+
+		count := int(binary.BigEndian.Uint32(bytes))
+		if count > len(d.data)-d.offset {
+			return nil, fmt.Errorf("invalid amf0 strict array count %d", count)
+		}
+
+		index := len(d.objects)
+		d.objects = append(d.objects, nil)
+		array := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			value, err := d.Read()
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		d.objects[index] = array
+		return array, nil
+	case AMF0Date:
+		bytes, err := d.read(10)
+		if err != nil {
+			return nil, err
+		}
+		return time.UnixMilli(int64(math.Float64frombits(binary.BigEndian.Uint64(bytes)))), nil
+	default:
+		return nil, fmt.Errorf("unsupported amf0 marker 0x%x", marker[0])
+	}
+}
+
+// Decode 解码所有值
+func Decode(data []byte) ([]interface{}, error) {
+	decoder := NewDecoder(data)
+	var values []interface{}
+	for decoder.More() {
+		value, err := decoder.Read()
+		if err != nil {
+			return values, err
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func NewDecoder(data []byte) *Decoder {
+	return &Decoder{data: data}
+}
+
+// 属性按key排序, 保证编码结果稳定
+func mapToObject(m map[string]interface{}) Object {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	object := make(Object, 0, len(m))
+	for _, key := range keys {
+		object = append(object, Property{key, m[key]})
+	}
+
+	return object
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,60 @@
+package amf
+
+import (
+	"github.com/lkmio/avformat/utils"
+	"testing"
+	"time"
+)
+
+func TestAMF0(t *testing.T) {
+	date := time.UnixMilli(1700000000000)
+	data, err := Encode("connect", 1, Object{{Key: "app", Value: "live"}, {Key: "audio", Value: true}}, nil, Undefined{},
+		ECMAArray{{Key: "width", Value: 1920}}, []interface{}{"a", 2.5}, date)
+	if err != nil {
+		panic(err)
+	}
+
+	values, err := Decode(data)
+	if err != nil {
+		panic(err)
+	}
+
+	utils.Assert(len(values) == 8)
+	utils.Assert(values[0] == "connect" && values[1] == float64(1))
+	object := values[2].(Object)
+	utils.Assert(object.GetString("app") == "live")
+	audio, _ := object.Get("audio")
+	utils.Assert(audio == true)
+	utils.Assert(values[3] == nil && values[4] == Undefined{})
+	utils.Assert(Object(values[5].(ECMAArray)).GetNumber("width") == 1920)
+	array := values[6].([]interface{})
+	utils.Assert(len(array) == 2 && array[0] == "a" && array[1] == 2.5)
+	utils.Assert(values[7].(time.Time).Equal(date))
+
+	_, err = Decode(data[:len(data)-1])
+	utils.Assert(err != nil)
+}
+
+func TestAMF0Reference(t *testing.T) {
+	object := Object{{Key: "a", Value: 1}}
+	data, err := Encode(object, TypedObject{ClassName: "Point", Object: Object{{Key: "x", Value: 1}}}, map[string]interface{}{"b": "c"}, XMLDocument("<a/>"))
+	if err != nil {
+		panic(err)
+	}
+
+	// 引用第一个对象
+	data = append(data, AMF0Reference, 0x00, 0x00)
+	values, err := Decode(data)
+	if err != nil {
+		panic(err)
+	}
+
+	utils.Assert(len(values) == 5)
+	utils.Assert(values[1].(TypedObject).ClassName == "Point")
+	utils.Assert(values[2].(Object).GetString("b") == "c")
+	utils.Assert(values[3] == XMLDocument("<a/>"))
+	utils.Assert(values[4].(Object).GetNumber("a") == 1)
+
+	_, err = Decode([]byte{AMF0Reference, 0x00, 0x05})
+	utils.Assert(err != nil)
+}
@@ -0,0 +1,693 @@
+package amf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AMF3数据类型
+const (
+	AMF3Undefined    = 0x00
+	AMF3Null         = 0x01
+	AMF3False        = 0x02
+	AMF3True         = 0x03
+	AMF3Integer      = 0x04
+	AMF3Double       = 0x05
+	AMF3String       = 0x06
+	AMF3XMLDocument  = 0x07
+	AMF3Date         = 0x08
+	AMF3Array        = 0x09
+	AMF3Object       = 0x0A
+	AMF3XML          = 0x0B
+	AMF3ByteArray    = 0x0C
+	AMF3VectorInt    = 0x0D
+	AMF3VectorUint   = 0x0E
+	AMF3VectorDouble = 0x0F
+	AMF3VectorObject = 0x10
+	AMF3Dictionary   = 0x11
+
+	// 29位有符号整数的范围, 超出的使用Double
+	amf3IntMax = 1<<28 - 1
+	amf3IntMin = -1 << 28
+)
+
+// DictionaryEntry AMF3 Dictionary的键值对, key可以是任意类型
+type DictionaryEntry struct {
+	Key   interface{}
+	Value interface{}
+}
+
+type Dictionary []DictionaryEntry
+
+// AMF3 trait, 描述对象的类名和密封成员
+type traits3 struct {
+	className      string
+	dynamic        bool
+	externalizable bool
+	members        []string
+}
+
+// Encoder3 AMF3编码, 字符串和trait使用引用表, 支持的Go类型和Encoder相同, 另外支持[]byte和[]int32/[]uint32/[]float64向量
+type Encoder3 struct {
+	buffer  []byte
+	strings map[string]int
+	traits  map[string]int
+}
+
+func (e *Encoder3) Bytes() []byte {
+	return e.buffer
+}
+
+// 变长29位无符号整数
+func (e *Encoder3) writeU29(value uint32) {
+	value &= 0x1FFFFFFF
+	switch {
+	case value < 0x80:
+		e.buffer = append(e.buffer, byte(value))
+	case value < 0x4000:
+		e.buffer = append(e.buffer, byte(value>>7|0x80), byte(value&0x7F))
+	case value < 0x200000:
+		e.buffer = append(e.buffer, byte(value>>14|0x80), byte(value>>7|0x80), byte(value&0x7F))
+	default:
+		e.buffer = append(e.buffer, byte(value>>22|0x80), byte(value>>15|0x80), byte(value>>8|0x80), byte(value))
+	}
+}
+
+// 字符串不带类型标记, 非空字符串写入引用表
+func (e *Encoder3) writeUTF8(value string) {
+	if value == "" {
+		e.buffer = append(e.buffer, 0x01)
+		return
+	}
+
+	if index, ok := e.strings[value]; ok {
+		e.writeU29(uint32(index) << 1)
+		return
+	}
+
+	if e.strings == nil {
+		e.strings = make(map[string]int)
+	}
+
+	e.strings[value] = len(e.strings)
+	e.writeU29(uint32(len(value))<<1 | 1)
+	e.buffer = append(e.buffer, value...)
+}
+
+func (e *Encoder3) writeDouble(value float64) {
+	e.buffer = append(e.buffer, AMF3Double)
+	e.buffer = binary.BigEndian.AppendUint64(e.buffer, math.Float64bits(value))
+}
+
+// 写入trait, 相同的trait使用引用
+func (e *Encoder3) writeTraits(t traits3) {
+	key := t.className + "\x00" + strings.Join(t.members, "\x00")
+	if t.dynamic {
+		key += "\x00dynamic"
+	}
+
+	if index, ok := e.traits[key]; ok {
+		e.writeU29(uint32(index)<<2 | 0x01)
+		return
+	}
+
+	if e.traits == nil {
+		e.traits = make(map[string]int)
+	}
+
+	e.traits[key] = len(e.traits)
+	flags := uint32(len(t.members))<<4 | 0x03
+	if t.dynamic {
+		flags |= 0x08
+	}
+
+	e.writeU29(flags)
+	e.writeUTF8(t.className)
+	for _, member := range t.members {
+		e.writeUTF8(member)
+	}
+}
+
+// WriteObject 编码为匿名动态对象
+func (e *Encoder3) WriteObject(object Object) error {
+	e.buffer = append(e.buffer, AMF3Object)
+	e.writeTraits(traits3{dynamic: true})
+	for _, property := range object {
+		e.writeUTF8(property.Key)
+		if err := e.Write(property.Value); err != nil {
+			return err
+		}
+	}
+
+	e.writeUTF8("")
+	return nil
+}
+
+// WriteTypedObject 编码为密封对象, 属性都是密封成员
+func (e *Encoder3) WriteTypedObject(object TypedObject) error {
+	t := traits3{className: object.ClassName}
+	for _, property := range object.Object {
+		t.members = append(t.members, property.Key)
+	}
+
+	e.buffer = append(e.buffer, AMF3Object)
+	e.writeTraits(t)
+	for _, property := range object.Object {
+		if err := e.Write(property.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteArray 编码数组, 关联部分和密集部分都可以为空
+func (e *Encoder3) WriteArray(assoc []Property, dense []interface{}) error {
+	e.buffer = append(e.buffer, AMF3Array)
+	e.writeU29(uint32(len(dense))<<1 | 1)
+	for _, property := range assoc {
+		e.writeUTF8(property.Key)
+		if err := e.Write(property.Value); err != nil {
+			return err
+		}
+	}
+
+	e.writeUTF8("")
+	for _, value := range dense {
+		if err := e.Write(value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Write 根据Go类型编码
+func (e *Encoder3) Write(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		e.buffer = append(e.buffer, AMF3Null)
+	case Undefined:
+		e.buffer = append(e.buffer, AMF3Undefined)
+	case bool:
+		if v {
+			e.buffer = append(e.buffer, AMF3True)
+		} else {
+			e.buffer = append(e.buffer, AMF3False)
+		}
+	case string:
+		e.buffer = append(e.buffer, AMF3String)
+		e.writeUTF8(v)
+	case XMLDocument:
+		e.buffer = append(e.buffer, AMF3XML)
+		e.writeU29(uint32(len(v))<<1 | 1)
+		e.buffer = append(e.buffer, v...)
+	case time.Time:
+		e.buffer = append(e.buffer, AMF3Date, 0x01)
+		e.buffer = binary.BigEndian.AppendUint64(e.buffer, math.Float64bits(float64(v.UnixMilli())))
+	case []byte:
+		e.buffer = append(e.buffer, AMF3ByteArray)
+		e.writeU29(uint32(len(v))<<1 | 1)
+		e.buffer = append(e.buffer, v...)
+	case Object:
+		return e.WriteObject(v)
+	case TypedObject:
+		return e.WriteTypedObject(v)
+	case map[string]interface{}:
+		return e.WriteObject(mapToObject(v))
+	case ECMAArray:
+		return e.WriteArray(v, nil)
+	case []interface{}:
+		return e.WriteArray(nil, v)
+	case []int32:
+		e.buffer = append(e.buffer, AMF3VectorInt)
+		e.writeU29(uint32(len(v))<<1 | 1)
+		e.buffer = append(e.buffer, 0x00)
+		for _, i := range v {
+			e.buffer = binary.BigEndian.AppendUint32(e.buffer, uint32(i))
+		}
+	case []uint32:
+		e.buffer = append(e.buffer, AMF3VectorUint)
+		e.writeU29(uint32(len(v))<<1 | 1)
+		e.buffer = append(e.buffer, 0x00)
+		for _, i := range v {
+			e.buffer = binary.BigEndian.AppendUint32(e.buffer, i)
+		}
+	case []float64:
+		e.buffer = append(e.buffer, AMF3VectorDouble)
+		e.writeU29(uint32(len(v))<<1 | 1)
+		e.buffer = append(e.buffer, 0x00)
+		for _, f := range v {
+			e.buffer = binary.BigEndian.AppendUint64(e.buffer, math.Float64bits(f))
+		}
+	case float32, float64:
+		number, _ := toFloat64(v)
+		e.writeDouble(number)
+	default:
+		number, ok := toFloat64(value)
+		if !ok {
+			return fmt.Errorf("unsupported amf3 type %T", value)
+		}
+
+		// 整数在29位范围内使用Integer
+		if number == math.Trunc(number) && number >= amf3IntMin && number <= amf3IntMax {
+			e.buffer = append(e.buffer, AMF3Integer)
+			e.writeU29(uint32(int32(number)))
+		} else {
+			e.writeDouble(number)
+		}
+	}
+
+	return nil
+}
+
+// Encode3 依次编码多个AMF3值, 共享引用表
+func Encode3(values ...interface{}) ([]byte, error) {
+	encoder := Encoder3{}
+	for _, value := range values {
+		if err := encoder.Write(value); err != nil {
+			return nil, err
+		}
+	}
+
+	return encoder.Bytes(), nil
+}
+
+// Decoder3 AMF3解码, Integer解码为int, Double为float64, 匿名对象为Object, 带类名的对象为TypedObject,
+// 只有密集部分的Array为[]interface{}, 否则为ECMAArray(密集部分的key为索引).
+type Decoder3 struct {
+	data    []byte
+	offset  int
+	strings []string
+	objects []interface{}
+	traits  []traits3
+}
+
+func (d *Decoder3) More() bool {
+	return d.offset < len(d.data)
+}
+
+func (d *Decoder3) Offset() int {
+	return d.offset
+}
+
+func (d *Decoder3) read(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.offset < n {
+		return nil, fmt.Errorf("amf3 data too short")
+	}
+
+	bytes := d.data[d.offset : d.offset+n]
+	d.offset += n
+	return bytes, nil
+}
+
+func (d *Decoder3) readU29() (uint32, error) {
+	var value uint32
+	for i := 0; i < 4; i++ {
+		b, err := d.read(1)
+		if err != nil {
+			return 0, err
+		}
+
+		if i == 3 {
+			return value<<8 | uint32(b[0]), nil
+		}
+
+		value = value<<7 | uint32(b[0]&0x7F)
+		if b[0]&0x80 == 0 {
+			break
+		}
+	}
+
+	return value, nil
+}
+
+// 读取引用或长度, 返回值为(长度或索引, 是否内联)
+func (d *Decoder3) readRef() (int, bool, error) {
+	value, err := d.readU29()
+	return int(value >> 1), value&1 == 1, err
+}
+
+func (d *Decoder3) readUTF8() (string, error) {
+	length, inline, err := d.readRef()
+	if err != nil {
+		return "", err
+	} else if !inline {
+		if length >= len(d.strings) {
+			return "", fmt.Errorf("invalid amf3 string reference %d", length)
+		}
+		return d.strings[length], nil
+	}
+
+	bytes, err := d.read(length)
+	if err != nil {
+		return "", err
+	}
+
+	str := string(bytes)
+	if str != "" {
+		d.strings = append(d.strings, str)
+	}
+	return str, nil
+}
+
+func (d *Decoder3) objectRef(index int) (interface{}, error) {
+	if index >= len(d.objects) {
+		return nil, fmt.Errorf("invalid amf3 object reference %d", index)
+	}
+
+	return d.objects[index], nil
+}
+
+// value为对象的U29头, 第2位为0时引用trait表
+func (d *Decoder3) readTraits(value uint32) (traits3, error) {
+	// 引用已有trait
+	if value&0x03 == 0x01 {
+		index := int(value >> 2)
+		if index >= len(d.traits) {
+			return traits3{}, fmt.Errorf("invalid amf3 traits reference %d", index)
+		}
+		return d.traits[index], nil
+	}
+
+	t := traits3{externalizable: value&0x07 == 0x07, dynamic: value&0x0F == 0x0B}
+	className, err := d.readUTF8()
+	if err != nil {
+		return t, err
+	}
+
+	t.className = className
+	if t.externalizable {
+		return t, fmt.Errorf("unsupported amf3 externalizable object %s", className)
+	}
+
+	count := int(value >> 4)
+	for i := 0; i < count; i++ {
+		member, err := d.readUTF8()
+		if err != nil {
+			return t, err
+		}
+		t.members = append(t.members, member)
+	}
+
+	d.traits = append(d.traits, t)
+	return t, nil
+}
+
+func (d *Decoder3) readObject() (interface{}, error) {
+	value, err := d.readU29()
+	if err != nil {
+		return nil, err
+	} else if value&1 == 0 {
+		return d.objectRef(int(value >> 1))
+	}
+
+	t, err := d.readTraits(value)
+	if err != nil {
+		return nil, err
+	}
+
+	index := len(d.objects)
+	d.objects = append(d.objects, nil)
+
+	var object Object
+	for _, member := range t.members {
+		value, err := d.Read()
+		if err != nil {
+			return nil, err
+		}
+		object = append(object, Property{member, value})
+	}
+
+	for t.dynamic {
+		key, err := d.readUTF8()
+		if err != nil {
+			return nil, err
+		} else if key == "" {
+			break
+		}
+
+		value, err := d.Read()
+		if err != nil {
+			return nil, err
+		}
+		object = append(object, Property{key, value})
+	}
+
+	var result interface{} = object
+	if t.className != "" {
+		result = TypedObject{t.className, object}
+	}
+
+	d.objects[index] = result
+	return result, nil
+}
+
+func (d *Decoder3) readArray() (interface{}, error) {
+	count, inline, err := d.readRef()
+	if err != nil {
+		return nil, err
+	} else if !inline {
+		return d.objectRef(count)
+	} else if count > len(d.data)-d.offset {
+		return nil, fmt.Errorf("invalid amf3 array count %d", count)
+	}
+
+	index := len(d.objects)
+	d.objects = append(d.objects, nil)
+
+	var assoc []Property
+	for {
+		key, err := d.readUTF8()
+		if err != nil {
+			return nil, err
+		} else if key == "" {
+			break
+		}
+
+		value, err := d.Read()
+		if err != nil {
+			return nil, err
+		}
+		assoc = append(assoc, Property{key, value})
+	}
+
+	dense := make([]interface{}, 0, count)
+	for i := 0; i < count; i++ {
+		value, err := d.Read()
+		if err != nil {
+			return nil, err
+		}
+		dense = append(dense, value)
+	}
+
+	var result interface{} = dense
+	if len(assoc) > 0 {
+		for i, value := range dense {
+			assoc = append(assoc, Property{strconv.Itoa(i), value})
+		}
+		result = ECMAArray(assoc)
+	}
+
+	d.objects[index] = result
+	return result, nil
+}
+
+// 读取int/uint/double/object向量
+func (d *Decoder3) readVector(marker byte) (interface{}, error) {
+	count, inline, err := d.readRef()
+	if err != nil {
+		return nil, err
+	} else if !inline {
+		return d.objectRef(count)
+	} else if count > len(d.data)-d.offset {
+		return nil, fmt.Errorf("invalid amf3 vector count %d", count)
+	}
+
+	// fixed-vector
+	if _, err = d.read(1); err != nil {
+		return nil, err
+	}
+
+	var result interface{}
+	switch marker {
+	case AMF3VectorInt, AMF3VectorUint:
+		bytes, err := d.read(count * 4)
+		if err != nil {
+			return nil, err
+		}
+
+		ints, uints := make([]int32, count), make([]uint32, count)
+		for i := range uints {
+			uints[i] = binary.BigEndian.Uint32(bytes[i*4:])
+			ints[i] = int32(uints[i])
+		}
+
+		if AMF3VectorInt == marker {
+			result = ints
+		} else {
+			result = uints
+		}
+	case AMF3VectorDouble:
+		bytes, err := d.read(count * 8)
+		if err != nil {
+			return nil, err
+		}
+
+		doubles := make([]float64, count)
+		for i := range doubles {
+			doubles[i] = math.Float64frombits(binary.BigEndian.Uint64(bytes[i*8:]))
+		}
+		result = doubles
+	default:
+		// 元素类型名
+		if _, err = d.readUTF8(); err != nil {
+			return nil, err
+		}
+
+		values := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			value, err := d.Read()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		result = values
+	}
+
+	d.objects = append(d.objects, result)
+	return result, nil
+}
+
+// Read 解码一个值
+func (d *Decoder3) Read() (interface{}, error) {
+	marker, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch marker[0] {
+	case AMF3Undefined:
+		return Undefined{}, nil
+	case AMF3Null:
+		return nil, nil
+	case AMF3False:
+		return false, nil
+	case AMF3True:
+		return true, nil
+	case AMF3Integer:
+		value, err := d.readU29()
+		// 29位有符号数
+		return int(int32(value<<3) >> 3), err
+	case AMF3Double:
+		bytes, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(bytes)), nil
+	case AMF3String:
+		return d.readUTF8()
+	case AMF3XMLDocument, AMF3XML, AMF3ByteArray:
+		length, inline, err := d.readRef()
+		if err != nil {
+			return nil, err
+		} else if !inline {
+			return d.objectRef(length)
+		}
+
+		bytes, err := d.read(length)
+		if err != nil {
+			return nil, err
+		}
+
+		var result interface{} = XMLDocument(bytes)
+		if AMF3ByteArray == marker[0] {
+			result = append([]byte{}, bytes...)
+		}
+
+		d.objects = append(d.objects, result)
+		return result, nil
+	case AMF3Date:
+		index, inline, err := d.readRef()
+		if err != nil {
+			return nil, err
+		} else if !inline {
+			return d.objectRef(index)
+		}
+
+		bytes, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+
+		date := time.UnixMilli(int64(math.Float64frombits(binary.BigEndian.Uint64(bytes))))
+		d.objects = append(d.objects, date)
+		return date, nil
+	case AMF3Array:
+		return d.readArray()
+	case AMF3Object:
+		return d.readObject()
+	case AMF3VectorInt, AMF3VectorUint, AMF3VectorDouble, AMF3VectorObject:
+		return d.readVector(marker[0])
+	case AMF3Dictionary:
+		count, inline, err := d.readRef()
+		if err != nil {
+			return nil, err
+		} else if !inline {
+			return d.objectRef(count)
+		} else if count > len(d.data)-d.offset {
+			return nil, fmt.Errorf("invalid amf3 dictionary count %d", count)
+		}
+
+		// weak-keys
+		if _, err = d.read(1); err != nil {
+			return nil, err
+		}
+
+		index := len(d.objects)
+		d.objects = append(d.objects, nil)
+		dictionary := make(Dictionary, 0, count)
+		for i := 0; i < count; i++ {
+			key, err := d.Read()
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := d.Read()
+			if err != nil {
+				return nil, err
+			}
+			dictionary = append(dictionary, DictionaryEntry{key, value})
+		}
+
+		d.objects[index] = dictionary
+		return dictionary, nil
+	default:
+		return nil, fmt.Errorf("unsupported amf3 marker 0x%x", marker[0])
+	}
+}
+
+// Decode3 解码所有AMF3值
+func Decode3(data []byte) ([]interface{}, error) {
+	decoder := NewDecoder3(data)
+	var values []interface{}
+	for decoder.More() {
+		value, err := decoder.Read()
+		if err != nil {
+			return values, err
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func NewDecoder3(data []byte) *Decoder3 {
+	return &Decoder3{data: data}
+}
@@ -0,0 +1,64 @@
+package amf
+
+import (
+	"bytes"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+	"time"
+)
+
+func TestAMF3(t *testing.T) {
+	date := time.UnixMilli(1700000000000)
+	object := Object{{Key: "name", Value: "avformat"}, {Key: "size", Value: 300}}
+	typed := TypedObject{ClassName: "flex.Point", Object: Object{{Key: "x", Value: 1.5}, {Key: "y", Value: -2}}}
+	data, err := Encode3(1, -268435456, 1<<28, 2.5, "name", "name", object, object, typed, typed, true, nil, Undefined{},
+		[]interface{}{"a", 1}, ECMAArray{{Key: "k", Value: "v"}}, []byte{1, 2, 3}, date, []int32{-1, 2}, []float64{0.5})
+	if err != nil {
+		panic(err)
+	}
+
+	values, err := Decode3(data)
+	if err != nil {
+		panic(err)
+	}
+
+	utils.Assert(len(values) == 19)
+	utils.Assert(values[0] == 1 && values[1] == -268435456 && values[2] == float64(1<<28) && values[3] == 2.5)
+	utils.Assert(values[4] == "name" && values[5] == "name")
+	for _, value := range values[6:8] {
+		decoded := value.(Object)
+		utils.Assert(decoded.GetString("name") == "avformat" && decoded.GetNumber("size") == 300)
+	}
+	for _, value := range values[8:10] {
+		decoded := value.(TypedObject)
+		utils.Assert(decoded.ClassName == "flex.Point" && decoded.Object.GetNumber("x") == 1.5 && decoded.Object.GetNumber("y") == -2)
+	}
+	utils.Assert(values[10] == true && values[11] == nil && values[12] == Undefined{})
+	array := values[13].([]interface{})
+	utils.Assert(len(array) == 2 && array[0] == "a" && array[1] == 1)
+	utils.Assert(Object(values[14].(ECMAArray)).GetString("k") == "v")
+	utils.Assert(bytes.Equal(values[15].([]byte), []byte{1, 2, 3}))
+	utils.Assert(values[16].(time.Time).Equal(date))
+	ints := values[17].([]int32)
+	utils.Assert(len(ints) == 2 && ints[0] == -1 && ints[1] == 2)
+	utils.Assert(values[18].([]float64)[0] == 0.5)
+
+	// 字符串和trait引用比内联短
+	single, _ := Encode3(object)
+	double, _ := Encode3(object, object)
+	utils.Assert(len(double)-len(single) < len(single))
+
+	// 对象引用: 0x0A 0x00 引用第一个对象
+	values, err = Decode3(append(append([]byte{}, single...), AMF3Object, 0x00))
+	utils.Assert(err == nil && len(values) == 2 && values[1].(Object).GetString("name") == "avformat")
+
+	// AMF0中切换到AMF3
+	encoder := Encoder{}
+	encoder.WriteString("onMetaData")
+	if err = encoder.WriteAMF3(object); err != nil {
+		panic(err)
+	}
+
+	values, err = Decode(encoder.Bytes())
+	utils.Assert(err == nil && len(values) == 2 && values[1].(Object).GetString("name") == "avformat")
+}
@@ -0,0 +1,139 @@
+package av1
+
+import (
+	"bytes"
+	"github.com/lkmio/avformat/bufio"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+func writeUVLC(w *bufio.BitsWriter, v uint64) {
+	length := 0
+	for tmp := v + 1; tmp > 1; tmp >>= 1 {
+		length++
+	}
+	w.Write(length, 0)
+	w.Write(length+1, v+1)
+}
+
+// 构造1080p 59.94帧 Main 10bit HDR10的sequence header OBU
+func newSequenceHeaderOBU() []byte {
+	w := &bufio.BitsWriter{Data: make([]byte, 64)}
+	w.Write(3, 0) // seq_profile
+	w.Write(2, 0) // still_picture, reduced_still_picture_header
+	w.Write(1, 1) // timing_info_present_flag
+	w.Write(32, 1001)
+	w.Write(32, 60000)
+	w.Write(1, 1) // equal_picture_interval
+	writeUVLC(w, 0)
+	w.Write(1, 0)  // decoder_model_info_present_flag
+	w.Write(1, 0)  // initial_display_delay_present_flag
+	w.Write(5, 0)  // operating_points_cnt_minus_1
+	w.Write(12, 0) // operating_point_idc
+	w.Write(5, 8)  // seq_level_idx, level 4.0
+	w.Write(1, 1)  // seq_tier
+	w.Write(4, 10)
+	w.Write(4, 10)
+	w.Write(11, 1919)
+	w.Write(11, 1079)
+	w.Write(1, 0)    // frame_id_numbers_present_flag
+	w.Write(3, 3)    // use_128x128_superblock, enable_filter_intra, enable_intra_edge_filter
+	w.Write(5, 0x1F) // enable_interintra_compound ... enable_order_hint
+	w.Write(2, 3)    // enable_jnt_comp, enable_ref_frame_mvs
+	w.Write(1, 1)    // seq_choose_screen_content_tools
+	w.Write(1, 1)    // seq_choose_integer_mv
+	w.Write(3, 6)    // order_hint_bits_minus_1
+	w.Write(3, 3)    // enable_superres, enable_cdef, enable_restoration
+	// color_config
+	w.Write(1, 1) // high_bitdepth
+	w.Write(1, 0) // mono_chrome
+	w.Write(1, 1) // color_description_present_flag
+	w.Write(8, 9)
+	w.Write(8, 16)
+	w.Write(8, 9)
+	w.Write(1, 0) // color_range
+	w.Write(2, 0) // chroma_sample_position
+	w.Write(1, 0) // separate_uv_delta_q
+	w.Write(1, 0) // film_grain_params_present
+	w.Write(1, 1) // trailing_one_bit
+	payload := w.Data[:(w.Offset+7)/8]
+
+	obu := []byte{byte(OBUSequenceHeader)<<3 | 0x02}
+	obu = AppendLeb128(obu, uint64(len(payload)))
+	return append(obu, payload...)
+}
+
+// 构造frame OBU, 只包含帧头开头的字段
+func newFrameOBU(frameType byte) []byte {
+	return []byte{byte(OBUFrame)<<3 | 0x02, 2, frameType<<5 | 0x10, 0xFF}
+}
+
+func TestSequenceHeader(t *testing.T) {
+	obu := newSequenceHeaderOBU()
+	seq, err := ParseSequenceHeaderOBU(obu)
+	if err != nil {
+		panic(err)
+	}
+
+	utils.Assert(seq.Width() == 1920 && seq.Height() == 1080 && seq.FrameRate() > 59.9 && seq.FrameRate() < 60)
+	utils.Assert(seq.OperatingPoints[0].SeqLevelIdx == 8 && seq.OperatingPoints[0].SeqTier == 1 && seq.OrderHintBits == 7)
+	utils.Assert(seq.SeqForceScreenContentTools == SelectScreenContentTools && seq.SeqForceIntegerMv == SelectIntegerMv && seq.EnableCdef == 1)
+	color := seq.ColorConfig
+	utils.Assert(color.BitDepth == 10 && color.ColorPrimaries == 9 && color.TransferCharacteristics == 16 && color.SubsamplingX == 1 && color.SubsamplingY == 1)
+
+	// 关键帧需要同一个temporal unit中的sequence header
+	td := []byte{byte(OBUTemporalDelimiter)<<3 | 0x02, 0}
+	key := append(append(append([]byte{}, td...), obu...), newFrameOBU(FrameTypeKey)...)
+	inter := append(append([]byte{}, td...), newFrameOBU(FrameTypeInter)...)
+	utils.Assert(IsKeyFrame(key) && !IsKeyFrame(inter) && !IsKeyFrame(newFrameOBU(FrameTypeKey)))
+	utils.Assert(bytes.Equal(FindSequenceHeader(key), obu) && bytes.Equal(RemoveTemporalDelimiter(inter), newFrameOBU(FrameTypeInter)))
+
+	// Annex B和Low Overhead互转
+	annexB, err := LowOverhead2AnnexB(key)
+	if err != nil {
+		panic(err)
+	}
+	var types []OBUType
+	err = SplitAnnexB(annexB, func(obu OBU) bool {
+		types = append(types, obu.Header.Type)
+		return !obu.Header.HasSizeField
+	})
+	utils.Assert(err == nil && len(types) == 3 && types[1] == OBUSequenceHeader)
+
+	lowOverhead, err := AnnexB2LowOverhead(annexB)
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(bytes.Equal(lowOverhead, key))
+
+	value, n, err := ReadLeb128(AppendLeb128(nil, 300))
+	utils.Assert(err == nil && value == 300 && n == 2 && Leb128Size(300) == 2)
+	_, _, err = ReadLeb128([]byte{0x80})
+	utils.Assert(err != nil)
+}
+
+func TestAV1CodecConfigurationRecord(t *testing.T) {
+	// 不携带obu_size的sequence header
+	obu := newSequenceHeaderOBU()
+	header, _ := ParseOBUHeader(obu)
+	_, n, _ := ReadLeb128(obu[1:])
+	header.HasSizeField = false
+	noSize := append(header.Marshal(nil), obu[1+n:]...)
+
+	record := AV1CodecConfigurationRecord{}
+	if err := record.UpdateFromSequenceHeader(noSize); err != nil {
+		panic(err)
+	}
+	utils.Assert(record.SeqLevelIdx0 == 8 && record.SeqTier0 == 1 && record.HighBitdepth == 1 && record.TwelveBit == 0)
+	utils.Assert(record.ChromaSubsamplingX == 1 && record.ChromaSubsamplingY == 1 && bytes.Equal(record.SequenceHeaderOBU(), obu))
+
+	data := record.Marshal()
+	utils.Assert(data[0] == 0x81 && data[1] == 0x08 && data[2] == 0xCC && data[3] == 0)
+
+	other := AV1CodecConfigurationRecord{}
+	if err := other.Unmarshal(data); err != nil {
+		panic(err)
+	}
+	utils.Assert(other.Version == 1 && other.SeqTier0 == 1 && bytes.Equal(other.Marshal(), data))
+	utils.Assert(other.Unmarshal([]byte{0x01, 0, 0, 0}) != nil)
+}
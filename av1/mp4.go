@@ -0,0 +1,128 @@
+package av1
+
+import (
+	"fmt"
+)
+
+/*
+aligned(8) class AV1CodecConfigurationRecord {
+unsigned int(1) marker = 1;
+unsigned int(7) version = 1;
+unsigned int(3) seq_profile;
+unsigned int(5) seq_level_idx_0;
+unsigned int(1) seq_tier_0;
+unsigned int(1) high_bitdepth;
+unsigned int(1) twelve_bit;
+unsigned int(1) monochrome;
+unsigned int(1) chroma_subsampling_x;
+unsigned int(1) chroma_subsampling_y;
+unsigned int(2) chroma_sample_position;
+unsigned int(3) reserved = 0;
+unsigned int(1) initial_presentation_delay_present;
+if(initial_presentation_delay_present) {
+unsigned int(4) initial_presentation_delay_minus_one;
+} else {
+unsigned int(4) reserved = 0;
+}
+unsigned int(8) configOBUs[];
+}
+*/
+
+type AV1CodecConfigurationRecord struct {
+	Version                          byte
+	SeqProfile                       byte
+	SeqLevelIdx0                     byte
+	SeqTier0                         byte
+	HighBitdepth                     byte
+	TwelveBit                        byte
+	Monochrome                       byte
+	ChromaSubsamplingX               byte
+	ChromaSubsamplingY               byte
+	ChromaSamplePosition             byte
+	InitialPresentationDelayPresent  byte
+	InitialPresentationDelayMinusOne byte
+
+	ConfigOBUs []byte // Low Overhead格式, 一般只包含sequence header
+}
+
+func (a *AV1CodecConfigurationRecord) Marshal() []byte {
+	data := make([]byte, 4, 4+len(a.ConfigOBUs))
+	data[0] = 0x81
+	data[1] = a.SeqProfile<<5 | a.SeqLevelIdx0&0x1F
+	data[2] = a.SeqTier0<<7 | a.HighBitdepth<<6 | a.TwelveBit<<5 | a.Monochrome<<4 | a.ChromaSubsamplingX<<3 | a.ChromaSubsamplingY<<2 | a.ChromaSamplePosition&0x3
+	if a.InitialPresentationDelayPresent != 0 {
+		data[3] = 0x10 | a.InitialPresentationDelayMinusOne&0xF
+	}
+
+	return append(data, a.ConfigOBUs...)
+}
+
+func (a *AV1CodecConfigurationRecord) Unmarshal(data []byte) error {
+	if len(data) < 4 {
+		return fmt.Errorf("invalid av1C size %d", len(data))
+	} else if data[0]&0x80 == 0 {
+		return fmt.Errorf("invalid av1C marker")
+	}
+
+	a.Version = data[0] & 0x7F
+	if a.Version != 1 {
+		return fmt.Errorf("unsupported av1C version %d", a.Version)
+	}
+
+	a.SeqProfile = data[1] >> 5
+	a.SeqLevelIdx0 = data[1] & 0x1F
+	a.SeqTier0 = data[2] >> 7
+	a.HighBitdepth = data[2] >> 6 & 0x1
+	a.TwelveBit = data[2] >> 5 & 0x1
+	a.Monochrome = data[2] >> 4 & 0x1
+	a.ChromaSubsamplingX = data[2] >> 3 & 0x1
+	a.ChromaSubsamplingY = data[2] >> 2 & 0x1
+	a.ChromaSamplePosition = data[2] & 0x3
+	a.InitialPresentationDelayPresent = data[3] >> 4 & 0x1
+	if a.InitialPresentationDelayPresent != 0 {
+		a.InitialPresentationDelayMinusOne = data[3] & 0xF
+	}
+
+	a.ConfigOBUs = data[4:]
+	return nil
+}
+
+// UpdateFromSequenceHeader 根据sequence header OBU更新配置, sequence header作为configOBUs
+func (a *AV1CodecConfigurationRecord) UpdateFromSequenceHeader(obu []byte) error {
+	seq, err := ParseSequenceHeaderOBU(obu)
+	if err != nil {
+		return err
+	}
+
+	color := seq.ColorConfig
+	a.Version = 1
+	a.SeqProfile = byte(seq.SeqProfile)
+	a.SeqLevelIdx0 = byte(seq.OperatingPoints[0].SeqLevelIdx)
+	a.SeqTier0 = byte(seq.OperatingPoints[0].SeqTier)
+	a.HighBitdepth, a.TwelveBit = 0, 0
+	if color.BitDepth > 8 {
+		a.HighBitdepth = 1
+	}
+	if color.BitDepth == 12 {
+		a.TwelveBit = 1
+	}
+	a.Monochrome = byte(color.MonoChrome)
+	a.ChromaSubsamplingX = byte(color.SubsamplingX)
+	a.ChromaSubsamplingY = byte(color.SubsamplingY)
+	a.ChromaSamplePosition = byte(color.ChromaSamplePosition)
+	a.InitialPresentationDelayPresent = byte(seq.OperatingPoints[0].InitialDisplayDelayPresent)
+	a.InitialPresentationDelayMinusOne = byte(seq.OperatingPoints[0].InitialDisplayDelayMinusOne)
+
+	// configOBUs中的OBU必须携带obu_size
+	header, _ := ParseOBUHeader(obu)
+	if !header.HasSizeField {
+		obu = appendOBU(nil, OBU{Header: header, Payload: obu[header.Size():]}, true)
+	}
+	a.ConfigOBUs = obu
+	return nil
+}
+
+// SequenceHeaderOBU 返回configOBUs中的sequence header
+func (a *AV1CodecConfigurationRecord) SequenceHeaderOBU() []byte {
+	return FindSequenceHeader(a.ConfigOBUs)
+}
@@ -0,0 +1,300 @@
+package av1
+
+import (
+	"fmt"
+)
+
+type OBUType byte
+
+const (
+	OBUSequenceHeader       = OBUType(1)
+	OBUTemporalDelimiter    = OBUType(2)
+	OBUFrameHeader          = OBUType(3)
+	OBUTileGroup            = OBUType(4)
+	OBUMetadata             = OBUType(5)
+	OBUFrame                = OBUType(6)
+	OBURedundantFrameHeader = OBUType(7)
+	OBUTileList             = OBUType(8)
+	OBUPadding              = OBUType(15)
+)
+
+// OBUHeader obu_header, 参考5.3.2
+type OBUHeader struct {
+	Type          OBUType
+	ExtensionFlag bool
+	HasSizeField  bool
+	TemporalId    byte
+	SpatialId     byte
+}
+
+// Size 返回OBU头的长度
+func (h *OBUHeader) Size() int {
+	if h.ExtensionFlag {
+		return 2
+	}
+	return 1
+}
+
+// Marshal 写入OBU头
+func (h *OBUHeader) Marshal(dst []byte) []byte {
+	b := byte(h.Type) << 3
+	if h.ExtensionFlag {
+		b |= 0x04
+	}
+	if h.HasSizeField {
+		b |= 0x02
+	}
+
+	dst = append(dst, b)
+	if h.ExtensionFlag {
+		dst = append(dst, h.TemporalId<<5|(h.SpatialId&0x3)<<3)
+	}
+	return dst
+}
+
+func ParseOBUHeader(data []byte) (OBUHeader, error) {
+	var h OBUHeader
+	if len(data) < 1 {
+		return h, fmt.Errorf("invalid obu header size %d", len(data))
+	} else if data[0]&0x80 != 0 {
+		return h, fmt.Errorf("obu_forbidden_bit must be 0")
+	}
+
+	h.Type = OBUType(data[0] >> 3 & 0xF)
+	h.ExtensionFlag = data[0]&0x04 != 0
+	h.HasSizeField = data[0]&0x02 != 0
+	if h.ExtensionFlag {
+		if len(data) < 2 {
+			return h, fmt.Errorf("invalid obu extension header size %d", len(data))
+		}
+		h.TemporalId = data[1] >> 5
+		h.SpatialId = data[1] >> 3 & 0x3
+	}
+	return h, nil
+}
+
+// OBU 一个完整的OBU, Data包含OBU头
+type OBU struct {
+	Header  OBUHeader
+	Payload []byte
+	Data    []byte
+}
+
+// ReadLeb128 读取leb128, 返回值和占用的字节数, 参考4.10.5
+func ReadLeb128(data []byte) (uint64, int, error) {
+	var value uint64
+	for i := 0; i < 8; i++ {
+		if i >= len(data) {
+			return 0, 0, fmt.Errorf("invalid leb128")
+		}
+
+		value |= uint64(data[i]&0x7F) << (i * 7)
+		if data[i]&0x80 == 0 {
+			return value, i + 1, nil
+		}
+	}
+
+	return 0, 0, fmt.Errorf("leb128 exceeds 8 bytes")
+}
+
+// AppendLeb128 写入leb128
+func AppendLeb128(dst []byte, value uint64) []byte {
+	for {
+		b := byte(value & 0x7F)
+		value >>= 7
+		if value == 0 {
+			return append(dst, b)
+		}
+		dst = append(dst, b|0x80)
+	}
+}
+
+// Leb128Size 返回leb128编码后的长度
+func Leb128Size(value uint64) int {
+	n := 1
+	for value >>= 7; value > 0; value >>= 7 {
+		n++
+	}
+	return n
+}
+
+// readOBU 读取一个OBU, size为-1时使用obu_size或者剩余全部数据
+func readOBU(data []byte, size int) (OBU, int, error) {
+	if size >= 0 && size > len(data) {
+		return OBU{}, 0, fmt.Errorf("invalid obu length %d", size)
+	} else if size >= 0 {
+		data = data[:size]
+	}
+
+	header, err := ParseOBUHeader(data)
+	if err != nil {
+		return OBU{}, 0, err
+	}
+
+	offset := header.Size()
+	end := len(data)
+	if header.HasSizeField {
+		obuSize, n, err := ReadLeb128(data[offset:])
+		if err != nil {
+			return OBU{}, 0, err
+		}
+
+		offset += n
+		if obuSize > uint64(len(data)-offset) {
+			return OBU{}, 0, fmt.Errorf("invalid obu_size %d", obuSize)
+		}
+		end = offset + int(obuSize)
+	} else if offset > end {
+		return OBU{}, 0, fmt.Errorf("invalid obu size %d", end)
+	}
+
+	return OBU{Header: header, Payload: data[offset:end], Data: data[:end]}, end, nil
+}
+
+// SplitOBUs 按照Low Overhead Bitstream Format拆分OBU, 只有最后一个OBU可以不携带obu_size. 回调返回false时停止
+func SplitOBUs(data []byte, cb func(obu OBU) bool) error {
+	for len(data) > 0 {
+		obu, n, err := readOBU(data, -1)
+		if err != nil {
+			return err
+		} else if !cb(obu) {
+			return nil
+		}
+
+		data = data[n:]
+	}
+
+	return nil
+}
+
+// SplitAnnexB 拆分Annex B格式的temporal_unit, 参考Annex B.2. 回调返回false时停止
+func SplitAnnexB(data []byte, cb func(obu OBU) bool) error {
+	for len(data) > 0 {
+		temporalUnitSize, n, err := ReadLeb128(data)
+		if err != nil {
+			return err
+		} else if temporalUnitSize > uint64(len(data)-n) {
+			return fmt.Errorf("invalid temporal_unit_size %d", temporalUnitSize)
+		}
+
+		temporalUnit := data[n : n+int(temporalUnitSize)]
+		data = data[n+int(temporalUnitSize):]
+		for len(temporalUnit) > 0 {
+			frameUnitSize, n, err := ReadLeb128(temporalUnit)
+			if err != nil {
+				return err
+			} else if frameUnitSize > uint64(len(temporalUnit)-n) {
+				return fmt.Errorf("invalid frame_unit_size %d", frameUnitSize)
+			}
+
+			frameUnit := temporalUnit[n : n+int(frameUnitSize)]
+			temporalUnit = temporalUnit[n+int(frameUnitSize):]
+			for len(frameUnit) > 0 {
+				obuLength, n, err := ReadLeb128(frameUnit)
+				if err != nil {
+					return err
+				} else if obuLength > uint64(len(frameUnit)-n) {
+					return fmt.Errorf("invalid obu_length %d", obuLength)
+				}
+
+				obu, _, err := readOBU(frameUnit[n:], int(obuLength))
+				if err != nil {
+					return err
+				} else if !cb(obu) {
+					return nil
+				}
+				frameUnit = frameUnit[n+int(obuLength):]
+			}
+		}
+	}
+
+	return nil
+}
+
+// appendOBU 写入OBU, sizeField为true时携带obu_size
+func appendOBU(dst []byte, obu OBU, sizeField bool) []byte {
+	header := obu.Header
+	header.HasSizeField = sizeField
+	dst = header.Marshal(dst)
+	if sizeField {
+		dst = AppendLeb128(dst, uint64(len(obu.Payload)))
+	}
+	return append(dst, obu.Payload...)
+}
+
+// AnnexB2LowOverhead Annex B格式转为Low Overhead格式, 所有OBU都携带obu_size
+func AnnexB2LowOverhead(data []byte) ([]byte, error) {
+	dst := make([]byte, 0, len(data)+16)
+	err := SplitAnnexB(data, func(obu OBU) bool {
+		dst = appendOBU(dst, obu, true)
+		return true
+	})
+	return dst, err
+}
+
+// LowOverhead2AnnexB Low Overhead格式的temporal unit转为Annex B格式, 所有OBU放在一个frame_unit中, 去掉obu_size
+func LowOverhead2AnnexB(data []byte) ([]byte, error) {
+	var frameUnit []byte
+	err := SplitOBUs(data, func(obu OBU) bool {
+		frameUnit = AppendLeb128(frameUnit, uint64(obu.Header.Size()+len(obu.Payload)))
+		frameUnit = appendOBU(frameUnit, obu, false)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	frameUnitSize := uint64(len(frameUnit))
+	dst := AppendLeb128(nil, frameUnitSize+uint64(Leb128Size(frameUnitSize)))
+	dst = AppendLeb128(dst, frameUnitSize)
+	return append(dst, frameUnit...), nil
+}
+
+// RemoveTemporalDelimiter 去掉开头的temporal delimiter, MP4的sample不应包含
+func RemoveTemporalDelimiter(data []byte) []byte {
+	for len(data) > 0 {
+		obu, n, err := readOBU(data, -1)
+		if err != nil || OBUTemporalDelimiter != obu.Header.Type {
+			break
+		}
+		data = data[n:]
+	}
+	return data
+}
+
+// FindSequenceHeader 返回Low Overhead格式中的sequence header OBU
+func FindSequenceHeader(data []byte) []byte {
+	var result []byte
+	_ = SplitOBUs(data, func(obu OBU) bool {
+		if OBUSequenceHeader == obu.Header.Type {
+			result = obu.Data
+		}
+		return result == nil
+	})
+	return result
+}
+
+// IsKeyFrame 是否为显示的关键帧, temporal unit需要包含sequence header
+func IsKeyFrame(data []byte) bool {
+	var seq *SequenceHeader
+	var key bool
+	_ = SplitOBUs(data, func(obu OBU) bool {
+		switch obu.Header.Type {
+		case OBUSequenceHeader:
+			header, err := ParseSequenceHeader(obu.Payload)
+			if err != nil {
+				return false
+			}
+			seq = &header
+		case OBUFrameHeader, OBUFrame:
+			// 只解析第一个帧头
+			if seq != nil {
+				header, err := ParseFrameHeader(obu.Payload, seq)
+				key = err == nil && FrameTypeKey == header.FrameType && header.ShowFrame != 0
+			}
+			return false
+		}
+		return true
+	})
+	return key
+}
@@ -0,0 +1,497 @@
+package av1
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/lkmio/avformat/bufio"
+)
+
+const (
+	FrameTypeKey       = 0
+	FrameTypeInter     = 1
+	FrameTypeIntraOnly = 2
+	FrameTypeSwitch    = 3
+
+	// SelectScreenContentTools seq_force_screen_content_tools和seq_force_integer_mv的缺省值
+	SelectScreenContentTools = 2
+	SelectIntegerMv          = 2
+
+	ColorPrimariesBT709         = 1
+	ColorPrimariesUnspecified   = 2 // CP_UNSPECIFIED, TC_UNSPECIFIED和MC_UNSPECIFIED都为2
+	TransferCharacteristicsSRGB = 13
+	MatrixCoefficientsIdentity  = 0
+)
+
+// OperatingPoint 序列头中每个operating point的参数
+type OperatingPoint struct {
+	Idc                         uint
+	SeqLevelIdx                 uint
+	SeqTier                     uint
+	DecoderModelPresent         uint
+	DecoderBufferDelay          uint
+	EncoderBufferDelay          uint
+	LowDelayModeFlag            uint
+	InitialDisplayDelayPresent  uint
+	InitialDisplayDelayMinusOne uint
+}
+
+// ColorConfig color_config, 参考5.5.2
+type ColorConfig struct {
+	BitDepth                uint
+	MonoChrome              uint
+	ColorDescriptionPresent uint
+	ColorPrimaries          uint
+	TransferCharacteristics uint
+	MatrixCoefficients      uint
+	ColorRange              uint
+	SubsamplingX            uint
+	SubsamplingY            uint
+	ChromaSamplePosition    uint
+	SeparateUvDeltaQ        uint
+}
+
+// SequenceHeader sequence_header_obu, 参考5.5.1
+type SequenceHeader struct {
+	SeqProfile                uint
+	StillPicture              uint
+	ReducedStillPictureHeader uint
+
+	TimingInfoPresentFlag    uint
+	NumUnitsInDisplayTick    uint
+	TimeScale                uint
+	EqualPictureInterval     uint
+	NumTicksPerPictureMinus1 uint
+
+	DecoderModelInfoPresentFlag       uint
+	BufferDelayLengthMinus1           uint
+	NumUnitsInDecodingTick            uint
+	BufferRemovalTimeLengthMinus1     uint
+	FramePresentationTimeLengthMinus1 uint
+
+	InitialDisplayDelayPresentFlag uint
+	OperatingPoints                []OperatingPoint
+
+	FrameWidthBitsMinus1  uint
+	FrameHeightBitsMinus1 uint
+	MaxFrameWidthMinus1   uint
+	MaxFrameHeightMinus1  uint
+
+	FrameIdNumbersPresentFlag     uint
+	DeltaFrameIdLengthMinus2      uint
+	AdditionalFrameIdLengthMinus1 uint
+	Use128x128Superblock          uint
+	EnableFilterIntra             uint
+	EnableIntraEdgeFilter         uint
+	EnableInterintraCompound      uint
+	EnableMaskedCompound          uint
+	EnableWarpedMotion            uint
+	EnableDualFilter              uint
+	EnableOrderHint               uint
+	EnableJntComp                 uint
+	EnableRefFrameMvs             uint
+	SeqChooseScreenContentTools   uint
+	SeqForceScreenContentTools    uint
+	SeqChooseIntegerMv            uint
+	SeqForceIntegerMv             uint
+	OrderHintBits                 uint
+	EnableSuperres                uint
+	EnableCdef                    uint
+	EnableRestoration             uint
+	ColorConfig                   ColorConfig
+	FilmGrainParamsPresent        uint
+}
+
+func (s *SequenceHeader) Width() int {
+	return int(s.MaxFrameWidthMinus1) + 1
+}
+
+func (s *SequenceHeader) Height() int {
+	return int(s.MaxFrameHeightMinus1) + 1
+}
+
+// FrameRate 根据timing_info计算帧率, 没有则为0
+func (s *SequenceHeader) FrameRate() float64 {
+	if s.TimingInfoPresentFlag == 0 || s.NumUnitsInDisplayTick == 0 {
+		return 0
+	}
+
+	rate := float64(s.TimeScale) / float64(s.NumUnitsInDisplayTick)
+	if s.EqualPictureInterval != 0 {
+		rate /= float64(s.NumTicksPerPictureMinus1 + 1)
+	}
+	return rate
+}
+
+// ParseSequenceHeaderOBU 解析包含OBU头的sequence header
+func ParseSequenceHeaderOBU(data []byte) (SequenceHeader, error) {
+	obu, _, err := readOBU(data, -1)
+	if err != nil {
+		return SequenceHeader{}, err
+	} else if OBUSequenceHeader != obu.Header.Type {
+		return SequenceHeader{}, fmt.Errorf("invalid sequence header obu type %d", obu.Header.Type)
+	}
+
+	return ParseSequenceHeader(obu.Payload)
+}
+
+// ParseSequenceHeader 解析sequence header OBU的负载
+func ParseSequenceHeader(payload []byte) (s SequenceHeader, err error) {
+	r := &bufio.GolombBitReader{R: bytes.NewReader(payload)}
+	if s.SeqProfile, err = r.ReadBits(3); err != nil {
+		return
+	} else if s.SeqProfile > 2 {
+		err = fmt.Errorf("invalid seq_profile %d", s.SeqProfile)
+		return
+	}
+	if s.StillPicture, err = r.ReadBit(); err != nil {
+		return
+	}
+	if s.ReducedStillPictureHeader, err = r.ReadBit(); err != nil {
+		return
+	}
+
+	if s.ReducedStillPictureHeader != 0 {
+		s.OperatingPoints = make([]OperatingPoint, 1)
+		if s.OperatingPoints[0].SeqLevelIdx, err = r.ReadBits(5); err != nil {
+			return
+		}
+	} else if err = s.parseOperatingPoints(r); err != nil {
+		return
+	}
+
+	if s.FrameWidthBitsMinus1, err = r.ReadBits(4); err != nil {
+		return
+	}
+	if s.FrameHeightBitsMinus1, err = r.ReadBits(4); err != nil {
+		return
+	}
+	if s.MaxFrameWidthMinus1, err = r.ReadBits(int(s.FrameWidthBitsMinus1 + 1)); err != nil {
+		return
+	}
+	if s.MaxFrameHeightMinus1, err = r.ReadBits(int(s.FrameHeightBitsMinus1 + 1)); err != nil {
+		return
+	}
+
+	if s.ReducedStillPictureHeader == 0 {
+		if s.FrameIdNumbersPresentFlag, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+	if s.FrameIdNumbersPresentFlag != 0 {
+		if s.DeltaFrameIdLengthMinus2, err = r.ReadBits(4); err != nil {
+			return
+		}
+		if s.AdditionalFrameIdLengthMinus1, err = r.ReadBits(3); err != nil {
+			return
+		}
+	}
+
+	// use_128x128_superblock, enable_filter_intra, enable_intra_edge_filter
+	for _, flag := range []*uint{&s.Use128x128Superblock, &s.EnableFilterIntra, &s.EnableIntraEdgeFilter} {
+		if *flag, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	s.SeqForceScreenContentTools = SelectScreenContentTools
+	s.SeqForceIntegerMv = SelectIntegerMv
+	if s.ReducedStillPictureHeader == 0 {
+		if err = s.parseInterTools(r); err != nil {
+			return
+		}
+	}
+
+	for _, flag := range []*uint{&s.EnableSuperres, &s.EnableCdef, &s.EnableRestoration} {
+		if *flag, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	if err = s.parseColorConfig(r); err != nil {
+		return
+	}
+
+	s.FilmGrainParamsPresent, err = r.ReadBit()
+	return
+}
+
+func (s *SequenceHeader) parseOperatingPoints(r *bufio.GolombBitReader) (err error) {
+	if s.TimingInfoPresentFlag, err = r.ReadBit(); err != nil {
+		return
+	}
+
+	if s.TimingInfoPresentFlag != 0 {
+		if s.NumUnitsInDisplayTick, err = r.ReadBits(32); err != nil {
+			return
+		}
+		if s.TimeScale, err = r.ReadBits(32); err != nil {
+			return
+		}
+		if s.EqualPictureInterval, err = r.ReadBit(); err != nil {
+			return
+		}
+		if s.EqualPictureInterval != 0 {
+			// uvlc()与ue(v)的编码方式相同
+			if s.NumTicksPerPictureMinus1, err = r.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+		}
+
+		if s.DecoderModelInfoPresentFlag, err = r.ReadBit(); err != nil {
+			return
+		}
+		if s.DecoderModelInfoPresentFlag != 0 {
+			if s.BufferDelayLengthMinus1, err = r.ReadBits(5); err != nil {
+				return
+			}
+			if s.NumUnitsInDecodingTick, err = r.ReadBits(32); err != nil {
+				return
+			}
+			if s.BufferRemovalTimeLengthMinus1, err = r.ReadBits(5); err != nil {
+				return
+			}
+			if s.FramePresentationTimeLengthMinus1, err = r.ReadBits(5); err != nil {
+				return
+			}
+		}
+	}
+
+	if s.InitialDisplayDelayPresentFlag, err = r.ReadBit(); err != nil {
+		return
+	}
+
+	var operating_points_cnt_minus_1 uint
+	if operating_points_cnt_minus_1, err = r.ReadBits(5); err != nil {
+		return
+	}
+
+	s.OperatingPoints = make([]OperatingPoint, operating_points_cnt_minus_1+1)
+	for i := range s.OperatingPoints {
+		point := &s.OperatingPoints[i]
+		if point.Idc, err = r.ReadBits(12); err != nil {
+			return
+		}
+		if point.SeqLevelIdx, err = r.ReadBits(5); err != nil {
+			return
+		}
+		if point.SeqLevelIdx > 7 {
+			if point.SeqTier, err = r.ReadBit(); err != nil {
+				return
+			}
+		}
+
+		if s.DecoderModelInfoPresentFlag != 0 {
+			if point.DecoderModelPresent, err = r.ReadBit(); err != nil {
+				return
+			}
+			if point.DecoderModelPresent != 0 {
+				n := int(s.BufferDelayLengthMinus1 + 1)
+				if point.DecoderBufferDelay, err = r.ReadBits(n); err != nil {
+					return
+				}
+				if point.EncoderBufferDelay, err = r.ReadBits(n); err != nil {
+					return
+				}
+				if point.LowDelayModeFlag, err = r.ReadBit(); err != nil {
+					return
+				}
+			}
+		}
+
+		if s.InitialDisplayDelayPresentFlag != 0 {
+			if point.InitialDisplayDelayPresent, err = r.ReadBit(); err != nil {
+				return
+			}
+			if point.InitialDisplayDelayPresent != 0 {
+				if point.InitialDisplayDelayMinusOne, err = r.ReadBits(4); err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	return
+}
+
+func (s *SequenceHeader) parseInterTools(r *bufio.GolombBitReader) (err error) {
+	for _, flag := range []*uint{&s.EnableInterintraCompound, &s.EnableMaskedCompound, &s.EnableWarpedMotion, &s.EnableDualFilter, &s.EnableOrderHint} {
+		if *flag, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	if s.EnableOrderHint != 0 {
+		if s.EnableJntComp, err = r.ReadBit(); err != nil {
+			return
+		}
+		if s.EnableRefFrameMvs, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	if s.SeqChooseScreenContentTools, err = r.ReadBit(); err != nil {
+		return
+	}
+	if s.SeqChooseScreenContentTools == 0 {
+		if s.SeqForceScreenContentTools, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	if s.SeqForceScreenContentTools > 0 {
+		if s.SeqChooseIntegerMv, err = r.ReadBit(); err != nil {
+			return
+		}
+		if s.SeqChooseIntegerMv == 0 {
+			if s.SeqForceIntegerMv, err = r.ReadBit(); err != nil {
+				return
+			}
+		}
+	}
+
+	if s.EnableOrderHint != 0 {
+		var order_hint_bits_minus_1 uint
+		if order_hint_bits_minus_1, err = r.ReadBits(3); err != nil {
+			return
+		}
+		s.OrderHintBits = order_hint_bits_minus_1 + 1
+	}
+	return
+}
+
+func (s *SequenceHeader) parseColorConfig(r *bufio.GolombBitReader) (err error) {
+	c := &s.ColorConfig
+	var high_bitdepth uint
+	if high_bitdepth, err = r.ReadBit(); err != nil {
+		return
+	}
+
+	c.BitDepth = 8
+	if 2 == s.SeqProfile && high_bitdepth != 0 {
+		var twelve_bit uint
+		if twelve_bit, err = r.ReadBit(); err != nil {
+			return
+		}
+		c.BitDepth = 10 + 2*twelve_bit
+	} else if high_bitdepth != 0 {
+		c.BitDepth = 10
+	}
+
+	if 1 != s.SeqProfile {
+		if c.MonoChrome, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	c.ColorPrimaries = ColorPrimariesUnspecified
+	c.TransferCharacteristics = ColorPrimariesUnspecified
+	c.MatrixCoefficients = ColorPrimariesUnspecified
+	if c.ColorDescriptionPresent, err = r.ReadBit(); err != nil {
+		return
+	}
+	if c.ColorDescriptionPresent != 0 {
+		if c.ColorPrimaries, err = r.ReadBits(8); err != nil {
+			return
+		}
+		if c.TransferCharacteristics, err = r.ReadBits(8); err != nil {
+			return
+		}
+		if c.MatrixCoefficients, err = r.ReadBits(8); err != nil {
+			return
+		}
+	}
+
+	if c.MonoChrome != 0 {
+		c.SubsamplingX, c.SubsamplingY = 1, 1
+		c.ColorRange, err = r.ReadBit()
+		return
+	} else if ColorPrimariesBT709 == c.ColorPrimaries && TransferCharacteristicsSRGB == c.TransferCharacteristics && MatrixCoefficientsIdentity == c.MatrixCoefficients {
+		c.ColorRange = 1
+	} else {
+		if c.ColorRange, err = r.ReadBit(); err != nil {
+			return
+		}
+
+		switch s.SeqProfile {
+		case 0:
+			c.SubsamplingX, c.SubsamplingY = 1, 1
+		case 1:
+			break
+		default:
+			c.SubsamplingX = 1
+			if 12 == c.BitDepth {
+				if c.SubsamplingX, err = r.ReadBit(); err != nil {
+					return
+				}
+				if c.SubsamplingX != 0 {
+					if c.SubsamplingY, err = r.ReadBit(); err != nil {
+						return
+					}
+				}
+			}
+		}
+
+		if c.SubsamplingX != 0 && c.SubsamplingY != 0 {
+			if c.ChromaSamplePosition, err = r.ReadBits(2); err != nil {
+				return
+			}
+		}
+	}
+
+	c.SeparateUvDeltaQ, err = r.ReadBit()
+	return
+}
+
+// FrameHeader uncompressed_header开头的帧类型信息, 参考5.9.2
+type FrameHeader struct {
+	ShowExistingFrame uint
+	FrameToShowMapIdx uint
+	FrameType         uint
+	ShowFrame         uint
+	ShowableFrame     uint
+}
+
+// ParseFrameHeader 解析frame header OBU或frame OBU的帧类型
+func ParseFrameHeader(payload []byte, seq *SequenceHeader) (h FrameHeader, err error) {
+	if seq.ReducedStillPictureHeader != 0 {
+		h.FrameType = FrameTypeKey
+		h.ShowFrame = 1
+		return
+	}
+
+	r := &bufio.GolombBitReader{R: bytes.NewReader(payload)}
+	temporalPointInfo := seq.DecoderModelInfoPresentFlag != 0 && seq.EqualPictureInterval == 0
+	if h.ShowExistingFrame, err = r.ReadBit(); err != nil {
+		return
+	}
+
+	// 显示已解码的帧, 帧类型取决于参考帧
+	if h.ShowExistingFrame != 0 {
+		h.ShowFrame = 1
+		h.FrameToShowMapIdx, err = r.ReadBits(3)
+		return
+	}
+
+	if h.FrameType, err = r.ReadBits(2); err != nil {
+		return
+	}
+	if h.ShowFrame, err = r.ReadBit(); err != nil {
+		return
+	}
+
+	if h.ShowFrame != 0 && temporalPointInfo {
+		// frame_presentation_time
+		if _, err = r.ReadBits(int(seq.FramePresentationTimeLengthMinus1 + 1)); err != nil {
+			return
+		}
+	}
+
+	if h.ShowFrame != 0 {
+		if FrameTypeKey != h.FrameType {
+			h.ShowableFrame = 1
+		}
+	} else if h.ShowableFrame, err = r.ReadBit(); err != nil {
+		return
+	}
+	return
+}
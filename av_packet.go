@@ -24,6 +24,7 @@ const (
 	PacketTypeAVCC   = PacketType(2)
 	PacketTypeNONE   = PacketType(3)
 	DTSUndefined     = DTS(-1)
+	PTSUndefined     = PTS(-1)
 )
 
 var (
@@ -1,6 +1,12 @@
 package avformat
 
-import "github.com/lkmio/avformat/utils"
+import (
+	"github.com/lkmio/avformat/av1"
+	"github.com/lkmio/avformat/avc"
+	"github.com/lkmio/avformat/hevc"
+	"github.com/lkmio/avformat/utils"
+	"github.com/lkmio/avformat/vvc"
+)
 
 type AudioConfig struct {
 	SampleRate    int  // 音频采样率
@@ -9,18 +15,150 @@ type AudioConfig struct {
 	HasADTSHeader bool // 是否存在ADTSHeader
 }
 
+// ColorInfo 视频的色彩信息, 取值参考ISO/IEC 23091-2
+type ColorInfo struct {
+	Primaries uint8 // 色彩原色, colour_primaries
+	Transfer  uint8 // 转换特性, transfer_characteristics
+	Matrix    uint8 // 矩阵系数, matrix_coefficients
+	FullRange bool  // 是否为全范围(0-255)
+}
+
+// Marshal 序列化为colr box的nclx内容
+func (c *ColorInfo) Marshal() []byte {
+	data := []byte{'n', 'c', 'l', 'x', 0, c.Primaries, 0, c.Transfer, 0, c.Matrix, 0}
+	if c.FullRange {
+		data[10] = 0x80
+	}
+	return data
+}
+
+// IsHDR 转换特性是否为PQ或HLG
+func (c *ColorInfo) IsHDR() bool {
+	return hevc.TransferPQ == c.Transfer || hevc.TransferHLG == c.Transfer
+}
+
+type VideoConfig struct {
+	FrameRate           float64 // 帧率, 码流未携带时为0
+	SarWidth            int     // 像素宽高比, 未知时为0
+	SarHeight           int
+	MaxNumReorderFrames int // 最大重排序帧数, 为0时没有B帧
+	BitDepth            int // 亮度位深
+}
+
 type AVStream struct {
 	MediaType       utils.AVMediaType
 	Index           int
 	CodecID         utils.AVCodecID
 	CodecParameters CodecData
-	Colors          []byte
+	Colors          []byte     // colr box的nclx内容, 与ColorInfo同时更新
+	ColorInfo       *ColorInfo // 码流未携带色彩描述时为nil
 	Data            []byte
 	Timebase        int
 
 	AudioConfig
+	VideoConfig
+}
+
+// UpdateVideoConfig 从编码器参数中解析帧率、宽高比、位深和色彩信息
+func (s *AVStream) UpdateVideoConfig() error {
+	// VP8/VP9没有参数集, 使用vpcC
+	if codecData, ok := s.CodecParameters.(*VPCodecData); ok {
+		record := codecData.Record
+		s.BitDepth = int(record.BitDepth)
+		s.ColorInfo = &ColorInfo{
+			Primaries: record.ColourPrimaries,
+			Transfer:  record.TransferCharacteristics,
+			Matrix:    record.MatrixCoefficients,
+			FullRange: record.VideoFullRangeFlag != 0,
+		}
+		s.Colors = s.ColorInfo.Marshal()
+		return nil
+	}
+
+	if s.CodecParameters == nil || len(s.CodecParameters.SPS()) == 0 {
+		return nil
+	}
+
+	if utils.AVCodecIdH264 == s.CodecID {
+		sps, err := avc.ParseSPS(s.CodecParameters.SPS()[0])
+		if err != nil {
+			return err
+		}
+
+		s.FrameRate = sps.FrameRate
+		s.SarWidth, s.SarHeight = int(sps.VUI.SarWidth), int(sps.VUI.SarHeight)
+		s.MaxNumReorderFrames = int(sps.VUI.MaxNumReorderFrames)
+		s.BitDepth = int(sps.BitDepthLumaMinus8) + 8
+		if sps.VUI.ColourDescriptionPresentFlag != 0 || sps.VUI.VideoFullRangeFlag != 0 {
+			s.ColorInfo = &ColorInfo{
+				Primaries: uint8(sps.VUI.ColourPrimaries),
+				Transfer:  uint8(sps.VUI.TransferCharacteristics),
+				Matrix:    uint8(sps.VUI.MatrixCoefficients),
+				FullRange: sps.VUI.VideoFullRangeFlag != 0,
+			}
+		}
+	} else if utils.AVCodecIdH265 == s.CodecID {
+		sps, err := hevc.ParseSPS(s.CodecParameters.SPS()[0])
+		if err != nil {
+			return err
+		}
+
+		s.FrameRate = sps.FrameRate
+		s.SarWidth, s.SarHeight = int(sps.VUI.SarWidth), int(sps.VUI.SarHeight)
+		s.MaxNumReorderFrames = int(sps.MaxNumReorderPics[sps.MaxSubLayersMinus1])
+		s.BitDepth = sps.BitDepthLuma()
+		if sps.VUI.ColourDescriptionPresentFlag != 0 || sps.VUI.VideoFullRangeFlag != 0 {
+			s.ColorInfo = &ColorInfo{
+				Primaries: uint8(sps.VUI.ColourPrimaries),
+				Transfer:  uint8(sps.VUI.TransferCharacteristics),
+				Matrix:    uint8(sps.VUI.MatrixCoeffs),
+				FullRange: sps.VUI.VideoFullRangeFlag != 0,
+			}
+		}
+	} else if utils.AVCodecIdH266 == s.CodecID {
+		sps, err := vvc.ParseSPS(s.CodecParameters.SPS()[0])
+		if err != nil {
+			return err
+		}
+
+		// 没有解析VUI, 帧率和色彩信息未知
+		s.BitDepth = sps.BitDepth()
+		if len(sps.MaxNumReorderPics) > 0 {
+			s.MaxNumReorderFrames = int(sps.MaxNumReorderPics[sps.MaxSublayersMinus1])
+		}
+	} else if utils.AVCodecIdAV1 == s.CodecID {
+		seq, err := av1.ParseSequenceHeaderOBU(s.CodecParameters.SPS()[0])
+		if err != nil {
+			return err
+		}
+
+		// AV1没有B帧, 通过隐藏帧实现重排序
+		color := seq.ColorConfig
+		s.FrameRate = seq.FrameRate()
+		s.BitDepth = int(color.BitDepth)
+		if color.ColorDescriptionPresent != 0 || color.ColorRange != 0 {
+			s.ColorInfo = &ColorInfo{
+				Primaries: uint8(color.ColorPrimaries),
+				Transfer:  uint8(color.TransferCharacteristics),
+				Matrix:    uint8(color.MatrixCoefficients),
+				FullRange: color.ColorRange != 0,
+			}
+		}
+	}
+
+	if s.ColorInfo != nil {
+		s.Colors = s.ColorInfo.Marshal()
+	}
+	return nil
 }
 
 func NewAVStream(type_ utils.AVMediaType, index int, codecId utils.AVCodecID, extra []byte, config CodecData) *AVStream {
-	return &AVStream{MediaType: type_, Index: index, CodecID: codecId, Data: extra, CodecParameters: config}
+	stream := &AVStream{MediaType: type_, Index: index, CodecID: codecId, Data: extra, CodecParameters: config}
+	if utils.AVMediaTypeVideo == type_ {
+		if err := stream.UpdateVideoConfig(); err != nil {
+			println(err.Error())
+		}
+	}
+
+	return stream
 }
@@ -0,0 +1,292 @@
+package avc
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/lkmio/avformat/bufio"
+	"math/bits"
+)
+
+// PPS pic_parameter_set_rbsp, 参考7.3.2.2
+type PPS struct {
+	Id                                    uint
+	SPSId                                 uint
+	EntropyCodingModeFlag                 uint // 0-CAVLC 1-CABAC
+	BottomFieldPicOrderInFramePresentFlag uint
+	NumSliceGroupsMinus1                  uint
+	SliceGroupMapType                     uint
+	NumRefIdxL0DefaultActiveMinus1        uint
+	NumRefIdxL1DefaultActiveMinus1        uint
+	WeightedPredFlag                      uint
+	WeightedBipredIdc                     uint
+	PicInitQpMinus26                      int
+	PicInitQsMinus26                      int
+	ChromaQpIndexOffset                   int
+	DeblockingFilterControlPresentFlag    uint
+	ConstrainedIntraPredFlag              uint
+	RedundantPicCntPresentFlag            uint
+	Transform8x8ModeFlag                  uint
+	PicScalingMatrixPresentFlag           uint
+	SecondChromaQpIndexOffset             int
+}
+
+// MoreRBSPData 当前位置之后是否还有rbsp_trailing_bits之外的数据
+func MoreRBSPData(rbsp []byte, reader *bytes.Reader, br *bufio.GolombBitReader) bool {
+	last := len(rbsp) - 1
+	for last >= 0 && rbsp[last] == 0 {
+		last--
+	}
+	if last < 0 {
+		return false
+	}
+
+	// rbsp_stop_one_bit的位置
+	stop := last*8 + 7 - bits.TrailingZeros8(rbsp[last])
+	position := (len(rbsp)-reader.Len())*8 - br.BufferedBits()
+	return position < stop
+}
+
+func skipScalingList(r *bufio.GolombBitReader, size int) error {
+	lastScale, nextScale := 8, 8
+	for j := 0; j < size; j++ {
+		if nextScale != 0 {
+			delta_scale, err := r.ReadSE()
+			if err != nil {
+				return err
+			}
+			nextScale = (lastScale + int(delta_scale) + 256) % 256
+		}
+		if nextScale != 0 {
+			lastScale = nextScale
+		}
+	}
+	return nil
+}
+
+// ParsePPS 解析PPS, spsList用于查找引用的SPS的色度格式, 找不到时按照4:2:0处理
+func ParsePPS(data []byte, spsList map[uint]*SPS) (p PPS, err error) {
+	rbsp := nal2rbsp(RemoveStartCode(data))
+	if len(rbsp) < 2 {
+		err = fmt.Errorf("invalid pps size %d", len(rbsp))
+		return
+	}
+
+	reader := bytes.NewReader(rbsp[1:])
+	r := &bufio.GolombBitReader{R: reader}
+	if p.Id, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	} else if p.Id >= H264MaxPpsCount {
+		err = fmt.Errorf("invalid pic_parameter_set_id %d", p.Id)
+		return
+	}
+	if p.SPSId, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	} else if p.SPSId >= H264MaxSpsCount {
+		err = fmt.Errorf("invalid seq_parameter_set_id %d", p.SPSId)
+		return
+	}
+	if p.EntropyCodingModeFlag, err = r.ReadBit(); err != nil {
+		return
+	}
+	if p.BottomFieldPicOrderInFramePresentFlag, err = r.ReadBit(); err != nil {
+		return
+	}
+	if p.NumSliceGroupsMinus1, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	} else if p.NumSliceGroupsMinus1 >= H264MaxSliceGroups {
+		err = fmt.Errorf("invalid num_slice_groups_minus1 %d", p.NumSliceGroupsMinus1)
+		return
+	}
+
+	if p.NumSliceGroupsMinus1 > 0 {
+		if err = p.skipSliceGroups(r); err != nil {
+			return
+		}
+	}
+
+	if p.NumRefIdxL0DefaultActiveMinus1, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if p.NumRefIdxL1DefaultActiveMinus1, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if p.NumRefIdxL0DefaultActiveMinus1 >= H264MaxRefs || p.NumRefIdxL1DefaultActiveMinus1 >= H264MaxRefs {
+		err = fmt.Errorf("invalid num_ref_idx_default_active_minus1 %d %d", p.NumRefIdxL0DefaultActiveMinus1, p.NumRefIdxL1DefaultActiveMinus1)
+		return
+	}
+	if p.WeightedPredFlag, err = r.ReadBit(); err != nil {
+		return
+	}
+	if p.WeightedBipredIdc, err = r.ReadBits(2); err != nil {
+		return
+	}
+
+	var value uint
+	if value, err = r.ReadSE(); err != nil {
+		return
+	}
+	p.PicInitQpMinus26 = int(value)
+	if value, err = r.ReadSE(); err != nil {
+		return
+	}
+	p.PicInitQsMinus26 = int(value)
+	if value, err = r.ReadSE(); err != nil {
+		return
+	}
+	p.ChromaQpIndexOffset = int(value)
+	p.SecondChromaQpIndexOffset = p.ChromaQpIndexOffset
+
+	if p.DeblockingFilterControlPresentFlag, err = r.ReadBit(); err != nil {
+		return
+	}
+	if p.ConstrainedIntraPredFlag, err = r.ReadBit(); err != nil {
+		return
+	}
+	if p.RedundantPicCntPresentFlag, err = r.ReadBit(); err != nil {
+		return
+	}
+
+	// High profile扩展字段
+	if !MoreRBSPData(rbsp[1:], reader, r) {
+		return
+	}
+
+	if p.Transform8x8ModeFlag, err = r.ReadBit(); err != nil {
+		return
+	}
+	if p.PicScalingMatrixPresentFlag, err = r.ReadBit(); err != nil {
+		return
+	}
+	if p.PicScalingMatrixPresentFlag != 0 {
+		count := 6
+		if p.Transform8x8ModeFlag != 0 {
+			if sps, ok := spsList[p.SPSId]; ok && sps.ChromaFormatIdc == 3 {
+				count += 6
+			} else {
+				count += 2
+			}
+		}
+
+		for i := 0; i < count; i++ {
+			var pic_scaling_list_present_flag uint
+			if pic_scaling_list_present_flag, err = r.ReadBit(); err != nil {
+				return
+			}
+			if pic_scaling_list_present_flag == 0 {
+				continue
+			}
+
+			size := 16
+			if i >= 6 {
+				size = 64
+			}
+			if err = skipScalingList(r, size); err != nil {
+				return
+			}
+		}
+	}
+
+	if value, err = r.ReadSE(); err != nil {
+		return
+	}
+	p.SecondChromaQpIndexOffset = int(value)
+	return
+}
+
+func (p *PPS) skipSliceGroups(r *bufio.GolombBitReader) (err error) {
+	if p.SliceGroupMapType, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+
+	switch p.SliceGroupMapType {
+	case 0:
+		for i := uint(0); i <= p.NumSliceGroupsMinus1; i++ {
+			// run_length_minus1
+			if _, err = r.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+		}
+	case 2:
+		for i := uint(0); i < p.NumSliceGroupsMinus1; i++ {
+			// top_left, bottom_right
+			if _, err = r.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+			if _, err = r.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+		}
+	case 3, 4, 5:
+		// slice_group_change_direction_flag, slice_group_change_rate_minus1
+		if _, err = r.ReadBit(); err != nil {
+			return
+		}
+		if _, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	case 6:
+		var pic_size_in_map_units_minus1 uint
+		if pic_size_in_map_units_minus1, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+
+		length := bits.Len(p.NumSliceGroupsMinus1)
+		for i := uint(0); i <= pic_size_in_map_units_minus1; i++ {
+			if _, err = r.ReadBits(length); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// ParameterSets 按照id保存SPS和PPS, 后出现的同id参数集会覆盖之前的
+type ParameterSets struct {
+	SPS map[uint]*SPS
+	PPS map[uint]*PPS
+}
+
+// Input 解析SPS或PPS, 其他NALU忽略
+func (p *ParameterSets) Input(nalu []byte) error {
+	nalu = RemoveStartCode(nalu)
+	if len(nalu) == 0 {
+		return nil
+	}
+
+	switch nalu[0] & 0x1F {
+	case H264NalSPS:
+		sps, err := ParseSPS(nalu)
+		if err != nil {
+			return err
+		} else if sps.Id >= H264MaxSpsCount {
+			return fmt.Errorf("invalid seq_parameter_set_id %d", sps.Id)
+		}
+		p.SPS[sps.Id] = &sps
+	case H264NalPPS:
+		pps, err := ParsePPS(nalu, p.SPS)
+		if err != nil {
+			return err
+		}
+		p.PPS[pps.Id] = &pps
+	}
+
+	return nil
+}
+
+// Find 返回pps_id对应的PPS和它引用的SPS
+func (p *ParameterSets) Find(ppsId uint) (*PPS, *SPS, error) {
+	pps, ok := p.PPS[ppsId]
+	if !ok {
+		return nil, nil, fmt.Errorf("not find pps %d", ppsId)
+	}
+
+	sps, ok := p.SPS[pps.SPSId]
+	if !ok {
+		return nil, nil, fmt.Errorf("pps %d references unknown sps %d", ppsId, pps.SPSId)
+	}
+	return pps, sps, nil
+}
+
+func NewParameterSets() *ParameterSets {
+	return &ParameterSets{SPS: make(map[uint]*SPS), PPS: make(map[uint]*PPS)}
+}
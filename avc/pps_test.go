@@ -0,0 +1,75 @@
+package avc
+
+import (
+	"encoding/hex"
+	"github.com/lkmio/avformat/bufio"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+func writeSE(w *bufio.BitsWriter, v int) {
+	if v > 0 {
+		writeUE(w, uint64(2*v-1))
+	} else {
+		writeUE(w, uint64(-2*v))
+	}
+}
+
+func TestParsePPS(t *testing.T) {
+	data, _ := hex.DecodeString("68ce0f2c80")
+	pps, err := ParsePPS(data, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	// Baseline没有扩展字段
+	utils.Assert(pps.Id == 0 && pps.SPSId == 0 && pps.EntropyCodingModeFlag == 0 && pps.DeblockingFilterControlPresentFlag == 1)
+	utils.Assert(pps.PicInitQpMinus26 == -3 && pps.ChromaQpIndexOffset == -2 && pps.SecondChromaQpIndexOffset == -2 && pps.Transform8x8ModeFlag == 0)
+
+	// High profile, 携带transform_8x8_mode_flag
+	w := &bufio.BitsWriter{Data: make([]byte, 32)}
+	w.Write(8, 0x68)
+	writeUE(w, 1)
+	writeUE(w, 2)
+	w.Write(2, 2) // entropy_coding_mode_flag, bottom_field_pic_order_in_frame_present_flag
+	writeUE(w, 0)
+	writeUE(w, 2)
+	writeUE(w, 1)
+	w.Write(1, 1)
+	w.Write(2, 2)
+	writeSE(w, -3)
+	writeSE(w, 0)
+	writeSE(w, 1)
+	w.Write(3, 4)
+	w.Write(1, 1) // transform_8x8_mode_flag
+	w.Write(1, 1) // pic_scaling_matrix_present_flag
+	for i := 0; i < 8; i++ {
+		w.Write(1, 0)
+	}
+	writeSE(w, 2)
+	w.Write(1, 1)
+
+	sets := NewParameterSets()
+	if err = sets.Input(w.Data[:(w.Offset+7)/8]); err != nil {
+		panic(err)
+	}
+
+	high := sets.PPS[1]
+	utils.Assert(high.SPSId == 2 && high.EntropyCodingModeFlag == 1 && high.NumRefIdxL0DefaultActiveMinus1 == 2 && high.NumRefIdxL1DefaultActiveMinus1 == 1)
+	utils.Assert(high.WeightedPredFlag == 1 && high.WeightedBipredIdc == 2 && high.PicInitQpMinus26 == -3 && high.ChromaQpIndexOffset == 1)
+	utils.Assert(high.Transform8x8ModeFlag == 1 && high.PicScalingMatrixPresentFlag == 1 && high.SecondChromaQpIndexOffset == 2)
+
+	// 引用的SPS不存在
+	_, _, err = sets.Find(1)
+	utils.Assert(err != nil)
+
+	sps, _ := hex.DecodeString("6742c01eda01e0089f961000000300100000030320f162ea")
+	if err = sets.Input(sps); err != nil {
+		panic(err)
+	} else if err = sets.Input(data); err != nil {
+		panic(err)
+	}
+
+	p, s, err := sets.Find(0)
+	utils.Assert(err == nil && p.SPSId == 0 && s.Width == 1920)
+}
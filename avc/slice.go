@@ -0,0 +1,458 @@
+package avc
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/lkmio/avformat/bufio"
+)
+
+const (
+	SliceTypeP  = 0
+	SliceTypeB  = 1
+	SliceTypeI  = 2
+	SliceTypeSP = 3
+	SliceTypeSI = 4
+)
+
+// SliceHeader slice_header, 参考7.3.3. 只解析到dec_ref_pic_marking
+type SliceHeader struct {
+	NalUnitType uint
+	NalRefIdc   uint
+
+	FirstMbInSlice         uint
+	SliceType              uint
+	PPSId                  uint
+	ColourPlaneId          uint
+	FrameNum               uint
+	FieldPicFlag           uint
+	BottomFieldFlag        uint
+	IdrPicId               uint
+	PicOrderCntLsb         uint
+	DeltaPicOrderCntBottom int
+	DeltaPicOrderCnt       [2]int
+	RedundantPicCnt        uint
+
+	DirectSpatialMvPredFlag    uint
+	NumRefIdxL0ActiveMinus1    uint
+	NumRefIdxL1ActiveMinus1    uint
+	NoOutputOfPriorPicsFlag    uint
+	LongTermReferenceFlag      uint
+	MemoryManagementControlOp5 bool // 是否包含memory_management_control_operation 5
+}
+
+// Type 返回0-4的slice类型
+func (h *SliceHeader) Type() uint {
+	return h.SliceType % 5
+}
+
+func (h *SliceHeader) IsIDR() bool {
+	return H264NalIDRSlice == h.NalUnitType
+}
+
+func (h *SliceHeader) IsIntra() bool {
+	return SliceTypeI == h.Type() || SliceTypeSI == h.Type()
+}
+
+func (h *SliceHeader) IsB() bool {
+	return SliceTypeB == h.Type()
+}
+
+// IsFirstSliceOfPicture 根据7.4.1.2.4判断当前slice是否属于新的图像, prev为上一个slice
+func (h *SliceHeader) IsFirstSliceOfPicture(prev *SliceHeader, sps *SPS) bool {
+	if prev == nil {
+		return true
+	}
+
+	return h.FrameNum != prev.FrameNum ||
+		h.PPSId != prev.PPSId ||
+		h.FieldPicFlag != prev.FieldPicFlag ||
+		h.BottomFieldFlag != prev.BottomFieldFlag ||
+		(h.NalRefIdc != prev.NalRefIdc && (h.NalRefIdc == 0 || prev.NalRefIdc == 0)) ||
+		(sps.PicOrderCntType == 0 && (h.PicOrderCntLsb != prev.PicOrderCntLsb || h.DeltaPicOrderCntBottom != prev.DeltaPicOrderCntBottom)) ||
+		(sps.PicOrderCntType == 1 && h.DeltaPicOrderCnt != prev.DeltaPicOrderCnt) ||
+		h.IsIDR() != prev.IsIDR() ||
+		(h.IsIDR() && prev.IsIDR() && h.IdrPicId != prev.IdrPicId)
+}
+
+func readSE(r *bufio.GolombBitReader) (int, error) {
+	value, err := r.ReadSE()
+	return int(value), err
+}
+
+// ParseSliceHeader 解析slice头, 使用sets查找引用的PPS和SPS
+func ParseSliceHeader(nalu []byte, sets *ParameterSets) (*SliceHeader, *SPS, error) {
+	nalu = RemoveStartCode(nalu)
+	if len(nalu) < 2 {
+		return nil, nil, fmt.Errorf("invalid slice size %d", len(nalu))
+	}
+
+	h := &SliceHeader{NalUnitType: uint(nalu[0] & 0x1F), NalRefIdc: uint(nalu[0] >> 5 & 0x3)}
+	if H264NalSlice != h.NalUnitType && H264NalIDRSlice != h.NalUnitType {
+		return nil, nil, fmt.Errorf("unsupported slice nal unit type %d", h.NalUnitType)
+	}
+
+	// slice头不会很长, 避免拷贝整个slice
+	r := &bufio.GolombBitReader{R: bytes.NewReader(nal2rbsp(nalu[1:bufio.MinInt(len(nalu), 512)]))}
+	sps, err := h.parse(r, sets)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, sps, nil
+}
+
+func (h *SliceHeader) parse(r *bufio.GolombBitReader, sets *ParameterSets) (sps *SPS, err error) {
+	if h.FirstMbInSlice, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if h.SliceType, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	} else if h.SliceType > 9 {
+		return nil, fmt.Errorf("invalid slice_type %d", h.SliceType)
+	}
+	if h.PPSId, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+
+	var pps *PPS
+	if pps, sps, err = sets.Find(h.PPSId); err != nil {
+		return
+	}
+
+	if sps.SeparateColourPlaneFlag != 0 {
+		if h.ColourPlaneId, err = r.ReadBits(2); err != nil {
+			return
+		}
+	}
+	if h.FrameNum, err = r.ReadBits(int(sps.Log2MaxFrameNumMinus4 + 4)); err != nil {
+		return
+	}
+	if sps.FrameMbsOnlyFlag == 0 {
+		if h.FieldPicFlag, err = r.ReadBit(); err != nil {
+			return
+		}
+		if h.FieldPicFlag != 0 {
+			if h.BottomFieldFlag, err = r.ReadBit(); err != nil {
+				return
+			}
+		}
+	}
+	if h.IsIDR() {
+		if h.IdrPicId, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+
+	if sps.PicOrderCntType == 0 {
+		if h.PicOrderCntLsb, err = r.ReadBits(int(sps.Log2MaxPicOrderCntLsbMinus4 + 4)); err != nil {
+			return
+		}
+		if pps.BottomFieldPicOrderInFramePresentFlag != 0 && h.FieldPicFlag == 0 {
+			if h.DeltaPicOrderCntBottom, err = readSE(r); err != nil {
+				return
+			}
+		}
+	} else if sps.PicOrderCntType == 1 && sps.DeltaPicOrderAlwaysZeroFlag == 0 {
+		if h.DeltaPicOrderCnt[0], err = readSE(r); err != nil {
+			return
+		}
+		if pps.BottomFieldPicOrderInFramePresentFlag != 0 && h.FieldPicFlag == 0 {
+			if h.DeltaPicOrderCnt[1], err = readSE(r); err != nil {
+				return
+			}
+		}
+	}
+
+	if pps.RedundantPicCntPresentFlag != 0 {
+		if h.RedundantPicCnt, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+
+	sliceType := h.Type()
+	if SliceTypeB == sliceType {
+		if h.DirectSpatialMvPredFlag, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	h.NumRefIdxL0ActiveMinus1 = pps.NumRefIdxL0DefaultActiveMinus1
+	h.NumRefIdxL1ActiveMinus1 = pps.NumRefIdxL1DefaultActiveMinus1
+	if SliceTypeP == sliceType || SliceTypeSP == sliceType || SliceTypeB == sliceType {
+		var num_ref_idx_active_override_flag uint
+		if num_ref_idx_active_override_flag, err = r.ReadBit(); err != nil {
+			return
+		}
+		if num_ref_idx_active_override_flag != 0 {
+			if h.NumRefIdxL0ActiveMinus1, err = r.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+			if SliceTypeB == sliceType {
+				if h.NumRefIdxL1ActiveMinus1, err = r.ReadExponentialGolombCode(); err != nil {
+					return
+				}
+			}
+		}
+		if h.NumRefIdxL0ActiveMinus1 >= H264MaxRefs || h.NumRefIdxL1ActiveMinus1 >= H264MaxRefs {
+			return nil, fmt.Errorf("invalid num_ref_idx_active_minus1 %d %d", h.NumRefIdxL0ActiveMinus1, h.NumRefIdxL1ActiveMinus1)
+		}
+	}
+
+	// ref_pic_list_modification
+	if !h.IsIntra() {
+		if err = skipRefPicListModification(r); err != nil {
+			return
+		}
+		if SliceTypeB == sliceType {
+			if err = skipRefPicListModification(r); err != nil {
+				return
+			}
+		}
+	}
+
+	if (pps.WeightedPredFlag != 0 && (SliceTypeP == sliceType || SliceTypeSP == sliceType)) || (pps.WeightedBipredIdc == 1 && SliceTypeB == sliceType) {
+		if err = h.skipPredWeightTable(r, sps); err != nil {
+			return
+		}
+	}
+
+	if h.NalRefIdc != 0 {
+		err = h.parseDecRefPicMarking(r)
+	}
+	return
+}
+
+func skipRefPicListModification(r *bufio.GolombBitReader) error {
+	ref_pic_list_modification_flag, err := r.ReadBit()
+	if err != nil || ref_pic_list_modification_flag == 0 {
+		return err
+	}
+
+	for i := 0; i < H264MaxRPLMCount; i++ {
+		modification_of_pic_nums_idc, err := r.ReadExponentialGolombCode()
+		if err != nil {
+			return err
+		} else if modification_of_pic_nums_idc == 3 {
+			return nil
+		} else if modification_of_pic_nums_idc > 5 {
+			return fmt.Errorf("invalid modification_of_pic_nums_idc %d", modification_of_pic_nums_idc)
+		}
+
+		// abs_diff_pic_num_minus1 或 long_term_pic_num 或 abs_diff_view_idx_minus1
+		if _, err = r.ReadExponentialGolombCode(); err != nil {
+			return err
+		}
+	}
+
+	return fmt.Errorf("too many ref_pic_list_modification operations")
+}
+
+func (h *SliceHeader) skipPredWeightTable(r *bufio.GolombBitReader, sps *SPS) error {
+	chromaArrayType := sps.ChromaFormatIdc
+	if sps.SeparateColourPlaneFlag != 0 {
+		chromaArrayType = 0
+	}
+
+	// luma_log2_weight_denom
+	if _, err := r.ReadExponentialGolombCode(); err != nil {
+		return err
+	}
+	if chromaArrayType != 0 {
+		// chroma_log2_weight_denom
+		if _, err := r.ReadExponentialGolombCode(); err != nil {
+			return err
+		}
+	}
+
+	lists := []uint{h.NumRefIdxL0ActiveMinus1}
+	if h.IsB() {
+		lists = append(lists, h.NumRefIdxL1ActiveMinus1)
+	}
+
+	for _, count := range lists {
+		for i := uint(0); i <= count; i++ {
+			flag, err := r.ReadBit()
+			if err != nil {
+				return err
+			}
+
+			// weight, offset
+			for j := uint(0); j < flag*2; j++ {
+				if _, err = r.ReadSE(); err != nil {
+					return err
+				}
+			}
+
+			if chromaArrayType == 0 {
+				continue
+			} else if flag, err = r.ReadBit(); err != nil {
+				return err
+			}
+			for j := uint(0); j < flag*4; j++ {
+				if _, err = r.ReadSE(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (h *SliceHeader) parseDecRefPicMarking(r *bufio.GolombBitReader) (err error) {
+	if h.IsIDR() {
+		if h.NoOutputOfPriorPicsFlag, err = r.ReadBit(); err != nil {
+			return
+		}
+		h.LongTermReferenceFlag, err = r.ReadBit()
+		return
+	}
+
+	var adaptive_ref_pic_marking_mode_flag uint
+	if adaptive_ref_pic_marking_mode_flag, err = r.ReadBit(); err != nil || adaptive_ref_pic_marking_mode_flag == 0 {
+		return
+	}
+
+	for i := 0; i < H264MaxMMCOCount; i++ {
+		var mmco uint
+		if mmco, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+
+		switch mmco {
+		case 0:
+			return
+		case 1, 2, 4, 6:
+			// difference_of_pic_nums_minus1, long_term_pic_num, max_long_term_frame_idx_plus1, long_term_frame_idx
+			if _, err = r.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+		case 3:
+			// difference_of_pic_nums_minus1, long_term_frame_idx
+			if _, err = r.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+			if _, err = r.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+		case 5:
+			h.MemoryManagementControlOp5 = true
+		default:
+			return fmt.Errorf("invalid memory_management_control_operation %d", mmco)
+		}
+	}
+
+	return fmt.Errorf("too many memory_management_control_operation")
+}
+
+// PicOrderCounter 根据8.2.1计算POC, 需要按照解码顺序输入每个图像的第一个slice
+type PicOrderCounter struct {
+	prevPicOrderCntMsb int
+	prevPicOrderCntLsb int
+	prevFrameNumOffset int
+	prevFrameNum       int
+	prevHasMMCO5       bool
+}
+
+// Calculate 返回当前图像的POC, 帧为顶场和底场中较小的
+func (c *PicOrderCounter) Calculate(h *SliceHeader, sps *SPS) int {
+	var top, bottom int
+	maxFrameNum := 1 << (sps.Log2MaxFrameNumMinus4 + 4)
+	frameNum := int(h.FrameNum)
+
+	// 前一个图像的mmco5等同于重置frame_num
+	frameNumOffset := c.prevFrameNumOffset
+	if c.prevHasMMCO5 {
+		frameNumOffset = 0
+	}
+	if h.IsIDR() {
+		frameNumOffset = 0
+	} else if c.prevFrameNum > frameNum {
+		frameNumOffset += maxFrameNum
+	}
+
+	switch sps.PicOrderCntType {
+	case 0:
+		if h.IsIDR() {
+			c.prevPicOrderCntMsb, c.prevPicOrderCntLsb = 0, 0
+		}
+
+		maxLsb := 1 << (sps.Log2MaxPicOrderCntLsbMinus4 + 4)
+		lsb := int(h.PicOrderCntLsb)
+		msb := c.prevPicOrderCntMsb
+		if lsb < c.prevPicOrderCntLsb && c.prevPicOrderCntLsb-lsb >= maxLsb/2 {
+			msb += maxLsb
+		} else if lsb > c.prevPicOrderCntLsb && lsb-c.prevPicOrderCntLsb > maxLsb/2 {
+			msb -= maxLsb
+		}
+
+		top = msb + lsb
+		bottom = top + h.DeltaPicOrderCntBottom
+		if h.FieldPicFlag != 0 {
+			bottom = top
+		}
+
+		if h.NalRefIdc != 0 {
+			c.prevPicOrderCntMsb, c.prevPicOrderCntLsb = msb, lsb
+			if h.MemoryManagementControlOp5 {
+				// 8.2.1: mmco5之后, 顶场的POC作为prevPicOrderCntLsb
+				c.prevPicOrderCntMsb = 0
+				c.prevPicOrderCntLsb = 0
+				if h.BottomFieldFlag == 0 {
+					c.prevPicOrderCntLsb = top - bufio.MinInt(top, bottom)
+				}
+			}
+		}
+	case 1:
+		var expected int
+		absFrameNum := 0
+		if len(sps.OffsetForRefFrame) != 0 {
+			absFrameNum = frameNumOffset + frameNum
+		}
+		if h.NalRefIdc == 0 && absFrameNum > 0 {
+			absFrameNum--
+		}
+
+		if absFrameNum > 0 {
+			n := len(sps.OffsetForRefFrame)
+			var deltaPerCycle int
+			for _, offset := range sps.OffsetForRefFrame {
+				deltaPerCycle += offset
+			}
+
+			expected = (absFrameNum - 1) / n * deltaPerCycle
+			for i := 0; i <= (absFrameNum-1)%n; i++ {
+				expected += sps.OffsetForRefFrame[i]
+			}
+		}
+		if h.NalRefIdc == 0 {
+			expected += sps.OffsetForNonRefPic
+		}
+
+		if h.FieldPicFlag == 0 {
+			top = expected + h.DeltaPicOrderCnt[0]
+			bottom = top + sps.OffsetForTopToBottomField + h.DeltaPicOrderCnt[1]
+		} else if h.BottomFieldFlag == 0 {
+			top = expected + h.DeltaPicOrderCnt[0]
+			bottom = top
+		} else {
+			bottom = expected + sps.OffsetForTopToBottomField + h.DeltaPicOrderCnt[0]
+			top = bottom
+		}
+	default:
+		if !h.IsIDR() {
+			top = 2 * (frameNumOffset + frameNum)
+			if h.NalRefIdc == 0 {
+				top--
+			}
+		}
+		bottom = top
+	}
+
+	c.prevFrameNumOffset = frameNumOffset
+	c.prevFrameNum = frameNum
+	c.prevHasMMCO5 = h.MemoryManagementControlOp5
+	if h.MemoryManagementControlOp5 {
+		c.prevFrameNum = 0
+	}
+
+	return bufio.MinInt(top, bottom)
+}
@@ -0,0 +1,166 @@
+package avc
+
+import (
+	"github.com/lkmio/avformat/bufio"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+// 构造Baseline SPS, log2_max_frame_num和log2_max_pic_order_cnt_lsb都为4
+func newSliceTestSPS(id, pocType uint64) []byte {
+	w := &bufio.BitsWriter{Data: make([]byte, 32)}
+	w.Write(8, 0x67)
+	w.Write(8, 66)
+	w.Write(8, 0)
+	w.Write(8, 30)
+	writeUE(w, id)
+	writeUE(w, 0) // log2_max_frame_num_minus4
+	writeUE(w, pocType)
+	if pocType == 0 {
+		writeUE(w, 0) // log2_max_pic_order_cnt_lsb_minus4
+	}
+	writeUE(w, 2)  // max_num_ref_frames
+	w.Write(1, 0)  // gaps_in_frame_num_value_allowed_flag
+	writeUE(w, 19) // pic_width_in_mbs_minus1
+	writeUE(w, 14) // pic_height_in_map_units_minus1
+	w.Write(3, 6)  // frame_mbs_only_flag, direct_8x8_inference_flag, frame_cropping_flag
+	w.Write(1, 0)  // vui_parameters_present_flag
+	w.Write(1, 1)
+	return w.Data[:(w.Offset+7)/8]
+}
+
+func newSliceTestPPS(id, spsId uint64) []byte {
+	w := &bufio.BitsWriter{Data: make([]byte, 16)}
+	w.Write(8, 0x68)
+	writeUE(w, id)
+	writeUE(w, spsId)
+	w.Write(2, 0) // entropy_coding_mode_flag, bottom_field_pic_order_in_frame_present_flag
+	writeUE(w, 0) // num_slice_groups_minus1
+	writeUE(w, 0)
+	writeUE(w, 0)
+	w.Write(3, 0) // weighted_pred_flag, weighted_bipred_idc
+	writeSE(w, 0)
+	writeSE(w, 0)
+	writeSE(w, 0)
+	w.Write(3, 4) // deblocking_filter_control_present_flag, constrained_intra_pred_flag, redundant_pic_cnt_present_flag
+	w.Write(1, 1)
+	return w.Data[:(w.Offset+7)/8]
+}
+
+type testSlice struct {
+	refIdc, sliceType, frameNum, pocLsb, firstMb uint64
+	idr                                          bool
+}
+
+func (s testSlice) marshal(ppsId uint64, pocType uint64) []byte {
+	w := &bufio.BitsWriter{Data: make([]byte, 32)}
+	nalType := uint64(H264NalSlice)
+	if s.idr {
+		nalType = H264NalIDRSlice
+	}
+
+	// 携带起始码
+	w.Write(32, 1)
+	w.Write(8, s.refIdc<<5|nalType)
+	writeUE(w, s.firstMb)
+	writeUE(w, s.sliceType)
+	writeUE(w, ppsId)
+	w.Write(4, s.frameNum)
+	if s.idr {
+		writeUE(w, 0) // idr_pic_id
+	}
+	if pocType == 0 {
+		w.Write(4, s.pocLsb)
+	}
+
+	sliceType := s.sliceType % 5
+	if SliceTypeB == sliceType {
+		w.Write(1, 0) // direct_spatial_mv_pred_flag
+	}
+	if SliceTypeP == sliceType || SliceTypeB == sliceType {
+		w.Write(1, 0) // num_ref_idx_active_override_flag
+		w.Write(1, 0) // ref_pic_list_modification_flag_l0
+	}
+	if SliceTypeB == sliceType {
+		w.Write(1, 0)
+	}
+	if s.refIdc != 0 && s.idr {
+		w.Write(2, 0)
+	} else if s.refIdc != 0 {
+		w.Write(1, 0) // adaptive_ref_pic_marking_mode_flag
+	}
+
+	// 模拟slice数据
+	w.Write(16, 0xFFFF)
+	return w.Data[:(w.Offset+7)/8]
+}
+
+func TestParseSliceHeader(t *testing.T) {
+	sets := NewParameterSets()
+	for _, nalu := range [][]byte{newSliceTestSPS(0, 0), newSliceTestPPS(0, 0), newSliceTestSPS(1, 2), newSliceTestPPS(1, 1)} {
+		if err := sets.Input(nalu); err != nil {
+			panic(err)
+		}
+	}
+
+	// POC type 0, IPBPPPP, 第6帧lsb回绕
+	slices := []testSlice{
+		{refIdc: 3, sliceType: 7, idr: true},
+		{refIdc: 2, sliceType: 5, frameNum: 1, pocLsb: 4},
+		{refIdc: 0, sliceType: 6, frameNum: 2, pocLsb: 2},
+		{refIdc: 2, sliceType: 0, frameNum: 2, pocLsb: 8},
+		{refIdc: 2, sliceType: 0, frameNum: 3, pocLsb: 12},
+		{refIdc: 2, sliceType: 0, frameNum: 4, pocLsb: 0},
+		{refIdc: 2, sliceType: 0, frameNum: 5, pocLsb: 4},
+	}
+	expected := []int{0, 4, 2, 8, 12, 16, 20}
+
+	var prev *SliceHeader
+	counter := PicOrderCounter{}
+	for i, s := range slices {
+		h, sps, err := ParseSliceHeader(s.marshal(0, 0), sets)
+		if err != nil {
+			panic(err)
+		}
+
+		utils.Assert(h.IsFirstSliceOfPicture(prev, sps))
+		utils.Assert(h.IsIDR() == s.idr && h.FrameNum == uint(s.frameNum) && h.PicOrderCntLsb == uint(s.pocLsb))
+		utils.Assert(counter.Calculate(h, sps) == expected[i])
+		prev = h
+	}
+
+	// 同一图像的第二个slice
+	h, sps, err := ParseSliceHeader(testSlice{refIdc: 2, sliceType: 0, frameNum: 5, pocLsb: 4, firstMb: 150}.marshal(0, 0), sets)
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(h.FirstMbInSlice == 150 && !h.IsFirstSliceOfPicture(prev, sps))
+
+	// I/P/B分类
+	utils.Assert(prev.Type() == SliceTypeP && !prev.IsIntra() && !prev.IsB())
+	h, _, _ = ParseSliceHeader(slices[0].marshal(0, 0), sets)
+	utils.Assert(h.IsIntra() && h.Type() == SliceTypeI)
+	h, _, _ = ParseSliceHeader(slices[2].marshal(0, 0), sets)
+	utils.Assert(h.IsB() && h.NalRefIdc == 0 && h.DirectSpatialMvPredFlag == 0)
+
+	// POC type 2, 非参考图像的POC为2*FrameNumOffset+frame_num-1
+	slices = []testSlice{
+		{refIdc: 3, sliceType: 7, idr: true},
+		{refIdc: 2, sliceType: 5, frameNum: 1},
+		{refIdc: 0, sliceType: 5, frameNum: 2},
+		{refIdc: 2, sliceType: 5, frameNum: 2},
+	}
+	expected = []int{0, 2, 3, 4}
+	counter = PicOrderCounter{}
+	for i, s := range slices {
+		h, sps, err = ParseSliceHeader(s.marshal(1, 2), sets)
+		if err != nil {
+			panic(err)
+		}
+		utils.Assert(sps.PicOrderCntType == 2 && counter.Calculate(h, sps) == expected[i])
+	}
+
+	// 未知的PPS
+	_, _, err = ParseSliceHeader(slices[0].marshal(2, 2), sets)
+	utils.Assert(err != nil)
+}
@@ -21,13 +21,32 @@ type SPS struct {
 	CropTop    uint
 	CropBottom uint
 
-	Width  int
-	Height int
-	FPS    int
+	ChromaFormatIdc         uint
+	SeparateColourPlaneFlag uint
+	BitDepthLumaMinus8      uint
+	BitDepthChromaMinus8    uint
+	MaxNumRefFrames         uint
+	FrameMbsOnlyFlag        uint
+
+	Log2MaxFrameNumMinus4       uint
+	PicOrderCntType             uint
+	Log2MaxPicOrderCntLsbMinus4 uint
+	DeltaPicOrderAlwaysZeroFlag uint
+	OffsetForNonRefPic          int
+	OffsetForTopToBottomField   int
+	OffsetForRefFrame           []int
+
+	Width     int
+	Height    int
+	FPS       int
+	FrameRate float64 // 根据timing_info计算的实际帧率, 没有则为0
+
+	VUIParametersPresentFlag uint
+	VUI                      VUI
 }
 
 func ParseSPS(data []byte) (s SPS, err error) {
-	data = RemoveStartCode(data)
+	data = nal2rbsp(RemoveStartCode(data))
 	r := &bufio.GolombBitReader{R: bytes.NewReader(data)}
 
 	if _, err = r.ReadBits(8); err != nil {
@@ -54,29 +73,27 @@ func ParseSPS(data []byte) (s SPS, err error) {
 		return
 	}
 
+	// 缺省为4:2:0
+	s.ChromaFormatIdc = 1
 	if s.ProfileIdc == 100 || s.ProfileIdc == 110 ||
 		s.ProfileIdc == 122 || s.ProfileIdc == 244 ||
 		s.ProfileIdc == 44 || s.ProfileIdc == 83 ||
 		s.ProfileIdc == 86 || s.ProfileIdc == 118 {
 
-		var chroma_format_idc uint
-		if chroma_format_idc, err = r.ReadExponentialGolombCode(); err != nil {
+		if s.ChromaFormatIdc, err = r.ReadExponentialGolombCode(); err != nil {
 			return
 		}
 
-		if chroma_format_idc == 3 {
-			// residual_colour_transform_flag
-			if _, err = r.ReadBit(); err != nil {
+		if s.ChromaFormatIdc == 3 {
+			if s.SeparateColourPlaneFlag, err = r.ReadBit(); err != nil {
 				return
 			}
 		}
 
-		// bit_depth_luma_minus8
-		if _, err = r.ReadExponentialGolombCode(); err != nil {
+		if s.BitDepthLumaMinus8, err = r.ReadExponentialGolombCode(); err != nil {
 			return
 		}
-		// bit_depth_chroma_minus8
-		if _, err = r.ReadExponentialGolombCode(); err != nil {
+		if s.BitDepthChromaMinus8, err = r.ReadExponentialGolombCode(); err != nil {
 			return
 		}
 		// qpprime_y_zero_transform_bypass_flag
@@ -90,7 +107,12 @@ func ParseSPS(data []byte) (s SPS, err error) {
 		}
 
 		if seq_scaling_matrix_present_flag != 0 {
-			for i := 0; i < 8; i++ {
+			count := 8
+			if s.ChromaFormatIdc == 3 {
+				count = 12
+			}
+
+			for i := 0; i < count; i++ {
 				var seq_scaling_list_present_flag uint
 				if seq_scaling_list_present_flag, err = r.ReadBit(); err != nil {
 					return
@@ -121,46 +143,56 @@ func ParseSPS(data []byte) (s SPS, err error) {
 		}
 	}
 
-	// log2_max_frame_num_minus4
-	if _, err = r.ReadExponentialGolombCode(); err != nil {
+	if s.Log2MaxFrameNumMinus4, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	} else if s.Log2MaxFrameNumMinus4 > 12 {
+		err = fmt.Errorf("invalid log2_max_frame_num_minus4 %d", s.Log2MaxFrameNumMinus4)
 		return
 	}
 
-	var pic_order_cnt_type uint
-	if pic_order_cnt_type, err = r.ReadExponentialGolombCode(); err != nil {
+	if s.PicOrderCntType, err = r.ReadExponentialGolombCode(); err != nil {
 		return
 	}
-	if pic_order_cnt_type == 0 {
-		// log2_max_pic_order_cnt_lsb_minus4
-		if _, err = r.ReadExponentialGolombCode(); err != nil {
+	if s.PicOrderCntType == 0 {
+		if s.Log2MaxPicOrderCntLsbMinus4, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		} else if s.Log2MaxPicOrderCntLsbMinus4 > 12 {
+			err = fmt.Errorf("invalid log2_max_pic_order_cnt_lsb_minus4 %d", s.Log2MaxPicOrderCntLsbMinus4)
 			return
 		}
-	} else if pic_order_cnt_type == 1 {
-		// delta_pic_order_always_zero_flag
-		if _, err = r.ReadBit(); err != nil {
+	} else if s.PicOrderCntType == 1 {
+		if s.DeltaPicOrderAlwaysZeroFlag, err = r.ReadBit(); err != nil {
 			return
 		}
-		// offset_for_non_ref_pic
-		if _, err = r.ReadSE(); err != nil {
+
+		var value uint
+		if value, err = r.ReadSE(); err != nil {
 			return
 		}
-		// offset_for_top_to_bottom_field
-		if _, err = r.ReadSE(); err != nil {
+		s.OffsetForNonRefPic = int(value)
+		if value, err = r.ReadSE(); err != nil {
 			return
 		}
+		s.OffsetForTopToBottomField = int(value)
+
 		var num_ref_frames_in_pic_order_cnt_cycle uint
 		if num_ref_frames_in_pic_order_cnt_cycle, err = r.ReadExponentialGolombCode(); err != nil {
 			return
+		} else if num_ref_frames_in_pic_order_cnt_cycle > 255 {
+			err = fmt.Errorf("invalid num_ref_frames_in_pic_order_cnt_cycle %d", num_ref_frames_in_pic_order_cnt_cycle)
+			return
 		}
-		for i := uint(0); i < num_ref_frames_in_pic_order_cnt_cycle; i++ {
-			if _, err = r.ReadSE(); err != nil {
+
+		s.OffsetForRefFrame = make([]int, num_ref_frames_in_pic_order_cnt_cycle)
+		for i := range s.OffsetForRefFrame {
+			if value, err = r.ReadSE(); err != nil {
 				return
 			}
+			s.OffsetForRefFrame[i] = int(value)
 		}
 	}
 
-	// max_num_ref_frames
-	if _, err = r.ReadExponentialGolombCode(); err != nil {
+	if s.MaxNumRefFrames, err = r.ReadExponentialGolombCode(); err != nil {
 		return
 	}
 
@@ -179,11 +211,10 @@ func ParseSPS(data []byte) (s SPS, err error) {
 	}
 	s.MbHeight++
 
-	var frame_mbs_only_flag uint
-	if frame_mbs_only_flag, err = r.ReadBit(); err != nil {
+	if s.FrameMbsOnlyFlag, err = r.ReadBit(); err != nil {
 		return
 	}
-	if frame_mbs_only_flag == 0 {
+	if s.FrameMbsOnlyFlag == 0 {
 		// mb_adaptive_frame_field_flag
 		if _, err = r.ReadBit(); err != nil {
 			return
@@ -214,133 +245,100 @@ func ParseSPS(data []byte) (s SPS, err error) {
 		}
 	}
 
-	s.Width = int((s.MbWidth * 16) - s.CropLeft*2 - s.CropRight*2)
-	s.Height = int(((2 - frame_mbs_only_flag) * s.MbHeight * 16) - s.CropTop*2 - s.CropBottom*2)
-
-	vui_parameter_present_flag, err := r.ReadBit()
-	if err != nil {
-		return
+	// 裁剪单位取决于色度格式和是否为场编码
+	cropUnitX, cropUnitY := uint(1), 2-s.FrameMbsOnlyFlag
+	if s.ChromaFormatIdc == 1 {
+		cropUnitX, cropUnitY = 2, cropUnitY*2
+	} else if s.ChromaFormatIdc == 2 {
+		cropUnitX = 2
 	}
 
-	if vui_parameter_present_flag != 0 {
-		aspect_ratio_info_present_flag, err := r.ReadBit()
-		if err != nil {
-			return s, err
-		}
-
-		if aspect_ratio_info_present_flag != 0 {
-			aspect_ratio_idc, err := r.ReadBits(8)
-			if err != nil {
-				return s, err
-			}
+	s.Width = int((s.MbWidth * 16) - (s.CropLeft+s.CropRight)*cropUnitX)
+	s.Height = int(((2 - s.FrameMbsOnlyFlag) * s.MbHeight * 16) - (s.CropTop+s.CropBottom)*cropUnitY)
 
-			if aspect_ratio_idc == 255 {
-				sar_width, err := r.ReadBits(16)
-				if err != nil {
-					return s, err
-				}
-				sar_height, err := r.ReadBits(16)
-				if err != nil {
-					return s, err
-				}
-
-				_, _ = sar_width, sar_height
-			}
-		}
+	if s.VUIParametersPresentFlag, err = r.ReadBit(); err != nil {
+		return
+	}
 
-		overscan_info_present_flag, err := r.ReadBit()
-		if err != nil {
-			return s, err
+	if s.VUIParametersPresentFlag != 0 {
+		if err = parseVUI(r, &s.VUI); err != nil {
+			return
 		}
+	} else {
+		s.VUI.setDefault()
+	}
 
-		if overscan_info_present_flag != 0 {
-			overscan_appropriate_flagu, err := r.ReadBit()
-			if err != nil {
-				return s, err
-			}
+	if s.VUI.TimingInfoPresentFlag != 0 && s.VUI.NumUnitsInTick != 0 {
+		// 一帧包含两个场, 每个tick对应一个场
+		s.FrameRate = float64(s.VUI.TimeScale) / float64(2*s.VUI.NumUnitsInTick)
+		// 与之前的实现保持一致向下取整, 29.97为29
+		s.FPS = int(math.Floor(s.FrameRate))
+	}
 
-			_ = overscan_appropriate_flagu
+	if s.VUI.BitstreamRestrictionFlag == 0 {
+		// 缺省时按照level的最大DPB推导
+		s.VUI.MaxDecFrameBuffering = s.MaxDpbFrames()
+		s.VUI.MaxNumReorderFrames = s.VUI.MaxDecFrameBuffering
+		if s.IsIntraProfile() {
+			s.VUI.MaxDecFrameBuffering = 0
+			s.VUI.MaxNumReorderFrames = 0
 		}
-		video_signal_type_present_flag, err := r.ReadBit()
-		if video_signal_type_present_flag != 0 {
-			video_format, err := r.ReadBits(3)
-			if err != nil {
-				return s, err
-			}
-			_ = video_format
-			video_full_range_flag, err := r.ReadBit()
-			if err != nil {
-				return s, err
-			}
-			_ = video_full_range_flag
-			colour_description_present_flag, err := r.ReadBit()
-			if err != nil {
-				return s, err
-			}
-			if colour_description_present_flag != 0 {
-				colour_primaries, err := r.ReadBits(8)
-				if err != nil {
-					return s, err
-				}
-				_ = colour_primaries
-				transfer_characteristics, err := r.ReadBits(8)
-				if err != nil {
-					return s, err
-				}
-				_ = transfer_characteristics
-				matrix_coefficients, err := r.ReadBits(8)
-				if err != nil {
-					return s, err
-				}
-				_ = matrix_coefficients
-			}
-		}
-		chroma_loc_info_present_flag, err := r.ReadBit()
-		if err != nil {
-			return s, err
-		}
-		if chroma_loc_info_present_flag != 0 {
-			chroma_sample_loc_type_top_field, err := r.ReadSE()
-			if err != nil {
-				return s, err
-			}
-			_ = chroma_sample_loc_type_top_field
-
-			chroma_sample_loc_type_bottom_field, err := r.ReadSE()
-			if err != nil {
-				return s, err
-			}
+	}
+	return
+}
 
-			_ = chroma_sample_loc_type_bottom_field
-		}
+// IsIntraProfile 是否为仅包含帧内编码的profile
+func (s *SPS) IsIntraProfile() bool {
+	switch s.ProfileIdc {
+	case 44:
+		return true
+	case 86, 100, 110, 122, 244:
+		return s.ConstraintSetFlag&0x04 != 0
+	}
+	return false
+}
 
-		timing_info_present_flag, err := r.ReadBit()
-		if err != nil {
-			return s, err
+// MaxDpbFrames 根据level和图像大小计算DPB可容纳的最大帧数, 参考Table A-1
+func (s *SPS) MaxDpbFrames() uint {
+	var maxDpbMbs uint
+	switch s.LevelIdc {
+	case 9, 10:
+		maxDpbMbs = 396
+	case 11:
+		maxDpbMbs = 900
+		// level 1b
+		if s.ConstraintSetFlag&0x04 != 0 && s.ProfileIdc != 100 && s.ProfileIdc != 110 && s.ProfileIdc != 122 && s.ProfileIdc != 244 {
+			maxDpbMbs = 396
 		}
+	case 12, 13, 20:
+		maxDpbMbs = 2376
+	case 21:
+		maxDpbMbs = 4752
+	case 22, 30:
+		maxDpbMbs = 8100
+	case 31:
+		maxDpbMbs = 18000
+	case 32:
+		maxDpbMbs = 20480
+	case 40, 41:
+		maxDpbMbs = 32768
+	case 42:
+		maxDpbMbs = 34816
+	case 50:
+		maxDpbMbs = 110400
+	case 51, 52:
+		maxDpbMbs = 184320
+	default:
+		maxDpbMbs = 696320
+	}
 
-		if timing_info_present_flag != 0 {
-			num_units_in_tick, err := r.ReadBits(32)
-			if err != nil {
-				return s, err
-			}
-			time_scale, err := r.ReadBits(32)
-			if err != nil {
-				return s, err
-			}
-			s.FPS = int(math.Floor(float64(time_scale) / float64(num_units_in_tick) / 2.0))
-			fixed_frame_rate_flag, err := r.ReadBit()
-			if err != nil {
-				return s, err
-			}
-			if fixed_frame_rate_flag != 0 {
-				//utils.L.InfoLn("fixed_frame_rate_flag", fixed_frame_rate_flag)
-				//have been devide 2
-				//self.fps = self.fps / 2
-			}
-		}
+	frameMbs := s.MbWidth * (2 - s.FrameMbsOnlyFlag) * s.MbHeight
+	if frameMbs == 0 {
+		return 16
+	} else if frames := maxDpbMbs / frameMbs; frames < 16 {
+		return frames
 	}
-	return
+	return 16
 }
 
 func NewCodecDataFromAVCDecoderConfigurationRecord(record []byte) (*AVCDecoderConfigurationRecord, *SPS, error) {
@@ -0,0 +1,150 @@
+package avc
+
+import (
+	"bytes"
+	"github.com/lkmio/avformat/bufio"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+func writeUE(w *bufio.BitsWriter, v uint64) {
+	length := 0
+	for tmp := v + 1; tmp > 1; tmp >>= 1 {
+		length++
+	}
+	w.Write(length, 0)
+	w.Write(length+1, v+1)
+}
+
+// 插入防竞争字节
+func rbsp2nal(rbsp []byte) []byte {
+	var nal []byte
+	zeros := 0
+	for _, b := range rbsp {
+		if zeros >= 2 && b <= 3 {
+			nal = append(nal, 3)
+			zeros = 0
+		}
+
+		nal = append(nal, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return nal
+}
+
+func TestParseSPSWithVUI(t *testing.T) {
+	w := &bufio.BitsWriter{Data: make([]byte, 128)}
+	w.Write(8, 0x67)
+	w.Write(8, 100) // profile_idc
+	w.Write(8, 0)
+	w.Write(8, 40) // level_idc
+	writeUE(w, 0)  // seq_parameter_set_id
+	writeUE(w, 1)  // chroma_format_idc
+	writeUE(w, 0)
+	writeUE(w, 0)
+	w.Write(2, 0)   // qpprime_y_zero_transform_bypass_flag, seq_scaling_matrix_present_flag
+	writeUE(w, 0)   // log2_max_frame_num_minus4
+	writeUE(w, 0)   // pic_order_cnt_type
+	writeUE(w, 2)   // log2_max_pic_order_cnt_lsb_minus4
+	writeUE(w, 4)   // max_num_ref_frames
+	w.Write(1, 0)   // gaps_in_frame_num_value_allowed_flag
+	writeUE(w, 119) // pic_width_in_mbs_minus1
+	writeUE(w, 67)  // pic_height_in_map_units_minus1
+	w.Write(3, 0x7) // frame_mbs_only_flag, direct_8x8_inference_flag, frame_cropping_flag
+	writeUE(w, 0)
+	writeUE(w, 0)
+	writeUE(w, 0)
+	writeUE(w, 4)
+	w.Write(1, 1) // vui_parameters_present_flag
+
+	// SAR 4:3
+	w.Write(1, 1)
+	w.Write(8, AspectRatioExtendedSAR)
+	w.Write(16, 4)
+	w.Write(16, 3)
+	w.Write(1, 0)
+	// BT.2020 PQ, 全范围
+	w.Write(1, 1)
+	w.Write(3, 5)
+	w.Write(2, 3)
+	w.Write(8, 9)
+	w.Write(8, 16)
+	w.Write(8, 9)
+	w.Write(1, 0)
+	// 59.94fps
+	w.Write(1, 1)
+	w.Write(32, 1001)
+	w.Write(32, 120000)
+	w.Write(1, 1)
+	// NAL HRD, 3.2Mbps
+	w.Write(1, 1)
+	writeUE(w, 0)
+	w.Write(4, 4)
+	w.Write(4, 3)
+	writeUE(w, 3124)
+	writeUE(w, 999)
+	w.Write(1, 1)
+	w.Write(5, 23)
+	w.Write(5, 23)
+	w.Write(5, 23)
+	w.Write(5, 24)
+	w.Write(1, 0) // vcl_hrd_parameters_present_flag
+	w.Write(1, 0) // low_delay_hrd_flag
+	w.Write(1, 1) // pic_struct_present_flag
+	// bitstream_restriction
+	w.Write(1, 1)
+	w.Write(1, 1)
+	writeUE(w, 0)
+	writeUE(w, 0)
+	writeUE(w, 16)
+	writeUE(w, 16)
+	writeUE(w, 2)
+	writeUE(w, 4)
+	w.Write(1, 1) // rbsp_stop_one_bit
+
+	rbsp := w.Data[:(w.Offset+7)/8]
+	nal := rbsp2nal(rbsp)
+
+	sps, err := ParseSPS(append(append([]byte{}, StartCode4...), nal...))
+	if err != nil {
+		panic(err)
+	}
+
+	utils.Assert(sps.Width == 1920 && sps.Height == 1080 && sps.MaxNumRefFrames == 4)
+	utils.Assert(sps.VUI.SarWidth == 4 && sps.VUI.SarHeight == 3)
+	utils.Assert(sps.VUI.VideoFullRangeFlag == 1 && sps.VUI.ColourPrimaries == 9 && sps.VUI.TransferCharacteristics == 16 && sps.VUI.MatrixCoefficients == 9)
+	utils.Assert(sps.FPS == 59 && sps.FrameRate > 59.94 && sps.FrameRate < 59.95 && sps.VUI.FixedFrameRateFlag == 1)
+	utils.Assert(sps.VUI.NalHRD != nil && sps.VUI.VclHRD == nil && sps.VUI.NalHRD.BitRate(0) == 3200000 && sps.VUI.NalHRD.CpbSize(0) == 1000*128)
+	utils.Assert(sps.VUI.NalHRD.CbrFlag[0] == 1 && sps.VUI.NalHRD.TimeOffsetLength == 24 && sps.VUI.PicStructPresentFlag == 1)
+	utils.Assert(sps.VUI.MaxNumReorderFrames == 2 && sps.VUI.MaxDecFrameBuffering == 4 && sps.VUI.Log2MaxMvLengthVertical == 16)
+
+	// 没有VUI时使用缺省值, 重排序帧数按照level推导
+	w = &bufio.BitsWriter{Data: make([]byte, 16)}
+	w.Write(8, 0x67)
+	w.Write(8, 77)
+	w.Write(8, 0)
+	w.Write(8, 31)
+	writeUE(w, 0)
+	writeUE(w, 0)
+	writeUE(w, 2)
+	writeUE(w, 1)
+	w.Write(1, 0)
+	writeUE(w, 79)
+	writeUE(w, 44)
+	w.Write(3, 0x6)
+	w.Write(1, 0)
+	w.Write(1, 1)
+
+	sps, err = ParseSPS(w.Data[:(w.Offset+7)/8])
+	if err != nil {
+		panic(err)
+	}
+
+	utils.Assert(sps.Width == 1280 && sps.Height == 720 && sps.FPS == 0 && sps.FrameRate == 0)
+	utils.Assert(sps.VUI.ColourPrimaries == ColourUnspecified && sps.VUI.MaxNumReorderFrames == 5)
+	utils.Assert(bytes.Equal(nal2rbsp([]byte{0, 0, 3, 1}), []byte{0, 0, 1}))
+}
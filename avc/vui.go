@@ -0,0 +1,292 @@
+package avc
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/lkmio/avformat/bufio"
+)
+
+// 预定义的像素宽高比, 参考Table E-1
+var sampleAspectRatios = [][2]uint{
+	{0, 0}, {1, 1}, {12, 11}, {10, 11}, {16, 11}, {40, 33}, {24, 11}, {20, 11}, {32, 11},
+	{80, 33}, {18, 11}, {15, 11}, {64, 33}, {160, 99}, {4, 3}, {3, 2}, {2, 1},
+}
+
+const (
+	AspectRatioExtendedSAR = 255
+
+	// ColourUnspecified 未指定的色彩原色/转换特性/矩阵系数
+	ColourUnspecified = 2
+)
+
+// HRD hrd_parameters, 参考E.1.2
+type HRD struct {
+	CpbCntMinus1                       uint
+	BitRateScale                       uint
+	CpbSizeScale                       uint
+	BitRateValueMinus1                 []uint
+	CpbSizeValueMinus1                 []uint
+	CbrFlag                            []uint
+	InitialCpbRemovalDelayLengthMinus1 uint
+	CpbRemovalDelayLengthMinus1        uint
+	DpbOutputDelayLengthMinus1         uint
+	TimeOffsetLength                   uint
+}
+
+// BitRate 返回第i个CPB的码率, 单位bit/s
+func (h *HRD) BitRate(i int) uint {
+	return (h.BitRateValueMinus1[i] + 1) << (6 + h.BitRateScale)
+}
+
+// CpbSize 返回第i个CPB的大小, 单位bit
+func (h *HRD) CpbSize(i int) uint {
+	return (h.CpbSizeValueMinus1[i] + 1) << (4 + h.CpbSizeScale)
+}
+
+// VUI vui_parameters, 参考E.1.1. 未出现的字段为标准规定的缺省值
+type VUI struct {
+	AspectRatioInfoPresentFlag uint
+	AspectRatioIdc             uint
+	SarWidth                   uint
+	SarHeight                  uint
+
+	OverscanInfoPresentFlag uint
+	OverscanAppropriateFlag uint
+
+	VideoSignalTypePresentFlag   uint
+	VideoFormat                  uint
+	VideoFullRangeFlag           uint
+	ColourDescriptionPresentFlag uint
+	ColourPrimaries              uint
+	TransferCharacteristics      uint
+	MatrixCoefficients           uint
+
+	ChromaLocInfoPresentFlag       uint
+	ChromaSampleLocTypeTopField    uint
+	ChromaSampleLocTypeBottomField uint
+
+	TimingInfoPresentFlag uint
+	NumUnitsInTick        uint
+	TimeScale             uint
+	FixedFrameRateFlag    uint
+
+	NalHRD          *HRD
+	VclHRD          *HRD
+	LowDelayHRDFlag uint
+
+	PicStructPresentFlag uint
+
+	BitstreamRestrictionFlag           uint
+	MotionVectorsOverPicBoundariesFlag uint
+	MaxBytesPerPicDenom                uint
+	MaxBitsPerMbDenom                  uint
+	Log2MaxMvLengthHorizontal          uint
+	Log2MaxMvLengthVertical            uint
+	MaxNumReorderFrames                uint
+	MaxDecFrameBuffering               uint
+}
+
+func (v *VUI) setDefault() {
+	// 5-Unspecified video format
+	v.VideoFormat = 5
+	v.ColourPrimaries = ColourUnspecified
+	v.TransferCharacteristics = ColourUnspecified
+	v.MatrixCoefficients = ColourUnspecified
+	v.MotionVectorsOverPicBoundariesFlag = 1
+	v.MaxBytesPerPicDenom = 2
+	v.MaxBitsPerMbDenom = 1
+	v.Log2MaxMvLengthHorizontal = 15
+	v.Log2MaxMvLengthVertical = 15
+}
+
+func parseHRD(r *bufio.GolombBitReader) (h *HRD, err error) {
+	h = &HRD{}
+	if h.CpbCntMinus1, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	} else if h.CpbCntMinus1 > 31 {
+		return nil, fmt.Errorf("invalid cpb_cnt_minus1 %d", h.CpbCntMinus1)
+	}
+
+	if h.BitRateScale, err = r.ReadBits(4); err != nil {
+		return
+	}
+	if h.CpbSizeScale, err = r.ReadBits(4); err != nil {
+		return
+	}
+
+	count := h.CpbCntMinus1 + 1
+	h.BitRateValueMinus1 = make([]uint, count)
+	h.CpbSizeValueMinus1 = make([]uint, count)
+	h.CbrFlag = make([]uint, count)
+	for i := uint(0); i < count; i++ {
+		if h.BitRateValueMinus1[i], err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if h.CpbSizeValueMinus1[i], err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if h.CbrFlag[i], err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	if h.InitialCpbRemovalDelayLengthMinus1, err = r.ReadBits(5); err != nil {
+		return
+	}
+	if h.CpbRemovalDelayLengthMinus1, err = r.ReadBits(5); err != nil {
+		return
+	}
+	if h.DpbOutputDelayLengthMinus1, err = r.ReadBits(5); err != nil {
+		return
+	}
+	h.TimeOffsetLength, err = r.ReadBits(5)
+	return
+}
+
+func parseVUI(r *bufio.GolombBitReader, v *VUI) (err error) {
+	v.setDefault()
+	if v.AspectRatioInfoPresentFlag, err = r.ReadBit(); err != nil {
+		return
+	}
+
+	if v.AspectRatioInfoPresentFlag != 0 {
+		if v.AspectRatioIdc, err = r.ReadBits(8); err != nil {
+			return
+		}
+
+		if v.AspectRatioIdc == AspectRatioExtendedSAR {
+			if v.SarWidth, err = r.ReadBits(16); err != nil {
+				return
+			}
+			if v.SarHeight, err = r.ReadBits(16); err != nil {
+				return
+			}
+		} else if v.AspectRatioIdc < uint(len(sampleAspectRatios)) {
+			v.SarWidth = sampleAspectRatios[v.AspectRatioIdc][0]
+			v.SarHeight = sampleAspectRatios[v.AspectRatioIdc][1]
+		}
+	}
+
+	if v.OverscanInfoPresentFlag, err = r.ReadBit(); err != nil {
+		return
+	}
+	if v.OverscanInfoPresentFlag != 0 {
+		if v.OverscanAppropriateFlag, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	if v.VideoSignalTypePresentFlag, err = r.ReadBit(); err != nil {
+		return
+	}
+	if v.VideoSignalTypePresentFlag != 0 {
+		if v.VideoFormat, err = r.ReadBits(3); err != nil {
+			return
+		}
+		if v.VideoFullRangeFlag, err = r.ReadBit(); err != nil {
+			return
+		}
+		if v.ColourDescriptionPresentFlag, err = r.ReadBit(); err != nil {
+			return
+		}
+
+		if v.ColourDescriptionPresentFlag != 0 {
+			if v.ColourPrimaries, err = r.ReadBits(8); err != nil {
+				return
+			}
+			if v.TransferCharacteristics, err = r.ReadBits(8); err != nil {
+				return
+			}
+			if v.MatrixCoefficients, err = r.ReadBits(8); err != nil {
+				return
+			}
+		}
+	}
+
+	if v.ChromaLocInfoPresentFlag, err = r.ReadBit(); err != nil {
+		return
+	}
+	if v.ChromaLocInfoPresentFlag != 0 {
+		if v.ChromaSampleLocTypeTopField, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if v.ChromaSampleLocTypeBottomField, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+
+	if v.TimingInfoPresentFlag, err = r.ReadBit(); err != nil {
+		return
+	}
+	if v.TimingInfoPresentFlag != 0 {
+		if v.NumUnitsInTick, err = r.ReadBits(32); err != nil {
+			return
+		}
+		if v.TimeScale, err = r.ReadBits(32); err != nil {
+			return
+		}
+		if v.FixedFrameRateFlag, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	var nal_hrd_parameters_present_flag, vcl_hrd_parameters_present_flag uint
+	if nal_hrd_parameters_present_flag, err = r.ReadBit(); err != nil {
+		return
+	} else if nal_hrd_parameters_present_flag != 0 {
+		if v.NalHRD, err = parseHRD(r); err != nil {
+			return
+		}
+	}
+
+	if vcl_hrd_parameters_present_flag, err = r.ReadBit(); err != nil {
+		return
+	} else if vcl_hrd_parameters_present_flag != 0 {
+		if v.VclHRD, err = parseHRD(r); err != nil {
+			return
+		}
+	}
+
+	if v.NalHRD != nil || v.VclHRD != nil {
+		if v.LowDelayHRDFlag, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	if v.PicStructPresentFlag, err = r.ReadBit(); err != nil {
+		return
+	}
+
+	if v.BitstreamRestrictionFlag, err = r.ReadBit(); err != nil {
+		return
+	}
+	if v.BitstreamRestrictionFlag != 0 {
+		if v.MotionVectorsOverPicBoundariesFlag, err = r.ReadBit(); err != nil {
+			return
+		}
+		if v.MaxBytesPerPicDenom, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if v.MaxBitsPerMbDenom, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if v.Log2MaxMvLengthHorizontal, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if v.Log2MaxMvLengthVertical, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if v.MaxNumReorderFrames, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if v.MaxDecFrameBuffering, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// nal2rbsp 去除防竞争字节0x03
+func nal2rbsp(nal []byte) []byte {
+	return bytes.Replace(nal, []byte{0x0, 0x0, 0x3}, []byte{0x0, 0x0}, -1)
+}
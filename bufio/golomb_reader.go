@@ -83,7 +83,13 @@ func (self *GolombBitReader) ReadSE() (res uint, err error) {
 	if res&0x01 != 0 {
 		res = (res + 1) / 2
 	} else {
-		res = -res / 2
+		// 负数以补码返回, 调用方转换为int
+		res = uint(-int(res / 2))
 	}
 	return
 }
+
+// BufferedBits 已经从R读入但还未消费的bit数
+func (self *GolombBitReader) BufferedBits() int {
+	return int(self.left)
+}
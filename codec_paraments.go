@@ -2,8 +2,13 @@ package avformat
 
 import (
 	"fmt"
+	"github.com/lkmio/avformat/av1"
 	"github.com/lkmio/avformat/avc"
 	"github.com/lkmio/avformat/hevc"
+	"github.com/lkmio/avformat/utils"
+	"github.com/lkmio/avformat/vp8"
+	"github.com/lkmio/avformat/vp9"
+	"github.com/lkmio/avformat/vvc"
 )
 
 type CodecData interface {
@@ -97,6 +102,121 @@ func (h HEVCCodecData) MP4ExtraData() []byte {
 	return h.m4vc
 }
 
+// VVCCodecData VPS可以不存在
+type VVCCodecData struct {
+	codecData
+	Record *vvc.VVCDecoderConfigurationRecord
+}
+
+func (h VVCCodecData) SPS() [][]byte {
+	return h.Record.SPSList
+}
+
+func (h VVCCodecData) PPS() [][]byte {
+	return h.Record.PPSList
+}
+
+func (h VVCCodecData) VPS() [][]byte {
+	return h.Record.VPSList
+}
+
+func (h VVCCodecData) AnnexBExtraData() []byte {
+	if h.annexB == nil {
+		h.annexB = mix(h.Record.VPSList, h.Record.SPSList, h.Record.PPSList)
+	}
+
+	return h.annexB
+}
+
+func (h VVCCodecData) MP4ExtraData() []byte {
+	if h.m4vc == nil {
+		h.m4vc, _ = h.Record.Marshal(h.Record.VPSList, h.Record.SPSList, h.Record.PPSList)
+	}
+
+	return h.m4vc
+}
+
+// AV1CodecData SPS返回sequence header OBU, AnnexBExtraData返回Low Overhead格式的configOBUs
+type AV1CodecData struct {
+	codecData
+	Record *av1.AV1CodecConfigurationRecord
+}
+
+func (a AV1CodecData) AnnexBExtraData() []byte {
+	return a.Record.ConfigOBUs
+}
+
+func (a AV1CodecData) MP4ExtraData() []byte {
+	if a.m4vc == nil {
+		a.m4vc = a.Record.Marshal()
+	}
+
+	return a.m4vc
+}
+
+func (a AV1CodecData) SPS() [][]byte {
+	if obu := a.Record.SequenceHeaderOBU(); obu != nil {
+		return [][]byte{obu}
+	}
+
+	return nil
+}
+
+func (a AV1CodecData) PPS() [][]byte {
+	return nil
+}
+
+// VPCodecData VP8和VP9共用, vpcC不携带宽高, 需要从关键帧中获取. 没有AnnexB格式的extra data
+type VPCodecData struct {
+	codecData
+	Record *vp9.VPCodecConfigurationRecord
+}
+
+func (v VPCodecData) AnnexBExtraData() []byte {
+	return nil
+}
+
+func (v VPCodecData) MP4ExtraData() []byte {
+	if v.m4vc == nil {
+		v.m4vc = v.Record.Marshal()
+	}
+
+	return v.m4vc
+}
+
+func (v VPCodecData) SPS() [][]byte {
+	return nil
+}
+
+func (v VPCodecData) PPS() [][]byte {
+	return nil
+}
+
+// UpdateFromKeyFrame 从关键帧头中获取宽高
+func (v *VPCodecData) UpdateFromKeyFrame(id utils.AVCodecID, data []byte) error {
+	if utils.AVCodecIdVP8 == id {
+		header, err := vp8.ParseFrameHeader(data)
+		if err != nil {
+			return err
+		} else if !header.KeyFrame {
+			return fmt.Errorf("not a vp8 key frame")
+		}
+
+		v.width, v.height = header.Width, header.Height
+		return nil
+	}
+
+	header, err := vp9.ParseKeyFrameHeader(data)
+	if err != nil {
+		return err
+	} else if header.Width == 0 {
+		return fmt.Errorf("vp9 frame size not found")
+	}
+
+	v.width, v.height = header.Width, header.Height
+	return nil
+}
+
 func ParseAVCDecoderConfigurationRecord(data []byte) (CodecData, error) {
 	configurationRecord := avc.AVCDecoderConfigurationRecord{}
 	if err := configurationRecord.Unmarshal(data); err != nil {
@@ -142,6 +262,70 @@ func ParseHEVCDecoderConfigurationRecord(data []byte) (CodecData, error) {
 	return &c, nil
 }
 
+func ParseVVCDecoderConfigurationRecord(data []byte) (CodecData, error) {
+	configurationRecord := vvc.VVCDecoderConfigurationRecord{}
+	if err := configurationRecord.Unmarshal(data); err != nil {
+		return nil, err
+	}
+
+	sps, err := vvc.ParseSPS(configurationRecord.SPSList[0])
+	if err != nil {
+		return nil, err
+	}
+
+	c := VVCCodecData{
+		codecData: codecData{
+			m4vc:   data,
+			width:  sps.Width,
+			height: sps.Height,
+		},
+		Record: &configurationRecord,
+	}
+	return &c, nil
+}
+
+func ParseAV1CodecConfigurationRecord(data []byte) (CodecData, error) {
+	record := av1.AV1CodecConfigurationRecord{}
+	if err := record.Unmarshal(data); err != nil {
+		return nil, err
+	}
+
+	obu := record.SequenceHeaderOBU()
+	if obu == nil {
+		return nil, fmt.Errorf("av1C missing sequence header")
+	}
+
+	seq, err := av1.ParseSequenceHeaderOBU(obu)
+	if err != nil {
+		return nil, err
+	}
+
+	c := AV1CodecData{
+		codecData: codecData{
+			m4vc:   data,
+			width:  seq.Width(),
+			height: seq.Height(),
+		},
+		Record: &record,
+	}
+	return &c, nil
+}
+
+func ParseVPCodecConfigurationRecord(data []byte) (CodecData, error) {
+	record := vp9.VPCodecConfigurationRecord{}
+	if err := record.Unmarshal(data); err != nil {
+		return nil, err
+	}
+
+	c := VPCodecData{
+		codecData: codecData{
+			m4vc: data,
+		},
+		Record: &record,
+	}
+	return &c, nil
+}
+
 func mix(data ...[][]byte) []byte {
 	var extra []byte
 	for _, v := range data {
@@ -193,6 +377,13 @@ func NewHEVCCodecData(vps, sps, pps []byte) (CodecData, error) {
 	recordInfo.VPSList[0] = vps
 	recordInfo.SPSList[0] = sps
 	recordInfo.PPSList[0] = pps
+	if err = recordInfo.UpdateFromParameterSets(vps, sps, pps); err != nil {
+		// VPS或PPS异常时只使用SPS中的信息, 不影响创建track
+		println(err.Error())
+		if err = recordInfo.UpdateFromSPS(sps); err != nil {
+			return nil, err
+		}
+	}
 
 	c := HEVCCodecData{codecData: codecData{
 		annexB: mix(recordInfo.VPSList, recordInfo.SPSList, recordInfo.PPSList),
@@ -204,3 +395,90 @@ func NewHEVCCodecData(vps, sps, pps []byte) (CodecData, error) {
 
 	return &c, nil
 }
+
+// NewVVCCodecData vps可以为nil
+func NewVVCCodecData(vps, sps, pps []byte) (CodecData, error) {
+	spsInfo, err := vvc.ParseSPS(sps)
+	if err != nil {
+		return nil, fmt.Errorf("h266parser: parse SPS failed(%s)", err)
+	}
+
+	recordInfo := vvc.VVCDecoderConfigurationRecord{
+		SPSList: [][]byte{sps},
+		PPSList: [][]byte{pps},
+	}
+
+	if vps != nil {
+		recordInfo.VPSList = [][]byte{vps}
+	}
+	if err = recordInfo.UpdateFromSPS(sps); err != nil {
+		return nil, err
+	}
+
+	c := VVCCodecData{codecData: codecData{
+		annexB: mix(recordInfo.VPSList, recordInfo.SPSList, recordInfo.PPSList),
+		width:  spsInfo.Width,
+		height: spsInfo.Height,
+	},
+		Record: &recordInfo,
+	}
+
+	return &c, nil
+}
+
+// NewAV1CodecData 根据sequence header OBU创建
+func NewAV1CodecData(sequenceHeader []byte) (CodecData, error) {
+	seq, err := av1.ParseSequenceHeaderOBU(sequenceHeader)
+	if err != nil {
+		return nil, fmt.Errorf("av1parser: parse sequence header failed(%s)", err)
+	}
+
+	record := av1.AV1CodecConfigurationRecord{}
+	if err = record.UpdateFromSequenceHeader(sequenceHeader); err != nil {
+		return nil, err
+	}
+
+	c := AV1CodecData{codecData: codecData{
+		width:  seq.Width(),
+		height: seq.Height(),
+	},
+		Record: &record,
+	}
+
+	return &c, nil
+}
+
+// NewVPCodecData 根据VP8或VP9关键帧创建
+func NewVPCodecData(id utils.AVCodecID, keyFrame []byte) (CodecData, error) {
+	record := vp9.VPCodecConfigurationRecord{}
+	if utils.AVCodecIdVP8 == id {
+		header, err := vp8.ParseFrameHeader(keyFrame)
+		if err != nil {
+			return nil, fmt.Errorf("vp8parser: parse frame header failed(%s)", err)
+		} else if !header.KeyFrame {
+			return nil, fmt.Errorf("vp8parser: not a key frame")
+		}
+
+		// VP8只支持8位4:2:0
+		record.Version = 1
+		record.Profile = header.Version
+		record.BitDepth = 8
+		record.ColourPrimaries, record.TransferCharacteristics, record.MatrixCoefficients = 2, 2, 2
+	} else {
+		header, err := vp9.ParseKeyFrameHeader(keyFrame)
+		if err != nil {
+			return nil, fmt.Errorf("vp9parser: parse frame header failed(%s)", err)
+		} else if header.Width == 0 {
+			return nil, fmt.Errorf("vp9parser: frame size not found")
+		}
+
+		record.UpdateFromFrameHeader(&header)
+	}
+
+	c := VPCodecData{Record: &record}
+	if err := c.UpdateFromKeyFrame(id, keyFrame); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
@@ -0,0 +1,257 @@
+package avformat
+
+import (
+	"fmt"
+	"github.com/lkmio/avformat/avc"
+	"github.com/lkmio/avformat/utils"
+	"strconv"
+	"strings"
+)
+
+// CodecInfo RFC6381 codecs参数中的一项, 例如avc1.64001f, hvc1.1.6.L93.B0, av01.0.04M.08, mp4a.40.2
+type CodecInfo struct {
+	CodecID   utils.AVCodecID
+	MediaType utils.AVMediaType
+	Tag       string // avc1/avc3, hvc1/hev1, mp4a...
+
+	// avc: profile_idc, constraint_set flags, level_idc
+	// hevc: general_profile_space, general_profile_idc, 兼容标志, tier, general_level_idc, 约束标志
+	// av1: seq_profile, seq_level_idx_0, seq_tier_0, 位深
+	ProfileSpace  int
+	Profile       int
+	Compatibility uint32
+	Constraints   uint64
+	Tier          int
+	Level         int
+	BitDepth      int
+
+	ObjectType int // mp4a的AudioObjectType
+}
+
+func (c *CodecInfo) String() string {
+	switch c.CodecID {
+	case utils.AVCodecIdH264:
+		return fmt.Sprintf("%s.%02x%02x%02x", c.Tag, c.Profile, c.Constraints, c.Level)
+	case utils.AVCodecIdH265:
+		codecs := c.Tag + "."
+		if c.ProfileSpace > 0 {
+			codecs += string(rune('A' + c.ProfileSpace - 1))
+		}
+
+		tier := 'L'
+		if c.Tier != 0 {
+			tier = 'H'
+		}
+
+		// 兼容标志按位反转, 约束标志6个字节省略末尾的0
+		codecs += fmt.Sprintf("%d.%X.%c%d", c.Profile, reverseBits32(c.Compatibility), tier, c.Level)
+		n := 6
+		for n > 0 && c.Constraints>>(8*(6-n))&0xFF == 0 {
+			n--
+		}
+		for i := 0; i < n; i++ {
+			codecs += fmt.Sprintf(".%X", byte(c.Constraints>>(8*(5-i))))
+		}
+		return codecs
+	case utils.AVCodecIdAV1:
+		tier := 'M'
+		if c.Tier != 0 {
+			tier = 'H'
+		}
+		return fmt.Sprintf("%s.%d.%02d%c.%02d", c.Tag, c.Profile, c.Level, tier, c.BitDepth)
+	case utils.AVCodecIdAAC, utils.AVCodecIdMP3:
+		return fmt.Sprintf("mp4a.40.%d", c.ObjectType)
+	}
+
+	return c.Tag
+}
+
+func reverseBits32(v uint32) uint32 {
+	var r uint32
+	for i := 0; i < 32; i++ {
+		r |= (v >> i & 1) << (31 - i)
+	}
+
+	return r
+}
+
+// CodecInfo 根据编码参数生成RFC6381的codecs信息
+func (s *AVStream) CodecInfo() (*CodecInfo, error) {
+	info := &CodecInfo{CodecID: s.CodecID, MediaType: s.MediaType}
+	switch s.CodecID {
+	case utils.AVCodecIdH264:
+		if s.CodecParameters == nil || len(s.CodecParameters.SPS()) == 0 {
+			return nil, fmt.Errorf("missing h264 sps")
+		}
+
+		sps, err := avc.ParseSPS(s.CodecParameters.SPS()[0])
+		if err != nil {
+			return nil, err
+		}
+
+		// 解析SPS时去掉了reserved_zero_2bits
+		info.Tag, info.Profile, info.Constraints, info.Level = "avc1", int(sps.ProfileIdc), uint64(sps.ConstraintSetFlag<<2), int(sps.LevelIdc)
+	case utils.AVCodecIdH265:
+		codecData, ok := s.CodecParameters.(*HEVCCodecData)
+		if !ok {
+			return nil, fmt.Errorf("missing h265 decoder configuration record")
+		}
+
+		record := codecData.Record
+		info.Tag = "hvc1"
+		info.ProfileSpace = int(record.GeneralProfileSpace)
+		info.Profile = int(record.GeneralProfileIdc)
+		info.Compatibility = record.GeneralProfileCompatibilityFlags
+		info.Constraints = record.GeneralConstraintIndicatorFlags
+		info.Tier = int(record.GeneralTierFlag)
+		info.Level = int(record.GeneralLevelIdc)
+	case utils.AVCodecIdAV1:
+		codecData, ok := s.CodecParameters.(*AV1CodecData)
+		if !ok {
+			return nil, fmt.Errorf("missing av1 codec configuration record")
+		}
+
+		record := codecData.Record
+		info.Tag = "av01"
+		info.Profile, info.Level, info.Tier = int(record.SeqProfile), int(record.SeqLevelIdx0), int(record.SeqTier0)
+		info.BitDepth = 8 + 2*int(record.HighBitdepth) + 2*int(record.TwelveBit)
+	case utils.AVCodecIdAAC:
+		if len(s.Data) < 2 {
+			return nil, fmt.Errorf("missing aac audio specific config")
+		}
+
+		config, err := utils.ParseMpeg4AudioConfig(s.Data)
+		if err != nil {
+			return nil, err
+		}
+		info.Tag, info.ObjectType = "mp4a", config.ObjectType
+	case utils.AVCodecIdMP3:
+		info.Tag, info.ObjectType = "mp4a", 34
+	case utils.AVCodecIdOPUS:
+		info.Tag = "opus"
+	case utils.AVCodecIdAC3:
+		info.Tag = "ac-3"
+	case utils.AVCodecIdEAC3:
+		info.Tag = "ec-3"
+	default:
+		return nil, fmt.Errorf("unsupported codec %s", s.CodecID)
+	}
+
+	return info, nil
+}
+
+// CodecString 返回RFC6381的codecs字符串, 不支持的编码返回空字符串
+func (s *AVStream) CodecString() string {
+	info, err := s.CodecInfo()
+	if err != nil {
+		return ""
+	}
+
+	return info.String()
+}
+
+// ParseCodecString 解析RFC6381的codecs字符串
+func ParseCodecString(codecs string) (*CodecInfo, error) {
+	fields := strings.Split(strings.TrimSpace(codecs), ".")
+	info := &CodecInfo{Tag: fields[0], MediaType: utils.AVMediaTypeVideo}
+	invalid := fmt.Errorf("invalid codecs string %s", codecs)
+
+	switch fields[0] {
+	case "avc1", "avc3":
+		if len(fields) != 2 || len(fields[1]) != 6 {
+			return nil, invalid
+		}
+
+		value, err := strconv.ParseUint(fields[1], 16, 32)
+		if err != nil {
+			return nil, invalid
+		}
+		info.CodecID = utils.AVCodecIdH264
+		info.Profile, info.Constraints, info.Level = int(value>>16), value>>8&0xFF, int(value&0xFF)
+	case "hvc1", "hev1":
+		if len(fields) < 4 || len(fields) > 10 || len(fields[1]) == 0 || len(fields[3]) < 2 {
+			return nil, invalid
+		}
+
+		info.CodecID = utils.AVCodecIdH265
+		profile := fields[1]
+		if profile[0] >= 'A' && profile[0] <= 'C' {
+			info.ProfileSpace = int(profile[0]-'A') + 1
+			profile = profile[1:]
+		}
+
+		var err error
+		if info.Profile, err = strconv.Atoi(profile); err != nil {
+			return nil, invalid
+		}
+
+		compatibility, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil {
+			return nil, invalid
+		}
+		info.Compatibility = reverseBits32(uint32(compatibility))
+
+		if fields[3][0] == 'H' {
+			info.Tier = 1
+		} else if fields[3][0] != 'L' {
+			return nil, invalid
+		}
+		if info.Level, err = strconv.Atoi(fields[3][1:]); err != nil {
+			return nil, invalid
+		}
+
+		for i, field := range fields[4:] {
+			b, err := strconv.ParseUint(field, 16, 8)
+			if err != nil {
+				return nil, invalid
+			}
+			info.Constraints |= b << (8 * (5 - i))
+		}
+	case "av01":
+		// 只解析必选的profile, level, tier和位深
+		if len(fields) < 4 || len(fields[2]) != 3 || (fields[2][2] != 'M' && fields[2][2] != 'H') {
+			return nil, invalid
+		}
+
+		var err error
+		info.CodecID = utils.AVCodecIdAV1
+		if info.Profile, err = strconv.Atoi(fields[1]); err != nil {
+			return nil, invalid
+		} else if info.Level, err = strconv.Atoi(fields[2][:2]); err != nil {
+			return nil, invalid
+		} else if info.BitDepth, err = strconv.Atoi(fields[3]); err != nil {
+			return nil, invalid
+		}
+		if fields[2][2] == 'H' {
+			info.Tier = 1
+		}
+	case "mp4a":
+		info.MediaType = utils.AVMediaTypeAudio
+		if len(fields) == 2 && (strings.EqualFold(fields[1], "6B") || fields[1] == "69") {
+			info.CodecID, info.ObjectType = utils.AVCodecIdMP3, 34
+			break
+		} else if len(fields) != 3 || fields[1] != "40" {
+			return nil, invalid
+		}
+
+		var err error
+		if info.ObjectType, err = strconv.Atoi(fields[2]); err != nil {
+			return nil, invalid
+		}
+
+		info.CodecID = utils.AVCodecIdAAC
+		if info.ObjectType == 34 {
+			info.CodecID = utils.AVCodecIdMP3
+		}
+	case "opus", "Opus":
+		info.CodecID, info.MediaType = utils.AVCodecIdOPUS, utils.AVMediaTypeAudio
+	case "ac-3":
+		info.CodecID, info.MediaType = utils.AVCodecIdAC3, utils.AVMediaTypeAudio
+	case "ec-3":
+		info.CodecID, info.MediaType = utils.AVCodecIdEAC3, utils.AVMediaTypeAudio
+	default:
+		return nil, fmt.Errorf("unsupported codecs string %s", codecs)
+	}
+
+	return info, nil
+}
@@ -0,0 +1,84 @@
+package avformat
+
+import (
+	"encoding/hex"
+	"github.com/lkmio/avformat/hevc"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+func TestCodecString(t *testing.T) {
+	record, _ := hex.DecodeString("0142c01effe100186742c01eda01e0089f961000000300100000030320f162ea01000568ce0f2c80")
+	codecData, err := ParseAVCDecoderConfigurationRecord(record)
+	if err != nil {
+		panic(err)
+	}
+
+	stream := &AVStream{MediaType: utils.AVMediaTypeVideo, CodecID: utils.AVCodecIdH264, CodecParameters: codecData}
+	utils.Assert(stream.CodecString() == "avc1.42c01e")
+
+	// 从AnnexB创建的HEVC也需要PTL
+	record, _ = hex.DecodeString("0101600000009000000000005df000fcfdf8f800000f03a00001001840010c01ffff01600000030090000003000003005d999809a10001002d42010101600000030090000003000003005da00280802d165999a4932b9a808080820000030002000003003210a2000100074401c172b46240")
+	hevcRecord := hevc.HEVCDecoderConfigurationRecord{}
+	if err = hevcRecord.Unmarshal(record); err != nil {
+		panic(err)
+	}
+
+	codecData, err = NewHEVCCodecData(hevcRecord.VPSList[0], hevcRecord.SPSList[0], hevcRecord.PPSList[0])
+	if err != nil {
+		panic(err)
+	}
+
+	stream = &AVStream{MediaType: utils.AVMediaTypeVideo, CodecID: utils.AVCodecIdH265, CodecParameters: codecData}
+	utils.Assert(stream.CodecString() == "hvc1.1.6.L93.90")
+	// 生成的hvcC和原始的PTL一致
+	utils.Assert(hex.EncodeToString(codecData.MP4ExtraData()[:13]) == hex.EncodeToString(record[:13]))
+
+	// PPS引用的参数集不完整, 不影响创建track
+	codecData, err = NewHEVCCodecData(hevcRecord.SPSList[0], hevcRecord.SPSList[0], hevcRecord.PPSList[0])
+	utils.Assert(err == nil && codecData.(*HEVCCodecData).Record.GeneralLevelIdc == 93)
+
+	// AV1 Main 10bit, level 4.0 high tier
+	sequenceHeader, _ := hex.DecodeString("0a170400000fa40003a983000008d577f86e7ffce848804820")
+	codecData, err = NewAV1CodecData(sequenceHeader)
+	if err != nil {
+		panic(err)
+	}
+
+	stream = NewAVStream(utils.AVMediaTypeVideo, 0, utils.AVCodecIdAV1, nil, codecData)
+	utils.Assert(stream.CodecString() == "av01.0.08H.10" && codecData.Width() == 1920 && codecData.Height() == 1080)
+	utils.Assert(stream.BitDepth == 10 && stream.ColorInfo.IsHDR() && stream.FrameRate > 59.9)
+	utils.Assert(hex.EncodeToString(stream.Colors) == hex.EncodeToString(stream.ColorInfo.Marshal()) && stream.Colors[7] == stream.ColorInfo.Transfer)
+	av1Data, err := ParseAV1CodecConfigurationRecord(codecData.MP4ExtraData())
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(hex.EncodeToString(av1Data.SPS()[0]) == hex.EncodeToString(sequenceHeader))
+
+	stream = &AVStream{MediaType: utils.AVMediaTypeAudio, CodecID: utils.AVCodecIdAAC, Data: []byte{0x14, 0x08}}
+	utils.Assert(stream.CodecString() == "mp4a.40.2")
+	stream = &AVStream{MediaType: utils.AVMediaTypeAudio, CodecID: utils.AVCodecIdPCMALAW}
+	utils.Assert(stream.CodecString() == "")
+
+	for _, codecs := range []string{"avc1.64001f", "hvc1.1.6.L93.B0", "hev1.A2.4.H120.90.1", "av01.0.04M.08", "mp4a.40.5", "ec-3"} {
+		info, err := ParseCodecString(codecs)
+		if err != nil {
+			panic(err)
+		}
+		utils.Assert(info.String() == codecs)
+	}
+
+	info, _ := ParseCodecString("avc1.64001f")
+	utils.Assert(info.CodecID == utils.AVCodecIdH264 && info.Profile == 100 && info.Level == 31)
+	info, _ = ParseCodecString("hvc1.2.4.H153.B0")
+	utils.Assert(info.Profile == 2 && info.Compatibility == 0x20000000 && info.Tier == 1 && info.Level == 153 && info.Constraints == 0xB00000000000)
+	info, _ = ParseCodecString("av01.2.19H.12.0.110.01.01.01.0")
+	utils.Assert(info.CodecID == utils.AVCodecIdAV1 && info.Profile == 2 && info.Level == 19 && info.Tier == 1 && info.BitDepth == 12)
+	info, _ = ParseCodecString("mp4a.6B")
+	utils.Assert(info.CodecID == utils.AVCodecIdMP3 && info.MediaType == utils.AVMediaTypeAudio)
+
+	_, err = ParseCodecString("avc1.64")
+	utils.Assert(err != nil)
+	_, err = ParseCodecString("vp09.00.10.08")
+	utils.Assert(err != nil)
+}
@@ -0,0 +1,128 @@
+package dash
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+)
+
+const (
+	mpdNamespace = "urn:mpeg:dash:schema:mpd:2011"
+	liveProfile  = "urn:mpeg:dash:profile:isoff-live:2011"
+
+	audioChannelConfigurationScheme = "urn:mpeg:dash:23003:3:audio_channel_configuration:2011"
+)
+
+// MPD DASH索引文件
+type MPD struct {
+	XMLName                    xml.Name  `xml:"MPD"`
+	Xmlns                      string    `xml:"xmlns,attr"`
+	Profiles                   string    `xml:"profiles,attr"`
+	Type                       string    `xml:"type,attr"`
+	AvailabilityStartTime      string    `xml:"availabilityStartTime,attr,omitempty"`
+	PublishTime                string    `xml:"publishTime,attr,omitempty"`
+	MinimumUpdatePeriod        string    `xml:"minimumUpdatePeriod,attr,omitempty"`
+	TimeShiftBufferDepth       string    `xml:"timeShiftBufferDepth,attr,omitempty"`
+	SuggestedPresentationDelay string    `xml:"suggestedPresentationDelay,attr,omitempty"`
+	MediaPresentationDuration  string    `xml:"mediaPresentationDuration,attr,omitempty"`
+	MinBufferTime              string    `xml:"minBufferTime,attr"`
+	Periods                    []*Period `xml:"Period"`
+}
+
+type Period struct {
+	ID             string           `xml:"id,attr"`
+	Start          string           `xml:"start,attr"`
+	AdaptationSets []*AdaptationSet `xml:"AdaptationSet"`
+}
+
+// AdaptationSet 同一种媒体类型的Representation
+type AdaptationSet struct {
+	ID               int               `xml:"id,attr"`
+	ContentType      string            `xml:"contentType,attr"`
+	MimeType         string            `xml:"mimeType,attr"`
+	SegmentAlignment bool              `xml:"segmentAlignment,attr"`
+	StartWithSAP     int               `xml:"startWithSAP,attr,omitempty"`
+	Representations  []*Representation `xml:"Representation"`
+}
+
+type Representation struct {
+	ID                        string           `xml:"id,attr"`
+	Codecs                    string           `xml:"codecs,attr"`
+	Bandwidth                 int              `xml:"bandwidth,attr"`
+	Width                     int              `xml:"width,attr,omitempty"`
+	Height                    int              `xml:"height,attr,omitempty"`
+	AudioSamplingRate         int              `xml:"audioSamplingRate,attr,omitempty"`
+	AudioChannelConfiguration *Descriptor      `xml:"AudioChannelConfiguration,omitempty"`
+	SegmentTemplate           *SegmentTemplate `xml:"SegmentTemplate"`
+}
+
+type Descriptor struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+}
+
+type SegmentTemplate struct {
+	Timescale              int              `xml:"timescale,attr"`
+	Initialization         string           `xml:"initialization,attr"`
+	Media                  string           `xml:"media,attr"`
+	StartNumber            int              `xml:"startNumber,attr,omitempty"`
+	PresentationTimeOffset int64            `xml:"presentationTimeOffset,attr,omitempty"`
+	SegmentTimeline        *SegmentTimeline `xml:"SegmentTimeline"`
+}
+
+type SegmentTimeline struct {
+	S []*S `xml:"S"`
+}
+
+// S 时间线中的一组切片, T为空时接着上一组, R为重复次数
+type S struct {
+	T *int64 `xml:"t,attr"`
+	D int64  `xml:"d,attr"`
+	R int    `xml:"r,attr,omitempty"`
+}
+
+func (m *MPD) Marshal() ([]byte, error) {
+	data, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+func ParseMPD(data []byte) (*MPD, error) {
+	mpd := &MPD{}
+	if err := xml.Unmarshal(data, mpd); err != nil {
+		return nil, err
+	}
+
+	return mpd, nil
+}
+
+// 格式化为xs:duration
+func formatDuration(duration time.Duration) string {
+	return fmt.Sprintf("PT%.3fS", duration.Seconds())
+}
+
+// 时间线, 连续且时长相同的切片合并为一组
+func newSegmentTimeline(segments []*segment) *SegmentTimeline {
+	timeline := &SegmentTimeline{}
+	var last *S
+	var end int64
+	for i, seg := range segments {
+		if last != nil && seg.time == end && seg.duration == last.D {
+			last.R++
+		} else {
+			last = &S{D: seg.duration}
+			if i == 0 || seg.time != end {
+				t := seg.time
+				last.T = &t
+			}
+			timeline.S = append(timeline.S, last)
+		}
+
+		end = seg.time + seg.duration
+	}
+
+	return timeline
+}
@@ -0,0 +1,403 @@
+package dash
+
+import (
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/fmp4"
+	"github.com/lkmio/avformat/hls"
+	"github.com/lkmio/avformat/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Template int
+
+const (
+	TemplateNumber = Template(iota) // $Number$命名切片
+	TemplateTime                    // $Time$命名切片
+)
+
+const (
+	DefaultSegmentDuration = 4 * time.Second
+	DefaultWindowSize      = 5
+
+	// 移出索引的切片保留的数量, 避免播放器还在下载时被删除
+	expiredSegments = 2
+)
+
+type segment struct {
+	number   int
+	time     int64 // 时间基为timescale
+	duration int64
+	size     int
+}
+
+// 每个track单独封装为CMAF
+type representation struct {
+	id        string
+	stream    *avformat.AVStream
+	muxer     *fmp4.Muxer
+	timescale int
+	codecs    string
+
+	started  bool
+	start    int64 // 当前切片的开始时间
+	end      int64
+	segments []*segment
+	expired  []*segment
+}
+
+// Packager 实现OnUnpackStreamHandler, 在关键帧处按目标时长切片, 生成动态MPD
+type Packager struct {
+	mutex           sync.Mutex
+	storage         hls.Storage
+	name            string
+	segmentDuration time.Duration
+	windowSize      int
+	template        Template
+
+	tracks          avformat.TrackManager
+	representations []*representation // track索引对应的Representation, 不支持的编码为nil
+	hasVideo        bool
+
+	started               bool
+	segmentStart          int64 // 当前切片的开始时间, 毫秒
+	baseDts               int64 // 第一个切片的开始时间, 毫秒, 所有Representation的presentationTimeOffset
+	number                int
+	availabilityStartTime time.Time
+
+	completed bool
+	closed    bool
+}
+
+// SetSegmentDuration 设置切片的目标时长, 有视频时只在关键帧处切片
+func (p *Packager) SetSegmentDuration(duration time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.segmentDuration = duration
+}
+
+// SetWindowSize 设置MPD时间线中的切片数量
+func (p *Packager) SetWindowSize(size int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.windowSize = size
+}
+
+// SetTemplate 设置切片使用$Number$还是$Time$命名
+func (p *Packager) SetTemplate(template Template) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.template = template
+}
+
+// SetName 设置MPD文件名(不含扩展名), 也作为切片文件名的前缀
+func (p *Packager) SetName(name string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.name = name
+}
+
+// ManifestName 返回MPD文件名
+func (p *Packager) ManifestName() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.name + ".mpd"
+}
+
+func (p *Packager) initTemplate() string {
+	return p.name + "_$RepresentationID$_init.mp4"
+}
+
+func (p *Packager) mediaTemplate() string {
+	if TemplateTime == p.template {
+		return p.name + "_$RepresentationID$_$Time$.m4s"
+	}
+
+	return p.name + "_$RepresentationID$_$Number$.m4s"
+}
+
+func (p *Packager) segmentName(r *representation, seg *segment) string {
+	name := strings.ReplaceAll(p.mediaTemplate(), "$RepresentationID$", r.id)
+	name = strings.ReplaceAll(name, "$Number$", strconv.Itoa(seg.number))
+	return strings.ReplaceAll(name, "$Time$", strconv.FormatInt(seg.time, 10))
+}
+
+func (p *Packager) OnNewTrack(track avformat.Track) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.completed {
+		p.tracks.Add(track)
+	}
+}
+
+func (p *Packager) OnTrackComplete() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.completed || p.closed {
+		return
+	}
+
+	p.completed = true
+	var supported int
+	for _, track := range p.tracks.Tracks {
+		stream := track.GetStream()
+		muxer := fmp4.NewMuxer()
+		index, err := muxer.AddTrack(stream)
+		if err != nil {
+			println(err.Error())
+			p.representations = append(p.representations, nil)
+			continue
+		}
+
+		r := &representation{
+			id:        strconv.Itoa(stream.Index),
+			stream:    stream,
+			muxer:     muxer,
+			timescale: muxer.Timescale(index),
+			codecs:    stream.CodecString(),
+		}
+
+		name := strings.ReplaceAll(p.initTemplate(), "$RepresentationID$", r.id)
+		if err = p.storage.Put(name, muxer.AppendInitSegment(nil)); err != nil {
+			println(err.Error())
+		}
+
+		supported++
+		p.hasVideo = p.hasVideo || utils.AVMediaTypeVideo == stream.MediaType
+		p.representations = append(p.representations, r)
+	}
+
+	if supported == 0 {
+		println("no track supported by dash")
+		p.closed = true
+	}
+}
+
+func (p *Packager) OnTrackNotFind() {
+	p.Close()
+}
+
+func (p *Packager) OnPacket(packet *avformat.AVPacket) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.completed || p.closed || packet.Index >= len(p.representations) || p.representations[packet.Index] == nil {
+		return
+	}
+
+	dts := packet.ConvertDts(1000)
+	key := !p.hasVideo || (utils.AVMediaTypeVideo == packet.MediaType && packet.Key)
+	if p.started && key && dts-p.segmentStart >= p.segmentDuration.Milliseconds() {
+		p.flush()
+	}
+
+	if !p.started {
+		if !key {
+			return
+		}
+
+		p.started = true
+		p.segmentStart = dts
+		if p.availabilityStartTime.IsZero() {
+			p.availabilityStartTime = time.Now()
+			p.baseDts = dts
+		}
+	}
+
+	r := p.representations[packet.Index]
+	if err := r.muxer.AddPacket(0, packet); err != nil {
+		println(err.Error())
+		return
+	}
+
+	dts = packet.ConvertDts(r.timescale)
+	if !r.started {
+		r.started = true
+		r.start = dts
+	}
+
+	if end := dts + packet.GetDuration(r.timescale); end > r.end {
+		r.end = end
+	}
+}
+
+// flush 结束所有Representation的当前切片
+func (p *Packager) flush() {
+	if !p.started {
+		return
+	}
+
+	p.started = false
+	for _, r := range p.representations {
+		if r == nil || !r.started {
+			continue
+		}
+
+		r.started = false
+		seg := &segment{number: p.number, time: r.start, duration: r.end - r.start}
+		data := r.muxer.AppendFragment(nil)
+		seg.size = len(data)
+		if err := p.storage.Put(p.segmentName(r, seg), data); err != nil {
+			println(err.Error())
+			continue
+		}
+
+		r.segments = append(r.segments, seg)
+		if len(r.segments) > p.windowSize {
+			r.expired = append(r.expired, r.segments[0])
+			r.segments = r.segments[1:]
+		}
+
+		for len(r.expired) > expiredSegments {
+			if err := p.storage.Delete(p.segmentName(r, r.expired[0])); err != nil {
+				println(err.Error())
+			}
+			r.expired = r.expired[1:]
+		}
+	}
+
+	p.number++
+	p.writeManifest(false)
+}
+
+func (p *Packager) writeManifest(static bool) {
+	if err := p.storage.Put(p.name+".mpd", p.manifest(static)); err != nil {
+		println(err.Error())
+	}
+}
+
+// 生成MPD, static为true时表示直播已经结束
+func (p *Packager) manifest(static bool) []byte {
+	mpd := &MPD{
+		Xmlns:         mpdNamespace,
+		Profiles:      liveProfile,
+		Type:          "dynamic",
+		MinBufferTime: formatDuration(p.segmentDuration),
+	}
+
+	period := &Period{ID: "0", Start: formatDuration(0)}
+	var duration time.Duration
+	adaptationSets := make(map[utils.AVMediaType]*AdaptationSet)
+	for _, r := range p.representations {
+		if r == nil || len(r.segments) == 0 {
+			continue
+		}
+
+		adaptationSet, ok := adaptationSets[r.stream.MediaType]
+		if !ok {
+			adaptationSet = &AdaptationSet{ID: len(period.AdaptationSets), SegmentAlignment: true, StartWithSAP: 1}
+			if utils.AVMediaTypeVideo == r.stream.MediaType {
+				adaptationSet.ContentType, adaptationSet.MimeType = "video", "video/mp4"
+			} else {
+				adaptationSet.ContentType, adaptationSet.MimeType = "audio", "audio/mp4"
+			}
+
+			adaptationSets[r.stream.MediaType] = adaptationSet
+			period.AdaptationSets = append(period.AdaptationSets, adaptationSet)
+		}
+
+		adaptationSet.Representations = append(adaptationSet.Representations, p.representation(r))
+
+		last := r.segments[len(r.segments)-1]
+		if end := time.Duration(avformat.ConvertTs(last.time+last.duration, r.timescale, 1000)-p.baseDts) * time.Millisecond; end > duration {
+			duration = end
+		}
+	}
+
+	mpd.Periods = append(mpd.Periods, period)
+	if static {
+		mpd.Type = "static"
+		mpd.MediaPresentationDuration = formatDuration(duration)
+	} else {
+		mpd.AvailabilityStartTime = p.availabilityStartTime.UTC().Format(time.RFC3339)
+		mpd.PublishTime = time.Now().UTC().Format(time.RFC3339)
+		mpd.MinimumUpdatePeriod = formatDuration(p.segmentDuration)
+		mpd.TimeShiftBufferDepth = formatDuration(p.segmentDuration * time.Duration(p.windowSize))
+		mpd.SuggestedPresentationDelay = formatDuration(p.segmentDuration * 2)
+	}
+
+	data, err := mpd.Marshal()
+	if err != nil {
+		println(err.Error())
+	}
+
+	return data
+}
+
+func (p *Packager) representation(r *representation) *Representation {
+	// 码率按照窗口内的切片计算
+	var size, duration int64
+	for _, seg := range r.segments {
+		size += int64(seg.size)
+		duration += seg.duration
+	}
+
+	representation := &Representation{
+		ID:        r.id,
+		Codecs:    r.codecs,
+		Bandwidth: 1,
+		SegmentTemplate: &SegmentTemplate{
+			Timescale:              r.timescale,
+			Initialization:         p.initTemplate(),
+			Media:                  p.mediaTemplate(),
+			PresentationTimeOffset: avformat.ConvertTs(p.baseDts, 1000, r.timescale),
+			SegmentTimeline:        newSegmentTimeline(r.segments),
+		},
+	}
+
+	if duration > 0 {
+		representation.Bandwidth = int(size * 8 * int64(r.timescale) / duration)
+	}
+
+	if TemplateNumber == p.template {
+		representation.SegmentTemplate.StartNumber = r.segments[0].number
+	}
+
+	if utils.AVMediaTypeVideo == r.stream.MediaType && r.stream.CodecParameters != nil {
+		representation.Width = r.stream.CodecParameters.Width()
+		representation.Height = r.stream.CodecParameters.Height()
+	} else if utils.AVMediaTypeAudio == r.stream.MediaType {
+		representation.AudioSamplingRate = r.timescale
+		if r.stream.Channels > 0 {
+			representation.AudioChannelConfiguration = &Descriptor{SchemeIDURI: audioChannelConfigurationScheme, Value: strconv.Itoa(r.stream.Channels)}
+		}
+	}
+
+	return representation
+}
+
+// Manifest 返回当前的MPD
+func (p *Packager) Manifest() []byte {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.manifest(p.closed)
+}
+
+// Close 写入最后一个切片, MPD改为static
+func (p *Packager) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	p.flush()
+	p.closed = true
+	if p.completed {
+		p.writeManifest(true)
+	}
+}
+
+func NewPackager(storage hls.Storage) *Packager {
+	return &Packager{
+		storage:         storage,
+		name:            "manifest",
+		segmentDuration: DefaultSegmentDuration,
+		windowSize:      DefaultWindowSize,
+	}
+}
@@ -0,0 +1,102 @@
+package dash
+
+import (
+	"encoding/hex"
+	"github.com/lkmio/avformat/flv"
+	"github.com/lkmio/avformat/hls"
+	"github.com/lkmio/avformat/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 输入音视频, 视频1秒一个GOP, 时间戳为帧序号*40ms, 音频每64ms一帧
+func inputTestSource(packager *Packager, frames int) {
+	record, _ := hex.DecodeString("0142c01effe100186742c01eda01e0089f961000000300100000030320f162ea01000568ce0f2c80")
+	demuxer := flv.NewDemuxer()
+	demuxer.SetHandler(packager)
+	_ = demuxer.InputVideo(append([]byte{0x17, 0, 0, 0, 0}, record...), 0)
+	_ = demuxer.InputAudio([]byte{0xAF, 0x00, 0x14, 0x08}, 0)
+
+	var audioTs int
+	for i := 0; i < frames; i++ {
+		for ; audioTs <= i*40; audioTs += 64 {
+			if err := demuxer.InputAudio([]byte{0xAF, 0x01, 0x21, 0x10}, uint32(audioTs)); err != nil {
+				panic(err)
+			}
+		}
+
+		frame := []byte{0x27, 1, 0, 0, 0, 0, 0, 0, 2, 0x41, 0x9a}
+		if i%25 == 0 {
+			frame = []byte{0x17, 1, 0, 0, 0, 0, 0, 0, 2, 0x65, 0x88}
+		}
+
+		if err := demuxer.InputVideo(frame, uint32(i*40)); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestPackager(t *testing.T) {
+	storage := hls.NewMemoryStorage()
+	packager := NewPackager(storage)
+	packager.SetSegmentDuration(time.Second)
+	packager.SetWindowSize(3)
+	packager.SetName("live")
+
+	// 完成6个切片
+	inputTestSource(packager, 175)
+	data, ok := storage.Get(packager.ManifestName())
+	utils.Assert(ok)
+	mpd, err := ParseMPD(data)
+	if err != nil {
+		panic(err)
+	}
+
+	utils.Assert(mpd.Type == "dynamic" && mpd.AvailabilityStartTime != "" && mpd.MinimumUpdatePeriod == "PT1.000S" && mpd.TimeShiftBufferDepth == "PT3.000S")
+	utils.Assert(len(mpd.Periods) == 1 && len(mpd.Periods[0].AdaptationSets) == 2)
+
+	video := mpd.Periods[0].AdaptationSets[0]
+	utils.Assert(video.ContentType == "video" && video.MimeType == "video/mp4" && len(video.Representations) == 1)
+	representation := video.Representations[0]
+	utils.Assert(representation.Codecs == "avc1.42c01e" && representation.Width > 0 && representation.Bandwidth > 1)
+
+	template := representation.SegmentTemplate
+	utils.Assert(template.Timescale == 90000 && template.StartNumber == 3 && template.Media == "live_$RepresentationID$_$Number$.m4s")
+	// 窗口内3个相同时长的切片合并为一组
+	timeline := template.SegmentTimeline.S
+	utils.Assert(len(timeline) == 1 && *timeline[0].T == 3*90000 && timeline[0].D == 90000 && timeline[0].R == 2)
+
+	audio := mpd.Periods[0].AdaptationSets[1].Representations[0]
+	utils.Assert(audio.Codecs == "mp4a.40.2" && audio.AudioSamplingRate == 16000 && audio.AudioChannelConfiguration.Value == "1")
+	utils.Assert(audio.SegmentTemplate.Timescale == 16000 && len(audio.SegmentTemplate.SegmentTimeline.S) > 0)
+
+	// 保留移出窗口的最近2个切片
+	_, ok = storage.Get("live_0_0.m4s")
+	utils.Assert(!ok)
+	segment, ok := storage.Get("live_0_1.m4s")
+	utils.Assert(ok && string(segment[4:8]) == "moof")
+	init, ok := storage.Get("live_1_init.mp4")
+	utils.Assert(ok && strings.Contains(string(init), "mp4a"))
+
+	packager.Close()
+	data, _ = storage.Get("live.mpd")
+	mpd, _ = ParseMPD(data)
+	utils.Assert(mpd.Type == "static" && mpd.MediaPresentationDuration != "" && mpd.AvailabilityStartTime == "")
+}
+
+func TestPackagerTimeTemplate(t *testing.T) {
+	storage := hls.NewMemoryStorage()
+	packager := NewPackager(storage)
+	packager.SetSegmentDuration(time.Second)
+	packager.SetTemplate(TemplateTime)
+	inputTestSource(packager, 100)
+
+	data, _ := storage.Get("manifest.mpd")
+	mpd, _ := ParseMPD(data)
+	template := mpd.Periods[0].AdaptationSets[0].Representations[0].SegmentTemplate
+	utils.Assert(template.StartNumber == 0 && template.Media == "manifest_$RepresentationID$_$Time$.m4s")
+
+	_, ok := storage.Get("manifest_0_90000.m4s")
+	utils.Assert(ok)
+}
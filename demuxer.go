@@ -38,6 +38,8 @@ type BaseDemuxer struct {
 	AutoFree                bool                                 // 回调Packet后, 是否自动释放Packet
 	streamIndex2BufferIndex map[int]int
 	onPreprocessPacket      func(packet *AVPacket)
+	timestampGenerators     map[int]*TimestampGenerator // 为缺少DTS或PTS的视频帧生成时间戳
+	timestampErrors         map[int]error               // 每个track第一次生成时间戳失败的原因
 }
 
 func (s *BaseDemuxer) Input(data []byte) error {
@@ -53,7 +55,7 @@ func (s *BaseDemuxer) GetTimebase() int {
 	switch s.Name {
 	case "flv", "jt1078":
 		return 1000
-	case "ps", "ts":
+	case "ps", "ts", "rtp":
 		return 90000
 	default:
 		panic(fmt.Sprintf("unknown demuxer name: %s", s.Name))
@@ -63,9 +65,9 @@ func (s *BaseDemuxer) GetTimebase() int {
 func (s *BaseDemuxer) GetPackType() PacketType {
 
 	switch s.Name {
-	case "flv":
+	case "flv", "mp4":
 		return PacketTypeAVCC
-	case "ps", "ts", "jt1078":
+	case "ps", "ts", "jt1078", "rtp":
 		return PacketTypeAnnexB
 	default:
 		return PacketTypeNONE
@@ -239,7 +241,17 @@ func (s *BaseDemuxer) OnVideoPacket(bufferIndex int, id utils.AVCodecID, data []
 		return
 	}
 
+	// vpcC不携带宽高, 从关键帧中获取
+	if codecData, isVP := track.GetStream().CodecParameters.(*VPCodecData); isVP && key && codecData.Width() == 0 {
+		if err := codecData.UpdateFromKeyFrame(id, data); err != nil {
+			println(err.Error())
+		}
+	}
+
 	packet := NewVideoPacket(data, dts, pts, key, s.GetPackType(), id, track.GetStream().Index, track.GetStream().Timebase)
+	if int64(DTSUndefined) == dts || int64(PTSUndefined) == pts {
+		s.generateTimestamp(track.GetStream(), packet)
+	}
 
 	ok = true
 	packet.BufferIndex = bufferIndex
@@ -249,6 +261,52 @@ func (s *BaseDemuxer) OnVideoPacket(bufferIndex int, id utils.AVCodecID, data []
 	s.processBufferedPacket(packet)
 }
 
+// 根据POC补全缺失的DTS或PTS, 无法解析时使用另一个时间戳
+func (s *BaseDemuxer) generateTimestamp(stream *AVStream, packet *AVPacket) {
+	if utils.AVCodecIdH264 == stream.CodecID || utils.AVCodecIdH265 == stream.CodecID {
+		generator, ok := s.timestampGenerators[stream.Index]
+		if !ok {
+			generator = NewTimestampGenerator(stream.CodecID, stream.Timebase)
+			if stream.CodecParameters != nil {
+				_ = generator.SetExtraData(stream.CodecParameters.AnnexBExtraData())
+			}
+
+			switch codecData := stream.CodecParameters.(type) {
+			case *AVCCodecData:
+				generator.SetLengthSize(int(codecData.Record.LengthSizeMinusOne) + 1)
+			case *HEVCCodecData:
+				// hvcC解析时已经加1
+				generator.SetLengthSize(int(codecData.Record.LengthSizeMinusOne))
+			}
+
+			if s.timestampGenerators == nil {
+				s.timestampGenerators = make(map[int]*TimestampGenerator)
+			}
+			s.timestampGenerators[stream.Index] = generator
+		}
+
+		err := generator.Generate(packet)
+		if err == nil {
+			return
+		}
+
+		// 每个track只打印第一次的错误, 避免刷屏
+		if _, reported := s.timestampErrors[stream.Index]; !reported {
+			if s.timestampErrors == nil {
+				s.timestampErrors = make(map[int]error)
+			}
+			s.timestampErrors[stream.Index] = err
+			println(fmt.Sprintf("generate timestamp failed, track %d: %s", stream.Index, err.Error()))
+		}
+	}
+
+	if int64(DTSUndefined) == packet.Dts {
+		packet.Dts = packet.Pts
+	} else if int64(PTSUndefined) == packet.Pts {
+		packet.Pts = packet.Dts
+	}
+}
+
 // 回调AVPacket, 如果没有完成探测, 则保存到Packets中, 否则回调处理
 // 保证回调的顺序是OnNewTrack...->OnTrackComplete->OnPacket...
 func (s *BaseDemuxer) processBufferedPacket(packet *AVPacket) {
@@ -268,9 +326,11 @@ func (s *BaseDemuxer) processBufferedPacket(packet *AVPacket) {
 		return
 	}
 
-	// 计算上一个AVPacket的duration
+	// 计算上一个AVPacket的duration, 已经根据码流计算出时长的保持不变
 	prevPacket := packets.Get(prevPacketIndex)
-	prevPacket.Duration = packet.Dts - prevPacket.Dts
+	if prevPacket.Duration == 0 {
+		prevPacket.Duration = packet.Dts - prevPacket.Dts
+	}
 
 	// 如果已经完成探测, 则回调处理
 	if s.Completed {
@@ -0,0 +1,255 @@
+package flv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/utils"
+)
+
+// Demuxer 解析FLV tag, 可以直接输入RTMP的音视频消息, 也可以输入FLV文件/HTTP-FLV的字节流
+type Demuxer struct {
+	avformat.BaseDemuxer
+	headerParsed   bool
+	expectedTracks int // 根据FLV头或onMetaData得到的track数量, 全部解析后不再探测
+	metaData       *MetaData
+}
+
+// MetaData 返回最近一次解析的onMetaData, 没有时为nil
+func (d *Demuxer) MetaData() *MetaData {
+	return d.metaData
+}
+
+// Input 输入FLV字节流, 返回已经解析的长度, 剩余不完整的tag需要和后续数据一起输入
+func (d *Demuxer) Input(data []byte) (int, error) {
+	var n int
+	if !d.headerParsed {
+		if len(data) < HeaderSize+4 {
+			return 0, nil
+		} else if !bytes.Equal(data[:3], []byte("FLV")) {
+			return 0, fmt.Errorf("invalid flv header")
+		}
+
+		if data[4]&0x04 != 0 {
+			d.expectedTracks++
+		}
+		if data[4]&0x01 != 0 {
+			d.expectedTracks++
+		}
+
+		// 跳过头和PreviousTagSize0
+		n = int(binary.BigEndian.Uint32(data[5:])) + 4
+		if n > len(data) {
+			return 0, nil
+		}
+		d.headerParsed = true
+	}
+
+	header := TagHeader{}
+	for len(data)-n >= TagHeaderSize {
+		_ = header.Unmarshal(data[n:])
+		end := n + TagHeaderSize + header.DataSize + 4
+		if end > len(data) {
+			break
+		}
+
+		if err := d.InputTag(header.Type, data[n+TagHeaderSize:end-4], header.Timestamp); err != nil {
+			println(err.Error())
+		}
+
+		n = end
+	}
+
+	return n, nil
+}
+
+// InputTag 输入一个tag的body, ts单位毫秒
+func (d *Demuxer) InputTag(tagType byte, data []byte, ts uint32) error {
+	switch tagType {
+	case TagTypeVideo:
+		return d.InputVideo(data, ts)
+	case TagTypeAudio:
+		return d.InputAudio(data, ts)
+	case TagTypeScript:
+		return d.InputScript(data)
+	default:
+		return nil
+	}
+}
+
+// InputScript 解析onMetaData, 没有FLV头时(RTMP)根据hasVideo/hasAudio确定track数量
+func (d *Demuxer) InputScript(data []byte) error {
+	metaData, err := ParseMetaData(data)
+	if err != nil {
+		return err
+	}
+
+	d.metaData = metaData
+	if d.headerParsed {
+		return nil
+	}
+
+	d.expectedTracks = 0
+	if metaData.HasVideo {
+		d.expectedTracks++
+	}
+	if metaData.HasAudio {
+		d.expectedTracks++
+	}
+
+	d.checkTracks()
+	return nil
+}
+
+func (d *Demuxer) InputVideo(data []byte, ts uint32) error {
+	if len(data) < 1 {
+		return fmt.Errorf("empty flv video tag")
+	} else if data[0]&0x80 != 0 {
+		return d.inputExVideo(data, ts)
+	}
+
+	frameType, codec := data[0]>>4&0x7, int(data[0]&0xF)
+	var id utils.AVCodecID
+	switch codec {
+	case VideoCodecAVC:
+		id = utils.AVCodecIdH264
+	case VideoCodecHEVC:
+		id = utils.AVCodecIdH265
+	default:
+		return fmt.Errorf("unsupported flv video codec %d", codec)
+	}
+
+	if len(data) < 5 {
+		return fmt.Errorf("invalid flv video tag size %d", len(data))
+	}
+
+	return d.onVideo(id, data[1], data[5:], frameType == FrameTypeKey, ts, readInt24(data[2:]))
+}
+
+// Enhanced RTMP视频tag, 1字节头+FourCC
+func (d *Demuxer) inputExVideo(data []byte, ts uint32) error {
+	if len(data) < 5 {
+		return fmt.Errorf("invalid flv video tag size %d", len(data))
+	}
+
+	frameType, packetType := data[0]>>4&0x7, data[0]&0xF
+	var fourCC [4]byte
+	copy(fourCC[:], data[1:5])
+
+	var id utils.AVCodecID
+	switch fourCC {
+	case FourCCHEVC:
+		id = utils.AVCodecIdH265
+	case FourCCAV1:
+		id = utils.AVCodecIdAV1
+	case FourCCVP9:
+		id = utils.AVCodecIdVP9
+	default:
+		return fmt.Errorf("unsupported flv video fourcc %s", string(fourCC[:]))
+	}
+
+	payload := data[5:]
+	switch packetType {
+	case PacketTypeSequenceStart:
+		return d.onVideo(id, AVCPacketTypeSequenceHeader, payload, true, ts, 0)
+	case PacketTypeCodedFrames:
+		// 只有HEVC携带cts
+		if utils.AVCodecIdH265 != id {
+			return d.onVideo(id, AVCPacketTypeNALU, payload, frameType == FrameTypeKey, ts, 0)
+		} else if len(payload) < 3 {
+			return fmt.Errorf("invalid flv video tag size %d", len(data))
+		}
+		return d.onVideo(id, AVCPacketTypeNALU, payload[3:], frameType == FrameTypeKey, ts, readInt24(payload))
+	case PacketTypeCodedFramesX:
+		return d.onVideo(id, AVCPacketTypeNALU, payload, frameType == FrameTypeKey, ts, 0)
+	default:
+		return nil
+	}
+}
+
+func (d *Demuxer) onVideo(id utils.AVCodecID, packetType byte, payload []byte, key bool, ts uint32, cts int32) error {
+	if len(payload) == 0 {
+		return nil
+	}
+
+	bufferIndex := d.FindBufferIndexByMediaType(utils.AVMediaTypeVideo)
+	switch packetType {
+	case AVCPacketTypeSequenceHeader:
+		_, _ = d.DataPipeline.Write(payload, bufferIndex, utils.AVMediaTypeVideo)
+		extraData, _ := d.DataPipeline.Feat(bufferIndex)
+		d.OnNewVideoTrack(bufferIndex, id, 1000, extraData)
+		d.checkTracks()
+	case AVCPacketTypeNALU:
+		_, _ = d.DataPipeline.Write(payload, bufferIndex, utils.AVMediaTypeVideo)
+		data, _ := d.DataPipeline.Feat(bufferIndex)
+		dts := int64(ts)
+		d.OnVideoPacket(bufferIndex, id, data, key, dts, dts+int64(cts), avformat.PacketTypeAVCC)
+	}
+
+	return nil
+}
+
+func (d *Demuxer) InputAudio(data []byte, ts uint32) error {
+	if len(data) < 2 {
+		return fmt.Errorf("invalid flv audio tag size %d", len(data))
+	}
+
+	var id utils.AVCodecID
+	switch format := data[0] >> 4; format {
+	case SoundFormatAAC:
+		id = utils.AVCodecIdAAC
+	case SoundFormatG711A:
+		id = utils.AVCodecIdPCMALAW
+	case SoundFormatG711U:
+		id = utils.AVCodecIdPCMMULAW
+	case SoundFormatMP3:
+		id = utils.AVCodecIdMP3
+	default:
+		return fmt.Errorf("unsupported flv sound format %d", format)
+	}
+
+	bufferIndex := d.FindBufferIndexByMediaType(utils.AVMediaTypeAudio)
+	payload := data[1:]
+	if utils.AVCodecIdAAC == id {
+		payload = data[2:]
+		if data[1] == AACPacketTypeSequenceHeader {
+			// AudioSpecificConfig至少2个字节
+			if len(payload) < 2 {
+				return fmt.Errorf("invalid aac sequence header size %d", len(payload))
+			}
+
+			_, _ = d.DataPipeline.Write(payload, bufferIndex, utils.AVMediaTypeAudio)
+			extraData, _ := d.DataPipeline.Feat(bufferIndex)
+			d.OnNewAudioTrack(bufferIndex, id, 1000, extraData, avformat.AudioConfig{})
+			d.checkTracks()
+			return nil
+		}
+	}
+
+	if len(payload) == 0 {
+		return nil
+	}
+
+	_, _ = d.DataPipeline.Write(payload, bufferIndex, utils.AVMediaTypeAudio)
+	frame, _ := d.DataPipeline.Feat(bufferIndex)
+	d.OnAudioPacket(bufferIndex, id, frame, int64(ts))
+	return nil
+}
+
+// 已知track数量时, 全部解析后立即结束探测
+func (d *Demuxer) checkTracks() {
+	if d.expectedTracks > 0 && !d.Completed && d.Tracks.Size() >= d.expectedTracks {
+		d.ProbeComplete()
+	}
+}
+
+func NewDemuxer() *Demuxer {
+	return &Demuxer{
+		BaseDemuxer: avformat.BaseDemuxer{
+			DataPipeline: &avformat.StreamsBuffer{},
+			Name:         "flv",
+			AutoFree:     true,
+		},
+	}
+}
@@ -0,0 +1,91 @@
+package flv
+
+import (
+	"encoding/hex"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+type testHandler struct {
+	tracks   []avformat.Track
+	complete bool
+	packets  []*avformat.AVPacket
+}
+
+func (h *testHandler) OnNewTrack(track avformat.Track) {
+	h.tracks = append(h.tracks, track)
+}
+
+func (h *testHandler) OnTrackComplete() {
+	h.complete = true
+}
+
+func (h *testHandler) OnTrackNotFind() {
+}
+
+func (h *testHandler) OnPacket(packet *avformat.AVPacket) {
+	h.packets = append(h.packets, &avformat.AVPacket{Data: append([]byte{}, packet.Data...), Dts: packet.Dts, Pts: packet.Pts, Key: packet.Key, MediaType: packet.MediaType})
+}
+
+func appendTag(dst []byte, tagType byte, ts uint32, body []byte) []byte {
+	header := TagHeader{Type: tagType, DataSize: len(body), Timestamp: ts}
+	tag := make([]byte, TagHeaderSize)
+	header.MarshalTo(tag)
+	dst = append(append(dst, tag...), body...)
+	size := len(body) + TagHeaderSize
+	return append(dst, byte(size>>24), byte(size>>16), byte(size>>8), byte(size))
+}
+
+func TestDemuxer(t *testing.T) {
+	record, _ := hex.DecodeString("0142c01effe100186742c01eda01e0089f961000000300100000030320f162ea01000568ce0f2c80")
+	data := []byte{'F', 'L', 'V', 1, 0x05, 0, 0, 0, 9, 0, 0, 0, 0}
+	script, err := (&MetaData{Width: 640, Height: 480, VideoCodecID: VideoCodecAVC, HasVideo: true, HasAudio: true}).Marshal()
+	if err != nil {
+		panic(err)
+	}
+	data = appendTag(data, TagTypeScript, 0, script)
+	data = appendTag(data, TagTypeVideo, 0, append([]byte{0x17, 0, 0, 0, 0}, record...))
+	data = appendTag(data, TagTypeAudio, 0, []byte{0xAF, 0, 0x12, 0x10})
+	for i := 0; i < 3; i++ {
+		data = appendTag(data, TagTypeVideo, uint32(i*40), []byte{0x17, 1, 0, 0, 40, 0, 0, 0, 2, 0x65, byte(i)})
+		data = appendTag(data, TagTypeAudio, uint32(i*23), []byte{0xAF, 1, byte(i)})
+	}
+
+	handler := &testHandler{}
+	demuxer := NewDemuxer()
+	demuxer.SetHandler(handler)
+
+	// 分段输入
+	var buffer []byte
+	for i := 0; i < len(data); i += 7 {
+		end := i + 7
+		if end > len(data) {
+			end = len(data)
+		}
+
+		buffer = append(buffer, data[i:end]...)
+		n, err := demuxer.Input(buffer)
+		if err != nil {
+			panic(err)
+		}
+		buffer = buffer[n:]
+	}
+
+	// FLV头标记了音视频, 两个sequence header后立即完成探测
+	utils.Assert(handler.complete && len(handler.tracks) == 2)
+	utils.Assert(utils.AVCodecIdAAC == handler.tracks[1].GetStream().CodecID)
+	utils.Assert(len(handler.packets) == 4)
+	metaData := demuxer.MetaData()
+	utils.Assert(metaData != nil && metaData.Width == 640 && metaData.Height == 480 && metaData.VideoCodecID == VideoCodecAVC && metaData.HasAudio)
+	for _, packet := range handler.packets {
+		if utils.AVMediaTypeVideo == packet.MediaType {
+			utils.Assert(packet.Key && packet.Pts-packet.Dts == 40 && packet.Data[4] == 0x65)
+		}
+	}
+
+	// 空的AAC sequence header
+	demuxer = NewDemuxer()
+	demuxer.SetHandler(&testHandler{})
+	utils.Assert(demuxer.InputAudio([]byte{0xAF, 0}, 0) != nil)
+}
@@ -0,0 +1,316 @@
+package flv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/utils"
+)
+
+const (
+	TagTypeAudio  = 8
+	TagTypeVideo  = 9
+	TagTypeScript = 18
+
+	HeaderSize    = 9
+	TagHeaderSize = 11
+
+	// 视频帧类型
+	FrameTypeKey   = 1
+	FrameTypeInter = 2
+
+	// 视频编码
+	VideoCodecAVC  = 7
+	VideoCodecHEVC = 12 // 非标准, 国内通用
+
+	// AVCPacketType
+	AVCPacketTypeSequenceHeader = 0
+	AVCPacketTypeNALU           = 1
+	AVCPacketTypeEndOfSequence  = 2
+
+	// 音频编码
+	SoundFormatMP3      = 2
+	SoundFormatG711A    = 7
+	SoundFormatG711U    = 8
+	SoundFormatAAC      = 10
+	SoundFormatExHeader = 9 // Enhanced RTMP
+
+	// AACPacketType
+	AACPacketTypeSequenceHeader = 0
+	AACPacketTypeRaw            = 1
+
+	// Enhanced RTMP视频PacketType
+	PacketTypeSequenceStart = 0
+	PacketTypeCodedFrames   = 1
+	PacketTypeSequenceEnd   = 2
+	PacketTypeCodedFramesX  = 3 // 没有cts
+)
+
+// Enhanced RTMP的FourCC
+var (
+	FourCCHEVC = [4]byte{'h', 'v', 'c', '1'}
+	FourCCAV1  = [4]byte{'a', 'v', '0', '1'}
+	FourCCVP9  = [4]byte{'v', 'p', '0', '9'}
+)
+
+// TagHeader FLV tag头
+type TagHeader struct {
+	Type      byte
+	DataSize  int
+	Timestamp uint32 // 包含扩展的高8位
+	StreamID  uint32
+}
+
+func (t *TagHeader) Unmarshal(data []byte) error {
+	if len(data) < TagHeaderSize {
+		return fmt.Errorf("flv tag header too short")
+	}
+
+	t.Type = data[0] & 0x1F
+	t.DataSize = int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	t.Timestamp = uint32(data[4])<<16 | uint32(data[5])<<8 | uint32(data[6]) | uint32(data[7])<<24
+	t.StreamID = uint32(data[8])<<16 | uint32(data[9])<<8 | uint32(data[10])
+	return nil
+}
+
+func (t *TagHeader) MarshalTo(dst []byte) int {
+	dst[0] = t.Type
+	dst[1], dst[2], dst[3] = byte(t.DataSize>>16), byte(t.DataSize>>8), byte(t.DataSize)
+	dst[4], dst[5], dst[6], dst[7] = byte(t.Timestamp>>16), byte(t.Timestamp>>8), byte(t.Timestamp), byte(t.Timestamp>>24)
+	dst[8], dst[9], dst[10] = byte(t.StreamID>>16), byte(t.StreamID>>8), byte(t.StreamID)
+	return TagHeaderSize
+}
+
+// AppendHeader 写入FLV头和第一个PreviousTagSize
+func AppendHeader(dst []byte, hasVideo, hasAudio bool) []byte {
+	var flags byte
+	if hasAudio {
+		flags |= 0x04
+	}
+	if hasVideo {
+		flags |= 0x01
+	}
+
+	return append(dst, 'F', 'L', 'V', 1, flags, 0, 0, 0, HeaderSize, 0, 0, 0, 0)
+}
+
+// AppendTag 写入tag头, tag body和PreviousTagSize
+func AppendTag(dst []byte, tagType byte, timestamp uint32, body []byte) []byte {
+	header := TagHeader{Type: tagType, DataSize: len(body), Timestamp: timestamp}
+	var bytes [TagHeaderSize]byte
+	header.MarshalTo(bytes[:])
+	dst = append(dst, bytes[:]...)
+	dst = append(dst, body...)
+	return binary.BigEndian.AppendUint32(dst, uint32(TagHeaderSize+len(body)))
+}
+
+// VideoCodecID 返回FLV的视频编码, 不支持的返回-1
+func VideoCodecID(id utils.AVCodecID) int {
+	switch id {
+	case utils.AVCodecIdH264:
+		return VideoCodecAVC
+	case utils.AVCodecIdH265:
+		return VideoCodecHEVC
+	default:
+		return -1
+	}
+}
+
+// VideoFourCC 返回Enhanced RTMP的FourCC, 不支持的返回false
+func VideoFourCC(id utils.AVCodecID) ([4]byte, bool) {
+	switch id {
+	case utils.AVCodecIdH265:
+		return FourCCHEVC, true
+	case utils.AVCodecIdAV1:
+		return FourCCAV1, true
+	case utils.AVCodecIdVP9:
+		return FourCCVP9, true
+	default:
+		return [4]byte{}, false
+	}
+}
+
+// SoundFormat 返回FLV的音频编码, 不支持的返回-1
+func SoundFormat(id utils.AVCodecID) int {
+	switch id {
+	case utils.AVCodecIdAAC:
+		return SoundFormatAAC
+	case utils.AVCodecIdPCMALAW:
+		return SoundFormatG711A
+	case utils.AVCodecIdPCMMULAW:
+		return SoundFormatG711U
+	case utils.AVCodecIdMP3:
+		return SoundFormatMP3
+	default:
+		return -1
+	}
+}
+
+// 音频tag的第一个字节, AAC固定为44100/16位/立体声
+func audioTagHeader(stream *avformat.AVStream) (byte, error) {
+	format := SoundFormat(stream.CodecID)
+	if format < 0 {
+		return 0, fmt.Errorf("unsupported flv audio codec %s", stream.CodecID)
+	}
+
+	// SoundRate: 0-5.5k 1-11k 2-22k 3-44k, SoundSize 1-16bit, SoundType 0-mono 1-stereo
+	header := byte(format)<<4 | 1<<1
+	if SoundFormatAAC == format {
+		return header | 3<<2 | 1, nil
+	}
+
+	switch {
+	case stream.SampleRate >= 44100:
+		header |= 3 << 2
+	case stream.SampleRate >= 22050:
+		header |= 2 << 2
+	case stream.SampleRate >= 11025:
+		header |= 1 << 2
+	}
+
+	if stream.Channels > 1 {
+		header |= 1
+	}
+
+	return header, nil
+}
+
+// NewVideoSequenceHeader 生成视频sequence header tag body
+func NewVideoSequenceHeader(stream *avformat.AVStream) ([]byte, error) {
+	codec := VideoCodecID(stream.CodecID)
+	if codec < 0 {
+		return nil, fmt.Errorf("unsupported flv video codec %s", stream.CodecID)
+	} else if stream.CodecParameters == nil {
+		return nil, fmt.Errorf("not find video codec parameters")
+	}
+
+	record := stream.CodecParameters.MP4ExtraData()
+	if len(record) == 0 {
+		return nil, fmt.Errorf("invalid video decoder configuration record")
+	}
+
+	body := []byte{FrameTypeKey<<4 | byte(codec), AVCPacketTypeSequenceHeader, 0, 0, 0}
+	return append(body, record...), nil
+}
+
+// NewExVideoSequenceHeader 生成Enhanced RTMP视频sequence header tag body, 没有编码器参数时使用AVStream.Data
+func NewExVideoSequenceHeader(stream *avformat.AVStream) ([]byte, error) {
+	fourCC, ok := VideoFourCC(stream.CodecID)
+	if !ok {
+		return nil, fmt.Errorf("unsupported enhanced flv video codec %s", stream.CodecID)
+	}
+
+	record := stream.Data
+	if stream.CodecParameters != nil {
+		record = stream.CodecParameters.MP4ExtraData()
+	}
+
+	if len(record) == 0 {
+		return nil, fmt.Errorf("invalid video decoder configuration record")
+	}
+
+	body := append([]byte{0x80 | FrameTypeKey<<4 | PacketTypeSequenceStart}, fourCC[:]...)
+	return append(body, record...), nil
+}
+
+// NewAudioSequenceHeader 生成AAC sequence header tag body, 其他编码没有sequence header, 返回nil
+func NewAudioSequenceHeader(stream *avformat.AVStream) ([]byte, error) {
+	if utils.AVCodecIdAAC != stream.CodecID {
+		return nil, nil
+	} else if len(stream.Data) < 2 {
+		return nil, fmt.Errorf("not find aac audio specific config")
+	}
+
+	header, _ := audioTagHeader(stream)
+	return append([]byte{header, AACPacketTypeSequenceHeader}, stream.Data...), nil
+}
+
+// AppendVideoTagBody 追加视频tag body, data为AVCC格式, cts单位毫秒
+func AppendVideoTagBody(dst []byte, id utils.AVCodecID, data []byte, key bool, cts int32) ([]byte, error) {
+	codec := VideoCodecID(id)
+	if codec < 0 {
+		return nil, fmt.Errorf("unsupported flv video codec %s", id)
+	}
+
+	frameType := byte(FrameTypeInter)
+	if key {
+		frameType = FrameTypeKey
+	}
+
+	dst = append(dst, frameType<<4|byte(codec), AVCPacketTypeNALU, byte(cts>>16), byte(cts>>8), byte(cts))
+	return append(dst, data...), nil
+}
+
+// AppendExVideoTagBody 追加Enhanced RTMP视频tag body, 只有HEVC携带cts, cts为0时使用CodedFramesX
+func AppendExVideoTagBody(dst []byte, id utils.AVCodecID, data []byte, key bool, cts int32) ([]byte, error) {
+	fourCC, ok := VideoFourCC(id)
+	if !ok {
+		return nil, fmt.Errorf("unsupported enhanced flv video codec %s", id)
+	}
+
+	frameType := byte(FrameTypeInter)
+	if key {
+		frameType = FrameTypeKey
+	}
+
+	if utils.AVCodecIdH265 == id && cts == 0 {
+		dst = append(append(dst, 0x80|frameType<<4|PacketTypeCodedFramesX), fourCC[:]...)
+	} else {
+		dst = append(append(dst, 0x80|frameType<<4|PacketTypeCodedFrames), fourCC[:]...)
+		if utils.AVCodecIdH265 == id {
+			dst = append(dst, byte(cts>>16), byte(cts>>8), byte(cts))
+		}
+	}
+
+	return append(dst, data...), nil
+}
+
+// AppendAudioTagBody 追加音频tag body, AAC数据不能包含ADTS头
+func AppendAudioTagBody(dst []byte, stream *avformat.AVStream, data []byte) ([]byte, error) {
+	header, err := audioTagHeader(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	dst = append(dst, header)
+	if utils.AVCodecIdAAC == stream.CodecID {
+		dst = append(dst, AACPacketTypeRaw)
+	}
+
+	return append(dst, data...), nil
+}
+
+// NewPacketTagBody 将AVPacket转换为tag body, 视频转为AVCC, AAC去掉ADTS头
+func NewPacketTagBody(stream *avformat.AVStream, packet *avformat.AVPacket) ([]byte, error) {
+	if utils.AVMediaTypeVideo == packet.MediaType {
+		data := avformat.AnnexBPacket2AVCC(packet)
+		cts := packet.ConvertPts(1000) - packet.ConvertDts(1000)
+		return AppendVideoTagBody(make([]byte, 0, len(data)+5), packet.CodecID, data, packet.Key, int32(cts))
+	}
+
+	data := packet.Data
+	if utils.AVCodecIdAAC == packet.CodecID && stream.HasADTSHeader && len(data) > 7 {
+		header, err := utils.ReadADtsFixedHeader(data)
+		if err != nil {
+			return nil, err
+		} else if header.ProtectionAbsent() == 0 {
+			data = data[9:]
+		} else {
+			data = data[7:]
+		}
+	}
+
+	return AppendAudioTagBody(make([]byte, 0, len(data)+2), stream, data)
+}
+
+// NewExVideoTagBody 将视频AVPacket转换为Enhanced RTMP tag body
+func NewExVideoTagBody(packet *avformat.AVPacket) ([]byte, error) {
+	data := avformat.AnnexBPacket2AVCC(packet)
+	cts := packet.ConvertPts(1000) - packet.ConvertDts(1000)
+	return AppendExVideoTagBody(make([]byte, 0, len(data)+8), packet.CodecID, data, packet.Key, int32(cts))
+}
+
+// 读取24位有符号数
+func readInt24(data []byte) int32 {
+	return int32(binary.BigEndian.Uint32([]byte{0, data[0], data[1], data[2]})<<8) >> 8
+}
@@ -0,0 +1,178 @@
+package flv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/amf"
+	"github.com/lkmio/avformat/utils"
+)
+
+// MetaData onMetaData中常用的属性, 编码ID为FLV的编码ID, Enhanced RTMP为FourCC的数值
+type MetaData struct {
+	Duration        float64
+	FileSize        float64
+	Width           float64
+	Height          float64
+	FrameRate       float64
+	VideoDataRate   float64
+	VideoCodecID    float64
+	AudioDataRate   float64
+	AudioCodecID    float64
+	AudioSampleRate float64
+	AudioSampleSize float64
+	Stereo          bool
+	HasVideo        bool
+	HasAudio        bool
+	Encoder         string
+	Properties      amf.Object // 解析时保存所有属性
+}
+
+// FourCCValue 返回FourCC的数值, 用于onMetaData的编码ID
+func FourCCValue(fourCC [4]byte) float64 {
+	return float64(binary.BigEndian.Uint32(fourCC[:]))
+}
+
+// NewMetaData 根据AVStream生成onMetaData, enhanced为true时HEVC/AV1/VP9使用FourCC
+func NewMetaData(streams []*avformat.AVStream, enhanced bool) *MetaData {
+	metaData := &MetaData{Encoder: "avformat"}
+	for _, stream := range streams {
+		if utils.AVMediaTypeVideo == stream.MediaType {
+			metaData.HasVideo = true
+			if fourCC, ok := VideoFourCC(stream.CodecID); ok && (enhanced || VideoCodecID(stream.CodecID) < 0) {
+				metaData.VideoCodecID = FourCCValue(fourCC)
+			} else if codec := VideoCodecID(stream.CodecID); codec >= 0 {
+				metaData.VideoCodecID = float64(codec)
+			}
+
+			if stream.CodecParameters != nil {
+				metaData.Width = float64(stream.CodecParameters.Width())
+				metaData.Height = float64(stream.CodecParameters.Height())
+			}
+
+			metaData.FrameRate = stream.FrameRate
+		} else if utils.AVMediaTypeAudio == stream.MediaType {
+			metaData.HasAudio = true
+			if format := SoundFormat(stream.CodecID); format >= 0 {
+				metaData.AudioCodecID = float64(format)
+			}
+
+			metaData.AudioSampleRate = float64(stream.SampleRate)
+			metaData.AudioSampleSize = 16
+			metaData.Stereo = stream.Channels > 1
+		}
+	}
+
+	return metaData
+}
+
+// ECMAArray 返回onMetaData的属性, 值为0的数字不输出
+func (m *MetaData) ECMAArray() amf.ECMAArray {
+	var array amf.Object
+	numbers := []amf.Property{
+		{Key: "duration", Value: m.Duration},
+		{Key: "filesize", Value: m.FileSize},
+		{Key: "width", Value: m.Width},
+		{Key: "height", Value: m.Height},
+		{Key: "framerate", Value: m.FrameRate},
+		{Key: "videodatarate", Value: m.VideoDataRate},
+		{Key: "videocodecid", Value: m.VideoCodecID},
+		{Key: "audiodatarate", Value: m.AudioDataRate},
+		{Key: "audiocodecid", Value: m.AudioCodecID},
+		{Key: "audiosamplerate", Value: m.AudioSampleRate},
+		{Key: "audiosamplesize", Value: m.AudioSampleSize},
+	}
+
+	for _, property := range numbers {
+		if property.Value.(float64) != 0 {
+			array = append(array, property)
+		}
+	}
+
+	if m.HasAudio {
+		array.Set("stereo", m.Stereo)
+	}
+
+	array.Set("hasVideo", m.HasVideo)
+	array.Set("hasAudio", m.HasAudio)
+	if m.Encoder != "" {
+		array.Set("encoder", m.Encoder)
+	}
+
+	return amf.ECMAArray(array)
+}
+
+// Marshal 生成script tag body: "onMetaData" + ECMAArray
+func (m *MetaData) Marshal() ([]byte, error) {
+	return amf.Encode("onMetaData", m.ECMAArray())
+}
+
+// ParseMetaData 解析script tag body或RTMP的@setDataFrame消息
+func ParseMetaData(data []byte) (*MetaData, error) {
+	values, err := amf.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(values) > 0 && values[0] == "@setDataFrame" {
+		values = values[1:]
+	}
+
+	if len(values) < 2 || values[0] != "onMetaData" {
+		return nil, fmt.Errorf("not find onMetaData")
+	}
+
+	var properties amf.Object
+	switch v := values[1].(type) {
+	case amf.ECMAArray:
+		properties = amf.Object(v)
+	case amf.Object:
+		properties = v
+	default:
+		return nil, fmt.Errorf("invalid onMetaData type %T", values[1])
+	}
+
+	metaData := &MetaData{
+		Duration:        properties.GetNumber("duration"),
+		FileSize:        properties.GetNumber("filesize"),
+		Width:           properties.GetNumber("width"),
+		Height:          properties.GetNumber("height"),
+		FrameRate:       properties.GetNumber("framerate"),
+		VideoDataRate:   properties.GetNumber("videodatarate"),
+		VideoCodecID:    codecIDValue(properties, "videocodecid"),
+		AudioDataRate:   properties.GetNumber("audiodatarate"),
+		AudioCodecID:    codecIDValue(properties, "audiocodecid"),
+		AudioSampleRate: properties.GetNumber("audiosamplerate"),
+		AudioSampleSize: properties.GetNumber("audiosamplesize"),
+		Stereo:          properties.GetBool("stereo"),
+		Encoder:         properties.GetString("encoder"),
+		Properties:      properties,
+	}
+
+	// 没有hasVideo/hasAudio时根据编码ID判断
+	if _, ok := properties.Get("hasVideo"); ok {
+		metaData.HasVideo = properties.GetBool("hasVideo")
+	} else {
+		metaData.HasVideo = metaData.VideoCodecID != 0
+	}
+
+	if _, ok := properties.Get("hasAudio"); ok {
+		metaData.HasAudio = properties.GetBool("hasAudio")
+	} else {
+		_, ok = properties.Get("audiocodecid")
+		metaData.HasAudio = ok
+	}
+
+	return metaData, nil
+}
+
+// 编码ID可能是数字或者FourCC字符串
+func codecIDValue(properties amf.Object, key string) float64 {
+	if str := properties.GetString(key); len(str) == 4 {
+		var fourCC [4]byte
+		copy(fourCC[:], str)
+		return FourCCValue(fourCC)
+	}
+
+	return properties.GetNumber(key)
+}
@@ -0,0 +1,45 @@
+package fmp4
+
+import (
+	"encoding/binary"
+)
+
+// 开始写入box, 返回box的起始位置, 写完内容后调用endBox填充大小
+func beginBox(dst []byte, boxType string) ([]byte, int) {
+	return append(dst, 0, 0, 0, 0, boxType[0], boxType[1], boxType[2], boxType[3]), len(dst)
+}
+
+// 开始写入full box
+func beginFullBox(dst []byte, boxType string, version byte, flags uint32) ([]byte, int) {
+	dst, offset := beginBox(dst, boxType)
+	return append(dst, version, byte(flags>>16), byte(flags>>8), byte(flags)), offset
+}
+
+func endBox(dst []byte, offset int) []byte {
+	binary.BigEndian.PutUint32(dst[offset:], uint32(len(dst)-offset))
+	return dst
+}
+
+func appendUint16(dst []byte, v uint16) []byte {
+	return binary.BigEndian.AppendUint16(dst, v)
+}
+
+func appendUint32(dst []byte, v uint32) []byte {
+	return binary.BigEndian.AppendUint32(dst, v)
+}
+
+func appendUint64(dst []byte, v uint64) []byte {
+	return binary.BigEndian.AppendUint64(dst, v)
+}
+
+// 单位矩阵
+var matrix = []byte{
+	0x00, 0x01, 0x00, 0x00, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0x00, 0x01, 0x00, 0x00, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0x40, 0x00, 0x00, 0x00,
+}
+
+// 写入MPEG-4描述符的tag和长度, 长度固定使用4字节
+func appendDescriptor(dst []byte, tag byte, size int) []byte {
+	return append(dst, tag, 0x80|byte(size>>21)&0x7F, 0x80|byte(size>>14)&0x7F, 0x80|byte(size>>7)&0x7F, byte(size)&0x7F)
+}
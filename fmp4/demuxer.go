@@ -0,0 +1,503 @@
+package fmp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/opus"
+	"github.com/lkmio/avformat/utils"
+)
+
+type demuxTrack struct {
+	id          uint32
+	codecID     utils.AVCodecID
+	mediaType   utils.AVMediaType
+	timescale   int
+	extraData   []byte
+	bufferIndex int
+	nextDts     int64 // 没有tfdt时使用
+	offset      int64 // 不连续产生的偏移
+
+	discontinuity bool
+
+	// trex/tfhd中的默认值
+	defaultDuration uint32
+	defaultSize     uint32
+	defaultFlags    uint32
+}
+
+// Demuxer 解析fMP4的init segment和fragment, 视频输出AVCC格式
+type Demuxer struct {
+	avformat.BaseDemuxer
+	tracks map[uint32]*demuxTrack
+}
+
+// Input 输入fMP4字节流, 返回已经解析的长度, moof需要和mdat一起输入
+func (d *Demuxer) Input(data []byte) (int, error) {
+	var n int
+	for len(data)-n >= 8 {
+		size, boxType := readBoxHeader(data[n:])
+		if size < 8 {
+			return n, fmt.Errorf("invalid mp4 box size %d", size)
+		} else if n+size > len(data) {
+			break
+		}
+
+		box := data[n : n+size]
+		switch boxType {
+		case "moov":
+			if err := d.parseMoov(box[8:]); err != nil {
+				return n, err
+			}
+		case "moof":
+			// 等待后面的mdat
+			if n+size+8 > len(data) {
+				return n, nil
+			}
+
+			mdatSize, mdatType := readBoxHeader(data[n+size:])
+			if "mdat" != mdatType {
+				return n, fmt.Errorf("moof not followed by mdat")
+			} else if n+size+mdatSize > len(data) {
+				return n, nil
+			}
+
+			if err := d.parseMoof(data[n : n+size+mdatSize]); err != nil {
+				println(err.Error())
+			}
+			size += mdatSize
+		}
+
+		n += size
+	}
+
+	return n, nil
+}
+
+func readBoxHeader(data []byte) (int, string) {
+	return int(binary.BigEndian.Uint32(data)), string(data[4:8])
+}
+
+// 遍历子box
+func forEachBox(data []byte, fn func(boxType string, body []byte) error) error {
+	for len(data) >= 8 {
+		size, boxType := readBoxHeader(data)
+		if size < 8 || size > len(data) {
+			return fmt.Errorf("invalid mp4 box %s size %d", boxType, size)
+		}
+
+		if err := fn(boxType, data[8:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+
+	return nil
+}
+
+// 查找子box
+func findBox(data []byte, path ...string) []byte {
+	for _, name := range path {
+		var found []byte
+		_ = forEachBox(data, func(boxType string, body []byte) error {
+			if found == nil && boxType == name {
+				found = body
+			}
+			return nil
+		})
+
+		if found == nil {
+			return nil
+		}
+		data = found
+	}
+
+	return data
+}
+
+func (d *Demuxer) parseMoov(data []byte) error {
+	// 多次收到init segment时只解析第一次
+	if d.Completed {
+		return nil
+	}
+
+	err := forEachBox(data, func(boxType string, body []byte) error {
+		switch boxType {
+		case "trak":
+			return d.parseTrak(body)
+		case "mvex":
+			return forEachBox(body, func(boxType string, trex []byte) error {
+				if "trex" == boxType && len(trex) >= 24 {
+					if track, ok := d.tracks[binary.BigEndian.Uint32(trex[4:])]; ok {
+						track.defaultDuration = binary.BigEndian.Uint32(trex[12:])
+						track.defaultSize = binary.BigEndian.Uint32(trex[16:])
+						track.defaultFlags = binary.BigEndian.Uint32(trex[20:])
+					}
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, track := range d.sortedTracks() {
+		track.bufferIndex = d.FindBufferIndex(int(track.id))
+		if utils.AVMediaTypeVideo == track.mediaType {
+			_, _ = d.DataPipeline.Write(track.extraData, track.bufferIndex, track.mediaType)
+			extraData, _ := d.DataPipeline.Feat(track.bufferIndex)
+			d.OnNewVideoTrack(track.bufferIndex, track.codecID, track.timescale, extraData)
+		} else {
+			_, _ = d.DataPipeline.Write(track.extraData, track.bufferIndex, track.mediaType)
+			extraData, _ := d.DataPipeline.Feat(track.bufferIndex)
+			d.OnNewAudioTrack(track.bufferIndex, track.codecID, track.timescale, extraData, avformat.AudioConfig{})
+		}
+	}
+
+	// init segment包含所有track
+	d.ProbeComplete()
+	return nil
+}
+
+// 按track id排序
+func (d *Demuxer) sortedTracks() []*demuxTrack {
+	var tracks []*demuxTrack
+	for _, track := range d.tracks {
+		i := len(tracks)
+		tracks = append(tracks, track)
+		for ; i > 0 && tracks[i-1].id > track.id; i-- {
+			tracks[i] = tracks[i-1]
+		}
+		tracks[i] = track
+	}
+
+	return tracks
+}
+
+func (d *Demuxer) parseTrak(data []byte) error {
+	tkhd := findBox(data, "tkhd")
+	mdhd := findBox(data, "mdia", "mdhd")
+	stsd := findBox(data, "mdia", "minf", "stbl", "stsd")
+	if tkhd == nil || mdhd == nil || len(stsd) < 16 {
+		return fmt.Errorf("invalid mp4 trak")
+	}
+
+	// version 1的track_ID和timescale位于偏移20, version 0位于偏移12
+	offset := 12
+	if len(tkhd) > 0 && 1 == tkhd[0] {
+		offset = 20
+	}
+	if len(tkhd) < offset+4 || len(mdhd) < offset+4 {
+		return fmt.Errorf("invalid mp4 tkhd size %d or mdhd size %d", len(tkhd), len(mdhd))
+	}
+
+	track := &demuxTrack{}
+	track.id = binary.BigEndian.Uint32(tkhd[offset:])
+	track.timescale = int(binary.BigEndian.Uint32(mdhd[offset:]))
+
+	// 只解析第一个sample entry
+	_, entryType := readBoxHeader(stsd[8:])
+	entry := stsd[16:]
+	// VisualSampleEntry固定字段78字节, AudioSampleEntry固定字段28字节
+	switch entryType {
+	case "avc1", "avc3", "hvc1", "hev1", "av01", "vp08", "vp09":
+		if len(entry) < 78 {
+			return fmt.Errorf("invalid mp4 sample entry %s size %d", entryType, len(entry))
+		}
+	case "mp4a", "Opus", "ac-3", "ec-3":
+		if len(entry) < 28 {
+			return fmt.Errorf("invalid mp4 sample entry %s size %d", entryType, len(entry))
+		}
+	}
+
+	switch entryType {
+	case "avc1", "avc3":
+		track.codecID, track.mediaType = utils.AVCodecIdH264, utils.AVMediaTypeVideo
+		track.extraData = findBox(entry[78:], "avcC")
+	case "hvc1", "hev1":
+		track.codecID, track.mediaType = utils.AVCodecIdH265, utils.AVMediaTypeVideo
+		track.extraData = findBox(entry[78:], "hvcC")
+	case "av01":
+		track.codecID, track.mediaType = utils.AVCodecIdAV1, utils.AVMediaTypeVideo
+		track.extraData = findBox(entry[78:], "av1C")
+	case "vp08":
+		track.codecID, track.mediaType = utils.AVCodecIdVP8, utils.AVMediaTypeVideo
+		track.extraData = findBox(entry[78:], "vpcC")
+	case "vp09":
+		track.codecID, track.mediaType = utils.AVCodecIdVP9, utils.AVMediaTypeVideo
+		track.extraData = findBox(entry[78:], "vpcC")
+	case "mp4a":
+		track.codecID, track.mediaType = utils.AVCodecIdAAC, utils.AVMediaTypeAudio
+		track.extraData = parseESDS(findBox(entry[28:], "esds"))
+	case "Opus":
+		// 转为OpusHead, 与其他封装格式保持一致
+		track.codecID, track.mediaType = utils.AVCodecIdOPUS, utils.AVMediaTypeAudio
+		if head, err := opus.ParseDOps(findBox(entry[28:], "dOps")); err == nil {
+			track.extraData = head.Marshal()
+		}
+	case "ac-3":
+		track.codecID, track.mediaType = utils.AVCodecIdAC3, utils.AVMediaTypeAudio
+		track.extraData = findBox(entry[28:], "dac3")
+	case "ec-3":
+		track.codecID, track.mediaType = utils.AVCodecIdEAC3, utils.AVMediaTypeAudio
+		track.extraData = findBox(entry[28:], "dec3")
+	default:
+		println(fmt.Sprintf("unsupported mp4 sample entry %s", entryType))
+		return nil
+	}
+
+	if track.extraData == nil {
+		return fmt.Errorf("missing %s decoder config", entryType)
+	}
+
+	d.tracks[track.id] = track
+	return nil
+}
+
+// 从esds中读取AudioSpecificConfig
+func parseESDS(data []byte) []byte {
+	if len(data) < 4 {
+		return nil
+	}
+
+	data = data[4:]
+	for len(data) > 2 {
+		tag := data[0]
+		var size, i int
+		for i = 1; i < 5 && i < len(data); i++ {
+			size = size<<7 | int(data[i]&0x7F)
+			if data[i]&0x80 == 0 {
+				break
+			}
+		}
+
+		if i >= len(data) {
+			return nil
+		}
+
+		body := data[i+1:]
+		switch tag {
+		case 0x03:
+			// ES_ID和flags
+			if len(body) < 3 {
+				return nil
+			}
+			flags := body[2]
+			body = body[3:]
+			if flags&0x80 != 0 {
+				if len(body) < 2 {
+					return nil
+				}
+				body = body[2:]
+			}
+			if flags&0x40 != 0 && len(body) > 0 {
+				if 1+int(body[0]) > len(body) {
+					return nil
+				}
+				body = body[1+int(body[0]):]
+			}
+			if flags&0x20 != 0 {
+				if len(body) < 2 {
+					return nil
+				}
+				body = body[2:]
+			}
+			data = body
+		case 0x04:
+			if len(body) < 13 {
+				return nil
+			}
+			data = body[13:]
+		case 0x05:
+			if size > len(body) {
+				return nil
+			}
+			return body[:size]
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// 解析moof和其后的mdat, data从moof开始
+func (d *Demuxer) parseMoof(data []byte) error {
+	moofSize, _ := readBoxHeader(data)
+	return forEachBox(data[8:moofSize], func(boxType string, body []byte) error {
+		if "traf" == boxType {
+			return d.parseTraf(body, data)
+		}
+		return nil
+	})
+}
+
+func (d *Demuxer) parseTraf(traf []byte, fragment []byte) error {
+	tfhd := findBox(traf, "tfhd")
+	if len(tfhd) < 8 {
+		return fmt.Errorf("invalid tfhd")
+	}
+
+	track, ok := d.tracks[binary.BigEndian.Uint32(tfhd[4:])]
+	if !ok {
+		return nil
+	}
+
+	flags := uint32(tfhd[1])<<16 | uint32(tfhd[2])<<8 | uint32(tfhd[3])
+	defaultDuration, defaultSize, defaultFlags := track.defaultDuration, track.defaultSize, track.defaultFlags
+	offset := 8
+	readField := func() uint32 {
+		if offset+4 > len(tfhd) {
+			return 0
+		}
+		v := binary.BigEndian.Uint32(tfhd[offset:])
+		offset += 4
+		return v
+	}
+
+	// base-data-offset为文件内的绝对位置, 忽略, 只支持相对于moof
+	if flags&0x01 != 0 {
+		offset += 8
+	}
+	if flags&0x02 != 0 {
+		readField()
+	}
+	if flags&0x08 != 0 {
+		defaultDuration = readField()
+	}
+	if flags&0x10 != 0 {
+		defaultSize = readField()
+	}
+	if flags&0x20 != 0 {
+		defaultFlags = readField()
+	}
+
+	dts := track.nextDts
+	if tfdt := findBox(traf, "tfdt"); len(tfdt) >= 8 {
+		if tfdt[0] == 1 && len(tfdt) >= 12 {
+			dts = int64(binary.BigEndian.Uint64(tfdt[4:]))
+		} else {
+			dts = int64(binary.BigEndian.Uint32(tfdt[4:]))
+		}
+
+		// 不连续时接着上一帧的时间戳
+		if track.discontinuity {
+			track.offset = track.nextDts - dts
+		}
+		dts += track.offset
+	}
+	track.discontinuity = false
+
+	return forEachBox(traf, func(boxType string, trun []byte) error {
+		if "trun" != boxType {
+			return nil
+		} else if len(trun) < 8 {
+			return fmt.Errorf("invalid trun")
+		}
+
+		version := trun[0]
+		flags := uint32(trun[1])<<16 | uint32(trun[2])<<8 | uint32(trun[3])
+		count := int(binary.BigEndian.Uint32(trun[4:]))
+		position := 8
+		read := func() uint32 {
+			v := binary.BigEndian.Uint32(trun[position:])
+			position += 4
+			return v
+		}
+
+		fields := 0
+		for _, flag := range []uint32{0x100, 0x200, 0x400, 0x800} {
+			if flags&flag != 0 {
+				fields++
+			}
+		}
+
+		need := 8 + count*fields*4
+		if flags&0x01 != 0 {
+			need += 4
+		}
+		if flags&0x04 != 0 {
+			need += 4
+		}
+		if need > len(trun) {
+			return fmt.Errorf("invalid trun sample count %d", count)
+		}
+
+		var dataOffset int
+		if flags&0x01 != 0 {
+			dataOffset += int(int32(read()))
+		}
+
+		firstFlags := defaultFlags
+		hasFirstFlags := flags&0x04 != 0
+		if hasFirstFlags {
+			firstFlags = read()
+		}
+
+		for i := 0; i < count; i++ {
+			duration, size, sampleFlags := defaultDuration, defaultSize, defaultFlags
+			var cts int64
+			if flags&0x100 != 0 {
+				duration = read()
+			}
+			if flags&0x200 != 0 {
+				size = read()
+			}
+			if flags&0x400 != 0 {
+				sampleFlags = read()
+			} else if i == 0 && hasFirstFlags {
+				sampleFlags = firstFlags
+			}
+			if flags&0x800 != 0 {
+				if version == 0 {
+					cts = int64(read())
+				} else {
+					cts = int64(int32(read()))
+				}
+			}
+
+			if dataOffset < 0 || dataOffset+int(size) > len(fragment) {
+				return fmt.Errorf("mp4 sample out of range")
+			}
+
+			sample := fragment[dataOffset : dataOffset+int(size)]
+			dataOffset += int(size)
+			d.onSample(track, sample, dts, dts+cts, sampleFlags&0x10000 == 0)
+			dts += int64(duration)
+		}
+
+		track.nextDts = dts
+		return nil
+	})
+}
+
+func (d *Demuxer) onSample(track *demuxTrack, data []byte, dts, pts int64, key bool) {
+	_, _ = d.DataPipeline.Write(data, track.bufferIndex, track.mediaType)
+	frame, _ := d.DataPipeline.Feat(track.bufferIndex)
+	if utils.AVMediaTypeVideo == track.mediaType {
+		d.OnVideoPacket(track.bufferIndex, track.codecID, frame, key, dts, pts, avformat.PacketTypeAVCC)
+	} else {
+		d.OnAudioPacket(track.bufferIndex, track.codecID, frame, dts)
+	}
+}
+
+// Discontinuity 之后的时间戳不再连续, 例如HLS的EXT-X-DISCONTINUITY, 输出的时间戳接着之前的继续增长
+func (d *Demuxer) Discontinuity() {
+	for _, track := range d.tracks {
+		track.discontinuity = true
+	}
+}
+
+func NewDemuxer() *Demuxer {
+	return &Demuxer{
+		BaseDemuxer: avformat.BaseDemuxer{
+			DataPipeline: &avformat.StreamsBuffer{},
+			Name:         "mp4",
+			AutoFree:     true,
+		},
+		tracks: make(map[uint32]*demuxTrack),
+	}
+}
@@ -0,0 +1,133 @@
+package fmp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/bufio"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+type testHandler struct {
+	tracks   []avformat.Track
+	complete bool
+	packets  []*avformat.AVPacket
+}
+
+func (h *testHandler) OnNewTrack(track avformat.Track) {
+	h.tracks = append(h.tracks, track)
+}
+
+func (h *testHandler) OnTrackComplete() {
+	h.complete = true
+}
+
+func (h *testHandler) OnTrackNotFind() {
+}
+
+func (h *testHandler) OnPacket(packet *avformat.AVPacket) {
+	h.packets = append(h.packets, &avformat.AVPacket{Data: append([]byte{}, packet.Data...), Dts: packet.Dts, Pts: packet.Pts, Key: packet.Key, Duration: packet.Duration, MediaType: packet.MediaType})
+}
+
+func TestDemuxer(t *testing.T) {
+	record, _ := hex.DecodeString("0142c01effe100186742c01eda01e0089f961000000300100000030320f162ea01000568ce0f2c80")
+	codecData, err := avformat.ParseAVCDecoderConfigurationRecord(record)
+	if err != nil {
+		panic(err)
+	}
+
+	muxer := NewMuxer()
+	video, _ := muxer.AddTrack(&avformat.AVStream{MediaType: utils.AVMediaTypeVideo, CodecID: utils.AVCodecIdH264, CodecParameters: codecData})
+	audio, _ := muxer.AddTrack(&avformat.AVStream{MediaType: utils.AVMediaTypeAudio, CodecID: utils.AVCodecIdAAC, Data: []byte{0x14, 0x08}, AudioConfig: avformat.AudioConfig{SampleRate: 16000, Channels: 1}})
+
+	data := muxer.AppendInitSegment(nil)
+	for i := 0; i < 6; i++ {
+		frame := []byte{0, 0, 0, 2, 0x41, byte(i)}
+		if i%3 == 0 {
+			frame = []byte{0, 0, 0, 2, 0x65, byte(i)}
+		}
+
+		packet := avformat.NewVideoPacket(frame, int64(i*40), int64(i*40+40), i%3 == 0, avformat.PacketTypeAVCC, utils.AVCodecIdH264, 0, 1000)
+		packet.Duration = 40
+		_ = muxer.AddPacket(video, packet)
+
+		packet = avformat.NewAudioPacket([]byte{0x21, byte(i)}, int64(i*64), utils.AVCodecIdAAC, 1, 1000)
+		packet.Duration = 64
+		_ = muxer.AddPacket(audio, packet)
+
+		// 每3帧一个fragment
+		if i%3 == 2 {
+			data = muxer.AppendFragment(data)
+		}
+	}
+
+	handler := &testHandler{}
+	demuxer := NewDemuxer()
+	demuxer.SetHandler(handler)
+
+	// 分段输入, 不完整的box留给下次
+	var buffer []byte
+	for i := 0; i < len(data); i += 100 {
+		buffer = append(buffer, data[i:bufio.MinInt(i+100, len(data))]...)
+		n, err := demuxer.Input(buffer)
+		if err != nil {
+			panic(err)
+		}
+		buffer = buffer[n:]
+	}
+	utils.Assert(len(buffer) == 0)
+
+	utils.Assert(handler.complete && len(handler.tracks) == 2)
+	utils.Assert(handler.tracks[0].GetStream().CodecID == utils.AVCodecIdH264 && handler.tracks[1].GetStream().Timebase == 16000)
+	utils.Assert(bytes.Equal(handler.tracks[1].GetStream().Data, []byte{0x14, 0x08}))
+
+	// 每个track的最后一帧缓存在demuxer中
+	var videos, audios []*avformat.AVPacket
+	for _, packet := range handler.packets {
+		if utils.AVMediaTypeVideo == packet.MediaType {
+			videos = append(videos, packet)
+		} else {
+			audios = append(audios, packet)
+		}
+	}
+
+	utils.Assert(len(videos) == 5 && len(audios) == 5)
+	for i, packet := range videos {
+		utils.Assert(packet.Dts == int64(i*40*90) && packet.Pts == int64(i*40*90+40*90) && packet.Key == (i%3 == 0))
+		utils.Assert(packet.Data[len(packet.Data)-1] == byte(i))
+	}
+	for i, packet := range audios {
+		utils.Assert(packet.Dts == int64(i*1024) && bytes.Equal(packet.Data, []byte{0x21, byte(i)}))
+	}
+}
+
+func TestDemuxerTruncatedTrak(t *testing.T) {
+	box := func(boxType string, children ...[]byte) []byte {
+		body := bytes.Join(children, nil)
+		data := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+		return append(append(data, boxType...), body...)
+	}
+
+	trak := func(tkhd, entry []byte) []byte {
+		stsd := box("stsd", make([]byte, 8), entry)
+		return box("moov", box("trak", box("tkhd", tkhd), box("mdia", box("mdhd", make([]byte, 20)), box("minf", box("stbl", stsd)))))
+	}
+
+	// 截断的tkhd, 截断的Opus/mp4a/avc1 sample entry
+	inputs := [][]byte{
+		trak(make([]byte, 8), box("Opus", make([]byte, 40))),
+		trak([]byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, box("Opus", make([]byte, 40))),
+		trak(make([]byte, 20), box("Opus", make([]byte, 16))),
+		trak(make([]byte, 20), box("mp4a", make([]byte, 16))),
+		trak(make([]byte, 20), box("avc1", make([]byte, 40))),
+	}
+
+	for _, data := range inputs {
+		demuxer := NewDemuxer()
+		demuxer.SetHandler(&testHandler{})
+		_, err := demuxer.Input(data)
+		utils.Assert(err != nil)
+	}
+}
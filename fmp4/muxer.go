@@ -0,0 +1,471 @@
+package fmp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/ac3"
+	"github.com/lkmio/avformat/av1"
+	"github.com/lkmio/avformat/opus"
+	"github.com/lkmio/avformat/utils"
+)
+
+const (
+	// 视频的时间基, 音频使用采样率
+	VideoTimescale = 90000
+
+	// sample flags
+	sampleFlagsKey   = 0x02000000 // sample_depends_on=2
+	sampleFlagsInter = 0x01010000 // sample_depends_on=1, sample_is_non_sync_sample=1
+)
+
+type sample struct {
+	data     []byte
+	dts      int64 // timescale
+	cts      int32
+	duration uint32
+	key      bool
+}
+
+type track struct {
+	stream       *avformat.AVStream
+	id           uint32
+	timescale    int
+	samples      []sample
+	lastDuration uint32
+}
+
+// Muxer fMP4封装, 生成init segment(ftyp+moov)和fragment(moof+mdat)
+type Muxer struct {
+	tracks   []*track
+	sequence uint32
+}
+
+// Supported 返回是否支持封装该编码
+func Supported(id utils.AVCodecID) bool {
+	return utils.AVCodecIdH264 == id || utils.AVCodecIdH265 == id || utils.AVCodecIdAV1 == id ||
+		utils.AVCodecIdVP8 == id || utils.AVCodecIdVP9 == id || utils.AVCodecIdAAC == id || utils.AVCodecIdOPUS == id ||
+		utils.AVCodecIdAC3 == id || utils.AVCodecIdEAC3 == id
+}
+
+func (m *Muxer) AddTrack(stream *avformat.AVStream) (int, error) {
+	if !Supported(stream.CodecID) {
+		return -1, fmt.Errorf("unsupported fmp4 codec %s", stream.CodecID)
+	} else if utils.AVMediaTypeVideo == stream.MediaType && stream.CodecParameters == nil {
+		return -1, fmt.Errorf("missing %s codec parameters", stream.CodecID)
+	} else if utils.AVCodecIdAAC == stream.CodecID && len(stream.Data) < 2 {
+		return -1, fmt.Errorf("missing aac audio specific config")
+	} else if utils.AVCodecIdOPUS == stream.CodecID {
+		if _, err := opus.ParseOpusHead(stream.Data); err != nil {
+			return -1, err
+		}
+	} else if utils.AVCodecIdAC3 == stream.CodecID {
+		if _, err := ac3.ParseAC3SpecificBox(stream.Data); err != nil {
+			return -1, err
+		}
+	} else if utils.AVCodecIdEAC3 == stream.CodecID {
+		if _, err := ac3.ParseEC3SpecificBox(stream.Data); err != nil {
+			return -1, err
+		}
+	}
+
+	t := &track{stream: stream, id: uint32(len(m.tracks) + 1), timescale: VideoTimescale}
+	if utils.AVMediaTypeAudio == stream.MediaType && stream.SampleRate > 0 {
+		t.timescale = stream.SampleRate
+	}
+
+	m.tracks = append(m.tracks, t)
+	return len(m.tracks) - 1, nil
+}
+
+// Timescale 返回track的时间基
+func (m *Muxer) Timescale(index int) int {
+	return m.tracks[index].timescale
+}
+
+// AppendInitSegment 写入ftyp和moov
+func (m *Muxer) AppendInitSegment(dst []byte) []byte {
+	dst, ftyp := beginBox(dst, "ftyp")
+	dst = append(dst, "iso5"...)
+	dst = appendUint32(dst, 512)
+	dst = append(dst, "iso5iso6mp41"...)
+	dst = endBox(dst, ftyp)
+
+	dst, moov := beginBox(dst, "moov")
+	dst, mvhd := beginFullBox(dst, "mvhd", 0, 0)
+	dst = appendUint32(dst, 0)    // creation_time
+	dst = appendUint32(dst, 0)    // modification_time
+	dst = appendUint32(dst, 1000) // timescale
+	dst = appendUint32(dst, 0)    // duration
+	dst = appendUint32(dst, 0x00010000)
+	dst = appendUint16(dst, 0x0100)
+	dst = append(dst, make([]byte, 10)...)
+	dst = append(dst, matrix...)
+	dst = append(dst, make([]byte, 24)...)
+	dst = appendUint32(dst, uint32(len(m.tracks)+1)) // next_track_ID
+	dst = endBox(dst, mvhd)
+
+	for _, t := range m.tracks {
+		dst = t.appendTrak(dst)
+	}
+
+	dst, mvex := beginBox(dst, "mvex")
+	for _, t := range m.tracks {
+		var trex int
+		dst, trex = beginFullBox(dst, "trex", 0, 0)
+		dst = appendUint32(dst, t.id)
+		dst = appendUint32(dst, 1) // default_sample_description_index
+		dst = appendUint32(dst, 0)
+		dst = appendUint32(dst, 0)
+		dst = appendUint32(dst, 0)
+		dst = endBox(dst, trex)
+	}
+	dst = endBox(dst, mvex)
+
+	return endBox(dst, moov)
+}
+
+func (m *Muxer) WriteHeader(dst []byte) (int, error) {
+	header := m.AppendInitSegment(nil)
+	if len(dst) < len(header) {
+		return 0, fmt.Errorf("fmp4 buffer too small")
+	}
+
+	return copy(dst, header), nil
+}
+
+func (t *track) appendTrak(dst []byte) []byte {
+	video := utils.AVMediaTypeVideo == t.stream.MediaType
+	var width, height int
+	if video {
+		width, height = t.stream.CodecParameters.Width(), t.stream.CodecParameters.Height()
+	}
+
+	dst, trak := beginBox(dst, "trak")
+	dst, tkhd := beginFullBox(dst, "tkhd", 0, 3) // enabled|in_movie
+	dst = appendUint32(dst, 0)
+	dst = appendUint32(dst, 0)
+	dst = appendUint32(dst, t.id)
+	dst = appendUint32(dst, 0)
+	dst = appendUint32(dst, 0) // duration
+	dst = append(dst, make([]byte, 8)...)
+	dst = appendUint16(dst, 0) // layer
+	dst = appendUint16(dst, 0) // alternate_group
+	if video {
+		dst = appendUint16(dst, 0)
+	} else {
+		dst = appendUint16(dst, 0x0100) // volume
+	}
+	dst = appendUint16(dst, 0)
+	dst = append(dst, matrix...)
+	dst = appendUint32(dst, uint32(width)<<16)
+	dst = appendUint32(dst, uint32(height)<<16)
+	dst = endBox(dst, tkhd)
+
+	dst, mdia := beginBox(dst, "mdia")
+	dst, mdhd := beginFullBox(dst, "mdhd", 0, 0)
+	dst = appendUint32(dst, 0)
+	dst = appendUint32(dst, 0)
+	dst = appendUint32(dst, uint32(t.timescale))
+	dst = appendUint32(dst, 0)
+	dst = appendUint16(dst, 0x55C4) // und
+	dst = appendUint16(dst, 0)
+	dst = endBox(dst, mdhd)
+
+	dst, hdlr := beginFullBox(dst, "hdlr", 0, 0)
+	dst = appendUint32(dst, 0)
+	if video {
+		dst = append(dst, "vide"...)
+		dst = append(dst, make([]byte, 12)...)
+		dst = append(dst, "VideoHandler\x00"...)
+	} else {
+		dst = append(dst, "soun"...)
+		dst = append(dst, make([]byte, 12)...)
+		dst = append(dst, "SoundHandler\x00"...)
+	}
+	dst = endBox(dst, hdlr)
+
+	dst, minf := beginBox(dst, "minf")
+	if video {
+		var vmhd int
+		dst, vmhd = beginFullBox(dst, "vmhd", 0, 1)
+		dst = append(dst, make([]byte, 8)...)
+		dst = endBox(dst, vmhd)
+	} else {
+		var smhd int
+		dst, smhd = beginFullBox(dst, "smhd", 0, 0)
+		dst = append(dst, make([]byte, 4)...)
+		dst = endBox(dst, smhd)
+	}
+
+	dst, dinf := beginBox(dst, "dinf")
+	dst, dref := beginFullBox(dst, "dref", 0, 0)
+	dst = appendUint32(dst, 1)
+	dst, url := beginFullBox(dst, "url ", 0, 1) // self-contained
+	dst = endBox(dst, url)
+	dst = endBox(dst, dref)
+	dst = endBox(dst, dinf)
+
+	dst, stbl := beginBox(dst, "stbl")
+	dst, stsd := beginFullBox(dst, "stsd", 0, 0)
+	dst = appendUint32(dst, 1)
+	if video {
+		dst = t.appendVisualSampleEntry(dst, width, height)
+	} else {
+		dst = t.appendAudioSampleEntry(dst)
+	}
+	dst = endBox(dst, stsd)
+
+	// fragment中的sample表为空
+	for _, name := range []string{"stts", "stsc", "stco"} {
+		var box int
+		dst, box = beginFullBox(dst, name, 0, 0)
+		dst = appendUint32(dst, 0)
+		dst = endBox(dst, box)
+	}
+
+	dst, stsz := beginFullBox(dst, "stsz", 0, 0)
+	dst = appendUint32(dst, 0)
+	dst = appendUint32(dst, 0)
+	dst = endBox(dst, stsz)
+
+	dst = endBox(dst, stbl)
+	dst = endBox(dst, minf)
+	dst = endBox(dst, mdia)
+	return endBox(dst, trak)
+}
+
+func (t *track) appendVisualSampleEntry(dst []byte, width, height int) []byte {
+	entryType, configType := "avc1", "avcC"
+	if utils.AVCodecIdH265 == t.stream.CodecID {
+		entryType, configType = "hvc1", "hvcC"
+	} else if utils.AVCodecIdAV1 == t.stream.CodecID {
+		entryType, configType = "av01", "av1C"
+	} else if utils.AVCodecIdVP8 == t.stream.CodecID {
+		entryType, configType = "vp08", "vpcC"
+	} else if utils.AVCodecIdVP9 == t.stream.CodecID {
+		entryType, configType = "vp09", "vpcC"
+	}
+
+	dst, entry := beginBox(dst, entryType)
+	dst = append(dst, make([]byte, 6)...)
+	dst = appendUint16(dst, 1) // data_reference_index
+	dst = append(dst, make([]byte, 16)...)
+	dst = appendUint16(dst, uint16(width))
+	dst = appendUint16(dst, uint16(height))
+	dst = appendUint32(dst, 0x00480000) // 72dpi
+	dst = appendUint32(dst, 0x00480000)
+	dst = appendUint32(dst, 0)
+	dst = appendUint16(dst, 1) // frame_count
+	dst = append(dst, make([]byte, 32)...)
+	dst = appendUint16(dst, 0x0018)
+	dst = appendUint16(dst, 0xFFFF)
+
+	dst, config := beginBox(dst, configType)
+	dst = append(dst, t.stream.CodecParameters.MP4ExtraData()...)
+	dst = endBox(dst, config)
+
+	// 像素宽高比
+	if t.stream.SarWidth > 0 && t.stream.SarHeight > 0 {
+		var pasp int
+		dst, pasp = beginBox(dst, "pasp")
+		dst = appendUint32(dst, uint32(t.stream.SarWidth))
+		dst = appendUint32(dst, uint32(t.stream.SarHeight))
+		dst = endBox(dst, pasp)
+	}
+
+	if colors := t.stream.ColorInfo; colors != nil {
+		var colr int
+		dst, colr = beginBox(dst, "colr")
+		dst = append(dst, colors.Marshal()...)
+		dst = endBox(dst, colr)
+	}
+
+	return endBox(dst, entry)
+}
+
+func (t *track) appendAudioSampleEntry(dst []byte) []byte {
+	channels := t.stream.Channels
+	if channels < 1 {
+		channels = 2
+	}
+
+	entryType := "mp4a"
+	switch t.stream.CodecID {
+	case utils.AVCodecIdOPUS:
+		entryType = "Opus"
+	case utils.AVCodecIdAC3:
+		entryType = "ac-3"
+	case utils.AVCodecIdEAC3:
+		entryType = "ec-3"
+	}
+
+	dst, entry := beginBox(dst, entryType)
+	dst = append(dst, make([]byte, 6)...)
+	dst = appendUint16(dst, 1)
+	dst = append(dst, make([]byte, 8)...)
+	dst = appendUint16(dst, uint16(channels))
+	dst = appendUint16(dst, 16)
+	dst = appendUint32(dst, 0)
+	dst = appendUint32(dst, uint32(t.timescale)<<16)
+
+	if utils.AVCodecIdOPUS == t.stream.CodecID {
+		head, _ := opus.ParseOpusHead(t.stream.Data)
+		var dOps int
+		dst, dOps = beginBox(dst, "dOps")
+		dst = append(dst, head.MarshalDOps()...)
+		dst = endBox(dst, dOps)
+		return endBox(dst, entry)
+	} else if utils.AVCodecIdAC3 == t.stream.CodecID || utils.AVCodecIdEAC3 == t.stream.CodecID {
+		// stream.Data即dac3/dec3的内容
+		boxType := "dac3"
+		if utils.AVCodecIdEAC3 == t.stream.CodecID {
+			boxType = "dec3"
+		}
+
+		var specific int
+		dst, specific = beginBox(dst, boxType)
+		dst = append(dst, t.stream.Data...)
+		dst = endBox(dst, specific)
+		return endBox(dst, entry)
+	}
+
+	// ES_Descriptor -> DecoderConfigDescriptor -> DecoderSpecificInfo
+	config := t.stream.Data
+	dst, esds := beginFullBox(dst, "esds", 0, 0)
+	dst = appendDescriptor(dst, 0x03, 3+5+13+5+len(config)+5+1)
+	dst = appendUint16(dst, uint16(t.id))
+	dst = append(dst, 0)
+	dst = appendDescriptor(dst, 0x04, 13+5+len(config))
+	dst = append(dst, 0x40, 0x15) // MPEG-4 Audio, AudioStream
+	dst = append(dst, 0, 0, 0)    // bufferSizeDB
+	dst = appendUint32(dst, 0)    // maxBitrate
+	dst = appendUint32(dst, 0)    // avgBitrate
+	dst = appendDescriptor(dst, 0x05, len(config))
+	dst = append(dst, config...)
+	dst = appendDescriptor(dst, 0x06, 1)
+	dst = append(dst, 0x02)
+	dst = endBox(dst, esds)
+	return endBox(dst, entry)
+}
+
+// AddPacket 缓存一帧, 视频转为AVCC, AV1去掉temporal delimiter, VP8/VP9保持不变, AAC去掉ADTS头. 时长使用AVPacket的Duration, 没有则使用上一帧的时长
+func (m *Muxer) AddPacket(index int, packet *avformat.AVPacket) error {
+	if index < 0 || index >= len(m.tracks) {
+		return fmt.Errorf("invalid fmp4 track index %d", index)
+	}
+
+	t := m.tracks[index]
+	data := packet.Data
+	if utils.AVCodecIdAV1 == packet.CodecID {
+		data = av1.RemoveTemporalDelimiter(data)
+	} else if utils.AVMediaTypeVideo == packet.MediaType && utils.AVCodecIdVP8 != packet.CodecID && utils.AVCodecIdVP9 != packet.CodecID {
+		data = avformat.AnnexBPacket2AVCC(packet)
+	} else if t.stream.HasADTSHeader && len(data) > 7 {
+		header, err := utils.ReadADtsFixedHeader(data)
+		if err != nil {
+			return err
+		} else if header.ProtectionAbsent() == 0 {
+			data = data[9:]
+		} else {
+			data = data[7:]
+		}
+	}
+
+	// 时间戳回退时duration为负数, 使用上一帧的时长
+	duration := t.lastDuration
+	if d := packet.GetDuration(t.timescale); d > 0 {
+		duration = uint32(d)
+	}
+	t.lastDuration = duration
+
+	dts := packet.ConvertDts(t.timescale)
+	t.samples = append(t.samples, sample{
+		// AVPacket会被释放, 需要拷贝
+		data:     append([]byte{}, data...),
+		dts:      dts,
+		cts:      int32(packet.ConvertPts(t.timescale) - dts),
+		duration: duration,
+		key:      packet.Key || utils.AVMediaTypeAudio == packet.MediaType,
+	})
+
+	return nil
+}
+
+// AppendFragment 将缓存的帧写入moof和mdat, 没有缓存的帧则不写入
+func (m *Muxer) AppendFragment(dst []byte) []byte {
+	var total int
+	for _, t := range m.tracks {
+		total += len(t.samples)
+	}
+
+	if total == 0 {
+		return dst
+	}
+
+	m.sequence++
+	start := len(dst)
+	dst, moof := beginBox(dst, "moof")
+	dst, mfhd := beginFullBox(dst, "mfhd", 0, 0)
+	dst = appendUint32(dst, m.sequence)
+	dst = endBox(dst, mfhd)
+
+	// data_offset相对于moof, 写完moof后再填充
+	var offsets []int
+	for _, t := range m.tracks {
+		if len(t.samples) == 0 {
+			continue
+		}
+
+		var traf, tfhd, tfdt, trun int
+		dst, traf = beginBox(dst, "traf")
+		dst, tfhd = beginFullBox(dst, "tfhd", 0, 0x020000) // default-base-is-moof
+		dst = appendUint32(dst, t.id)
+		dst = endBox(dst, tfhd)
+
+		dst, tfdt = beginFullBox(dst, "tfdt", 1, 0)
+		dst = appendUint64(dst, uint64(t.samples[0].dts))
+		dst = endBox(dst, tfdt)
+
+		// data-offset, duration, size, flags, composition time offset
+		dst, trun = beginFullBox(dst, "trun", 1, 0x000F01)
+		dst = appendUint32(dst, uint32(len(t.samples)))
+		offsets = append(offsets, len(dst))
+		dst = appendUint32(dst, 0)
+		for _, s := range t.samples {
+			flags := uint32(sampleFlagsInter)
+			if s.key {
+				flags = sampleFlagsKey
+			}
+
+			dst = appendUint32(dst, s.duration)
+			dst = appendUint32(dst, uint32(len(s.data)))
+			dst = appendUint32(dst, flags)
+			dst = appendUint32(dst, uint32(s.cts))
+		}
+		dst = endBox(dst, trun)
+		dst = endBox(dst, traf)
+	}
+	dst = endBox(dst, moof)
+
+	dst, mdat := beginBox(dst, "mdat")
+	var i int
+	for _, t := range m.tracks {
+		if len(t.samples) == 0 {
+			continue
+		}
+
+		binary.BigEndian.PutUint32(dst[offsets[i]:], uint32(len(dst)-start))
+		i++
+		for _, s := range t.samples {
+			dst = append(dst, s.data...)
+		}
+		t.samples = t.samples[:0]
+	}
+
+	return endBox(dst, mdat)
+}
+
+func NewMuxer() *Muxer {
+	return &Muxer{}
+}
@@ -0,0 +1,124 @@
+package fmp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/opus"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+// 读取同一层级的box, 返回类型和内容
+func readBoxes(data []byte) ([]string, [][]byte) {
+	var types []string
+	var bodies [][]byte
+	for len(data) >= 8 {
+		size := binary.BigEndian.Uint32(data)
+		utils.Assert(size >= 8 && int(size) <= len(data))
+		types = append(types, string(data[4:8]))
+		bodies = append(bodies, data[8:size])
+		data = data[size:]
+	}
+
+	utils.Assert(len(data) == 0)
+	return types, bodies
+}
+
+func TestMuxer(t *testing.T) {
+	record, _ := hex.DecodeString("0142c01effe100186742c01eda01e0089f961000000300100000030320f162ea01000568ce0f2c80")
+	codecData, err := avformat.ParseAVCDecoderConfigurationRecord(record)
+	if err != nil {
+		panic(err)
+	}
+
+	muxer := NewMuxer()
+	video, _ := muxer.AddTrack(&avformat.AVStream{MediaType: utils.AVMediaTypeVideo, CodecID: utils.AVCodecIdH264, CodecParameters: codecData})
+	audio, err := muxer.AddTrack(&avformat.AVStream{MediaType: utils.AVMediaTypeAudio, CodecID: utils.AVCodecIdAAC, Data: []byte{0x14, 0x08}, AudioConfig: avformat.AudioConfig{SampleRate: 16000, Channels: 1}})
+	if err != nil {
+		panic(err)
+	}
+	_, err = muxer.AddTrack(&avformat.AVStream{MediaType: utils.AVMediaTypeAudio, CodecID: utils.AVCodecIdPCMALAW})
+	utils.Assert(err != nil)
+	utils.Assert(muxer.Timescale(audio) == 16000)
+
+	types, bodies := readBoxes(muxer.AppendInitSegment(nil))
+	utils.Assert(len(types) == 2 && types[0] == "ftyp" && types[1] == "moov")
+	types, bodies = readBoxes(bodies[1])
+	utils.Assert(len(types) == 4 && types[1] == "trak" && types[2] == "trak" && types[3] == "mvex")
+	utils.Assert(bytes.Contains(bodies[1], record) && bytes.Contains(bodies[2], []byte("esds")))
+
+	// 没有缓存的帧不生成fragment
+	utils.Assert(len(muxer.AppendFragment(nil)) == 0)
+
+	frames := [][]byte{{0, 0, 0, 2, 0x65, 0x88}, {0, 0, 0, 2, 0x41, 0x9a}}
+	for i, frame := range frames {
+		packet := avformat.NewVideoPacket(frame, int64(i*40), int64(i*40+40), i == 0, avformat.PacketTypeAVCC, utils.AVCodecIdH264, 0, 1000)
+		packet.Duration = 40
+		if err = muxer.AddPacket(video, packet); err != nil {
+			panic(err)
+		}
+	}
+
+	packet := avformat.NewAudioPacket([]byte{0x21, 0x10}, 0, utils.AVCodecIdAAC, 1, 1000)
+	packet.Duration = 64
+	if err = muxer.AddPacket(audio, packet); err != nil {
+		panic(err)
+	}
+
+	fragment := muxer.AppendFragment(nil)
+	types, bodies = readBoxes(fragment)
+	utils.Assert(len(types) == 2 && types[0] == "moof" && types[1] == "mdat")
+	utils.Assert(bytes.Equal(bodies[1], []byte{0, 0, 0, 2, 0x65, 0x88, 0, 0, 0, 2, 0x41, 0x9a, 0x21, 0x10}))
+
+	// 第一个traf的trun
+	types, moof := readBoxes(bodies[0])
+	utils.Assert(len(types) == 3 && types[1] == "traf")
+	types, traf := readBoxes(moof[1])
+	utils.Assert(types[2] == "trun")
+	trun := traf[2]
+	utils.Assert(binary.BigEndian.Uint32(trun[4:]) == 2)
+	offset := binary.BigEndian.Uint32(trun[8:])
+	utils.Assert(bytes.Equal(fragment[offset:offset+6], frames[0]))
+	// duration, size, flags, cts
+	utils.Assert(binary.BigEndian.Uint32(trun[12:]) == 40*90 && binary.BigEndian.Uint32(trun[16:]) == 6)
+	utils.Assert(binary.BigEndian.Uint32(trun[20:]) == sampleFlagsKey && binary.BigEndian.Uint32(trun[24:]) == 40*90)
+
+	// 音频的时间基为采样率
+	types, traf = readBoxes(moof[2])
+	utils.Assert(binary.BigEndian.Uint32(traf[2][12:]) == 1024)
+	offset = binary.BigEndian.Uint32(traf[2][8:])
+	utils.Assert(bytes.Equal(fragment[offset:offset+2], []byte{0x21, 0x10}))
+}
+
+func TestOpus(t *testing.T) {
+	head := opus.NewOpusHead(2, 48000)
+	muxer := NewMuxer()
+	audio, err := muxer.AddTrack(&avformat.AVStream{MediaType: utils.AVMediaTypeAudio, CodecID: utils.AVCodecIdOPUS, Data: head.Marshal(), AudioConfig: avformat.AudioConfig{SampleRate: 48000, Channels: 2}})
+	if err != nil {
+		panic(err)
+	}
+
+	data := muxer.AppendInitSegment(nil)
+	for i := 0; i < 4; i++ {
+		// CELT 20ms 立体声
+		packet := avformat.NewAudioPacket([]byte{0xFC, byte(i)}, int64(i*960), utils.AVCodecIdOPUS, 0, 48000)
+		packet.Duration = 960
+		_ = muxer.AddPacket(audio, packet)
+	}
+	data = muxer.AppendFragment(data)
+
+	handler := &testHandler{}
+	demuxer := NewDemuxer()
+	demuxer.SetHandler(handler)
+	n, err := demuxer.Input(data)
+	utils.Assert(err == nil && n == len(data))
+
+	// dOps转回OpusHead
+	utils.Assert(len(handler.tracks) == 1 && bytes.Equal(handler.tracks[0].GetStream().Data, head.Marshal()))
+	utils.Assert(len(handler.packets) == 3)
+	for i, packet := range handler.packets {
+		utils.Assert(packet.Dts == int64(i*960) && packet.Duration == 960 && packet.Data[1] == byte(i))
+	}
+}
@@ -83,9 +83,31 @@ func (r *HEVCDecoderConfigurationRecord) Marshal(vpsList, spsList, ppsList [][]b
 		return nil, fmt.Errorf("vps cannot be null")
 	}
 
+	// 没有设置profile/tier/level时, 从参数集中读取
+	if r.GeneralProfileIdc == 0 {
+		if err := r.UpdateFromParameterSets(vpsList[0], spsList[0], ppsList[0]); err != nil {
+			// VPS或PPS异常时只使用SPS中的信息
+			println(err.Error())
+			if err = r.UpdateFromSPS(spsList[0]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	bytes := make([]byte, 1024)
 	bytes[0] = 1
-	bytes[21] = 3
+	bytes[1] = r.GeneralProfileSpace<<6 | r.GeneralTierFlag<<5 | r.GeneralProfileIdc
+	binary.BigEndian.PutUint32(bytes[2:], r.GeneralProfileCompatibilityFlags)
+	binary.BigEndian.PutUint32(bytes[6:], uint32(r.GeneralConstraintIndicatorFlags>>16))
+	binary.BigEndian.PutUint16(bytes[10:], uint16(r.GeneralConstraintIndicatorFlags))
+	bytes[12] = r.GeneralLevelIdc
+	binary.BigEndian.PutUint16(bytes[13:], 0xF000|r.MinSpatialSegmentationIdc)
+	bytes[15] = 0xFC | r.ParallelismType
+	bytes[16] = 0xFC | r.ChromaFormat
+	bytes[17] = 0xF8 | r.BitDepthLumaMinus8
+	bytes[18] = 0xF8 | r.BitDepthChromaMinus8
+	binary.BigEndian.PutUint16(bytes[19:], r.AvgFrameRate)
+	bytes[21] = r.ConstantFrameRate<<6 | r.NumTemporalLayers<<3 | r.TemporalIdNested<<2 | 3
 	bytes[22] = 3
 	writer := bufio.NewBytesWriter(bytes)
 	if err := writer.Seek(23); err != nil {
@@ -130,6 +152,70 @@ func (r *HEVCDecoderConfigurationRecord) Marshal(vpsList, spsList, ppsList [][]b
 	return bytes[:writer.Offset()], nil
 }
 
+// UpdateFromSPS 从SPS中读取profile/tier/level, 色度格式和位深
+func (r *HEVCDecoderConfigurationRecord) UpdateFromSPS(sps []byte) error {
+	info, err := ParseSPS(sps)
+	if err != nil {
+		return err
+	}
+
+	r.ConfigurationVersion = 1
+	r.GeneralProfileSpace = byte(info.GeneralProfileSpace)
+	r.GeneralTierFlag = byte(info.GeneralTierFlag)
+	r.GeneralProfileIdc = byte(info.GeneralProfileIDC)
+	r.GeneralProfileCompatibilityFlags = info.GeneralProfileCompatibilityFlags
+	r.GeneralConstraintIndicatorFlags = info.GeneralConstraintIndicatorFlags
+	r.GeneralLevelIdc = byte(info.GeneralLevelIDC)
+	r.ChromaFormat = byte(info.ChromaFormat)
+	r.BitDepthLumaMinus8 = byte(info.BitDepthLumaMinus8)
+	r.BitDepthChromaMinus8 = byte(info.BitDepthChromaMinus8)
+	r.NumTemporalLayers = byte(info.NumTemporalLayers)
+	r.TemporalIdNested = byte(info.TemporalIdNested)
+	return nil
+}
+
+// UpdateFromParameterSets 检查VPS/SPS/PPS的引用关系, 再从中读取PTL、时域层数、并行类型和帧率.
+// 失败时不修改record
+func (r *HEVCDecoderConfigurationRecord) UpdateFromParameterSets(vps, sps, pps []byte) error {
+	sets := NewParameterSets()
+	for _, nalu := range [][]byte{vps, sps, pps} {
+		if err := sets.Input(nalu); err != nil {
+			return err
+		}
+	}
+
+	// 只输入了一个PPS
+	var ppsId uint
+	for id := range sets.PPS {
+		ppsId = id
+	}
+
+	record := *r
+	ppsInfo, spsInfo, vpsInfo, err := sets.Find(ppsId)
+	if err != nil {
+		return err
+	} else if err = record.UpdateFromSPS(sps); err != nil {
+		return err
+	}
+
+	// hvcC的时域层数取VPS和SPS中较大的
+	if layers := vpsInfo.MaxSubLayersMinus1 + 1; layers > spsInfo.NumTemporalLayers {
+		record.NumTemporalLayers = byte(layers)
+	}
+	record.TemporalIdNested = byte(spsInfo.TemporalIdNested & vpsInfo.TemporalIdNestingFlag)
+	record.ParallelismType = ppsInfo.ParallelismType()
+	record.MinSpatialSegmentationIdc = uint16(spsInfo.VUI.MinSpatialSegmentationIdc)
+
+	frameRate := spsInfo.FrameRate
+	if frameRate == 0 {
+		frameRate = vpsInfo.FrameRate()
+	}
+	// 单位为帧/256秒
+	record.AvgFrameRate = uint16(frameRate*256 + 0.5)
+	*r = record
+	return nil
+}
+
 func (r *HEVCDecoderConfigurationRecord) Unmarshal(data []byte) error {
 	reader := bufio.NewBytesReader(data)
 	if err := reader.Seek(23); err != nil {
@@ -0,0 +1,308 @@
+package hevc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/lkmio/avformat/avc"
+	"github.com/lkmio/avformat/bufio"
+)
+
+const (
+	HevcMaxVpsCount = 16
+	HevcMaxSpsCount = 16
+	HevcMaxPpsCount = 64
+)
+
+// PPS pic_parameter_set_rbsp, 参考7.3.2.3
+type PPS struct {
+	Id                                uint
+	SPSId                             uint
+	DependentSliceSegmentsEnabledFlag uint
+	OutputFlagPresentFlag             uint
+	NumExtraSliceHeaderBits           uint
+	SignDataHidingEnabledFlag         uint
+	CabacInitPresentFlag              uint
+	NumRefIdxL0DefaultActiveMinus1    uint
+	NumRefIdxL1DefaultActiveMinus1    uint
+	InitQpMinus26                     int
+	ConstrainedIntraPredFlag          uint
+	TransformSkipEnabledFlag          uint
+	CuQpDeltaEnabledFlag              uint
+	DiffCuQpDeltaDepth                uint
+	CbQpOffset                        int
+	CrQpOffset                        int
+	SliceChromaQpOffsetsPresentFlag   uint
+	WeightedPredFlag                  uint
+	WeightedBipredFlag                uint
+	TransquantBypassEnabledFlag       uint
+	TilesEnabledFlag                  uint
+	EntropyCodingSyncEnabledFlag      uint // WPP
+
+	NumTileColumnsMinus1             uint
+	NumTileRowsMinus1                uint
+	UniformSpacingFlag               uint
+	ColumnWidthMinus1                []uint
+	RowHeightMinus1                  []uint
+	LoopFilterAcrossTilesEnabledFlag uint
+
+	LoopFilterAcrossSlicesEnabledFlag   uint
+	DeblockingFilterControlPresentFlag  uint
+	DeblockingFilterOverrideEnabledFlag uint
+	DeblockingFilterDisabledFlag        uint
+	BetaOffsetDiv2                      int
+	TcOffsetDiv2                        int
+
+	ScalingListDataPresentFlag             uint
+	ListsModificationPresentFlag           uint
+	Log2ParallelMergeLevelMinus2           uint
+	SliceSegmentHeaderExtensionPresentFlag uint
+	ExtensionPresentFlag                   uint
+}
+
+// ParallelismType hvcC中的parallelismType, 0-未知或混合 1-slice 2-tile 3-wavefront
+func (p *PPS) ParallelismType() byte {
+	if p.TilesEnabledFlag != 0 && p.EntropyCodingSyncEnabledFlag != 0 {
+		return 0
+	} else if p.EntropyCodingSyncEnabledFlag != 0 {
+		return 3
+	} else if p.TilesEnabledFlag != 0 {
+		return 2
+	}
+	return 1
+}
+
+func readSE(br *bufio.GolombBitReader) (int, error) {
+	value, err := br.ReadSE()
+	return int(value), err
+}
+
+func ParsePPS(pps []byte) (p PPS, err error) {
+	pps = avc.RemoveStartCode(pps)
+	if len(pps) < 2 {
+		err = errors.New("incorrect Unit Size")
+		return
+	}
+
+	br := &bufio.GolombBitReader{R: bytes.NewReader(nal2rbsp(pps[2:]))}
+	if p.Id, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	} else if p.Id >= HevcMaxPpsCount {
+		err = fmt.Errorf("invalid pps_pic_parameter_set_id %d", p.Id)
+		return
+	}
+	if p.SPSId, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	} else if p.SPSId >= HevcMaxSpsCount {
+		err = fmt.Errorf("invalid pps_seq_parameter_set_id %d", p.SPSId)
+		return
+	}
+	if p.DependentSliceSegmentsEnabledFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if p.OutputFlagPresentFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if p.NumExtraSliceHeaderBits, err = br.ReadBits(3); err != nil {
+		return
+	}
+	if p.SignDataHidingEnabledFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if p.CabacInitPresentFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if p.NumRefIdxL0DefaultActiveMinus1, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if p.NumRefIdxL1DefaultActiveMinus1, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if p.NumRefIdxL0DefaultActiveMinus1 > 14 || p.NumRefIdxL1DefaultActiveMinus1 > 14 {
+		err = fmt.Errorf("invalid num_ref_idx_default_active_minus1 %d %d", p.NumRefIdxL0DefaultActiveMinus1, p.NumRefIdxL1DefaultActiveMinus1)
+		return
+	}
+	if p.InitQpMinus26, err = readSE(br); err != nil {
+		return
+	}
+	if p.ConstrainedIntraPredFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if p.TransformSkipEnabledFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if p.CuQpDeltaEnabledFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if p.CuQpDeltaEnabledFlag != 0 {
+		if p.DiffCuQpDeltaDepth, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+	if p.CbQpOffset, err = readSE(br); err != nil {
+		return
+	}
+	if p.CrQpOffset, err = readSE(br); err != nil {
+		return
+	}
+	if p.SliceChromaQpOffsetsPresentFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if p.WeightedPredFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if p.WeightedBipredFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if p.TransquantBypassEnabledFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if p.TilesEnabledFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if p.EntropyCodingSyncEnabledFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+
+	if p.TilesEnabledFlag != 0 {
+		if p.NumTileColumnsMinus1, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if p.NumTileRowsMinus1, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if p.NumTileColumnsMinus1 > 19 || p.NumTileRowsMinus1 > 21 {
+			err = fmt.Errorf("invalid tiles %dx%d", p.NumTileColumnsMinus1+1, p.NumTileRowsMinus1+1)
+			return
+		}
+
+		if p.UniformSpacingFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+		if p.UniformSpacingFlag == 0 {
+			p.ColumnWidthMinus1 = make([]uint, p.NumTileColumnsMinus1)
+			p.RowHeightMinus1 = make([]uint, p.NumTileRowsMinus1)
+			for i := range p.ColumnWidthMinus1 {
+				if p.ColumnWidthMinus1[i], err = br.ReadExponentialGolombCode(); err != nil {
+					return
+				}
+			}
+			for i := range p.RowHeightMinus1 {
+				if p.RowHeightMinus1[i], err = br.ReadExponentialGolombCode(); err != nil {
+					return
+				}
+			}
+		}
+		if p.LoopFilterAcrossTilesEnabledFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	if p.LoopFilterAcrossSlicesEnabledFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if p.DeblockingFilterControlPresentFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if p.DeblockingFilterControlPresentFlag != 0 {
+		if p.DeblockingFilterOverrideEnabledFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+		if p.DeblockingFilterDisabledFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+		if p.DeblockingFilterDisabledFlag == 0 {
+			if p.BetaOffsetDiv2, err = readSE(br); err != nil {
+				return
+			}
+			if p.TcOffsetDiv2, err = readSE(br); err != nil {
+				return
+			}
+		}
+	}
+
+	if p.ScalingListDataPresentFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if p.ScalingListDataPresentFlag != 0 {
+		if err = skipScalingListData(br); err != nil {
+			return
+		}
+	}
+	if p.ListsModificationPresentFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if p.Log2ParallelMergeLevelMinus2, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if p.SliceSegmentHeaderExtensionPresentFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	p.ExtensionPresentFlag, err = br.ReadBit()
+	return
+}
+
+// ParameterSets 按照id保存VPS、SPS和PPS, 后出现的同id参数集会覆盖之前的
+type ParameterSets struct {
+	VPS map[uint]*VPS
+	SPS map[uint]*HEVCSPSInfo
+	PPS map[uint]*PPS
+}
+
+// Input 解析VPS、SPS或PPS, 其他NALU忽略
+func (p *ParameterSets) Input(nalu []byte) error {
+	nalu = avc.RemoveStartCode(nalu)
+	if len(nalu) == 0 {
+		return nil
+	}
+
+	switch HEVCNALUnitType(nalu[0] >> 1 & 0x3F) {
+	case HevcNalVPS:
+		vps, err := ParseVPS(nalu)
+		if err != nil {
+			return err
+		}
+		p.VPS[vps.Id] = &vps
+	case HevcNalSPS:
+		sps, err := ParseSPS(nalu)
+		if err != nil {
+			return err
+		} else if sps.SPSId >= HevcMaxSpsCount {
+			return fmt.Errorf("invalid sps_seq_parameter_set_id %d", sps.SPSId)
+		}
+		p.SPS[sps.SPSId] = &sps
+	case HevcNalPPS:
+		pps, err := ParsePPS(nalu)
+		if err != nil {
+			return err
+		}
+		p.PPS[pps.Id] = &pps
+	}
+
+	return nil
+}
+
+// Find 返回pps_id对应的PPS以及它引用的SPS和VPS, 并检查引用关系和时域层数
+func (p *ParameterSets) Find(ppsId uint) (*PPS, *HEVCSPSInfo, *VPS, error) {
+	pps, ok := p.PPS[ppsId]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("not find pps %d", ppsId)
+	}
+
+	sps, ok := p.SPS[pps.SPSId]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("pps %d references unknown sps %d", ppsId, pps.SPSId)
+	}
+
+	vps, ok := p.VPS[sps.VPSId]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("sps %d references unknown vps %d", sps.SPSId, sps.VPSId)
+	} else if sps.MaxSubLayersMinus1 > vps.MaxSubLayersMinus1 {
+		return nil, nil, nil, fmt.Errorf("sps_max_sub_layers_minus1 %d is greater than vps_max_sub_layers_minus1 %d", sps.MaxSubLayersMinus1, vps.MaxSubLayersMinus1)
+	}
+	return pps, sps, vps, nil
+}
+
+func NewParameterSets() *ParameterSets {
+	return &ParameterSets{VPS: make(map[uint]*VPS), SPS: make(map[uint]*HEVCSPSInfo), PPS: make(map[uint]*PPS)}
+}
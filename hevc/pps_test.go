@@ -0,0 +1,58 @@
+package hevc
+
+import (
+	"encoding/hex"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+func TestParameterSets(t *testing.T) {
+	vps, _ := hex.DecodeString("40010c01ffff01600000030090000003000003005d999809")
+	sps, _ := hex.DecodeString("42010101600000030090000003000003005da00280802d165999a4932b9a808080820000030002000003003210")
+	pps, _ := hex.DecodeString("4401c172b46240")
+
+	vpsInfo, err := ParseVPS(vps)
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(vpsInfo.Id == 0 && vpsInfo.MaxSubLayersMinus1 == 0 && vpsInfo.GeneralProfileIDC == 1 && vpsInfo.GeneralLevelIDC == 93)
+	utils.Assert(vpsInfo.MaxNumReorderPics[0] == 2 && vpsInfo.TimingInfoPresentFlag == 0 && vpsInfo.FrameRate() == 0)
+
+	ppsInfo, err := ParsePPS(pps)
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(ppsInfo.Id == 0 && ppsInfo.SPSId == 0 && ppsInfo.SignDataHidingEnabledFlag == 1 && ppsInfo.CuQpDeltaEnabledFlag == 1)
+	utils.Assert(ppsInfo.WeightedPredFlag == 1 && ppsInfo.EntropyCodingSyncEnabledFlag == 1 && ppsInfo.ParallelismType() == 3)
+
+	record := HEVCDecoderConfigurationRecord{}
+	if err = record.UpdateFromParameterSets(vps, sps, pps); err != nil {
+		panic(err)
+	}
+	utils.Assert(record.NumTemporalLayers == 1 && record.TemporalIdNested == 1 && record.ParallelismType == 3 && record.AvgFrameRate == 25*256)
+
+	// 缺少PPS引用的SPS
+	sets := NewParameterSets()
+	for _, nalu := range [][]byte{vps, pps} {
+		if err = sets.Input(nalu); err != nil {
+			panic(err)
+		}
+	}
+	_, _, _, err = sets.Find(1)
+	utils.Assert(err != nil)
+	_, _, _, err = sets.Find(0)
+	utils.Assert(err != nil)
+
+	// 缺少SPS引用的VPS
+	sets = NewParameterSets()
+	_ = sets.Input(sps)
+	_ = sets.Input(pps)
+	_, _, _, err = sets.Find(0)
+	utils.Assert(err != nil)
+	utils.Assert(record.UpdateFromParameterSets(sps, sps, pps) != nil)
+	utils.Assert(record.ParallelismType == 3 && record.AvgFrameRate == 25*256)
+
+	// 缺少VPS时只从SPS读取
+	data, err := (&HEVCDecoderConfigurationRecord{}).Marshal([][]byte{sps}, [][]byte{sps}, [][]byte{pps})
+	utils.Assert(err == nil && data[1] == 1 && data[12] == 93)
+}
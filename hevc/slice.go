@@ -0,0 +1,277 @@
+package hevc
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/lkmio/avformat/avc"
+	"github.com/lkmio/avformat/bufio"
+	"math/bits"
+)
+
+// SliceHeader slice_segment_header, 参考7.3.6.1. 只解析到slice_temporal_mvp_enabled_flag
+type SliceHeader struct {
+	NalUnitType HEVCNALUnitType
+	TemporalId  uint
+
+	FirstSliceSegmentInPicFlag uint
+	NoOutputOfPriorPicsFlag    uint
+	PPSId                      uint
+	DependentSliceSegmentFlag  uint
+	SliceSegmentAddress        uint
+
+	// 非独立slice segment不携带以下字段
+	SliceType                   HEVCSliceType
+	PicOutputFlag               uint
+	ColourPlaneId               uint
+	PicOrderCntLsb              uint
+	ShortTermRefPicSetSpsFlag   uint
+	ShortTermRefPicSetIdx       uint
+	ShortTermRefPicSet          *ShortTermRefPicSet // 当前图像使用的短期参考集, IDR为nil
+	NumLongTermSps              uint
+	NumLongTermPics             uint
+	SliceTemporalMvpEnabledFlag uint
+}
+
+// IsIRAP BLA、IDR、CRA
+func (h *SliceHeader) IsIRAP() bool {
+	return h.NalUnitType >= HevcNalBlaWLP && h.NalUnitType <= HevcNalRsvIRAPVCL23
+}
+
+func (h *SliceHeader) IsIDR() bool {
+	return HevcNalIdrWRADL == h.NalUnitType || HevcNalIdrNLP == h.NalUnitType
+}
+
+func (h *SliceHeader) IsBLA() bool {
+	return h.NalUnitType >= HevcNalBlaWLP && h.NalUnitType <= HevcNalBlaNLP
+}
+
+// IsRASL 依赖于前一个IRAP之前图像的前置图像
+func (h *SliceHeader) IsRASL() bool {
+	return HevcNalRASLN == h.NalUnitType || HevcNalRASLR == h.NalUnitType
+}
+
+func (h *SliceHeader) IsRADL() bool {
+	return HevcNalRADLN == h.NalUnitType || HevcNalRADLR == h.NalUnitType
+}
+
+// IsSubLayerNonReference 不被同一时域层的图像参考
+func (h *SliceHeader) IsSubLayerNonReference() bool {
+	return h.NalUnitType <= HevcNalVclN14 && h.NalUnitType%2 == 0
+}
+
+// IsFirstSliceOfPicture 是否为图像的第一个slice segment
+func (h *SliceHeader) IsFirstSliceOfPicture() bool {
+	return h.FirstSliceSegmentInPicFlag != 0
+}
+
+// ceilLog2 返回Ceil(Log2(n))
+func ceilLog2(n uint) int {
+	if n <= 1 {
+		return 0
+	}
+	return bits.Len(n - 1)
+}
+
+// ParseSliceHeader 解析slice segment头, 使用sets查找引用的PPS和SPS
+func ParseSliceHeader(nalu []byte, sets *ParameterSets) (*SliceHeader, *HEVCSPSInfo, error) {
+	nalu = avc.RemoveStartCode(nalu)
+	if len(nalu) < 3 {
+		return nil, nil, fmt.Errorf("invalid slice size %d", len(nalu))
+	}
+
+	h := &SliceHeader{NalUnitType: HEVCNALUnitType(nalu[0] >> 1 & 0x3F), TemporalId: uint(nalu[1]&0x7) - 1}
+	if h.NalUnitType > HevcNalRsvVCL31 {
+		return nil, nil, fmt.Errorf("unsupported slice nal unit type %d", h.NalUnitType)
+	}
+
+	// slice头不会很长, 避免拷贝整个slice
+	br := &bufio.GolombBitReader{R: bytes.NewReader(nal2rbsp(nalu[2:bufio.MinInt(len(nalu), 512)]))}
+	sps, err := h.parse(br, sets)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, sps, nil
+}
+
+func (h *SliceHeader) parse(br *bufio.GolombBitReader, sets *ParameterSets) (sps *HEVCSPSInfo, err error) {
+	if h.FirstSliceSegmentInPicFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if h.IsIRAP() {
+		if h.NoOutputOfPriorPicsFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+	}
+	if h.PPSId, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+
+	var pps *PPS
+	if pps, sps, _, err = sets.Find(h.PPSId); err != nil {
+		return
+	}
+
+	if h.FirstSliceSegmentInPicFlag == 0 {
+		if pps.DependentSliceSegmentsEnabledFlag != 0 {
+			if h.DependentSliceSegmentFlag, err = br.ReadBit(); err != nil {
+				return
+			}
+		}
+		if h.SliceSegmentAddress, err = br.ReadBits(ceilLog2(sps.PicSizeInCtbsY())); err != nil {
+			return
+		}
+	}
+	if h.DependentSliceSegmentFlag != 0 {
+		return
+	}
+
+	// slice_reserved_flag
+	if _, err = br.ReadBits(int(pps.NumExtraSliceHeaderBits)); err != nil {
+		return
+	}
+
+	var sliceType uint
+	if sliceType, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	} else if sliceType > uint(HevcSliceI) {
+		return nil, fmt.Errorf("invalid slice_type %d", sliceType)
+	}
+	h.SliceType = HEVCSliceType(sliceType)
+
+	h.PicOutputFlag = 1
+	if pps.OutputFlagPresentFlag != 0 {
+		if h.PicOutputFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+	}
+	if sps.SeparateColourPlaneFlag != 0 {
+		if h.ColourPlaneId, err = br.ReadBits(2); err != nil {
+			return
+		}
+	}
+	if h.IsIDR() {
+		return
+	}
+
+	if h.PicOrderCntLsb, err = br.ReadBits(int(sps.Log2MaxPicOrderCntLsbMinus4 + 4)); err != nil {
+		return
+	}
+	if h.ShortTermRefPicSetSpsFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+
+	num := uint(len(sps.ShortTermRefPicSets))
+	if h.ShortTermRefPicSetSpsFlag == 0 {
+		var set ShortTermRefPicSet
+		if set, err = parseShortTermRefPicSet(br, num, num, sps.ShortTermRefPicSets); err != nil {
+			return
+		}
+		h.ShortTermRefPicSet = &set
+	} else if num == 0 {
+		return nil, fmt.Errorf("sps %d has no short_term_ref_pic_set", sps.SPSId)
+	} else {
+		if num > 1 {
+			if h.ShortTermRefPicSetIdx, err = br.ReadBits(ceilLog2(num)); err != nil {
+				return
+			} else if h.ShortTermRefPicSetIdx >= num {
+				return nil, fmt.Errorf("invalid short_term_ref_pic_set_idx %d", h.ShortTermRefPicSetIdx)
+			}
+		}
+		h.ShortTermRefPicSet = &sps.ShortTermRefPicSets[h.ShortTermRefPicSetIdx]
+	}
+
+	if sps.LongTermRefPicsPresentFlag != 0 {
+		if err = h.skipLongTermRefPics(br, sps); err != nil {
+			return
+		}
+	}
+
+	if sps.TemporalMvpEnabledFlag != 0 {
+		h.SliceTemporalMvpEnabledFlag, err = br.ReadBit()
+	}
+	return
+}
+
+func (h *SliceHeader) skipLongTermRefPics(br *bufio.GolombBitReader, sps *HEVCSPSInfo) (err error) {
+	numLongTermRefPicsSps := uint(len(sps.LtRefPicPocLsbSps))
+	if numLongTermRefPicsSps > 0 {
+		if h.NumLongTermSps, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		} else if h.NumLongTermSps > numLongTermRefPicsSps {
+			return fmt.Errorf("invalid num_long_term_sps %d", h.NumLongTermSps)
+		}
+	}
+	if h.NumLongTermPics, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	} else if h.NumLongTermSps+h.NumLongTermPics > 32 {
+		return fmt.Errorf("invalid num_long_term_pics %d", h.NumLongTermPics)
+	}
+
+	for i := uint(0); i < h.NumLongTermSps+h.NumLongTermPics; i++ {
+		if i < h.NumLongTermSps {
+			// lt_idx_sps
+			if _, err = br.ReadBits(ceilLog2(numLongTermRefPicsSps)); err != nil {
+				return
+			}
+		} else {
+			// poc_lsb_lt, used_by_curr_pic_lt_flag
+			if _, err = br.ReadBits(int(sps.Log2MaxPicOrderCntLsbMinus4 + 4)); err != nil {
+				return
+			}
+			if _, err = br.ReadBit(); err != nil {
+				return
+			}
+		}
+
+		var delta_poc_msb_present_flag uint
+		if delta_poc_msb_present_flag, err = br.ReadBit(); err != nil {
+			return
+		}
+		if delta_poc_msb_present_flag != 0 {
+			// delta_poc_msb_cycle_lt
+			if _, err = br.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// PicOrderCounter 根据8.3.1计算POC, 需要按照解码顺序输入每个图像的第一个slice segment
+type PicOrderCounter struct {
+	prevTid0Poc int
+	started     bool // 是否收到过IRAP, 之后的CRA不再重置POC
+}
+
+// Reset 遇到EOS等情况时调用, 下一个CRA按照序列的第一个图像处理
+func (c *PicOrderCounter) Reset() {
+	c.started = false
+}
+
+func (c *PicOrderCounter) Calculate(h *SliceHeader, sps *HEVCSPSInfo) int {
+	maxLsb := 1 << (sps.Log2MaxPicOrderCntLsbMinus4 + 4)
+	lsb := int(h.PicOrderCntLsb)
+
+	var msb int
+	// NoRaslOutputFlag为1的IRAP, PicOrderCntMsb为0
+	if !(h.IsIRAP() && (h.IsIDR() || h.IsBLA() || !c.started)) {
+		prevLsb := c.prevTid0Poc & (maxLsb - 1)
+		prevMsb := c.prevTid0Poc - prevLsb
+		msb = prevMsb
+		if lsb < prevLsb && prevLsb-lsb >= maxLsb/2 {
+			msb += maxLsb
+		} else if lsb > prevLsb && lsb-prevLsb > maxLsb/2 {
+			msb -= maxLsb
+		}
+	}
+
+	if h.IsIRAP() {
+		c.started = true
+	}
+
+	poc := msb + lsb
+	if h.TemporalId == 0 && !h.IsRASL() && !h.IsRADL() && !h.IsSubLayerNonReference() {
+		c.prevTid0Poc = poc
+	}
+	return poc
+}
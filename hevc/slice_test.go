@@ -0,0 +1,108 @@
+package hevc
+
+import (
+	"encoding/hex"
+	"github.com/lkmio/avformat/bufio"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+type testSlice struct {
+	nalType         HEVCNALUnitType
+	sliceType       HEVCSliceType
+	pocLsb, address uint64
+	tid             uint64
+}
+
+// 按照测试用的SPS/PPS构造slice segment头: log2_max_pic_order_cnt_lsb为8, PicSizeInCtbsY为240
+func (s testSlice) marshal() []byte {
+	w := &bufio.BitsWriter{Data: make([]byte, 32)}
+	w.Write(32, 1)
+	w.Write(8, uint64(s.nalType)<<1)
+	w.Write(8, s.tid+1)
+
+	if s.address == 0 {
+		w.Write(1, 1)
+	} else {
+		w.Write(1, 0)
+	}
+	if s.nalType >= HevcNalBlaWLP && s.nalType <= HevcNalRsvIRAPVCL23 {
+		w.Write(1, 0) // no_output_of_prior_pics_flag
+	}
+	writeUE(w, 0) // slice_pic_parameter_set_id
+	if s.address != 0 {
+		w.Write(8, s.address)
+	}
+	writeUE(w, uint64(s.sliceType))
+
+	if HevcNalIdrWRADL != s.nalType && HevcNalIdrNLP != s.nalType {
+		w.Write(8, s.pocLsb)
+		w.Write(1, 0) // short_term_ref_pic_set_sps_flag
+		// st_ref_pic_set(0), 一个前向参考图像
+		writeUE(w, 1)
+		writeUE(w, 0)
+		writeUE(w, 0)
+		w.Write(1, 1)
+		w.Write(1, 1) // slice_temporal_mvp_enabled_flag
+	}
+
+	w.Write(16, 0xFFFF)
+	return w.Data[:(w.Offset+7)/8]
+}
+
+func TestParseSliceHeader(t *testing.T) {
+	sets := NewParameterSets()
+	for _, str := range []string{
+		"40010c01ffff01600000030090000003000003005d999809",
+		"42010101600000030090000003000003005da00280802d165999a4932b9a808080820000030002000003003210",
+		"4401c172b46240",
+	} {
+		nalu, _ := hex.DecodeString(str)
+		if err := sets.Input(nalu); err != nil {
+			panic(err)
+		}
+	}
+
+	// lsb从220到20时回绕, 非参考图像和时域层大于0的图像不影响prevTid0Pic, CRA不重置POC, IDR重置POC
+	slices := []testSlice{
+		{nalType: HevcNalIdrWRADL, sliceType: HevcSliceI},
+		{nalType: HevcNalTrailR, sliceType: HevcSliceP, pocLsb: 100},
+		{nalType: HevcNalTrailR, sliceType: HevcSliceP, pocLsb: 220},
+		{nalType: HevcNalTrailN, sliceType: HevcSliceB, pocLsb: 120},
+		{nalType: HevcNalTrailR, sliceType: HevcSliceP, pocLsb: 20},
+		{nalType: HevcNalTrailR, sliceType: HevcSliceB, pocLsb: 10, tid: 1},
+		{nalType: HevcNalCraNUT, sliceType: HevcSliceI, pocLsb: 100},
+		{nalType: HevcNalRASLN, sliceType: HevcSliceB, pocLsb: 90},
+		{nalType: HevcNalIdrNLP, sliceType: HevcSliceI},
+	}
+	expected := []int{0, 100, 220, 120, 276, 266, 356, 346, 0}
+
+	counter := PicOrderCounter{}
+	for i, s := range slices {
+		h, sps, err := ParseSliceHeader(s.marshal(), sets)
+		if err != nil {
+			panic(err)
+		}
+
+		utils.Assert(h.IsFirstSliceOfPicture() && h.NalUnitType == s.nalType && h.SliceType == s.sliceType && h.TemporalId == uint(s.tid))
+		utils.Assert(counter.Calculate(h, sps) == expected[i])
+		if h.IsIDR() {
+			utils.Assert(h.IsIRAP() && h.ShortTermRefPicSet == nil)
+		} else {
+			utils.Assert(h.PicOrderCntLsb == uint(s.pocLsb) && h.SliceTemporalMvpEnabledFlag == 1)
+			utils.Assert(h.ShortTermRefPicSet.NumNegativePics() == 1 && h.ShortTermRefPicSet.DeltaPocS0[0] == -1 && h.ShortTermRefPicSet.NumPositivePics() == 0)
+		}
+	}
+
+	// 同一图像的第二个slice segment
+	h, _, err := ParseSliceHeader(testSlice{nalType: HevcNalTrailR, sliceType: HevcSliceP, pocLsb: 20, address: 120}.marshal(), sets)
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(!h.IsFirstSliceOfPicture() && h.SliceSegmentAddress == 120 && h.PicOrderCntLsb == 20)
+
+	// 第一个CRA按照序列起点处理
+	counter = PicOrderCounter{}
+	h, sps, _ := ParseSliceHeader(slices[6].marshal(), sets)
+	utils.Assert(counter.Calculate(h, sps) == 100)
+}
@@ -8,25 +8,135 @@ import (
 	"github.com/lkmio/avformat/bufio"
 )
 
+// ShortTermRefPicSet st_ref_pic_set, 保存推导后的DeltaPoc, 参考7.4.8
+type ShortTermRefPicSet struct {
+	InterRefPicSetPredictionFlag uint
+	DeltaPocS0                   []int
+	UsedByCurrPicS0              []uint
+	DeltaPocS1                   []int
+	UsedByCurrPicS1              []uint
+}
+
+func (s *ShortTermRefPicSet) NumNegativePics() int {
+	return len(s.DeltaPocS0)
+}
+
+func (s *ShortTermRefPicSet) NumPositivePics() int {
+	return len(s.DeltaPocS1)
+}
+
+func (s *ShortTermRefPicSet) NumDeltaPocs() int {
+	return len(s.DeltaPocS0) + len(s.DeltaPocS1)
+}
+
+// RangeExtension sps_range_extension
+type RangeExtension struct {
+	TransformSkipRotationEnabledFlag    uint
+	TransformSkipContextEnabledFlag     uint
+	ImplicitRdpcmEnabledFlag            uint
+	ExplicitRdpcmEnabledFlag            uint
+	ExtendedPrecisionProcessingFlag     uint
+	IntraSmoothingDisabledFlag          uint
+	HighPrecisionOffsetsEnabledFlag     uint
+	PersistentRiceAdaptationEnabledFlag uint
+	CabacBypassAlignmentEnabledFlag     uint
+}
+
 type HEVCSPSInfo struct {
-	//avc.SPS
-
-	numTemporalLayers                uint
-	temporalIdNested                 uint
-	chromaFormat                     uint
-	PicWidthInLumaSamples            uint
-	PicHeightInLumaSamples           uint
-	bitDepthLumaMinus8               uint
-	bitDepthChromaMinus8             uint
-	generalProfileSpace              uint
-	generalTierFlag                  uint
-	generalProfileIDC                uint
-	generalProfileCompatibilityFlags uint32
-	generalConstraintIndicatorFlags  uint64
-	generalLevelIDC                  uint
-	fps                              uint
-	Width                            int
-	Height                           int
+	VPSId                            uint
+	MaxSubLayersMinus1               uint
+	NumTemporalLayers                uint
+	TemporalIdNested                 uint
+	GeneralProfileSpace              uint
+	GeneralTierFlag                  uint
+	GeneralProfileIDC                uint
+	GeneralProfileCompatibilityFlags uint32
+	GeneralConstraintIndicatorFlags  uint64
+	GeneralLevelIDC                  uint
+
+	SPSId                   uint
+	ChromaFormat            uint
+	SeparateColourPlaneFlag uint
+	PicWidthInLumaSamples   uint
+	PicHeightInLumaSamples  uint
+
+	ConformanceWindowFlag uint
+	ConfWinLeftOffset     uint
+	ConfWinRightOffset    uint
+	ConfWinTopOffset      uint
+	ConfWinBottomOffset   uint
+
+	BitDepthLumaMinus8          uint
+	BitDepthChromaMinus8        uint
+	Log2MaxPicOrderCntLsbMinus4 uint
+
+	// 下标为时域层
+	MaxDecPicBufferingMinus1 []uint
+	MaxNumReorderPics        []uint
+	MaxLatencyIncreasePlus1  []uint
+
+	Log2MinLumaCodingBlockSizeMinus3     uint
+	Log2DiffMaxMinLumaCodingBlockSize    uint
+	Log2MinLumaTransformBlockSizeMinus2  uint
+	Log2DiffMaxMinLumaTransformBlockSize uint
+	MaxTransformHierarchyDepthInter      uint
+	MaxTransformHierarchyDepthIntra      uint
+
+	ScalingListEnabledFlag          uint
+	AmpEnabledFlag                  uint
+	SampleAdaptiveOffsetEnabledFlag uint
+
+	PCMEnabledFlag                       uint
+	PCMSampleBitDepthLumaMinus1          uint
+	PCMSampleBitDepthChromaMinus1        uint
+	Log2MinPCMLumaCodingBlockSizeMinus3  uint
+	Log2DiffMaxMinPCMLumaCodingBlockSize uint
+	PCMLoopFilterDisabledFlag            uint
+
+	ShortTermRefPicSets []ShortTermRefPicSet
+
+	LongTermRefPicsPresentFlag uint
+	LtRefPicPocLsbSps          []uint
+	UsedByCurrPicLtSpsFlag     []uint
+
+	TemporalMvpEnabledFlag          uint
+	StrongIntraSmoothingEnabledFlag uint
+
+	VUIParametersPresentFlag uint
+	VUI                      VUI
+
+	RangeExtension *RangeExtension // 没有sps_range_extension时为nil
+
+	Width     int     // 裁剪后的宽
+	Height    int     // 裁剪后的高
+	FPS       int     // 四舍五入后的帧率
+	FrameRate float64 // 根据VUI的timing_info计算的帧率, 没有则为0
+}
+
+// BitDepthLuma 亮度位深
+func (s *HEVCSPSInfo) BitDepthLuma() int {
+	return int(s.BitDepthLumaMinus8) + 8
+}
+
+// BitDepthChroma 色度位深
+func (s *HEVCSPSInfo) BitDepthChroma() int {
+	return int(s.BitDepthChromaMinus8) + 8
+}
+
+// IsHDR 转换特性是否为PQ或HLG
+func (s *HEVCSPSInfo) IsHDR() bool {
+	return TransferPQ == s.VUI.TransferCharacteristics || TransferHLG == s.VUI.TransferCharacteristics
+}
+
+// Log2CtbSize CTB大小的log2
+func (s *HEVCSPSInfo) Log2CtbSize() uint {
+	return s.Log2MinLumaCodingBlockSizeMinus3 + 3 + s.Log2DiffMaxMinLumaCodingBlockSize
+}
+
+// PicSizeInCtbsY 一帧包含的CTB数量
+func (s *HEVCSPSInfo) PicSizeInCtbsY() uint {
+	ctbSize := uint(1) << s.Log2CtbSize()
+	return ((s.PicWidthInLumaSamples + ctbSize - 1) / ctbSize) * ((s.PicHeightInLumaSamples + ctbSize - 1) / ctbSize)
 }
 
 func ParseSPS(sps []byte) (ctx HEVCSPSInfo, err error) {
@@ -39,140 +149,471 @@ func ParseSPS(sps []byte) (ctx HEVCSPSInfo, err error) {
 
 	rbsp := nal2rbsp(sps[2:])
 	br := &bufio.GolombBitReader{R: bytes.NewReader(rbsp)}
-	if _, err = br.ReadBits(4); err != nil {
+	if ctx.VPSId, err = br.ReadBits(4); err != nil {
 		return
 	}
-	spsMaxSubLayersMinus1, err := br.ReadBits(3)
-	if err != nil {
+	if ctx.MaxSubLayersMinus1, err = br.ReadBits(3); err != nil {
 		return
 	}
 
-	if spsMaxSubLayersMinus1+1 > ctx.numTemporalLayers {
-		ctx.numTemporalLayers = spsMaxSubLayersMinus1 + 1
-	}
-	if ctx.temporalIdNested, err = br.ReadBit(); err != nil {
+	ctx.NumTemporalLayers = ctx.MaxSubLayersMinus1 + 1
+	if ctx.TemporalIdNested, err = br.ReadBit(); err != nil {
 		return
 	}
-	if err = parsePTL(br, &ctx, spsMaxSubLayersMinus1); err != nil {
+
+	// 兼容标志和约束标志与每层PTL按位与, 初始为全1
+	ctx.GeneralProfileCompatibilityFlags = 0xFFFFFFFF
+	ctx.GeneralConstraintIndicatorFlags = 0xFFFFFFFFFFFF
+	if err = parsePTL(br, &ctx, ctx.MaxSubLayersMinus1); err != nil {
 		return
 	}
-	if _, err = br.ReadExponentialGolombCode(); err != nil {
+	if ctx.SPSId, err = br.ReadExponentialGolombCode(); err != nil {
 		return
 	}
-	var cf uint
-	if cf, err = br.ReadExponentialGolombCode(); err != nil {
+	if ctx.ChromaFormat, err = br.ReadExponentialGolombCode(); err != nil {
 		return
 	}
-	ctx.chromaFormat = uint(cf)
-	if ctx.chromaFormat == 3 {
-		if _, err = br.ReadBit(); err != nil {
+	if ctx.ChromaFormat == 3 {
+		if ctx.SeparateColourPlaneFlag, err = br.ReadBit(); err != nil {
 			return
 		}
 	}
 	if ctx.PicWidthInLumaSamples, err = br.ReadExponentialGolombCode(); err != nil {
 		return
 	}
-	ctx.Width = int(ctx.PicWidthInLumaSamples)
 	if ctx.PicHeightInLumaSamples, err = br.ReadExponentialGolombCode(); err != nil {
 		return
 	}
-	ctx.Height = int(ctx.PicHeightInLumaSamples)
-	conformanceWindowFlag, err := br.ReadBit()
-	if err != nil {
+	if ctx.ConformanceWindowFlag, err = br.ReadBit(); err != nil {
 		return
 	}
-	if conformanceWindowFlag != 0 {
-		if _, err = br.ReadExponentialGolombCode(); err != nil {
+	if ctx.ConformanceWindowFlag != 0 {
+		if ctx.ConfWinLeftOffset, err = br.ReadExponentialGolombCode(); err != nil {
 			return
 		}
-		if _, err = br.ReadExponentialGolombCode(); err != nil {
+		if ctx.ConfWinRightOffset, err = br.ReadExponentialGolombCode(); err != nil {
 			return
 		}
-		if _, err = br.ReadExponentialGolombCode(); err != nil {
+		if ctx.ConfWinTopOffset, err = br.ReadExponentialGolombCode(); err != nil {
 			return
 		}
-		if _, err = br.ReadExponentialGolombCode(); err != nil {
+		if ctx.ConfWinBottomOffset, err = br.ReadExponentialGolombCode(); err != nil {
 			return
 		}
 	}
 
-	var bdlm8 uint
-	if bdlm8, err = br.ReadExponentialGolombCode(); err != nil {
+	// 裁剪单位取决于色度格式
+	subWidthC, subHeightC := uint(1), uint(1)
+	if ctx.ChromaFormat == 1 {
+		subWidthC, subHeightC = 2, 2
+	} else if ctx.ChromaFormat == 2 {
+		subWidthC = 2
+	}
+	ctx.Width = int(ctx.PicWidthInLumaSamples - subWidthC*(ctx.ConfWinLeftOffset+ctx.ConfWinRightOffset))
+	ctx.Height = int(ctx.PicHeightInLumaSamples - subHeightC*(ctx.ConfWinTopOffset+ctx.ConfWinBottomOffset))
+
+	if ctx.BitDepthLumaMinus8, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if ctx.BitDepthChromaMinus8, err = br.ReadExponentialGolombCode(); err != nil {
 		return
 	}
-	ctx.bitDepthChromaMinus8 = uint(bdlm8)
-	var bdcm8 uint
-	if bdcm8, err = br.ReadExponentialGolombCode(); err != nil {
+	if ctx.Log2MaxPicOrderCntLsbMinus4, err = br.ReadExponentialGolombCode(); err != nil {
 		return
 	}
-	ctx.bitDepthChromaMinus8 = uint(bdcm8)
 
-	_, err = br.ReadExponentialGolombCode()
-	if err != nil {
+	if err = parseSubLayerOrderingInfo(br, ctx.MaxSubLayersMinus1, &ctx.MaxDecPicBufferingMinus1, &ctx.MaxNumReorderPics, &ctx.MaxLatencyIncreasePlus1); err != nil {
 		return
 	}
-	spsSubLayerOrderingInfoPresentFlag, err := br.ReadBit()
-	if err != nil {
+
+	if ctx.Log2MinLumaCodingBlockSizeMinus3, err = br.ReadExponentialGolombCode(); err != nil {
 		return
 	}
-	var i uint
-	if spsSubLayerOrderingInfoPresentFlag != 0 {
-		i = 0
-	} else {
-		i = spsMaxSubLayersMinus1
+	if ctx.Log2DiffMaxMinLumaCodingBlockSize, err = br.ReadExponentialGolombCode(); err != nil {
+		return
 	}
-	for ; i <= spsMaxSubLayersMinus1; i++ {
-		if _, err = br.ReadExponentialGolombCode(); err != nil {
+	if ctx.Log2MinLumaTransformBlockSizeMinus2, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if ctx.Log2DiffMaxMinLumaTransformBlockSize, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if ctx.MaxTransformHierarchyDepthInter, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if ctx.MaxTransformHierarchyDepthIntra, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+
+	if ctx.ScalingListEnabledFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if ctx.ScalingListEnabledFlag != 0 {
+		var sps_scaling_list_data_present_flag uint
+		if sps_scaling_list_data_present_flag, err = br.ReadBit(); err != nil {
+			return
+		}
+		if sps_scaling_list_data_present_flag != 0 {
+			if err = skipScalingListData(br); err != nil {
+				return
+			}
+		}
+	}
+
+	if ctx.AmpEnabledFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if ctx.SampleAdaptiveOffsetEnabledFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if ctx.PCMEnabledFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if ctx.PCMEnabledFlag != 0 {
+		if ctx.PCMSampleBitDepthLumaMinus1, err = br.ReadBits(4); err != nil {
+			return
+		}
+		if ctx.PCMSampleBitDepthChromaMinus1, err = br.ReadBits(4); err != nil {
+			return
+		}
+		if ctx.Log2MinPCMLumaCodingBlockSizeMinus3, err = br.ReadExponentialGolombCode(); err != nil {
 			return
 		}
-		if _, err = br.ReadExponentialGolombCode(); err != nil {
+		if ctx.Log2DiffMaxMinPCMLumaCodingBlockSize, err = br.ReadExponentialGolombCode(); err != nil {
 			return
 		}
-		if _, err = br.ReadExponentialGolombCode(); err != nil {
+		if ctx.PCMLoopFilterDisabledFlag, err = br.ReadBit(); err != nil {
 			return
 		}
 	}
 
-	if _, err = br.ReadExponentialGolombCode(); err != nil {
+	var num_short_term_ref_pic_sets uint
+	if num_short_term_ref_pic_sets, err = br.ReadExponentialGolombCode(); err != nil {
 		return
+	} else if num_short_term_ref_pic_sets > 64 {
+		err = fmt.Errorf("invalid num_short_term_ref_pic_sets %d", num_short_term_ref_pic_sets)
+		return
+	}
+
+	ctx.ShortTermRefPicSets = make([]ShortTermRefPicSet, 0, num_short_term_ref_pic_sets)
+	for i := uint(0); i < num_short_term_ref_pic_sets; i++ {
+		var set ShortTermRefPicSet
+		if set, err = parseShortTermRefPicSet(br, i, num_short_term_ref_pic_sets, ctx.ShortTermRefPicSets); err != nil {
+			return
+		}
+		ctx.ShortTermRefPicSets = append(ctx.ShortTermRefPicSets, set)
 	}
-	if _, err = br.ReadExponentialGolombCode(); err != nil {
+
+	if ctx.LongTermRefPicsPresentFlag, err = br.ReadBit(); err != nil {
 		return
 	}
-	if _, err = br.ReadExponentialGolombCode(); err != nil {
+	if ctx.LongTermRefPicsPresentFlag != 0 {
+		var num_long_term_ref_pics_sps uint
+		if num_long_term_ref_pics_sps, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		} else if num_long_term_ref_pics_sps > 32 {
+			err = fmt.Errorf("invalid num_long_term_ref_pics_sps %d", num_long_term_ref_pics_sps)
+			return
+		}
+
+		ctx.LtRefPicPocLsbSps = make([]uint, num_long_term_ref_pics_sps)
+		ctx.UsedByCurrPicLtSpsFlag = make([]uint, num_long_term_ref_pics_sps)
+		for i := uint(0); i < num_long_term_ref_pics_sps; i++ {
+			if ctx.LtRefPicPocLsbSps[i], err = br.ReadBits(int(ctx.Log2MaxPicOrderCntLsbMinus4 + 4)); err != nil {
+				return
+			}
+			if ctx.UsedByCurrPicLtSpsFlag[i], err = br.ReadBit(); err != nil {
+				return
+			}
+		}
+	}
+
+	if ctx.TemporalMvpEnabledFlag, err = br.ReadBit(); err != nil {
 		return
 	}
-	if _, err = br.ReadExponentialGolombCode(); err != nil {
+	if ctx.StrongIntraSmoothingEnabledFlag, err = br.ReadBit(); err != nil {
 		return
 	}
-	if _, err = br.ReadExponentialGolombCode(); err != nil {
+
+	if ctx.VUIParametersPresentFlag, err = br.ReadBit(); err != nil {
 		return
 	}
-	if _, err = br.ReadExponentialGolombCode(); err != nil {
+	if ctx.VUIParametersPresentFlag != 0 {
+		if err = parseVUI(br, &ctx.VUI, ctx.MaxSubLayersMinus1); err != nil {
+			return
+		}
+	} else {
+		ctx.VUI.setDefault()
+	}
+
+	if ctx.VUI.TimingInfoPresentFlag != 0 && ctx.VUI.NumUnitsInTick != 0 {
+		ctx.FrameRate = float64(ctx.VUI.TimeScale) / float64(ctx.VUI.NumUnitsInTick)
+		// 场编码时每个图像为一场
+		if ctx.VUI.FieldSeqFlag != 0 {
+			ctx.FrameRate /= 2
+		}
+		ctx.FPS = int(ctx.FrameRate + 0.5)
+	}
+
+	var sps_extension_present_flag uint
+	if sps_extension_present_flag, err = br.ReadBit(); err != nil {
 		return
 	}
+	if sps_extension_present_flag != 0 {
+		// sps_range_extension_flag, sps_multilayer_extension_flag, sps_3d_extension_flag, sps_scc_extension_flag, sps_extension_4bits
+		var flags uint
+		if flags, err = br.ReadBits(8); err != nil {
+			return
+		}
+		if flags&0x80 != 0 {
+			if ctx.RangeExtension, err = parseRangeExtension(br); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func parseSubLayerOrderingInfo(br *bufio.GolombBitReader, maxSubLayersMinus1 uint, maxDecPicBufferingMinus1, maxNumReorderPics, maxLatencyIncreasePlus1 *[]uint) error {
+	sub_layer_ordering_info_present_flag, err := br.ReadBit()
+	if err != nil {
+		return err
+	}
+
+	*maxDecPicBufferingMinus1 = make([]uint, maxSubLayersMinus1+1)
+	*maxNumReorderPics = make([]uint, maxSubLayersMinus1+1)
+	*maxLatencyIncreasePlus1 = make([]uint, maxSubLayersMinus1+1)
+
+	var i uint
+	if sub_layer_ordering_info_present_flag == 0 {
+		i = maxSubLayersMinus1
+	}
+	start := i
+	for ; i <= maxSubLayersMinus1; i++ {
+		if (*maxDecPicBufferingMinus1)[i], err = br.ReadExponentialGolombCode(); err != nil {
+			return err
+		}
+		if (*maxNumReorderPics)[i], err = br.ReadExponentialGolombCode(); err != nil {
+			return err
+		}
+		if (*maxLatencyIncreasePlus1)[i], err = br.ReadExponentialGolombCode(); err != nil {
+			return err
+		}
+	}
+
+	// 未出现的低层与最高层相同
+	for i = 0; i < start; i++ {
+		(*maxDecPicBufferingMinus1)[i] = (*maxDecPicBufferingMinus1)[start]
+		(*maxNumReorderPics)[i] = (*maxNumReorderPics)[start]
+		(*maxLatencyIncreasePlus1)[i] = (*maxLatencyIncreasePlus1)[start]
+	}
+	return nil
+}
+
+func skipScalingListData(br *bufio.GolombBitReader) error {
+	for sizeId := 0; sizeId < 4; sizeId++ {
+		step := 1
+		if sizeId == 3 {
+			step = 3
+		}
+
+		for matrixId := 0; matrixId < 6; matrixId += step {
+			scaling_list_pred_mode_flag, err := br.ReadBit()
+			if err != nil {
+				return err
+			}
+
+			if scaling_list_pred_mode_flag == 0 {
+				// scaling_list_pred_matrix_id_delta
+				if _, err = br.ReadExponentialGolombCode(); err != nil {
+					return err
+				}
+				continue
+			}
+
+			coefNum := bufio.MinInt(64, 1<<(4+(sizeId<<1)))
+			if sizeId > 1 {
+				// scaling_list_dc_coef_minus8
+				if _, err = br.ReadSE(); err != nil {
+					return err
+				}
+			}
+			for i := 0; i < coefNum; i++ {
+				// scaling_list_delta_coef
+				if _, err = br.ReadSE(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// parseShortTermRefPicSet 解析第idx个st_ref_pic_set, idx等于SPS中参考集数量num时为切片头中的参考集
+func parseShortTermRefPicSet(br *bufio.GolombBitReader, idx, num uint, sets []ShortTermRefPicSet) (set ShortTermRefPicSet, err error) {
+	if idx != 0 {
+		if set.InterRefPicSetPredictionFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	if set.InterRefPicSetPredictionFlag != 0 {
+		var delta_idx_minus1, delta_rps_sign, abs_delta_rps_minus1 uint
+		if idx == num {
+			if delta_idx_minus1, err = br.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+		}
+		if delta_idx_minus1+1 > idx {
+			err = fmt.Errorf("invalid delta_idx_minus1 %d", delta_idx_minus1)
+			return
+		}
+		if delta_rps_sign, err = br.ReadBit(); err != nil {
+			return
+		}
+		if abs_delta_rps_minus1, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+
+		ref := &sets[idx-(delta_idx_minus1+1)]
+		deltaRps := (1 - 2*int(delta_rps_sign)) * int(abs_delta_rps_minus1+1)
+		numDeltaPocs := ref.NumDeltaPocs()
+		usedByCurrPic := make([]uint, numDeltaPocs+1)
+		useDelta := make([]uint, numDeltaPocs+1)
+		for j := 0; j <= numDeltaPocs; j++ {
+			if usedByCurrPic[j], err = br.ReadBit(); err != nil {
+				return
+			}
+
+			useDelta[j] = 1
+			if usedByCurrPic[j] == 0 {
+				if useDelta[j], err = br.ReadBit(); err != nil {
+					return
+				}
+			}
+		}
+
+		// 7-61
+		numNegative := ref.NumNegativePics()
+		for j := ref.NumPositivePics() - 1; j >= 0; j-- {
+			if dPoc := ref.DeltaPocS1[j] + deltaRps; dPoc < 0 && useDelta[numNegative+j] != 0 {
+				set.DeltaPocS0 = append(set.DeltaPocS0, dPoc)
+				set.UsedByCurrPicS0 = append(set.UsedByCurrPicS0, usedByCurrPic[numNegative+j])
+			}
+		}
+		if deltaRps < 0 && useDelta[numDeltaPocs] != 0 {
+			set.DeltaPocS0 = append(set.DeltaPocS0, deltaRps)
+			set.UsedByCurrPicS0 = append(set.UsedByCurrPicS0, usedByCurrPic[numDeltaPocs])
+		}
+		for j := 0; j < numNegative; j++ {
+			if dPoc := ref.DeltaPocS0[j] + deltaRps; dPoc < 0 && useDelta[j] != 0 {
+				set.DeltaPocS0 = append(set.DeltaPocS0, dPoc)
+				set.UsedByCurrPicS0 = append(set.UsedByCurrPicS0, usedByCurrPic[j])
+			}
+		}
+
+		// 7-62
+		for j := numNegative - 1; j >= 0; j-- {
+			if dPoc := ref.DeltaPocS0[j] + deltaRps; dPoc > 0 && useDelta[j] != 0 {
+				set.DeltaPocS1 = append(set.DeltaPocS1, dPoc)
+				set.UsedByCurrPicS1 = append(set.UsedByCurrPicS1, usedByCurrPic[j])
+			}
+		}
+		if deltaRps > 0 && useDelta[numDeltaPocs] != 0 {
+			set.DeltaPocS1 = append(set.DeltaPocS1, deltaRps)
+			set.UsedByCurrPicS1 = append(set.UsedByCurrPicS1, usedByCurrPic[numDeltaPocs])
+		}
+		for j := 0; j < ref.NumPositivePics(); j++ {
+			if dPoc := ref.DeltaPocS1[j] + deltaRps; dPoc > 0 && useDelta[numNegative+j] != 0 {
+				set.DeltaPocS1 = append(set.DeltaPocS1, dPoc)
+				set.UsedByCurrPicS1 = append(set.UsedByCurrPicS1, usedByCurrPic[numNegative+j])
+			}
+		}
+		return
+	}
+
+	var num_negative_pics, num_positive_pics uint
+	if num_negative_pics, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if num_positive_pics, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if num_negative_pics > 16 || num_positive_pics > 16 {
+		err = fmt.Errorf("invalid st_ref_pic_set num_negative_pics %d num_positive_pics %d", num_negative_pics, num_positive_pics)
+		return
+	}
+
+	set.DeltaPocS0 = make([]int, num_negative_pics)
+	set.UsedByCurrPicS0 = make([]uint, num_negative_pics)
+	poc := 0
+	for i := uint(0); i < num_negative_pics; i++ {
+		var delta_poc_s0_minus1 uint
+		if delta_poc_s0_minus1, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		poc -= int(delta_poc_s0_minus1 + 1)
+		set.DeltaPocS0[i] = poc
+		if set.UsedByCurrPicS0[i], err = br.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	set.DeltaPocS1 = make([]int, num_positive_pics)
+	set.UsedByCurrPicS1 = make([]uint, num_positive_pics)
+	poc = 0
+	for i := uint(0); i < num_positive_pics; i++ {
+		var delta_poc_s1_minus1 uint
+		if delta_poc_s1_minus1, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		poc += int(delta_poc_s1_minus1 + 1)
+		set.DeltaPocS1[i] = poc
+		if set.UsedByCurrPicS1[i], err = br.ReadBit(); err != nil {
+			return
+		}
+	}
 	return
 }
 
+func parseRangeExtension(br *bufio.GolombBitReader) (*RangeExtension, error) {
+	flags, err := br.ReadBits(9)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RangeExtension{
+		TransformSkipRotationEnabledFlag:    flags >> 8 & 1,
+		TransformSkipContextEnabledFlag:     flags >> 7 & 1,
+		ImplicitRdpcmEnabledFlag:            flags >> 6 & 1,
+		ExplicitRdpcmEnabledFlag:            flags >> 5 & 1,
+		ExtendedPrecisionProcessingFlag:     flags >> 4 & 1,
+		IntraSmoothingDisabledFlag:          flags >> 3 & 1,
+		HighPrecisionOffsetsEnabledFlag:     flags >> 2 & 1,
+		PersistentRiceAdaptationEnabledFlag: flags >> 1 & 1,
+		CabacBypassAlignmentEnabledFlag:     flags & 1,
+	}, nil
+}
+
 func parsePTL(br *bufio.GolombBitReader, ctx *HEVCSPSInfo, maxSubLayersMinus1 uint) error {
 	var err error
 	var ptl HEVCSPSInfo
-	if ptl.generalProfileSpace, err = br.ReadBits(2); err != nil {
+	if ptl.GeneralProfileSpace, err = br.ReadBits(2); err != nil {
 		return err
 	}
-	if ptl.generalTierFlag, err = br.ReadBit(); err != nil {
+	if ptl.GeneralTierFlag, err = br.ReadBit(); err != nil {
 		return err
 	}
-	if ptl.generalProfileIDC, err = br.ReadBits(5); err != nil {
+	if ptl.GeneralProfileIDC, err = br.ReadBits(5); err != nil {
 		return err
 	}
-	if ptl.generalProfileCompatibilityFlags, err = br.ReadBits32(32); err != nil {
+	if ptl.GeneralProfileCompatibilityFlags, err = br.ReadBits32(32); err != nil {
 		return err
 	}
-	if ptl.generalConstraintIndicatorFlags, err = br.ReadBits64(48); err != nil {
+	if ptl.GeneralConstraintIndicatorFlags, err = br.ReadBits64(48); err != nil {
 		return err
 	}
-	if ptl.generalLevelIDC, err = br.ReadBits(8); err != nil {
+	if ptl.GeneralLevelIDC, err = br.ReadBits(8); err != nil {
 		return err
 	}
 	updatePTL(ctx, &ptl)
@@ -219,25 +660,25 @@ func parsePTL(br *bufio.GolombBitReader, ctx *HEVCSPSInfo, maxSubLayersMinus1 ui
 }
 
 func updatePTL(ctx, ptl *HEVCSPSInfo) {
-	ctx.generalProfileSpace = ptl.generalProfileSpace
+	ctx.GeneralProfileSpace = ptl.GeneralProfileSpace
 
-	if ptl.generalTierFlag > ctx.generalTierFlag {
-		ctx.generalLevelIDC = ptl.generalLevelIDC
+	if ptl.GeneralTierFlag > ctx.GeneralTierFlag {
+		ctx.GeneralLevelIDC = ptl.GeneralLevelIDC
 
-		ctx.generalTierFlag = ptl.generalTierFlag
+		ctx.GeneralTierFlag = ptl.GeneralTierFlag
 	} else {
-		if ptl.generalLevelIDC > ctx.generalLevelIDC {
-			ctx.generalLevelIDC = ptl.generalLevelIDC
+		if ptl.GeneralLevelIDC > ctx.GeneralLevelIDC {
+			ctx.GeneralLevelIDC = ptl.GeneralLevelIDC
 		}
 	}
 
-	if ptl.generalProfileIDC > ctx.generalProfileIDC {
-		ctx.generalProfileIDC = ptl.generalProfileIDC
+	if ptl.GeneralProfileIDC > ctx.GeneralProfileIDC {
+		ctx.GeneralProfileIDC = ptl.GeneralProfileIDC
 	}
 
-	ctx.generalProfileCompatibilityFlags &= ptl.generalProfileCompatibilityFlags
+	ctx.GeneralProfileCompatibilityFlags &= ptl.GeneralProfileCompatibilityFlags
 
-	ctx.generalConstraintIndicatorFlags &= ptl.generalConstraintIndicatorFlags
+	ctx.GeneralConstraintIndicatorFlags &= ptl.GeneralConstraintIndicatorFlags
 }
 
 func nal2rbsp(nal []byte) []byte {
@@ -0,0 +1,155 @@
+package hevc
+
+import (
+	"github.com/lkmio/avformat/bufio"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+func writeUE(w *bufio.BitsWriter, v uint64) {
+	length := 0
+	for tmp := v + 1; tmp > 1; tmp >>= 1 {
+		length++
+	}
+	w.Write(length, 0)
+	w.Write(length+1, v+1)
+}
+
+// 构造4K 10bit HDR的SPS
+func newHDRSPS() []byte {
+	w := &bufio.BitsWriter{Data: make([]byte, 256)}
+	w.Write(16, 0x4201)
+	w.Write(4, 0) // sps_video_parameter_set_id
+	w.Write(3, 0) // sps_max_sub_layers_minus1
+	w.Write(1, 1)
+	// Main10, level 5.0
+	w.Write(8, 2)
+	w.Write(32, 0x20000000)
+	w.Write(48, 0x900000000000)
+	w.Write(8, 150)
+
+	writeUE(w, 0)    // sps_seq_parameter_set_id
+	writeUE(w, 1)    // chroma_format_idc
+	writeUE(w, 3840) // pic_width_in_luma_samples
+	writeUE(w, 2176) // pic_height_in_luma_samples
+	w.Write(1, 1)    // conformance_window_flag
+	writeUE(w, 0)
+	writeUE(w, 0)
+	writeUE(w, 0)
+	writeUE(w, 8)
+	writeUE(w, 2) // bit_depth_luma_minus8
+	writeUE(w, 2) // bit_depth_chroma_minus8
+	writeUE(w, 4) // log2_max_pic_order_cnt_lsb_minus4
+	w.Write(1, 1)
+	writeUE(w, 4)
+	writeUE(w, 2)
+	writeUE(w, 0)
+	writeUE(w, 0)
+	writeUE(w, 3)
+	writeUE(w, 0)
+	writeUE(w, 3)
+	writeUE(w, 0)
+	writeUE(w, 0)
+
+	// scaling_list_data, 第一个矩阵显式携带系数
+	w.Write(2, 3)
+	w.Write(1, 1)
+	for i := 0; i < 16; i++ {
+		writeUE(w, 0)
+	}
+	for i := 1; i < 20; i++ {
+		w.Write(1, 0)
+		writeUE(w, 0)
+	}
+
+	w.Write(3, 0x6) // amp_enabled_flag, sample_adaptive_offset_enabled_flag, pcm_enabled_flag
+
+	// 两个短期参考集, 第二个由第一个预测
+	writeUE(w, 2)
+	writeUE(w, 2)
+	writeUE(w, 1)
+	writeUE(w, 0)
+	w.Write(1, 1)
+	writeUE(w, 1)
+	w.Write(1, 1)
+	writeUE(w, 1)
+	w.Write(1, 0)
+	w.Write(1, 1) // inter_ref_pic_set_prediction_flag
+	w.Write(1, 1) // delta_rps_sign
+	writeUE(w, 0)
+	w.Write(4, 0xF)
+
+	// 长期参考
+	w.Write(1, 1)
+	writeUE(w, 1)
+	w.Write(8, 5)
+	w.Write(1, 1)
+
+	w.Write(2, 3) // sps_temporal_mvp_enabled_flag, strong_intra_smoothing_enabled_flag
+	w.Write(1, 1) // vui_parameters_present_flag
+	w.Write(1, 1)
+	w.Write(8, 1)
+	w.Write(1, 0)
+	// BT.2020 PQ
+	w.Write(1, 1)
+	w.Write(3, 5)
+	w.Write(1, 0)
+	w.Write(1, 1)
+	w.Write(8, ColourBT2020)
+	w.Write(8, TransferPQ)
+	w.Write(8, 9)
+	w.Write(5, 0) // chroma_loc_info_present_flag ... default_display_window_flag
+	// 59.94fps
+	w.Write(1, 1)
+	w.Write(32, 1001)
+	w.Write(32, 60000)
+	w.Write(1, 0)
+	// NAL HRD, 6.4Mbps
+	w.Write(1, 1)
+	w.Write(3, 0x4)
+	w.Write(8, 0)
+	w.Write(15, 23<<10|23<<5|23)
+	w.Write(1, 1)
+	writeUE(w, 0)
+	writeUE(w, 0)
+	writeUE(w, 99999)
+	writeUE(w, 0)
+	w.Write(1, 0)
+	w.Write(1, 0) // bitstream_restriction_flag
+
+	w.Write(1, 1)
+	w.Write(8, 0x80)
+	w.Write(9, 0x18)
+	w.Write(1, 1)
+	return w.Data[:(w.Offset+7)/8]
+}
+
+func TestParseSPS(t *testing.T) {
+	sps, err := ParseSPS(newHDRSPS())
+	if err != nil {
+		panic(err)
+	}
+
+	utils.Assert(sps.GeneralProfileIDC == 2 && sps.GeneralLevelIDC == 150 && sps.Width == 3840 && sps.Height == 2160)
+	utils.Assert(sps.BitDepthLuma() == 10 && sps.BitDepthChroma() == 10 && sps.IsHDR() && sps.VUI.ColourPrimaries == ColourBT2020)
+	utils.Assert(sps.FPS == 60 && sps.FrameRate > 59.94 && sps.FrameRate < 59.95 && sps.VUI.SarWidth == 1 && sps.VUI.SarHeight == 1)
+	utils.Assert(sps.MaxDecPicBufferingMinus1[0] == 4 && sps.MaxNumReorderPics[0] == 2 && sps.Log2CtbSize() == 6)
+	utils.Assert(sps.ScalingListEnabledFlag == 1 && sps.AmpEnabledFlag == 1 && sps.SampleAdaptiveOffsetEnabledFlag == 1)
+
+	utils.Assert(len(sps.ShortTermRefPicSets) == 2)
+	set := sps.ShortTermRefPicSets[0]
+	utils.Assert(len(set.DeltaPocS0) == 2 && set.DeltaPocS0[0] == -1 && set.DeltaPocS0[1] == -3 && set.DeltaPocS1[0] == 2 && set.UsedByCurrPicS1[0] == 0)
+	set = sps.ShortTermRefPicSets[1]
+	utils.Assert(set.InterRefPicSetPredictionFlag == 1 && set.NumNegativePics() == 3 && set.NumPositivePics() == 1)
+	utils.Assert(set.DeltaPocS0[0] == -1 && set.DeltaPocS0[1] == -2 && set.DeltaPocS0[2] == -4 && set.DeltaPocS1[0] == 1)
+
+	utils.Assert(sps.LongTermRefPicsPresentFlag == 1 && sps.LtRefPicPocLsbSps[0] == 5 && sps.UsedByCurrPicLtSpsFlag[0] == 1)
+	utils.Assert(sps.VUI.HRD != nil && sps.VUI.HRD.BitRate(0) == 6400000 && sps.VUI.HRD.FixedPicRateWithinCvsFlag[0] == 1)
+	utils.Assert(sps.RangeExtension != nil && sps.RangeExtension.ExtendedPrecisionProcessingFlag == 1 && sps.RangeExtension.IntraSmoothingDisabledFlag == 1)
+
+	record := HEVCDecoderConfigurationRecord{}
+	if err = record.UpdateFromSPS(newHDRSPS()); err != nil {
+		panic(err)
+	}
+	utils.Assert(record.BitDepthLumaMinus8 == 2 && record.GeneralProfileIdc == 2 && record.ChromaFormat == 1)
+}
@@ -0,0 +1,177 @@
+package hevc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/lkmio/avformat/avc"
+	"github.com/lkmio/avformat/bufio"
+)
+
+// VPS video_parameter_set_rbsp, 参考7.3.2.1
+type VPS struct {
+	Id                     uint
+	BaseLayerInternalFlag  uint
+	BaseLayerAvailableFlag uint
+	MaxLayersMinus1        uint
+	MaxSubLayersMinus1     uint
+	TemporalIdNestingFlag  uint
+
+	GeneralProfileSpace              uint
+	GeneralTierFlag                  uint
+	GeneralProfileIDC                uint
+	GeneralProfileCompatibilityFlags uint32
+	GeneralConstraintIndicatorFlags  uint64
+	GeneralLevelIDC                  uint
+
+	// 下标为时域层
+	MaxDecPicBufferingMinus1 []uint
+	MaxNumReorderPics        []uint
+	MaxLatencyIncreasePlus1  []uint
+
+	MaxLayerId         uint
+	NumLayerSetsMinus1 uint
+	LayerIdIncluded    [][]uint // 下标从1开始, layer set 0只包含基本层
+
+	TimingInfoPresentFlag       uint
+	NumUnitsInTick              uint
+	TimeScale                   uint
+	PocProportionalToTimingFlag uint
+	NumTicksPocDiffOneMinus1    uint
+	HRDLayerSetIdx              []uint
+	HRD                         []*HRD
+
+	ExtensionFlag uint
+}
+
+// FrameRate 根据timing_info计算帧率, 没有则为0
+func (v *VPS) FrameRate() float64 {
+	if v.TimingInfoPresentFlag == 0 || v.NumUnitsInTick == 0 {
+		return 0
+	}
+	return float64(v.TimeScale) / float64(v.NumUnitsInTick)
+}
+
+func ParseVPS(vps []byte) (v VPS, err error) {
+	vps = avc.RemoveStartCode(vps)
+	if len(vps) < 2 {
+		err = errors.New("incorrect Unit Size")
+		return
+	}
+
+	br := &bufio.GolombBitReader{R: bytes.NewReader(nal2rbsp(vps[2:]))}
+	if v.Id, err = br.ReadBits(4); err != nil {
+		return
+	}
+	if v.BaseLayerInternalFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if v.BaseLayerAvailableFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if v.MaxLayersMinus1, err = br.ReadBits(6); err != nil {
+		return
+	}
+	if v.MaxSubLayersMinus1, err = br.ReadBits(3); err != nil {
+		return
+	} else if v.MaxSubLayersMinus1 > 6 {
+		err = fmt.Errorf("invalid vps_max_sub_layers_minus1 %d", v.MaxSubLayersMinus1)
+		return
+	}
+	if v.TemporalIdNestingFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+
+	var reserved uint
+	if reserved, err = br.ReadBits(16); err != nil {
+		return
+	} else if reserved != 0xFFFF {
+		err = fmt.Errorf("invalid vps_reserved_0xffff_16bits %x", reserved)
+		return
+	}
+
+	// 复用SPS的PTL解析
+	ptl := HEVCSPSInfo{GeneralProfileCompatibilityFlags: 0xFFFFFFFF, GeneralConstraintIndicatorFlags: 0xFFFFFFFFFFFF}
+	if err = parsePTL(br, &ptl, v.MaxSubLayersMinus1); err != nil {
+		return
+	}
+	v.GeneralProfileSpace = ptl.GeneralProfileSpace
+	v.GeneralTierFlag = ptl.GeneralTierFlag
+	v.GeneralProfileIDC = ptl.GeneralProfileIDC
+	v.GeneralProfileCompatibilityFlags = ptl.GeneralProfileCompatibilityFlags
+	v.GeneralConstraintIndicatorFlags = ptl.GeneralConstraintIndicatorFlags
+	v.GeneralLevelIDC = ptl.GeneralLevelIDC
+
+	if err = parseSubLayerOrderingInfo(br, v.MaxSubLayersMinus1, &v.MaxDecPicBufferingMinus1, &v.MaxNumReorderPics, &v.MaxLatencyIncreasePlus1); err != nil {
+		return
+	}
+
+	if v.MaxLayerId, err = br.ReadBits(6); err != nil {
+		return
+	}
+	if v.NumLayerSetsMinus1, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	} else if v.NumLayerSetsMinus1 > 1023 {
+		err = fmt.Errorf("invalid vps_num_layer_sets_minus1 %d", v.NumLayerSetsMinus1)
+		return
+	}
+
+	v.LayerIdIncluded = make([][]uint, v.NumLayerSetsMinus1+1)
+	for i := uint(1); i <= v.NumLayerSetsMinus1; i++ {
+		v.LayerIdIncluded[i] = make([]uint, v.MaxLayerId+1)
+		for j := uint(0); j <= v.MaxLayerId; j++ {
+			if v.LayerIdIncluded[i][j], err = br.ReadBit(); err != nil {
+				return
+			}
+		}
+	}
+
+	if v.TimingInfoPresentFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if v.TimingInfoPresentFlag != 0 {
+		if v.NumUnitsInTick, err = br.ReadBits(32); err != nil {
+			return
+		}
+		if v.TimeScale, err = br.ReadBits(32); err != nil {
+			return
+		}
+		if v.PocProportionalToTimingFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+		if v.PocProportionalToTimingFlag != 0 {
+			if v.NumTicksPocDiffOneMinus1, err = br.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+		}
+
+		var vps_num_hrd_parameters uint
+		if vps_num_hrd_parameters, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		} else if vps_num_hrd_parameters > v.NumLayerSetsMinus1+1 {
+			err = fmt.Errorf("invalid vps_num_hrd_parameters %d", vps_num_hrd_parameters)
+			return
+		}
+
+		v.HRDLayerSetIdx = make([]uint, vps_num_hrd_parameters)
+		v.HRD = make([]*HRD, vps_num_hrd_parameters)
+		for i := uint(0); i < vps_num_hrd_parameters; i++ {
+			if v.HRDLayerSetIdx[i], err = br.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+
+			cprms_present_flag := uint(1)
+			if i > 0 {
+				if cprms_present_flag, err = br.ReadBit(); err != nil {
+					return
+				}
+			}
+			if v.HRD[i], err = parseHRD(br, cprms_present_flag, v.MaxSubLayersMinus1); err != nil {
+				return
+			}
+		}
+	}
+
+	v.ExtensionFlag, err = br.ReadBit()
+	return
+}
@@ -0,0 +1,428 @@
+package hevc
+
+import (
+	"fmt"
+	"github.com/lkmio/avformat/bufio"
+)
+
+// 预定义的像素宽高比, 参考Table E-1
+var sampleAspectRatios = [][2]uint{
+	{0, 0}, {1, 1}, {12, 11}, {10, 11}, {16, 11}, {40, 33}, {24, 11}, {20, 11}, {32, 11},
+	{80, 33}, {18, 11}, {15, 11}, {64, 33}, {160, 99}, {4, 3}, {3, 2}, {2, 1},
+}
+
+const (
+	AspectRatioExtendedSAR = 255
+
+	// ColourUnspecified 未指定的色彩原色/转换特性/矩阵系数
+	ColourUnspecified = 2
+	ColourBT709       = 1
+	ColourBT2020      = 9
+
+	TransferPQ  = 16 // SMPTE ST 2084
+	TransferHLG = 18 // ARIB STD-B67
+)
+
+// SubLayerHRD sub_layer_hrd_parameters
+type SubLayerHRD struct {
+	BitRateValueMinus1   []uint
+	CpbSizeValueMinus1   []uint
+	CpbSizeDuValueMinus1 []uint
+	BitRateDuValueMinus1 []uint
+	CbrFlag              []uint
+}
+
+// HRD hrd_parameters, 参考E.2.2
+type HRD struct {
+	NalHRDParametersPresentFlag            uint
+	VclHRDParametersPresentFlag            uint
+	SubPicHRDParamsPresentFlag             uint
+	TickDivisorMinus2                      uint
+	DuCpbRemovalDelayIncrementLengthMinus1 uint
+	SubPicCpbParamsInPicTimingSeiFlag      uint
+	DpbOutputDelayDuLengthMinus1           uint
+	BitRateScale                           uint
+	CpbSizeScale                           uint
+	CpbSizeDuScale                         uint
+	InitialCpbRemovalDelayLengthMinus1     uint
+	AuCpbRemovalDelayLengthMinus1          uint
+	DpbOutputDelayLengthMinus1             uint
+
+	// 下标为时域层
+	FixedPicRateGeneralFlag     []uint
+	FixedPicRateWithinCvsFlag   []uint
+	ElementalDurationInTcMinus1 []uint
+	LowDelayHRDFlag             []uint
+	CpbCntMinus1                []uint
+	NalSubLayers                []*SubLayerHRD
+	VclSubLayers                []*SubLayerHRD
+}
+
+// BitRate 返回最高时域层第i个CPB的码率, 单位bit/s. 优先使用NAL HRD
+func (h *HRD) BitRate(i int) uint {
+	layers := h.NalSubLayers
+	if h.NalHRDParametersPresentFlag == 0 {
+		layers = h.VclSubLayers
+	}
+
+	if len(layers) == 0 || layers[len(layers)-1] == nil || i >= len(layers[len(layers)-1].BitRateValueMinus1) {
+		return 0
+	}
+	return (layers[len(layers)-1].BitRateValueMinus1[i] + 1) << (6 + h.BitRateScale)
+}
+
+// VUI vui_parameters, 参考E.2.1. 未出现的字段为标准规定的缺省值
+type VUI struct {
+	AspectRatioInfoPresentFlag uint
+	AspectRatioIdc             uint
+	SarWidth                   uint
+	SarHeight                  uint
+
+	OverscanInfoPresentFlag uint
+	OverscanAppropriateFlag uint
+
+	VideoSignalTypePresentFlag   uint
+	VideoFormat                  uint
+	VideoFullRangeFlag           uint
+	ColourDescriptionPresentFlag uint
+	ColourPrimaries              uint
+	TransferCharacteristics      uint
+	MatrixCoeffs                 uint
+
+	ChromaLocInfoPresentFlag       uint
+	ChromaSampleLocTypeTopField    uint
+	ChromaSampleLocTypeBottomField uint
+
+	NeutralChromaIndicationFlag uint
+	FieldSeqFlag                uint
+	FrameFieldInfoPresentFlag   uint
+
+	DefaultDisplayWindowFlag uint
+	DefDispWinLeftOffset     uint
+	DefDispWinRightOffset    uint
+	DefDispWinTopOffset      uint
+	DefDispWinBottomOffset   uint
+
+	TimingInfoPresentFlag       uint
+	NumUnitsInTick              uint
+	TimeScale                   uint
+	PocProportionalToTimingFlag uint
+	NumTicksPocDiffOneMinus1    uint
+	HRDParametersPresentFlag    uint
+	HRD                         *HRD
+
+	BitstreamRestrictionFlag           uint
+	TilesFixedStructureFlag            uint
+	MotionVectorsOverPicBoundariesFlag uint
+	RestrictedRefPicListsFlag          uint
+	MinSpatialSegmentationIdc          uint
+	MaxBytesPerPicDenom                uint
+	MaxBitsPerMinCuDenom               uint
+	Log2MaxMvLengthHorizontal          uint
+	Log2MaxMvLengthVertical            uint
+}
+
+func (v *VUI) setDefault() {
+	// 5-Unspecified video format
+	v.VideoFormat = 5
+	v.ColourPrimaries = ColourUnspecified
+	v.TransferCharacteristics = ColourUnspecified
+	v.MatrixCoeffs = ColourUnspecified
+	v.MotionVectorsOverPicBoundariesFlag = 1
+	v.MaxBytesPerPicDenom = 2
+	v.MaxBitsPerMinCuDenom = 1
+	v.Log2MaxMvLengthHorizontal = 15
+	v.Log2MaxMvLengthVertical = 15
+}
+
+func parseSubLayerHRD(br *bufio.GolombBitReader, cpbCnt uint, subPicHRDParamsPresentFlag uint) (s *SubLayerHRD, err error) {
+	s = &SubLayerHRD{
+		BitRateValueMinus1: make([]uint, cpbCnt),
+		CpbSizeValueMinus1: make([]uint, cpbCnt),
+		CbrFlag:            make([]uint, cpbCnt),
+	}
+	if subPicHRDParamsPresentFlag != 0 {
+		s.CpbSizeDuValueMinus1 = make([]uint, cpbCnt)
+		s.BitRateDuValueMinus1 = make([]uint, cpbCnt)
+	}
+
+	for i := uint(0); i < cpbCnt; i++ {
+		if s.BitRateValueMinus1[i], err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if s.CpbSizeValueMinus1[i], err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if subPicHRDParamsPresentFlag != 0 {
+			if s.CpbSizeDuValueMinus1[i], err = br.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+			if s.BitRateDuValueMinus1[i], err = br.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+		}
+		if s.CbrFlag[i], err = br.ReadBit(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func parseHRD(br *bufio.GolombBitReader, commonInfPresentFlag uint, maxSubLayersMinus1 uint) (h *HRD, err error) {
+	h = &HRD{}
+	if commonInfPresentFlag != 0 {
+		if h.NalHRDParametersPresentFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+		if h.VclHRDParametersPresentFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+
+		if h.NalHRDParametersPresentFlag != 0 || h.VclHRDParametersPresentFlag != 0 {
+			if h.SubPicHRDParamsPresentFlag, err = br.ReadBit(); err != nil {
+				return
+			}
+			if h.SubPicHRDParamsPresentFlag != 0 {
+				if h.TickDivisorMinus2, err = br.ReadBits(8); err != nil {
+					return
+				}
+				if h.DuCpbRemovalDelayIncrementLengthMinus1, err = br.ReadBits(5); err != nil {
+					return
+				}
+				if h.SubPicCpbParamsInPicTimingSeiFlag, err = br.ReadBit(); err != nil {
+					return
+				}
+				if h.DpbOutputDelayDuLengthMinus1, err = br.ReadBits(5); err != nil {
+					return
+				}
+			}
+			if h.BitRateScale, err = br.ReadBits(4); err != nil {
+				return
+			}
+			if h.CpbSizeScale, err = br.ReadBits(4); err != nil {
+				return
+			}
+			if h.SubPicHRDParamsPresentFlag != 0 {
+				if h.CpbSizeDuScale, err = br.ReadBits(4); err != nil {
+					return
+				}
+			}
+			if h.InitialCpbRemovalDelayLengthMinus1, err = br.ReadBits(5); err != nil {
+				return
+			}
+			if h.AuCpbRemovalDelayLengthMinus1, err = br.ReadBits(5); err != nil {
+				return
+			}
+			if h.DpbOutputDelayLengthMinus1, err = br.ReadBits(5); err != nil {
+				return
+			}
+		}
+	}
+
+	count := maxSubLayersMinus1 + 1
+	h.FixedPicRateGeneralFlag = make([]uint, count)
+	h.FixedPicRateWithinCvsFlag = make([]uint, count)
+	h.ElementalDurationInTcMinus1 = make([]uint, count)
+	h.LowDelayHRDFlag = make([]uint, count)
+	h.CpbCntMinus1 = make([]uint, count)
+	h.NalSubLayers = make([]*SubLayerHRD, count)
+	h.VclSubLayers = make([]*SubLayerHRD, count)
+	for i := uint(0); i < count; i++ {
+		if h.FixedPicRateGeneralFlag[i], err = br.ReadBit(); err != nil {
+			return
+		}
+
+		h.FixedPicRateWithinCvsFlag[i] = 1
+		if h.FixedPicRateGeneralFlag[i] == 0 {
+			if h.FixedPicRateWithinCvsFlag[i], err = br.ReadBit(); err != nil {
+				return
+			}
+		}
+
+		if h.FixedPicRateWithinCvsFlag[i] != 0 {
+			if h.ElementalDurationInTcMinus1[i], err = br.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+		} else if h.LowDelayHRDFlag[i], err = br.ReadBit(); err != nil {
+			return
+		}
+
+		if h.LowDelayHRDFlag[i] == 0 {
+			if h.CpbCntMinus1[i], err = br.ReadExponentialGolombCode(); err != nil {
+				return
+			} else if h.CpbCntMinus1[i] > 31 {
+				return nil, fmt.Errorf("invalid cpb_cnt_minus1 %d", h.CpbCntMinus1[i])
+			}
+		}
+
+		if h.NalHRDParametersPresentFlag != 0 {
+			if h.NalSubLayers[i], err = parseSubLayerHRD(br, h.CpbCntMinus1[i]+1, h.SubPicHRDParamsPresentFlag); err != nil {
+				return
+			}
+		}
+		if h.VclHRDParametersPresentFlag != 0 {
+			if h.VclSubLayers[i], err = parseSubLayerHRD(br, h.CpbCntMinus1[i]+1, h.SubPicHRDParamsPresentFlag); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func parseVUI(br *bufio.GolombBitReader, v *VUI, maxSubLayersMinus1 uint) (err error) {
+	v.setDefault()
+	if v.AspectRatioInfoPresentFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if v.AspectRatioInfoPresentFlag != 0 {
+		if v.AspectRatioIdc, err = br.ReadBits(8); err != nil {
+			return
+		}
+
+		if v.AspectRatioIdc == AspectRatioExtendedSAR {
+			if v.SarWidth, err = br.ReadBits(16); err != nil {
+				return
+			}
+			if v.SarHeight, err = br.ReadBits(16); err != nil {
+				return
+			}
+		} else if v.AspectRatioIdc < uint(len(sampleAspectRatios)) {
+			v.SarWidth = sampleAspectRatios[v.AspectRatioIdc][0]
+			v.SarHeight = sampleAspectRatios[v.AspectRatioIdc][1]
+		}
+	}
+
+	if v.OverscanInfoPresentFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if v.OverscanInfoPresentFlag != 0 {
+		if v.OverscanAppropriateFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	if v.VideoSignalTypePresentFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if v.VideoSignalTypePresentFlag != 0 {
+		if v.VideoFormat, err = br.ReadBits(3); err != nil {
+			return
+		}
+		if v.VideoFullRangeFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+		if v.ColourDescriptionPresentFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+		if v.ColourDescriptionPresentFlag != 0 {
+			if v.ColourPrimaries, err = br.ReadBits(8); err != nil {
+				return
+			}
+			if v.TransferCharacteristics, err = br.ReadBits(8); err != nil {
+				return
+			}
+			if v.MatrixCoeffs, err = br.ReadBits(8); err != nil {
+				return
+			}
+		}
+	}
+
+	if v.ChromaLocInfoPresentFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if v.ChromaLocInfoPresentFlag != 0 {
+		if v.ChromaSampleLocTypeTopField, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if v.ChromaSampleLocTypeBottomField, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+
+	if v.NeutralChromaIndicationFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if v.FieldSeqFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if v.FrameFieldInfoPresentFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+
+	if v.DefaultDisplayWindowFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if v.DefaultDisplayWindowFlag != 0 {
+		if v.DefDispWinLeftOffset, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if v.DefDispWinRightOffset, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if v.DefDispWinTopOffset, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if v.DefDispWinBottomOffset, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+
+	if v.TimingInfoPresentFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if v.TimingInfoPresentFlag != 0 {
+		if v.NumUnitsInTick, err = br.ReadBits(32); err != nil {
+			return
+		}
+		if v.TimeScale, err = br.ReadBits(32); err != nil {
+			return
+		}
+		if v.PocProportionalToTimingFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+		if v.PocProportionalToTimingFlag != 0 {
+			if v.NumTicksPocDiffOneMinus1, err = br.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+		}
+		if v.HRDParametersPresentFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+		if v.HRDParametersPresentFlag != 0 {
+			if v.HRD, err = parseHRD(br, 1, maxSubLayersMinus1); err != nil {
+				return
+			}
+		}
+	}
+
+	if v.BitstreamRestrictionFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if v.BitstreamRestrictionFlag != 0 {
+		if v.TilesFixedStructureFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+		if v.MotionVectorsOverPicBoundariesFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+		if v.RestrictedRefPicListsFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+		if v.MinSpatialSegmentationIdc, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if v.MaxBytesPerPicDenom, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if v.MaxBitsPerMinCuDenom, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if v.Log2MaxMvLengthHorizontal, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if v.Log2MaxMvLengthVertical, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+	return
+}
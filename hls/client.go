@@ -0,0 +1,374 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/fmp4"
+	"github.com/lkmio/avformat/mpegts"
+	"github.com/lkmio/avformat/utils"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultClientTimeout = 10 * time.Second
+
+	// 直播从倒数第几个切片开始拉取
+	liveStartSegments = 3
+)
+
+// Fetcher 下载m3u8, 切片和密钥
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) ([]byte, error)
+}
+
+type FetcherFunc func(ctx context.Context, url string) ([]byte, error)
+
+func (f FetcherFunc) Fetch(ctx context.Context, url string) ([]byte, error) {
+	return f(ctx, url)
+}
+
+// HTTPFetcher 使用http.Client下载
+type HTTPFetcher struct {
+	Client *http.Client
+}
+
+func (f *HTTPFetcher) Fetch(ctx context.Context, url string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+	if http.StatusOK != response.StatusCode {
+		return nil, fmt.Errorf("fetch %s failed: %s", url, response.Status)
+	}
+
+	return io.ReadAll(response.Body)
+}
+
+// 切片的解复用器, TS和fMP4
+type segmentDemuxer interface {
+	Input(data []byte) (int, error)
+	SetHandler(handler avformat.OnUnpackStreamHandler)
+	Discontinuity()
+	Close()
+}
+
+// Client 拉取HLS流, 解析切片后回调给OnUnpackStreamHandler
+type Client struct {
+	url     string
+	fetcher Fetcher
+	timeout time.Duration
+	handler avformat.OnUnpackStreamHandler
+
+	playlistURL  string // 媒体m3u8的地址
+	demuxer      segmentDemuxer
+	initSegment  string            // 已经输入的init segment
+	keys         map[string][]byte // 已经下载的密钥
+	lastSequence int               // 已经输入的最后一个切片序号
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	closed    chan struct{}
+	err       error
+}
+
+func (c *Client) SetHandler(handler avformat.OnUnpackStreamHandler) {
+	c.handler = handler
+}
+
+// SetFetcher 设置下载方式, 默认使用http
+func (c *Client) SetFetcher(fetcher Fetcher) {
+	c.fetcher = fetcher
+}
+
+// SetTimeout 设置每次下载的超时时间
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+// Play 下载m3u8, 成功后在后台拉取切片, 多码率时选择码率最高的一路
+func (c *Client) Play() error {
+	utils.Assert(c.handler != nil)
+
+	playlist, err := c.loadPlaylist()
+	if err != nil {
+		c.close(err)
+		return err
+	}
+
+	// 直播从最新的几个切片开始
+	c.lastSequence = playlist.MediaSequence - 1
+	if !playlist.EndList && PlaylistEvent != playlist.Type && len(playlist.Segments) > liveStartSegments {
+		c.lastSequence = playlist.Segments[len(playlist.Segments)-liveStartSegments-1].Sequence
+	}
+
+	go c.run(playlist)
+	return nil
+}
+
+func (c *Client) fetch(rawURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	defer cancel()
+	return c.fetcher.Fetch(ctx, rawURL)
+}
+
+// 下载媒体m3u8, 第一次下载时处理多码率m3u8
+func (c *Client) loadPlaylist() (*Playlist, error) {
+	if c.playlistURL == "" {
+		c.playlistURL = c.url
+	}
+
+	data, err := c.fetch(c.playlistURL)
+	if err != nil {
+		return nil, err
+	}
+
+	playlist, master, err := ParsePlaylist(data)
+	if err != nil {
+		return nil, err
+	} else if playlist != nil {
+		return playlist, nil
+	} else if c.playlistURL != c.url {
+		return nil, fmt.Errorf("unexpected master playlist %s", c.playlistURL)
+	}
+
+	variant := master.Variants[0]
+	for _, v := range master.Variants[1:] {
+		if v.Bandwidth > variant.Bandwidth {
+			variant = v
+		}
+	}
+
+	if c.playlistURL, err = resolveURL(c.url, variant.URI); err != nil {
+		return nil, err
+	}
+
+	return c.loadPlaylist()
+}
+
+func resolveURL(base, reference string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	referenceURL, err := url.Parse(reference)
+	if err != nil {
+		return "", err
+	}
+
+	return baseURL.ResolveReference(referenceURL).String(), nil
+}
+
+// 拉取切片, 直播按照目标时长刷新m3u8
+func (c *Client) run(playlist *Playlist) {
+	defer func() {
+		if c.demuxer != nil {
+			c.demuxer.Close()
+		}
+	}()
+
+	for {
+		var updated bool
+		for _, segment := range playlist.Segments {
+			if segment.Sequence <= c.lastSequence {
+				continue
+			}
+
+			if err := c.inputSegment(segment); err != nil {
+				c.close(err)
+				return
+			}
+
+			c.lastSequence = segment.Sequence
+			updated = true
+		}
+
+		if playlist.EndList {
+			c.close(nil)
+			return
+		}
+
+		// 没有新的切片时, 以一半的目标时长刷新
+		interval := time.Duration(playlist.TargetDuration) * time.Second
+		if !updated {
+			interval /= 2
+		}
+
+		select {
+		case <-c.closed:
+			return
+		case <-time.After(interval):
+		}
+
+		var err error
+		if playlist, err = c.loadPlaylist(); err != nil {
+			c.close(err)
+			return
+		}
+	}
+}
+
+func (c *Client) inputSegment(segment *Segment) error {
+	segmentURL, err := resolveURL(c.playlistURL, segment.Name)
+	if err != nil {
+		return err
+	}
+
+	if c.demuxer == nil {
+		if segment.Map != "" {
+			c.demuxer = fmp4.NewDemuxer()
+		} else {
+			c.demuxer = mpegts.NewDemuxer()
+		}
+		c.demuxer.SetHandler(c.handler)
+	} else if segment.Discontinuity {
+		c.demuxer.Discontinuity()
+	}
+
+	if segment.Map != "" && segment.Map != c.initSegment {
+		if _, ok := c.demuxer.(*fmp4.Demuxer); !ok {
+			return fmt.Errorf("unexpected init segment %s", segment.Map)
+		} else if err = c.inputInitSegment(segment.Map); err != nil {
+			return err
+		}
+	}
+
+	data, err := c.fetch(segmentURL)
+	if err != nil {
+		return err
+	} else if data, err = c.decrypt(segment, data); err != nil {
+		return err
+	} else if _, err = c.demuxer.Input(data); err != nil {
+		return err
+	}
+
+	// 切片结束时输出所有完整的帧
+	if demuxer, ok := c.demuxer.(*mpegts.Demuxer); ok {
+		demuxer.Flush()
+	}
+
+	return nil
+}
+
+func (c *Client) inputInitSegment(uri string) error {
+	initURL, err := resolveURL(c.playlistURL, uri)
+	if err != nil {
+		return err
+	}
+
+	data, err := c.fetch(initURL)
+	if err != nil {
+		return err
+	} else if _, err = c.demuxer.Input(data); err != nil {
+		return err
+	}
+
+	c.initSegment = uri
+	return nil
+}
+
+// 解密AES-128加密的切片
+func (c *Client) decrypt(segment *Segment, data []byte) ([]byte, error) {
+	if segment.Key == nil {
+		return data, nil
+	} else if segment.Key.Method != "AES-128" {
+		return nil, fmt.Errorf("unsupported encryption method %s", segment.Key.Method)
+	}
+
+	keyURL, err := resolveURL(c.playlistURL, segment.Key.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := c.keys[keyURL]
+	if !ok {
+		if key, err = c.fetch(keyURL); err != nil {
+			return nil, err
+		}
+		c.keys[keyURL] = key
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	} else if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid encrypted segment size %d", len(data))
+	}
+
+	// 没有IV时使用切片序号
+	iv := segment.Key.IV
+	if iv == nil {
+		iv = make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], uint64(segment.Sequence))
+	}
+
+	plaintext := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, data)
+
+	// PKCS7填充
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, fmt.Errorf("invalid pkcs7 padding")
+	}
+
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+func (c *Client) close(err error) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		c.cancel()
+		close(c.closed)
+	})
+}
+
+// Close 停止拉流
+func (c *Client) Close() {
+	c.close(nil)
+}
+
+// Wait 等待拉流结束, 点播全部切片处理完毕或主动关闭时返回nil
+func (c *Client) Wait() error {
+	<-c.closed
+	return c.err
+}
+
+// NewClient 创建客户端, rawURL为媒体m3u8或多码率m3u8的地址
+func NewClient(rawURL string) (*Client, error) {
+	if _, err := url.Parse(rawURL); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Client{
+		url:     rawURL,
+		fetcher: &HTTPFetcher{},
+		timeout: DefaultClientTimeout,
+		keys:    make(map[string][]byte),
+		ctx:     ctx,
+		cancel:  cancel,
+		closed:  make(chan struct{}),
+	}, nil
+}
@@ -0,0 +1,177 @@
+package hls
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/utils"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testHandler struct {
+	tracks  []avformat.Track
+	packets []*avformat.AVPacket
+}
+
+func (h *testHandler) OnNewTrack(track avformat.Track) {
+	h.tracks = append(h.tracks, track)
+}
+
+func (h *testHandler) OnTrackComplete() {
+}
+
+func (h *testHandler) OnTrackNotFind() {
+}
+
+func (h *testHandler) OnPacket(packet *avformat.AVPacket) {
+	h.packets = append(h.packets, &avformat.AVPacket{Dts: packet.Dts, Pts: packet.Pts, Key: packet.Key, Timebase: packet.Timebase})
+}
+
+// 生成150帧的切片, 第100帧开始时间戳回到0
+func newTestSegmenter(format Format) (*MemoryStorage, *Segmenter) {
+	storage := NewMemoryStorage()
+	segmenter := NewSegmenter(storage, format)
+	segmenter.SetTargetDuration(time.Second)
+	segmenter.SetPlaylistType(PlaylistVOD)
+	source := newTestSource(segmenter)
+	source.input(0, 100, 0)
+	source.input(0, 50, 0)
+	segmenter.Close()
+	return storage, segmenter
+}
+
+// 时间戳连续增长, 每帧40ms
+func assertPackets(handler *testHandler, frames int) {
+	utils.Assert(len(handler.tracks) == 1 && handler.tracks[0].GetStream().CodecID == utils.AVCodecIdH264)
+	utils.Assert(len(handler.packets) == frames)
+	for i, packet := range handler.packets {
+		utils.Assert(packet.ConvertDts(1000) == int64(i*40))
+		utils.Assert(packet.Key == (i%25 == 0))
+	}
+}
+
+func TestClient(t *testing.T) {
+	storage, segmenter := newTestSegmenter(FormatTS)
+
+	// 第2个切片使用AES-128加密, IV为切片序号
+	key := []byte("0123456789abcdef")
+	segments := segmenter.Segments()
+	segments[1].Key = &Key{Method: "AES-128", URI: "/key"}
+	data, _ := storage.Get(segments[1].Name)
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	for i := 0; i < padding; i++ {
+		data = append(data, byte(padding))
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(segments[1].Sequence))
+	block, _ := aes.NewCipher(key)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	_ = storage.Put(segments[1].Name, data)
+
+	playlist := &Playlist{Type: PlaylistVOD, Version: 3, TargetDuration: 1, Segments: segments, EndList: true}
+	_ = storage.Put("index.m3u8", playlist.Marshal())
+	utils.Assert(strings.Count(string(playlist.Marshal()), "#EXT-X-KEY:") == 2)
+
+	var keyRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/master.m3u8":
+			_, _ = w.Write([]byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=100000,CODECS=\"avc1.42c01e\"\nlow/index.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x480,CODECS=\"avc1.42c01e,mp4a.40.2\"\nhigh/index.m3u8\n"))
+		case "/key":
+			keyRequests++
+			_, _ = w.Write(key)
+		default:
+			utils.Assert(strings.HasPrefix(r.URL.Path, "/high/"))
+			storage.ServeHTTP(w, r)
+		}
+	}))
+	defer server.Close()
+
+	handler := &testHandler{}
+	client, err := NewClient(server.URL + "/master.m3u8")
+	if err != nil {
+		panic(err)
+	}
+
+	client.SetHandler(handler)
+	if err = client.Play(); err != nil {
+		panic(err)
+	} else if err = client.Wait(); err != nil {
+		panic(err)
+	}
+
+	// 源和客户端的demuxer各缓存最后一帧
+	assertPackets(handler, 148)
+	utils.Assert(keyRequests == 1)
+}
+
+func TestClientFMP4(t *testing.T) {
+	storage, _ := newTestSegmenter(FormatFMP4)
+	var requests []string
+	client, _ := NewClient("memory://live/index.m3u8")
+	client.SetFetcher(FetcherFunc(func(ctx context.Context, url string) ([]byte, error) {
+		requests = append(requests, path.Base(url))
+		if data, ok := storage.Get(path.Base(url)); ok {
+			return data, nil
+		}
+		return nil, fmt.Errorf("%s not found", url)
+	}))
+
+	handler := &testHandler{}
+	client.SetHandler(handler)
+	if err := client.Play(); err != nil {
+		panic(err)
+	} else if err = client.Wait(); err != nil {
+		panic(err)
+	}
+
+	assertPackets(handler, 148)
+	utils.Assert(requests[0] == "index.m3u8" && requests[1] == "index_init.mp4")
+	utils.Assert(strings.Count(strings.Join(requests, ","), "init") == 1)
+}
+
+func TestParsePlaylist(t *testing.T) {
+	playlist, master, err := ParsePlaylist([]byte("#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:4\n#EXT-X-MEDIA-SEQUENCE:10\n#EXT-X-MAP:URI=\"init.mp4\"\n" +
+		"#EXT-X-KEY:METHOD=AES-128,URI=\"key?a=1,b=2\",IV=0x000102030405060708090A0B0C0D0E0F\n#EXTINF:4.000,\na.m4s\n" +
+		"#EXT-X-DISCONTINUITY\n#EXT-X-KEY:METHOD=NONE\n#EXTINF:3.5,title\nb.m4s\n#EXT-X-ENDLIST\n"))
+	if err != nil {
+		panic(err)
+	}
+
+	utils.Assert(master == nil && playlist.Version == 7 && playlist.TargetDuration == 4 && playlist.EndList)
+	utils.Assert(len(playlist.Segments) == 2 && playlist.Segments[1].Sequence == 11 && playlist.Segments[1].Duration == 3.5)
+	utils.Assert(playlist.Segments[0].Key.URI == "key?a=1,b=2" && playlist.Segments[0].Key.IV[15] == 0x0F && playlist.Segments[0].Map == "init.mp4")
+	utils.Assert(playlist.Segments[1].Key == nil && playlist.Segments[1].Discontinuity && !playlist.Segments[0].Discontinuity)
+
+	_, _, err = ParsePlaylist([]byte("#EXT-X-VERSION:3\n"))
+	utils.Assert(err != nil)
+}
+
+func TestMasterPlaylist(t *testing.T) {
+	record, _ := hex.DecodeString("0142c01effe100186742c01eda01e0089f961000000300100000030320f162ea01000568ce0f2c80")
+	config, err := avformat.ParseAVCDecoderConfigurationRecord(record)
+	if err != nil {
+		panic(err)
+	}
+
+	stream := avformat.NewAVStream(utils.AVMediaTypeVideo, 0, utils.AVCodecIdH264, record, config)
+	master := &MasterPlaylist{Variants: []*Variant{NewVariant("high/index.m3u8", 800000, []*avformat.AVStream{stream})}}
+	_, parsed, err := ParsePlaylist(master.Marshal())
+	if err != nil {
+		panic(err)
+	}
+
+	variant := parsed.Variants[0]
+	utils.Assert(variant.URI == "high/index.m3u8" && variant.Bandwidth == 800000 && variant.Resolution == "1920x1080")
+	utils.Assert(variant.FrameRate == 25 && variant.Codecs == "avc1.42c01e")
+}
@@ -0,0 +1,103 @@
+package hls
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+)
+
+// FileGetter 读取已保存的文件, MemoryStorage实现了该接口
+type FileGetter interface {
+	Get(name string) ([]byte, bool)
+}
+
+// Handler 提供索引和切片, 支持LL-HLS的_HLS_msn/_HLS_part阻塞请求和预加载分片
+type Handler struct {
+	segmenter *Segmenter
+	files     FileGetter
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	name := path.Base(r.URL.Path)
+	if name == h.segmenter.PlaylistName() {
+		h.servePlaylist(w, r)
+		return
+	}
+
+	data, ok := h.files.Get(name)
+	// 预加载的分片, 等待生成
+	if !ok && name == h.segmenter.PreloadHint() {
+		data, ok = h.waitFile(r, name)
+	}
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType(name))
+	_, _ = w.Write(data)
+}
+
+func (h *Handler) servePlaylist(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Has("_HLS_msn") {
+		msn, err := strconv.Atoi(query.Get("_HLS_msn"))
+		part := -1
+		if err == nil && query.Has("_HLS_part") {
+			part, err = strconv.Atoi(query.Get("_HLS_part"))
+		}
+
+		if err != nil || msn < 0 || (query.Has("_HLS_part") && part < 0) {
+			http.Error(w, "invalid _HLS_msn or _HLS_part", http.StatusBadRequest)
+			return
+		}
+
+		if err = h.segmenter.WaitPart(r.Context(), msn, part); ErrFutureSegment == err {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if ErrWaitTimeout == err {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			return
+		}
+	} else if query.Has("_HLS_part") {
+		http.Error(w, "_HLS_part without _HLS_msn", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType(h.segmenter.PlaylistName()))
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(h.segmenter.Playlist())
+}
+
+// 等待文件生成, 最多等待waitTimeoutTargets个目标时长
+func (h *Handler) waitFile(r *http.Request, name string) ([]byte, bool) {
+	timeout := time.NewTimer(waitTimeoutTargets * h.segmenter.TargetDuration())
+	defer timeout.Stop()
+
+	for {
+		updated := h.segmenter.updated()
+		if data, ok := h.files.Get(name); ok {
+			return data, true
+		} else if h.segmenter.Closed() {
+			return nil, false
+		}
+
+		select {
+		case <-updated:
+		case <-timeout.C:
+			return nil, false
+		case <-r.Context().Done():
+			return nil, false
+		}
+	}
+}
+
+// NewHandler files需要能读取segmenter写入的文件
+func NewHandler(segmenter *Segmenter, files FileGetter) *Handler {
+	return &Handler{segmenter: segmenter, files: files}
+}
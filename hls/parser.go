@@ -0,0 +1,202 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Variant 多码率m3u8中的一路流
+type Variant struct {
+	URI        string
+	Bandwidth  int
+	Resolution string
+	Codecs     string
+	FrameRate  float64 // 为0时不输出
+}
+
+// MasterPlaylist 多码率m3u8
+type MasterPlaylist struct {
+	Variants []*Variant
+}
+
+// NewVariant 根据流信息生成多码率m3u8中的一路流
+func NewVariant(uri string, bandwidth int, streams []*avformat.AVStream) *Variant {
+	variant := &Variant{URI: uri, Bandwidth: bandwidth}
+	var codecs []string
+	for _, stream := range streams {
+		if codec := stream.CodecString(); codec != "" {
+			codecs = append(codecs, codec)
+		}
+
+		if utils.AVMediaTypeVideo != stream.MediaType {
+			continue
+		} else if stream.CodecParameters != nil {
+			variant.Resolution = fmt.Sprintf("%dx%d", stream.CodecParameters.Width(), stream.CodecParameters.Height())
+		}
+		variant.FrameRate = stream.FrameRate
+	}
+
+	variant.Codecs = strings.Join(codecs, ",")
+	return variant
+}
+
+func (m *MasterPlaylist) Marshal() []byte {
+	buffer := &bytes.Buffer{}
+	buffer.WriteString("#EXTM3U\n")
+	for _, variant := range m.Variants {
+		fmt.Fprintf(buffer, "#EXT-X-STREAM-INF:BANDWIDTH=%d", variant.Bandwidth)
+		if variant.Resolution != "" {
+			fmt.Fprintf(buffer, ",RESOLUTION=%s", variant.Resolution)
+		}
+		if variant.FrameRate > 0 {
+			fmt.Fprintf(buffer, ",FRAME-RATE=%.3f", variant.FrameRate)
+		}
+		if variant.Codecs != "" {
+			fmt.Fprintf(buffer, ",CODECS=\"%s\"", variant.Codecs)
+		}
+		fmt.Fprintf(buffer, "\n%s\n", variant.URI)
+	}
+
+	return buffer.Bytes()
+}
+
+// ParsePlaylist 解析m3u8, 返回媒体m3u8或多码率m3u8其中之一
+func ParsePlaylist(data []byte) (*Playlist, *MasterPlaylist, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "#EXTM3U" {
+		return nil, nil, fmt.Errorf("invalid m3u8 header")
+	}
+
+	playlist := &Playlist{}
+	master := &MasterPlaylist{}
+	segment := &Segment{}
+	var key *Key
+	var variant *Variant
+	var hasInf bool
+	var sequence int
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		} else if !strings.HasPrefix(line, "#") {
+			if variant != nil {
+				variant.URI = line
+				master.Variants = append(master.Variants, variant)
+				variant = nil
+			} else if hasInf {
+				segment.Name = line
+				segment.Sequence = playlist.MediaSequence + sequence
+				segment.Key = key
+				segment.Map = playlist.Map
+				playlist.Segments = append(playlist.Segments, segment)
+				segment = &Segment{}
+				hasInf = false
+				sequence++
+			}
+			continue
+		}
+
+		tag, value, _ := strings.Cut(line, ":")
+		var err error
+		switch tag {
+		case "#EXT-X-VERSION":
+			playlist.Version, err = strconv.Atoi(value)
+		case "#EXT-X-TARGETDURATION":
+			playlist.TargetDuration, err = strconv.Atoi(value)
+		case "#EXT-X-MEDIA-SEQUENCE":
+			playlist.MediaSequence, err = strconv.Atoi(value)
+		case "#EXT-X-DISCONTINUITY-SEQUENCE":
+			playlist.DiscontinuitySequence, err = strconv.Atoi(value)
+		case "#EXT-X-PLAYLIST-TYPE":
+			if value == "EVENT" {
+				playlist.Type = PlaylistEvent
+			} else if value == "VOD" {
+				playlist.Type = PlaylistVOD
+			}
+		case "#EXT-X-ENDLIST":
+			playlist.EndList = true
+		case "#EXT-X-DISCONTINUITY":
+			segment.Discontinuity = true
+		case "#EXT-X-PROGRAM-DATE-TIME":
+			segment.ProgramDateTime, err = time.Parse(time.RFC3339Nano, value)
+		case "#EXTINF":
+			duration, _, _ := strings.Cut(value, ",")
+			segment.Duration, err = strconv.ParseFloat(duration, 64)
+			hasInf = true
+		case "#EXT-X-MAP":
+			playlist.Map = parseAttributes(value)["URI"]
+		case "#EXT-X-KEY":
+			key, err = parseKey(parseAttributes(value))
+		case "#EXT-X-STREAM-INF":
+			attributes := parseAttributes(value)
+			variant = &Variant{Resolution: attributes["RESOLUTION"], Codecs: attributes["CODECS"]}
+			variant.Bandwidth, err = strconv.Atoi(attributes["BANDWIDTH"])
+			if err == nil && attributes["FRAME-RATE"] != "" {
+				variant.FrameRate, err = strconv.ParseFloat(attributes["FRAME-RATE"], 64)
+			}
+		}
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid m3u8 tag %s: %s", tag, err.Error())
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	} else if len(master.Variants) > 0 {
+		return nil, master, nil
+	}
+
+	return playlist, nil, nil
+}
+
+func parseKey(attributes map[string]string) (*Key, error) {
+	method := attributes["METHOD"]
+	if method == "" || method == "NONE" {
+		return nil, nil
+	}
+
+	key := &Key{Method: method, URI: attributes["URI"]}
+	if iv, ok := attributes["IV"]; ok {
+		iv = strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X")
+		data, err := hex.DecodeString(iv)
+		if err != nil || len(data) != 16 {
+			return nil, fmt.Errorf("invalid iv %s", attributes["IV"])
+		}
+		key.IV = data
+	}
+
+	return key, nil
+}
+
+// 解析属性列表, 引号中的值可能包含逗号
+func parseAttributes(value string) map[string]string {
+	attributes := make(map[string]string)
+	for len(value) > 0 {
+		name, rest, ok := strings.Cut(value, "=")
+		if !ok {
+			break
+		}
+
+		var attribute string
+		if strings.HasPrefix(rest, "\"") {
+			attribute, rest, _ = strings.Cut(rest[1:], "\"")
+			rest = strings.TrimPrefix(rest, ",")
+		} else {
+			attribute, rest, _ = strings.Cut(rest, ",")
+		}
+
+		attributes[strings.TrimSpace(name)] = attribute
+		value = rest
+	}
+
+	return attributes
+}
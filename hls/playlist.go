@@ -0,0 +1,152 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"time"
+)
+
+type PlaylistType int
+
+const (
+	PlaylistLive  = PlaylistType(iota) // 滑动窗口, 删除过期切片
+	PlaylistEvent                      // 只追加, 不删除切片
+	PlaylistVOD                        // 结束后一次性写入
+)
+
+// PROGRAM-DATE-TIME的格式, 精确到毫秒
+const dateTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// Part LL-HLS的分片, Duration单位为秒
+type Part struct {
+	Name        string
+	Duration    float64
+	Independent bool // 以关键帧开始
+}
+
+// Key EXT-X-KEY, IV为空时使用切片序号
+type Key struct {
+	Method string
+	URI    string
+	IV     []byte
+}
+
+// Segment 切片信息, Duration单位为秒
+type Segment struct {
+	Name            string
+	Sequence        int
+	Duration        float64
+	Discontinuity   bool
+	ProgramDateTime time.Time
+	Parts           []*Part
+	Key             *Key   // 为空表示不加密
+	Map             string // 解析时使用, 切片对应的init segment
+}
+
+// Playlist 生成m3u8所需的信息
+type Playlist struct {
+	Type                  PlaylistType
+	Version               int
+	TargetDuration        int
+	MediaSequence         int
+	DiscontinuitySequence int
+	Map                   string // fMP4的init segment
+	Segments              []*Segment
+	EndList               bool
+
+	// LL-HLS, PartTarget为0时不输出
+	PartTarget           float64
+	PendingParts         []*Part // 未完成切片的分片
+	PendingDiscontinuity bool
+	PreloadHint          string
+}
+
+func (p *Playlist) Marshal() []byte {
+	buffer := &bytes.Buffer{}
+	buffer.WriteString("#EXTM3U\n")
+	fmt.Fprintf(buffer, "#EXT-X-VERSION:%d\n", p.Version)
+	fmt.Fprintf(buffer, "#EXT-X-TARGETDURATION:%d\n", p.TargetDuration)
+	if p.PartTarget > 0 {
+		fmt.Fprintf(buffer, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", p.PartTarget*3)
+		fmt.Fprintf(buffer, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", p.PartTarget)
+	}
+
+	fmt.Fprintf(buffer, "#EXT-X-MEDIA-SEQUENCE:%d\n", p.MediaSequence)
+	if p.DiscontinuitySequence > 0 {
+		fmt.Fprintf(buffer, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", p.DiscontinuitySequence)
+	}
+
+	if PlaylistEvent == p.Type {
+		buffer.WriteString("#EXT-X-PLAYLIST-TYPE:EVENT\n")
+	} else if PlaylistVOD == p.Type {
+		buffer.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	}
+
+	if p.Map != "" {
+		fmt.Fprintf(buffer, "#EXT-X-MAP:URI=\"%s\"\n", p.Map)
+	}
+
+	var key *Key
+	for _, segment := range p.Segments {
+		if segment.Discontinuity {
+			buffer.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+
+		// 密钥变化时输出
+		if segment.Key != key {
+			key = segment.Key
+			appendKey(buffer, key)
+		}
+
+		if !segment.ProgramDateTime.IsZero() {
+			fmt.Fprintf(buffer, "#EXT-X-PROGRAM-DATE-TIME:%s\n", segment.ProgramDateTime.Format(dateTimeFormat))
+		}
+
+		appendParts(buffer, segment.Parts)
+		fmt.Fprintf(buffer, "#EXTINF:%.3f,\n%s\n", segment.Duration, segment.Name)
+	}
+
+	if len(p.PendingParts) > 0 && p.PendingDiscontinuity {
+		buffer.WriteString("#EXT-X-DISCONTINUITY\n")
+	}
+
+	appendParts(buffer, p.PendingParts)
+	if p.PreloadHint != "" {
+		fmt.Fprintf(buffer, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s\"\n", p.PreloadHint)
+	}
+
+	if p.EndList {
+		buffer.WriteString("#EXT-X-ENDLIST\n")
+	}
+
+	return buffer.Bytes()
+}
+
+func appendKey(buffer *bytes.Buffer, key *Key) {
+	if key == nil {
+		buffer.WriteString("#EXT-X-KEY:METHOD=NONE\n")
+		return
+	}
+
+	fmt.Fprintf(buffer, "#EXT-X-KEY:METHOD=%s,URI=\"%s\"", key.Method, key.URI)
+	if key.IV != nil {
+		fmt.Fprintf(buffer, ",IV=0x%X", key.IV)
+	}
+	buffer.WriteString("\n")
+}
+
+func appendParts(buffer *bytes.Buffer, parts []*Part) {
+	for _, part := range parts {
+		fmt.Fprintf(buffer, "#EXT-X-PART:DURATION=%.3f,URI=\"%s\"", part.Duration, part.Name)
+		if part.Independent {
+			buffer.WriteString(",INDEPENDENT=YES")
+		}
+		buffer.WriteString("\n")
+	}
+}
+
+// 返回切片时长向上取整后的秒数
+func ceilSeconds(duration float64) int {
+	return int(math.Ceil(duration))
+}
@@ -0,0 +1,566 @@
+package hls
+
+import (
+	"context"
+	"fmt"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/fmp4"
+	"github.com/lkmio/avformat/mpegts"
+	"github.com/lkmio/avformat/utils"
+	"sync"
+	"time"
+)
+
+var (
+	ErrFutureSegment = fmt.Errorf("requested segment is too far in the future")
+	ErrWaitTimeout   = fmt.Errorf("wait for playlist update timeout")
+)
+
+type Format int
+
+const (
+	FormatTS   = Format(iota) // MPEG-TS切片
+	FormatFMP4                // fMP4切片, 需要init segment
+)
+
+const (
+	DefaultTargetDuration = 6 * time.Second
+	DefaultWindowSize     = 5
+
+	// 移出索引的切片保留的数量, 避免播放器还在下载时被删除
+	expiredSegments = 2
+
+	// 时间戳跳变超过该值时认为不连续, 毫秒
+	maxTimestampJump = 10000
+
+	// 索引中保留分片的时长, 单位为目标时长
+	partsHoldTargets = 3
+
+	// 阻塞请求和预加载分片的最长等待时间, 单位为目标时长. 超时后索引返回503, 分片返回404
+	waitTimeoutTargets = 3
+)
+
+// Segmenter 实现OnUnpackStreamHandler, 在关键帧处按目标时长切片, 生成HLS索引文件
+type Segmenter struct {
+	mutex          sync.Mutex
+	storage        Storage
+	format         Format
+	playlistType   PlaylistType
+	targetDuration time.Duration
+	windowSize     int
+	name           string
+	partTarget     time.Duration
+
+	tracks   avformat.TrackManager
+	indexes  []int // track索引对应的muxer索引, 不支持的编码为-1
+	hasVideo bool
+	ts       *mpegts.Muxer
+	mp4      *fmp4.Muxer
+
+	started      bool
+	buffer       []byte // 当前TS切片
+	segmentStart int64  // 当前切片的开始时间, 毫秒
+	segmentEnd   int64
+	lastDts      map[int]int64
+	baseDts      int64
+	baseTime     time.Time // baseDts对应的系统时间, 用于PROGRAM-DATE-TIME
+
+	parts           []*Part // 当前切片已完成的分片
+	partStarted     bool
+	partStart       int64
+	partIndependent bool
+	segmentData     []byte // 当前切片已完成的分片数据
+
+	sequence              int
+	segments              []*Segment
+	expired               []*Segment
+	mediaSequence         int
+	discontinuitySequence int
+	discontinuity         bool
+	maxDuration           float64
+
+	completed bool
+	closed    bool
+	notify    chan struct{} // 索引更新时关闭
+}
+
+// SetTargetDuration 设置切片的目标时长, 有视频时只在关键帧处切片
+func (s *Segmenter) SetTargetDuration(duration time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.targetDuration = duration
+}
+
+func (s *Segmenter) SetPlaylistType(playlistType PlaylistType) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.playlistType = playlistType
+}
+
+// SetWindowSize 设置直播索引中的切片数量
+func (s *Segmenter) SetWindowSize(size int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.windowSize = size
+}
+
+// SetPartTarget 设置LL-HLS分片的目标时长, 例如200ms, 只支持fMP4. 为0时不生成分片
+func (s *Segmenter) SetPartTarget(duration time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.partTarget = duration
+}
+
+func (s *Segmenter) lowLatency() bool {
+	return FormatFMP4 == s.format && s.partTarget > 0
+}
+
+// SetName 设置索引文件名(不含扩展名), 也作为切片文件名的前缀
+func (s *Segmenter) SetName(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.name = name
+}
+
+// PlaylistName 返回索引文件名
+func (s *Segmenter) PlaylistName() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.name + ".m3u8"
+}
+
+func (s *Segmenter) initName() string {
+	return s.name + "_init.mp4"
+}
+
+func (s *Segmenter) OnNewTrack(track avformat.Track) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.completed {
+		s.tracks.Add(track)
+	}
+}
+
+func (s *Segmenter) OnTrackComplete() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.completed || s.closed {
+		return
+	}
+
+	s.completed = true
+	var supported int
+	for _, track := range s.tracks.Tracks {
+		stream := track.GetStream()
+		var index int
+		var err error
+		if FormatFMP4 == s.format {
+			index, err = s.mp4.AddTrack(stream)
+		} else {
+			index, err = s.ts.AddTrack(stream)
+		}
+
+		if err != nil {
+			println(err.Error())
+			index = -1
+		} else {
+			supported++
+			s.hasVideo = s.hasVideo || utils.AVMediaTypeVideo == stream.MediaType
+		}
+
+		s.indexes = append(s.indexes, index)
+	}
+
+	if supported == 0 {
+		println("no track supported by hls")
+		s.closed = true
+		return
+	}
+
+	if FormatFMP4 == s.format {
+		if err := s.storage.Put(s.initName(), s.mp4.AppendInitSegment(nil)); err != nil {
+			println(err.Error())
+		}
+	}
+}
+
+func (s *Segmenter) OnTrackNotFind() {
+	s.Close()
+}
+
+func (s *Segmenter) OnPacket(packet *avformat.AVPacket) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.completed || s.closed || packet.Index >= len(s.indexes) || s.indexes[packet.Index] < 0 {
+		return
+	}
+
+	dts := packet.ConvertDts(1000)
+	key := !s.hasVideo || (utils.AVMediaTypeVideo == packet.MediaType && packet.Key)
+
+	// 时间戳回退或跳变, 结束当前切片, 下一个切片标记为不连续
+	if last, ok := s.lastDts[packet.Index]; ok && (dts < last || dts-last > maxTimestampJump) {
+		s.flush(s.segmentEnd)
+		s.markDiscontinuity()
+	}
+
+	if s.started && key && dts-s.segmentStart >= s.targetDuration.Milliseconds() {
+		s.flush(dts)
+	}
+
+	// 加上当前帧后超过目标时长, 结束当前分片
+	duration := packet.GetDuration(1000)
+	if s.partStarted && dts+duration-s.partStart > s.partTarget.Milliseconds() {
+		s.flushPart(dts)
+	}
+
+	if !s.started {
+		if !key {
+			return
+		}
+
+		s.startSegment(dts)
+	}
+
+	if s.lowLatency() && !s.partStarted {
+		s.partStarted = true
+		s.partStart = dts
+		s.partIndependent = key
+	}
+
+	var err error
+	index := s.indexes[packet.Index]
+	if FormatFMP4 == s.format {
+		err = s.mp4.AddPacket(index, packet)
+	} else {
+		s.buffer, err = s.ts.AppendAVPacket(s.buffer, index, packet)
+	}
+
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	s.lastDts[packet.Index] = dts
+	if end := dts + duration; end > s.segmentEnd {
+		s.segmentEnd = end
+	}
+}
+
+// Discontinuity 输入源发生变化, 例如重连后, 下一个切片标记为不连续
+func (s *Segmenter) Discontinuity() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.flush(s.segmentEnd)
+	s.markDiscontinuity()
+}
+
+func (s *Segmenter) markDiscontinuity() {
+	s.discontinuity = true
+	s.lastDts = make(map[int]int64)
+	s.baseTime = time.Time{}
+}
+
+func (s *Segmenter) startSegment(dts int64) {
+	s.started = true
+	s.segmentStart = dts
+	s.segmentEnd = dts
+	if s.baseTime.IsZero() {
+		s.baseTime = time.Now()
+		s.baseDts = dts
+	}
+
+	if FormatTS == s.format {
+		s.buffer = s.ts.AppendHeader(nil)
+	}
+}
+
+// flush 结束当前切片, end为切片的结束时间
+func (s *Segmenter) flush(end int64) {
+	if !s.started {
+		return
+	}
+
+	s.flushPart(end)
+	s.started = false
+	var data []byte
+	var parts []*Part
+	extension := ".ts"
+	if s.lowLatency() {
+		// 切片由所有分片组成
+		data, parts = s.segmentData, s.parts
+		s.segmentData, s.parts = nil, nil
+		extension = ".m4s"
+	} else if FormatFMP4 == s.format {
+		data = s.mp4.AppendFragment(nil)
+		extension = ".m4s"
+	} else {
+		data = s.buffer
+		s.buffer = nil
+	}
+
+	segment := &Segment{
+		Name:            fmt.Sprintf("%s_%d%s", s.name, s.sequence, extension),
+		Sequence:        s.sequence,
+		Duration:        float64(end-s.segmentStart) / 1000,
+		Discontinuity:   s.discontinuity && s.sequence > 0,
+		ProgramDateTime: s.baseTime.Add(time.Duration(s.segmentStart-s.baseDts) * time.Millisecond),
+		Parts:           parts,
+	}
+
+	s.sequence++
+	s.discontinuity = false
+	if err := s.storage.Put(segment.Name, data); err != nil {
+		println(err.Error())
+		return
+	}
+
+	s.segments = append(s.segments, segment)
+	if segment.Duration > s.maxDuration {
+		s.maxDuration = segment.Duration
+	}
+
+	// 滑动窗口, 删除过期切片
+	if PlaylistLive == s.playlistType && len(s.segments) > s.windowSize {
+		removed := s.segments[0]
+		s.segments = s.segments[1:]
+		s.mediaSequence++
+		if removed.Discontinuity {
+			s.discontinuitySequence++
+		}
+
+		s.expired = append(s.expired, removed)
+		for len(s.expired) > expiredSegments {
+			s.delete(s.expired[0])
+			s.expired = s.expired[1:]
+		}
+	}
+
+	if PlaylistVOD != s.playlistType {
+		s.writePlaylist(false)
+	}
+}
+
+// 删除切片和它的分片
+func (s *Segmenter) delete(segment *Segment) {
+	for _, part := range segment.Parts {
+		if err := s.storage.Delete(part.Name); err != nil {
+			println(err.Error())
+		}
+	}
+
+	if err := s.storage.Delete(segment.Name); err != nil {
+		println(err.Error())
+	}
+}
+
+func (s *Segmenter) partName(sequence, index int) string {
+	return fmt.Sprintf("%s_%d.%d.m4s", s.name, sequence, index)
+}
+
+// flushPart 结束当前分片, 写入后立即更新索引
+func (s *Segmenter) flushPart(end int64) {
+	if !s.partStarted {
+		return
+	}
+
+	s.partStarted = false
+	data := s.mp4.AppendFragment(nil)
+	part := &Part{Name: s.partName(s.sequence, len(s.parts)), Duration: float64(end-s.partStart) / 1000, Independent: s.partIndependent}
+	if err := s.storage.Put(part.Name, data); err != nil {
+		println(err.Error())
+	}
+
+	s.parts = append(s.parts, part)
+	s.segmentData = append(s.segmentData, data...)
+	if PlaylistVOD != s.playlistType {
+		s.writePlaylist(false)
+	}
+}
+
+func (s *Segmenter) playlist(endList bool) *Playlist {
+	playlist := &Playlist{
+		Type:                  s.playlistType,
+		Version:               3,
+		TargetDuration:        ceilSeconds(s.targetDuration.Seconds()),
+		MediaSequence:         s.mediaSequence,
+		DiscontinuitySequence: s.discontinuitySequence,
+		Segments:              s.segments,
+		EndList:               endList,
+	}
+
+	if maxDuration := ceilSeconds(s.maxDuration); maxDuration > playlist.TargetDuration {
+		playlist.TargetDuration = maxDuration
+	}
+
+	if FormatFMP4 == s.format {
+		playlist.Version = 7
+		playlist.Map = s.initName()
+	}
+
+	if s.lowLatency() {
+		playlist.Version = 9
+		playlist.PartTarget = s.partTarget.Seconds()
+		playlist.PendingParts = s.parts
+		playlist.PendingDiscontinuity = s.discontinuity && s.sequence > 0
+		if !endList {
+			playlist.PreloadHint = s.preloadHint()
+		}
+
+		// 只保留最近的分片
+		var duration float64
+		for _, part := range s.parts {
+			duration += part.Duration
+		}
+
+		playlist.Segments = make([]*Segment, len(s.segments))
+		for i := len(s.segments) - 1; i >= 0; i-- {
+			segment := s.segments[i]
+			if duration > partsHoldTargets*s.targetDuration.Seconds() && len(segment.Parts) > 0 {
+				clone := *segment
+				clone.Parts = nil
+				segment = &clone
+			}
+
+			duration += s.segments[i].Duration
+			playlist.Segments[i] = segment
+		}
+	}
+
+	return playlist
+}
+
+// 下一个分片的文件名
+func (s *Segmenter) preloadHint() string {
+	return s.partName(s.sequence, len(s.parts))
+}
+
+// PreloadHint 返回EXT-X-PRELOAD-HINT的分片, 没有开启LL-HLS或已经关闭时返回空
+func (s *Segmenter) PreloadHint() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.lowLatency() || s.closed {
+		return ""
+	}
+
+	return s.preloadHint()
+}
+
+func (s *Segmenter) TargetDuration() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.targetDuration
+}
+
+func (s *Segmenter) Closed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed
+}
+
+func (s *Segmenter) writePlaylist(endList bool) {
+	if err := s.storage.Put(s.name+".m3u8", s.playlist(endList).Marshal()); err != nil {
+		println(err.Error())
+	}
+
+	s.notifyUpdate()
+}
+
+func (s *Segmenter) notifyUpdate() {
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// 返回索引更新的通知
+func (s *Segmenter) updated() <-chan struct{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.notify
+}
+
+// 是否已经包含msn切片的第part个分片, part小于0时要求整个切片完成
+func (s *Segmenter) hasPart(msn, part int) bool {
+	return msn < s.sequence || (msn == s.sequence && part >= 0 && part < len(s.parts))
+}
+
+// WaitPart 阻塞直到索引包含msn切片的第part个分片, part小于0时等待切片完成.
+// 最多等待3个目标时长, msn超过当前切片2个以上时返回ErrFutureSegment
+func (s *Segmenter) WaitPart(ctx context.Context, msn, part int) error {
+	s.mutex.Lock()
+	timeout := time.NewTimer(waitTimeoutTargets * s.targetDuration)
+	s.mutex.Unlock()
+	defer timeout.Stop()
+
+	for {
+		s.mutex.Lock()
+		if s.closed || s.hasPart(msn, part) {
+			s.mutex.Unlock()
+			return nil
+		} else if msn > s.sequence+2 {
+			s.mutex.Unlock()
+			return ErrFutureSegment
+		}
+
+		notify := s.notify
+		s.mutex.Unlock()
+
+		select {
+		case <-notify:
+		case <-timeout.C:
+			return ErrWaitTimeout
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Playlist 返回当前的索引文件
+func (s *Segmenter) Playlist() []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.playlist(s.closed).Marshal()
+}
+
+// Segments 返回索引中的切片
+func (s *Segmenter) Segments() []*Segment {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*Segment{}, s.segments...)
+}
+
+// Close 结束最后一个切片, 写入带EXT-X-ENDLIST的索引文件
+func (s *Segmenter) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.flush(s.segmentEnd)
+	s.closed = true
+	if len(s.segments) > 0 {
+		s.writePlaylist(true)
+	} else {
+		s.notifyUpdate()
+	}
+}
+
+func NewSegmenter(storage Storage, format Format) *Segmenter {
+	return &Segmenter{
+		storage:        storage,
+		format:         format,
+		targetDuration: DefaultTargetDuration,
+		windowSize:     DefaultWindowSize,
+		name:           "index",
+		ts:             mpegts.NewMuxer(),
+		mp4:            fmp4.NewMuxer(),
+		lastDts:        make(map[int]int64),
+		notify:         make(chan struct{}),
+	}
+}
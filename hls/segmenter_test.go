@@ -0,0 +1,231 @@
+package hls
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/lkmio/avformat/flv"
+	"github.com/lkmio/avformat/utils"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 1秒一个GOP的H264源
+type testSource struct {
+	demuxer *flv.Demuxer
+}
+
+func newTestSource(segmenter *Segmenter) *testSource {
+	record, _ := hex.DecodeString("0142c01effe100186742c01eda01e0089f961000000300100000030320f162ea01000568ce0f2c80")
+	demuxer := flv.NewDemuxer()
+	demuxer.SetHandler(segmenter)
+	_ = demuxer.InputVideo(append([]byte{0x17, 0, 0, 0, 0}, record...), 0)
+	return &testSource{demuxer: demuxer}
+}
+
+// 输入[start, end)范围的帧, 时间戳为帧序号*40ms
+func (s *testSource) input(start, end, offset int) {
+	for i := start; i < end; i++ {
+		frame := []byte{0x27, 1, 0, 0, 0, 0, 0, 0, 2, 0x41, 0x9a}
+		if i%25 == 0 {
+			frame = []byte{0x17, 1, 0, 0, 0, 0, 0, 0, 2, 0x65, 0x88}
+		}
+
+		if err := s.demuxer.InputVideo(frame, uint32(offset+i*40)); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestSegmenter(t *testing.T) {
+	storage := NewMemoryStorage()
+	segmenter := NewSegmenter(storage, FormatTS)
+	segmenter.SetTargetDuration(2 * time.Second)
+	segmenter.SetWindowSize(3)
+
+	// 14秒, 完成6个切片
+	source := newTestSource(segmenter)
+	source.input(0, 350, 0)
+
+	playlist, ok := storage.Get("index.m3u8")
+	utils.Assert(ok && bytes.Equal(playlist, segmenter.Playlist()))
+	text := string(playlist)
+	utils.Assert(strings.Contains(text, "#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:3\n"))
+	utils.Assert(strings.Contains(text, "#EXTINF:2.000,\nindex_3.ts\n") && strings.Contains(text, "index_5.ts"))
+	utils.Assert(strings.Count(text, "#EXT-X-PROGRAM-DATE-TIME:") == 3 && !strings.Contains(text, "#EXT-X-ENDLIST"))
+
+	// 保留移出索引的最近2个切片
+	_, ok = storage.Get("index_0.ts")
+	utils.Assert(!ok)
+	segment, ok := storage.Get("index_1.ts")
+	utils.Assert(ok && len(segment)%188 == 0 && bytes.Equal(segment[:3], []byte{0x47, 0x40, 0x00}))
+
+	segments := segmenter.Segments()
+	utils.Assert(segments[1].ProgramDateTime.Sub(segments[0].ProgramDateTime) == 2*time.Second)
+
+	// 时间戳回退, 新切片标记为不连续
+	source.input(0, 100, 0)
+	segmenter.Close()
+	text = string(segmenter.Playlist())
+	utils.Assert(strings.Contains(text, "#EXT-X-DISCONTINUITY\n") && strings.HasSuffix(text, "#EXT-X-ENDLIST\n"))
+	playlist, _ = storage.Get("index.m3u8")
+	utils.Assert(string(playlist) == text)
+}
+
+func TestSegmenterFMP4(t *testing.T) {
+	var names []string
+	storage := NewCallbackStorage(func(name string, data []byte) error {
+		names = append(names, name)
+		if strings.HasSuffix(name, ".m4s") {
+			utils.Assert(string(data[4:8]) == "moof")
+		}
+		return nil
+	}, nil)
+
+	segmenter := NewSegmenter(storage, FormatFMP4)
+	segmenter.SetPlaylistType(PlaylistVOD)
+	segmenter.SetName("vod")
+	source := newTestSource(segmenter)
+	source.input(0, 400, 0)
+	segmenter.Close()
+
+	// VOD只在结束时写入索引
+	utils.Assert(names[0] == "vod_init.mp4" && names[len(names)-1] == "vod.m3u8")
+	utils.Assert(strings.Count(strings.Join(names, ","), "vod.m3u8") == 1)
+
+	text := string(segmenter.Playlist())
+	utils.Assert(strings.Contains(text, "#EXT-X-VERSION:7\n") && strings.Contains(text, "#EXT-X-PLAYLIST-TYPE:VOD\n"))
+	utils.Assert(strings.Contains(text, "#EXT-X-MAP:URI=\"vod_init.mp4\"\n") && strings.Contains(text, "#EXTINF:6.000,\nvod_0.m4s\n"))
+	utils.Assert(len(segmenter.Segments()) == 3)
+}
+
+func TestLowLatency(t *testing.T) {
+	storage := NewMemoryStorage()
+	segmenter := NewSegmenter(storage, FormatFMP4)
+	segmenter.SetTargetDuration(time.Second)
+	segmenter.SetPartTarget(200 * time.Millisecond)
+
+	// 完成2个切片, 第3个切片完成1个分片
+	source := newTestSource(segmenter)
+	source.input(0, 60, 0)
+
+	text := string(segmenter.Playlist())
+	utils.Assert(strings.Contains(text, "#EXT-X-VERSION:9\n#EXT-X-TARGETDURATION:1\n#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=0.600\n#EXT-X-PART-INF:PART-TARGET=0.200\n"))
+	utils.Assert(strings.Contains(text, "#EXT-X-PART:DURATION=0.200,URI=\"index_0.0.m4s\",INDEPENDENT=YES\n#EXT-X-PART:DURATION=0.200,URI=\"index_0.1.m4s\"\n"))
+	utils.Assert(strings.Contains(text, "#EXTINF:1.000,\nindex_1.m4s\n#EXT-X-PART:DURATION=0.200,URI=\"index_2.0.m4s\",INDEPENDENT=YES\n"))
+	utils.Assert(strings.HasSuffix(text, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"index_2.1.m4s\"\n"))
+
+	// 切片由分片组成
+	segment, _ := storage.Get("index_0.m4s")
+	var parts []byte
+	for i := 0; i < 5; i++ {
+		part, ok := storage.Get(fmt.Sprintf("index_0.%d.m4s", i))
+		utils.Assert(ok)
+		parts = append(parts, part...)
+	}
+	utils.Assert(bytes.Equal(segment, parts))
+
+	server := httptest.NewServer(NewHandler(segmenter, storage))
+	defer server.Close()
+
+	get := func(uri string) (int, string) {
+		response, err := http.Get(server.URL + uri)
+		if err != nil {
+			panic(err)
+		}
+
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		return response.StatusCode, string(body)
+	}
+
+	code, _ := get("/index.m3u8?_HLS_msn=10")
+	utils.Assert(code == http.StatusBadRequest)
+	code, _ = get("/index.m3u8?_HLS_part=1")
+	utils.Assert(code == http.StatusBadRequest)
+
+	// 阻塞直到分片生成
+	playlist := make(chan string)
+	preload := make(chan string)
+	go func() {
+		_, body := get("/index.m3u8?_HLS_msn=2&_HLS_part=1")
+		playlist <- body
+	}()
+	go func() {
+		_, body := get("/index_2.1.m4s")
+		preload <- body
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-playlist:
+		panic("playlist should block")
+	default:
+	}
+
+	source.input(60, 62, 0)
+	utils.Assert(strings.Contains(<-playlist, "#EXT-X-PART:DURATION=0.200,URI=\"index_2.1.m4s\"\n"))
+	utils.Assert(strings.HasPrefix((<-preload)[4:], "moof"))
+}
+
+func TestBlockingReload(t *testing.T) {
+	storage := NewMemoryStorage()
+	segmenter := NewSegmenter(storage, FormatFMP4)
+	segmenter.SetTargetDuration(time.Second)
+	segmenter.SetPartTarget(200 * time.Millisecond)
+	source := newTestSource(segmenter)
+	source.input(0, 60, 0)
+
+	server := httptest.NewServer(NewHandler(segmenter, storage))
+	defer server.Close()
+
+	get := func(uri string, result chan string) {
+		response, err := http.Get(server.URL + uri)
+		if err != nil {
+			panic(err)
+		}
+
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		result <- strconv.Itoa(response.StatusCode) + " " + string(body)
+	}
+
+	blocked := func(results ...chan string) bool {
+		time.Sleep(100 * time.Millisecond)
+		for _, result := range results {
+			select {
+			case <-result:
+				return false
+			default:
+			}
+		}
+		return true
+	}
+
+	// 下一个切片的第一个分片, 以及当前的预加载分片
+	playlist := make(chan string, 1)
+	preload := make(chan string, 1)
+	go get("/index.m3u8?_HLS_msn=3&_HLS_part=0", playlist)
+	go get("/index_2.1.m4s", preload)
+	utils.Assert(blocked(playlist, preload))
+
+	// 生成预加载分片, 索引继续阻塞
+	source.input(60, 62, 0)
+	utils.Assert(strings.HasPrefix(<-preload, "200 "))
+	utils.Assert(blocked(playlist))
+
+	// 切片3的第一个分片完成
+	source.input(62, 85, 0)
+	text := <-playlist
+	utils.Assert(strings.HasPrefix(text, "200 ") && strings.Contains(text, "URI=\"index_3.0.m4s\",INDEPENDENT=YES\n"))
+
+	// 不是预加载的分片, 不等待
+	missing := make(chan string, 1)
+	go get("/index_9.0.m4s", missing)
+	utils.Assert(strings.HasPrefix(<-missing, "404 "))
+}
@@ -0,0 +1,139 @@
+package hls
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Storage 保存切片和索引文件, 过期的切片会被删除
+type Storage interface {
+	Put(name string, data []byte) error
+
+	Delete(name string) error
+}
+
+// MemoryStorage 保存在内存中, 可以直接作为http.Handler使用
+type MemoryStorage struct {
+	mutex sync.RWMutex
+	files map[string][]byte
+}
+
+func (m *MemoryStorage) Put(name string, data []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.files[name] = data
+	return nil
+}
+
+func (m *MemoryStorage) Delete(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.files, name)
+	return nil
+}
+
+func (m *MemoryStorage) Get(name string) ([]byte, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	data, ok := m.files[name]
+	return data, ok
+}
+
+// ServeHTTP 根据请求路径的文件名返回文件
+func (m *MemoryStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	data, ok := m.Get(name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if contentType := ContentType(name); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	// 索引文件实时更新, 不能缓存
+	if strings.HasSuffix(name, ".m3u8") {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	_, _ = w.Write(data)
+}
+
+// ContentType 返回HLS文件的Content-Type
+func ContentType(name string) string {
+	switch path.Ext(name) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	case ".mp4", ".m4s":
+		return "video/mp4"
+	default:
+		return ""
+	}
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{files: make(map[string][]byte)}
+}
+
+// DiskStorage 保存到本地目录
+type DiskStorage struct {
+	dir string
+}
+
+// Put 先写入临时文件再重命名, 避免读取到不完整的索引文件
+func (d *DiskStorage) Put(name string, data []byte) error {
+	file := filepath.Join(d.dir, name)
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
+
+func (d *DiskStorage) Delete(name string) error {
+	if err := os.Remove(filepath.Join(d.dir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func NewDiskStorage(dir string) (*DiskStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &DiskStorage{dir: dir}, nil
+}
+
+// CallbackStorage 通过回调保存, 例如上传到对象存储
+type CallbackStorage struct {
+	onPut    func(name string, data []byte) error
+	onDelete func(name string) error
+}
+
+func (c *CallbackStorage) Put(name string, data []byte) error {
+	return c.onPut(name, data)
+}
+
+func (c *CallbackStorage) Delete(name string) error {
+	if c.onDelete == nil {
+		return nil
+	}
+
+	return c.onDelete(name)
+}
+
+// NewCallbackStorage onDelete可以为nil
+func NewCallbackStorage(onPut func(name string, data []byte) error, onDelete func(name string) error) *CallbackStorage {
+	return &CallbackStorage{onPut: onPut, onDelete: onDelete}
+}
@@ -0,0 +1,363 @@
+package mpegts
+
+import (
+	"fmt"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/ac3"
+	"github.com/lkmio/avformat/utils"
+)
+
+// PTS/DTS为33位, 超过后回绕
+const timestampWrap = int64(1) << 33
+
+type pesStream struct {
+	pid         uint16
+	codecID     utils.AVCodecID
+	mediaType   utils.AVMediaType
+	bufferIndex int
+	data        []byte // 正在组装的PES
+	length      int    // PES包的总长度, 0为不确定
+	lastDts     int64
+	duration    int64 // 上一个PES的时长
+	offset      int64 // 回绕和不连续产生的偏移
+
+	discontinuity bool
+}
+
+// Demuxer 解析TS流, 输出AnnexB格式的视频和带ADTS头的AAC
+type Demuxer struct {
+	avformat.BaseDemuxer
+	pmtPID         int
+	streams        map[uint16]*pesStream
+	expectedTracks int
+}
+
+// Input 输入TS流, 返回已经解析的长度, 剩余不足一个TS包的数据需要和后续数据一起输入
+func (d *Demuxer) Input(data []byte) (int, error) {
+	var n int
+	for len(data)-n >= PacketSize {
+		// 丢失同步, 查找下一个同步字节
+		if data[n] != SyncByte {
+			n++
+			continue
+		}
+
+		if err := d.inputPacket(data[n : n+PacketSize]); err != nil {
+			println(err.Error())
+		}
+		n += PacketSize
+	}
+
+	return n, nil
+}
+
+func (d *Demuxer) inputPacket(packet []byte) error {
+	pid := uint16(packet[1]&0x1F)<<8 | uint16(packet[2])
+	unitStart := packet[1]&0x40 != 0
+	payload := packet[4:]
+	if control := packet[3] >> 4 & 0x03; control&0x01 == 0 {
+		return nil
+	} else if control&0x02 != 0 {
+		if int(packet[4])+1 > len(payload) {
+			return fmt.Errorf("invalid ts adaptation field length %d", packet[4])
+		}
+		payload = payload[1+int(packet[4]):]
+	}
+
+	if PIDPAT == pid {
+		return d.parsePAT(payload, unitStart)
+	} else if int(pid) == d.pmtPID {
+		return d.parsePMT(payload, unitStart)
+	}
+
+	stream, ok := d.streams[pid]
+	if !ok {
+		return nil
+	}
+
+	if unitStart {
+		d.flushStream(stream)
+		if len(payload) >= 6 {
+			if length := int(payload[4])<<8 | int(payload[5]); length > 0 {
+				stream.length = length + 6
+			}
+		}
+	} else if len(stream.data) == 0 {
+		// 没有收到PES的开始
+		return nil
+	}
+
+	stream.data = append(stream.data, payload...)
+	if stream.length > 0 && len(stream.data) >= stream.length {
+		stream.data = stream.data[:stream.length]
+		d.flushStream(stream)
+	}
+
+	return nil
+}
+
+// 返回section内容, 只支持在一个TS包中的section
+func readSection(payload []byte, unitStart bool) ([]byte, error) {
+	if !unitStart || len(payload) < 1 {
+		return nil, nil
+	}
+
+	pointer := int(payload[0])
+	if 1+pointer+3 > len(payload) {
+		return nil, fmt.Errorf("invalid psi pointer field %d", pointer)
+	}
+
+	section := payload[1+pointer:]
+	length := int(section[1]&0x0F)<<8 | int(section[2])
+	if 3+length > len(section) || length < 9 {
+		return nil, fmt.Errorf("invalid psi section length %d", length)
+	}
+
+	return section[:3+length], nil
+}
+
+func (d *Demuxer) parsePAT(payload []byte, unitStart bool) error {
+	section, err := readSection(payload, unitStart)
+	if section == nil {
+		return err
+	}
+
+	// 跳过8字节的头, 去掉CRC
+	for programs := section[8 : len(section)-4]; len(programs) >= 4; programs = programs[4:] {
+		if number := int(programs[0])<<8 | int(programs[1]); number != 0 {
+			d.pmtPID = int(programs[2]&0x1F)<<8 | int(programs[3])
+			break
+		}
+	}
+
+	return nil
+}
+
+func (d *Demuxer) parsePMT(payload []byte, unitStart bool) error {
+	section, err := readSection(payload, unitStart)
+	if section == nil {
+		return err
+	} else if len(section) < 16 {
+		return fmt.Errorf("invalid pmt section")
+	}
+
+	infoLength := int(section[10]&0x0F)<<8 | int(section[11])
+	if 12+infoLength > len(section)-4 {
+		return fmt.Errorf("invalid pmt program info length %d", infoLength)
+	}
+
+	var expected int
+	for es := section[12+infoLength : len(section)-4]; len(es) >= 5; {
+		pid := uint16(es[1]&0x1F)<<8 | uint16(es[2])
+		esLength := int(es[3]&0x0F)<<8 | int(es[4])
+		if 5+esLength > len(es) {
+			return fmt.Errorf("invalid pmt es info length %d", esLength)
+		}
+
+		var id utils.AVCodecID
+		mediaType := utils.AVMediaTypeAudio
+		switch es[0] {
+		case StreamTypeH264:
+			id, mediaType = utils.AVCodecIdH264, utils.AVMediaTypeVideo
+		case StreamTypeH265:
+			id, mediaType = utils.AVCodecIdH265, utils.AVMediaTypeVideo
+		case StreamTypeAAC:
+			id = utils.AVCodecIdAAC
+		case StreamTypeMP3, 0x03:
+			id = utils.AVCodecIdMP3
+		case StreamTypeAC3:
+			id = utils.AVCodecIdAC3
+		case StreamTypeEAC3:
+			id = utils.AVCodecIdEAC3
+		case StreamTypePrivateData:
+			id = privateDataCodec(es[5 : 5+esLength])
+		}
+
+		// 每个切片都会重复PAT/PMT, 已经存在的不再添加
+		if _, ok := d.streams[pid]; !ok && id != utils.AVCodecIdNONE {
+			d.streams[pid] = &pesStream{pid: pid, codecID: id, mediaType: mediaType, bufferIndex: -1, lastDts: -1}
+		}
+
+		if id != utils.AVCodecIdNONE {
+			expected++
+		}
+		es = es[5+esLength:]
+	}
+
+	d.expectedTracks = expected
+	return nil
+}
+
+// 根据ES描述符识别私有数据流的编码, 目前只识别AC-3/E-AC-3
+func privateDataCodec(descriptors []byte) utils.AVCodecID {
+	for len(descriptors) >= 2 && 2+int(descriptors[1]) <= len(descriptors) {
+		tag, body := descriptors[0], descriptors[2:2+int(descriptors[1])]
+		switch {
+		case ac3.DescriptorTagAC3 == tag:
+			return utils.AVCodecIdAC3
+		case ac3.DescriptorTagEnhancedAC3 == tag:
+			return utils.AVCodecIdEAC3
+		case DescriptorTagRegistration == tag && len(body) >= 4:
+			// format_identifier
+			if string(body[:4]) == "AC-3" {
+				return utils.AVCodecIdAC3
+			} else if string(body[:4]) == "EAC3" {
+				return utils.AVCodecIdEAC3
+			}
+		}
+
+		descriptors = descriptors[2+len(body):]
+	}
+
+	return utils.AVCodecIdNONE
+}
+
+// 解析完整的PES, 输出AVPacket
+func (d *Demuxer) flushStream(stream *pesStream) {
+	data := stream.data
+	stream.data = stream.data[:0]
+	stream.length = 0
+	if len(data) < 9 || data[0] != 0 || data[1] != 0 || data[2] != 1 {
+		return
+	}
+
+	flags := data[7] >> 6
+	headerLength := 9 + int(data[8])
+	if headerLength > len(data) || (flags&0x02 != 0 && len(data) < 14) || (flags == 0x03 && len(data) < 19) {
+		return
+	} else if flags&0x02 == 0 {
+		// 没有时间戳的PES不处理
+		return
+	}
+
+	pts := readTimestamp(data[9:])
+	dts := pts
+	if flags == 0x03 {
+		dts = readTimestamp(data[14:])
+	}
+
+	// 不连续时接着上一帧的时间戳, 否则处理33位回绕
+	if stream.discontinuity && stream.lastDts >= 0 {
+		stream.offset = stream.lastDts + stream.duration - dts
+	} else if stream.lastDts >= 0 && stream.lastDts-(dts+stream.offset) > timestampWrap/2 {
+		stream.offset += timestampWrap
+	}
+
+	stream.discontinuity = false
+	dts += stream.offset
+	pts += stream.offset
+	if pts < dts-timestampWrap/2 {
+		pts += timestampWrap
+	}
+
+	if stream.lastDts >= 0 && dts > stream.lastDts {
+		stream.duration = dts - stream.lastDts
+	}
+	stream.lastDts = dts
+
+	payload := data[headerLength:]
+	if utils.AVMediaTypeVideo == stream.mediaType {
+		frame := d.writeFrame(stream, payload)
+		d.OnVideoPacket(stream.bufferIndex, stream.codecID, frame, avformat.IsKeyFrame(stream.codecID, frame), dts, pts, avformat.PacketTypeAnnexB)
+	} else if utils.AVCodecIdAAC == stream.codecID {
+		d.inputADTS(stream, payload, pts)
+	} else if utils.AVCodecIdAC3 == stream.codecID || utils.AVCodecIdEAC3 == stream.codecID {
+		d.inputAC3(stream, payload, pts)
+	} else {
+		frame := d.writeFrame(stream, payload)
+		d.OnAudioPacket(stream.bufferIndex, stream.codecID, frame, pts)
+	}
+
+	d.checkTracks()
+}
+
+// 写入一帧, 返回StreamsBuffer中的数据.
+// StreamsBuffer要求按顺序创建, 第一次写入时再分配, 避免无效的PES占用索引
+func (d *Demuxer) writeFrame(stream *pesStream, data []byte) []byte {
+	if stream.bufferIndex < 0 {
+		stream.bufferIndex = d.FindBufferIndex(int(stream.pid))
+	}
+
+	_, _ = d.DataPipeline.Write(data, stream.bufferIndex, stream.mediaType)
+	frame, _ := d.DataPipeline.Feat(stream.bufferIndex)
+	return frame
+}
+
+// 一个PES可能包含多个ADTS帧, 拆分后依次输出
+func (d *Demuxer) inputADTS(stream *pesStream, data []byte, pts int64) {
+	for len(data) >= 7 {
+		header, err := utils.ReadADtsFixedHeader(data)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+
+		size := int(data[3]&0x03)<<11 | int(data[4])<<3 | int(data[5])>>5
+		if size < 7 || size > len(data) {
+			return
+		}
+
+		frame := d.writeFrame(stream, data[:size])
+		d.OnAudioPacket(stream.bufferIndex, stream.codecID, frame, pts)
+
+		sampleRate, _ := utils.GetSampleRateFromFrequency(header.Frequency())
+		if sampleRate > 0 {
+			pts += int64(1024 * Timebase / sampleRate)
+		}
+		data = data[size:]
+	}
+}
+
+// 一个PES可能包含多个AC-3同步帧, 拆分后依次输出
+func (d *Demuxer) inputAC3(stream *pesStream, data []byte, pts int64) {
+	_, err := ac3.SplitFrames(data, func(frame []byte, header ac3.Header) {
+		frame = d.writeFrame(stream, frame)
+		d.OnAudioPacket(stream.bufferIndex, stream.codecID, frame, pts)
+		pts += header.Duration(Timebase)
+	})
+
+	if err != nil {
+		println(err.Error())
+	}
+}
+
+// 已知track数量时, 全部解析后立即结束探测
+func (d *Demuxer) checkTracks() {
+	if d.expectedTracks > 0 && !d.Completed && d.Tracks.Size() >= d.expectedTracks {
+		d.ProbeComplete()
+	}
+}
+
+// Flush 输出所有未完成的PES, 例如一个切片结束时
+func (d *Demuxer) Flush() {
+	for _, stream := range d.streams {
+		if len(stream.data) > 0 {
+			d.flushStream(stream)
+		}
+	}
+}
+
+// Discontinuity 之后的时间戳不再连续, 例如HLS的EXT-X-DISCONTINUITY, 输出的时间戳接着之前的继续增长
+func (d *Demuxer) Discontinuity() {
+	d.Flush()
+	for _, stream := range d.streams {
+		stream.discontinuity = true
+	}
+}
+
+// 读取PES中的PTS/DTS
+func readTimestamp(data []byte) int64 {
+	return int64(data[0]>>1&0x07)<<30 | int64(data[1])<<22 | int64(data[2]>>1)<<15 | int64(data[3])<<7 | int64(data[4]>>1)
+}
+
+func NewDemuxer() *Demuxer {
+	return &Demuxer{
+		BaseDemuxer: avformat.BaseDemuxer{
+			DataPipeline: &avformat.StreamsBuffer{},
+			Name:         "ts",
+			AutoFree:     true,
+		},
+		pmtPID:  -1,
+		streams: make(map[uint16]*pesStream),
+	}
+}
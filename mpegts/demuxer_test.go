@@ -0,0 +1,158 @@
+package mpegts
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/bufio"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+type testHandler struct {
+	tracks   []avformat.Track
+	complete bool
+	packets  []*avformat.AVPacket
+}
+
+func (h *testHandler) OnNewTrack(track avformat.Track) {
+	h.tracks = append(h.tracks, track)
+}
+
+func (h *testHandler) OnTrackComplete() {
+	h.complete = true
+}
+
+func (h *testHandler) OnTrackNotFind() {
+}
+
+func (h *testHandler) OnPacket(packet *avformat.AVPacket) {
+	h.packets = append(h.packets, &avformat.AVPacket{Data: append([]byte{}, packet.Data...), Dts: packet.Dts, Pts: packet.Pts, Key: packet.Key, MediaType: packet.MediaType})
+}
+
+func TestDemuxer(t *testing.T) {
+	sps, _ := hex.DecodeString("6742c01eda01e0089f961000000300100000030320f162ea")
+	pps, _ := hex.DecodeString("68ce0f2c80")
+	startCode := []byte{0, 0, 0, 1}
+	keyFrame := bytes.Join([][]byte{nil, sps, pps, {0x65, 0x88, 0x84}}, startCode)
+	frame := append(append([]byte{}, startCode...), 0x41, 0x9a, 0x02)
+
+	// 一个PES包含2个ADTS帧
+	adts := make([]byte, 9)
+	utils.SetADtsHeader(adts, 0, 1, 8, 1, len(adts))
+	adts[7], adts[8] = 0x21, 0x10
+
+	muxer := NewMuxer()
+	_, _ = muxer.AddTrack(&avformat.AVStream{MediaType: utils.AVMediaTypeVideo, CodecID: utils.AVCodecIdH264})
+	_, _ = muxer.AddTrack(&avformat.AVStream{MediaType: utils.AVMediaTypeAudio, CodecID: utils.AVCodecIdAAC})
+
+	// 时间戳从回绕前开始
+	base := timestampWrap - 3600*5
+	data := muxer.AppendHeader(nil)
+	for i := 0; i < 20; i++ {
+		ts := (base + int64(i*3600)) % timestampWrap
+		video := frame
+		if i%10 == 0 {
+			video = keyFrame
+		}
+
+		data = muxer.AppendPacket(data, 0, video, ts, (ts+3600)%timestampWrap, i%10 == 0)
+		data = muxer.AppendPacket(data, 1, append(append([]byte{}, adts...), adts...), ts, ts, true)
+	}
+
+	handler := &testHandler{}
+	demuxer := NewDemuxer()
+	demuxer.SetHandler(handler)
+
+	// 不对齐的输入和开头的垃圾数据
+	input := append([]byte{1, 2, 3}, data...)
+	var buffer []byte
+	for i := 0; i < len(input); i += 100 {
+		buffer = append(buffer, input[i:bufio.MinInt(i+100, len(input))]...)
+		n, err := demuxer.Input(buffer)
+		if err != nil {
+			panic(err)
+		}
+		buffer = buffer[n:]
+	}
+	demuxer.Flush()
+
+	// 音频PES有长度, 先于视频解析出track
+	utils.Assert(handler.complete && len(handler.tracks) == 2)
+	utils.Assert(utils.AVCodecIdAAC == handler.tracks[0].GetStream().CodecID && handler.tracks[0].GetStream().SampleRate == 16000)
+	utils.Assert(utils.AVCodecIdH264 == handler.tracks[1].GetStream().CodecID && handler.tracks[1].GetStream().CodecParameters.Width() > 0)
+
+	var videos, audios []*avformat.AVPacket
+	for _, packet := range handler.packets {
+		if utils.AVMediaTypeVideo == packet.MediaType {
+			videos = append(videos, packet)
+		} else {
+			audios = append(audios, packet)
+		}
+	}
+
+	// 最后一帧被延迟输出
+	utils.Assert(len(videos) == 19 && len(audios) == 39)
+	utils.Assert(videos[0].Key && bytes.Equal(videos[0].Data, keyFrame) && bytes.Equal(videos[1].Data, frame))
+	for i, packet := range videos {
+		utils.Assert(packet.Dts == base+int64(i*3600) && packet.Pts == packet.Dts+3600)
+	}
+
+	// 第二个ADTS帧的时间戳加上一帧的时长
+	utils.Assert(bytes.Equal(audios[0].Data, adts) && audios[1].Dts == base+1024*Timebase/16000)
+}
+
+// 无效的音频PES不能占用StreamsBuffer的索引, 否则后续track写入越界
+func TestTruncatedAudioPES(t *testing.T) {
+	ac3Frame := make([]byte, 128)
+	copy(ac3Frame, []byte{0x0B, 0x77, 0x00, 0x00, 0x00, 0x40, 0x40})
+	adts := make([]byte, 9)
+	utils.SetADtsHeader(adts, 0, 1, 8, 1, len(adts))
+
+	muxer := NewMuxer()
+	_, _ = muxer.AddTrack(&avformat.AVStream{MediaType: utils.AVMediaTypeAudio, CodecID: utils.AVCodecIdAAC})
+	_, _ = muxer.AddTrack(&avformat.AVStream{MediaType: utils.AVMediaTypeAudio, CodecID: utils.AVCodecIdAC3})
+	_, _ = muxer.AddTrack(&avformat.AVStream{MediaType: utils.AVMediaTypeAudio, CodecID: utils.AVCodecIdMP3})
+
+	// 截断的ADTS和AC-3帧
+	data := muxer.AppendHeader(nil)
+	data = muxer.AppendPacket(data, 0, adts[:8], 0, 0, true)
+	data = muxer.AppendPacket(data, 1, ac3Frame[:100], 0, 0, true)
+	data = muxer.AppendPacket(data, 2, []byte{0xFF, 0xFB, 0x90, 0x00}, 0, 0, true)
+	data = muxer.AppendPacket(data, 0, adts, 1000, 1000, true)
+	data = muxer.AppendPacket(data, 1, ac3Frame, 1000, 1000, true)
+
+	handler := &testHandler{}
+	demuxer := NewDemuxer()
+	demuxer.SetHandler(handler)
+	n, err := demuxer.Input(data)
+	utils.Assert(err == nil && n == len(data))
+	demuxer.Flush()
+	utils.Assert(len(handler.tracks) == 3)
+}
+
+func FuzzDemuxer(f *testing.F) {
+	ac3Frame := make([]byte, 128)
+	copy(ac3Frame, []byte{0x0B, 0x77, 0x00, 0x00, 0x00, 0x40, 0x40})
+	adts := make([]byte, 9)
+	utils.SetADtsHeader(adts, 0, 1, 8, 1, len(adts))
+
+	muxer := NewMuxer()
+	_, _ = muxer.AddTrack(&avformat.AVStream{MediaType: utils.AVMediaTypeAudio, CodecID: utils.AVCodecIdAAC})
+	_, _ = muxer.AddTrack(&avformat.AVStream{MediaType: utils.AVMediaTypeAudio, CodecID: utils.AVCodecIdAC3})
+	_, _ = muxer.AddTrack(&avformat.AVStream{MediaType: utils.AVMediaTypeVideo, CodecID: utils.AVCodecIdH264})
+	data := muxer.AppendHeader(nil)
+	for i := 0; i < 3; i++ {
+		data = muxer.AppendPacket(data, 0, adts, int64(i*1000), int64(i*1000), true)
+		data = muxer.AppendPacket(data, 1, ac3Frame, int64(i*1000), int64(i*1000), true)
+		data = muxer.AppendPacket(data, 2, []byte{0, 0, 0, 1, 0x65, byte(i)}, int64(i*1000), int64(i*1000), true)
+	}
+	f.Add(data)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		demuxer := NewDemuxer()
+		demuxer.SetHandler(&testHandler{})
+		_, _ = demuxer.Input(data)
+		demuxer.Flush()
+	})
+}
@@ -0,0 +1,80 @@
+package mpegts
+
+import (
+	"github.com/lkmio/avformat/utils"
+)
+
+const (
+	PacketSize = 188
+	SyncByte   = 0x47
+
+	PIDPAT = 0x0000
+	PIDPMT = 0x1000
+
+	// 第一个ES的PID, 后续track依次递增
+	PIDElementaryStart = 0x0100
+
+	// stream_type
+	StreamTypeMP3         = 0x04
+	StreamTypePrivateData = 0x06 // DVB通过描述符区分AC-3/E-AC-3
+	StreamTypeAAC         = 0x0F
+	StreamTypeH264        = 0x1B
+	StreamTypeH265        = 0x24
+	StreamTypeAC3         = 0x81 // ATSC
+	StreamTypeEAC3        = 0x87 // ATSC
+
+	// registration_descriptor
+	DescriptorTagRegistration = 0x05
+
+	// PES的stream_id
+	StreamIDAudio = 0xC0
+	StreamIDVideo = 0xE0
+
+	// PTS/DTS的时钟频率
+	Timebase = 90000
+)
+
+// StreamType 返回TS的stream_type, 不支持的返回-1
+func StreamType(id utils.AVCodecID) int {
+	switch id {
+	case utils.AVCodecIdH264:
+		return StreamTypeH264
+	case utils.AVCodecIdH265:
+		return StreamTypeH265
+	case utils.AVCodecIdAAC:
+		return StreamTypeAAC
+	case utils.AVCodecIdMP3:
+		return StreamTypeMP3
+	case utils.AVCodecIdAC3, utils.AVCodecIdEAC3:
+		// 与DVB一致, 使用PES私有数据加AC-3描述符
+		return StreamTypePrivateData
+	default:
+		return -1
+	}
+}
+
+var crc32Table [256]uint32
+
+func init() {
+	for i := range crc32Table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+		crc32Table[i] = crc
+	}
+}
+
+// CRC32 PSI使用的CRC32/MPEG-2
+func CRC32(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc = crc<<8 ^ crc32Table[byte(crc>>24)^b]
+	}
+
+	return crc
+}
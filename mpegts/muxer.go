@@ -0,0 +1,277 @@
+package mpegts
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/ac3"
+	"github.com/lkmio/avformat/bufio"
+	"github.com/lkmio/avformat/utils"
+)
+
+var (
+	// 每个视频帧前加上AUD
+	h264AUD = []byte{0x00, 0x00, 0x00, 0x01, 0x09, 0xF0}
+	h265AUD = []byte{0x00, 0x00, 0x00, 0x01, 0x46, 0x01, 0x50}
+)
+
+type muxStream struct {
+	stream     *avformat.AVStream
+	pid        uint16
+	streamType byte
+	streamID   byte
+	counter    byte
+}
+
+// Muxer TS封装, 实现avformat.Muxer, 输入的时间戳为90KHZ
+type Muxer struct {
+	streams    []*muxStream
+	patCounter byte
+	pmtCounter byte
+	pcrPID     uint16
+}
+
+func (m *Muxer) AddTrack(stream *avformat.AVStream) (int, error) {
+	streamType := StreamType(stream.CodecID)
+	if streamType < 0 {
+		return -1, fmt.Errorf("unsupported ts codec %s", stream.CodecID)
+	}
+
+	s := &muxStream{stream: stream, pid: uint16(PIDElementaryStart + len(m.streams)), streamType: byte(streamType), streamID: StreamIDAudio}
+	if utils.AVMediaTypeVideo == stream.MediaType {
+		s.streamID = StreamIDVideo
+		// 优先使用视频的PID作为PCR
+		if m.pcrPID == 0 || m.findStream(m.pcrPID).streamID != StreamIDVideo {
+			m.pcrPID = s.pid
+		}
+	} else if m.pcrPID == 0 {
+		m.pcrPID = s.pid
+	}
+
+	m.streams = append(m.streams, s)
+	return len(m.streams) - 1, nil
+}
+
+// 返回PMT中ES的描述符, AC-3/E-AC-3需要描述符区分编码, 根据dac3/dec3填充
+func (s *muxStream) descriptors() []byte {
+	switch s.stream.CodecID {
+	case utils.AVCodecIdAC3:
+		if box, err := ac3.ParseAC3SpecificBox(s.stream.Data); err == nil {
+			return box.Descriptor().Marshal()
+		}
+		return (&ac3.Descriptor{}).Marshal()
+	case utils.AVCodecIdEAC3:
+		if box, err := ac3.ParseEC3SpecificBox(s.stream.Data); err == nil {
+			return box.Descriptor().Marshal()
+		}
+		return (&ac3.Descriptor{Enhanced: true}).Marshal()
+	default:
+		return nil
+	}
+}
+
+func (m *Muxer) findStream(pid uint16) *muxStream {
+	for _, s := range m.streams {
+		if s.pid == pid {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// AppendHeader 写入PAT和PMT, 每个切片开头都需要
+func (m *Muxer) AppendHeader(dst []byte) []byte {
+	// program_number 1 -> PMT
+	pat := []byte{0x00, 0xB0, 0, 0x00, 0x01, 0xC1, 0x00, 0x00, 0x00, 0x01, 0xE0 | PIDPMT>>8, PIDPMT & 0xFF}
+	dst = m.appendSection(dst, PIDPAT, &m.patCounter, pat)
+
+	pmt := []byte{0x02, 0xB0, 0, 0x00, 0x01, 0xC1, 0x00, 0x00, 0xE0 | byte(m.pcrPID>>8), byte(m.pcrPID), 0xF0, 0x00}
+	for _, s := range m.streams {
+		info := s.descriptors()
+		pmt = append(pmt, s.streamType, 0xE0|byte(s.pid>>8), byte(s.pid), 0xF0|byte(len(info)>>8), byte(len(info)))
+		pmt = append(pmt, info...)
+	}
+
+	return m.appendSection(dst, PIDPMT, &m.pmtCounter, pmt)
+}
+
+// 填充section_length和CRC, 写入一个TS包
+func (m *Muxer) appendSection(dst []byte, pid uint16, counter *byte, section []byte) []byte {
+	length := len(section) - 3 + 4
+	section[1] |= byte(length >> 8)
+	section[2] = byte(length)
+	section = binary.BigEndian.AppendUint32(section, CRC32(section))
+
+	offset := len(dst)
+	dst = append(dst, SyncByte, 0x40|byte(pid>>8), byte(pid), 0x10|*counter&0x0F, 0x00)
+	*counter++
+	dst = append(dst, section...)
+	for len(dst)-offset < PacketSize {
+		dst = append(dst, 0xFF)
+	}
+
+	return dst
+}
+
+func (m *Muxer) WriteHeader(dst []byte) (int, error) {
+	header := m.AppendHeader(nil)
+	if len(dst) < len(header) {
+		return 0, fmt.Errorf("ts buffer too small")
+	}
+
+	return copy(dst, header), nil
+}
+
+// Input 实现avformat.Muxer, 视频需要AnnexB格式, 关键帧根据NALU判断
+func (m *Muxer) Input(dst []byte, index int, data []byte, dts, pts int64) (int, error) {
+	if index < 0 || index >= len(m.streams) {
+		return 0, fmt.Errorf("invalid ts track index %d", index)
+	}
+
+	key := true
+	if StreamIDVideo == m.streams[index].streamID {
+		key = avformat.IsKeyFrame(m.streams[index].stream.CodecID, data)
+	}
+
+	bytes := m.AppendPacket(nil, index, data, dts, pts, key)
+	if len(dst) < len(bytes) {
+		return 0, fmt.Errorf("ts buffer too small")
+	}
+
+	return copy(dst, bytes), nil
+}
+
+// AppendAVPacket 将AVPacket转换为TS包, 视频转为AnnexB, 关键帧前加上sps/pps, AAC加上ADTS头
+func (m *Muxer) AppendAVPacket(dst []byte, index int, packet *avformat.AVPacket) ([]byte, error) {
+	if index < 0 || index >= len(m.streams) {
+		return dst, fmt.Errorf("invalid ts track index %d", index)
+	}
+
+	stream := m.streams[index].stream
+	var data []byte
+	if utils.AVMediaTypeVideo == packet.MediaType {
+		data = h264AUD
+		if utils.AVCodecIdH265 == packet.CodecID {
+			data = h265AUD
+		}
+
+		data = append([]byte{}, data...)
+		if packet.Key && stream.CodecParameters != nil {
+			data = append(data, stream.CodecParameters.AnnexBExtraData()...)
+		}
+
+		data = append(data, avformat.AVCCPacket2AnnexB(stream, packet)...)
+	} else if utils.AVCodecIdAAC == packet.CodecID && !stream.HasADTSHeader {
+		config, err := utils.ParseMpeg4AudioConfig(stream.Data)
+		if err != nil {
+			return dst, err
+		}
+
+		data = make([]byte, 7+len(packet.Data))
+		utils.SetADtsHeader(data, 0, config.ObjectType-1, config.SamplingIndex, config.ChanConfig, len(data))
+		copy(data[7:], packet.Data)
+	} else {
+		data = packet.Data
+	}
+
+	return m.AppendPacket(dst, index, data, packet.ConvertDts(Timebase), packet.ConvertPts(Timebase), packet.Key || utils.AVMediaTypeAudio == packet.MediaType), nil
+}
+
+// AppendPacket 将一帧封装为PES, 再拆分为TS包
+func (m *Muxer) AppendPacket(dst []byte, index int, data []byte, dts, pts int64, key bool) []byte {
+	s := m.streams[index]
+
+	// PES头
+	pes := []byte{0x00, 0x00, 0x01, s.streamID, 0, 0, 0x80, 0x80, 5}
+	if dts != pts {
+		pes[7], pes[8] = 0xC0, 10
+		pes = appendTimestamp(pes, 0x3, pts)
+		pes = appendTimestamp(pes, 0x1, dts)
+	} else {
+		pes = appendTimestamp(pes, 0x2, pts)
+	}
+
+	// 视频的PES长度可以为0
+	if length := len(pes) - 6 + len(data); length <= 0xFFFF && StreamIDAudio == s.streamID {
+		binary.BigEndian.PutUint16(pes[4:], uint16(length))
+	}
+
+	first := true
+	for len(pes)+len(data) > 0 {
+		var packet [PacketSize]byte
+		packet[0] = SyncByte
+		packet[1] = byte(s.pid >> 8)
+		packet[2] = byte(s.pid)
+		packet[3] = 0x10 | s.counter&0x0F
+		s.counter++
+
+		// 自适应字段, 不包含长度字节
+		var adaptation []byte
+		if first {
+			packet[1] |= 0x40
+			if key || s.pid == m.pcrPID {
+				adaptation = []byte{0x00}
+			}
+			if key {
+				adaptation[0] |= 0x40
+			}
+			if s.pid == m.pcrPID {
+				adaptation[0] |= 0x10
+				adaptation = appendPCR(adaptation, dts)
+			}
+		}
+
+		space := PacketSize - 4
+		if adaptation != nil {
+			space -= 1 + len(adaptation)
+		}
+
+		// 最后一个包使用自适应字段填充
+		if remain := len(pes) + len(data); remain < space {
+			if adaptation == nil {
+				adaptation = []byte{}
+				space--
+				if remain < space {
+					adaptation = append(adaptation, 0x00)
+					space--
+				}
+			}
+
+			for ; remain < space; space-- {
+				adaptation = append(adaptation, 0xFF)
+			}
+		}
+
+		n := 4
+		if adaptation != nil {
+			packet[3] |= 0x20
+			packet[4] = byte(len(adaptation))
+			n += 1 + copy(packet[5:], adaptation)
+		}
+
+		written := copy(packet[n:], pes)
+		pes = pes[written:]
+		copy(packet[n+written:], data)
+		data = data[bufio.MinInt(len(data), PacketSize-n-written):]
+
+		dst = append(dst, packet[:]...)
+		first = false
+	}
+
+	return dst
+}
+
+// 写入5字节的PTS/DTS
+func appendTimestamp(dst []byte, prefix byte, ts int64) []byte {
+	return append(dst, prefix<<4|byte(ts>>29)&0x0E|0x01, byte(ts>>22), byte(ts>>14)|0x01, byte(ts>>7), byte(ts<<1)|0x01)
+}
+
+// 写入6字节的PCR, 扩展部分为0
+func appendPCR(dst []byte, base int64) []byte {
+	return append(dst, byte(base>>25), byte(base>>17), byte(base>>9), byte(base>>1), byte(base<<7)|0x7E, 0x00)
+}
+
+func NewMuxer() *Muxer {
+	return &Muxer{}
+}
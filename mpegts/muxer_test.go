@@ -0,0 +1,117 @@
+package mpegts
+
+import (
+	"bytes"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/ac3"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+// 按PID拼接payload
+func demux(data []byte) map[uint16][]byte {
+	payloads := make(map[uint16][]byte)
+	counters := make(map[uint16]byte)
+	for i := 0; i < len(data); i += PacketSize {
+		packet := data[i : i+PacketSize]
+		utils.Assert(packet[0] == SyncByte)
+
+		pid := uint16(packet[1]&0x1F)<<8 | uint16(packet[2])
+		if counter, ok := counters[pid]; ok {
+			utils.Assert(packet[3]&0x0F == (counter+1)&0x0F)
+		}
+		counters[pid] = packet[3] & 0x0F
+
+		offset := 4
+		if packet[3]&0x20 != 0 {
+			offset += 1 + int(packet[4])
+		}
+		payloads[pid] = append(payloads[pid], packet[offset:]...)
+	}
+
+	return payloads
+}
+
+func TestMuxer(t *testing.T) {
+	muxer := NewMuxer()
+	_, err := muxer.AddTrack(&avformat.AVStream{MediaType: utils.AVMediaTypeAudio, CodecID: utils.AVCodecIdAAC})
+	if err != nil {
+		panic(err)
+	}
+	video, err := muxer.AddTrack(&avformat.AVStream{MediaType: utils.AVMediaTypeVideo, CodecID: utils.AVCodecIdH264})
+	if err != nil {
+		panic(err)
+	}
+	_, err = muxer.AddTrack(&avformat.AVStream{MediaType: utils.AVMediaTypeVideo, CodecID: utils.AVCodecIdVP9})
+	utils.Assert(err != nil)
+
+	// PCR使用视频的PID
+	utils.Assert(muxer.pcrPID == PIDElementaryStart+1)
+
+	header := muxer.AppendHeader(nil)
+	utils.Assert(len(header) == PacketSize*2)
+	payloads := demux(header)
+	pat := payloads[PIDPAT][1:]
+	utils.Assert(CRC32(pat[:3+int(pat[2])]) == 0)
+	pmt := payloads[PIDPMT][1:]
+	utils.Assert(CRC32(pmt[:3+int(pmt[2])]) == 0 && pmt[12] == StreamTypeAAC && pmt[17] == StreamTypeH264)
+
+	// 各种长度的帧, 覆盖填充的边界
+	for size := 150; size < 400; size++ {
+		frame := make([]byte, size)
+		for i := range frame {
+			frame[i] = byte(i)
+		}
+
+		data := make([]byte, PacketSize*4)
+		n, err := muxer.Input(data, video, frame, 900000, 903600)
+		if err != nil {
+			panic(err)
+		}
+		utils.Assert(n%PacketSize == 0)
+
+		pes := demux(data[:n])[PIDElementaryStart+1]
+		utils.Assert(pes[7] == 0xC0 && readTimestamp(pes[9:]) == 903600 && readTimestamp(pes[14:]) == 900000)
+		utils.Assert(bytes.Equal(pes[19:], frame))
+	}
+
+	// 音频设置PES长度
+	frame := bytes.Repeat([]byte{0xAA}, 300)
+	data := muxer.AppendPacket(nil, 0, frame, 1000, 1000, true)
+	pes := demux(data)[PIDElementaryStart]
+	utils.Assert(int(pes[4])<<8|int(pes[5]) == 8+len(frame) && bytes.Equal(pes[14:], frame))
+}
+
+func TestAC3(t *testing.T) {
+	// 48kHz 32kbps 立体声, 每帧128字节
+	frame := make([]byte, 128)
+	copy(frame, []byte{0x0B, 0x77, 0x00, 0x00, 0x00, 0x40, 0x40})
+	dac3 := []byte{0x10, 0x10, 0x00}
+
+	muxer := NewMuxer()
+	_, _ = muxer.AddTrack(&avformat.AVStream{MediaType: utils.AVMediaTypeAudio, CodecID: utils.AVCodecIdAC3, Data: dac3})
+
+	// DVB方式: 私有数据加AC-3描述符
+	data := muxer.AppendHeader(nil)
+	pmt := demux(data)[PIDPMT][1:]
+	utils.Assert(pmt[12] == StreamTypePrivateData && pmt[16] == 5 && pmt[17] == ac3.DescriptorTagAC3)
+	utils.Assert(privateDataCodec([]byte{DescriptorTagRegistration, 4, 'E', 'A', 'C', '3'}) == utils.AVCodecIdEAC3)
+
+	// 一个PES包含2帧
+	for i := 0; i < 4; i++ {
+		data = muxer.AppendPacket(data, 0, append(append([]byte{}, frame...), frame...), int64(i*5760), int64(i*5760), true)
+	}
+
+	handler := &testHandler{}
+	demuxer := NewDemuxer()
+	demuxer.SetHandler(handler)
+	n, err := demuxer.Input(data)
+	utils.Assert(err == nil && n == len(data))
+	demuxer.Flush()
+
+	stream := handler.tracks[0].GetStream()
+	utils.Assert(utils.AVCodecIdAC3 == stream.CodecID && stream.SampleRate == 48000 && stream.Channels == 2 && bytes.Equal(stream.Data, dac3))
+	for i, packet := range handler.packets[:7] {
+		utils.Assert(packet.Dts == int64(i*2880) && bytes.Equal(packet.Data, frame))
+	}
+}
@@ -0,0 +1,99 @@
+package opus
+
+import (
+	"fmt"
+)
+
+const (
+	// SampleRate Opus解码输出固定为48kHz, 时长都以48kHz的采样数计算
+	SampleRate = 48000
+
+	// MaxPacketSamples 一个包最多120ms
+	MaxPacketSamples = 5760
+
+	ModeSILK   = 0
+	ModeHybrid = 1
+	ModeCELT   = 2
+)
+
+// TOC Opus包的第一个字节, 参考RFC 6716 3.1
+type TOC struct {
+	Config byte
+	Stereo bool
+	Code   byte // 0: 1帧, 1: 2帧等长, 2: 2帧不等长, 3: 任意帧数
+}
+
+func ParseTOC(b byte) TOC {
+	return TOC{Config: b >> 3, Stereo: b&0x4 != 0, Code: b & 0x3}
+}
+
+func (t TOC) Mode() int {
+	if t.Config < 12 {
+		return ModeSILK
+	} else if t.Config < 16 {
+		return ModeHybrid
+	}
+	return ModeCELT
+}
+
+// FrameSamples 每帧的采样数, 对应2.5ms到60ms
+func (t TOC) FrameSamples() int {
+	switch t.Mode() {
+	case ModeSILK:
+		// 10, 20, 40, 60ms
+		return []int{480, 960, 1920, 2880}[t.Config&0x3]
+	case ModeHybrid:
+		// 10, 20ms
+		return []int{480, 960}[t.Config&0x1]
+	default:
+		// 2.5, 5, 10, 20ms
+		return []int{120, 240, 480, 960}[t.Config&0x3]
+	}
+}
+
+// FrameCount 返回包内的帧数
+func FrameCount(packet []byte) (int, error) {
+	if len(packet) < 1 {
+		return 0, fmt.Errorf("empty opus packet")
+	}
+
+	switch ParseTOC(packet[0]).Code {
+	case 0:
+		return 1, nil
+	case 1, 2:
+		return 2, nil
+	default:
+		if len(packet) < 2 {
+			return 0, fmt.Errorf("invalid opus code 3 packet size %d", len(packet))
+		} else if count := int(packet[1] & 0x3F); count == 0 {
+			return 0, fmt.Errorf("invalid opus frame count 0")
+		} else {
+			return count, nil
+		}
+	}
+}
+
+// PacketSamples 返回包的总采样数, 超过120ms视为无效
+func PacketSamples(packet []byte) (int, error) {
+	count, err := FrameCount(packet)
+	if err != nil {
+		return 0, err
+	}
+
+	samples := count * ParseTOC(packet[0]).FrameSamples()
+	if samples > MaxPacketSamples {
+		return 0, fmt.Errorf("opus packet duration %d exceeds 120ms", samples)
+	}
+
+	return samples, nil
+}
+
+// PacketDuration 返回以timebase为单位的包时长
+func PacketDuration(packet []byte, timebase int) (int64, error) {
+	samples, err := PacketSamples(packet)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(samples) * int64(timebase) / SampleRate, nil
+}
@@ -0,0 +1,170 @@
+package opus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+var opusHeadMagic = []byte("OpusHead")
+
+/*
+RFC 7845 5.1
+ 0                   1                   2                   3
+ 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|      'O'      |      'p'      |      'u'      |      's'      |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|      'H'      |      'e'      |      'a'      |      'd'      |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|  Version = 1  | Channel Count |           Pre-skip            |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|                     Input Sample Rate (Hz)                    |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|   Output Gain (Q7.8 in dB)    | Mapping Family|               |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+               :
+|                                                               |
+:               Optional Channel Mapping Table...               :
+|                                                               |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+*/
+
+// OpusHead 标识头, 字段为小端序. 序列化结果即Matroska的CodecPrivate
+type OpusHead struct {
+	Version              byte
+	Channels             byte
+	PreSkip              uint16
+	InputSampleRate      uint32
+	OutputGain           int16
+	ChannelMappingFamily byte
+	StreamCount          byte // 以下字段仅在ChannelMappingFamily不为0时存在
+	CoupledCount         byte
+	ChannelMapping       []byte
+}
+
+// NewOpusHead 创建family 0的OpusHead, 只支持单声道和立体声. pre-skip使用推荐值80ms
+func NewOpusHead(channels, inputSampleRate int) *OpusHead {
+	return &OpusHead{
+		Version:         1,
+		Channels:        byte(channels),
+		PreSkip:         3840,
+		InputSampleRate: uint32(inputSampleRate),
+	}
+}
+
+func ParseOpusHead(data []byte) (*OpusHead, error) {
+	if len(data) < 19 || !bytes.Equal(data[:8], opusHeadMagic) {
+		return nil, fmt.Errorf("invalid opus head")
+	}
+
+	h := &OpusHead{
+		Version:              data[8],
+		Channels:             data[9],
+		PreSkip:              binary.LittleEndian.Uint16(data[10:]),
+		InputSampleRate:      binary.LittleEndian.Uint32(data[12:]),
+		OutputGain:           int16(binary.LittleEndian.Uint16(data[16:])),
+		ChannelMappingFamily: data[18],
+	}
+
+	// 高4位为主版本号, 不兼容
+	if h.Version>>4 != 0 {
+		return nil, fmt.Errorf("unsupported opus head version %d", h.Version)
+	} else if h.Channels == 0 {
+		return nil, fmt.Errorf("invalid opus channel count 0")
+	}
+
+	if h.ChannelMappingFamily != 0 {
+		if len(data) < 21+int(h.Channels) {
+			return nil, fmt.Errorf("invalid opus channel mapping table size %d", len(data))
+		}
+
+		h.StreamCount = data[19]
+		h.CoupledCount = data[20]
+		h.ChannelMapping = data[21 : 21+int(h.Channels)]
+	} else if h.Channels > 2 {
+		return nil, fmt.Errorf("invalid opus channel count %d for mapping family 0", h.Channels)
+	}
+
+	return h, nil
+}
+
+// Marshal 写入OpusHead, 即Matroska的CodecPrivate
+func (h *OpusHead) Marshal() []byte {
+	data := make([]byte, 19, 21+len(h.ChannelMapping))
+	copy(data, opusHeadMagic)
+	data[8] = h.Version
+	data[9] = h.Channels
+	binary.LittleEndian.PutUint16(data[10:], h.PreSkip)
+	binary.LittleEndian.PutUint32(data[12:], h.InputSampleRate)
+	binary.LittleEndian.PutUint16(data[16:], uint16(h.OutputGain))
+	data[18] = h.ChannelMappingFamily
+	if h.ChannelMappingFamily != 0 {
+		data = append(data, h.StreamCount, h.CoupledCount)
+		data = append(data, h.ChannelMapping...)
+	}
+
+	return data
+}
+
+/*
+Encapsulation of Opus in ISO Base Media File Format 4.3.2
+class OpusSpecificBox extends Box('dOps') {
+unsigned int(8) Version = 0;
+unsigned int(8) OutputChannelCount;
+unsigned int(16) PreSkip;
+unsigned int(32) InputSampleRate;
+signed int(16) OutputGain;
+unsigned int(8) ChannelMappingFamily;
+if (ChannelMappingFamily != 0) {
+unsigned int(8) StreamCount;
+unsigned int(8) CoupledCount;
+unsigned int(8 * OutputChannelCount) ChannelMapping;
+}
+}
+*/
+
+// MarshalDOps 写入dOps的内容, 字段为大端序
+func (h *OpusHead) MarshalDOps() []byte {
+	data := make([]byte, 11, 13+len(h.ChannelMapping))
+	data[1] = h.Channels
+	binary.BigEndian.PutUint16(data[2:], h.PreSkip)
+	binary.BigEndian.PutUint32(data[4:], h.InputSampleRate)
+	binary.BigEndian.PutUint16(data[8:], uint16(h.OutputGain))
+	data[10] = h.ChannelMappingFamily
+	if h.ChannelMappingFamily != 0 {
+		data = append(data, h.StreamCount, h.CoupledCount)
+		data = append(data, h.ChannelMapping...)
+	}
+
+	return data
+}
+
+// ParseDOps 解析dOps的内容
+func ParseDOps(data []byte) (*OpusHead, error) {
+	if len(data) < 11 {
+		return nil, fmt.Errorf("invalid dOps size %d", len(data))
+	} else if data[0] != 0 {
+		return nil, fmt.Errorf("unsupported dOps version %d", data[0])
+	}
+
+	h := &OpusHead{
+		Version:              1,
+		Channels:             data[1],
+		PreSkip:              binary.BigEndian.Uint16(data[2:]),
+		InputSampleRate:      binary.BigEndian.Uint32(data[4:]),
+		OutputGain:           int16(binary.BigEndian.Uint16(data[8:])),
+		ChannelMappingFamily: data[10],
+	}
+
+	if h.ChannelMappingFamily != 0 {
+		if len(data) < 13+int(h.Channels) {
+			return nil, fmt.Errorf("invalid dOps channel mapping table size %d", len(data))
+		}
+
+		h.StreamCount = data[11]
+		h.CoupledCount = data[12]
+		h.ChannelMapping = data[13 : 13+int(h.Channels)]
+	}
+
+	return h, nil
+}
@@ -0,0 +1,66 @@
+package opus
+
+import (
+	"bytes"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+func TestPacketDuration(t *testing.T) {
+	// config 1, SILK 20ms, 单帧
+	samples, err := PacketSamples([]byte{1 << 3, 0xFF})
+	utils.Assert(err == nil && samples == 960)
+
+	// config 16, CELT 2.5ms, 立体声2帧
+	samples, err = PacketSamples([]byte{16<<3 | 0x4 | 1, 0xFF})
+	utils.Assert(err == nil && samples == 240 && ParseTOC(16<<3|0x4).Stereo)
+
+	// config 3, SILK 60ms, code 3两帧
+	duration, err := PacketDuration([]byte{3<<3 | 3, 2, 0xFF}, 1000)
+	utils.Assert(err == nil && duration == 120)
+
+	// config 13, Hybrid 20ms, code 3三帧
+	samples, err = PacketSamples([]byte{13<<3 | 3, 0x80 | 3})
+	utils.Assert(err == nil && samples == 2880 && ParseTOC(13<<3).Mode() == ModeHybrid)
+
+	// 超过120ms
+	_, err = PacketSamples([]byte{3<<3 | 3, 3})
+	utils.Assert(err != nil)
+	_, err = FrameCount([]byte{3})
+	utils.Assert(err != nil)
+	_, err = FrameCount([]byte{3, 0})
+	utils.Assert(err != nil)
+}
+
+func TestOpusHead(t *testing.T) {
+	head := NewOpusHead(2, 44100)
+	data := head.Marshal()
+	utils.Assert(len(data) == 19 && bytes.Equal(data[:8], []byte("OpusHead")) && data[9] == 2 && data[10] == 0x00 && data[11] == 0x0F)
+
+	parsed, err := ParseOpusHead(data)
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(parsed.Channels == 2 && parsed.PreSkip == 3840 && parsed.InputSampleRate == 44100 && parsed.ChannelMappingFamily == 0)
+
+	// 5.1声道, family 1
+	surround := &OpusHead{Version: 1, Channels: 6, PreSkip: 312, InputSampleRate: 48000, OutputGain: -256, ChannelMappingFamily: 1,
+		StreamCount: 4, CoupledCount: 2, ChannelMapping: []byte{0, 4, 1, 2, 3, 5}}
+	dOps := surround.MarshalDOps()
+	utils.Assert(len(dOps) == 19 && dOps[0] == 0 && dOps[2] == 0x01 && dOps[3] == 0x38 && dOps[8] == 0xFF && dOps[9] == 0x00)
+
+	parsed, err = ParseDOps(dOps)
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(bytes.Equal(parsed.Marshal(), surround.Marshal()) && parsed.OutputGain == -256)
+
+	parsed, err = ParseOpusHead(surround.Marshal())
+	utils.Assert(err == nil && parsed.StreamCount == 4 && bytes.Equal(parsed.ChannelMapping, surround.ChannelMapping))
+
+	_, err = ParseOpusHead(surround.Marshal()[:22])
+	utils.Assert(err != nil)
+	data[9] = 3
+	_, err = ParseOpusHead(data)
+	utils.Assert(err != nil)
+}
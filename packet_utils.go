@@ -1,9 +1,13 @@
 package avformat
 
 import (
+	"github.com/lkmio/avformat/av1"
 	"github.com/lkmio/avformat/avc"
 	"github.com/lkmio/avformat/hevc"
 	"github.com/lkmio/avformat/utils"
+	"github.com/lkmio/avformat/vp8"
+	"github.com/lkmio/avformat/vp9"
+	"github.com/lkmio/avformat/vvc"
 )
 
 func ConvertTs(ts int64, srcTimeBase, dstTimeBase int) int64 {
@@ -70,6 +74,14 @@ func IsKeyFrame(id utils.AVCodecID, data []byte) bool {
 		return avc.IsKeyFrame(data)
 	} else if utils.AVCodecIdH265 == id {
 		return hevc.IsKeyFrame(data)
+	} else if utils.AVCodecIdH266 == id {
+		return vvc.IsKeyFrame(data)
+	} else if utils.AVCodecIdAV1 == id {
+		return av1.IsKeyFrame(data)
+	} else if utils.AVCodecIdVP8 == id {
+		return vp8.IsKeyFrame(data)
+	} else if utils.AVCodecIdVP9 == id {
+		return vp9.IsKeyFrame(data)
 	} else {
 		return false
 	}
@@ -0,0 +1,284 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	DefaultChunkSize = 128
+	MaxChunkSize     = 0xFFFFFF
+
+	// 消息类型
+	MessageTypeSetChunkSize     = 1
+	MessageTypeAbort            = 2
+	MessageTypeAcknowledgement  = 3
+	MessageTypeUserControl      = 4
+	MessageTypeWindowAckSize    = 5
+	MessageTypeSetPeerBandwidth = 6
+	MessageTypeAudio            = 8
+	MessageTypeVideo            = 9
+	MessageTypeDataAMF3         = 15
+	MessageTypeCommandAMF3      = 17
+	MessageTypeDataAMF0         = 18
+	MessageTypeCommandAMF0      = 20
+	MessageTypeAggregate        = 22
+
+	// 用户控制事件
+	UserControlStreamBegin  = 0
+	UserControlStreamEOF    = 1
+	UserControlStreamDry    = 2
+	UserControlSetBuffer    = 3
+	UserControlPingRequest  = 6
+	UserControlPingResponse = 7
+
+	// 发送使用的chunk stream id
+	chunkStreamControl = 2
+	chunkStreamCommand = 3
+	chunkStreamAudio   = 4
+	chunkStreamVideo   = 6
+
+	extendedTimestamp = 0xFFFFFF
+
+	// 单个消息的最大长度, 避免恶意数据撑爆内存
+	maxMessageSize = 16 * 1024 * 1024
+)
+
+type Message struct {
+	TypeID    byte
+	StreamID  uint32
+	Timestamp uint32
+	Payload   []byte
+}
+
+// 接收端每个chunk stream的状态, 用于还原type1/2/3的消息头
+type chunkStream struct {
+	timestamp uint32
+	delta     uint32
+	length    int
+	typeID    byte
+	streamID  uint32
+	extended  bool
+	payload   []byte
+	started   bool
+}
+
+// ChunkReader 从chunk流中还原消息
+type ChunkReader struct {
+	reader    io.Reader
+	chunkSize int
+	streams   map[uint32]*chunkStream
+	buffer    [16]byte
+}
+
+func (r *ChunkReader) SetChunkSize(size int) {
+	r.chunkSize = size
+}
+
+// Abort 丢弃chunk stream中未接收完的消息
+func (r *ChunkReader) Abort(csid uint32) {
+	if stream, ok := r.streams[csid]; ok {
+		stream.payload = nil
+	}
+}
+
+func (r *ChunkReader) read(n int) ([]byte, error) {
+	_, err := io.ReadFull(r.reader, r.buffer[:n])
+	return r.buffer[:n], err
+}
+
+// ReadMessage 读取chunk直到还原出一个完整的消息
+func (r *ChunkReader) ReadMessage() (*Message, error) {
+	for {
+		header, err := r.read(1)
+		if err != nil {
+			return nil, err
+		}
+
+		fmt_, csid := header[0]>>6, uint32(header[0]&0x3F)
+		if csid == 0 {
+			if header, err = r.read(1); err != nil {
+				return nil, err
+			}
+			csid = 64 + uint32(header[0])
+		} else if csid == 1 {
+			if header, err = r.read(2); err != nil {
+				return nil, err
+			}
+			csid = 64 + uint32(header[0]) + uint32(header[1])<<8
+		}
+
+		stream, ok := r.streams[csid]
+		if !ok {
+			stream = &chunkStream{}
+			r.streams[csid] = stream
+		}
+
+		if !stream.started && fmt_ != 0 {
+			return nil, fmt.Errorf("chunk stream %d missing type 0 header", csid)
+		} else if fmt_ < 2 && len(stream.payload) > 0 {
+			// 上一个消息未接收完, 不能修改消息长度
+			return nil, fmt.Errorf("chunk stream %d type %d header before message completed", csid, fmt_)
+		}
+
+		var ts uint32
+		switch fmt_ {
+		case 0:
+			if header, err = r.read(11); err != nil {
+				return nil, err
+			}
+			ts = uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2])
+			stream.length = int(header[3])<<16 | int(header[4])<<8 | int(header[5])
+			stream.typeID = header[6]
+			stream.streamID = binary.LittleEndian.Uint32(header[7:])
+		case 1:
+			if header, err = r.read(7); err != nil {
+				return nil, err
+			}
+			ts = uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2])
+			stream.length = int(header[3])<<16 | int(header[4])<<8 | int(header[5])
+			stream.typeID = header[6]
+		case 2:
+			if header, err = r.read(3); err != nil {
+				return nil, err
+			}
+			ts = uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2])
+		}
+
+		if fmt_ != 3 {
+			stream.extended = ts == extendedTimestamp
+		}
+
+		if stream.extended {
+			if header, err = r.read(4); err != nil {
+				return nil, err
+			}
+
+			// type3的扩展时间戳在分片中与之前相同, 只有新消息才使用
+			if fmt_ != 3 || len(stream.payload) == 0 {
+				ts = binary.BigEndian.Uint32(header)
+			}
+		}
+
+		// 新消息的时间戳
+		if len(stream.payload) == 0 {
+			switch fmt_ {
+			case 0:
+				stream.timestamp = ts
+				stream.delta = 0
+			case 1, 2:
+				stream.delta = ts
+				stream.timestamp += ts
+			case 3:
+				if stream.extended {
+					stream.delta = ts
+				}
+				stream.timestamp += stream.delta
+			}
+		}
+
+		stream.started = true
+		if stream.length > maxMessageSize {
+			return nil, fmt.Errorf("rtmp message size %d too large", stream.length)
+		} else if stream.length < len(stream.payload) {
+			return nil, fmt.Errorf("rtmp message size %d less than received %d", stream.length, len(stream.payload))
+		}
+
+		size := stream.length - len(stream.payload)
+		if size > r.chunkSize {
+			size = r.chunkSize
+		}
+
+		offset := len(stream.payload)
+		if cap(stream.payload) < stream.length {
+			payload := make([]byte, offset, stream.length)
+			copy(payload, stream.payload)
+			stream.payload = payload
+		}
+
+		stream.payload = stream.payload[:offset+size]
+		if _, err = io.ReadFull(r.reader, stream.payload[offset:]); err != nil {
+			return nil, err
+		}
+
+		if len(stream.payload) < stream.length {
+			continue
+		}
+
+		message := &Message{TypeID: stream.typeID, StreamID: stream.streamID, Timestamp: stream.timestamp, Payload: stream.payload}
+		stream.payload = nil
+		return message, nil
+	}
+}
+
+func NewChunkReader(reader io.Reader) *ChunkReader {
+	return &ChunkReader{reader: reader, chunkSize: DefaultChunkSize, streams: make(map[uint32]*chunkStream)}
+}
+
+// ChunkWriter 将消息切分成chunk, 第一个chunk使用type0, 后续使用type3
+type ChunkWriter struct {
+	writer    io.Writer
+	chunkSize int
+	buffer    []byte
+}
+
+func (w *ChunkWriter) SetChunkSize(size int) {
+	w.chunkSize = size
+}
+
+func (w *ChunkWriter) ChunkSize() int {
+	return w.chunkSize
+}
+
+func appendBasicHeader(dst []byte, fmt_ byte, csid uint32) []byte {
+	if csid < 64 {
+		return append(dst, fmt_<<6|byte(csid))
+	} else if csid < 320 {
+		return append(dst, fmt_<<6, byte(csid-64))
+	}
+
+	return append(dst, fmt_<<6|1, byte(csid-64), byte((csid-64)>>8))
+}
+
+// WriteMessage 写入消息, 非并发安全
+func (w *ChunkWriter) WriteMessage(csid uint32, message *Message) error {
+	ts := message.Timestamp
+	extended := ts >= extendedTimestamp
+	if extended {
+		ts = extendedTimestamp
+	}
+
+	length := len(message.Payload)
+	w.buffer = appendBasicHeader(w.buffer[:0], 0, csid)
+	w.buffer = append(w.buffer, byte(ts>>16), byte(ts>>8), byte(ts), byte(length>>16), byte(length>>8), byte(length), message.TypeID)
+	w.buffer = binary.LittleEndian.AppendUint32(w.buffer, message.StreamID)
+	if extended {
+		w.buffer = binary.BigEndian.AppendUint32(w.buffer, message.Timestamp)
+	}
+
+	payload := message.Payload
+	for first := true; first || len(payload) > 0; first = false {
+		if !first {
+			w.buffer = appendBasicHeader(w.buffer, 3, csid)
+			if extended {
+				w.buffer = binary.BigEndian.AppendUint32(w.buffer, message.Timestamp)
+			}
+		}
+
+		size := len(payload)
+		if size > w.chunkSize {
+			size = w.chunkSize
+		}
+
+		w.buffer = append(w.buffer, payload[:size]...)
+		payload = payload[size:]
+	}
+
+	_, err := w.writer.Write(w.buffer)
+	return err
+}
+
+func NewChunkWriter(writer io.Writer) *ChunkWriter {
+	return &ChunkWriter{writer: writer, chunkSize: DefaultChunkSize}
+}
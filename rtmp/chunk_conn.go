@@ -0,0 +1,148 @@
+package rtmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/lkmio/avformat/amf"
+	"io"
+	"net"
+	"sync"
+)
+
+// 统计读取的字节数, 用于发送Acknowledgement
+type countReader struct {
+	reader io.Reader
+	count  uint32
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += uint32(n)
+	return n, err
+}
+
+// chunkConn 服务端和客户端共用的连接, 处理协议控制消息和确认窗口
+type chunkConn struct {
+	conn        net.Conn
+	reader      *countReader
+	chunkReader *ChunkReader
+	writeMutex  sync.Mutex
+	chunkWriter *ChunkWriter
+	ackWindow   uint32
+	lastAck     uint32
+}
+
+// readMessage 读取下一个消息, 协议控制消息和ping在内部处理, 不返回
+func (c *chunkConn) readMessage() (*Message, error) {
+	for {
+		message, err := c.chunkReader.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		if c.ackWindow > 0 && c.reader.count-c.lastAck >= c.ackWindow {
+			c.lastAck = c.reader.count
+			if err = c.writeMessage(chunkStreamControl, newControlMessage(MessageTypeAcknowledgement, c.lastAck)); err != nil {
+				return nil, err
+			}
+		}
+
+		switch message.TypeID {
+		case MessageTypeSetChunkSize:
+			if len(message.Payload) < 4 {
+				return nil, fmt.Errorf("invalid set chunk size message")
+			}
+
+			size := int(binary.BigEndian.Uint32(message.Payload) & 0x7FFFFFFF)
+			if size < 1 || size > MaxChunkSize {
+				return nil, fmt.Errorf("invalid chunk size %d", size)
+			}
+
+			c.chunkReader.SetChunkSize(size)
+		case MessageTypeAbort:
+			if len(message.Payload) >= 4 {
+				c.chunkReader.Abort(binary.BigEndian.Uint32(message.Payload))
+			}
+		case MessageTypeWindowAckSize:
+			if len(message.Payload) >= 4 {
+				c.ackWindow = binary.BigEndian.Uint32(message.Payload)
+			}
+		case MessageTypeAcknowledgement, MessageTypeSetPeerBandwidth:
+			break
+		case MessageTypeUserControl:
+			if len(message.Payload) >= 6 && binary.BigEndian.Uint16(message.Payload) == UserControlPingRequest {
+				if err = c.writeMessage(chunkStreamControl, newUserControlMessage(UserControlPingResponse, binary.BigEndian.Uint32(message.Payload[2:]))); err != nil {
+					return nil, err
+				}
+				break
+			}
+
+			return message, nil
+		default:
+			return message, nil
+		}
+	}
+}
+
+func (c *chunkConn) writeMessage(csid uint32, message *Message) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.chunkWriter.WriteMessage(csid, message)
+}
+
+// 通知对端并修改发送的chunk大小
+func (c *chunkConn) setChunkSize(size int) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if err := c.chunkWriter.WriteMessage(chunkStreamControl, newControlMessage(MessageTypeSetChunkSize, uint32(size))); err != nil {
+		return err
+	}
+
+	c.chunkWriter.SetChunkSize(size)
+	return nil
+}
+
+func (c *chunkConn) writeCommand(streamID uint32, values ...interface{}) error {
+	message, err := NewCommandMessage(streamID, values...)
+	if err != nil {
+		return err
+	}
+
+	return c.writeMessage(chunkStreamCommand, message)
+}
+
+// 发送订阅的消息, 直到取消订阅或发送失败. 推流时onMetaData需要加上@setDataFrame
+func (c *chunkConn) sendMessages(sub *subscriber, streamID uint32, setDataFrame bool) error {
+	for message := range sub.messages {
+		csid := uint32(chunkStreamAudio)
+		payload := message.payload
+		if MessageTypeVideo == message.typeID {
+			csid = chunkStreamVideo
+		} else if MessageTypeDataAMF0 == message.typeID {
+			csid = chunkStreamCommand
+			if setDataFrame {
+				prefix, _ := amf.Encode("@setDataFrame")
+				payload = append(prefix, payload...)
+			}
+		}
+
+		err := c.writeMessage(csid, &Message{TypeID: message.typeID, StreamID: streamID, Timestamp: uint32(message.timestamp), Payload: payload})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newChunkConn(netConn net.Conn) *chunkConn {
+	reader := &countReader{reader: bufio.NewReaderSize(netConn, 4096)}
+	return &chunkConn{
+		conn:        netConn,
+		reader:      reader,
+		chunkReader: NewChunkReader(reader),
+		chunkWriter: NewChunkWriter(netConn),
+	}
+}
@@ -0,0 +1,460 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/amf"
+	"github.com/lkmio/avformat/flv"
+	"github.com/lkmio/avformat/utils"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultClientTimeout 建立连接和等待应答的超时时间
+	DefaultClientTimeout = 10 * time.Second
+
+	// 播放时发送的缓冲时长, 毫秒
+	clientBufferLength = 3000
+)
+
+// Client RTMP客户端, Play拉流并回调给OnUnpackStreamHandler, Publish将Stream中的AVPacket推送到服务器
+type Client struct {
+	host     string
+	app      string
+	name     string // 流名, 包含查询参数
+	tcURL    string
+	timeout  time.Duration
+	enhanced bool
+
+	reconnectAttempts int
+	reconnectInterval time.Duration
+
+	mutex         sync.Mutex
+	conn          *chunkConn
+	transactionID float64
+	streamID      uint32
+
+	// 拉流
+	handler  avformat.OnUnpackStreamHandler
+	demuxer  *flv.Demuxer
+	tsOffset uint32 // 重连后时间戳从上次结束的位置继续
+	lastTs   uint32
+
+	// 推流
+	stream *Stream
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	err       error
+}
+
+func (c *Client) SetHandler(handler avformat.OnUnpackStreamHandler) {
+	c.handler = handler
+}
+
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+// SetEnhanced 在connect中声明支持Enhanced RTMP, 推流时Stream也需要调用SetEnhanced
+func (c *Client) SetEnhanced(enhanced bool) {
+	c.enhanced = enhanced
+}
+
+// SetReconnect 设置断线重连, attempts为每次断开后的最大重试次数, 默认不重连
+func (c *Client) SetReconnect(attempts int, interval time.Duration) {
+	c.reconnectAttempts, c.reconnectInterval = attempts, interval
+}
+
+// Play 拉流, 成功后在后台接收数据
+func (c *Client) Play() error {
+	utils.Assert(c.handler != nil)
+
+	c.demuxer = flv.NewDemuxer()
+	c.demuxer.SetHandler(c.handler)
+	conn, err := c.start()
+	if err != nil {
+		return err
+	}
+
+	go c.run(conn)
+	return nil
+}
+
+// Publish 推流, stream需要设置为源Demuxer的handler, 等待track解析完毕后再连接服务器
+func (c *Client) Publish(stream *Stream) error {
+	if !stream.WaitTracks(c.timeout) {
+		return fmt.Errorf("stream not ready")
+	}
+
+	c.stream = stream
+	conn, err := c.start()
+	if err != nil {
+		return err
+	}
+
+	go c.run(conn)
+	return nil
+}
+
+// 建立连接并完成play/publish
+func (c *Client) start() (*chunkConn, error) {
+	netConn, err := net.DialTimeout("tcp", c.host, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := newChunkConn(netConn)
+	streamID, err := c.handshake(conn)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	_ = netConn.SetDeadline(time.Time{})
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// 主动关闭后不再建立连接
+	select {
+	case <-c.closed:
+		netConn.Close()
+		return nil, fmt.Errorf("client closed")
+	default:
+		c.conn, c.streamID = conn, streamID
+	}
+
+	return conn, nil
+}
+
+// 握手并完成connect/createStream/play或publish, 返回消息流ID
+func (c *Client) handshake(conn *chunkConn) (uint32, error) {
+	_ = conn.conn.SetDeadline(time.Now().Add(c.timeout))
+	if err := clientHandshake(conn.reader, conn.conn); err != nil {
+		return 0, err
+	} else if err = conn.setChunkSize(outChunkSize); err != nil {
+		return 0, err
+	}
+
+	object := amf.Object{
+		{Key: "app", Value: c.app},
+		{Key: "flashVer", Value: "FMLE/3.0 (compatible; avformat)"},
+		{Key: "tcUrl", Value: c.tcURL},
+	}
+
+	if c.stream != nil {
+		object.Set("type", "nonprivate")
+	} else {
+		object.Set("fpad", false)
+		object.Set("capabilities", 15)
+		object.Set("audioCodecs", 3191)
+		object.Set("videoCodecs", 252)
+		object.Set("videoFunction", 1)
+	}
+
+	if c.enhanced {
+		object.Set("fourCcList", []interface{}{"hvc1", "av01", "vp09"})
+	}
+
+	if _, err := c.call(conn, "connect", object); err != nil {
+		return 0, err
+	}
+
+	if c.stream != nil {
+		_ = conn.writeCommand(0, "releaseStream", c.nextTransactionID(), nil, c.name)
+		_ = conn.writeCommand(0, "FCPublish", c.nextTransactionID(), nil, c.name)
+	}
+
+	result, err := c.call(conn, "createStream", nil)
+	if err != nil {
+		return 0, err
+	} else if len(result.Arguments) == 0 {
+		return 0, fmt.Errorf("invalid createStream result")
+	}
+
+	id, _ := result.Arguments[0].(float64)
+	streamID := uint32(id)
+	if c.stream != nil {
+		if err = conn.writeCommand(streamID, "publish", 0, nil, c.name, "live"); err != nil {
+			return 0, err
+		}
+
+		return streamID, c.waitStatus(conn, "NetStream.Publish.Start")
+	}
+
+	if err = conn.writeCommand(streamID, "play", 0, nil, c.name); err != nil {
+		return 0, err
+	} else if err = conn.writeMessage(chunkStreamControl, newUserControlMessage(UserControlSetBuffer, streamID, clientBufferLength)); err != nil {
+		return 0, err
+	}
+
+	return streamID, c.waitStatus(conn, "NetStream.Play.Start")
+}
+
+func (c *Client) nextTransactionID() float64 {
+	c.transactionID++
+	return c.transactionID
+}
+
+// 发送命令并等待_result
+func (c *Client) call(conn *chunkConn, name string, object interface{}, args ...interface{}) (*Command, error) {
+	transactionID := c.nextTransactionID()
+	if err := conn.writeCommand(0, append([]interface{}{name, transactionID, object}, args...)...); err != nil {
+		return nil, err
+	}
+
+	for {
+		command, err := c.readCommand(conn)
+		if err != nil {
+			return nil, err
+		} else if command.TransactionID != transactionID {
+			continue
+		}
+
+		if command.Name == "_error" {
+			return nil, fmt.Errorf("rtmp %s failed: %s", name, statusDescription(command))
+		} else if command.Name == "_result" {
+			return command, nil
+		}
+	}
+}
+
+// 等待onStatus, 跳过其他状态, level为error时返回错误
+func (c *Client) waitStatus(conn *chunkConn, code string) error {
+	for {
+		command, err := c.readCommand(conn)
+		if err != nil {
+			return err
+		} else if command.Name != "onStatus" || len(command.Arguments) == 0 {
+			continue
+		}
+
+		info, _ := command.Arguments[0].(amf.Object)
+		if info.GetString("code") == code {
+			return nil
+		} else if info.GetString("level") == "error" {
+			return fmt.Errorf("rtmp %s", info.GetString("code"))
+		}
+	}
+}
+
+// 读取下一个命令, 握手阶段的其他消息被忽略
+func (c *Client) readCommand(conn *chunkConn) (*Command, error) {
+	for {
+		message, err := conn.readMessage()
+		if err != nil {
+			return nil, err
+		} else if MessageTypeCommandAMF0 != message.TypeID && MessageTypeCommandAMF3 != message.TypeID {
+			continue
+		}
+
+		payload := message.Payload
+		if MessageTypeCommandAMF3 == message.TypeID && len(payload) > 0 {
+			payload = payload[1:]
+		}
+
+		return ParseCommand(payload)
+	}
+}
+
+// 处理连接直到断开, 断开后按设置重连
+func (c *Client) run(conn *chunkConn) {
+	for {
+		var err error
+		if c.stream != nil {
+			err = c.servePublish(conn)
+		} else {
+			err = c.servePlay(conn)
+		}
+
+		conn.conn.Close()
+		select {
+		case <-c.closed:
+			return
+		default:
+		}
+
+		if errStreamClosed == err {
+			c.close(nil)
+			return
+		}
+
+		conn = nil
+		for attempt := 0; conn == nil && attempt < c.reconnectAttempts; attempt++ {
+			select {
+			case <-c.closed:
+				return
+			case <-time.After(c.reconnectInterval):
+			}
+
+			var reconnectErr error
+			if conn, reconnectErr = c.start(); reconnectErr != nil {
+				println(reconnectErr.Error())
+			}
+		}
+
+		if conn == nil {
+			c.close(err)
+			return
+		}
+
+		c.tsOffset = c.lastTs + 1
+	}
+}
+
+var errStreamClosed = fmt.Errorf("stream closed")
+
+func (c *Client) servePlay(conn *chunkConn) error {
+	for {
+		_ = conn.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		message, err := conn.readMessage()
+		if err != nil {
+			return err
+		}
+
+		switch message.TypeID {
+		case MessageTypeAudio, MessageTypeVideo:
+			c.lastTs = message.Timestamp + c.tsOffset
+			if err = c.demuxer.InputTag(message.TypeID, message.Payload, c.lastTs); err != nil {
+				println(err.Error())
+			}
+		case MessageTypeDataAMF0:
+			if err = c.demuxer.InputScript(message.Payload); err != nil {
+				println(err.Error())
+			}
+		case MessageTypeUserControl:
+			if len(message.Payload) >= 2 && binary.BigEndian.Uint16(message.Payload) == UserControlStreamEOF {
+				return io.EOF
+			}
+		case MessageTypeCommandAMF0:
+			command, err := ParseCommand(message.Payload)
+			if err != nil || command.Name != "onStatus" || len(command.Arguments) == 0 {
+				continue
+			}
+
+			info, _ := command.Arguments[0].(amf.Object)
+			if code := info.GetString("code"); code == "NetStream.Play.Stop" || code == "NetStream.Play.UnpublishNotify" {
+				return fmt.Errorf("rtmp %s", code)
+			}
+		}
+	}
+}
+
+// 发送订阅的消息, 同时读取服务器的控制消息
+func (c *Client) servePublish(conn *chunkConn) error {
+	sub, err := c.stream.subscribe()
+	if err != nil {
+		return errStreamClosed
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- conn.sendMessages(sub, c.streamID, true)
+		conn.conn.Close()
+	}()
+
+	for err == nil {
+		_, err = conn.readMessage()
+	}
+
+	// 源流被关闭时订阅已经被移除
+	if !c.stream.unsubscribe(sub) {
+		<-done
+		return errStreamClosed
+	}
+
+	if sendErr := <-done; sendErr != nil {
+		return sendErr
+	}
+
+	return err
+}
+
+func (c *Client) close(err error) {
+	c.closeOnce.Do(func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		c.err = err
+		close(c.closed)
+		if c.conn != nil {
+			c.conn.conn.Close()
+		}
+	})
+}
+
+// Close 断开连接, 推流时先发送deleteStream
+func (c *Client) Close() {
+	select {
+	case <-c.closed:
+		return
+	default:
+	}
+
+	c.mutex.Lock()
+	conn, streamID := c.conn, c.streamID
+	c.mutex.Unlock()
+	if conn != nil && c.stream != nil {
+		_ = conn.writeCommand(0, "FCUnpublish", 0, nil, c.name)
+		_ = conn.writeCommand(0, "deleteStream", 0, nil, streamID)
+	}
+
+	c.close(nil)
+}
+
+// Wait 等待连接断开, 返回断开原因, 主动关闭或推流的源流结束时返回nil
+func (c *Client) Wait() error {
+	<-c.closed
+	return c.err
+}
+
+// NewClient 创建客户端, rtmp://host[:port]/app/stream, 最后一级路径和查询参数作为流名
+func NewClient(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	} else if u.Scheme != "rtmp" {
+		return nil, fmt.Errorf("unsupported scheme: %s", u.Scheme)
+	}
+
+	path := strings.Trim(u.Path, "/")
+	i := strings.LastIndexByte(path, '/')
+	if i <= 0 || i == len(path)-1 {
+		return nil, fmt.Errorf("invalid rtmp url: %s", rawURL)
+	}
+
+	client := &Client{
+		host:    u.Host,
+		app:     path[:i],
+		name:    path[i+1:],
+		timeout: DefaultClientTimeout,
+		closed:  make(chan struct{}),
+	}
+
+	if u.Port() == "" {
+		client.host += ":1935"
+	}
+
+	if u.RawQuery != "" {
+		client.name += "?" + u.RawQuery
+	}
+
+	client.tcURL = "rtmp://" + u.Host + "/" + client.app
+	return client, nil
+}
+
+func statusDescription(command *Command) string {
+	if len(command.Arguments) > 0 {
+		if info, ok := command.Arguments[0].(amf.Object); ok {
+			return info.GetString("code")
+		}
+	}
+
+	return "unknown error"
+}
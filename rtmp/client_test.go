@@ -0,0 +1,144 @@
+package rtmp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/flv"
+	"github.com/lkmio/avformat/utils"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testHandler struct {
+	mutex   sync.Mutex
+	tracks  []avformat.Track
+	packets chan *avformat.AVPacket
+}
+
+func (h *testHandler) OnNewTrack(track avformat.Track) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.tracks = append(h.tracks, track)
+}
+
+func (h *testHandler) OnTrackComplete() {
+}
+
+func (h *testHandler) OnTrackNotFind() {
+}
+
+func (h *testHandler) OnPacket(packet *avformat.AVPacket) {
+	select {
+	case h.packets <- &avformat.AVPacket{Data: append([]byte{}, packet.Data...), Key: packet.Key, Dts: packet.Dts, MediaType: packet.MediaType}:
+	default:
+	}
+}
+
+// 等待下一个关键帧
+func (h *testHandler) waitKey() *avformat.AVPacket {
+	for {
+		select {
+		case packet := <-h.packets:
+			if packet.Key {
+				return packet
+			}
+		case <-time.After(5 * time.Second):
+			panic("wait key frame timeout")
+		}
+	}
+}
+
+// 源流, 持续输入视频帧直到stop被关闭
+func startTestSource(stop chan struct{}) *Stream {
+	record, _ := hex.DecodeString(testAVCRecord)
+	stream := NewStream()
+	demuxer := flv.NewDemuxer()
+	demuxer.SetHandler(stream)
+	_ = demuxer.InputVideo(append([]byte{0x17, 0, 0, 0, 0}, record...), 0)
+
+	input := func(i int) {
+		_ = demuxer.InputVideo(testVideoFrame(i%5 == 0), uint32(i*40))
+	}
+
+	for i := 0; i < 10; i++ {
+		input(i)
+	}
+
+	go func() {
+		defer stream.Close()
+		for i := 10; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(10 * time.Millisecond):
+				input(i)
+			}
+		}
+	}()
+
+	return stream
+}
+
+func TestClient(t *testing.T) {
+	server := NewServer()
+	if err := server.Start("127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	defer server.Close()
+
+	stop := make(chan struct{})
+	publisher, err := NewClient("rtmp://" + server.Addr().String() + "/live/test?token=1")
+	if err != nil {
+		panic(err)
+	}
+
+	publisher.SetReconnect(3, 100*time.Millisecond)
+	if err = publisher.Publish(startTestSource(stop)); err != nil {
+		panic(err)
+	}
+	utils.Assert(server.FindStream("live/test") != nil)
+
+	handler := &testHandler{packets: make(chan *avformat.AVPacket, 1024)}
+	player, err := NewClient("rtmp://" + server.Addr().String() + "/live/test")
+	if err != nil {
+		panic(err)
+	}
+
+	player.SetHandler(handler)
+	player.SetReconnect(10, 100*time.Millisecond)
+	if err = player.Play(); err != nil {
+		panic(err)
+	}
+
+	packet := handler.waitKey()
+	utils.Assert(bytes.Equal(packet.Data, testVideoFrame(true)[5:]))
+	handler.mutex.Lock()
+	utils.Assert(len(handler.tracks) == 1 && utils.AVCodecIdH264 == handler.tracks[0].GetStream().CodecID)
+	handler.mutex.Unlock()
+
+	// 服务端断开所有连接, 推流端和播放端重连后继续
+	server.mutex.Lock()
+	for c := range server.conns {
+		c.conn.Close()
+	}
+	server.mutex.Unlock()
+
+	time.Sleep(300 * time.Millisecond)
+	for len(handler.packets) > 0 {
+		<-handler.packets
+	}
+
+	next := handler.waitKey()
+	utils.Assert(next.Dts > packet.Dts)
+
+	// 源流结束后, 推流端正常退出
+	close(stop)
+	utils.Assert(publisher.Wait() == nil)
+	player.Close()
+	utils.Assert(player.Wait() == nil)
+
+	_, err = NewClient("rtmp://127.0.0.1/live")
+	utils.Assert(err != nil)
+}
@@ -0,0 +1,262 @@
+package rtmp
+
+import (
+	"github.com/lkmio/avformat/amf"
+	"github.com/lkmio/avformat/flv"
+	"net"
+	"sync"
+	"time"
+)
+
+// conn 一个TCP连接对应一个会话, 只支持一路推流或拉流
+type conn struct {
+	*chunkConn
+	server *Server
+	app    string
+
+	// 推流
+	publishing bool
+	path       string
+	stream     *Stream
+	demuxer    *flv.Demuxer
+
+	// 拉流
+	mutex      sync.Mutex
+	playing    bool
+	subscriber *subscriber
+	playStream *Stream
+	closed     bool
+}
+
+func (c *conn) run() {
+	defer c.close()
+
+	_ = c.conn.SetDeadline(time.Now().Add(readTimeout))
+	if err := serverHandshake(c.reader, c.conn); err != nil {
+		println(err.Error())
+		return
+	}
+	_ = c.conn.SetWriteDeadline(time.Time{})
+
+	for {
+		_ = c.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		message, err := c.readMessage()
+		if err != nil {
+			return
+		}
+
+		if err = c.handle(message); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+}
+
+func (c *conn) handle(message *Message) error {
+	switch message.TypeID {
+	case MessageTypeAudio, MessageTypeVideo:
+		if c.publishing {
+			if err := c.demuxer.InputTag(message.TypeID, message.Payload, message.Timestamp); err != nil {
+				println(err.Error())
+			}
+		}
+	case MessageTypeDataAMF3, MessageTypeDataAMF0:
+		payload := message.Payload
+		if MessageTypeDataAMF3 == message.TypeID && len(payload) > 0 {
+			payload = payload[1:]
+		}
+
+		// @setDataFrame onMetaData
+		if c.publishing {
+			if err := c.demuxer.InputScript(payload); err != nil {
+				println(err.Error())
+			}
+		}
+	case MessageTypeCommandAMF3, MessageTypeCommandAMF0:
+		payload := message.Payload
+		if MessageTypeCommandAMF3 == message.TypeID && len(payload) > 0 {
+			payload = payload[1:]
+		}
+
+		command, err := ParseCommand(payload)
+		if err != nil {
+			return err
+		}
+
+		return c.onCommand(message.StreamID, command)
+	}
+
+	return nil
+}
+
+func (c *conn) onCommand(streamID uint32, command *Command) error {
+	switch command.Name {
+	case "connect":
+		return c.onConnect(command)
+	case "releaseStream", "FCPublish", "FCUnpublish", "getStreamLength":
+		if command.Name == "FCUnpublish" {
+			c.stopPublish()
+		}
+		return c.writeResult(command.TransactionID, nil)
+	case "createStream":
+		return c.writeResult(command.TransactionID, nil, 1)
+	case "publish":
+		return c.onPublish(streamID, command)
+	case "play":
+		return c.onPlay(streamID, command)
+	case "deleteStream", "closeStream":
+		c.stopPublish()
+		c.stopPlay()
+	}
+
+	return nil
+}
+
+func (c *conn) onConnect(command *Command) error {
+	c.app = trimQuery(command.Object.GetString("app"))
+
+	if err := c.writeMessage(chunkStreamControl, newControlMessage(MessageTypeWindowAckSize, DefaultWindowAckSize)); err != nil {
+		return err
+	} else if err = c.writeMessage(chunkStreamControl, newSetPeerBandwidthMessage(DefaultWindowAckSize, 2)); err != nil {
+		return err
+	} else if err = c.setChunkSize(outChunkSize); err != nil {
+		return err
+	}
+
+	return c.writeResult(command.TransactionID, amf.Object{
+		{Key: "fmsVer", Value: "FMS/3,0,1,123"},
+		{Key: "capabilities", Value: 31},
+	}, amf.Object{
+		{Key: "level", Value: "status"},
+		{Key: "code", Value: "NetConnection.Connect.Success"},
+		{Key: "description", Value: "Connection succeeded."},
+		{Key: "objectEncoding", Value: 0},
+	})
+}
+
+func (c *conn) onPublish(streamID uint32, command *Command) error {
+	name := trimQuery(command.StringArgument(0))
+	if c.publishing || name == "" {
+		return c.writeMessage(chunkStreamCommand, newStatusMessage(streamID, "error", "NetStream.Publish.BadName", "Invalid stream name."))
+	}
+
+	path := c.app + "/" + name
+	stream := NewStream()
+	if (c.server.onPublish != nil && !c.server.onPublish(path, stream)) || !c.server.AddStream(path, stream) {
+		return c.writeMessage(chunkStreamCommand, newStatusMessage(streamID, "error", "NetStream.Publish.BadName", "Stream already publishing."))
+	}
+
+	demuxer := flv.NewDemuxer()
+	demuxer.SetHandler(stream)
+	c.path, c.stream, c.demuxer, c.publishing = path, stream, demuxer, true
+	return c.writeMessage(chunkStreamCommand, newStatusMessage(streamID, "status", "NetStream.Publish.Start", "Start publishing."))
+}
+
+func (c *conn) onPlay(streamID uint32, command *Command) error {
+	path := c.app + "/" + trimQuery(command.StringArgument(0))
+	stream := c.server.FindStream(path)
+	if stream == nil {
+		return c.writeMessage(chunkStreamCommand, newStatusMessage(streamID, "error", "NetStream.Play.StreamNotFound", "Stream not found."))
+	}
+
+	c.mutex.Lock()
+	playing := c.playing
+	c.playing = true
+	c.mutex.Unlock()
+	if playing {
+		return nil
+	}
+
+	if err := c.writeMessage(chunkStreamControl, newUserControlMessage(UserControlStreamBegin, streamID)); err != nil {
+		return err
+	} else if err = c.writeMessage(chunkStreamCommand, newStatusMessage(streamID, "status", "NetStream.Play.Reset", "Playing and resetting.")); err != nil {
+		return err
+	} else if err = c.writeMessage(chunkStreamCommand, newStatusMessage(streamID, "status", "NetStream.Play.Start", "Started playing.")); err != nil {
+		return err
+	}
+
+	go c.play(stream, streamID)
+	return nil
+}
+
+// 发送音视频消息, 直到会话关闭或取消订阅
+func (c *conn) play(stream *Stream, streamID uint32) {
+	if !stream.WaitTracks(playTimeout) {
+		c.conn.Close()
+		return
+	}
+
+	sub, err := stream.subscribe()
+	if err != nil {
+		println(err.Error())
+		c.conn.Close()
+		return
+	}
+
+	c.mutex.Lock()
+	if c.closed || !c.playing {
+		c.mutex.Unlock()
+		stream.unsubscribe(sub)
+		return
+	}
+	c.subscriber, c.playStream = sub, stream
+	c.mutex.Unlock()
+
+	if err = c.sendMessages(sub, streamID, false); err != nil {
+		println(err.Error())
+		c.conn.Close()
+		return
+	}
+
+	// 流被关闭, 主动停止播放的不断开连接
+	c.mutex.Lock()
+	stopped := c.subscriber != sub
+	c.mutex.Unlock()
+	if !stopped {
+		c.conn.Close()
+	}
+}
+
+func (c *conn) stopPlay() {
+	c.mutex.Lock()
+	sub, stream := c.subscriber, c.playStream
+	c.subscriber, c.playStream, c.playing = nil, nil, false
+	c.mutex.Unlock()
+
+	if sub != nil {
+		stream.unsubscribe(sub)
+	}
+}
+
+func (c *conn) stopPublish() {
+	if !c.publishing {
+		return
+	}
+
+	c.publishing = false
+	c.server.removeStream(c.path, c.stream)
+	c.demuxer.Close()
+	c.stream.Close()
+}
+
+func (c *conn) writeResult(transactionID float64, values ...interface{}) error {
+	return c.writeCommand(0, append([]interface{}{"_result", transactionID}, values...)...)
+}
+
+func (c *conn) close() {
+	c.conn.Close()
+	c.server.removeConn(c)
+	c.stopPublish()
+
+	c.mutex.Lock()
+	c.closed = true
+	c.mutex.Unlock()
+	c.stopPlay()
+}
+
+func newConn(server *Server, netConn net.Conn) *conn {
+	return &conn{
+		chunkConn: newChunkConn(netConn),
+		server:    server,
+	}
+}
@@ -0,0 +1,153 @@
+package rtmp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+)
+
+const (
+	handshakeVersion = 3
+	handshakeSize    = 1536
+	digestSize       = 32
+)
+
+var (
+	genuineKeyTail = []byte{
+		0xF0, 0xEE, 0xC2, 0x4A, 0x80, 0x68, 0xBE, 0xE8, 0x2E, 0x00, 0xD0, 0xD1,
+		0x02, 0x9E, 0x7E, 0x57, 0x6E, 0xEC, 0x5D, 0x2D, 0x29, 0x80, 0x6F, 0xAB,
+		0x93, 0xB8, 0xE6, 0x36, 0xCF, 0xEB, 0x31, 0xAE,
+	}
+
+	// 服务端使用前36字节计算S1, 全部68字节计算S2
+	genuineFMSKey = append([]byte("Genuine Adobe Flash Media Server 001"), genuineKeyTail...)
+	// 客户端使用前30字节计算C1, 全部62字节计算C2
+	genuineFPKey = append([]byte("Genuine Adobe Flash Player 001"), genuineKeyTail...)
+
+	serverVersion = []byte{0x04, 0x05, 0x00, 0x01}
+	clientVersion = []byte{0x09, 0x00, 0x7C, 0x02}
+)
+
+/*
+复杂握手C1/S1有两种结构, time(4)+version(4)后面是764字节的key和764字节的digest, schema0中digest在前.
+digest块: offset(4)+random(offset)+digest(32)+random, digest的位置由offset的4个字节之和对728取余得到.
+*/
+func digestOffset(data []byte, schema int) int {
+	base := 8
+	if schema == 1 {
+		base = 772
+	}
+
+	return (int(data[base])+int(data[base+1])+int(data[base+2])+int(data[base+3]))%728 + base + 4
+}
+
+// 计算除了digest之外所有数据的HMAC-SHA256
+func makeDigest(key, data []byte, offset int) []byte {
+	h := hmac.New(sha256.New, key)
+	if offset >= 0 {
+		h.Write(data[:offset])
+		h.Write(data[offset+digestSize:])
+	} else {
+		h.Write(data)
+	}
+
+	return h.Sum(nil)
+}
+
+// 查找C1/S1中的digest, 返回digest和所在的schema, 没有找到返回nil
+func findDigest(data, key []byte) ([]byte, int) {
+	for _, schema := range []int{1, 0} {
+		offset := digestOffset(data, schema)
+		if bytes.Equal(makeDigest(key, data, offset), data[offset:offset+digestSize]) {
+			return data[offset : offset+digestSize], schema
+		}
+	}
+
+	return nil, -1
+}
+
+// 生成带digest的C1/S1
+func newDigestPacket(key, version []byte, schema int) []byte {
+	packet := make([]byte, handshakeSize)
+	_, _ = rand.Read(packet[8:])
+	copy(packet[4:], version)
+
+	offset := digestOffset(packet, schema)
+	copy(packet[offset:], makeDigest(key, packet, offset))
+	return packet
+}
+
+// 生成C2/S2, 最后32字节是以对端digest为key计算的digest
+func newHandshakeResponse(key, peerDigest []byte) []byte {
+	packet := make([]byte, handshakeSize)
+	_, _ = rand.Read(packet)
+
+	tempKey := makeDigest(key, peerDigest, -1)
+	copy(packet[handshakeSize-digestSize:], makeDigest(tempKey, packet[:handshakeSize-digestSize], -1))
+	return packet
+}
+
+// 服务端握手, C1的version为0时使用简单握手, 否则尝试复杂握手, digest校验失败则回退简单握手
+func serverHandshake(reader io.Reader, writer io.Writer) error {
+	c0c1 := make([]byte, handshakeSize+1)
+	if _, err := io.ReadFull(reader, c0c1); err != nil {
+		return err
+	} else if c0c1[0] != handshakeVersion {
+		return fmt.Errorf("unsupported rtmp version %d", c0c1[0])
+	}
+
+	c1 := c0c1[1:]
+	var s1, s2 []byte
+	if !bytes.Equal(c1[4:8], []byte{0, 0, 0, 0}) {
+		if digest, schema := findDigest(c1, genuineFPKey[:30]); digest != nil {
+			s1 = newDigestPacket(genuineFMSKey[:36], serverVersion, schema)
+			s2 = newHandshakeResponse(genuineFMSKey, digest)
+		}
+	}
+
+	if s1 == nil {
+		s1 = make([]byte, handshakeSize)
+		_, _ = rand.Read(s1[8:])
+		s2 = c1
+	}
+
+	response := make([]byte, 0, 1+handshakeSize*2)
+	response = append(response, handshakeVersion)
+	response = append(response, s1...)
+	response = append(response, s2...)
+	if _, err := writer.Write(response); err != nil {
+		return err
+	}
+
+	// C2不做校验
+	_, err := io.ReadFull(reader, make([]byte, handshakeSize))
+	return err
+}
+
+// 客户端握手, 使用复杂握手, 服务端不支持时按简单握手回显S1
+func clientHandshake(reader io.Reader, writer io.Writer) error {
+	c1 := newDigestPacket(genuineFPKey[:30], clientVersion, 1)
+	if _, err := writer.Write(append([]byte{handshakeVersion}, c1...)); err != nil {
+		return err
+	}
+
+	s0s1s2 := make([]byte, handshakeSize*2+1)
+	if _, err := io.ReadFull(reader, s0s1s2); err != nil {
+		return err
+	} else if s0s1s2[0] != handshakeVersion {
+		return fmt.Errorf("unsupported rtmp version %d", s0s1s2[0])
+	}
+
+	// S2不做校验
+	s1 := s0s1s2[1 : 1+handshakeSize]
+	c2 := s1
+	if digest, _ := findDigest(s1, genuineFMSKey[:36]); digest != nil {
+		c2 = newHandshakeResponse(genuineFPKey, digest)
+	}
+
+	_, err := writer.Write(c2)
+	return err
+}
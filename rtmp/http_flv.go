@@ -0,0 +1,124 @@
+package rtmp
+
+import (
+	"github.com/lkmio/avformat/flv"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultWriteTimeout 播放端长时间不读取时断开, 取消订阅
+const DefaultWriteTimeout = 10 * time.Second
+
+// HTTPFLVHandler 以HTTP-FLV或WebSocket-FLV播放Stream, 请求路径为/{app}/{name}.flv.
+// 先发送FLV头, onMetaData和sequence header, 再从最近的关键帧开始发送.
+type HTTPFLVHandler struct {
+	find         func(path string) *Stream
+	writeTimeout time.Duration
+}
+
+// SetWriteTimeout 设置每次发送的超时时间
+func (h *HTTPFLVHandler) SetWriteTimeout(timeout time.Duration) {
+	h.writeTimeout = timeout
+}
+
+func (h *HTTPFLVHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(trimQuery(r.URL.Path), ".flv")
+	stream := h.find(path)
+	if stream == nil || !stream.WaitTracks(playTimeout) {
+		http.NotFound(w, r)
+		return
+	}
+
+	sub, err := stream.subscribe()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	defer stream.unsubscribe(sub)
+	hasVideo, hasAudio := stream.mediaFlags()
+	header := flv.AppendHeader(nil, hasVideo, hasAudio)
+
+	if isWebSocket(r) {
+		conn, err := acceptWebSocket(w, r)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+
+		defer conn.Close()
+		conn.writeTimeout = h.writeTimeout
+		// 客户端断开后取消订阅, 结束发送
+		go func() {
+			_ = conn.readLoop()
+			stream.unsubscribe(sub)
+		}()
+
+		// 每个tag一个binary帧
+		send := func(data []byte) error {
+			return conn.writeFrame(wsOpBinary, data)
+		}
+
+		if err = sendTags(sub, header, send); err != nil {
+			println(err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/x-flv")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+
+	go func() {
+		<-r.Context().Done()
+		stream.unsubscribe(sub)
+	}()
+
+	flusher, _ := w.(http.Flusher)
+	// 与http.ResponseController相同, 通过接口设置底层连接的写超时
+	deadline, _ := w.(interface{ SetWriteDeadline(time.Time) error })
+	send := func(data []byte) error {
+		if deadline != nil && h.writeTimeout > 0 {
+			_ = deadline.SetWriteDeadline(time.Now().Add(h.writeTimeout))
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+
+		// 没有待发送的消息时再刷新
+		if flusher != nil && len(sub.messages) == 0 {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	if err = sendTags(sub, header, send); err != nil {
+		println(err.Error())
+	}
+}
+
+// sendTags 发送FLV头和订阅的消息, 直到取消订阅或发送失败
+func sendTags(sub *subscriber, header []byte, send func(data []byte) error) error {
+	if err := send(header); err != nil {
+		return err
+	}
+
+	var buffer []byte
+	for message := range sub.messages {
+		// RTMP的消息类型和FLV的tag类型相同
+		buffer = flv.AppendTag(buffer[:0], message.typeID, uint32(message.timestamp), message.payload)
+		if err := send(buffer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NewHTTPFLVHandler find根据路径查找流, 例如Server.FindStream
+func NewHTTPFLVHandler(find func(path string) *Stream) *HTTPFLVHandler {
+	return &HTTPFLVHandler{find: find, writeTimeout: DefaultWriteTimeout}
+}
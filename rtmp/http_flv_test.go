@@ -0,0 +1,233 @@
+package rtmp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/flv"
+	"github.com/lkmio/avformat/utils"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 读取FLV数据输入demuxer, 从handler获取解析出的AVPacket
+func readFLV(reader io.Reader) (*flv.Demuxer, *testHandler) {
+	handler := &testHandler{packets: make(chan *avformat.AVPacket, 1024)}
+	demuxer := flv.NewDemuxer()
+	demuxer.SetHandler(handler)
+	go func() {
+		var buffer []byte
+		data := make([]byte, 4096)
+		for {
+			n, err := reader.Read(data)
+			if err != nil {
+				return
+			}
+
+			buffer = append(buffer, data[:n]...)
+			consumed, err := demuxer.Input(buffer)
+			if err != nil {
+				panic(err)
+			}
+			buffer = buffer[consumed:]
+		}
+	}()
+
+	return demuxer, handler
+}
+
+// 从WebSocket帧中读取payload
+type wsReader struct {
+	reader *bufio.Reader
+	frame  []byte
+}
+
+func (r *wsReader) Read(p []byte) (int, error) {
+	for len(r.frame) == 0 {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(r.reader, header); err != nil {
+			return 0, err
+		}
+
+		utils.Assert(header[0] == 0x80|wsOpBinary && header[1]&0x80 == 0)
+		length := uint64(header[1] & 0x7F)
+		if length == 126 {
+			_, _ = io.ReadFull(r.reader, header)
+			length = uint64(binary.BigEndian.Uint16(header))
+		} else if length == 127 {
+			bytes := make([]byte, 8)
+			_, _ = io.ReadFull(r.reader, bytes)
+			length = binary.BigEndian.Uint64(bytes)
+		}
+
+		r.frame = make([]byte, length)
+		if _, err := io.ReadFull(r.reader, r.frame); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.frame)
+	r.frame = r.frame[n:]
+	return n, nil
+}
+
+func TestHTTPFLV(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	stream := startTestSource(stop)
+	utils.Assert(stream.WaitTracks(time.Second))
+
+	server := httptest.NewServer(NewHTTPFLVHandler(func(path string) *Stream {
+		if path == "live/test" {
+			return stream
+		}
+		return nil
+	}))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/live/missing.flv")
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(response.StatusCode == http.StatusNotFound)
+	response.Body.Close()
+
+	// HTTP-FLV, 从缓存的关键帧开始
+	response, err = http.Get(server.URL + "/live/test.flv")
+	if err != nil {
+		panic(err)
+	}
+	defer response.Body.Close()
+	utils.Assert(response.StatusCode == http.StatusOK && response.Header.Get("Content-Type") == "video/x-flv")
+
+	demuxer, handler := readFLV(response.Body)
+	packet := handler.waitKey()
+	utils.Assert(packet.Dts == 0 && bytes.Equal(packet.Data, testVideoFrame(true)[5:]))
+	utils.Assert(demuxer.MetaData() != nil && demuxer.MetaData().HasVideo)
+
+	// WebSocket-FLV
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	request := "GET /live/test.flv HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"
+	if _, err = conn.Write([]byte(request)); err != nil {
+		panic(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	wsResponse, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(wsResponse.StatusCode == http.StatusSwitchingProtocols)
+	utils.Assert(wsResponse.Header.Get("Sec-WebSocket-Accept") == "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+
+	_, handler = readFLV(&wsReader{reader: reader})
+	packet = handler.waitKey()
+	utils.Assert(bytes.Equal(packet.Data, testVideoFrame(true)[5:]))
+}
+
+// 会话不读取时, 源流不阻塞, 丢弃到下一个关键帧
+func TestSlowSubscriber(t *testing.T) {
+	record, _ := hex.DecodeString(testAVCRecord)
+	stream := NewStream()
+	demuxer := flv.NewDemuxer()
+	demuxer.SetHandler(stream)
+	_ = demuxer.InputVideo(append([]byte{0x17, 0, 0, 0, 0}, record...), 0)
+	for i := 0; i < 10; i++ {
+		_ = demuxer.InputVideo(testVideoFrame(i%5 == 0), uint32(i*40))
+	}
+
+	sub, err := stream.subscribe()
+	if err != nil {
+		panic(err)
+	}
+
+	for i := 10; i < subscriberQueueSize*2; i++ {
+		_ = demuxer.InputVideo(testVideoFrame(i%5 == 0), uint32(i*40))
+	}
+	utils.Assert(len(sub.messages) == subscriberQueueSize)
+
+	for len(sub.messages) > 0 {
+		<-sub.messages
+	}
+
+	_ = demuxer.InputVideo(testVideoFrame(false), uint32(subscriberQueueSize*2*40))
+	_ = demuxer.InputVideo(testVideoFrame(true), uint32(subscriberQueueSize*2*40+40))
+	_ = demuxer.InputVideo(testVideoFrame(false), uint32(subscriberQueueSize*2*40+80))
+	message := <-sub.messages
+	utils.Assert(bytes.Equal(message.payload, testVideoFrame(true)))
+	utils.Assert(stream.unsubscribe(sub))
+}
+
+// 播放端不读取时, 写超时后取消订阅
+func TestWriteTimeout(t *testing.T) {
+	record, _ := hex.DecodeString(testAVCRecord)
+	stream := NewStream()
+	demuxer := flv.NewDemuxer()
+	demuxer.SetHandler(stream)
+	_ = demuxer.InputVideo(append([]byte{0x17, 0, 0, 0, 0}, record...), 0)
+
+	// 1MB的关键帧, 尽快填满socket缓冲区
+	frame := append([]byte{0x17, 1, 0, 0, 0}, binary.BigEndian.AppendUint32(nil, 1024*1024)...)
+	frame = append(frame, 0x65, 0x88)
+	frame = append(frame, make([]byte, 1024*1024-2)...)
+	input := func(i int) {
+		_ = demuxer.InputVideo(frame, uint32(i*40))
+	}
+	input(0)
+	input(1)
+
+	handler := NewHTTPFLVHandler(func(path string) *Stream {
+		return stream
+	})
+	handler.SetWriteTimeout(200 * time.Millisecond)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	subscribers := func() int {
+		stream.mutex.Lock()
+		defer stream.mutex.Unlock()
+		return len(stream.subscribers)
+	}
+
+	requests := []string{
+		"GET /live/test.flv HTTP/1.1\r\nHost: test\r\n\r\n",
+		"GET /live/test.flv HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n",
+	}
+
+	for i, request := range requests {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+		if err != nil {
+			panic(err)
+		}
+
+		if _, err = conn.Write([]byte(request)); err != nil {
+			panic(err)
+		}
+
+		subscribed := false
+		deadline := time.Now().Add(5 * time.Second)
+		for n := 2; !subscribed || subscribers() > 0; n++ {
+			if !subscribed && subscribers() > 0 {
+				subscribed = true
+			}
+
+			utils.Assert(time.Now().Before(deadline))
+			input(i*1000 + n)
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		conn.Close()
+	}
+}
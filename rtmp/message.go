@@ -0,0 +1,86 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/lkmio/avformat/amf"
+)
+
+// Command AMF0命令消息
+type Command struct {
+	Name          string
+	TransactionID float64
+	Object        amf.Object // 命令对象, 为null时是nil
+	Arguments     []interface{}
+}
+
+// ParseCommand 解析AMF0命令, AMF3命令需要先去掉第一个字节
+func ParseCommand(payload []byte) (*Command, error) {
+	values, err := amf.Decode(payload)
+	if err != nil {
+		return nil, err
+	} else if len(values) < 2 {
+		return nil, fmt.Errorf("invalid rtmp command")
+	}
+
+	name, ok := values[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid rtmp command name")
+	}
+
+	command := &Command{Name: name}
+	command.TransactionID, _ = values[1].(float64)
+	if len(values) > 2 {
+		command.Object, _ = values[2].(amf.Object)
+		command.Arguments = values[3:]
+	}
+
+	return command, nil
+}
+
+// StringArgument 返回第index个字符串参数
+func (c *Command) StringArgument(index int) string {
+	if index >= len(c.Arguments) {
+		return ""
+	}
+
+	str, _ := c.Arguments[index].(string)
+	return str
+}
+
+// NewCommandMessage 生成AMF0命令消息
+func NewCommandMessage(streamID uint32, values ...interface{}) (*Message, error) {
+	payload, err := amf.Encode(values...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{TypeID: MessageTypeCommandAMF0, StreamID: streamID, Payload: payload}, nil
+}
+
+// 生成onStatus命令
+func newStatusMessage(streamID uint32, level, code, description string) *Message {
+	message, _ := NewCommandMessage(streamID, "onStatus", 0, nil, amf.Object{
+		{Key: "level", Value: level},
+		{Key: "code", Value: code},
+		{Key: "description", Value: description},
+	})
+	return message
+}
+
+func newControlMessage(typeID byte, value uint32) *Message {
+	return &Message{TypeID: typeID, Payload: binary.BigEndian.AppendUint32(nil, value)}
+}
+
+func newSetPeerBandwidthMessage(size uint32, limitType byte) *Message {
+	return &Message{TypeID: MessageTypeSetPeerBandwidth, Payload: append(binary.BigEndian.AppendUint32(nil, size), limitType)}
+}
+
+func newUserControlMessage(event uint16, values ...uint32) *Message {
+	payload := binary.BigEndian.AppendUint16(nil, event)
+	for _, value := range values {
+		payload = binary.BigEndian.AppendUint32(payload, value)
+	}
+
+	return &Message{TypeID: MessageTypeUserControl, Payload: payload}
+}
@@ -0,0 +1,145 @@
+package rtmp
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultWindowAckSize 发送给对端的确认窗口大小
+	DefaultWindowAckSize = 2500000
+
+	// 发送使用的chunk大小
+	outChunkSize = 4096
+
+	// play等待track解析完毕的时间
+	playTimeout = 5 * time.Second
+
+	// 读超时, 期间没有收到任何消息则断开
+	readTimeout = 60 * time.Second
+)
+
+// Server RTMP服务器, 支持推流(publish)和拉流(play), 推流的路径为app/stream
+type Server struct {
+	mutex     sync.Mutex
+	listener  net.Listener
+	streams   map[string]*Stream
+	conns     map[*conn]struct{}
+	onPublish func(path string, stream *Stream) bool
+	closed    bool
+}
+
+// SetOnPublishHandler 设置推流回调, 返回false拒绝推流, 未设置时允许所有推流
+func (s *Server) SetOnPublishHandler(onPublish func(path string, stream *Stream) bool) {
+	s.onPublish = onPublish
+}
+
+// AddStream 添加可以播放的流, path为app/stream
+func (s *Server) AddStream(path string, stream *Stream) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	path = strings.Trim(path, "/")
+	if _, ok := s.streams[path]; ok {
+		return false
+	}
+
+	s.streams[path] = stream
+	return true
+}
+
+func (s *Server) RemoveStream(path string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.streams, strings.Trim(path, "/"))
+}
+
+func (s *Server) removeStream(path string, stream *Stream) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.streams[path] == stream {
+		delete(s.streams, path)
+	}
+}
+
+func (s *Server) FindStream(path string) *Stream {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.streams[strings.Trim(path, "/")]
+}
+
+// Start 监听TCP地址并开始接受连接
+func (s *Server) Start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.listener = listener
+	go s.accept()
+	return nil
+}
+
+func (s *Server) accept() {
+	for {
+		netConn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c := newConn(s, netConn)
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			netConn.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.mutex.Unlock()
+
+		go c.run()
+	}
+}
+
+func (s *Server) removeConn(c *conn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.conns, c)
+}
+
+// Addr 返回TCP监听地址
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *Server) Close() {
+	s.mutex.Lock()
+	s.closed = true
+	conns := s.conns
+	s.conns = make(map[*conn]struct{})
+	s.mutex.Unlock()
+
+	if s.listener != nil {
+		s.listener.Close()
+	}
+
+	for c := range conns {
+		c.conn.Close()
+	}
+}
+
+func NewServer() *Server {
+	return &Server{
+		streams: make(map[string]*Stream),
+		conns:   make(map[*conn]struct{}),
+	}
+}
+
+// 去掉app或流名中的查询参数
+func trimQuery(name string) string {
+	name, _, _ = strings.Cut(name, "?")
+	return strings.Trim(name, "/")
+}
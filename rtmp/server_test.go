@@ -0,0 +1,281 @@
+package rtmp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/lkmio/avformat/amf"
+	"github.com/lkmio/avformat/flv"
+	"github.com/lkmio/avformat/utils"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+const testAVCRecord = "0142c01effe100186742c01eda01e0089f961000000300100000030320f162ea01000568ce0f2c80"
+
+type testConn struct {
+	conn   net.Conn
+	reader *ChunkReader
+	writer *ChunkWriter
+	tid    float64
+}
+
+// 简单握手
+func dialTest(server *Server) *testConn {
+	netConn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+
+	c0c1 := make([]byte, handshakeSize+1)
+	c0c1[0] = handshakeVersion
+	_, _ = rand.Read(c0c1[9:])
+	if _, err = netConn.Write(c0c1); err != nil {
+		panic(err)
+	}
+
+	s0s1s2 := make([]byte, handshakeSize*2+1)
+	if _, err = io.ReadFull(netConn, s0s1s2); err != nil {
+		panic(err)
+	}
+
+	utils.Assert(bytes.Equal(s0s1s2[1+handshakeSize:], c0c1[1:]))
+	if _, err = netConn.Write(s0s1s2[1 : 1+handshakeSize]); err != nil {
+		panic(err)
+	}
+
+	_ = netConn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &testConn{conn: netConn, reader: NewChunkReader(netConn), writer: NewChunkWriter(netConn)}
+}
+
+func (c *testConn) write(typeID byte, streamID, ts uint32, payload []byte) {
+	if err := c.writer.WriteMessage(chunkStreamVideo, &Message{TypeID: typeID, StreamID: streamID, Timestamp: ts, Payload: payload}); err != nil {
+		panic(err)
+	}
+}
+
+// 发送命令并等待应答, 返回应答命令
+func (c *testConn) call(streamID uint32, name string, args ...interface{}) *Command {
+	c.tid++
+	message, err := NewCommandMessage(streamID, append([]interface{}{name, c.tid}, args...)...)
+	if err != nil {
+		panic(err)
+	} else if err = c.writer.WriteMessage(chunkStreamCommand, message); err != nil {
+		panic(err)
+	}
+
+	for {
+		message := c.read()
+		if MessageTypeCommandAMF0 != message.TypeID {
+			continue
+		}
+
+		command, err := ParseCommand(message.Payload)
+		if err != nil {
+			panic(err)
+		}
+
+		// play先应答Play.Reset
+		if command.Name == "onStatus" && len(command.Arguments) > 0 && command.Arguments[0].(amf.Object).GetString("code") == "NetStream.Play.Reset" {
+			continue
+		}
+
+		return command
+	}
+}
+
+func (c *testConn) read() *Message {
+	for {
+		message, err := c.reader.ReadMessage()
+		if err != nil {
+			panic(err)
+		}
+
+		if MessageTypeSetChunkSize == message.TypeID {
+			c.reader.SetChunkSize(int(message.Payload[0])<<24 | int(message.Payload[1])<<16 | int(message.Payload[2])<<8 | int(message.Payload[3]))
+			continue
+		}
+
+		return message
+	}
+}
+
+func (c *testConn) connect(app string) {
+	result := c.call(0, "connect", amf.Object{{Key: "app", Value: app}, {Key: "tcUrl", Value: "rtmp://127.0.0.1/" + app}})
+	utils.Assert(result.Name == "_result")
+	utils.Assert(result.Arguments[0].(amf.Object).GetString("code") == "NetConnection.Connect.Success")
+	utils.Assert(c.call(0, "createStream", nil).Name == "_result")
+}
+
+func statusCode(command *Command) string {
+	return command.Arguments[0].(amf.Object).GetString("code")
+}
+
+func testVideoFrame(key bool) []byte {
+	if key {
+		return []byte{0x17, 1, 0, 0, 0, 0, 0, 0, 5, 0x65, 0x88, 0x84, 0x00, 0x10}
+	}
+
+	return []byte{0x27, 1, 0, 0, 0, 0, 0, 0, 4, 0x41, 0x9a, 0x02, 0x03}
+}
+
+func TestServer(t *testing.T) {
+	server := NewServer()
+	if err := server.Start("127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	defer server.Close()
+
+	record, _ := hex.DecodeString(testAVCRecord)
+	publisher := dialTest(server)
+	publisher.connect("live")
+	utils.Assert(statusCode(publisher.call(1, "publish", nil, "test?token=1", "live")) == "NetStream.Publish.Start")
+
+	// 重复推流
+	other := dialTest(server)
+	other.connect("live")
+	utils.Assert(statusCode(other.call(1, "publish", nil, "test", "live")) == "NetStream.Publish.BadName")
+	other.conn.Close()
+
+	if err := publisher.writer.WriteMessage(chunkStreamControl, newControlMessage(MessageTypeSetChunkSize, 16)); err != nil {
+		panic(err)
+	}
+	publisher.writer.SetChunkSize(16)
+	publisher.write(MessageTypeVideo, 1, 0, append([]byte{0x17, 0, 0, 0, 0}, record...))
+	for i := 0; i < 10; i++ {
+		publisher.write(MessageTypeVideo, 1, uint32(i*40), testVideoFrame(i%5 == 0))
+	}
+
+	stream := server.FindStream("live/test")
+	utils.Assert(stream != nil && stream.WaitTracks(time.Second))
+
+	player := dialTest(server)
+	player.connect("live")
+	utils.Assert(statusCode(player.call(1, "play", nil, "missing")) == "NetStream.Play.StreamNotFound")
+	utils.Assert(statusCode(player.call(1, "play", nil, "test")) == "NetStream.Play.Start")
+
+	// 等待订阅
+	time.Sleep(100 * time.Millisecond)
+	for i := 10; i < 20; i++ {
+		publisher.write(MessageTypeVideo, 1, uint32(i*40), testVideoFrame(i%5 == 0))
+	}
+
+	// 先发送onMetaData
+	data := player.read()
+	utils.Assert(MessageTypeDataAMF0 == data.TypeID)
+	metaData, err := flv.ParseMetaData(data.Payload)
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(metaData.HasVideo && !metaData.HasAudio && metaData.Width > 0 && metaData.Height > 0)
+	utils.Assert(metaData.VideoCodecID == float64(flv.VideoCodecAVC))
+
+	header := player.read()
+	utils.Assert(MessageTypeVideo == header.TypeID && header.Payload[0] == 0x17 && header.Payload[1] == flv.AVCPacketTypeSequenceHeader)
+
+	frame := player.read()
+	utils.Assert(MessageTypeVideo == frame.TypeID && frame.Timestamp == 0 && frame.StreamID == 1)
+	utils.Assert(bytes.Equal(frame.Payload, testVideoFrame(true)))
+
+	frame = player.read()
+	utils.Assert(frame.Timestamp == 40 && bytes.Equal(frame.Payload, testVideoFrame(false)))
+
+	// 推流断开后, 播放端也断开
+	publisher.conn.Close()
+	for {
+		if _, err := player.reader.ReadMessage(); err != nil {
+			break
+		}
+	}
+	utils.Assert(server.FindStream("live/test") == nil)
+}
+
+func TestComplexHandshake(t *testing.T) {
+	client, serverConn := net.Pipe()
+	go func() {
+		_ = serverHandshake(serverConn, serverConn)
+	}()
+
+	c1 := newDigestPacket(genuineFPKey[:30], []byte{9, 0, 124, 2}, 1)
+	if _, err := client.Write(append([]byte{handshakeVersion}, c1...)); err != nil {
+		panic(err)
+	}
+
+	s0s1s2 := make([]byte, handshakeSize*2+1)
+	if _, err := io.ReadFull(client, s0s1s2); err != nil {
+		panic(err)
+	}
+
+	s1, s2 := s0s1s2[1:1+handshakeSize], s0s1s2[1+handshakeSize:]
+	digest, schema := findDigest(s1, genuineFMSKey[:36])
+	utils.Assert(digest != nil && schema == 1)
+
+	// S2以C1的digest计算
+	c1Digest, _ := findDigest(c1, genuineFPKey[:30])
+	key := makeDigest(genuineFMSKey, c1Digest, -1)
+	utils.Assert(bytes.Equal(makeDigest(key, s2[:handshakeSize-digestSize], -1), s2[handshakeSize-digestSize:]))
+	_, _ = client.Write(make([]byte, handshakeSize))
+}
+
+func TestChunkReader(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	writer := NewChunkWriter(buffer)
+	payload := make([]byte, 1000)
+	_, _ = rand.Read(payload)
+
+	// 扩展时间戳
+	_ = writer.WriteMessage(chunkStreamVideo, &Message{TypeID: MessageTypeVideo, StreamID: 1, Timestamp: 0x1000000, Payload: payload})
+	// type1/type2/type3头
+	buffer.Write([]byte{1<<6 | chunkStreamVideo, 0, 0, 40, 0, 0, 2, MessageTypeAudio, 0xAA, 0xBB})
+	buffer.Write([]byte{2<<6 | chunkStreamVideo, 0, 0, 20, 0xCC, 0xDD})
+	buffer.Write([]byte{3<<6 | chunkStreamVideo, 0xEE, 0xFF})
+	// 3字节basic header
+	writer.SetChunkSize(300)
+	_ = writer.WriteMessage(1000, &Message{TypeID: MessageTypeVideo, Timestamp: 10, Payload: payload})
+
+	reader := NewChunkReader(buffer)
+	message, err := reader.ReadMessage()
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(message.Timestamp == 0x1000000 && message.StreamID == 1 && bytes.Equal(message.Payload, payload))
+
+	expected := []struct {
+		ts      uint32
+		payload []byte
+	}{{0x1000000 + 40, []byte{0xAA, 0xBB}}, {0x1000000 + 60, []byte{0xCC, 0xDD}}, {0x1000000 + 80, []byte{0xEE, 0xFF}}}
+	for _, e := range expected {
+		message, err = reader.ReadMessage()
+		if err != nil {
+			panic(err)
+		}
+		utils.Assert(message.TypeID == MessageTypeAudio && message.Timestamp == e.ts && bytes.Equal(message.Payload, e.payload))
+	}
+
+	reader.SetChunkSize(300)
+	message, err = reader.ReadMessage()
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(message.Timestamp == 10 && bytes.Equal(message.Payload, payload))
+
+	// 消息未接收完时, type1头修改长度
+	for _, fmt_ := range []byte{0, 1} {
+		buffer.Reset()
+		writer.SetChunkSize(DefaultChunkSize)
+		_ = writer.WriteMessage(chunkStreamVideo, &Message{TypeID: MessageTypeVideo, Payload: payload})
+		buffer.Truncate(1 + 11 + DefaultChunkSize)
+		header := []byte{fmt_<<6 | chunkStreamVideo, 0, 0, 40, 0, 0, 10, MessageTypeVideo}
+		if fmt_ == 0 {
+			header = append(header, 1, 0, 0, 0)
+		}
+		buffer.Write(header)
+		buffer.Write(make([]byte, 10))
+
+		reader = NewChunkReader(buffer)
+		_, err = reader.ReadMessage()
+		utils.Assert(err != nil)
+	}
+}
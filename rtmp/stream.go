@@ -0,0 +1,295 @@
+package rtmp
+
+import (
+	"fmt"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/flv"
+	"github.com/lkmio/avformat/utils"
+	"sync"
+	"time"
+)
+
+const (
+	// 每个会话缓存的消息数量, 超过后丢包并等待下一个关键帧
+	subscriberQueueSize = 1024
+
+	// 缓存的GOP最大消息数量, 超过后不再缓存, 新会话等待下一个关键帧
+	gopCacheSize = subscriberQueueSize / 2
+)
+
+// 发送给会话的音视频和onMetaData消息, payload被所有会话共享, 不能修改
+type outMessage struct {
+	typeID    byte
+	timestamp int64 // 毫秒
+	payload   []byte
+}
+
+type subscriber struct {
+	messages chan outMessage
+	waitKey  bool  // 等待关键帧后再发送
+	baseTime int64 // 第一个消息的时间戳, 发送的时间戳从0开始
+}
+
+// Stream 实现OnUnpackStreamHandler, 将任意Demuxer输出的AVPacket转换成FLV tag, 分发给所有播放会话.
+// FLV不支持的编码会被忽略.
+type Stream struct {
+	mutex           sync.Mutex
+	tracks          avformat.TrackManager
+	streams         []*avformat.AVStream // 按track索引, 不支持的编码为nil
+	enhanced        bool
+	sequenceHeaders []outMessage
+	gop             []outMessage // 最近一个关键帧开始的消息, 新会话从这里开始播放
+	hasVideo        bool
+	hasAudio        bool
+	completed       bool
+	closed          bool
+	ready           chan struct{}
+	subscribers     map[*subscriber]struct{}
+}
+
+// SetEnhanced 使用Enhanced RTMP发送HEVC/AV1/VP9, 需要在track解析完毕前设置
+func (s *Stream) SetEnhanced(enhanced bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.enhanced = enhanced
+}
+
+// 是否使用Enhanced RTMP封装
+func (s *Stream) exVideo(id utils.AVCodecID) bool {
+	_, ok := flv.VideoFourCC(id)
+	return s.enhanced && ok
+}
+
+func (s *Stream) OnNewTrack(track avformat.Track) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.completed {
+		s.tracks.Add(track)
+	}
+}
+
+func (s *Stream) OnTrackComplete() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.completed || s.closed {
+		return
+	}
+
+	defer close(s.ready)
+	var supported []*avformat.AVStream
+	for _, track := range s.tracks.Tracks {
+		stream := track.GetStream()
+		var typeID byte
+		var header []byte
+		var err error
+		if utils.AVMediaTypeVideo == stream.MediaType && s.exVideo(stream.CodecID) {
+			typeID = MessageTypeVideo
+			header, err = flv.NewExVideoSequenceHeader(stream)
+			s.hasVideo = err == nil
+		} else if utils.AVMediaTypeVideo == stream.MediaType && flv.VideoCodecID(stream.CodecID) >= 0 {
+			typeID = MessageTypeVideo
+			header, err = flv.NewVideoSequenceHeader(stream)
+			s.hasVideo = err == nil
+		} else if utils.AVMediaTypeAudio == stream.MediaType && flv.SoundFormat(stream.CodecID) >= 0 {
+			typeID = MessageTypeAudio
+			header, err = flv.NewAudioSequenceHeader(stream)
+			s.hasAudio = err == nil
+		} else {
+			err = fmt.Errorf("unsupported rtmp codec %s", stream.CodecID)
+		}
+
+		if err != nil {
+			println(err.Error())
+			s.streams = append(s.streams, nil)
+			continue
+		}
+
+		s.streams = append(s.streams, stream)
+		supported = append(supported, stream)
+		if header != nil {
+			s.sequenceHeaders = append(s.sequenceHeaders, outMessage{typeID: typeID, payload: header})
+		}
+	}
+
+	// onMetaData在sequence header之前发送
+	if metaData, err := flv.NewMetaData(supported, s.enhanced).Marshal(); err == nil {
+		s.sequenceHeaders = append([]outMessage{{typeID: MessageTypeDataAMF0, payload: metaData}}, s.sequenceHeaders...)
+	}
+
+	s.completed = true
+}
+
+func (s *Stream) OnTrackNotFind() {
+	s.Close()
+}
+
+func (s *Stream) OnPacket(packet *avformat.AVPacket) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.completed || s.closed || packet.Index >= len(s.streams) || s.streams[packet.Index] == nil {
+		return
+	}
+
+	var payload []byte
+	var err error
+	if utils.AVMediaTypeVideo == packet.MediaType && s.exVideo(packet.CodecID) {
+		payload, err = flv.NewExVideoTagBody(packet)
+	} else {
+		payload, err = flv.NewPacketTagBody(s.streams[packet.Index], packet)
+	}
+
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	typeID := byte(MessageTypeAudio)
+	key := !s.hasVideo
+	if utils.AVMediaTypeVideo == packet.MediaType {
+		typeID = MessageTypeVideo
+		key = packet.Key
+	}
+
+	message := outMessage{typeID: typeID, timestamp: packet.ConvertDts(1000), payload: payload}
+	s.cacheGOP(message, utils.AVMediaTypeVideo == packet.MediaType && packet.Key)
+	s.broadcast(message, key)
+}
+
+// 缓存最近的GOP, 只有视频时才缓存
+func (s *Stream) cacheGOP(message outMessage, key bool) {
+	if !s.hasVideo {
+		return
+	} else if key {
+		s.gop = append(s.gop[:0], message)
+	} else if len(s.gop) >= gopCacheSize {
+		s.gop = nil
+	} else if len(s.gop) > 0 {
+		s.gop = append(s.gop, message)
+	}
+}
+
+func (s *Stream) broadcast(message outMessage, key bool) {
+	for sub := range s.subscribers {
+		if sub.waitKey {
+			if !key {
+				continue
+			}
+
+			sub.waitKey = false
+		}
+
+		if sub.baseTime < 0 {
+			sub.baseTime = message.timestamp
+		}
+
+		out := message
+		out.timestamp -= sub.baseTime
+		if out.timestamp < 0 {
+			out.timestamp = 0
+		}
+
+		select {
+		case sub.messages <- out:
+		default:
+			// 会话发送太慢, 丢弃到下一个关键帧
+			sub.waitKey = s.hasVideo
+		}
+	}
+}
+
+// subscribe 添加播放会话, 先发送sequence header, 有视频时从最近的关键帧开始发送
+func (s *Stream) subscribe() (*subscriber, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed || !s.completed {
+		return nil, fmt.Errorf("stream not ready")
+	}
+
+	sub := &subscriber{messages: make(chan outMessage, subscriberQueueSize), waitKey: s.hasVideo, baseTime: -1}
+	for _, header := range s.sequenceHeaders {
+		sub.messages <- header
+	}
+
+	// 从缓存的GOP开始, 没有缓存则等待下一个关键帧
+	if len(s.gop) > 0 {
+		sub.waitKey = false
+		sub.baseTime = s.gop[0].timestamp
+		for _, message := range s.gop {
+			message.timestamp -= sub.baseTime
+			if message.timestamp < 0 {
+				message.timestamp = 0
+			}
+
+			sub.messages <- message
+		}
+	}
+
+	s.subscribers[sub] = struct{}{}
+	return sub, nil
+}
+
+// unsubscribe 取消订阅, 返回false表示流已经关闭
+func (s *Stream) unsubscribe(sub *subscriber) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, ok := s.subscribers[sub]
+	if ok {
+		delete(s.subscribers, sub)
+		close(sub.messages)
+	}
+
+	return ok
+}
+
+// 返回FLV头中的音视频标记
+func (s *Stream) mediaFlags() (bool, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.hasVideo, s.hasAudio
+}
+
+// WaitTracks 等待track解析完毕, 返回是否可以播放
+func (s *Stream) WaitTracks(timeout time.Duration) bool {
+	select {
+	case <-s.ready:
+	case <-time.After(timeout):
+		return false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.completed && !s.closed
+}
+
+// Close 关闭流, 断开所有播放会话
+func (s *Stream) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	s.gop = nil
+	if !s.completed {
+		close(s.ready)
+	}
+
+	for sub := range s.subscribers {
+		close(sub.messages)
+	}
+	s.subscribers = make(map[*subscriber]struct{})
+}
+
+func NewStream() *Stream {
+	return &Stream{
+		ready:       make(chan struct{}),
+		subscribers: make(map[*subscriber]struct{}),
+	}
+}
@@ -0,0 +1,193 @@
+package rtmp
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocket操作码
+const (
+	wsOpBinary = 0x2
+	wsOpClose  = 0x8
+	wsOpPing   = 0x9
+	wsOpPong   = 0xA
+
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// 客户端发送的帧最大长度, 播放端只发送控制帧
+	wsMaxFrameSize = 64 * 1024
+)
+
+// 服务端WebSocket连接, 只实现播放需要的部分, 不支持扩展
+type wsConn struct {
+	conn         net.Conn
+	reader       *bufio.Reader
+	writeMutex   sync.Mutex
+	writer       *bufio.Writer
+	writeTimeout time.Duration // 为0时不设置写超时
+}
+
+func isWebSocket(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+func headerContains(header http.Header, key, value string) bool {
+	for _, v := range header.Values(key) {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// acceptWebSocket 完成握手并接管http连接
+func acceptWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if http.MethodGet != r.Method || key == "" {
+		http.Error(w, "bad websocket request", http.StatusBadRequest)
+		return nil, fmt.Errorf("bad websocket request")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("http.Hijacker not implemented")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha1.Sum([]byte(key + wsGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(hash[:]) + "\r\n"
+	// mpegts.js等会带上子协议, 原样返回第一个
+	if protocol := r.Header.Get("Sec-WebSocket-Protocol"); protocol != "" {
+		protocol, _, _ = strings.Cut(protocol, ",")
+		response += "Sec-WebSocket-Protocol: " + strings.TrimSpace(protocol) + "\r\n"
+	}
+
+	if _, err = rw.WriteString(response + "\r\n"); err == nil {
+		err = rw.Flush()
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &wsConn{conn: conn, reader: rw.Reader, writer: rw.Writer}, nil
+}
+
+// writeFrame 发送一个不分片的帧
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if c.writeTimeout > 0 {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+	if length := len(payload); length < 126 {
+		header[1] = byte(length)
+	} else if length <= 0xFFFF {
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	} else {
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	if _, err := c.writer.Write(header); err != nil {
+		return err
+	} else if _, err = c.writer.Write(payload); err != nil {
+		return err
+	}
+
+	return c.writer.Flush()
+}
+
+// readFrame 读取一个客户端帧并去掉掩码
+func (c *wsConn) readFrame() (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+	if length == 126 {
+		var bytes [2]byte
+		if _, err := io.ReadFull(c.reader, bytes[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(bytes[:]))
+	} else if length == 127 {
+		var bytes [8]byte
+		if _, err := io.ReadFull(c.reader, bytes[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(bytes[:])
+	}
+
+	if !masked {
+		return 0, nil, fmt.Errorf("websocket client frame must be masked")
+	} else if length > wsMaxFrameSize {
+		return 0, nil, fmt.Errorf("websocket frame too large %d", length)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return 0, nil, err
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return 0, nil, err
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return opcode, payload, nil
+}
+
+// readLoop 处理客户端的控制帧, 直到连接断开或收到close
+func (c *wsConn) readLoop() error {
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			return err
+		}
+
+		switch opcode {
+		case wsOpPing:
+			if err = c.writeFrame(wsOpPong, payload); err != nil {
+				return err
+			}
+		case wsOpClose:
+			_ = c.writeFrame(wsOpClose, payload)
+			return io.EOF
+		}
+	}
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
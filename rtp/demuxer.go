@@ -0,0 +1,152 @@
+package rtp
+
+import (
+	"fmt"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/utils"
+)
+
+type demuxTrack struct {
+	stream       *avformat.AVStream
+	bufferIndex  int
+	depacketizer Depacketizer
+
+	hasBase bool
+	baseTs  int64 // 起始时间戳
+	lastTs  uint32
+	cycles  int64 // 时间戳回绕次数<<32
+}
+
+// 展开32位时间戳, 返回相对起始时间戳的值
+func (t *demuxTrack) unwrap(ts uint32) int64 {
+	if !t.hasBase {
+		t.hasBase = true
+		t.baseTs = int64(ts)
+	} else if ts < t.lastTs && t.lastTs-ts > 1<<31 {
+		t.cycles += 1 << 32
+	} else if ts > t.lastTs && ts-t.lastTs > 1<<31 && t.cycles > 0 {
+		// 回绕前的乱序包
+		return t.cycles - 1<<32 + int64(ts) - t.baseTs
+	}
+
+	t.lastTs = ts
+	return t.cycles + int64(ts) - t.baseTs
+}
+
+// Demuxer 将RTP包解析成AVPacket, track信息来自SDP
+type Demuxer struct {
+	avformat.BaseDemuxer
+	tracks map[byte]*demuxTrack // key为payload type
+}
+
+// AddStream 添加SDP中的流, stream的Timebase必须是RTP时钟频率
+func (d *Demuxer) AddStream(stream *avformat.AVStream, payloadType byte) error {
+	return d.AddStreamWithFmtp(stream, payloadType, nil)
+}
+
+// AddStreamWithFmtp 添加SDP中的流, 解包参数来自fmtp, 例如AAC的sizelength
+func (d *Demuxer) AddStreamWithFmtp(stream *avformat.AVStream, payloadType byte, fmtp map[string]string) error {
+	if _, ok := d.tracks[payloadType]; ok {
+		return fmt.Errorf("payload type %d already exists", payloadType)
+	}
+
+	depacketizer, err := NewDepacketizerWithFmtp(stream.CodecID, fmtp)
+	if err != nil {
+		return err
+	}
+
+	timebase := stream.Timebase
+	if timebase == 0 {
+		timebase = d.GetTimebase()
+	}
+
+	bufferIndex := d.FindBufferIndex(int(payloadType))
+	var extraData []byte
+	if len(stream.Data) > 0 {
+		// 编码器信息也写入缓冲区, 创建track后会释放
+		_, _ = d.DataPipeline.Write(stream.Data, bufferIndex, stream.MediaType)
+		extraData, _ = d.DataPipeline.Feat(bufferIndex)
+	}
+
+	if utils.AVMediaTypeVideo == stream.MediaType {
+		d.OnNewVideoTrack(bufferIndex, stream.CodecID, timebase, extraData)
+	} else if utils.AVMediaTypeAudio == stream.MediaType {
+		config := stream.AudioConfig
+		config.HasADTSHeader = false
+		if utils.AVCodecIdAAC == stream.CodecID && extraData == nil {
+			return fmt.Errorf("aac stream missing config")
+		}
+
+		d.OnNewAudioTrack(bufferIndex, stream.CodecID, timebase, extraData, config)
+	} else {
+		return fmt.Errorf("unsupported media type %s", stream.MediaType.String())
+	}
+
+	d.tracks[payloadType] = &demuxTrack{stream: stream, bufferIndex: bufferIndex, depacketizer: depacketizer}
+	return nil
+}
+
+// SetBaseTimestamp 设置起始RTP时间戳, 例如来自RTSP的RTP-Info
+func (d *Demuxer) SetBaseTimestamp(payloadType byte, ts uint32) {
+	if track, ok := d.tracks[payloadType]; ok {
+		track.hasBase = true
+		track.baseTs = int64(ts)
+		track.lastTs = ts
+	}
+}
+
+// RTPTimestamp 还原AVPacket的DTS对应的RTP时间戳
+func (d *Demuxer) RTPTimestamp(packet *avformat.AVPacket) (uint32, bool) {
+	for _, track := range d.tracks {
+		if track.bufferIndex == packet.BufferIndex && track.hasBase {
+			return uint32(track.baseTs + packet.Dts), true
+		}
+	}
+
+	return 0, false
+}
+
+func (d *Demuxer) Input(data []byte) (int, error) {
+	packet := Packet{}
+	if err := packet.Unmarshal(data); err != nil {
+		return 0, err
+	}
+
+	return len(data), d.InputPacket(&packet)
+}
+
+// InputPacket 输入有序的RTP包, UDP需要先经过JitterBuffer排序
+func (d *Demuxer) InputPacket(packet *Packet) error {
+	track, ok := d.tracks[packet.PayloadType]
+	if !ok {
+		return fmt.Errorf("unknown payload type %d", packet.PayloadType)
+	}
+
+	return track.depacketizer.Depacketize(packet, func(frame []byte, timestamp uint32) {
+		ts := track.unwrap(timestamp)
+		if ts < 0 {
+			return
+		}
+
+		_, _ = d.DataPipeline.Write(frame, track.bufferIndex, track.stream.MediaType)
+		data, _ := d.DataPipeline.Feat(track.bufferIndex)
+		if utils.AVMediaTypeVideo == track.stream.MediaType {
+			key := avformat.IsKeyFrame(track.stream.CodecID, data)
+			// RTP时间戳为显示时间, 根据POC推导DTS. 没有重排序时DTS等于PTS
+			d.OnVideoPacket(track.bufferIndex, track.stream.CodecID, data, key, int64(avformat.DTSUndefined), ts, avformat.PacketTypeAnnexB)
+		} else {
+			d.OnAudioPacket(track.bufferIndex, track.stream.CodecID, data, ts)
+		}
+	})
+}
+
+func NewDemuxer() *Demuxer {
+	return &Demuxer{
+		BaseDemuxer: avformat.BaseDemuxer{
+			DataPipeline: &avformat.StreamsBuffer{},
+			Name:         "rtp",
+			AutoFree:     true,
+		},
+		tracks: make(map[byte]*demuxTrack),
+	}
+}
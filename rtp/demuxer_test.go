@@ -0,0 +1,81 @@
+package rtp
+
+import (
+	"encoding/hex"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+type testHandler struct {
+	packets []*avformat.AVPacket
+}
+
+func (h *testHandler) OnNewTrack(track avformat.Track) {
+}
+
+func (h *testHandler) OnTrackComplete() {
+}
+
+func (h *testHandler) OnTrackNotFind() {
+}
+
+func (h *testHandler) OnPacket(packet *avformat.AVPacket) {
+	h.packets = append(h.packets, &avformat.AVPacket{Dts: packet.Dts, Pts: packet.Pts, MediaType: packet.MediaType})
+}
+
+// RTP只有显示时间戳, 没有B帧时DTS等于PTS
+func TestDemuxerTimestamp(t *testing.T) {
+	// High Profile, SPS没有VUI
+	extra, _ := hex.DecodeString("000000016764001eac2ca50507e40000000168ce3c80")
+	idr, _ := hex.DecodeString("00000001458880400ffff0")
+	p, _ := hex.DecodeString("00000001419a02043fffc0")
+
+	handler := &testHandler{}
+	demuxer := NewDemuxer()
+	demuxer.SetHandler(handler)
+	if err := demuxer.AddStream(&avformat.AVStream{MediaType: utils.AVMediaTypeVideo, CodecID: utils.AVCodecIdH264, Timebase: 90000, Data: extra}, 96); err != nil {
+		panic(err)
+	}
+	demuxer.ProbeComplete()
+
+	packetizer, _ := NewPacketizer(utils.AVCodecIdH264, 96, 1, 0, 1400)
+	for i := 0; i < 10; i++ {
+		frame := idr
+		if i > 0 {
+			// frame_num为i, pic_order_cnt_lsb为2i
+			frame = append([]byte{}, p...)
+			frame[6] = byte(i) << 1
+			frame[7] = byte(i) << 2
+		}
+
+		_ = packetizer.Packetize(frame, uint32(1000+i*3600), func(packet []byte) {
+			if _, err := demuxer.Input(packet); err != nil {
+				panic(err)
+			}
+		})
+	}
+
+	utils.Assert(len(handler.packets) >= 9)
+	for i, packet := range handler.packets {
+		utils.Assert(packet.Dts == packet.Pts && packet.Pts == int64(i*3600))
+	}
+}
+
+// AAC-lbr的AU header为sizelength=6, indexlength=2
+func TestAACDepacketizer(t *testing.T) {
+	depacketizer, err := NewDepacketizerWithFmtp(utils.AVCodecIdAAC, map[string]string{"mode": "AAC-lbr", "sizelength": "6", "indexlength": "2", "indexdeltalength": "2"})
+	if err != nil {
+		panic(err)
+	}
+
+	var frames [][]byte
+	packet := &Packet{Header: Header{Marker: true, Timestamp: 1024}, Payload: []byte{0x00, 0x10, 3 << 2, 2 << 2, 1, 2, 3, 4, 5}}
+	err = depacketizer.Depacketize(packet, func(frame []byte, timestamp uint32) {
+		frames = append(frames, append([]byte{}, frame...))
+	})
+	utils.Assert(err == nil && len(frames) == 2 && hex.EncodeToString(frames[0]) == "010203" && hex.EncodeToString(frames[1]) == "0405")
+
+	_, err = NewDepacketizerWithFmtp(utils.AVCodecIdAAC, map[string]string{"sizelength": "0"})
+	utils.Assert(err != nil)
+}
@@ -0,0 +1,316 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/lkmio/avformat/avc"
+	"github.com/lkmio/avformat/bufio"
+	"github.com/lkmio/avformat/hevc"
+	"github.com/lkmio/avformat/utils"
+	"strconv"
+)
+
+// Depacketizer 将有序的RTP包还原成帧
+type Depacketizer interface {
+	// Depacketize 输入RTP包, 每还原一帧回调一次, 回调的帧数据会被复用, 需要在回调中处理完
+	Depacketize(packet *Packet, cb func(frame []byte, timestamp uint32)) error
+}
+
+// 按时间戳和marker位组帧, 序号不连续时丢弃受影响的帧
+type frameAssembler struct {
+	frame     []byte
+	timestamp uint32
+	lastSeq   uint16
+	started   bool // 是否收到过包
+	corrupted bool // 当前帧是否不完整
+}
+
+// 输入新的RTP包前调用, 时间戳变化时输出上一帧
+func (f *frameAssembler) begin(packet *Packet, cb func(frame []byte, timestamp uint32)) {
+	if f.started && packet.SequenceNumber != f.lastSeq+1 {
+		// 丢失的包可能属于上一帧或当前帧
+		f.corrupted = true
+		if len(f.frame) > 0 && packet.Timestamp != f.timestamp {
+			f.reset()
+			f.corrupted = true
+		}
+	}
+
+	if len(f.frame) > 0 && packet.Timestamp != f.timestamp {
+		f.flush(cb)
+	}
+
+	f.started = true
+	f.lastSeq = packet.SequenceNumber
+	f.timestamp = packet.Timestamp
+}
+
+// 输入RTP包后调用, marker位表示帧结束
+func (f *frameAssembler) end(packet *Packet, cb func(frame []byte, timestamp uint32)) {
+	if packet.Marker {
+		f.flush(cb)
+	}
+}
+
+func (f *frameAssembler) flush(cb func(frame []byte, timestamp uint32)) {
+	if len(f.frame) > 0 && !f.corrupted {
+		cb(f.frame, f.timestamp)
+	}
+
+	f.reset()
+}
+
+func (f *frameAssembler) reset() {
+	f.frame = f.frame[:0]
+	f.corrupted = false
+}
+
+func (f *frameAssembler) appendNalu(nalu ...[]byte) {
+	f.frame = append(f.frame, avc.StartCode4...)
+	for _, bytes := range nalu {
+		f.frame = append(f.frame, bytes...)
+	}
+}
+
+// H264Depacketizer RFC 6184, 输出AnnexB格式, 支持Single NAL Unit/STAP-A/FU-A
+type H264Depacketizer struct {
+	frameAssembler
+	fuStarted bool
+}
+
+func (h *H264Depacketizer) Depacketize(packet *Packet, cb func(frame []byte, timestamp uint32)) error {
+	h.begin(packet, cb)
+	payload := packet.Payload
+	if len(payload) < 1 {
+		return fmt.Errorf("empty h264 payload")
+	}
+
+	switch payload[0] & 0x1F {
+	case h264NalSTAPA:
+		if err := h.appendAggregation(payload[1:]); err != nil {
+			return err
+		}
+	case h264NalFUA:
+		if len(payload) < 2 {
+			return fmt.Errorf("invalid h264 fu-a size %d", len(payload))
+		}
+
+		start, end := payload[1]&0x80 != 0, payload[1]&0x40 != 0
+		if start {
+			h.fuStarted = true
+			h.appendNalu([]byte{payload[0]&0xE0 | payload[1]&0x1F}, payload[2:])
+		} else if !h.fuStarted {
+			// 没有收到第一个分片
+			h.corrupted = true
+		} else {
+			h.frame = append(h.frame, payload[2:]...)
+		}
+
+		if end {
+			h.fuStarted = false
+		}
+	case 25, 26, 27, 29:
+		return fmt.Errorf("unsupported h264 packetization type %d", payload[0]&0x1F)
+	default:
+		h.appendNalu(payload)
+	}
+
+	h.end(packet, cb)
+	return nil
+}
+
+// STAP-A/AP聚合包, 每个NALU前有2字节长度
+func (f *frameAssembler) appendAggregation(data []byte) error {
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return fmt.Errorf("invalid aggregation packet")
+		}
+
+		size := int(binary.BigEndian.Uint16(data))
+		if len(data)-2 < size {
+			return fmt.Errorf("invalid aggregation nalu size %d", size)
+		}
+
+		f.appendNalu(data[2 : 2+size])
+		data = data[2+size:]
+	}
+
+	return nil
+}
+
+// H265Depacketizer RFC 7798, 输出AnnexB格式, 支持Single NAL Unit/AP/FU
+type H265Depacketizer struct {
+	frameAssembler
+	fuStarted bool
+}
+
+func (h *H265Depacketizer) Depacketize(packet *Packet, cb func(frame []byte, timestamp uint32)) error {
+	h.begin(packet, cb)
+	payload := packet.Payload
+	if len(payload) < 2 {
+		return fmt.Errorf("invalid h265 payload size %d", len(payload))
+	}
+
+	switch hevc.HEVCNALUnitType(payload[0] >> 1 & 0x3F) {
+	case h265NalAP:
+		if err := h.appendAggregation(payload[2:]); err != nil {
+			return err
+		}
+	case h265NalFU:
+		if len(payload) < 3 {
+			return fmt.Errorf("invalid h265 fu size %d", len(payload))
+		}
+
+		start, end := payload[2]&0x80 != 0, payload[2]&0x40 != 0
+		if start {
+			h.fuStarted = true
+			h.appendNalu([]byte{payload[0]&0x81 | (payload[2]&0x3F)<<1, payload[1]}, payload[3:])
+		} else if !h.fuStarted {
+			h.corrupted = true
+		} else {
+			h.frame = append(h.frame, payload[3:]...)
+		}
+
+		if end {
+			h.fuStarted = false
+		}
+	default:
+		h.appendNalu(payload)
+	}
+
+	h.end(packet, cb)
+	return nil
+}
+
+// AACDepacketizer RFC 3640 mpeg4-generic, 支持一个包多帧和单帧分片
+type AACDepacketizer struct {
+	SizeLength       int
+	IndexLength      int
+	IndexDeltaLength int
+
+	fragment     []byte // 分片的帧
+	fragmentSize int    // 分片帧的完整长度
+	lastSeq      uint16
+	started      bool
+}
+
+// NewAACDepacketizer 根据SDP的fmtp参数创建AAC解包器, 缺省的参数按照AAC-hbr
+func NewAACDepacketizer(fmtp map[string]string) (*AACDepacketizer, error) {
+	a := &AACDepacketizer{SizeLength: 13, IndexLength: 3, IndexDeltaLength: 3}
+	params := []struct {
+		key   string
+		value *int
+	}{{"sizelength", &a.SizeLength}, {"indexlength", &a.IndexLength}, {"indexdeltalength", &a.IndexDeltaLength}}
+
+	for _, param := range params {
+		value, ok := fmtp[param.key]
+		if !ok {
+			continue
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > 32 {
+			return nil, fmt.Errorf("invalid aac fmtp %s=%s", param.key, value)
+		}
+		*param.value = n
+	}
+
+	if a.SizeLength == 0 {
+		return nil, fmt.Errorf("invalid aac fmtp sizelength=0")
+	}
+	return a, nil
+}
+
+func (a *AACDepacketizer) Depacketize(packet *Packet, cb func(frame []byte, timestamp uint32)) error {
+	if a.started && packet.SequenceNumber != a.lastSeq+1 {
+		a.fragment = a.fragment[:0]
+	}
+
+	a.started = true
+	a.lastSeq = packet.SequenceNumber
+
+	payload := packet.Payload
+	if len(payload) < 2 {
+		return fmt.Errorf("invalid aac payload size %d", len(payload))
+	}
+
+	headersLength := int(binary.BigEndian.Uint16(payload))
+	headersSize := (headersLength + 7) / 8
+	if len(payload) < 2+headersSize {
+		return fmt.Errorf("invalid aac au headers length %d", headersLength)
+	}
+
+	reader := bufio.BitsReader{Data: payload[2 : 2+headersSize]}
+	data := payload[2+headersSize:]
+	var sizes []int
+	for i := 0; reader.Offset+a.SizeLength <= headersLength; i++ {
+		sizes = append(sizes, int(reader.Read(a.SizeLength)))
+		if i == 0 {
+			reader.Seek(a.IndexLength)
+		} else {
+			reader.Seek(a.IndexDeltaLength)
+		}
+	}
+
+	// 单帧分片
+	if len(sizes) == 1 && (sizes[0] > len(data) || len(a.fragment) > 0) {
+		if len(a.fragment) == 0 {
+			a.fragmentSize = sizes[0]
+		}
+
+		a.fragment = append(a.fragment, data...)
+		if len(a.fragment) >= a.fragmentSize {
+			cb(a.fragment[:a.fragmentSize], packet.Timestamp)
+			a.fragment = a.fragment[:0]
+		} else if packet.Marker {
+			// 分片不完整
+			a.fragment = a.fragment[:0]
+		}
+
+		return nil
+	}
+
+	for i, size := range sizes {
+		if len(data) < size {
+			return fmt.Errorf("invalid aac au size %d", size)
+		}
+
+		cb(data[:size], packet.Timestamp+uint32(i*utils.DefaultAACFrameLength))
+		data = data[size:]
+	}
+
+	return nil
+}
+
+// GenericDepacketizer 一个包一帧, 用于G711/G722/Opus等
+type GenericDepacketizer struct {
+}
+
+func (g *GenericDepacketizer) Depacketize(packet *Packet, cb func(frame []byte, timestamp uint32)) error {
+	if len(packet.Payload) > 0 {
+		cb(packet.Payload, packet.Timestamp)
+	}
+
+	return nil
+}
+
+// NewDepacketizer 创建编码器对应的解包器, AAC使用默认的fmtp参数
+func NewDepacketizer(id utils.AVCodecID) (Depacketizer, error) {
+	return NewDepacketizerWithFmtp(id, nil)
+}
+
+// NewDepacketizerWithFmtp 根据SDP的fmtp参数创建编码器对应的解包器
+func NewDepacketizerWithFmtp(id utils.AVCodecID, fmtp map[string]string) (Depacketizer, error) {
+	switch id {
+	case utils.AVCodecIdH264:
+		return &H264Depacketizer{}, nil
+	case utils.AVCodecIdH265:
+		return &H265Depacketizer{}, nil
+	case utils.AVCodecIdAAC:
+		return NewAACDepacketizer(fmtp)
+	case utils.AVCodecIdPCMALAW, utils.AVCodecIdPCMMULAW, utils.AVCodecIdADPCMG722, utils.AVCodecIdOPUS:
+		return &GenericDepacketizer{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported codec %s", id)
+	}
+}
@@ -0,0 +1,225 @@
+package rtp
+
+import (
+	"github.com/lkmio/avformat/utils"
+	"time"
+)
+
+const (
+	DefaultJitterLatency  = 200 * time.Millisecond
+	DefaultJitterCapacity = 1024
+)
+
+// LostRange 连续丢失的序号区间, 用于生成RTCP NACK
+type LostRange struct {
+	Start uint16
+	Count int
+}
+
+type bufferedPacket struct {
+	packet  *Packet
+	arrival time.Time
+}
+
+// JitterBuffer RTP接收缓冲区, 按序号重排输出.
+// 按序到达的包立即输出, 出现空洞时最多等待latency, 超时后放弃丢失的包.
+// 视频丢包后丢弃后续帧, 直到下一个关键帧.
+type JitterBuffer struct {
+	codec    utils.AVCodecID
+	latency  time.Duration
+	capacity int
+
+	packets    map[uint16]*bufferedPacket
+	started    bool
+	nextSeq    uint16 // 下一个待输出的序号
+	highestSeq uint16 // 已收到的最大序号
+
+	waitKeyFrame     bool      // 丢包后等待关键帧
+	pendingTimestamp uint32    // 等待关键帧时, 当前缓存帧的时间戳
+	pending          []*Packet // 等待关键帧时, 缓存当前帧的包
+
+	lostCount          int // 累计放弃的包数
+	onKeyFrameRequired func()
+}
+
+// Push 缓存RTP包, 返回false表示迟到或重复的包被丢弃.
+// 序号向前或向后跳变超过缓冲区容量时, 视为流重置.
+func (j *JitterBuffer) Push(packet *Packet, now time.Time) bool {
+	seq := packet.SequenceNumber
+	if !j.started {
+		j.started = true
+		j.nextSeq = seq
+		j.highestSeq = seq
+	} else if diff := SeqDiff(j.nextSeq, seq); diff >= j.capacity || -diff >= j.capacity {
+		j.reset(seq)
+	} else if diff < 0 {
+		// 迟到或重复的包
+		return false
+	}
+
+	if _, ok := j.packets[seq]; ok {
+		return false
+	}
+
+	j.packets[seq] = &bufferedPacket{packet: packet, arrival: now}
+	if SeqLess(j.highestSeq, seq) {
+		j.highestSeq = seq
+	}
+
+	return true
+}
+
+// Pop 返回可以输出的有序包
+func (j *JitterBuffer) Pop(now time.Time) []*Packet {
+	var result []*Packet
+	for len(j.packets) > 0 {
+		if buffered, ok := j.packets[j.nextSeq]; ok {
+			delete(j.packets, j.nextSeq)
+			j.nextSeq++
+			result = j.deliver(result, buffered.packet)
+			continue
+		}
+
+		// 空洞之后的第一个包, 等待超时后放弃空洞
+		seq, buffered := j.nextBuffered()
+		if buffered == nil || now.Sub(buffered.arrival) < j.latency {
+			break
+		}
+
+		j.onLost(SeqDiff(j.nextSeq, seq))
+		j.nextSeq = seq
+	}
+
+	return result
+}
+
+// Flush 忽略空洞, 输出所有缓存的包
+func (j *JitterBuffer) Flush() []*Packet {
+	var result []*Packet
+	for len(j.packets) > 0 {
+		seq, buffered := j.nextBuffered()
+		if seq != j.nextSeq {
+			j.onLost(SeqDiff(j.nextSeq, seq))
+		}
+
+		delete(j.packets, seq)
+		j.nextSeq = seq + 1
+		result = j.deliver(result, buffered.packet)
+	}
+
+	return result
+}
+
+// Lost 返回当前等待中的丢失序号区间
+func (j *JitterBuffer) Lost() []LostRange {
+	var ranges []LostRange
+	if len(j.packets) == 0 {
+		return nil
+	}
+
+	for seq := j.nextSeq; seq != j.highestSeq; seq++ {
+		if _, ok := j.packets[seq]; ok {
+			continue
+		}
+
+		if n := len(ranges); n > 0 && ranges[n-1].Start+uint16(ranges[n-1].Count) == seq {
+			ranges[n-1].Count++
+		} else {
+			ranges = append(ranges, LostRange{Start: seq, Count: 1})
+		}
+	}
+
+	return ranges
+}
+
+// WaitKeyFrame 是否正在等待关键帧, 调用方可据此发送PLI/FIR
+func (j *JitterBuffer) WaitKeyFrame() bool {
+	return j.waitKeyFrame
+}
+
+// LostCount 返回累计放弃的包数
+func (j *JitterBuffer) LostCount() int {
+	return j.lostCount
+}
+
+// Size 返回缓存的包数
+func (j *JitterBuffer) Size() int {
+	return len(j.packets)
+}
+
+// SetOnKeyFrameRequired 设置丢包后需要关键帧的回调
+func (j *JitterBuffer) SetOnKeyFrameRequired(cb func()) {
+	j.onKeyFrameRequired = cb
+}
+
+// 查找nextSeq之后最近的包
+func (j *JitterBuffer) nextBuffered() (uint16, *bufferedPacket) {
+	for seq := j.nextSeq; ; seq++ {
+		if buffered, ok := j.packets[seq]; ok {
+			return seq, buffered
+		} else if seq == j.highestSeq {
+			return 0, nil
+		}
+	}
+}
+
+func (j *JitterBuffer) onLost(count int) {
+	j.lostCount += count
+	if !IsKeyFrameCodec(j.codec) {
+		return
+	}
+
+	// 丢弃已缓存的不完整帧
+	j.pending = j.pending[:0]
+	if !j.waitKeyFrame {
+		j.waitKeyFrame = true
+		if j.onKeyFrameRequired != nil {
+			j.onKeyFrameRequired()
+		}
+	}
+}
+
+func (j *JitterBuffer) deliver(result []*Packet, packet *Packet) []*Packet {
+	if !j.waitKeyFrame {
+		return append(result, packet)
+	}
+
+	// 按帧缓存, 帧内任意包是关键帧才输出整帧
+	if len(j.pending) > 0 && j.pendingTimestamp != packet.Timestamp {
+		j.pending = j.pending[:0]
+	}
+
+	j.pendingTimestamp = packet.Timestamp
+	j.pending = append(j.pending, packet)
+	if IsKeyFramePayload(j.codec, packet.Payload) {
+		j.waitKeyFrame = false
+		result = append(result, j.pending...)
+		j.pending = j.pending[:0]
+	}
+
+	return result
+}
+
+func (j *JitterBuffer) reset(seq uint16) {
+	for key := range j.packets {
+		delete(j.packets, key)
+	}
+
+	j.nextSeq = seq
+	j.highestSeq = seq
+	j.onLost(0)
+}
+
+// NewJitterBuffer 创建RTP接收缓冲区, latency为空洞的最大等待时长
+func NewJitterBuffer(codec utils.AVCodecID, latency time.Duration) *JitterBuffer {
+	if latency <= 0 {
+		latency = DefaultJitterLatency
+	}
+
+	return &JitterBuffer{
+		codec:    codec,
+		latency:  latency,
+		capacity: DefaultJitterCapacity,
+		packets:  make(map[uint16]*bufferedPacket),
+	}
+}
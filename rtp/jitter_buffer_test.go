@@ -0,0 +1,71 @@
+package rtp
+
+import (
+	"github.com/lkmio/avformat/utils"
+	"testing"
+	"time"
+)
+
+func newTestPacket(seq uint16, ts uint32, payload ...byte) *Packet {
+	return &Packet{Header: Header{Version: Version, SequenceNumber: seq, Timestamp: ts}, Payload: payload}
+}
+
+func TestJitterBufferReorder(t *testing.T) {
+	now := time.Now()
+	buffer := NewJitterBuffer(utils.AVCodecIdPCMALAW, 100*time.Millisecond)
+
+	// 序号回绕
+	for _, seq := range []uint16{65534, 0, 65535, 2, 1} {
+		buffer.Push(newTestPacket(seq, 0), now)
+	}
+
+	packets := buffer.Pop(now)
+	utils.Assert(len(packets) == 5)
+	for i, seq := range []uint16{65534, 65535, 0, 1, 2} {
+		utils.Assert(packets[i].SequenceNumber == seq)
+	}
+
+	// 迟到的包被丢弃
+	utils.Assert(!buffer.Push(newTestPacket(1, 0), now))
+
+	// 序号向后跳变超过容量, 视为流重置
+	utils.Assert(buffer.Push(newTestPacket(60000, 0), now))
+	packets = buffer.Pop(now)
+	utils.Assert(len(packets) == 1 && packets[0].SequenceNumber == 60000)
+	utils.Assert(buffer.Push(newTestPacket(60001, 0), now) && !buffer.Push(newTestPacket(59999, 0), now))
+}
+
+func TestJitterBufferLost(t *testing.T) {
+	now := time.Now()
+	buffer := NewJitterBuffer(utils.AVCodecIdH264, 100*time.Millisecond)
+	var required int
+	buffer.SetOnKeyFrameRequired(func() {
+		required++
+	})
+
+	// IDR
+	buffer.Push(newTestPacket(10, 3000, 0x65, 0x88), now)
+	utils.Assert(len(buffer.Pop(now)) == 1)
+
+	// 丢失11, 12
+	buffer.Push(newTestPacket(13, 6000, 0x41, 0x9a), now)
+	buffer.Push(newTestPacket(14, 9000, 0x41, 0x9a), now)
+	utils.Assert(len(buffer.Pop(now)) == 0)
+
+	lost := buffer.Lost()
+	utils.Assert(len(lost) == 1 && lost[0].Start == 11 && lost[0].Count == 2)
+
+	// 超时后放弃空洞, P帧被丢弃, 等待关键帧
+	now = now.Add(150 * time.Millisecond)
+	utils.Assert(len(buffer.Pop(now)) == 0)
+	utils.Assert(buffer.WaitKeyFrame() && required == 1 && buffer.LostCount() == 2)
+
+	// SPS/PPS和IDR分开发送, 同一帧一起输出
+	buffer.Push(newTestPacket(15, 12000, 0x67, 0x42), now)
+	buffer.Push(newTestPacket(16, 12000, 0x68, 0xce), now)
+	buffer.Push(newTestPacket(17, 12000, 0x7c, 0x85, 0x88), now)
+	buffer.Push(newTestPacket(18, 12000, 0x7c, 0x45, 0x88), now)
+	packets := buffer.Pop(now)
+	utils.Assert(len(packets) == 4 && packets[0].SequenceNumber == 15)
+	utils.Assert(!buffer.WaitKeyFrame())
+}
@@ -0,0 +1,207 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	Version         = 2
+	FixedHeaderSize = 12
+)
+
+/*
+RFC 3550 5.1 RTP Fixed Header Fields
+
+	0                   1                   2                   3
+	0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	|V=2|P|X|  CC   |M|     PT      |       sequence number         |
+	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	|                           timestamp                           |
+	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	|           synchronization source (SSRC) identifier            |
+	+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+
+	|            contributing source (CSRC) identifiers             |
+	|                             ....                              |
+	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+*/
+
+type Header struct {
+	Version          byte
+	Padding          bool
+	Extension        bool
+	Marker           bool
+	PayloadType      byte
+	SequenceNumber   uint16
+	Timestamp        uint32
+	SSRC             uint32
+	CSRC             []uint32
+	ExtensionProfile uint16
+	ExtensionPayload []byte
+}
+
+// MarshalSize 返回序列化后的头长度
+func (h *Header) MarshalSize() int {
+	size := FixedHeaderSize + len(h.CSRC)*4
+	if h.Extension {
+		size += 4 + (len(h.ExtensionPayload)+3)/4*4
+	}
+
+	return size
+}
+
+// MarshalTo 序列化RTP头, 返回写入长度
+func (h *Header) MarshalTo(dst []byte) (int, error) {
+	size := h.MarshalSize()
+	if len(dst) < size {
+		return 0, fmt.Errorf("rtp header need %d bytes, buffer size %d", size, len(dst))
+	} else if len(h.CSRC) > 15 {
+		return 0, fmt.Errorf("too many csrc %d", len(h.CSRC))
+	}
+
+	dst[0] = Version<<6 | byte(len(h.CSRC))
+	if h.Padding {
+		dst[0] |= 0x20
+	}
+	if h.Extension {
+		dst[0] |= 0x10
+	}
+
+	dst[1] = h.PayloadType & 0x7F
+	if h.Marker {
+		dst[1] |= 0x80
+	}
+
+	binary.BigEndian.PutUint16(dst[2:], h.SequenceNumber)
+	binary.BigEndian.PutUint32(dst[4:], h.Timestamp)
+	binary.BigEndian.PutUint32(dst[8:], h.SSRC)
+
+	offset := FixedHeaderSize
+	for _, csrc := range h.CSRC {
+		binary.BigEndian.PutUint32(dst[offset:], csrc)
+		offset += 4
+	}
+
+	if h.Extension {
+		words := (len(h.ExtensionPayload) + 3) / 4
+		binary.BigEndian.PutUint16(dst[offset:], h.ExtensionProfile)
+		binary.BigEndian.PutUint16(dst[offset+2:], uint16(words))
+		offset += 4
+		n := copy(dst[offset:], h.ExtensionPayload)
+		// 扩展头按4字节对齐
+		for i := n; i < words*4; i++ {
+			dst[offset+i] = 0
+		}
+		offset += words * 4
+	}
+
+	return offset, nil
+}
+
+// Unmarshal 解析RTP头, 返回头长度
+func (h *Header) Unmarshal(data []byte) (int, error) {
+	if len(data) < FixedHeaderSize {
+		return 0, fmt.Errorf("rtp header size %d too short", len(data))
+	}
+
+	h.Version = data[0] >> 6
+	if h.Version != Version {
+		return 0, fmt.Errorf("unsupported rtp version %d", h.Version)
+	}
+
+	h.Padding = data[0]>>5&0x1 == 1
+	h.Extension = data[0]>>4&0x1 == 1
+	csrcCount := int(data[0] & 0xF)
+	h.Marker = data[1]>>7 == 1
+	h.PayloadType = data[1] & 0x7F
+	h.SequenceNumber = binary.BigEndian.Uint16(data[2:])
+	h.Timestamp = binary.BigEndian.Uint32(data[4:])
+	h.SSRC = binary.BigEndian.Uint32(data[8:])
+
+	offset := FixedHeaderSize
+	if len(data) < offset+csrcCount*4 {
+		return 0, fmt.Errorf("rtp csrc count %d exceeds packet size %d", csrcCount, len(data))
+	}
+
+	h.CSRC = h.CSRC[:0]
+	for i := 0; i < csrcCount; i++ {
+		h.CSRC = append(h.CSRC, binary.BigEndian.Uint32(data[offset:]))
+		offset += 4
+	}
+
+	if h.Extension {
+		if len(data) < offset+4 {
+			return 0, fmt.Errorf("rtp extension header exceeds packet size %d", len(data))
+		}
+
+		h.ExtensionProfile = binary.BigEndian.Uint16(data[offset:])
+		length := int(binary.BigEndian.Uint16(data[offset+2:])) * 4
+		offset += 4
+		if len(data) < offset+length {
+			return 0, fmt.Errorf("rtp extension size %d exceeds packet size %d", length, len(data))
+		}
+
+		h.ExtensionPayload = data[offset : offset+length]
+		offset += length
+	}
+
+	return offset, nil
+}
+
+type Packet struct {
+	Header
+	Payload []byte
+	Raw     []byte // 原始RTP包
+}
+
+// Marshal 序列化RTP包, Padding标记会被忽略
+func (p *Packet) Marshal() ([]byte, error) {
+	header := p.Header
+	header.Padding = false
+
+	bytes := make([]byte, header.MarshalSize()+len(p.Payload))
+	n, err := header.MarshalTo(bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	copy(bytes[n:], p.Payload)
+	return bytes, nil
+}
+
+// Unmarshal 解析RTP包, Payload引用data, 不拷贝
+func (p *Packet) Unmarshal(data []byte) error {
+	n, err := p.Header.Unmarshal(data)
+	if err != nil {
+		return err
+	}
+
+	end := len(data)
+	if p.Padding {
+		if end <= n {
+			return fmt.Errorf("rtp padding without payload")
+		}
+
+		paddingSize := int(data[end-1])
+		if paddingSize == 0 || end-n < paddingSize {
+			return fmt.Errorf("invalid rtp padding size %d", paddingSize)
+		}
+
+		end -= paddingSize
+	}
+
+	p.Payload = data[n:end]
+	p.Raw = data
+	return nil
+}
+
+// SeqLess 比较两个序号的先后, 处理回绕
+func SeqLess(a, b uint16) bool {
+	return a != b && int16(b-a) > 0
+}
+
+// SeqDiff 返回b相对a的序号距离, 处理回绕
+func SeqDiff(a, b uint16) int {
+	return int(int16(b - a))
+}
@@ -0,0 +1,264 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/lkmio/avformat/avc"
+	"github.com/lkmio/avformat/bufio"
+	"github.com/lkmio/avformat/hevc"
+	"github.com/lkmio/avformat/utils"
+)
+
+const (
+	DefaultMTU = 1400
+)
+
+// Packetizer 将一帧数据打包成RTP包
+type Packetizer interface {
+	// Packetize 打包一帧, 每生成一个RTP包回调一次, 回调的切片会被复用, 需要在回调中处理完
+	Packetize(data []byte, timestamp uint32, cb func(packet []byte)) error
+
+	// SequenceNumber 返回下一个RTP包的序号
+	SequenceNumber() uint16
+
+	SSRC() uint32
+
+	PayloadType() byte
+}
+
+type basePacketizer struct {
+	header Header
+	mtu    int
+	buffer []byte
+}
+
+func (b *basePacketizer) SequenceNumber() uint16 {
+	return b.header.SequenceNumber
+}
+
+func (b *basePacketizer) SSRC() uint32 {
+	return b.header.SSRC
+}
+
+func (b *basePacketizer) PayloadType() byte {
+	return b.header.PayloadType
+}
+
+// 单个RTP包的最大负载长度
+func (b *basePacketizer) maxPayloadSize() int {
+	return b.mtu - FixedHeaderSize
+}
+
+// 写入RTP头和负载, 负载由多段组成, 避免拷贝到临时缓冲区
+func (b *basePacketizer) write(timestamp uint32, marker bool, cb func(packet []byte), payloads ...[]byte) {
+	b.header.Timestamp = timestamp
+	b.header.Marker = marker
+	n, _ := b.header.MarshalTo(b.buffer)
+	for _, payload := range payloads {
+		n += copy(b.buffer[n:], payload)
+	}
+
+	b.header.SequenceNumber++
+	cb(b.buffer[:n])
+}
+
+// GenericPacketizer 一帧一个RTP包, 用于G711/G722/Opus等
+type GenericPacketizer struct {
+	basePacketizer
+}
+
+func (g *GenericPacketizer) Packetize(data []byte, timestamp uint32, cb func(packet []byte)) error {
+	if len(data) > g.maxPayloadSize() {
+		return fmt.Errorf("frame size %d exceeds mtu %d", len(data), g.mtu)
+	}
+
+	g.write(timestamp, true, cb, data)
+	return nil
+}
+
+// H264Packetizer RFC 6184, 输入AnnexB格式, 使用Single NAL Unit和FU-A
+type H264Packetizer struct {
+	basePacketizer
+}
+
+func (h *H264Packetizer) Packetize(data []byte, timestamp uint32, cb func(packet []byte)) error {
+	nalus := splitAnnexB(data, func(header byte) bool {
+		// RTP不需要AUD
+		return header&0x1F == avc.H264NalAUD
+	})
+
+	if len(nalus) == 0 {
+		return fmt.Errorf("not find nalu")
+	}
+
+	maxSize := h.maxPayloadSize()
+	for i, nalu := range nalus {
+		last := i == len(nalus)-1
+		if len(nalu) <= maxSize {
+			h.write(timestamp, last, cb, nalu)
+			continue
+		}
+
+		// FU-A
+		indicator := nalu[0]&0xE0 | h264NalFUA
+		nalType := nalu[0] & 0x1F
+		payload := nalu[1:]
+		for start := true; len(payload) > 0; start = false {
+			size := bufio.MinInt(len(payload), maxSize-2)
+			header := nalType
+			if start {
+				header |= 0x80
+			}
+
+			end := size == len(payload)
+			if end {
+				header |= 0x40
+			}
+
+			h.write(timestamp, last && end, cb, []byte{indicator, header}, payload[:size])
+			payload = payload[size:]
+		}
+	}
+
+	return nil
+}
+
+// H265Packetizer RFC 7798, 输入AnnexB格式, 使用Single NAL Unit和FU
+type H265Packetizer struct {
+	basePacketizer
+}
+
+func (h *H265Packetizer) Packetize(data []byte, timestamp uint32, cb func(packet []byte)) error {
+	nalus := splitAnnexB(data, func(header byte) bool {
+		return hevc.HEVCNALUnitType(header>>1&0x3F) == hevc.HevcNalAUD
+	})
+
+	if len(nalus) == 0 {
+		return fmt.Errorf("not find nalu")
+	}
+
+	maxSize := h.maxPayloadSize()
+	for i, nalu := range nalus {
+		last := i == len(nalus)-1
+		if len(nalu) <= maxSize {
+			h.write(timestamp, last, cb, nalu)
+			continue
+		} else if len(nalu) < 3 {
+			return fmt.Errorf("invalid nalu size %d", len(nalu))
+		}
+
+		nalType := nalu[0] >> 1 & 0x3F
+		indicator := []byte{nalu[0]&0x81 | h265NalFU<<1, nalu[1]}
+		payload := nalu[2:]
+		for start := true; len(payload) > 0; start = false {
+			size := bufio.MinInt(len(payload), maxSize-3)
+			header := nalType
+			if start {
+				header |= 0x80
+			}
+
+			end := size == len(payload)
+			if end {
+				header |= 0x40
+			}
+
+			h.write(timestamp, last && end, cb, indicator, []byte{header}, payload[:size])
+			payload = payload[size:]
+		}
+	}
+
+	return nil
+}
+
+// AACPacketizer RFC 3640 AAC-hbr模式, sizeLength=13, indexLength=3, 每个RTP包一帧
+type AACPacketizer struct {
+	basePacketizer
+}
+
+func (a *AACPacketizer) Packetize(data []byte, timestamp uint32, cb func(packet []byte)) error {
+	// 去掉ADTS头
+	if len(data) > 7 && data[0] == 0xFF && data[1]&0xF0 == 0xF0 {
+		header, err := utils.ReadADtsFixedHeader(data)
+		if err != nil {
+			return err
+		} else if header.ProtectionAbsent() == 0 {
+			data = data[9:]
+		} else {
+			data = data[7:]
+		}
+	}
+
+	if len(data) > 0x1FFF {
+		return fmt.Errorf("aac frame size %d too large", len(data))
+	}
+
+	// AU-headers-length(16bits) + AU-header(13bits size + 3bits index)
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint16(header, 16)
+	binary.BigEndian.PutUint16(header[2:], uint16(len(data)<<3))
+
+	// 超过MTU的帧分片发送, 每个分片携带相同的AU头
+	maxSize := a.maxPayloadSize() - len(header)
+	for len(data) > 0 {
+		size := bufio.MinInt(len(data), maxSize)
+		a.write(timestamp, size == len(data), cb, header, data[:size])
+		data = data[size:]
+	}
+
+	return nil
+}
+
+// 拆分AnnexB中的NALU, 去掉start code
+func splitAnnexB(data []byte, skip func(header byte) bool) [][]byte {
+	var nalus [][]byte
+	if len(data) < 4 {
+		return nil
+	}
+
+	avc.SplitNalU(data, func(nalu []byte) {
+		// 去掉start code
+		for len(nalu) > 0 && nalu[0] == 0 {
+			nalu = nalu[1:]
+		}
+		if len(nalu) > 0 && nalu[0] == 1 {
+			nalu = nalu[1:]
+		}
+
+		// 去掉尾部的0
+		for len(nalu) > 0 && nalu[len(nalu)-1] == 0 {
+			nalu = nalu[:len(nalu)-1]
+		}
+
+		if len(nalu) > 0 && !skip(nalu[0]) {
+			nalus = append(nalus, nalu)
+		}
+	})
+
+	return nalus
+}
+
+// NewPacketizer 创建编码器对应的打包器, seq为起始序号
+func NewPacketizer(id utils.AVCodecID, payloadType byte, ssrc uint32, seq uint16, mtu int) (Packetizer, error) {
+	if mtu <= FixedHeaderSize+3 {
+		mtu = DefaultMTU
+	}
+
+	base := basePacketizer{
+		header: Header{Version: Version, PayloadType: payloadType, SSRC: ssrc, SequenceNumber: seq},
+		mtu:    mtu,
+		buffer: make([]byte, mtu),
+	}
+
+	switch id {
+	case utils.AVCodecIdH264:
+		return &H264Packetizer{base}, nil
+	case utils.AVCodecIdH265:
+		return &H265Packetizer{base}, nil
+	case utils.AVCodecIdAAC:
+		return &AACPacketizer{base}, nil
+	case utils.AVCodecIdPCMALAW, utils.AVCodecIdPCMMULAW, utils.AVCodecIdADPCMG722, utils.AVCodecIdOPUS:
+		return &GenericPacketizer{base}, nil
+	default:
+		return nil, fmt.Errorf("unsupported codec %s", id)
+	}
+}
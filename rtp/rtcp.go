@@ -0,0 +1,541 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+type RTCPType byte
+
+const (
+	RTCPTypeSR    = RTCPType(200)
+	RTCPTypeRR    = RTCPType(201)
+	RTCPTypeSDES  = RTCPType(202)
+	RTCPTypeBYE   = RTCPType(203)
+	RTCPTypeAPP   = RTCPType(204)
+	RTCPTypeRTPFB = RTCPType(205) // RFC 4585 传输层反馈
+	RTCPTypePSFB  = RTCPType(206) // RFC 4585 负载相关反馈
+
+	RTCPFmtNACK = 1 // RTPFB Generic NACK
+	RTCPFmtPLI  = 1 // PSFB Picture Loss Indication
+
+	SDESEnd   = 0
+	SDESCNAME = 1
+	SDESName  = 2
+	SDESTool  = 6
+
+	rtcpHeaderSize = 4
+)
+
+// ntp纪元(1900)到unix纪元(1970)的秒数
+const ntpEpochOffset = 2208988800
+
+// NTPTime 64位NTP时间戳, 高32位秒, 低32位秒的小数部分
+type NTPTime uint64
+
+func NewNTPTime(t time.Time) NTPTime {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := (uint64(t.Nanosecond())<<32 + uint64(time.Second)/2) / uint64(time.Second)
+	return NTPTime(seconds<<32 | fraction)
+}
+
+func (n NTPTime) Time() time.Time {
+	seconds := int64(n>>32) - ntpEpochOffset
+	nanos := int64((uint64(n&0xFFFFFFFF)*uint64(time.Second) + 1<<31) >> 32)
+	return time.Unix(seconds, nanos)
+}
+
+// Middle 返回中间32位, 用于RR的LSR字段
+func (n NTPTime) Middle() uint32 {
+	return uint32(n >> 16)
+}
+
+type RTCPPacket interface {
+	Type() RTCPType
+
+	Marshal() ([]byte, error)
+}
+
+/*
+RFC 3550 6.4.1
+
+	0                   1                   2                   3
+	0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	|V=2|P|    RC   |   PT=SR=200   |             length            |
+	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	|                         SSRC of sender                        |
+	+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+
+	|              NTP timestamp, most significant word             |
+	|             NTP timestamp, least significant word             |
+	|                         RTP timestamp                         |
+	|                     sender's packet count                     |
+	|                      sender's octet count                     |
+	+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+=+
+	|                 report blocks (24 bytes each)                 |
+*/
+
+// ReceptionReport 接收报告块
+type ReceptionReport struct {
+	SSRC               uint32
+	FractionLost       byte
+	TotalLost          uint32 // 24位
+	LastSequenceNumber uint32 // 扩展最大序号
+	Jitter             uint32
+	LastSenderReport   uint32 // LSR
+	Delay              uint32 // DLSR, 单位1/65536秒
+}
+
+func (r *ReceptionReport) marshalTo(dst []byte) {
+	binary.BigEndian.PutUint32(dst, r.SSRC)
+	binary.BigEndian.PutUint32(dst[4:], uint32(r.FractionLost)<<24|r.TotalLost&0xFFFFFF)
+	binary.BigEndian.PutUint32(dst[8:], r.LastSequenceNumber)
+	binary.BigEndian.PutUint32(dst[12:], r.Jitter)
+	binary.BigEndian.PutUint32(dst[16:], r.LastSenderReport)
+	binary.BigEndian.PutUint32(dst[20:], r.Delay)
+}
+
+func (r *ReceptionReport) unmarshal(data []byte) {
+	r.SSRC = binary.BigEndian.Uint32(data)
+	r.FractionLost = data[4]
+	r.TotalLost = binary.BigEndian.Uint32(data[4:]) & 0xFFFFFF
+	r.LastSequenceNumber = binary.BigEndian.Uint32(data[8:])
+	r.Jitter = binary.BigEndian.Uint32(data[12:])
+	r.LastSenderReport = binary.BigEndian.Uint32(data[16:])
+	r.Delay = binary.BigEndian.Uint32(data[20:])
+}
+
+type SenderReport struct {
+	SSRC        uint32
+	NTPTime     NTPTime
+	RTPTime     uint32
+	PacketCount uint32
+	OctetCount  uint32
+	Reports     []ReceptionReport
+}
+
+func (s *SenderReport) Type() RTCPType {
+	return RTCPTypeSR
+}
+
+func (s *SenderReport) Marshal() ([]byte, error) {
+	if len(s.Reports) > 31 {
+		return nil, fmt.Errorf("too many reception reports %d", len(s.Reports))
+	}
+
+	bytes := make([]byte, rtcpHeaderSize+24+len(s.Reports)*24)
+	writeRTCPHeader(bytes, len(s.Reports), RTCPTypeSR)
+	binary.BigEndian.PutUint32(bytes[4:], s.SSRC)
+	binary.BigEndian.PutUint64(bytes[8:], uint64(s.NTPTime))
+	binary.BigEndian.PutUint32(bytes[16:], s.RTPTime)
+	binary.BigEndian.PutUint32(bytes[20:], s.PacketCount)
+	binary.BigEndian.PutUint32(bytes[24:], s.OctetCount)
+	for i := range s.Reports {
+		s.Reports[i].marshalTo(bytes[28+i*24:])
+	}
+
+	return bytes, nil
+}
+
+func (s *SenderReport) unmarshal(count int, body []byte) error {
+	if len(body) < 24+count*24 {
+		return fmt.Errorf("invalid sender report size %d", len(body))
+	}
+
+	s.SSRC = binary.BigEndian.Uint32(body)
+	s.NTPTime = NTPTime(binary.BigEndian.Uint64(body[4:]))
+	s.RTPTime = binary.BigEndian.Uint32(body[12:])
+	s.PacketCount = binary.BigEndian.Uint32(body[16:])
+	s.OctetCount = binary.BigEndian.Uint32(body[20:])
+	s.Reports = make([]ReceptionReport, count)
+	for i := range s.Reports {
+		s.Reports[i].unmarshal(body[24+i*24:])
+	}
+
+	return nil
+}
+
+type ReceiverReport struct {
+	SSRC    uint32
+	Reports []ReceptionReport
+}
+
+func (r *ReceiverReport) Type() RTCPType {
+	return RTCPTypeRR
+}
+
+func (r *ReceiverReport) Marshal() ([]byte, error) {
+	if len(r.Reports) > 31 {
+		return nil, fmt.Errorf("too many reception reports %d", len(r.Reports))
+	}
+
+	bytes := make([]byte, rtcpHeaderSize+4+len(r.Reports)*24)
+	writeRTCPHeader(bytes, len(r.Reports), RTCPTypeRR)
+	binary.BigEndian.PutUint32(bytes[4:], r.SSRC)
+	for i := range r.Reports {
+		r.Reports[i].marshalTo(bytes[8+i*24:])
+	}
+
+	return bytes, nil
+}
+
+func (r *ReceiverReport) unmarshal(count int, body []byte) error {
+	if len(body) < 4+count*24 {
+		return fmt.Errorf("invalid receiver report size %d", len(body))
+	}
+
+	r.SSRC = binary.BigEndian.Uint32(body)
+	r.Reports = make([]ReceptionReport, count)
+	for i := range r.Reports {
+		r.Reports[i].unmarshal(body[4+i*24:])
+	}
+
+	return nil
+}
+
+type SDESItem struct {
+	Type byte
+	Text string
+}
+
+type SDESChunk struct {
+	Source uint32
+	Items  []SDESItem
+}
+
+type SourceDescription struct {
+	Chunks []SDESChunk
+}
+
+func (s *SourceDescription) Type() RTCPType {
+	return RTCPTypeSDES
+}
+
+// CNAME 返回指定源的CNAME
+func (s *SourceDescription) CNAME(ssrc uint32) string {
+	for _, chunk := range s.Chunks {
+		if chunk.Source != ssrc {
+			continue
+		}
+
+		for _, item := range chunk.Items {
+			if SDESCNAME == item.Type {
+				return item.Text
+			}
+		}
+	}
+
+	return ""
+}
+
+func (s *SourceDescription) Marshal() ([]byte, error) {
+	if len(s.Chunks) > 31 {
+		return nil, fmt.Errorf("too many sdes chunks %d", len(s.Chunks))
+	}
+
+	bytes := make([]byte, rtcpHeaderSize, 128)
+	for _, chunk := range s.Chunks {
+		bytes = binary.BigEndian.AppendUint32(bytes, chunk.Source)
+		for _, item := range chunk.Items {
+			if len(item.Text) > 255 {
+				return nil, fmt.Errorf("sdes item too long %d", len(item.Text))
+			}
+
+			bytes = append(bytes, item.Type, byte(len(item.Text)))
+			bytes = append(bytes, item.Text...)
+		}
+
+		// 结束标记, 并按4字节对齐
+		bytes = append(bytes, SDESEnd)
+		for len(bytes)%4 != 0 {
+			bytes = append(bytes, 0)
+		}
+	}
+
+	writeRTCPHeader(bytes, len(s.Chunks), RTCPTypeSDES)
+	return bytes, nil
+}
+
+func (s *SourceDescription) unmarshal(count int, body []byte) error {
+	offset := 0
+	for i := 0; i < count; i++ {
+		if len(body) < offset+4 {
+			return fmt.Errorf("invalid sdes chunk")
+		}
+
+		chunk := SDESChunk{Source: binary.BigEndian.Uint32(body[offset:])}
+		offset += 4
+		for {
+			if offset >= len(body) {
+				return fmt.Errorf("invalid sdes item")
+			} else if body[offset] == SDESEnd {
+				offset++
+				break
+			} else if len(body) < offset+2 || len(body) < offset+2+int(body[offset+1]) {
+				return fmt.Errorf("invalid sdes item")
+			}
+
+			length := int(body[offset+1])
+			chunk.Items = append(chunk.Items, SDESItem{Type: body[offset], Text: string(body[offset+2 : offset+2+length])})
+			offset += 2 + length
+		}
+
+		// 跳过对齐的0
+		offset = (offset + 3) / 4 * 4
+		s.Chunks = append(s.Chunks, chunk)
+	}
+
+	return nil
+}
+
+type Goodbye struct {
+	Sources []uint32
+	Reason  string
+}
+
+func (g *Goodbye) Type() RTCPType {
+	return RTCPTypeBYE
+}
+
+func (g *Goodbye) Marshal() ([]byte, error) {
+	if len(g.Sources) > 31 {
+		return nil, fmt.Errorf("too many bye sources %d", len(g.Sources))
+	} else if len(g.Reason) > 255 {
+		return nil, fmt.Errorf("bye reason too long %d", len(g.Reason))
+	}
+
+	bytes := make([]byte, rtcpHeaderSize, rtcpHeaderSize+len(g.Sources)*4+len(g.Reason)+4)
+	for _, source := range g.Sources {
+		bytes = binary.BigEndian.AppendUint32(bytes, source)
+	}
+
+	if len(g.Reason) > 0 {
+		bytes = append(bytes, byte(len(g.Reason)))
+		bytes = append(bytes, g.Reason...)
+		for len(bytes)%4 != 0 {
+			bytes = append(bytes, 0)
+		}
+	}
+
+	writeRTCPHeader(bytes, len(g.Sources), RTCPTypeBYE)
+	return bytes, nil
+}
+
+func (g *Goodbye) unmarshal(count int, body []byte) error {
+	if len(body) < count*4 {
+		return fmt.Errorf("invalid bye size %d", len(body))
+	}
+
+	for i := 0; i < count; i++ {
+		g.Sources = append(g.Sources, binary.BigEndian.Uint32(body[i*4:]))
+	}
+
+	if reason := body[count*4:]; len(reason) > 0 && len(reason) > int(reason[0]) {
+		g.Reason = string(reason[1 : 1+int(reason[0])])
+	}
+
+	return nil
+}
+
+// NACKPair RFC 4585 6.2.1 Generic NACK, PacketID及之后16个包的丢失位图
+type NACKPair struct {
+	PacketID    uint16
+	LostPackets uint16
+}
+
+type TransportLayerNack struct {
+	SenderSSRC uint32
+	MediaSSRC  uint32
+	Nacks      []NACKPair
+}
+
+func (t *TransportLayerNack) Type() RTCPType {
+	return RTCPTypeRTPFB
+}
+
+// LostSequences 返回丢失的全部序号
+func (t *TransportLayerNack) LostSequences() []uint16 {
+	var seqs []uint16
+	for _, pair := range t.Nacks {
+		seqs = append(seqs, pair.PacketID)
+		for i := uint16(0); i < 16; i++ {
+			if pair.LostPackets>>i&0x1 == 1 {
+				seqs = append(seqs, pair.PacketID+i+1)
+			}
+		}
+	}
+
+	return seqs
+}
+
+func (t *TransportLayerNack) Marshal() ([]byte, error) {
+	bytes := make([]byte, rtcpHeaderSize+8+len(t.Nacks)*4)
+	writeRTCPHeader(bytes, RTCPFmtNACK, RTCPTypeRTPFB)
+	binary.BigEndian.PutUint32(bytes[4:], t.SenderSSRC)
+	binary.BigEndian.PutUint32(bytes[8:], t.MediaSSRC)
+	for i, pair := range t.Nacks {
+		binary.BigEndian.PutUint16(bytes[12+i*4:], pair.PacketID)
+		binary.BigEndian.PutUint16(bytes[14+i*4:], pair.LostPackets)
+	}
+
+	return bytes, nil
+}
+
+func (t *TransportLayerNack) unmarshal(body []byte) error {
+	if len(body) < 8 {
+		return fmt.Errorf("invalid nack size %d", len(body))
+	}
+
+	t.SenderSSRC = binary.BigEndian.Uint32(body)
+	t.MediaSSRC = binary.BigEndian.Uint32(body[4:])
+	for i := 8; i+4 <= len(body); i += 4 {
+		t.Nacks = append(t.Nacks, NACKPair{PacketID: binary.BigEndian.Uint16(body[i:]), LostPackets: binary.BigEndian.Uint16(body[i+2:])})
+	}
+
+	return nil
+}
+
+// NewTransportLayerNack 使用JitterBuffer.Lost返回的丢失区间创建NACK
+func NewTransportLayerNack(senderSSRC, mediaSSRC uint32, ranges []LostRange) *TransportLayerNack {
+	nack := &TransportLayerNack{SenderSSRC: senderSSRC, MediaSSRC: mediaSSRC}
+	for _, lost := range ranges {
+		for i := 0; i < lost.Count; i++ {
+			seq := lost.Start + uint16(i)
+			if n := len(nack.Nacks); n > 0 {
+				if diff := SeqDiff(nack.Nacks[n-1].PacketID, seq); diff > 0 && diff <= 16 {
+					nack.Nacks[n-1].LostPackets |= 1 << (diff - 1)
+					continue
+				}
+			}
+
+			nack.Nacks = append(nack.Nacks, NACKPair{PacketID: seq})
+		}
+	}
+
+	return nack
+}
+
+type PictureLossIndication struct {
+	SenderSSRC uint32
+	MediaSSRC  uint32
+}
+
+func (p *PictureLossIndication) Type() RTCPType {
+	return RTCPTypePSFB
+}
+
+func (p *PictureLossIndication) Marshal() ([]byte, error) {
+	bytes := make([]byte, rtcpHeaderSize+8)
+	writeRTCPHeader(bytes, RTCPFmtPLI, RTCPTypePSFB)
+	binary.BigEndian.PutUint32(bytes[4:], p.SenderSSRC)
+	binary.BigEndian.PutUint32(bytes[8:], p.MediaSSRC)
+	return bytes, nil
+}
+
+// RawRTCPPacket 未解析的RTCP包
+type RawRTCPPacket struct {
+	PacketType RTCPType
+	Count      int
+	Data       []byte
+}
+
+func (r *RawRTCPPacket) Type() RTCPType {
+	return r.PacketType
+}
+
+func (r *RawRTCPPacket) Marshal() ([]byte, error) {
+	return r.Data, nil
+}
+
+// 写RTCP头, length为32位字长减1
+func writeRTCPHeader(dst []byte, count int, pt RTCPType) {
+	dst[0] = Version<<6 | byte(count)&0x1F
+	dst[1] = byte(pt)
+	binary.BigEndian.PutUint16(dst[2:], uint16(len(dst)/4-1))
+}
+
+// UnmarshalRTCP 解析复合RTCP包
+func UnmarshalRTCP(data []byte) ([]RTCPPacket, error) {
+	var packets []RTCPPacket
+	for len(data) > 0 {
+		if len(data) < rtcpHeaderSize {
+			return nil, fmt.Errorf("rtcp header size %d too short", len(data))
+		} else if data[0]>>6 != Version {
+			return nil, fmt.Errorf("unsupported rtcp version %d", data[0]>>6)
+		}
+
+		count := int(data[0] & 0x1F)
+		pt := RTCPType(data[1])
+		size := (int(binary.BigEndian.Uint16(data[2:])) + 1) * 4
+		if len(data) < size {
+			return nil, fmt.Errorf("rtcp packet size %d exceeds buffer size %d", size, len(data))
+		}
+
+		body := data[rtcpHeaderSize:size]
+		// 去掉填充
+		if data[0]>>5&0x1 == 1 && len(body) > 0 {
+			padding := int(body[len(body)-1])
+			if padding > len(body) {
+				return nil, fmt.Errorf("invalid rtcp padding size %d", padding)
+			}
+			body = body[:len(body)-padding]
+		}
+
+		packet, err := unmarshalRTCPPacket(pt, count, body)
+		if err != nil {
+			return nil, err
+		} else if packet == nil {
+			packet = &RawRTCPPacket{PacketType: pt, Count: count, Data: data[:size]}
+		}
+
+		packets = append(packets, packet)
+		data = data[size:]
+	}
+
+	return packets, nil
+}
+
+// 解析单个RTCP包, 不支持的类型返回nil
+func unmarshalRTCPPacket(pt RTCPType, count int, body []byte) (RTCPPacket, error) {
+	switch pt {
+	case RTCPTypeSR:
+		sr := &SenderReport{}
+		return sr, sr.unmarshal(count, body)
+	case RTCPTypeRR:
+		rr := &ReceiverReport{}
+		return rr, rr.unmarshal(count, body)
+	case RTCPTypeSDES:
+		sdes := &SourceDescription{}
+		return sdes, sdes.unmarshal(count, body)
+	case RTCPTypeBYE:
+		bye := &Goodbye{}
+		return bye, bye.unmarshal(count, body)
+	case RTCPTypeRTPFB:
+		if RTCPFmtNACK == count {
+			nack := &TransportLayerNack{}
+			return nack, nack.unmarshal(body)
+		}
+	case RTCPTypePSFB:
+		if RTCPFmtPLI == count && len(body) >= 8 {
+			return &PictureLossIndication{SenderSSRC: binary.BigEndian.Uint32(body), MediaSSRC: binary.BigEndian.Uint32(body[4:])}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// MarshalRTCP 序列化复合RTCP包
+func MarshalRTCP(packets ...RTCPPacket) ([]byte, error) {
+	var bytes []byte
+	for _, packet := range packets {
+		data, err := packet.Marshal()
+		if err != nil {
+			return nil, err
+		}
+
+		bytes = append(bytes, data...)
+	}
+
+	return bytes, nil
+}
@@ -0,0 +1,253 @@
+package rtp
+
+import (
+	"github.com/lkmio/avformat"
+	"time"
+)
+
+// SourceReceiver 接收端单个RTP源的统计, 用于生成RR, 并根据SR把RTP时间戳映射到NTP时间.
+// 统计方法参考RFC 3550 A.1 A.3 A.8.
+type SourceReceiver struct {
+	SSRC      uint32
+	clockRate int
+
+	started       bool
+	maxSeq        uint16
+	baseSeq       uint32
+	cycles        uint32 // 序号回绕次数<<16
+	received      uint32
+	expectedPrior uint32
+	receivedPrior uint32
+
+	firstArrival time.Time
+	firstTs      uint32 // 第一个RTP包的时间戳
+	transit      float64
+	jitter       float64
+
+	hasSR         bool
+	lastSR        NTPTime // 最近一次SR的NTP时间
+	lastSRRTP     uint32  // 最近一次SR的RTP时间戳
+	lastSRArrival time.Time
+}
+
+func (r *SourceReceiver) OnPacket(packet *Packet, now time.Time) {
+	seq := packet.SequenceNumber
+	if !r.started {
+		r.started = true
+		r.maxSeq = seq
+		r.baseSeq = uint32(seq)
+		r.firstArrival = now
+		r.firstTs = packet.Timestamp
+	} else if SeqLess(r.maxSeq, seq) {
+		if seq < r.maxSeq {
+			r.cycles += 1 << 16
+		}
+		r.maxSeq = seq
+	}
+
+	r.received++
+
+	// 到达时间和RTP时间戳之差的变化量
+	arrival := now.Sub(r.firstArrival).Seconds() * float64(r.clockRate)
+	transit := arrival - float64(packet.Timestamp)
+	if r.received > 1 {
+		d := transit - r.transit
+		if d < 0 {
+			d = -d
+		}
+		r.jitter += (d - r.jitter) / 16
+	}
+	r.transit = transit
+}
+
+func (r *SourceReceiver) OnSenderReport(sr *SenderReport, now time.Time) {
+	r.hasSR = true
+	r.lastSR = sr.NTPTime
+	r.lastSRRTP = sr.RTPTime
+	r.lastSRArrival = now
+}
+
+// HasSenderReport 是否收到过SR
+func (r *SourceReceiver) HasSenderReport() bool {
+	return r.hasSR
+}
+
+// ReceptionReport 生成接收报告块, 会更新丢包率的统计区间
+func (r *SourceReceiver) ReceptionReport(now time.Time) ReceptionReport {
+	extendedMax := r.cycles + uint32(r.maxSeq)
+	expected := extendedMax - r.baseSeq + 1
+	lost := int64(expected) - int64(r.received)
+	if lost < 0 {
+		lost = 0
+	} else if lost > 0x7FFFFF {
+		lost = 0x7FFFFF
+	}
+
+	expectedInterval := expected - r.expectedPrior
+	receivedInterval := r.received - r.receivedPrior
+	r.expectedPrior = expected
+	r.receivedPrior = r.received
+
+	var fraction byte
+	if lostInterval := int64(expectedInterval) - int64(receivedInterval); expectedInterval > 0 && lostInterval > 0 {
+		fraction = byte(lostInterval << 8 / int64(expectedInterval))
+	}
+
+	report := ReceptionReport{
+		SSRC:               r.SSRC,
+		FractionLost:       fraction,
+		TotalLost:          uint32(lost),
+		LastSequenceNumber: extendedMax,
+		Jitter:             uint32(r.jitter),
+	}
+
+	if r.hasSR {
+		report.LastSenderReport = r.lastSR.Middle()
+		report.Delay = uint32(now.Sub(r.lastSRArrival).Seconds() * 65536)
+	}
+
+	return report
+}
+
+// WallClock 根据最近一次SR, 把RTP时间戳映射为发送端的NTP时间
+func (r *SourceReceiver) WallClock(rtpTime uint32) (time.Time, bool) {
+	if !r.hasSR || r.clockRate <= 0 {
+		return time.Time{}, false
+	}
+
+	// 相对SR的时间差, 可正可负, 处理回绕
+	diff := int64(int32(rtpTime - r.lastSRRTP))
+	offset := time.Duration(diff * int64(time.Second) / int64(r.clockRate))
+	return r.lastSR.Time().Add(offset), true
+}
+
+func NewSourceReceiver(ssrc uint32, clockRate int) *SourceReceiver {
+	return &SourceReceiver{SSRC: ssrc, clockRate: clockRate}
+}
+
+// SourceSender 发送端单个RTP源的统计, 用于生成SR
+type SourceSender struct {
+	SSRC      uint32
+	CNAME     string
+	clockRate int
+
+	started       bool
+	packetCount   uint32
+	octetCount    uint32
+	lastRTPTime   uint32
+	lastWallClock time.Time
+}
+
+// OnPacket 统计发送的RTP包, wallClock为该包采集时的时间, 没有则传发送时间
+func (s *SourceSender) OnPacket(packet *Packet, wallClock time.Time) {
+	s.started = true
+	s.packetCount++
+	s.octetCount += uint32(len(packet.Payload))
+	s.lastRTPTime = packet.Timestamp
+	s.lastWallClock = wallClock
+}
+
+// SenderReport 生成SR, RTP时间戳由最近一次发送的包推算到now
+func (s *SourceSender) SenderReport(now time.Time) *SenderReport {
+	sr := &SenderReport{
+		SSRC:        s.SSRC,
+		NTPTime:     NewNTPTime(now),
+		PacketCount: s.packetCount,
+		OctetCount:  s.octetCount,
+	}
+
+	if s.started {
+		elapsed := now.Sub(s.lastWallClock)
+		sr.RTPTime = s.lastRTPTime + uint32(int64(elapsed)*int64(s.clockRate)/int64(time.Second))
+	}
+
+	return sr
+}
+
+// Report 生成SR+SDES复合包
+func (s *SourceSender) Report(now time.Time) ([]byte, error) {
+	sdes := &SourceDescription{Chunks: []SDESChunk{{Source: s.SSRC, Items: []SDESItem{{Type: SDESCNAME, Text: s.CNAME}}}}}
+	return MarshalRTCP(s.SenderReport(now), sdes)
+}
+
+// Started 是否发送过RTP包
+func (s *SourceSender) Started() bool {
+	return s.started
+}
+
+func NewSourceSender(ssrc uint32, clockRate int, cname string) *SourceSender {
+	return &SourceSender{SSRC: ssrc, clockRate: clockRate, CNAME: cname}
+}
+
+// Synchronizer 根据各track的SR, 把音视频的RTP时间戳放到同一条时间线上
+type Synchronizer struct {
+	receivers map[int]*SourceReceiver
+	base      time.Time // 公共时间线的起点
+	ready     bool
+}
+
+// AddTrack 绑定track索引和RTP源, 重新计算公共时间线
+func (s *Synchronizer) AddTrack(index int, receiver *SourceReceiver) {
+	s.receivers[index] = receiver
+	s.base = time.Time{}
+	s.ready = false
+}
+
+// Ready 所有track都收到SR后才能同步.
+// 公共时间线从最早开始的track的第一个RTP包算起, 该track同步前后的时间戳保持连续.
+func (s *Synchronizer) Ready() bool {
+	if s.ready {
+		return true
+	} else if len(s.receivers) == 0 {
+		return false
+	}
+
+	var base time.Time
+	for _, receiver := range s.receivers {
+		if !receiver.HasSenderReport() {
+			return false
+		}
+
+		// 没有收到过RTP包, 使用SR的时间
+		t := receiver.lastSR.Time()
+		if receiver.started {
+			t, _ = receiver.WallClock(receiver.firstTs)
+		}
+
+		if base.IsZero() || t.Before(base) {
+			base = t
+		}
+	}
+
+	s.base = base
+	s.ready = true
+	return true
+}
+
+// Apply 用RTP时间戳对应的NTP时间重写AVPacket的CreatedTime和DTS/PTS, 保持PTS-DTS差值不变.
+// 未同步时返回false, 不修改AVPacket.
+func (s *Synchronizer) Apply(packet *avformat.AVPacket, rtpTime uint32) bool {
+	receiver, ok := s.receivers[packet.Index]
+	if !ok || !s.Ready() {
+		return false
+	}
+
+	wallClock, ok := receiver.WallClock(rtpTime)
+	if !ok {
+		return false
+	}
+
+	elapsed := wallClock.Sub(s.base)
+	seconds, nanos := int64(elapsed/time.Second), int64(elapsed%time.Second)
+	dts := seconds*int64(packet.Timebase) + nanos*int64(packet.Timebase)/int64(time.Second)
+
+	offset := packet.Pts - packet.Dts
+	packet.Dts = dts
+	packet.Pts = dts + offset
+	packet.CreatedTime = wallClock.UnixMilli()
+	return true
+}
+
+func NewSynchronizer() *Synchronizer {
+	return &Synchronizer{receivers: make(map[int]*SourceReceiver)}
+}
@@ -0,0 +1,76 @@
+package rtp
+
+import (
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+	"time"
+)
+
+func TestRTCPCompound(t *testing.T) {
+	now := time.Unix(1700000000, 500000000)
+	sender := NewSourceSender(0x1234, 90000, "camera")
+	sender.OnPacket(&Packet{Header: Header{Timestamp: 90000}, Payload: make([]byte, 100)}, now)
+
+	data, err := sender.Report(now.Add(time.Second))
+	if err != nil {
+		panic(err)
+	}
+
+	nack := NewTransportLayerNack(1, 0x1234, []LostRange{{Start: 65535, Count: 3}, {Start: 100, Count: 1}})
+	bye := &Goodbye{Sources: []uint32{0x1234}, Reason: "teardown"}
+	tail, err := MarshalRTCP(nack, bye)
+	if err != nil {
+		panic(err)
+	}
+
+	packets, err := UnmarshalRTCP(append(data, tail...))
+	if err != nil {
+		panic(err)
+	}
+
+	utils.Assert(len(packets) == 4)
+	sr := packets[0].(*SenderReport)
+	utils.Assert(sr.RTPTime == 180000 && sr.PacketCount == 1 && sr.OctetCount == 100)
+	utils.Assert(sr.NTPTime.Time().Sub(now.Add(time.Second)).Abs() < time.Microsecond)
+	utils.Assert(packets[1].(*SourceDescription).CNAME(0x1234) == "camera")
+
+	seqs := packets[2].(*TransportLayerNack).LostSequences()
+	utils.Assert(len(seqs) == 4 && seqs[0] == 65535 && seqs[2] == 1 && seqs[3] == 100)
+	utils.Assert(packets[3].(*Goodbye).Reason == "teardown")
+}
+
+func TestSynchronizer(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	video := NewSourceReceiver(1, 90000)
+	audio := NewSourceReceiver(2, 8000)
+	synchronizer := NewSynchronizer()
+	synchronizer.AddTrack(0, video)
+	synchronizer.AddTrack(1, audio)
+
+	// 音频SR比视频晚100ms
+	video.OnSenderReport(&SenderReport{NTPTime: NewNTPTime(now), RTPTime: 1000}, now)
+	audio.OnSenderReport(&SenderReport{NTPTime: NewNTPTime(now.Add(100 * time.Millisecond)), RTPTime: 50000}, now)
+
+	videoPacket := avformat.NewVideoPacket(nil, 0, 3600, true, avformat.PacketTypeAnnexB, utils.AVCodecIdH264, 0, 90000)
+	utils.Assert(synchronizer.Apply(videoPacket, 1000+9000))
+	audioPacket := avformat.NewAudioPacket(nil, 0, utils.AVCodecIdPCMALAW, 1, 8000)
+	utils.Assert(synchronizer.Apply(audioPacket, 50000))
+
+	// 同一时刻采集的音视频
+	utils.Assert(videoPacket.Dts == 9000 && videoPacket.Pts == 12600)
+	utils.Assert(audioPacket.Dts == 800)
+	utils.Assert(videoPacket.CreatedTime == audioPacket.CreatedTime)
+
+	// 新增的track收到SR之前不能同步, 起点从最早的RTP包重新计算
+	subtitle := NewSourceReceiver(3, 90000)
+	synchronizer.AddTrack(2, subtitle)
+	utils.Assert(!synchronizer.Ready())
+	subtitle.OnPacket(&Packet{Header: Header{SequenceNumber: 1, Timestamp: 0}}, now)
+	subtitle.OnSenderReport(&SenderReport{NTPTime: NewNTPTime(now.Add(time.Second)), RTPTime: 90000}, now)
+	utils.Assert(synchronizer.Ready())
+
+	videoPacket = avformat.NewVideoPacket(nil, 0, 0, true, avformat.PacketTypeAnnexB, utils.AVCodecIdH264, 0, 90000)
+	utils.Assert(synchronizer.Apply(videoPacket, 1000))
+	utils.Assert(videoPacket.Dts == 0)
+}
@@ -0,0 +1,98 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/avc"
+	"github.com/lkmio/avformat/hevc"
+	"github.com/lkmio/avformat/utils"
+)
+
+const (
+	h264NalSTAPA = 24
+	h264NalFUA   = 28
+	h265NalAP    = 48
+	h265NalFU    = 49
+)
+
+// IsKeyFramePayload 判断RTP负载是否包含关键帧, 负载会还原成AnnexB后交给avformat.IsKeyFrame判断.
+// 分片包只有第一个分片能判断出关键帧.
+func IsKeyFramePayload(id utils.AVCodecID, payload []byte) bool {
+	var annexB []byte
+	if utils.AVCodecIdH264 == id {
+		annexB = h264Payload2AnnexB(payload)
+	} else if utils.AVCodecIdH265 == id {
+		annexB = h265Payload2AnnexB(payload)
+	}
+
+	if annexB == nil {
+		return false
+	}
+
+	return avformat.IsKeyFrame(id, annexB)
+}
+
+// IsKeyFrameCodec 是否支持从RTP负载中判断关键帧
+func IsKeyFrameCodec(id utils.AVCodecID) bool {
+	return utils.AVCodecIdH264 == id || utils.AVCodecIdH265 == id
+}
+
+func h264Payload2AnnexB(payload []byte) []byte {
+	if len(payload) < 1 {
+		return nil
+	}
+
+	switch payload[0] & 0x1F {
+	case h264NalSTAPA:
+		return aggregation2AnnexB(payload[1:])
+	case h264NalFUA:
+		// 非起始分片
+		if len(payload) < 2 || payload[1]&0x80 == 0 {
+			return nil
+		}
+
+		annexB := append([]byte{}, avc.StartCode4...)
+		annexB = append(annexB, payload[0]&0xE0|payload[1]&0x1F)
+		return append(annexB, payload[2:]...)
+	default:
+		return append(append([]byte{}, avc.StartCode4...), payload...)
+	}
+}
+
+func h265Payload2AnnexB(payload []byte) []byte {
+	if len(payload) < 2 {
+		return nil
+	}
+
+	switch hevc.HEVCNALUnitType(payload[0] >> 1 & 0x3F) {
+	case h265NalAP:
+		return aggregation2AnnexB(payload[2:])
+	case h265NalFU:
+		if len(payload) < 3 || payload[2]&0x80 == 0 {
+			return nil
+		}
+
+		annexB := append([]byte{}, hevc.StartCode4...)
+		annexB = append(annexB, payload[0]&0x81|(payload[2]&0x3F)<<1, payload[1])
+		return append(annexB, payload[3:]...)
+	default:
+		return append(append([]byte{}, hevc.StartCode4...), payload...)
+	}
+}
+
+// 聚合包(STAP-A/AP)转AnnexB, 每个NALU前有2字节长度
+func aggregation2AnnexB(data []byte) []byte {
+	var annexB []byte
+	for len(data) > 2 {
+		size := int(binary.BigEndian.Uint16(data))
+		if size == 0 || len(data)-2 < size {
+			break
+		}
+
+		annexB = append(annexB, avc.StartCode4...)
+		annexB = append(annexB, data[2:2+size]...)
+		data = data[2+size:]
+	}
+
+	return annexB
+}
@@ -0,0 +1,138 @@
+package rtsp
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/lkmio/avformat/utils"
+	"strings"
+)
+
+// Authenticator 服务端鉴权, 同时支持Basic和Digest(RFC 2617)
+type Authenticator struct {
+	Realm    string
+	Username string
+	Password string
+}
+
+// Challenge 返回401应答的WWW-Authenticate头, 优先Digest. nonce由每个连接单独生成, 防止在其他连接上重放
+func (a *Authenticator) Challenge(nonce string) []string {
+	return []string{
+		fmt.Sprintf("Digest realm=\"%s\", nonce=\"%s\", algorithm=\"MD5\"", a.Realm, nonce),
+		fmt.Sprintf("Basic realm=\"%s\"", a.Realm),
+	}
+}
+
+// Verify 校验请求的Authorization头, Digest的nonce必须是当前连接下发的, uri必须与请求的URL一致
+func (a *Authenticator) Verify(method, url, nonce, authorization string) bool {
+	scheme, value, _ := strings.Cut(authorization, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		return err == nil && string(decoded) == a.Username+":"+a.Password
+	case "digest":
+		params := parseAuthParams(value)
+		if params["username"] != a.Username || params["realm"] != a.Realm || params["nonce"] != nonce || params["uri"] != url {
+			return false
+		}
+
+		expected := digestResponse(a.Username, a.Password, a.Realm, nonce, method, params["uri"], params["qop"], params["nc"], params["cnonce"])
+		return expected == params["response"]
+	default:
+		return false
+	}
+}
+
+func NewAuthenticator(realm, username, password string) *Authenticator {
+	return &Authenticator{Realm: realm, Username: username, Password: password}
+}
+
+// Authorization 客户端根据WWW-Authenticate生成Authorization头, 多个质询时优先Digest
+func Authorization(challenges []string, method, uri, username, password string) (string, error) {
+	var basic string
+	for _, challenge := range challenges {
+		scheme, value, _ := strings.Cut(challenge, " ")
+		switch strings.ToLower(scheme) {
+		case "digest":
+			params := parseAuthParams(value)
+			qop, nc, cnonce := "", "", ""
+			// 只支持qop=auth
+			for _, option := range strings.Split(params["qop"], ",") {
+				if strings.TrimSpace(option) == "auth" {
+					qop, nc, cnonce = "auth", "00000001", utils.RandStringBytes(16)
+				}
+			}
+
+			response := digestResponse(username, password, params["realm"], params["nonce"], method, uri, qop, nc, cnonce)
+			authorization := fmt.Sprintf("Digest username=\"%s\", realm=\"%s\", nonce=\"%s\", uri=\"%s\", response=\"%s\"",
+				username, params["realm"], params["nonce"], uri, response)
+			if qop != "" {
+				authorization += fmt.Sprintf(", qop=%s, nc=%s, cnonce=\"%s\"", qop, nc, cnonce)
+			}
+			if opaque, ok := params["opaque"]; ok {
+				authorization += fmt.Sprintf(", opaque=\"%s\"", opaque)
+			}
+
+			return authorization, nil
+		case "basic":
+			basic = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+		}
+	}
+
+	if basic == "" {
+		return "", fmt.Errorf("unsupported authenticate: %s", strings.Join(challenges, ";"))
+	}
+
+	return basic, nil
+}
+
+func md5Hex(value string) string {
+	sum := md5.Sum([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func digestResponse(username, password, realm, nonce, method, uri, qop, nc, cnonce string) string {
+	ha1 := md5Hex(username + ":" + realm + ":" + password)
+	ha2 := md5Hex(method + ":" + uri)
+	if qop == "" {
+		return md5Hex(ha1 + ":" + nonce + ":" + ha2)
+	}
+
+	return md5Hex(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+}
+
+// 解析key="value", key=value形式的参数, key转为小写
+func parseAuthParams(value string) map[string]string {
+	params := make(map[string]string)
+	for len(value) > 0 {
+		value = strings.TrimLeft(value, " ,")
+		i := strings.IndexByte(value, '=')
+		if i <= 0 {
+			break
+		}
+
+		key := strings.ToLower(strings.TrimSpace(value[:i]))
+		value = strings.TrimLeft(value[i+1:], " ")
+		if strings.HasPrefix(value, "\"") {
+			end := strings.IndexByte(value[1:], '"')
+			if end < 0 {
+				params[key] = value[1:]
+				break
+			}
+
+			params[key] = value[1 : end+1]
+			value = value[end+2:]
+		} else {
+			end := strings.IndexByte(value, ',')
+			if end < 0 {
+				end = len(value)
+			}
+
+			params[key] = strings.TrimSpace(value[:end])
+			value = value[end:]
+		}
+	}
+
+	return params
+}
@@ -0,0 +1,277 @@
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/rtp"
+	"github.com/lkmio/avformat/utils"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type testHandler struct {
+	tracks    []avformat.Track
+	completed chan struct{}
+	packets   chan *avformat.AVPacket
+}
+
+func (h *testHandler) OnNewTrack(track avformat.Track) {
+	h.tracks = append(h.tracks, track)
+}
+
+func (h *testHandler) OnTrackComplete() {
+	close(h.completed)
+}
+
+func (h *testHandler) OnTrackNotFind() {
+}
+
+func (h *testHandler) OnPacket(packet *avformat.AVPacket) {
+	// Packet回调后会被释放, 拷贝一份
+	packet = &avformat.AVPacket{Data: append([]byte{}, packet.Data...), Dts: packet.Dts, Key: packet.Key, MediaType: packet.MediaType, Index: packet.Index, Timebase: packet.Timebase, CreatedTime: packet.CreatedTime}
+	select {
+	case h.packets <- packet:
+	default:
+	}
+}
+
+func pull(url, transport string) (*testHandler, *Client) {
+	handler := &testHandler{completed: make(chan struct{}), packets: make(chan *avformat.AVPacket, 1024)}
+	client, err := NewClient(url)
+	if err != nil {
+		panic(err)
+	}
+
+	client.SetHandler(handler)
+	client.SetTransport(transport)
+	if err = client.Start(); err != nil {
+		panic(err)
+	}
+
+	return handler, client
+}
+
+func TestClient(t *testing.T) {
+	server, keyFrame, aacFrame, stop := startTestServer()
+	defer stop()
+
+	port := utils.RandomIntInRange(20000, 30000) &^ 1
+	if err := server.ListenUDP(port); err != nil {
+		panic(err)
+	}
+
+	address := server.Addr().String()
+	for _, transport := range []string{TransportTCP, TransportUDP} {
+		handler, client := pull("rtsp://admin:123456@"+address+"/live/test", transport)
+
+		select {
+		case <-handler.completed:
+		case <-time.After(5 * time.Second):
+			panic("track not complete")
+		}
+		utils.Assert(len(handler.tracks) == 2)
+		utils.Assert(handler.tracks[0].GetStream().CodecID == utils.AVCodecIdH264)
+		utils.Assert(handler.tracks[1].GetStream().CodecID == utils.AVCodecIdAAC && handler.tracks[1].GetStream().SampleRate == 16000)
+
+		var video, audio int
+		for video < 3 || audio < 3 {
+			select {
+			case packet := <-handler.packets:
+				if utils.AVMediaTypeVideo == packet.MediaType {
+					utils.Assert(packet.Key && bytes.Equal(packet.Data, keyFrame))
+					video++
+				} else {
+					utils.Assert(bytes.Equal(packet.Data, aacFrame))
+					audio++
+				}
+			case <-time.After(5 * time.Second):
+				panic("packet timeout " + transport + " " + strconv.Itoa(video) + " " + strconv.Itoa(audio))
+			}
+		}
+
+		client.Close()
+		utils.Assert(client.Wait() == nil)
+	}
+
+	// 错误的密码
+	client, _ := NewClient("rtsp://admin:654321@" + address + "/live/test")
+	client.SetHandler(&testHandler{})
+	utils.Assert(client.Start() != nil)
+}
+
+// 模拟摄像头完成握手, PLAY之后调用play发送数据. serverPort不为0时使用UDP传输
+func serveTestCamera(listener net.Listener, serverPort int, play func(conn net.Conn, transports []*Transport)) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var transports []*Transport
+	reader := bufio.NewReader(conn)
+	for {
+		request, err := ReadRequest(reader)
+		if err != nil {
+			return
+		}
+
+		response := NewResponse(request, StatusOK)
+		switch request.Method {
+		case MethodDescribe:
+			response.Header.Set("Content-Base", "rtsp://"+listener.Addr().String()+"/live/test/")
+			response.Body = []byte(cameraSDP)
+		case MethodSetup:
+			transport, err := ParseTransport(request.Header.Get("Transport"))
+			if err != nil {
+				return
+			} else if serverPort > 0 {
+				transport.ServerPort = []int{serverPort, serverPort + 1}
+			}
+
+			transports = append(transports, transport)
+			response.Header.Set("Transport", transport.String())
+			response.Header.Set("Session", "1")
+		}
+
+		if _, err = conn.Write(response.Marshal()); err != nil {
+			return
+		} else if MethodPlay == request.Method {
+			break
+		}
+	}
+
+	play(conn, transports)
+	_, _ = reader.WriteTo(io.Discard)
+}
+
+// 通过TCP交织发送音视频, 第一帧之后发送SR, 音频第一帧比视频晚采集audioOffset
+func serveSynchronized(listener net.Listener, keyFrame, aacFrame []byte, start time.Time, audioOffset time.Duration) {
+	serveTestCamera(listener, 0, func(conn net.Conn, transports []*Transport) {
+		video, _ := rtp.NewPacketizer(utils.AVCodecIdH264, 96, 1, 0, 1400)
+		audio, _ := rtp.NewPacketizer(utils.AVCodecIdAAC, 97, 2, 0, 1400)
+		var data []byte
+		for i := 0; i < 10; i++ {
+			_ = video.Packetize(keyFrame, uint32(1000+i*3600), func(packet []byte) {
+				data = AppendInterleavedFrame(data, 0, packet)
+			})
+			_ = audio.Packetize(aacFrame, uint32(50000+i*640), func(packet []byte) {
+				data = AppendInterleavedFrame(data, 2, packet)
+			})
+
+			if i == 0 {
+				videoSR, _ := rtp.MarshalRTCP(&rtp.SenderReport{SSRC: 1, NTPTime: rtp.NewNTPTime(start), RTPTime: 1000})
+				audioSR, _ := rtp.MarshalRTCP(&rtp.SenderReport{SSRC: 2, NTPTime: rtp.NewNTPTime(start.Add(audioOffset)), RTPTime: 50000})
+				data = AppendInterleavedFrame(data, 1, videoSR)
+				data = AppendInterleavedFrame(data, 3, audioSR)
+			}
+		}
+
+		_, _ = conn.Write(data)
+	})
+}
+
+func TestClientSynchronize(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer listener.Close()
+
+	start := time.Unix(1700000000, 0)
+	keyFrame := testKeyFrame(testStreams()[0])
+	go serveSynchronized(listener, keyFrame, bytes.Repeat([]byte{0x21}, 20), start, 100*time.Millisecond)
+
+	handler, client := pull("rtsp://"+listener.Addr().String()+"/live/test", TransportTCP)
+	defer client.Close()
+
+	// 最后一帧保留在demuxer中
+	var video, audio int
+	for video < 9 || audio < 9 {
+		select {
+		case packet := <-handler.packets:
+			// 同步后的时间戳与采集时间一致, 公共时间线从视频第一帧开始
+			utils.Assert(packet.CreatedTime > 0)
+			elapsed := packet.CreatedTime - start.UnixMilli()
+			diff := packet.Dts*1000/int64(packet.Timebase) - elapsed
+			utils.Assert(diff >= -1 && diff <= 1)
+
+			if utils.AVMediaTypeVideo == packet.MediaType {
+				utils.Assert(packet.Dts == int64(video*3600))
+				video++
+			} else {
+				utils.Assert(packet.Dts == int64(1600+audio*640))
+				audio++
+			}
+		case <-time.After(5 * time.Second):
+			panic("packet timeout " + strconv.Itoa(video) + " " + strconv.Itoa(audio))
+		}
+	}
+}
+
+func TestClientJitterBuffer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer listener.Close()
+
+	serverRTP, serverRTCP, err := listenUDPPair()
+	if err != nil {
+		panic(err)
+	}
+	defer serverRTP.Close()
+	defer serverRTCP.Close()
+
+	port := serverRTP.LocalAddr().(*net.UDPAddr).Port
+	go serveTestCamera(listener, port, func(conn net.Conn, transports []*Transport) {
+		// 丢失序号1, 之后没有新的包
+		addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: transports[0].ClientPort[0]}
+		for _, seq := range []uint16{0, 2, 3, 4} {
+			packet := &rtp.Packet{Header: rtp.Header{Version: rtp.Version, Marker: true, PayloadType: 96, SequenceNumber: seq, Timestamp: uint32(seq) * 3600, SSRC: 1}, Payload: []byte{0x65, 0x88, 0x84, 0x00}}
+			data, _ := packet.Marshal()
+			_, _ = serverRTP.WriteToUDP(data, addr)
+		}
+	})
+
+	handler, client := pull("rtsp://"+listener.Addr().String()+"/live/test", TransportUDP)
+	defer client.Close()
+
+	// 请求重传丢失的包, 放弃空洞后请求关键帧
+	var nack, pli bool
+	buffer := make([]byte, 1500)
+	_ = serverRTCP.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for !nack || !pli {
+		n, _, err := serverRTCP.ReadFromUDP(buffer)
+		if err != nil {
+			panic(err)
+		}
+
+		packets, err := rtp.UnmarshalRTCP(buffer[:n])
+		utils.Assert(err == nil)
+		for _, packet := range packets {
+			switch packet := packet.(type) {
+			case *rtp.TransportLayerNack:
+				seqs := packet.LostSequences()
+				utils.Assert(!nack && packet.MediaSSRC == 1 && len(seqs) == 1 && seqs[0] == 1)
+				nack = true
+			case *rtp.PictureLossIndication:
+				utils.Assert(packet.MediaSSRC == 1)
+				pli = true
+			}
+		}
+	}
+
+	// 没有新的包到达, 定时输出空洞之后的包. 空洞之后的第一帧不完整, 最后一帧保留在demuxer中
+	for frames := 0; frames < 2; frames++ {
+		select {
+		case packet := <-handler.packets:
+			utils.Assert(packet.Key && utils.AVMediaTypeVideo == packet.MediaType)
+		case <-time.After(5 * time.Second):
+			panic("packet timeout " + strconv.Itoa(frames))
+		}
+	}
+}
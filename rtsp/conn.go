@@ -0,0 +1,545 @@
+package rtsp
+
+import (
+	"bufio"
+	"github.com/lkmio/avformat/rtp"
+	"github.com/lkmio/avformat/sdp"
+	"github.com/lkmio/avformat/utils"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 推流的track
+type publishTrack struct {
+	control     string
+	codec       utils.AVCodecID
+	payloadType byte
+
+	// UDP推流
+	jitterBuffer *rtp.JitterBuffer
+	mediaSSRC    uint32
+	nacks        nackFilter
+}
+
+// conn 一个TCP连接对应一个会话
+type conn struct {
+	server     *Server
+	conn       net.Conn
+	reader     *bufio.Reader
+	writeMutex sync.Mutex
+	writeBuf   []byte
+
+	session    string
+	nonce      string // Digest鉴权使用, 每个连接不同
+	path       string
+	stream     *Stream
+	transports map[int]*Transport // key为track索引
+	udpAddrs   []string
+	subscriber *subscriber
+	playing    bool
+
+	// 推流
+	publishing    bool
+	recording     bool
+	publishTracks []*publishTrack
+	inputMutex    sync.Mutex
+	demuxer       *rtp.Demuxer
+	ssrc          uint32 // 本端发送NACK/PLI使用的SSRC
+	closed        chan struct{}
+}
+
+func (c *conn) run() {
+	defer c.close()
+
+	for {
+		_ = c.conn.SetReadDeadline(time.Now().Add(SessionTimeout))
+		interleaved, err := IsInterleavedFrame(c.reader)
+		if err != nil {
+			return
+		}
+
+		if interleaved {
+			channel, data, err := ReadInterleavedFrame(c.reader)
+			if err != nil {
+				return
+			}
+
+			c.onInterleavedFrame(channel, data)
+			continue
+		}
+
+		request, err := ReadRequest(c.reader)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+
+		response, closeConn := c.handle(request)
+		if err = c.writeResponse(response); err != nil || closeConn {
+			return
+		}
+
+		// 应答PLAY后再开始发送RTP包
+		if c.subscriber != nil && !c.playing {
+			c.playing = true
+			go c.play(c.subscriber)
+		}
+	}
+}
+
+func (c *conn) handle(request *Request) (*Response, bool) {
+	if c.server.auth != nil && request.Method != MethodOptions && !c.server.auth.Verify(request.Method, request.URL, c.nonce, request.Header.Get("Authorization")) {
+		response := NewResponse(request, StatusUnauthorized)
+		for _, challenge := range c.server.auth.Challenge(c.nonce) {
+			response.Header.Add("WWW-Authenticate", challenge)
+		}
+
+		return response, false
+	}
+
+	if id := sessionID(request.Header.Get("Session")); id != "" && id != c.session {
+		return NewResponse(request, StatusSessionNotFound), false
+	}
+
+	var response *Response
+	switch request.Method {
+	case MethodOptions:
+		response = NewResponse(request, StatusOK)
+		response.Header.Set("Public", strings.Join([]string{MethodOptions, MethodDescribe, MethodAnnounce, MethodSetup,
+			MethodPlay, MethodRecord, MethodTeardown, MethodGetParameter, MethodSetParameter}, ", "))
+	case MethodDescribe:
+		response = c.onDescribe(request)
+	case MethodAnnounce:
+		response = c.onAnnounce(request)
+	case MethodSetup:
+		response = c.onSetup(request)
+	case MethodPlay:
+		response = c.onPlay(request)
+	case MethodRecord:
+		response = c.onRecord(request)
+	case MethodTeardown:
+		response = NewResponse(request, StatusOK)
+		return response, true
+	case MethodGetParameter, MethodSetParameter:
+		response = NewResponse(request, StatusOK)
+	default:
+		response = NewResponse(request, StatusNotImplemented)
+	}
+
+	if c.session != "" && response.StatusCode == StatusOK {
+		response.Header.Set("Session", formatSession(c.session))
+	}
+
+	return response, false
+}
+
+func (c *conn) onDescribe(request *Request) *Response {
+	path, stream, _ := c.server.findStreamByURL(request.URL)
+	if stream == nil || !stream.WaitTracks(describeTimeout) {
+		return NewResponse(request, StatusNotFound)
+	}
+
+	c.path, c.stream = path, stream
+	response := NewResponse(request, StatusOK)
+	response.Header.Set("Content-Type", "application/sdp")
+	response.Header.Set("Content-Base", strings.TrimSuffix(request.URL, "/")+"/")
+	response.Body = stream.SDP().Marshal()
+	return response
+}
+
+func (c *conn) onAnnounce(request *Request) *Response {
+	if c.stream != nil {
+		return NewResponse(request, StatusMethodNotValid)
+	} else if !strings.EqualFold(request.Header.Get("Content-Type"), "application/sdp") {
+		return NewResponse(request, StatusBadRequest)
+	}
+
+	session, err := sdp.Parse(request.Body)
+	if err != nil {
+		println(err.Error())
+		return NewResponse(request, StatusBadRequest)
+	}
+
+	var tracks []*publishTrack
+	stream := NewStream()
+	demuxer := rtp.NewDemuxer()
+	demuxer.SetHandler(stream)
+	for i, media := range session.Medias {
+		avStream, err := media.Stream(i)
+		if err != nil {
+			println(err.Error())
+			continue
+		}
+
+		payloadType := byte(media.PayloadType())
+		if err = demuxer.AddStreamWithFmtp(avStream, payloadType, media.Fmtp(int(payloadType))); err != nil {
+			println(err.Error())
+			continue
+		}
+
+		tracks = append(tracks, &publishTrack{control: media.Control(), codec: avStream.CodecID, payloadType: payloadType})
+	}
+
+	if len(tracks) == 0 {
+		return NewResponse(request, StatusUnsupportedTransport)
+	}
+
+	path := requestPath(request.URL)
+	if c.server.onPublish != nil && !c.server.onPublish(path, stream) {
+		return NewResponse(request, StatusForbidden)
+	} else if !c.server.AddStream(path, stream) {
+		return NewResponse(request, StatusForbidden)
+	}
+
+	// SDP中的编码器信息完整时, 不需要探测
+	if demuxer.Tracks.Size() == len(tracks) {
+		demuxer.ProbeComplete()
+	}
+
+	c.path, c.stream, c.demuxer, c.publishing, c.publishTracks = path, stream, demuxer, true, tracks
+	c.session = utils.RandStringBytes(16)
+	return NewResponse(request, StatusOK)
+}
+
+// 根据SETUP的URL查找track索引
+func (c *conn) findTrack(rawURL string) int {
+	if c.publishing {
+		for i, track := range c.publishTracks {
+			if track.control != "" && (rawURL == track.control || strings.HasSuffix(rawURL, "/"+track.control)) {
+				return i
+			}
+		}
+
+		// 没有control时按顺序SETUP
+		if len(c.transports) < len(c.publishTracks) && c.publishTracks[len(c.transports)].control == "" {
+			return len(c.transports)
+		}
+
+		return -1
+	}
+
+	path, stream, control := c.server.findStreamByURL(rawURL)
+	if stream == nil || (c.stream != nil && stream != c.stream) || !stream.WaitTracks(describeTimeout) {
+		return -1
+	}
+
+	c.path, c.stream = path, stream
+	if control == "" && stream.trackCount() == 1 {
+		return 0
+	}
+
+	return stream.findTrack(control)
+}
+
+func (c *conn) onSetup(request *Request) *Response {
+	if c.subscriber != nil || c.recording {
+		return NewResponse(request, StatusMethodNotValid)
+	}
+
+	transport, err := ParseTransport(request.Header.Get("Transport"))
+	if err != nil || transport.Multicast {
+		return NewResponse(request, StatusUnsupportedTransport)
+	}
+
+	index := c.findTrack(request.URL)
+	if index < 0 {
+		return NewResponse(request, StatusNotFound)
+	}
+
+	if transport.IsTCP() {
+		if len(transport.Interleaved) == 0 {
+			transport.Interleaved = []int{index * 2, index*2 + 1}
+		}
+	} else if c.server.rtpConn == nil || len(transport.ClientPort) == 0 {
+		return NewResponse(request, StatusUnsupportedTransport)
+	} else {
+		host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+		transport.Destination = ""
+		transport.ServerPort = []int{c.server.rtpPort, c.server.rtpPort + 1}
+		for _, port := range transport.ClientPort {
+			addr := net.JoinHostPort(host, strconv.Itoa(port))
+			c.server.addUDPTarget(addr, c)
+			c.udpAddrs = append(c.udpAddrs, addr)
+		}
+	}
+
+	if c.session == "" {
+		c.session = utils.RandStringBytes(16)
+	}
+
+	c.transports[index] = transport
+	response := NewResponse(request, StatusOK)
+	response.Header.Set("Transport", transport.String())
+	return response
+}
+
+func (c *conn) onPlay(request *Request) *Response {
+	if c.publishing || c.stream == nil || len(c.transports) == 0 {
+		return NewResponse(request, StatusMethodNotValid)
+	} else if c.subscriber != nil {
+		// 已经在播放
+		return NewResponse(request, StatusOK)
+	}
+
+	sub, err := c.stream.subscribe()
+	if err != nil {
+		return NewResponse(request, StatusNotFound)
+	}
+
+	c.subscriber = sub
+
+	response := NewResponse(request, StatusOK)
+	response.Header.Set("Range", "npt=0.000-")
+	return response
+}
+
+func (c *conn) onRecord(request *Request) *Response {
+	if !c.publishing || len(c.transports) == 0 {
+		return NewResponse(request, StatusMethodNotValid)
+	}
+
+	c.inputMutex.Lock()
+	defer c.inputMutex.Unlock()
+	if c.recording {
+		return NewResponse(request, StatusOK)
+	}
+
+	// UDP推流经过JitterBuffer排序, 定时输出超时的包
+	c.recording = true
+	for index, transport := range c.transports {
+		if !transport.IsTCP() {
+			track := c.publishTracks[index]
+			track.jitterBuffer = rtp.NewJitterBuffer(track.codec, rtp.DefaultJitterLatency)
+			track.jitterBuffer.SetOnKeyFrameRequired(func() {
+				c.requestKeyFrame(track)
+			})
+		}
+	}
+
+	if len(c.udpAddrs) > 0 {
+		go c.drainLoop()
+	}
+	return NewResponse(request, StatusOK)
+}
+
+// 发送RTP/RTCP包, 直到会话关闭或取消订阅
+func (c *conn) play(sub *subscriber) {
+	host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+	ip := net.ParseIP(host)
+
+	for packet := range sub.packets {
+		transport := c.transports[packet.track]
+		if transport == nil {
+			continue
+		}
+
+		var err error
+		if transport.IsTCP() {
+			channel := transport.Interleaved[0]
+			if packet.rtcp {
+				channel = transport.Interleaved[1]
+			}
+
+			err = c.writeInterleavedFrame(channel, packet.data)
+		} else if packet.rtcp {
+			_, err = c.server.rtcpConn.WriteToUDP(packet.data, &net.UDPAddr{IP: ip, Port: transport.ClientPort[1]})
+		} else {
+			_, err = c.server.rtpConn.WriteToUDP(packet.data, &net.UDPAddr{IP: ip, Port: transport.ClientPort[0]})
+		}
+
+		if err != nil {
+			println(err.Error())
+			c.conn.Close()
+			return
+		}
+	}
+
+	// 流被关闭
+	c.conn.Close()
+}
+
+func (c *conn) onInterleavedFrame(channel int, data []byte) {
+	c.inputMutex.Lock()
+	defer c.inputMutex.Unlock()
+	if !c.recording {
+		return
+	}
+
+	for _, transport := range c.transports {
+		if len(transport.Interleaved) > 0 && transport.Interleaved[0] == channel {
+			if _, err := c.demuxer.Input(data); err != nil {
+				println(err.Error())
+			}
+			return
+		}
+	}
+}
+
+func (c *conn) onUDPPacket(data []byte, rtcp bool) {
+	// UDP会话通过RTCP保活
+	_ = c.conn.SetReadDeadline(time.Now().Add(SessionTimeout))
+	if rtcp {
+		return
+	}
+
+	packet := &rtp.Packet{}
+	if err := packet.Unmarshal(data); err != nil {
+		return
+	}
+
+	c.inputMutex.Lock()
+	defer c.inputMutex.Unlock()
+	if !c.recording {
+		return
+	}
+
+	// UDP可能乱序, 经过JitterBuffer排序后再解析
+	var track *publishTrack
+	for _, publish := range c.publishTracks {
+		if publish.payloadType == packet.PayloadType && publish.jitterBuffer != nil {
+			track = publish
+		}
+	}
+
+	if track == nil {
+		return
+	}
+
+	now := time.Now()
+	track.mediaSSRC = packet.SSRC
+	track.jitterBuffer.Push(packet, now)
+	c.inputPackets(track.jitterBuffer.Pop(now))
+	c.sendNack(track)
+}
+
+func (c *conn) inputPackets(packets []*rtp.Packet) {
+	for _, packet := range packets {
+		if err := c.demuxer.InputPacket(packet); err != nil {
+			println(err.Error())
+		}
+	}
+}
+
+// 定时输出JitterBuffer中等待超时的包, 没有新包到达时也能放弃空洞
+func (c *conn) drainLoop() {
+	ticker := time.NewTicker(jitterDrainInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case now := <-ticker.C:
+			c.inputMutex.Lock()
+			if !c.recording {
+				c.inputMutex.Unlock()
+				return
+			}
+
+			for _, track := range c.publishTracks {
+				if track.jitterBuffer != nil {
+					c.inputPackets(track.jitterBuffer.Pop(now))
+				}
+			}
+			c.inputMutex.Unlock()
+		}
+	}
+}
+
+// 请求推流端重传JitterBuffer中的空洞, 每个序号只请求一次
+func (c *conn) sendNack(track *publishTrack) {
+	if ranges := track.nacks.filter(track.jitterBuffer.Lost()); len(ranges) > 0 {
+		c.writeRTCP(track, rtp.NewTransportLayerNack(c.ssrc, track.mediaSSRC, ranges))
+	}
+}
+
+// 丢包后请求关键帧
+func (c *conn) requestKeyFrame(track *publishTrack) {
+	c.writeRTCP(track, &rtp.PictureLossIndication{SenderSSRC: c.ssrc, MediaSSRC: track.mediaSSRC})
+}
+
+// 发送RTCP到推流端的RTCP端口
+func (c *conn) writeRTCP(track *publishTrack, packets ...rtp.RTCPPacket) {
+	var transport *Transport
+	for index, publish := range c.publishTracks {
+		if publish == track {
+			transport = c.transports[index]
+		}
+	}
+
+	if transport == nil || len(transport.ClientPort) < 2 {
+		return
+	}
+
+	data, err := rtp.MarshalRTCP(packets...)
+	if err == nil {
+		host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+		_, err = c.server.rtcpConn.WriteToUDP(data, &net.UDPAddr{IP: net.ParseIP(host), Port: transport.ClientPort[1]})
+	}
+
+	if err != nil {
+		println(err.Error())
+	}
+}
+
+func (c *conn) writeResponse(response *Response) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	_, err := c.conn.Write(response.Marshal())
+	return err
+}
+
+func (c *conn) writeInterleavedFrame(channel int, data []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	c.writeBuf = AppendInterleavedFrame(c.writeBuf[:0], channel, data)
+	_, err := c.conn.Write(c.writeBuf)
+	return err
+}
+
+func (c *conn) close() {
+	close(c.closed)
+	c.conn.Close()
+	c.server.removeConn(c)
+	for _, addr := range c.udpAddrs {
+		c.server.removeUDPTarget(addr, c)
+	}
+
+	if c.subscriber != nil {
+		c.stream.unsubscribe(c.subscriber)
+	}
+
+	if c.publishing {
+		c.server.removeStream(c.path, c.stream)
+		c.inputMutex.Lock()
+		c.recording = false
+		// 输出JitterBuffer中剩余的包, 连接已关闭, 不再请求关键帧
+		for _, track := range c.publishTracks {
+			if track.jitterBuffer != nil {
+				track.jitterBuffer.SetOnKeyFrameRequired(nil)
+				c.inputPackets(track.jitterBuffer.Flush())
+			}
+		}
+		c.demuxer.Close()
+		c.inputMutex.Unlock()
+		c.stream.Close()
+	}
+}
+
+func newConn(server *Server, netConn net.Conn) *conn {
+	return &conn{
+		server:     server,
+		conn:       netConn,
+		reader:     bufio.NewReaderSize(netConn, 4096),
+		transports: make(map[int]*Transport),
+		nonce:      utils.RandStringBytes(32),
+		ssrc:       rand.Uint32(),
+		closed:     make(chan struct{}),
+	}
+}
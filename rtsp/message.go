@@ -0,0 +1,318 @@
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	Version = "RTSP/1.0"
+
+	MethodOptions      = "OPTIONS"
+	MethodDescribe     = "DESCRIBE"
+	MethodAnnounce     = "ANNOUNCE"
+	MethodSetup        = "SETUP"
+	MethodPlay         = "PLAY"
+	MethodPause        = "PAUSE"
+	MethodRecord       = "RECORD"
+	MethodTeardown     = "TEARDOWN"
+	MethodGetParameter = "GET_PARAMETER"
+	MethodSetParameter = "SET_PARAMETER"
+
+	StatusOK                   = 200
+	StatusBadRequest           = 400
+	StatusUnauthorized         = 401
+	StatusForbidden            = 403
+	StatusNotFound             = 404
+	StatusMethodNotAllowed     = 405
+	StatusSessionNotFound      = 454
+	StatusMethodNotValid       = 455
+	StatusUnsupportedTransport = 461
+	StatusInternalServerError  = 500
+	StatusNotImplemented       = 501
+	StatusServiceUnavailable   = 503
+
+	// 消息头数量和消息体长度上限, 避免恶意请求撑爆内存
+	maxHeaderCount = 128
+	maxBodySize    = 1024 * 1024
+)
+
+var statusText = map[int]string{
+	StatusOK:                   "OK",
+	StatusBadRequest:           "Bad Request",
+	StatusUnauthorized:         "Unauthorized",
+	StatusForbidden:            "Forbidden",
+	StatusNotFound:             "Not Found",
+	StatusMethodNotAllowed:     "Method Not Allowed",
+	StatusSessionNotFound:      "Session Not Found",
+	StatusMethodNotValid:       "Method Not Valid in This State",
+	StatusUnsupportedTransport: "Unsupported Transport",
+	StatusInternalServerError:  "Internal Server Error",
+	StatusNotImplemented:       "Not Implemented",
+	StatusServiceUnavailable:   "Service Unavailable",
+}
+
+func StatusText(code int) string {
+	return statusText[code]
+}
+
+type HeaderField struct {
+	Key   string
+	Value string
+}
+
+// Header 保留消息头的顺序和大小写, 查找时忽略大小写
+type Header []HeaderField
+
+func (h Header) Get(key string) string {
+	for _, field := range h {
+		if strings.EqualFold(field.Key, key) {
+			return field.Value
+		}
+	}
+
+	return ""
+}
+
+// Values 返回所有匹配的值, 例如多个WWW-Authenticate
+func (h Header) Values(key string) []string {
+	var values []string
+	for _, field := range h {
+		if strings.EqualFold(field.Key, key) {
+			values = append(values, field.Value)
+		}
+	}
+
+	return values
+}
+
+// Set 替换第一个匹配的值, 不存在则添加
+func (h *Header) Set(key, value string) {
+	for i, field := range *h {
+		if strings.EqualFold(field.Key, key) {
+			(*h)[i].Value = value
+			return
+		}
+	}
+
+	h.Add(key, value)
+}
+
+func (h *Header) Add(key, value string) {
+	*h = append(*h, HeaderField{key, value})
+}
+
+func (h *Header) Del(key string) {
+	fields := (*h)[:0]
+	for _, field := range *h {
+		if !strings.EqualFold(field.Key, key) {
+			fields = append(fields, field)
+		}
+	}
+
+	*h = fields
+}
+
+func (h Header) write(buffer *bytes.Buffer, body []byte) {
+	for _, field := range h {
+		if strings.EqualFold(field.Key, "Content-Length") {
+			continue
+		}
+
+		buffer.WriteString(field.Key)
+		buffer.WriteString(": ")
+		buffer.WriteString(field.Value)
+		buffer.WriteString("\r\n")
+	}
+
+	if len(body) > 0 {
+		buffer.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
+	}
+
+	buffer.WriteString("\r\n")
+	buffer.Write(body)
+}
+
+type Request struct {
+	Method string
+	URL    string
+	Header Header
+	Body   []byte
+}
+
+// CSeq 返回请求序号
+func (r *Request) CSeq() string {
+	return r.Header.Get("CSeq")
+}
+
+func (r *Request) Marshal() []byte {
+	buffer := bytes.Buffer{}
+	buffer.WriteString(fmt.Sprintf("%s %s %s\r\n", r.Method, r.URL, Version))
+	r.Header.write(&buffer, r.Body)
+	return buffer.Bytes()
+}
+
+func (r *Request) String() string {
+	return string(r.Marshal())
+}
+
+type Response struct {
+	StatusCode int
+	Reason     string
+	Header     Header
+	Body       []byte
+}
+
+func (r *Response) Marshal() []byte {
+	reason := r.Reason
+	if reason == "" {
+		reason = StatusText(r.StatusCode)
+	}
+
+	buffer := bytes.Buffer{}
+	buffer.WriteString(fmt.Sprintf("%s %d %s\r\n", Version, r.StatusCode, reason))
+	r.Header.write(&buffer, r.Body)
+	return buffer.Bytes()
+}
+
+func (r *Response) String() string {
+	return string(r.Marshal())
+}
+
+// NewResponse 创建应答, 拷贝请求的CSeq
+func NewResponse(request *Request, code int) *Response {
+	response := &Response{StatusCode: code}
+	if cseq := request.CSeq(); cseq != "" {
+		response.Header.Set("CSeq", cseq)
+	}
+
+	return response
+}
+
+// 读取起始行, 消息头和消息体
+func readMessage(reader *bufio.Reader) (string, Header, []byte, error) {
+	var line string
+	var err error
+
+	// 跳过消息之间的空行
+	for line == "" {
+		if line, err = reader.ReadString('\n'); err != nil {
+			return "", nil, nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+	}
+
+	var header Header
+	for {
+		field, err := reader.ReadString('\n')
+		if err != nil {
+			return "", nil, nil, err
+		}
+
+		field = strings.TrimRight(field, "\r\n")
+		if field == "" {
+			break
+		} else if len(header) >= maxHeaderCount {
+			return "", nil, nil, fmt.Errorf("too many rtsp headers")
+		}
+
+		i := strings.IndexByte(field, ':')
+		if i <= 0 {
+			return "", nil, nil, fmt.Errorf("invalid rtsp header: %s", field)
+		}
+
+		header.Add(strings.TrimSpace(field[:i]), strings.TrimSpace(field[i+1:]))
+	}
+
+	var body []byte
+	if value := header.Get("Content-Length"); value != "" {
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 0 || length > maxBodySize {
+			return "", nil, nil, fmt.Errorf("invalid content length: %s", value)
+		}
+
+		body = make([]byte, length)
+		if _, err = io.ReadFull(reader, body); err != nil {
+			return "", nil, nil, err
+		}
+	}
+
+	return line, header, body, nil
+}
+
+func ReadRequest(reader *bufio.Reader) (*Request, error) {
+	line, header, body, err := readMessage(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) != 3 || fields[2] != Version {
+		return nil, fmt.Errorf("invalid rtsp request line: %s", line)
+	}
+
+	return &Request{Method: fields[0], URL: fields[1], Header: header, Body: body}, nil
+}
+
+func ReadResponse(reader *bufio.Reader) (*Response, error) {
+	line, header, body, err := readMessage(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) < 2 || fields[0] != Version {
+		return nil, fmt.Errorf("invalid rtsp status line: %s", line)
+	}
+
+	code, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid rtsp status code: %s", fields[1])
+	}
+
+	response := &Response{StatusCode: code, Header: header, Body: body}
+	if len(fields) > 2 {
+		response.Reason = fields[2]
+	}
+
+	return response, nil
+}
+
+// IsInterleavedFrame 下一个消息是否是TCP交织的RTP/RTCP包
+func IsInterleavedFrame(reader *bufio.Reader) (bool, error) {
+	b, err := reader.Peek(1)
+	if err != nil {
+		return false, err
+	}
+
+	return b[0] == '$', nil
+}
+
+// ReadInterleavedFrame 读取$+channel(1)+length(2)+data, 返回的data不会被复用
+func ReadInterleavedFrame(reader *bufio.Reader) (int, []byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, nil, err
+	} else if header[0] != '$' {
+		return 0, nil, fmt.Errorf("invalid interleaved frame")
+	}
+
+	data := make([]byte, binary.BigEndian.Uint16(header[2:]))
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return 0, nil, err
+	}
+
+	return int(header[1]), data, nil
+}
+
+// AppendInterleavedFrame 将TCP交织包追加到dst
+func AppendInterleavedFrame(dst []byte, channel int, data []byte) []byte {
+	dst = append(dst, '$', byte(channel))
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(data)))
+	return append(dst, data...)
+}
@@ -0,0 +1,238 @@
+package rtsp
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SessionTimeout 会话超时时间, 期间没有收到请求或RTCP则断开
+	SessionTimeout = 60 * time.Second
+
+	// DESCRIBE等待track解析完毕的时间
+	describeTimeout = 5 * time.Second
+)
+
+// Server RTSP 1.0服务器, 支持TCP交织和UDP传输的拉流(DESCRIBE/SETUP/PLAY)和推流(ANNOUNCE/SETUP/RECORD)
+type Server struct {
+	mutex      sync.Mutex
+	listener   net.Listener
+	rtpConn    *net.UDPConn
+	rtcpConn   *net.UDPConn
+	rtpPort    int
+	streams    map[string]*Stream
+	udpTargets map[string]*conn // 客户端UDP地址对应的会话
+	conns      map[*conn]struct{}
+	auth       *Authenticator
+	onPublish  func(path string, stream *Stream) bool
+	closed     bool
+}
+
+// SetAuthenticator 设置鉴权, 为nil时不鉴权
+func (s *Server) SetAuthenticator(auth *Authenticator) {
+	s.auth = auth
+}
+
+// SetOnPublishHandler 设置推流回调, 返回false拒绝推流, 未设置时允许所有推流
+func (s *Server) SetOnPublishHandler(onPublish func(path string, stream *Stream) bool) {
+	s.onPublish = onPublish
+}
+
+// AddStream 添加可以播放的流, path不包含开头的/
+func (s *Server) AddStream(path string, stream *Stream) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	path = strings.Trim(path, "/")
+	if _, ok := s.streams[path]; ok {
+		return false
+	}
+
+	s.streams[path] = stream
+	return true
+}
+
+func (s *Server) RemoveStream(path string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.streams, strings.Trim(path, "/"))
+}
+
+func (s *Server) removeStream(path string, stream *Stream) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.streams[path] == stream {
+		delete(s.streams, path)
+	}
+}
+
+func (s *Server) FindStream(path string) *Stream {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.streams[strings.Trim(path, "/")]
+}
+
+// 根据请求URL查找流, 返回流的路径和URL中剩余的control
+func (s *Server) findStreamByURL(rawURL string) (string, *Stream, string) {
+	path := requestPath(rawURL)
+	if stream := s.FindStream(path); stream != nil {
+		return path, stream, ""
+	}
+
+	if i := strings.LastIndexByte(path, '/'); i > 0 {
+		if stream := s.FindStream(path[:i]); stream != nil {
+			return path[:i], stream, path[i+1:]
+		}
+	}
+
+	return path, nil, ""
+}
+
+// ListenUDP 开启UDP传输, port为RTP端口, RTCP使用port+1
+func (s *Server) ListenUDP(port int) error {
+	rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		return err
+	}
+
+	rtcpConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port + 1})
+	if err != nil {
+		rtpConn.Close()
+		return err
+	}
+
+	s.rtpConn, s.rtcpConn, s.rtpPort = rtpConn, rtcpConn, port
+	go s.readUDP(rtpConn, false)
+	go s.readUDP(rtcpConn, true)
+	return nil
+}
+
+func (s *Server) readUDP(udpConn *net.UDPConn, rtcp bool) {
+	buffer := make([]byte, 65535)
+	for {
+		n, addr, err := udpConn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		target := s.udpTargets[addr.String()]
+		s.mutex.Unlock()
+
+		if target != nil {
+			target.onUDPPacket(append([]byte{}, buffer[:n]...), rtcp)
+		}
+	}
+}
+
+func (s *Server) addUDPTarget(addr string, c *conn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.udpTargets[addr] = c
+}
+
+func (s *Server) removeUDPTarget(addr string, c *conn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.udpTargets[addr] == c {
+		delete(s.udpTargets, addr)
+	}
+}
+
+// Start 监听TCP地址并开始接受连接
+func (s *Server) Start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.listener = listener
+	go s.accept()
+	return nil
+}
+
+func (s *Server) accept() {
+	for {
+		netConn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c := newConn(s, netConn)
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			netConn.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.mutex.Unlock()
+
+		go c.run()
+	}
+}
+
+func (s *Server) removeConn(c *conn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.conns, c)
+}
+
+// Addr 返回TCP监听地址
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *Server) Close() {
+	s.mutex.Lock()
+	s.closed = true
+	conns := s.conns
+	s.conns = make(map[*conn]struct{})
+	s.mutex.Unlock()
+
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	if s.rtpConn != nil {
+		s.rtpConn.Close()
+		s.rtcpConn.Close()
+	}
+
+	for c := range conns {
+		c.conn.Close()
+	}
+}
+
+func NewServer() *Server {
+	return &Server{
+		streams:    make(map[string]*Stream),
+		udpTargets: make(map[string]*conn),
+		conns:      make(map[*conn]struct{}),
+	}
+}
+
+// 返回URL的路径, 去掉开头和结尾的/
+func requestPath(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return strings.Trim(u.Path, "/")
+}
+
+// 解析Session头, 去掉timeout参数
+func sessionID(value string) string {
+	id, _, _ := strings.Cut(value, ";")
+	return strings.TrimSpace(id)
+}
+
+func formatSession(id string) string {
+	return id + ";timeout=" + strconv.Itoa(int(SessionTimeout/time.Second))
+}
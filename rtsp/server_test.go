@@ -0,0 +1,396 @@
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/avc"
+	"github.com/lkmio/avformat/rtp"
+	"github.com/lkmio/avformat/sdp"
+	"github.com/lkmio/avformat/utils"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const cameraSDP = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=Media Presentation\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"t=0 0\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=fmtp:96 packetization-mode=1;sprop-parameter-sets=Z0LAHtoB4AifligQAAADABAAAAMDIPFi6g==,aM4PLIA=\r\n" +
+	"a=control:streamid=0\r\n" +
+	"m=audio 0 RTP/AVP 97\r\n" +
+	"a=rtpmap:97 MPEG4-GENERIC/16000/1\r\n" +
+	"a=fmtp:97 streamtype=5;mode=AAC-hbr;config=1408;sizelength=13;indexlength=3;indexdeltalength=3\r\n" +
+	"a=control:streamid=1\r\n"
+
+type testClient struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	cseq       int
+	session    string
+	username   string
+	password   string
+	challenges []string
+}
+
+func (c *testClient) request(method, url string, header Header, body []byte) *Response {
+	for {
+		c.cseq++
+		request := &Request{Method: method, URL: url, Header: append(Header{}, header...), Body: body}
+		request.Header.Set("CSeq", strconv.Itoa(c.cseq))
+		if c.session != "" {
+			request.Header.Set("Session", c.session)
+		}
+		if c.challenges != nil {
+			authorization, err := Authorization(c.challenges, method, url, c.username, c.password)
+			if err != nil {
+				panic(err)
+			}
+			request.Header.Set("Authorization", authorization)
+		}
+
+		if _, err := c.conn.Write(request.Marshal()); err != nil {
+			panic(err)
+		}
+
+		response := c.readResponse()
+		utils.Assert(response.Header.Get("CSeq") == strconv.Itoa(c.cseq))
+		if response.StatusCode == StatusUnauthorized && c.challenges == nil {
+			c.challenges = response.Header.Values("WWW-Authenticate")
+			continue
+		}
+
+		if session := response.Header.Get("Session"); session != "" {
+			c.session = sessionID(session)
+		}
+
+		return response
+	}
+}
+
+func (c *testClient) readResponse() *Response {
+	for {
+		interleaved, err := IsInterleavedFrame(c.reader)
+		if err != nil {
+			panic(err)
+		} else if !interleaved {
+			break
+		} else if _, _, err = ReadInterleavedFrame(c.reader); err != nil {
+			panic(err)
+		}
+	}
+
+	response, err := ReadResponse(c.reader)
+	if err != nil {
+		panic(err)
+	}
+
+	return response
+}
+
+// 读取RTP包, 还原出指定通道的一帧
+func (c *testClient) readFrame(channel int, depacketizer rtp.Depacketizer) []byte {
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		ch, data, err := ReadInterleavedFrame(c.reader)
+		if err != nil {
+			panic(err)
+		} else if ch != channel {
+			continue
+		}
+
+		packet := rtp.Packet{}
+		if err = packet.Unmarshal(data); err != nil {
+			panic(err)
+		}
+
+		var frame []byte
+		if err = depacketizer.Depacketize(&packet, func(data []byte, timestamp uint32) {
+			frame = append([]byte{}, data...)
+		}); err != nil {
+			panic(err)
+		} else if frame != nil {
+			return frame
+		}
+	}
+}
+
+func dial(server *Server) *testClient {
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+
+	return &testClient{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// 测试用的关键帧, 包含sps/pps和超过MTU的IDR
+func testKeyFrame(stream *avformat.AVStream) []byte {
+	frame := append([]byte{}, stream.Data...)
+	frame = append(frame, avc.StartCode4...)
+	frame = append(frame, 0x65)
+	for i := 0; i < 3000; i++ {
+		frame = append(frame, byte(i%250+1))
+	}
+
+	return frame
+}
+
+func testStreams() []*avformat.AVStream {
+	session, err := sdp.Parse([]byte(cameraSDP))
+	if err != nil {
+		panic(err)
+	}
+
+	streams, err := session.Streams()
+	if err != nil {
+		panic(err)
+	}
+
+	return streams
+}
+
+// 启动带鉴权的服务器, 持续输入关键帧和AAC帧, 返回停止函数
+func startTestServer() (*Server, []byte, []byte, func()) {
+	streams := testStreams()
+	keyFrame := testKeyFrame(streams[0])
+	aacFrame := bytes.Repeat([]byte{0x21}, 20)
+
+	stream := NewStream()
+	for _, s := range streams {
+		stream.OnNewTrack(&avformat.SimpleTrack{Stream: s})
+	}
+	stream.OnTrackComplete()
+
+	server := NewServer()
+	server.SetAuthenticator(NewAuthenticator("avformat", "admin", "123456"))
+	utils.Assert(server.AddStream("live/test", stream))
+	if err := server.Start("127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+
+			video := avformat.NewVideoPacket(keyFrame, int64(i*3600), int64(i*3600), true, avformat.PacketTypeAnnexB, utils.AVCodecIdH264, 0, 90000)
+			stream.OnPacket(video)
+			audio := avformat.NewAudioPacket(aacFrame, int64(i*640), utils.AVCodecIdAAC, 1, 16000)
+			stream.OnPacket(audio)
+		}
+	}()
+
+	return server, keyFrame, aacFrame, func() {
+		close(done)
+		server.Close()
+	}
+}
+
+func TestServerPlay(t *testing.T) {
+	server, keyFrame, aacFrame, stop := startTestServer()
+	defer stop()
+
+	client := dial(server)
+	client.username, client.password = "admin", "123456"
+	url := "rtsp://" + server.Addr().String() + "/live/test"
+
+	response := client.request(MethodOptions, url, nil, nil)
+	utils.Assert(response.StatusCode == StatusOK && client.challenges == nil)
+
+	response = client.request(MethodDescribe, url, nil, nil)
+	utils.Assert(response.StatusCode == StatusOK && len(client.challenges) == 2)
+	session, err := sdp.Parse(response.Body)
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(len(session.Medias) == 2)
+
+	base := response.Header.Get("Content-Base")
+	for i, media := range session.Medias {
+		transport := "RTP/AVP/TCP;unicast;interleaved=" + strconv.Itoa(i*2) + "-" + strconv.Itoa(i*2+1)
+		response = client.request(MethodSetup, base+media.Control(), Header{{"Transport", transport}}, nil)
+		utils.Assert(response.StatusCode == StatusOK && client.session != "")
+	}
+
+	response = client.request(MethodPlay, url, nil, nil)
+	utils.Assert(response.StatusCode == StatusOK)
+
+	// 第一帧必须是关键帧
+	frame := client.readFrame(0, &rtp.H264Depacketizer{})
+	utils.Assert(bytes.Equal(frame, keyFrame))
+
+	depacketizer, _ := rtp.NewDepacketizer(utils.AVCodecIdAAC)
+	frame = client.readFrame(2, depacketizer)
+	utils.Assert(bytes.Equal(frame, aacFrame))
+
+	// 错误的密码
+	other := dial(server)
+	other.username, other.password = "admin", "654321"
+	response = other.request(MethodDescribe, url, nil, nil)
+	utils.Assert(response.StatusCode == StatusUnauthorized)
+
+	// 其他连接下发的nonce不能重放
+	replay := dial(server)
+	replay.username, replay.password, replay.challenges = "admin", "123456", client.challenges
+	response = replay.request(MethodDescribe, url, nil, nil)
+	utils.Assert(response.StatusCode == StatusUnauthorized)
+
+	// Digest的uri与请求的URL不一致
+	authorization, err := Authorization(client.challenges, MethodDescribe, url+"/other", "admin", "123456")
+	if err != nil {
+		panic(err)
+	}
+	client.cseq++
+	request := &Request{Method: MethodDescribe, URL: url, Header: Header{{"CSeq", strconv.Itoa(client.cseq)}, {"Authorization", authorization}}}
+	if _, err = client.conn.Write(request.Marshal()); err != nil {
+		panic(err)
+	}
+	utils.Assert(client.readResponse().StatusCode == StatusUnauthorized)
+}
+
+func TestServerRecord(t *testing.T) {
+	keyFrame := testKeyFrame(testStreams()[0])
+
+	server := NewServer()
+	if err := server.Start("127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	defer server.Close()
+
+	url := "rtsp://" + server.Addr().String() + "/live/push"
+	publisher := dial(server)
+	response := publisher.request(MethodAnnounce, url, Header{{"Content-Type", "application/sdp"}}, []byte(cameraSDP))
+	utils.Assert(response.StatusCode == StatusOK)
+
+	// 重复推流
+	response = dial(server).request(MethodAnnounce, url, Header{{"Content-Type", "application/sdp"}}, []byte(cameraSDP))
+	utils.Assert(response.StatusCode == StatusForbidden)
+
+	for i := 0; i < 2; i++ {
+		transport := "RTP/AVP/TCP;unicast;mode=record;interleaved=" + strconv.Itoa(i*2) + "-" + strconv.Itoa(i*2+1)
+		response = publisher.request(MethodSetup, url+"/streamid="+strconv.Itoa(i), Header{{"Transport", transport}}, nil)
+		utils.Assert(response.StatusCode == StatusOK)
+	}
+
+	response = publisher.request(MethodRecord, url, nil, nil)
+	utils.Assert(response.StatusCode == StatusOK)
+
+	player := dial(server)
+	response = player.request(MethodDescribe, url, nil, nil)
+	utils.Assert(response.StatusCode == StatusOK)
+	response = player.request(MethodSetup, url+"/trackID=0", Header{{"Transport", "RTP/AVP/TCP;unicast;interleaved=0-1"}}, nil)
+	utils.Assert(response.StatusCode == StatusOK)
+	response = player.request(MethodPlay, url, nil, nil)
+	utils.Assert(response.StatusCode == StatusOK)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		packetizer, _ := rtp.NewPacketizer(utils.AVCodecIdH264, 96, 0x1234, 100, rtp.DefaultMTU)
+		var buffer []byte
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+
+			err := packetizer.Packetize(keyFrame, uint32(i*3600), func(packet []byte) {
+				buffer = AppendInterleavedFrame(buffer[:0], 0, packet)
+				_, _ = publisher.conn.Write(buffer)
+			})
+
+			if err != nil {
+				panic(err)
+			}
+		}
+	}()
+
+	frame := player.readFrame(0, &rtp.H264Depacketizer{})
+	utils.Assert(bytes.Equal(frame, keyFrame))
+
+	// 推流断开后, 流被删除
+	publisher.conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	utils.Assert(server.FindStream("live/push") == nil)
+}
+
+func TestServerRecordUDP(t *testing.T) {
+	server := NewServer()
+	if err := server.Start("127.0.0.1:0"); err != nil {
+		panic(err)
+	}
+	defer server.Close()
+
+	var err error
+	for i := 0; i < 32; i++ {
+		if err = server.ListenUDP(utils.RandomIntInRange(10000, 60000) &^ 1); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	rtpConn, rtcpConn, err := listenUDPPair()
+	if err != nil {
+		panic(err)
+	}
+	defer rtpConn.Close()
+	defer rtcpConn.Close()
+
+	url := "rtsp://" + server.Addr().String() + "/live/push"
+	publisher := dial(server)
+	response := publisher.request(MethodAnnounce, url, Header{{"Content-Type", "application/sdp"}}, []byte(cameraSDP))
+	utils.Assert(response.StatusCode == StatusOK)
+
+	port := rtpConn.LocalAddr().(*net.UDPAddr).Port
+	transport := "RTP/AVP;unicast;mode=record;client_port=" + strconv.Itoa(port) + "-" + strconv.Itoa(port+1)
+	response = publisher.request(MethodSetup, url+"/streamid=0", Header{{"Transport", transport}}, nil)
+	utils.Assert(response.StatusCode == StatusOK)
+	response = publisher.request(MethodRecord, url, nil, nil)
+	utils.Assert(response.StatusCode == StatusOK)
+
+	// 丢失序号1, 之后没有新的包
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: server.rtpPort}
+	for _, seq := range []uint16{0, 2, 3, 4} {
+		packet := &rtp.Packet{Header: rtp.Header{Version: rtp.Version, Marker: true, PayloadType: 96, SequenceNumber: seq, Timestamp: uint32(seq) * 3600, SSRC: 1}, Payload: []byte{0x65, 0x88, 0x84, 0x00}}
+		data, _ := packet.Marshal()
+		_, _ = rtpConn.WriteToUDP(data, addr)
+	}
+
+	// 请求重传丢失的包, 定时放弃空洞后请求关键帧
+	var nack, pli bool
+	buffer := make([]byte, 1500)
+	_ = rtcpConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for !nack || !pli {
+		n, _, err := rtcpConn.ReadFromUDP(buffer)
+		if err != nil {
+			panic(err)
+		}
+
+		packets, err := rtp.UnmarshalRTCP(buffer[:n])
+		utils.Assert(err == nil)
+		for _, packet := range packets {
+			switch packet := packet.(type) {
+			case *rtp.TransportLayerNack:
+				seqs := packet.LostSequences()
+				utils.Assert(!nack && packet.MediaSSRC == 1 && len(seqs) == 1 && seqs[0] == 1)
+				nack = true
+			case *rtp.PictureLossIndication:
+				utils.Assert(packet.MediaSSRC == 1)
+				pli = true
+			}
+		}
+	}
+}
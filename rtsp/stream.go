@@ -0,0 +1,278 @@
+package rtsp
+
+import (
+	"fmt"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/rtp"
+	"github.com/lkmio/avformat/sdp"
+	"github.com/lkmio/avformat/utils"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// ReportInterval 发送SR的间隔
+	ReportInterval = 5 * time.Second
+
+	// 每个会话缓存的RTP包数量, 超过后丢包并等待下一个关键帧
+	subscriberQueueSize = 1024
+)
+
+// 发送给会话的RTP/RTCP包, data被所有会话共享, 不能修改
+type outPacket struct {
+	track int
+	rtcp  bool
+	data  []byte
+}
+
+type subscriber struct {
+	packets chan outPacket
+	waitKey bool // 等待关键帧后再发送
+}
+
+type streamTrack struct {
+	stream     *avformat.AVStream
+	control    string
+	clockRate  int
+	packetizer rtp.Packetizer
+	sender     *rtp.SourceSender
+}
+
+// Stream 实现OnUnpackStreamHandler, 将任意Demuxer输出的AVPacket打包成RTP, 分发给所有播放会话.
+// 每个track只打包一次, 所有会话共享SSRC和序号.
+type Stream struct {
+	mutex       sync.Mutex
+	tracks      avformat.TrackManager
+	mediaTracks []*streamTrack
+	session     *sdp.Session
+	hasVideo    bool
+	completed   bool
+	closed      bool
+	ready       chan struct{}
+	subscribers map[*subscriber]struct{}
+	lastReport  time.Time
+}
+
+func (s *Stream) OnNewTrack(track avformat.Track) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.completed {
+		s.tracks.Add(track)
+	}
+}
+
+func (s *Stream) OnTrackComplete() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.completed || s.closed {
+		return
+	}
+
+	defer close(s.ready)
+	session, err := sdp.NewSession(&s.tracks, "0.0.0.0")
+	if err != nil {
+		println(err.Error())
+		s.closed = true
+		return
+	}
+
+	for i, track := range s.tracks.Tracks {
+		stream := track.GetStream()
+		payloadType, _ := strconv.Atoi(session.Medias[i].Formats[0])
+		packetizer, err := rtp.NewPacketizer(stream.CodecID, byte(payloadType), rand.Uint32(), uint16(rand.Intn(0xFFFF)), rtp.DefaultMTU)
+		if err != nil {
+			println(err.Error())
+			s.closed = true
+			return
+		}
+
+		clockRate := sdp.ClockRate(stream)
+		s.mediaTracks = append(s.mediaTracks, &streamTrack{
+			stream:     stream,
+			control:    session.Medias[i].Control(),
+			clockRate:  clockRate,
+			packetizer: packetizer,
+			sender:     rtp.NewSourceSender(packetizer.SSRC(), clockRate, "avformat"),
+		})
+
+		if utils.AVMediaTypeVideo == stream.MediaType {
+			s.hasVideo = true
+		}
+	}
+
+	s.session = session
+	s.completed = true
+}
+
+func (s *Stream) OnTrackNotFind() {
+	s.Close()
+}
+
+func (s *Stream) OnPacket(packet *avformat.AVPacket) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.completed || s.closed || packet.Index >= len(s.mediaTracks) {
+		return
+	}
+
+	track := s.mediaTracks[packet.Index]
+	data := packet.Data
+	key := false
+	if utils.AVMediaTypeVideo == packet.MediaType {
+		data = avformat.AVCCPacket2AnnexB(track.stream, packet)
+		key = packet.Key
+	}
+
+	now := time.Now()
+	timestamp := uint32(avformat.ConvertTs(packet.Pts, packet.Timebase, track.clockRate))
+	err := track.packetizer.Packetize(data, timestamp, func(bytes []byte) {
+		var rtpPacket rtp.Packet
+		if err := rtpPacket.Unmarshal(bytes); err == nil {
+			track.sender.OnPacket(&rtpPacket, now)
+		}
+
+		// 只有关键帧的第一个包可以作为会话的起点
+		s.broadcast(outPacket{track: packet.Index, data: append([]byte{}, bytes...)}, key)
+		key = false
+	})
+
+	if err != nil {
+		println(err.Error())
+	}
+
+	if now.Sub(s.lastReport) >= ReportInterval {
+		s.lastReport = now
+		s.sendReports(now)
+	}
+}
+
+func (s *Stream) sendReports(now time.Time) {
+	for i, track := range s.mediaTracks {
+		if !track.sender.Started() {
+			continue
+		}
+
+		report, err := track.sender.Report(now)
+		if err != nil {
+			println(err.Error())
+			continue
+		}
+
+		s.broadcast(outPacket{track: i, rtcp: true, data: report}, false)
+	}
+}
+
+func (s *Stream) broadcast(packet outPacket, key bool) {
+	for sub := range s.subscribers {
+		if sub.waitKey {
+			if !key || packet.rtcp {
+				continue
+			}
+
+			sub.waitKey = false
+		}
+
+		select {
+		case sub.packets <- packet:
+		default:
+			// 会话发送太慢, 丢弃到下一个关键帧
+			sub.waitKey = s.hasVideo
+		}
+	}
+}
+
+// subscribe 添加播放会话, 有视频时从下一个关键帧开始发送
+func (s *Stream) subscribe() (*subscriber, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, fmt.Errorf("stream closed")
+	}
+
+	sub := &subscriber{packets: make(chan outPacket, subscriberQueueSize), waitKey: s.hasVideo}
+	s.subscribers[sub] = struct{}{}
+	return sub, nil
+}
+
+func (s *Stream) unsubscribe(sub *subscriber) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.subscribers[sub]; ok {
+		delete(s.subscribers, sub)
+		close(sub.packets)
+	}
+}
+
+// WaitTracks 等待track解析完毕, 返回是否可以播放
+func (s *Stream) WaitTracks(timeout time.Duration) bool {
+	select {
+	case <-s.ready:
+	case <-time.After(timeout):
+		return false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.completed && !s.closed
+}
+
+// SDP 返回会话描述, track解析完毕前为nil
+func (s *Stream) SDP() *sdp.Session {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.session
+}
+
+// 根据SETUP的control查找track
+func (s *Stream) findTrack(control string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, track := range s.mediaTracks {
+		if track.control == control {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (s *Stream) trackCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.mediaTracks)
+}
+
+// Close 关闭流, 断开所有播放会话
+func (s *Stream) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	if !s.completed {
+		close(s.ready)
+	}
+
+	for sub := range s.subscribers {
+		close(sub.packets)
+	}
+	s.subscribers = make(map[*subscriber]struct{})
+}
+
+func NewStream() *Stream {
+	return &Stream{
+		ready:       make(chan struct{}),
+		subscribers: make(map[*subscriber]struct{}),
+	}
+}
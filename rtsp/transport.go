@@ -0,0 +1,126 @@
+package rtsp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Transport SETUP请求和应答的Transport头, 只解析第一个传输方式
+type Transport struct {
+	Protocol    string // RTP/AVP, RTP/AVP/UDP, RTP/AVP/TCP
+	Multicast   bool
+	Destination string
+	Source      string
+	Interleaved []int // TCP交织通道, RTP和RTCP
+	ClientPort  []int
+	ServerPort  []int
+	SSRC        string
+	Mode        string // PLAY/RECORD
+}
+
+// IsTCP 是否是TCP交织传输
+func (t *Transport) IsTCP() bool {
+	return strings.HasSuffix(strings.ToUpper(t.Protocol), "/TCP")
+}
+
+func (t *Transport) String() string {
+	fields := []string{t.Protocol}
+	if t.Multicast {
+		fields = append(fields, "multicast")
+	} else {
+		fields = append(fields, "unicast")
+	}
+
+	if t.Destination != "" {
+		fields = append(fields, "destination="+t.Destination)
+	}
+	if t.Source != "" {
+		fields = append(fields, "source="+t.Source)
+	}
+	if len(t.Interleaved) > 0 {
+		fields = append(fields, "interleaved="+formatRange(t.Interleaved))
+	}
+	if len(t.ClientPort) > 0 {
+		fields = append(fields, "client_port="+formatRange(t.ClientPort))
+	}
+	if len(t.ServerPort) > 0 {
+		fields = append(fields, "server_port="+formatRange(t.ServerPort))
+	}
+	if t.SSRC != "" {
+		fields = append(fields, "ssrc="+t.SSRC)
+	}
+	if t.Mode != "" {
+		fields = append(fields, "mode="+t.Mode)
+	}
+
+	return strings.Join(fields, ";")
+}
+
+func ParseTransport(value string) (*Transport, error) {
+	// 多个传输方式以逗号分隔, 按优先级排列
+	if i := strings.IndexByte(value, ','); i > 0 {
+		value = value[:i]
+	}
+
+	fields := strings.Split(value, ";")
+	transport := &Transport{Protocol: strings.TrimSpace(fields[0])}
+	if !strings.HasPrefix(strings.ToUpper(transport.Protocol), "RTP/AVP") {
+		return nil, fmt.Errorf("unsupported transport: %s", value)
+	}
+
+	var err error
+	for _, field := range fields[1:] {
+		key, param, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch strings.ToLower(key) {
+		case "multicast":
+			transport.Multicast = true
+		case "destination":
+			transport.Destination = param
+		case "source":
+			transport.Source = param
+		case "interleaved":
+			transport.Interleaved, err = parseRange(param)
+		case "client_port":
+			transport.ClientPort, err = parseRange(param)
+		case "server_port":
+			transport.ServerPort, err = parseRange(param)
+		case "ssrc":
+			transport.SSRC = param
+		case "mode":
+			transport.Mode = strings.ToUpper(strings.Trim(param, "\""))
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid transport: %s", value)
+		}
+	}
+
+	return transport, nil
+}
+
+// 解析a-b或a, 只有一个值时b=a+1
+func parseRange(value string) ([]int, error) {
+	first, second, ok := strings.Cut(value, "-")
+	a, err := strconv.Atoi(first)
+	if err != nil {
+		return nil, err
+	}
+
+	b := a + 1
+	if ok {
+		if b, err = strconv.Atoi(second); err != nil {
+			return nil, err
+		}
+	}
+
+	return []int{a, b}, nil
+}
+
+func formatRange(values []int) string {
+	if len(values) == 1 {
+		return strconv.Itoa(values[0])
+	}
+
+	return strconv.Itoa(values[0]) + "-" + strconv.Itoa(values[1])
+}
@@ -0,0 +1,349 @@
+package sdp
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Origin o=<username> <sess-id> <sess-version> <nettype> <addrtype> <unicast-address>
+type Origin struct {
+	Username       string
+	SessionID      string
+	SessionVersion string
+	NetType        string
+	AddrType       string
+	Address        string
+}
+
+func (o *Origin) String() string {
+	return fmt.Sprintf("%s %s %s %s %s %s", o.Username, o.SessionID, o.SessionVersion, o.NetType, o.AddrType, o.Address)
+}
+
+type Attribute struct {
+	Key   string
+	Value string // 属性标记(a=recvonly)没有值
+}
+
+// Line 未识别的行, 例如GB28181的y=和f=
+type Line struct {
+	Type  byte
+	Value string
+}
+
+type attributes []Attribute
+
+// Attribute 返回第一个匹配的属性值
+func (a attributes) Attribute(key string) (string, bool) {
+	for _, attr := range a {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// Attributes 返回所有匹配的属性值
+func (a attributes) Attributes(key string) []string {
+	var values []string
+	for _, attr := range a {
+		if attr.Key == key {
+			values = append(values, attr.Value)
+		}
+	}
+
+	return values
+}
+
+type Media struct {
+	Type       string // video/audio/application
+	Port       int
+	PortCount  int
+	Proto      string // RTP/AVP, TCP/RTP/AVP...
+	Formats    []string
+	Info       string
+	Connection string
+	Bandwidths []string
+	Attributes attributes
+	Lines      []Line
+}
+
+// Attribute 返回第一个匹配的属性值
+func (m *Media) Attribute(key string) (string, bool) {
+	return m.Attributes.Attribute(key)
+}
+
+// AddAttribute 添加属性, 值为空时为属性标记
+func (m *Media) AddAttribute(key, value string) {
+	m.Attributes = append(m.Attributes, Attribute{Key: key, Value: value})
+}
+
+// Control 返回a=control
+func (m *Media) Control() string {
+	control, _ := m.Attribute("control")
+	return control
+}
+
+// Direction 返回sendrecv/sendonly/recvonly/inactive, 默认sendrecv
+func (m *Media) Direction() string {
+	for _, attr := range m.Attributes {
+		switch attr.Key {
+		case "sendrecv", "sendonly", "recvonly", "inactive":
+			return attr.Key
+		}
+	}
+
+	return "sendrecv"
+}
+
+// Value 返回第一个指定类型的未识别行
+func (m *Media) Value(type_ byte) (string, bool) {
+	return findLine(m.Lines, type_)
+}
+
+type Session struct {
+	Version    int
+	Origin     Origin
+	Name       string
+	Info       string
+	URI        string
+	Connection string
+	Bandwidths []string
+	Timing     string
+	Attributes attributes
+	Lines      []Line
+	Medias     []*Media
+}
+
+// Attribute 返回第一个匹配的会话级属性值
+func (s *Session) Attribute(key string) (string, bool) {
+	return s.Attributes.Attribute(key)
+}
+
+// AddAttribute 添加会话级属性
+func (s *Session) AddAttribute(key, value string) {
+	s.Attributes = append(s.Attributes, Attribute{Key: key, Value: value})
+}
+
+// Value 返回第一个指定类型的未识别行, 先查找会话级再查找媒体级
+func (s *Session) Value(type_ byte) (string, bool) {
+	if value, ok := findLine(s.Lines, type_); ok {
+		return value, ok
+	}
+
+	for _, media := range s.Medias {
+		if value, ok := media.Value(type_); ok {
+			return value, ok
+		}
+	}
+
+	return "", false
+}
+
+// FindMedia 返回第一个指定类型的媒体描述
+func (s *Session) FindMedia(type_ string) *Media {
+	for _, media := range s.Medias {
+		if media.Type == type_ {
+			return media
+		}
+	}
+
+	return nil
+}
+
+func (s *Session) Marshal() []byte {
+	buffer := bytes.Buffer{}
+	writeLine := func(type_ byte, value string) {
+		buffer.WriteByte(type_)
+		buffer.WriteByte('=')
+		buffer.WriteString(value)
+		buffer.WriteString("\r\n")
+	}
+
+	writeAttributes := func(attrs attributes) {
+		for _, attr := range attrs {
+			if attr.Value == "" {
+				writeLine('a', attr.Key)
+			} else {
+				writeLine('a', attr.Key+":"+attr.Value)
+			}
+		}
+	}
+
+	name := s.Name
+	if name == "" {
+		name = "-"
+	}
+	timing := s.Timing
+	if timing == "" {
+		timing = "0 0"
+	}
+
+	writeLine('v', strconv.Itoa(s.Version))
+	writeLine('o', s.Origin.String())
+	writeLine('s', name)
+	if s.Info != "" {
+		writeLine('i', s.Info)
+	}
+	if s.URI != "" {
+		writeLine('u', s.URI)
+	}
+	if s.Connection != "" {
+		writeLine('c', s.Connection)
+	}
+	for _, bandwidth := range s.Bandwidths {
+		writeLine('b', bandwidth)
+	}
+	writeLine('t', timing)
+	writeAttributes(s.Attributes)
+	for _, line := range s.Lines {
+		writeLine(line.Type, line.Value)
+	}
+
+	for _, media := range s.Medias {
+		port := strconv.Itoa(media.Port)
+		if media.PortCount > 1 {
+			port += "/" + strconv.Itoa(media.PortCount)
+		}
+
+		writeLine('m', fmt.Sprintf("%s %s %s %s", media.Type, port, media.Proto, strings.Join(media.Formats, " ")))
+		if media.Info != "" {
+			writeLine('i', media.Info)
+		}
+		if media.Connection != "" {
+			writeLine('c', media.Connection)
+		}
+		for _, bandwidth := range media.Bandwidths {
+			writeLine('b', bandwidth)
+		}
+		writeAttributes(media.Attributes)
+		for _, line := range media.Lines {
+			writeLine(line.Type, line.Value)
+		}
+	}
+
+	return buffer.Bytes()
+}
+
+func (s *Session) String() string {
+	return string(s.Marshal())
+}
+
+// Parse 解析SDP, 兼容\n换行
+func Parse(data []byte) (*Session, error) {
+	session := &Session{}
+	var media *Media
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		} else if len(line) < 2 || line[1] != '=' {
+			return nil, fmt.Errorf("invalid sdp line: %s", line)
+		}
+
+		type_, value := line[0], strings.TrimSpace(line[2:])
+		switch type_ {
+		case 'v':
+			version, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sdp version: %s", value)
+			}
+			session.Version = version
+		case 'o':
+			fields := strings.Fields(value)
+			if len(fields) != 6 {
+				return nil, fmt.Errorf("invalid sdp origin: %s", value)
+			}
+			session.Origin = Origin{fields[0], fields[1], fields[2], fields[3], fields[4], fields[5]}
+		case 's':
+			session.Name = value
+		case 'u':
+			session.URI = value
+		case 't':
+			session.Timing = value
+		case 'm':
+			var err error
+			if media, err = parseMedia(value); err != nil {
+				return nil, err
+			}
+			session.Medias = append(session.Medias, media)
+		case 'i':
+			if media != nil {
+				media.Info = value
+			} else {
+				session.Info = value
+			}
+		case 'c':
+			if media != nil {
+				media.Connection = value
+			} else {
+				session.Connection = value
+			}
+		case 'b':
+			if media != nil {
+				media.Bandwidths = append(media.Bandwidths, value)
+			} else {
+				session.Bandwidths = append(session.Bandwidths, value)
+			}
+		case 'a':
+			attr := Attribute{Key: value}
+			if i := strings.IndexByte(value, ':'); i > 0 {
+				attr.Key, attr.Value = value[:i], value[i+1:]
+			}
+
+			if media != nil {
+				media.Attributes = append(media.Attributes, attr)
+			} else {
+				session.Attributes = append(session.Attributes, attr)
+			}
+		default:
+			if media != nil {
+				media.Lines = append(media.Lines, Line{type_, value})
+			} else {
+				session.Lines = append(session.Lines, Line{type_, value})
+			}
+		}
+	}
+
+	return session, nil
+}
+
+// m=<media> <port>/<number of ports> <proto> <fmt> ...
+func parseMedia(value string) (*Media, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("invalid sdp media: %s", value)
+	}
+
+	media := &Media{Type: fields[0], Proto: fields[2], Formats: fields[3:]}
+	port := fields[1]
+	if i := strings.IndexByte(port, '/'); i > 0 {
+		count, err := strconv.Atoi(port[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid sdp media port: %s", port)
+		}
+
+		media.PortCount = count
+		port = port[:i]
+	}
+
+	var err error
+	if media.Port, err = strconv.Atoi(port); err != nil {
+		return nil, fmt.Errorf("invalid sdp media port: %s", port)
+	}
+
+	return media, nil
+}
+
+func findLine(lines []Line, type_ byte) (string, bool) {
+	for _, line := range lines {
+		if line.Type == type_ {
+			return line.Value, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,138 @@
+package sdp
+
+import (
+	"encoding/hex"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+const cameraSDP = "v=0\r\n" +
+	"o=- 1109162014219182 1 IN IP4 192.168.1.64\r\n" +
+	"s=Media Presentation\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"t=0 0\r\n" +
+	"a=control:*\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=fmtp:96 profile-level-id=42C01E;packetization-mode=1;sprop-parameter-sets=Z0LAHtoB4AifligQAAADABAAAAMDIPFi6g==,aM4PLIA=\r\n" +
+	"a=control:trackID=1\r\n" +
+	"m=audio 0 RTP/AVP 97\r\n" +
+	"a=rtpmap:97 MPEG4-GENERIC/16000/1\r\n" +
+	"a=fmtp:97 streamtype=5;profile-level-id=15;mode=AAC-hbr;config=1408;sizeLength=13;indexLength=3;indexDeltaLength=3\r\n" +
+	"a=control:trackID=2\r\n"
+
+const gbSDP = "v=0\n" +
+	"o=34020000001320000001 0 0 IN IP4 192.168.1.10\n" +
+	"s=Play\n" +
+	"c=IN IP4 192.168.1.10\n" +
+	"t=0 0\n" +
+	"m=video 6000 RTP/AVP 96 98\n" +
+	"a=recvonly\n" +
+	"a=rtpmap:96 PS/90000\n" +
+	"a=rtpmap:98 H264/90000\n" +
+	"y=0100000001\n"
+
+func TestParseCameraSDP(t *testing.T) {
+	session, err := Parse([]byte(cameraSDP))
+	if err != nil {
+		panic(err)
+	}
+
+	streams, err := session.Streams()
+	if err != nil {
+		panic(err)
+	}
+
+	utils.Assert(len(streams) == 2)
+	video, audio := streams[0], streams[1]
+	utils.Assert(utils.AVCodecIdH264 == video.CodecID && video.Timebase == 90000)
+	utils.Assert(video.CodecParameters.Width() == 1920 && video.CodecParameters.Height() == 1080)
+	utils.Assert(session.Medias[0].Control() == "trackID=1")
+
+	utils.Assert(utils.AVCodecIdAAC == audio.CodecID && audio.Timebase == 16000)
+	utils.Assert(audio.SampleRate == 16000 && audio.Channels == 1 && hex.EncodeToString(audio.Data) == "1408")
+	utils.Assert(session.Medias[1].Fmtp(97)["sizelength"] == "13")
+}
+
+func TestParseGB28181SDP(t *testing.T) {
+	session, err := Parse([]byte(gbSDP))
+	if err != nil {
+		panic(err)
+	}
+
+	media := session.FindMedia("video")
+	utils.Assert(media.Port == 6000 && media.Direction() == "recvonly")
+	rtpMap, _ := media.RTPMap(96)
+	utils.Assert(rtpMap.EncodingName == "PS" && rtpMap.ClockRate == 90000)
+
+	ssrc, _ := session.Value('y')
+	utils.Assert(ssrc == "0100000001")
+
+	// 重新解析生成的SDP
+	session, err = Parse(session.Marshal())
+	if err != nil {
+		panic(err)
+	}
+	ssrc, _ = session.Value('y')
+	utils.Assert(ssrc == "0100000001" && len(session.FindMedia("video").Formats) == 2)
+}
+
+func TestNewSession(t *testing.T) {
+	source, _ := Parse([]byte(cameraSDP))
+	streams, _ := source.Streams()
+
+	tracks := avformat.TrackManager{}
+	for _, stream := range streams {
+		tracks.Add(&avformat.SimpleTrack{Stream: stream})
+	}
+
+	session, err := NewSession(&tracks, "127.0.0.1")
+	if err != nil {
+		panic(err)
+	}
+
+	println(session.String())
+
+	parsed, err := Parse(session.Marshal())
+	if err != nil {
+		panic(err)
+	}
+
+	result, err := parsed.Streams()
+	if err != nil {
+		panic(err)
+	}
+
+	utils.Assert(len(result) == 2)
+	utils.Assert(hex.EncodeToString(result[0].Data) == hex.EncodeToString(streams[0].Data))
+	utils.Assert(hex.EncodeToString(result[1].Data) == "1408" && result[1].SampleRate == 16000)
+	utils.Assert(parsed.Medias[0].Control() == "trackID=0")
+}
+
+// 静态负载类型的时钟频率不一定是采样率
+func TestStaticPayloadSampleRate(t *testing.T) {
+	session, err := Parse([]byte("v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 0 RTP/AVP 9\r\n" +
+		"m=audio 0 RTP/AVP 14\r\n" +
+		"m=audio 0 RTP/AVP 8\r\n" +
+		"m=audio 0 RTP/AVP 111\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\n"))
+	if err != nil {
+		panic(err)
+	}
+
+	streams, err := session.Streams()
+	if err != nil {
+		panic(err)
+	}
+
+	utils.Assert(len(streams) == 4)
+	utils.Assert(utils.AVCodecIdADPCMG722 == streams[0].CodecID && streams[0].Timebase == 8000 && streams[0].SampleRate == 16000)
+	utils.Assert(utils.AVCodecIdMP3 == streams[1].CodecID && streams[1].Timebase == 90000 && streams[1].SampleRate == 0)
+	utils.Assert(utils.AVCodecIdPCMALAW == streams[2].CodecID && streams[2].SampleRate == 8000 && streams[2].Channels == 1)
+	utils.Assert(utils.AVCodecIdOPUS == streams[3].CodecID && streams[3].SampleRate == 48000 && streams[3].Channels == 2)
+}
@@ -0,0 +1,503 @@
+package sdp
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/avc"
+	"github.com/lkmio/avformat/utils"
+	"strconv"
+	"strings"
+)
+
+// RTPMap a=rtpmap:<payload type> <encoding name>/<clock rate>[/<encoding parameters>]
+type RTPMap struct {
+	PayloadType  int
+	EncodingName string
+	ClockRate    int
+	Channels     int
+}
+
+func (r RTPMap) String() string {
+	value := fmt.Sprintf("%d %s/%d", r.PayloadType, r.EncodingName, r.ClockRate)
+	if r.Channels > 0 {
+		value += "/" + strconv.Itoa(r.Channels)
+	}
+
+	return value
+}
+
+var (
+	// RFC 3551 静态负载类型
+	staticRTPMaps = map[int]RTPMap{
+		0:  {0, "PCMU", 8000, 1},
+		8:  {8, "PCMA", 8000, 1},
+		9:  {9, "G722", 8000, 1},
+		14: {14, "MPA", 90000, 0},
+		26: {26, "JPEG", 90000, 0},
+		32: {32, "MPV", 90000, 0},
+		33: {33, "MP2T", 90000, 0},
+		34: {34, "H263", 90000, 0},
+	}
+
+	encodingNames = map[string]utils.AVCodecID{
+		"H264":          utils.AVCodecIdH264,
+		"H265":          utils.AVCodecIdH265,
+		"HEVC":          utils.AVCodecIdH265,
+		"H266":          utils.AVCodecIdH266,
+		"VP8":           utils.AVCodecIdVP8,
+		"VP9":           utils.AVCodecIdVP9,
+		"AV1":           utils.AVCodecIdAV1,
+		"H263":          utils.AVCodecIdH263,
+		"H263-1998":     utils.AVCodecIdH263,
+		"JPEG":          utils.AVCodecIdMJPEG,
+		"MPEG4-GENERIC": utils.AVCodecIdAAC,
+		"PCMA":          utils.AVCodecIdPCMALAW,
+		"PCMU":          utils.AVCodecIdPCMMULAW,
+		"G722":          utils.AVCodecIdADPCMG722,
+		"OPUS":          utils.AVCodecIdOPUS,
+		"MPA":           utils.AVCodecIdMP3,
+		"AC3":           utils.AVCodecIdAC3,
+		"EAC3":          utils.AVCodecIdEAC3,
+		"MP2T":          utils.AVCodecIdMPEG2TS,
+	}
+)
+
+// CodecID 根据rtpmap的编码名称返回编码器ID, PS等复用格式返回AVCodecIdNONE
+func CodecID(encodingName string) utils.AVCodecID {
+	if id, ok := encodingNames[strings.ToUpper(encodingName)]; ok {
+		return id
+	}
+
+	return utils.AVCodecIdNONE
+}
+
+// EncodingName 返回编码器在rtpmap中的名称
+func EncodingName(id utils.AVCodecID) string {
+	switch id {
+	case utils.AVCodecIdH264:
+		return "H264"
+	case utils.AVCodecIdH265:
+		return "H265"
+	case utils.AVCodecIdH266:
+		return "H266"
+	case utils.AVCodecIdVP8:
+		return "VP8"
+	case utils.AVCodecIdVP9:
+		return "VP9"
+	case utils.AVCodecIdAV1:
+		return "AV1"
+	case utils.AVCodecIdAAC:
+		return "MPEG4-GENERIC"
+	case utils.AVCodecIdPCMALAW:
+		return "PCMA"
+	case utils.AVCodecIdPCMMULAW:
+		return "PCMU"
+	case utils.AVCodecIdADPCMG722:
+		return "G722"
+	case utils.AVCodecIdOPUS:
+		return "opus"
+	case utils.AVCodecIdMP3:
+		return "MPA"
+	case utils.AVCodecIdAC3:
+		return "AC3"
+	case utils.AVCodecIdEAC3:
+		return "EAC3"
+	default:
+		return ""
+	}
+}
+
+// PayloadType 返回第一个负载类型
+func (m *Media) PayloadType() int {
+	if len(m.Formats) == 0 {
+		return -1
+	}
+
+	pt, err := strconv.Atoi(m.Formats[0])
+	if err != nil {
+		return -1
+	}
+
+	return pt
+}
+
+// RTPMap 返回负载类型的rtpmap, 没有声明时查找静态负载类型
+func (m *Media) RTPMap(payloadType int) (RTPMap, bool) {
+	for _, value := range m.Attributes.Attributes("rtpmap") {
+		fields := strings.Fields(value)
+		if len(fields) != 2 {
+			continue
+		} else if pt, err := strconv.Atoi(fields[0]); err != nil || pt != payloadType {
+			continue
+		}
+
+		params := strings.Split(fields[1], "/")
+		rtpMap := RTPMap{PayloadType: payloadType, EncodingName: params[0]}
+		if len(params) > 1 {
+			rtpMap.ClockRate, _ = strconv.Atoi(params[1])
+		}
+		if len(params) > 2 {
+			rtpMap.Channels, _ = strconv.Atoi(params[2])
+		}
+
+		return rtpMap, true
+	}
+
+	rtpMap, ok := staticRTPMaps[payloadType]
+	return rtpMap, ok
+}
+
+// Fmtp 返回负载类型的fmtp参数, 参数名转为小写
+func (m *Media) Fmtp(payloadType int) map[string]string {
+	prefix := strconv.Itoa(payloadType) + " "
+	for _, value := range m.Attributes.Attributes("fmtp") {
+		if !strings.HasPrefix(value, prefix) {
+			continue
+		}
+
+		params := make(map[string]string)
+		for _, param := range strings.Split(value[len(prefix):], ";") {
+			param = strings.TrimSpace(param)
+			if param == "" {
+				continue
+			}
+
+			if i := strings.IndexByte(param, '='); i > 0 {
+				params[strings.ToLower(param[:i])] = param[i+1:]
+			} else {
+				params[strings.ToLower(param)] = ""
+			}
+		}
+
+		return params
+	}
+
+	return nil
+}
+
+// Stream 根据第一个负载类型创建AVStream, Timebase为RTP时钟频率
+func (m *Media) Stream(index int) (*avformat.AVStream, error) {
+	pt := m.PayloadType()
+	rtpMap, ok := m.RTPMap(pt)
+	if !ok {
+		return nil, fmt.Errorf("not find rtpmap for payload type %d", pt)
+	}
+
+	id := CodecID(rtpMap.EncodingName)
+	if utils.AVCodecIdNONE == id {
+		return nil, fmt.Errorf("unsupported encoding name %s", rtpMap.EncodingName)
+	}
+
+	fmtp := m.Fmtp(pt)
+	stream := &avformat.AVStream{Index: index, CodecID: id, Timebase: rtpMap.ClockRate}
+	switch m.Type {
+	case "video":
+		stream.MediaType = utils.AVMediaTypeVideo
+		if err := parseVideoParameters(stream, fmtp); err != nil {
+			return nil, err
+		}
+	case "audio":
+		stream.MediaType = utils.AVMediaTypeAudio
+		stream.SampleRate = audioSampleRate(id, rtpMap.ClockRate)
+		stream.SampleSize = 16
+		stream.Channels = defaultChannels(rtpMap.Channels)
+		if err := parseAudioParameters(stream, fmtp); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported media type %s", m.Type)
+	}
+
+	return stream, nil
+}
+
+// Streams 返回所有支持的媒体流, 不支持的媒体描述(如PS)会被跳过
+func (s *Session) Streams() ([]*avformat.AVStream, error) {
+	var streams []*avformat.AVStream
+	for _, media := range s.Medias {
+		pt := media.PayloadType()
+		rtpMap, ok := media.RTPMap(pt)
+		if !ok || utils.AVCodecIdNONE == CodecID(rtpMap.EncodingName) || (media.Type != "video" && media.Type != "audio") {
+			continue
+		}
+
+		stream, err := media.Stream(len(streams))
+		if err != nil {
+			return nil, err
+		}
+
+		streams = append(streams, stream)
+	}
+
+	return streams, nil
+}
+
+// 音频采样率, 部分静态负载类型的时钟频率与采样率不同
+func audioSampleRate(id utils.AVCodecID, clockRate int) int {
+	switch id {
+	case utils.AVCodecIdADPCMG722:
+		// RFC 3551 4.5.2 时钟频率为8000, 实际采样率为16000
+		return 16000
+	case utils.AVCodecIdMP3:
+		// RFC 3551 4.5.13 时钟频率固定为90000, 采样率需要从帧头获取
+		return 0
+	default:
+		return clockRate
+	}
+}
+
+func defaultChannels(channels int) int {
+	if channels < 1 {
+		return 1
+	}
+
+	return channels
+}
+
+func decodeBase64(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if data, err := base64.StdEncoding.DecodeString(value); err == nil {
+		return data, nil
+	}
+
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+func addStartCode(nalu []byte) []byte {
+	return append(append([]byte{}, avc.StartCode4...), nalu...)
+}
+
+func parseVideoParameters(stream *avformat.AVStream, fmtp map[string]string) error {
+	var codecData avformat.CodecData
+	var err error
+
+	switch stream.CodecID {
+	case utils.AVCodecIdH264:
+		value, ok := fmtp["sprop-parameter-sets"]
+		if !ok {
+			return nil
+		}
+
+		var sps, pps []byte
+		for _, set := range strings.Split(value, ",") {
+			nalu, err := decodeBase64(set)
+			if err != nil || len(nalu) == 0 {
+				return fmt.Errorf("invalid sprop-parameter-sets %s", value)
+			}
+
+			switch nalu[0] & 0x1F {
+			case avc.H264NalSPS:
+				sps = addStartCode(nalu)
+			case avc.H264NalPPS:
+				pps = addStartCode(nalu)
+			}
+		}
+
+		if sps == nil || pps == nil {
+			return nil
+		}
+
+		codecData, err = avformat.NewAVCCodecData(sps, pps)
+	case utils.AVCodecIdH265:
+		var sets [3][]byte
+		for i, key := range []string{"sprop-vps", "sprop-sps", "sprop-pps"} {
+			value, ok := fmtp[key]
+			if !ok {
+				return nil
+			}
+
+			// 只取第一个参数集
+			nalu, err := decodeBase64(strings.Split(value, ",")[0])
+			if err != nil || len(nalu) == 0 {
+				return fmt.Errorf("invalid %s %s", key, value)
+			}
+
+			sets[i] = addStartCode(nalu)
+		}
+
+		codecData, err = avformat.NewHEVCCodecData(sets[0], sets[1], sets[2])
+	default:
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	stream.CodecParameters = codecData
+	stream.Data = codecData.AnnexBExtraData()
+	return stream.UpdateVideoConfig()
+}
+
+func parseAudioParameters(stream *avformat.AVStream, fmtp map[string]string) error {
+	if utils.AVCodecIdAAC != stream.CodecID {
+		return nil
+	}
+
+	value, ok := fmtp["config"]
+	if !ok {
+		return fmt.Errorf("not find aac config")
+	}
+
+	config, err := hex.DecodeString(value)
+	if err != nil || len(config) < 2 {
+		return fmt.Errorf("invalid aac config %s", value)
+	}
+
+	mpeg4AudioConfig, err := utils.ParseMpeg4AudioConfig(config)
+	if err != nil {
+		return err
+	}
+
+	stream.Data = config
+	stream.SampleRate = mpeg4AudioConfig.SampleRate
+	stream.Channels = mpeg4AudioConfig.Channels
+	return nil
+}
+
+// ClockRate 返回AVStream打包RTP使用的时钟频率
+func ClockRate(stream *avformat.AVStream) int {
+	if utils.AVMediaTypeVideo == stream.MediaType {
+		return 90000
+	}
+
+	switch stream.CodecID {
+	case utils.AVCodecIdOPUS:
+		return 48000
+	case utils.AVCodecIdADPCMG722:
+		// RFC 3551 4.5.2 历史原因, G722的时钟频率为8000
+		return 8000
+	case utils.AVCodecIdMP3:
+		return 90000
+	}
+
+	if stream.SampleRate > 0 {
+		return stream.SampleRate
+	}
+
+	return 8000
+}
+
+// DefaultPayloadType 返回编码器的负载类型, 没有静态负载类型的使用dynamic
+func DefaultPayloadType(id utils.AVCodecID, dynamic int) int {
+	switch id {
+	case utils.AVCodecIdPCMMULAW:
+		return 0
+	case utils.AVCodecIdPCMALAW:
+		return 8
+	case utils.AVCodecIdADPCMG722:
+		return 9
+	case utils.AVCodecIdMP3:
+		return 14
+	default:
+		return dynamic
+	}
+}
+
+// NewMedia 根据AVStream创建媒体描述, control为a=control的值
+func NewMedia(stream *avformat.AVStream, payloadType int, control string) (*Media, error) {
+	name := EncodingName(stream.CodecID)
+	if name == "" {
+		return nil, fmt.Errorf("unsupported codec %s", stream.CodecID)
+	}
+
+	rtpMap := RTPMap{PayloadType: payloadType, EncodingName: name, ClockRate: ClockRate(stream)}
+	media := &Media{Proto: "RTP/AVP", Formats: []string{strconv.Itoa(payloadType)}}
+	var fmtp string
+
+	if utils.AVMediaTypeVideo == stream.MediaType {
+		media.Type = "video"
+		var err error
+		if fmtp, err = videoFmtp(stream); err != nil {
+			return nil, err
+		}
+	} else if utils.AVMediaTypeAudio == stream.MediaType {
+		media.Type = "audio"
+		rtpMap.Channels = defaultChannels(stream.Channels)
+		if utils.AVCodecIdOPUS == stream.CodecID {
+			// RFC 7587 opus固定为2
+			rtpMap.Channels = 2
+		} else if utils.AVCodecIdAAC == stream.CodecID {
+			if len(stream.Data) < 2 {
+				return nil, fmt.Errorf("not find aac audio specific config")
+			}
+
+			fmtp = "streamtype=5;profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=" + hex.EncodeToString(stream.Data)
+		}
+	} else {
+		return nil, fmt.Errorf("unsupported media type %s", stream.MediaType)
+	}
+
+	media.AddAttribute("rtpmap", rtpMap.String())
+	if fmtp != "" {
+		media.AddAttribute("fmtp", strconv.Itoa(payloadType)+" "+fmtp)
+	}
+	if control != "" {
+		media.AddAttribute("control", control)
+	}
+
+	return media, nil
+}
+
+func videoFmtp(stream *avformat.AVStream) (string, error) {
+	codecData := stream.CodecParameters
+	if codecData == nil || (utils.AVCodecIdH264 != stream.CodecID && utils.AVCodecIdH265 != stream.CodecID) {
+		return "", nil
+	}
+
+	encode := func(data []byte) string {
+		return base64.StdEncoding.EncodeToString(avc.RemoveStartCode(data))
+	}
+
+	if utils.AVCodecIdH264 == stream.CodecID {
+		if len(codecData.SPS()) == 0 || len(codecData.PPS()) == 0 {
+			return "", fmt.Errorf("not find sps or pps")
+		}
+
+		sps := avc.RemoveStartCode(codecData.SPS()[0])
+		if len(sps) < 4 {
+			return "", fmt.Errorf("invalid sps")
+		}
+
+		return fmt.Sprintf("packetization-mode=1;profile-level-id=%s;sprop-parameter-sets=%s,%s",
+			strings.ToUpper(hex.EncodeToString(sps[1:4])), encode(codecData.SPS()[0]), encode(codecData.PPS()[0])), nil
+	}
+
+	vpsCodecData, ok := codecData.(interface{ VPS() [][]byte })
+	if !ok || len(vpsCodecData.VPS()) == 0 || len(codecData.SPS()) == 0 || len(codecData.PPS()) == 0 {
+		return "", fmt.Errorf("not find vps, sps or pps")
+	}
+
+	return fmt.Sprintf("sprop-vps=%s;sprop-sps=%s;sprop-pps=%s",
+		encode(vpsCodecData.VPS()[0]), encode(codecData.SPS()[0]), encode(codecData.PPS()[0])), nil
+}
+
+// NewSession 根据TrackManager创建RTSP使用的会话描述, 每个track的control为trackID=<index>
+func NewSession(tracks *avformat.TrackManager, address string) (*Session, error) {
+	if address == "" {
+		address = "0.0.0.0"
+	}
+
+	session := &Session{
+		Origin:     Origin{"-", "0", "0", "IN", "IP4", address},
+		Name:       "avformat",
+		Connection: "IN IP4 " + address,
+		Timing:     "0 0",
+	}
+	session.AddAttribute("tool", "avformat")
+	session.AddAttribute("control", "*")
+
+	for i, track := range tracks.Tracks {
+		stream := track.GetStream()
+		media, err := NewMedia(stream, DefaultPayloadType(stream.CodecID, 96+i), "trackID="+strconv.Itoa(stream.Index))
+		if err != nil {
+			return nil, err
+		}
+
+		session.Medias = append(session.Medias, media)
+	}
+
+	return session, nil
+}
@@ -0,0 +1,491 @@
+package srt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/lkmio/avformat/bufio"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultLatency = 120 * time.Millisecond
+
+	tickInterval      = 10 * time.Millisecond
+	keepaliveInterval = time.Second
+	peerIdleTimeout   = 5 * time.Second
+
+	// 没有收到ACK时重传的最小间隔
+	minRetransmitTimeout = 50 * time.Millisecond
+	// NAK的最小间隔
+	minNAKInterval = 20 * time.Millisecond
+	// 初始RTT, 微秒
+	initialRTT = 100000
+)
+
+var errPeerTimeout = fmt.Errorf("srt peer timeout")
+
+// Conn SRT连接, 数据包按照TSBPD延迟按序交付, 丢失的包通过NAK重传, 超过延迟仍未收到的包被丢弃
+type Conn struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	conn   net.PacketConn
+	peer   net.Addr
+	owner  bool // 呼叫端独占UDP socket
+	remove func()
+
+	socketID     uint32
+	peerSocketID uint32
+	streamID     string
+	latency      time.Duration
+	start        time.Time
+
+	// 发送
+	sendSeq    uint32
+	msgNumber  uint32
+	sendBuffer map[uint32]*packet // 未确认的包
+	lastSend   time.Time
+	rtt        uint32 // 微秒
+
+	// 接收
+	recvNext     uint32 // 下一个交付的序号
+	recvMax      uint32 // 已收到的最大序号+1
+	recvBuffer   map[uint32]*packet
+	losses       map[uint32]time.Time // 丢失的序号和最后发送NAK的时间
+	hasBase      bool
+	base         time.Time // 对端时间戳0对应的本地时间
+	lastTs       uint32
+	tsWrap       int64
+	ackNumber    uint32
+	lastAck      uint32
+	ackTimes     map[uint32]time.Time
+	lastReceive  time.Time
+	delivered    [][]byte
+	readBuffer   []byte
+	dropped      int
+	retransmits  int
+	dropOutgoing func(p *packet) bool // 测试使用, 模拟丢包
+
+	closed    bool
+	err       error
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func newConn(conn net.PacketConn, peer net.Addr, socketID, peerSocketID, initialSeq uint32, latency time.Duration) *Conn {
+	c := &Conn{
+		conn:         conn,
+		peer:         peer,
+		socketID:     socketID,
+		peerSocketID: peerSocketID,
+		latency:      latency,
+		start:        time.Now(),
+		sendSeq:      initialSeq,
+		sendBuffer:   make(map[uint32]*packet),
+		rtt:          initialRTT,
+		recvNext:     initialSeq,
+		recvMax:      initialSeq,
+		lastAck:      initialSeq,
+		recvBuffer:   make(map[uint32]*packet),
+		losses:       make(map[uint32]time.Time),
+		ackTimes:     make(map[uint32]time.Time),
+		lastReceive:  time.Now(),
+		done:         make(chan struct{}),
+	}
+
+	c.cond = sync.NewCond(&c.mutex)
+	return c
+}
+
+// StreamID 返回呼叫端在握手中携带的stream id
+func (c *Conn) StreamID() string {
+	return c.streamID
+}
+
+// Latency 返回协商后的TSBPD延迟
+func (c *Conn) Latency() time.Duration {
+	return c.latency
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.peer
+}
+
+func (c *Conn) timestamp() uint32 {
+	return uint32(time.Since(c.start).Microseconds())
+}
+
+func (c *Conn) send(p *packet) {
+	p.dest = c.peerSocketID
+	// 数据包的时间戳在入队时确定, 重传时保持不变, 接收端据此计算交付时间
+	if p.control {
+		p.timestamp = c.timestamp()
+	}
+	c.lastSend = time.Now()
+	if _, err := c.conn.WriteTo(p.marshal(nil), c.peer); err != nil {
+		println(err.Error())
+	}
+}
+
+// Write 发送数据, 按照PayloadSize拆分为多个数据包, TS流应当以188字节对齐
+func (c *Conn) Write(data []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	for n := 0; n < len(data); n += PayloadSize {
+		payload := append([]byte{}, data[n:bufio.MinInt(n+PayloadSize, len(data))]...)
+		p := &packet{seq: c.sendSeq, msg: msgSolo | c.msgNumber&msgNumberMask, timestamp: c.timestamp(), payload: payload}
+		c.sendSeq = seqAdd(c.sendSeq, 1)
+		c.msgNumber++
+
+		// 超过流量窗口时丢弃最早的包
+		if len(c.sendBuffer) >= defaultFlowWindow {
+			delete(c.sendBuffer, seqAdd(p.seq, -defaultFlowWindow))
+		}
+
+		c.sendBuffer[p.seq] = p
+		c.sendData(p)
+	}
+
+	return len(data), nil
+}
+
+func (c *Conn) sendData(p *packet) {
+	p.sentAt = time.Now()
+	if c.dropOutgoing != nil && c.dropOutgoing(p) {
+		return
+	}
+
+	c.send(p)
+}
+
+// Read 读取按序交付的数据
+func (c *Conn) Read(data []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for len(c.readBuffer) == 0 {
+		if len(c.delivered) > 0 {
+			c.readBuffer = c.delivered[0]
+			c.delivered = c.delivered[1:]
+		} else if c.closed {
+			if c.err != nil {
+				return 0, c.err
+			}
+			return 0, io.EOF
+		} else {
+			c.cond.Wait()
+		}
+	}
+
+	n := copy(data, c.readBuffer)
+	c.readBuffer = c.readBuffer[n:]
+	return n, nil
+}
+
+// 处理收到的包
+func (c *Conn) handle(p *packet) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	c.lastReceive = time.Now()
+	if !p.control {
+		c.handleData(p)
+		return
+	}
+
+	switch p.controlType {
+	case controlACK:
+		c.handleACK(p)
+	case controlNAK:
+		for _, seq := range parseLossList(p.payload) {
+			if lost, ok := c.sendBuffer[seq]; ok {
+				lost.msg |= msgRetransmitted
+				c.retransmits++
+				c.sendData(lost)
+			}
+		}
+	case controlACKACK:
+		if sentAt, ok := c.ackTimes[p.info]; ok {
+			// 平滑RTT
+			rtt := uint32(time.Since(sentAt).Microseconds())
+			c.rtt = (c.rtt*7 + rtt) / 8
+			delete(c.ackTimes, p.info)
+		}
+	case controlShutdown:
+		go c.close(nil, false)
+	}
+}
+
+func (c *Conn) handleData(p *packet) {
+	diff := seqDiff(p.seq, c.recvNext)
+	if diff < 0 || diff >= defaultFlowWindow {
+		return
+	} else if _, ok := c.recvBuffer[p.seq]; ok {
+		return
+	}
+
+	if !c.hasBase {
+		c.hasBase = true
+		c.base = time.Now().Add(-time.Duration(p.timestamp) * time.Microsecond)
+		c.lastTs = p.timestamp
+	}
+
+	c.recvBuffer[p.seq] = p
+	delete(c.losses, p.seq)
+	if gap := seqDiff(p.seq, c.recvMax); gap >= 0 {
+		// 中间的包丢失, 立即发送NAK
+		if gap > 0 {
+			var losses []uint32
+			now := time.Now()
+			for seq := c.recvMax; seq != p.seq; seq = seqAdd(seq, 1) {
+				c.losses[seq] = now
+				losses = append(losses, seq)
+			}
+			c.send(newControlPacket(controlNAK, 0, appendLossList(nil, losses)))
+		}
+
+		c.recvMax = seqAdd(p.seq, 1)
+	}
+}
+
+func (c *Conn) handleACK(p *packet) {
+	if len(p.payload) < 4 {
+		return
+	}
+
+	ack := binary.BigEndian.Uint32(p.payload) & seqMask
+	for seq := range c.sendBuffer {
+		if seqDiff(seq, ack) < 0 {
+			delete(c.sendBuffer, seq)
+		}
+	}
+
+	// 完整的ACK需要应答ACKACK
+	if len(p.payload) >= 16 {
+		if rtt := binary.BigEndian.Uint32(p.payload[4:]); rtt > 0 {
+			c.rtt = rtt
+		}
+		c.send(newControlPacket(controlACKACK, p.info, nil))
+	}
+}
+
+// 按照时间戳计算交付时间, 处理32位微秒时间戳的回绕
+func (c *Conn) deliveryTime(p *packet) time.Time {
+	ts := c.tsWrap + int64(p.timestamp)
+	if p.timestamp < c.lastTs && c.lastTs-p.timestamp > 1<<31 {
+		ts += 1 << 32
+	} else if p.timestamp > c.lastTs && p.timestamp-c.lastTs > 1<<31 {
+		ts -= 1 << 32
+	}
+
+	return c.base.Add(time.Duration(ts)*time.Microsecond + c.latency)
+}
+
+// 交付到达延迟时间的包, 之前丢失且已经超时的包被丢弃
+func (c *Conn) deliver(now time.Time) {
+	for c.recvNext != c.recvMax {
+		p, ok := c.recvBuffer[c.recvNext]
+		if !ok {
+			// 查找下一个收到的包, 已经到达交付时间时放弃丢失的包
+			next := c.recvNext
+			for next != c.recvMax {
+				if _, ok = c.recvBuffer[next]; ok {
+					break
+				}
+				next = seqAdd(next, 1)
+			}
+
+			if next == c.recvMax || now.Before(c.deliveryTime(c.recvBuffer[next])) {
+				return
+			}
+
+			for ; c.recvNext != next; c.recvNext = seqAdd(c.recvNext, 1) {
+				delete(c.losses, c.recvNext)
+				c.dropped++
+			}
+			continue
+		}
+
+		if now.Before(c.deliveryTime(p)) {
+			return
+		}
+
+		if p.timestamp < c.lastTs && c.lastTs-p.timestamp > 1<<31 {
+			c.tsWrap += 1 << 32
+		}
+		c.lastTs = p.timestamp
+
+		delete(c.recvBuffer, c.recvNext)
+		c.recvNext = seqAdd(c.recvNext, 1)
+		c.delivered = append(c.delivered, p.payload)
+		c.cond.Broadcast()
+	}
+}
+
+func (c *Conn) run() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		if err := c.tick(time.Now()); err != nil {
+			c.close(err, false)
+			return
+		}
+	}
+}
+
+func (c *Conn) tick(now time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return nil
+	} else if now.Sub(c.lastReceive) > peerIdleTimeout {
+		return errPeerTimeout
+	}
+
+	c.deliver(now)
+	c.sendACK(now)
+
+	// 定时重发NAK
+	rtt := time.Duration(c.rtt) * time.Microsecond
+	nakInterval := 2 * rtt
+	if nakInterval < minNAKInterval {
+		nakInterval = minNAKInterval
+	}
+
+	var losses []uint32
+	for seq, last := range c.losses {
+		if now.Sub(last) >= nakInterval {
+			losses = append(losses, seq)
+			c.losses[seq] = now
+		}
+	}
+
+	if len(losses) > 0 {
+		sort.Slice(losses, func(i, j int) bool {
+			return seqDiff(losses[i], losses[j]) < 0
+		})
+		c.send(newControlPacket(controlNAK, 0, appendLossList(nil, losses)))
+	}
+
+	// 长时间没有确认的包, 例如最后一个包丢失
+	retransmitTimeout := 4*rtt + tickInterval
+	if retransmitTimeout < minRetransmitTimeout {
+		retransmitTimeout = minRetransmitTimeout
+	}
+
+	for _, p := range c.sendBuffer {
+		if now.Sub(p.sentAt) >= retransmitTimeout {
+			p.msg |= msgRetransmitted
+			c.retransmits++
+			c.sendData(p)
+		}
+
+		// 超过延迟的包对端已经丢弃, 重传会刷新sentAt, 按照时间戳计算
+		if time.Duration(c.timestamp()-p.timestamp)*time.Microsecond > c.latency+time.Second {
+			delete(c.sendBuffer, p.seq)
+		}
+	}
+
+	if now.Sub(c.lastSend) >= keepaliveInterval {
+		c.send(newControlPacket(controlKeepalive, 0, nil))
+	}
+
+	return nil
+}
+
+// 连续收到的包有变化时发送ACK
+func (c *Conn) sendACK(now time.Time) {
+	ack := c.recvNext
+	for ack != c.recvMax {
+		if _, ok := c.recvBuffer[ack]; !ok {
+			break
+		}
+		ack = seqAdd(ack, 1)
+	}
+
+	if ack == c.lastAck {
+		return
+	}
+
+	c.lastAck = ack
+	c.ackNumber++
+	c.ackTimes[c.ackNumber] = now
+	for number, sentAt := range c.ackTimes {
+		if now.Sub(sentAt) > time.Second {
+			delete(c.ackTimes, number)
+		}
+	}
+
+	// last ack seq, rtt, rtt variance, available buffer, packet rate, link capacity, receiving rate
+	cif := binary.BigEndian.AppendUint32(nil, ack)
+	cif = binary.BigEndian.AppendUint32(cif, c.rtt)
+	cif = binary.BigEndian.AppendUint32(cif, c.rtt/2)
+	cif = binary.BigEndian.AppendUint32(cif, uint32(defaultFlowWindow-len(c.recvBuffer)))
+	cif = append(cif, make([]byte, 12)...)
+	c.send(newControlPacket(controlACK, c.ackNumber, cif))
+}
+
+func (c *Conn) close(err error, local bool) {
+	c.closeOnce.Do(func() {
+		c.mutex.Lock()
+		if local {
+			c.send(newControlPacket(controlShutdown, 0, make([]byte, 4)))
+		}
+
+		// 交付已经收到的连续的包
+		if err == nil {
+			for c.recvNext != c.recvMax {
+				p, ok := c.recvBuffer[c.recvNext]
+				if !ok {
+					break
+				}
+				delete(c.recvBuffer, c.recvNext)
+				c.recvNext = seqAdd(c.recvNext, 1)
+				c.delivered = append(c.delivered, p.payload)
+			}
+		}
+
+		c.closed = true
+		c.err = err
+		c.cond.Broadcast()
+		c.mutex.Unlock()
+
+		close(c.done)
+		if c.remove != nil {
+			c.remove()
+		}
+		if c.owner {
+			_ = c.conn.Close()
+		}
+	})
+}
+
+// Close 发送shutdown并关闭连接
+func (c *Conn) Close() error {
+	c.close(nil, true)
+	return nil
+}
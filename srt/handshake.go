@@ -0,0 +1,195 @@
+package srt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+const (
+	handshakeCIFSize = 48
+
+	handshakeInduction  = 1
+	handshakeConclusion = 0xFFFFFFFF
+
+	// 监听端在induction应答中返回的magic
+	handshakeMagic = 0x4A17
+
+	// 扩展标志
+	extensionHSREQ  = 0x1
+	extensionConfig = 0x4
+
+	// 扩展类型
+	extensionTypeHSREQ = 1
+	extensionTypeHSRSP = 2
+	extensionTypeSID   = 5
+
+	// SRT版本1.5.0
+	srtVersion = 0x010500
+
+	// HSREQ标志
+	flagTSBPDSND      = 0x01
+	flagTSBPDRCV      = 0x02
+	flagTLPKTDROP     = 0x08
+	flagPERIODICNAK   = 0x10
+	flagREXMITFLG     = 0x20
+	defaultSRTFlags   = flagTSBPDSND | flagTSBPDRCV | flagTLPKTDROP | flagPERIODICNAK | flagREXMITFLG
+	maxStreamIDLength = 512
+
+	defaultMTU        = 1500
+	defaultFlowWindow = 8192
+)
+
+type handshake struct {
+	version       uint32
+	encryption    uint16
+	extension     uint16
+	initialSeq    uint32
+	mtu           uint32
+	flowWindow    uint32
+	handshakeType uint32
+	socketID      uint32
+	cookie        uint32
+	peerIP        net.IP
+
+	// HSREQ/HSRSP
+	srtExtension uint16 // 0或者HSREQ/HSRSP
+	srtVersion   uint32
+	srtFlags     uint32
+	recvDelay    uint16 // 接收端TSBPD延迟, 毫秒
+	sendDelay    uint16
+
+	streamID string
+}
+
+func (h *handshake) marshal() []byte {
+	dst := binary.BigEndian.AppendUint32(nil, h.version)
+	dst = binary.BigEndian.AppendUint16(dst, h.encryption)
+	dst = binary.BigEndian.AppendUint16(dst, h.extension)
+	dst = binary.BigEndian.AppendUint32(dst, h.initialSeq)
+	dst = binary.BigEndian.AppendUint32(dst, h.mtu)
+	dst = binary.BigEndian.AppendUint32(dst, h.flowWindow)
+	dst = binary.BigEndian.AppendUint32(dst, h.handshakeType)
+	dst = binary.BigEndian.AppendUint32(dst, h.socketID)
+	dst = binary.BigEndian.AppendUint32(dst, h.cookie)
+	dst = appendPeerIP(dst, h.peerIP)
+
+	if h.srtExtension != 0 {
+		dst = binary.BigEndian.AppendUint16(dst, h.srtExtension)
+		dst = binary.BigEndian.AppendUint16(dst, 3)
+		dst = binary.BigEndian.AppendUint32(dst, h.srtVersion)
+		dst = binary.BigEndian.AppendUint32(dst, h.srtFlags)
+		dst = binary.BigEndian.AppendUint16(dst, h.recvDelay)
+		dst = binary.BigEndian.AppendUint16(dst, h.sendDelay)
+	}
+
+	if h.streamID != "" {
+		sid := encodeStreamID(h.streamID)
+		dst = binary.BigEndian.AppendUint16(dst, extensionTypeSID)
+		dst = binary.BigEndian.AppendUint16(dst, uint16(len(sid)/4))
+		dst = append(dst, sid...)
+	}
+
+	return dst
+}
+
+func parseHandshake(data []byte) (*handshake, error) {
+	if len(data) < handshakeCIFSize {
+		return nil, fmt.Errorf("invalid srt handshake size %d", len(data))
+	}
+
+	h := &handshake{
+		version:       binary.BigEndian.Uint32(data),
+		encryption:    binary.BigEndian.Uint16(data[4:]),
+		extension:     binary.BigEndian.Uint16(data[6:]),
+		initialSeq:    binary.BigEndian.Uint32(data[8:]),
+		mtu:           binary.BigEndian.Uint32(data[12:]),
+		flowWindow:    binary.BigEndian.Uint32(data[16:]),
+		handshakeType: binary.BigEndian.Uint32(data[20:]),
+		socketID:      binary.BigEndian.Uint32(data[24:]),
+		cookie:        binary.BigEndian.Uint32(data[28:]),
+		peerIP:        parsePeerIP(data[32:48]),
+	}
+
+	// induction阶段的扩展字段不是扩展标志
+	if h.version < 5 || h.handshakeType != handshakeConclusion {
+		return h, nil
+	}
+
+	for extensions := data[handshakeCIFSize:]; len(extensions) >= 4; {
+		extensionType := binary.BigEndian.Uint16(extensions)
+		length := int(binary.BigEndian.Uint16(extensions[2:])) * 4
+		if 4+length > len(extensions) {
+			return nil, fmt.Errorf("invalid srt handshake extension length %d", length)
+		}
+
+		content := extensions[4 : 4+length]
+		switch extensionType {
+		case extensionTypeHSREQ, extensionTypeHSRSP:
+			if len(content) < 12 {
+				return nil, fmt.Errorf("invalid srt hsreq length %d", len(content))
+			}
+
+			h.srtExtension = extensionType
+			h.srtVersion = binary.BigEndian.Uint32(content)
+			h.srtFlags = binary.BigEndian.Uint32(content[4:])
+			h.recvDelay = binary.BigEndian.Uint16(content[8:])
+			h.sendDelay = binary.BigEndian.Uint16(content[10:])
+		case extensionTypeSID:
+			h.streamID = decodeStreamID(content)
+		}
+
+		extensions = extensions[4+length:]
+	}
+
+	return h, nil
+}
+
+// IPv4地址按照32位主机字节序写入第一个字
+func appendPeerIP(dst []byte, ip net.IP) []byte {
+	address := make([]byte, 16)
+	if ip4 := ip.To4(); ip4 != nil {
+		address[0], address[1], address[2], address[3] = ip4[3], ip4[2], ip4[1], ip4[0]
+	} else if len(ip) == net.IPv6len {
+		copy(address, ip)
+	}
+
+	return append(dst, address...)
+}
+
+func parsePeerIP(data []byte) net.IP {
+	for _, b := range data[4:] {
+		if b != 0 {
+			return append(net.IP{}, data...)
+		}
+	}
+
+	return net.IPv4(data[3], data[2], data[1], data[0])
+}
+
+// stream id每4个字节按照小端序存储, 不足4字节补0
+func encodeStreamID(streamID string) []byte {
+	data := []byte(streamID)
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+
+	for i := 0; i < len(data); i += 4 {
+		data[i], data[i+1], data[i+2], data[i+3] = data[i+3], data[i+2], data[i+1], data[i]
+	}
+
+	return data
+}
+
+func decodeStreamID(data []byte) string {
+	decoded := make([]byte, 0, len(data))
+	for i := 0; i+4 <= len(data); i += 4 {
+		decoded = append(decoded, data[i+3], data[i+2], data[i+1], data[i])
+	}
+
+	for len(decoded) > 0 && decoded[len(decoded)-1] == 0 {
+		decoded = decoded[:len(decoded)-1]
+	}
+
+	return string(decoded)
+}
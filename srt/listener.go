@@ -0,0 +1,373 @@
+package srt
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultDialTimeout = 3 * time.Second
+
+	// 握手包重发间隔
+	handshakeInterval = 250 * time.Millisecond
+
+	maxPacketSize = 1500
+)
+
+var errListenerClosed = fmt.Errorf("srt listener closed")
+
+func randomUint32() uint32 {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// Listener 监听端, 所有连接共用一个UDP socket, 按照目标socket id分发
+type Listener struct {
+	mutex   sync.Mutex
+	conn    net.PacketConn
+	latency time.Duration
+	secret  uint32
+
+	conns     map[uint32]*Conn
+	responses map[string][]byte // 已经建立的连接, 重复的conclusion直接应答
+	accept    chan *Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Listen 监听UDP地址, 例如 ":9000"
+func Listen(address string) (*Listener, error) {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Listener{
+		conn:      conn,
+		latency:   DefaultLatency,
+		secret:    randomUint32(),
+		conns:     make(map[uint32]*Conn),
+		responses: make(map[string][]byte),
+		accept:    make(chan *Conn, 16),
+		closed:    make(chan struct{}),
+	}
+
+	go l.readLoop()
+	return l, nil
+}
+
+// SetLatency 设置接收延迟, 实际延迟取双方的最大值
+func (l *Listener) SetLatency(latency time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.latency = latency
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Accept 等待新的连接, 通过Conn.StreamID区分推流和拉流
+func (l *Listener) Accept() (*Conn, error) {
+	select {
+	case conn := <-l.accept:
+		return conn, nil
+	case <-l.closed:
+		return nil, errListenerClosed
+	}
+}
+
+// Close 关闭监听和所有连接
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.mutex.Lock()
+		var conns []*Conn
+		for _, conn := range l.conns {
+			conns = append(conns, conn)
+		}
+		l.mutex.Unlock()
+
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+		_ = l.conn.Close()
+	})
+
+	return nil
+}
+
+func (l *Listener) readLoop() {
+	buffer := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := l.conn.ReadFrom(buffer)
+		if err != nil {
+			_ = l.Close()
+			return
+		}
+
+		p, err := parsePacket(append([]byte{}, buffer[:n]...))
+		if err != nil {
+			continue
+		}
+
+		if p.control && controlHandshake == p.controlType {
+			l.handshake(p, addr)
+			continue
+		}
+
+		l.mutex.Lock()
+		conn := l.conns[p.dest]
+		l.mutex.Unlock()
+		if conn != nil {
+			conn.handle(p)
+		}
+	}
+}
+
+// 根据对端地址和时间生成cookie, 每分钟变化
+func (l *Listener) cookie(addr net.Addr, offset int64) uint32 {
+	hash := fnv.New32a()
+	_, _ = fmt.Fprintf(hash, "%s-%d-%d", addr.String(), l.secret, time.Now().Unix()/60+offset)
+	return hash.Sum32()
+}
+
+func (l *Listener) reply(addr net.Addr, dest uint32, h *handshake) []byte {
+	p := newControlPacket(controlHandshake, 0, h.marshal())
+	p.dest = dest
+	data := p.marshal(nil)
+	if _, err := l.conn.WriteTo(data, addr); err != nil {
+		println(err.Error())
+	}
+
+	return data
+}
+
+func (l *Listener) handshake(p *packet, addr net.Addr) {
+	h, err := parseHandshake(p.payload)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	if handshakeInduction == h.handshakeType {
+		l.reply(addr, h.socketID, &handshake{
+			version:       5,
+			extension:     handshakeMagic,
+			initialSeq:    h.initialSeq,
+			mtu:           defaultMTU,
+			flowWindow:    defaultFlowWindow,
+			handshakeType: handshakeInduction,
+			cookie:        l.cookie(addr, 0),
+			peerIP:        addrIP(addr),
+		})
+		return
+	} else if handshakeConclusion != h.handshakeType {
+		return
+	}
+
+	key := fmt.Sprintf("%s-%d", addr.String(), h.socketID)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// 应答丢失后对端会重发conclusion
+	if response, ok := l.responses[key]; ok {
+		_, _ = l.conn.WriteTo(response, addr)
+		return
+	}
+
+	if h.cookie != l.cookie(addr, 0) && h.cookie != l.cookie(addr, -1) {
+		println(fmt.Sprintf("invalid srt cookie from %s", addr.String()))
+		return
+	} else if h.version != 5 || h.srtExtension != extensionTypeHSREQ {
+		println(fmt.Sprintf("unsupported srt handshake version %d from %s", h.version, addr.String()))
+		return
+	} else if len(h.streamID) > maxStreamIDLength {
+		return
+	}
+
+	// 延迟取双方的最大值
+	latency := l.latency
+	if peerLatency := time.Duration(h.sendDelay) * time.Millisecond; peerLatency > latency {
+		latency = peerLatency
+	}
+
+	socketID := randomUint32() & seqMask
+	for _, ok := l.conns[socketID]; ok || socketID == 0; _, ok = l.conns[socketID] {
+		socketID = randomUint32() & seqMask
+	}
+
+	conn := newConn(l.conn, addr, socketID, h.socketID, h.initialSeq, latency)
+	conn.streamID = h.streamID
+	conn.remove = func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		delete(l.conns, socketID)
+		delete(l.responses, key)
+	}
+
+	l.responses[key] = l.reply(addr, h.socketID, &handshake{
+		version:       5,
+		extension:     extensionHSREQ,
+		initialSeq:    h.initialSeq,
+		mtu:           defaultMTU,
+		flowWindow:    defaultFlowWindow,
+		handshakeType: handshakeConclusion,
+		socketID:      socketID,
+		cookie:        h.cookie,
+		peerIP:        addrIP(addr),
+		srtExtension:  extensionTypeHSRSP,
+		srtVersion:    srtVersion,
+		srtFlags:      defaultSRTFlags,
+		recvDelay:     uint16(latency.Milliseconds()),
+		sendDelay:     uint16(latency.Milliseconds()),
+	})
+
+	l.conns[socketID] = conn
+	go conn.run()
+
+	select {
+	case l.accept <- conn:
+	default:
+		println("srt accept queue is full")
+		go conn.close(fmt.Errorf("srt accept queue is full"), true)
+	}
+}
+
+func addrIP(addr net.Addr) net.IP {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr.IP
+	}
+
+	return nil
+}
+
+// Dial 以呼叫端模式连接监听端, streamID为空时不携带, latency为0时使用默认延迟
+func Dial(address, streamID string, latency time.Duration) (*Conn, error) {
+	remote, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	} else if len(streamID) > maxStreamIDLength {
+		return nil, fmt.Errorf("srt stream id too long")
+	}
+
+	if latency <= 0 {
+		latency = DefaultLatency
+	}
+
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+
+	socketID := randomUint32()&seqMask | 1
+	initialSeq := randomUint32() & seqMask
+	request := &handshake{
+		version:       4,
+		extension:     2, // UDT的socket type DGRAM
+		initialSeq:    initialSeq,
+		mtu:           defaultMTU,
+		flowWindow:    defaultFlowWindow,
+		handshakeType: handshakeInduction,
+		socketID:      socketID,
+		peerIP:        remote.IP,
+	}
+
+	response, err := exchangeHandshake(conn, remote, request)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	} else if response.version != 5 || response.extension != handshakeMagic {
+		_ = conn.Close()
+		return nil, fmt.Errorf("srt listener does not support handshake v5")
+	}
+
+	request.version = 5
+	request.extension = extensionHSREQ
+	request.handshakeType = handshakeConclusion
+	request.cookie = response.cookie
+	request.srtExtension = extensionTypeHSREQ
+	request.srtVersion = srtVersion
+	request.srtFlags = defaultSRTFlags
+	request.recvDelay = uint16(latency.Milliseconds())
+	request.sendDelay = uint16(latency.Milliseconds())
+	if request.streamID = streamID; streamID != "" {
+		request.extension |= extensionConfig
+	}
+
+	if response, err = exchangeHandshake(conn, remote, request); err != nil {
+		_ = conn.Close()
+		return nil, err
+	} else if response.handshakeType != handshakeConclusion || response.srtExtension != extensionTypeHSRSP {
+		_ = conn.Close()
+		return nil, fmt.Errorf("srt handshake rejected: %d", int32(response.handshakeType))
+	}
+
+	if peerLatency := time.Duration(response.recvDelay) * time.Millisecond; peerLatency > latency {
+		latency = peerLatency
+	}
+
+	c := newConn(conn, remote, socketID, response.socketID, initialSeq, latency)
+	c.streamID = streamID
+	c.owner = true
+	go c.run()
+	go func() {
+		buffer := make([]byte, maxPacketSize)
+		for {
+			n, _, err := conn.ReadFrom(buffer)
+			if err != nil {
+				c.close(err, false)
+				return
+			}
+
+			if p, err := parsePacket(append([]byte{}, buffer[:n]...)); err == nil && p.dest == socketID {
+				c.handle(p)
+			}
+		}
+	}()
+
+	return c, nil
+}
+
+// 发送握手包并等待应答, 超时重发
+func exchangeHandshake(conn net.PacketConn, remote net.Addr, request *handshake) (*handshake, error) {
+	p := newControlPacket(controlHandshake, 0, request.marshal())
+	data := p.marshal(nil)
+	buffer := make([]byte, maxPacketSize)
+	deadline := time.Now().Add(DefaultDialTimeout)
+	defer conn.SetReadDeadline(time.Time{})
+
+	for time.Now().Before(deadline) {
+		if _, err := conn.WriteTo(data, remote); err != nil {
+			return nil, err
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(handshakeInterval))
+		for {
+			n, _, err := conn.ReadFrom(buffer)
+			if err != nil {
+				break
+			}
+
+			response, err := parsePacket(buffer[:n])
+			if err != nil || !response.control || controlHandshake != response.controlType || response.dest != request.socketID {
+				continue
+			}
+
+			h, err := parseHandshake(response.payload)
+			if err != nil {
+				return nil, err
+			} else if h.handshakeType == request.handshakeType || h.handshakeType >= 1000 {
+				return h, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("srt handshake timeout")
+}
@@ -0,0 +1,141 @@
+package srt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+const (
+	headerSize = 16
+
+	// 每个数据包携带7个TS包
+	PayloadSize = 1316
+
+	// 序号为31位
+	seqMask = 0x7FFFFFFF
+
+	// 消息号字段: PP(2) O(1) KK(2) R(1) msgno(26)
+	msgSolo          = 0xC0000000 // 完整的消息
+	msgRetransmitted = 0x04000000
+	msgNumberMask    = 0x03FFFFFF
+)
+
+// 控制包类型
+const (
+	controlHandshake = 0x0
+	controlKeepalive = 0x1
+	controlACK       = 0x2
+	controlNAK       = 0x3
+	controlShutdown  = 0x5
+	controlACKACK    = 0x6
+)
+
+type packet struct {
+	control bool
+
+	// 数据包
+	seq uint32
+	msg uint32
+
+	// 控制包
+	controlType uint16
+	subtype     uint16
+	info        uint32 // type-specific information
+
+	timestamp uint32 // 微秒, 相对于连接建立的时间
+	dest      uint32 // 对端的socket id
+	payload   []byte // 数据包的负载或者控制包的CIF
+
+	sentAt time.Time // 发送缓存中最后一次发送的时间
+}
+
+func (p *packet) marshal(dst []byte) []byte {
+	if p.control {
+		dst = binary.BigEndian.AppendUint32(dst, 0x80000000|uint32(p.controlType)<<16|uint32(p.subtype))
+		dst = binary.BigEndian.AppendUint32(dst, p.info)
+	} else {
+		dst = binary.BigEndian.AppendUint32(dst, p.seq&seqMask)
+		dst = binary.BigEndian.AppendUint32(dst, p.msg)
+	}
+
+	dst = binary.BigEndian.AppendUint32(dst, p.timestamp)
+	dst = binary.BigEndian.AppendUint32(dst, p.dest)
+	return append(dst, p.payload...)
+}
+
+func parsePacket(data []byte) (*packet, error) {
+	if len(data) < headerSize {
+		return nil, fmt.Errorf("invalid srt packet size %d", len(data))
+	}
+
+	p := &packet{
+		timestamp: binary.BigEndian.Uint32(data[8:]),
+		dest:      binary.BigEndian.Uint32(data[12:]),
+		payload:   data[headerSize:],
+	}
+
+	word := binary.BigEndian.Uint32(data)
+	if p.control = word&0x80000000 != 0; p.control {
+		p.controlType = uint16(word >> 16 & 0x7FFF)
+		p.subtype = uint16(word)
+		p.info = binary.BigEndian.Uint32(data[4:])
+	} else {
+		p.seq = word
+		p.msg = binary.BigEndian.Uint32(data[4:])
+	}
+
+	return p, nil
+}
+
+func newControlPacket(controlType uint16, info uint32, payload []byte) *packet {
+	return &packet{control: true, controlType: controlType, info: info, payload: payload}
+}
+
+func seqAdd(seq uint32, n int) uint32 {
+	return uint32(int64(seq)+int64(n)) & seqMask
+}
+
+// 返回a-b, 处理31位回绕
+func seqDiff(a, b uint32) int {
+	return int(int32((a-b)<<1) >> 1)
+}
+
+// 丢失列表, 单个序号最高位为0, 范围的起始序号最高位为1, 后面跟结束序号
+func appendLossList(dst []byte, losses []uint32) []byte {
+	for i := 0; i < len(losses); {
+		j := i
+		for j+1 < len(losses) && seqDiff(losses[j+1], losses[j]) == 1 {
+			j++
+		}
+
+		if i == j {
+			dst = binary.BigEndian.AppendUint32(dst, losses[i])
+		} else {
+			dst = binary.BigEndian.AppendUint32(dst, 0x80000000|losses[i])
+			dst = binary.BigEndian.AppendUint32(dst, losses[j])
+		}
+		i = j + 1
+	}
+
+	return dst
+}
+
+func parseLossList(data []byte) []uint32 {
+	var losses []uint32
+	for ; len(data) >= 4; data = data[4:] {
+		seq := binary.BigEndian.Uint32(data)
+		if seq&0x80000000 == 0 || len(data) < 8 {
+			losses = append(losses, seq&seqMask)
+			continue
+		}
+
+		first, last := seq&seqMask, binary.BigEndian.Uint32(data[4:])&seqMask
+		for s := first; seqDiff(last, s) >= 0 && len(losses) < 65536; s = seqAdd(s, 1) {
+			losses = append(losses, s)
+		}
+		data = data[4:]
+	}
+
+	return losses
+}
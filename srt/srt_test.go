@@ -0,0 +1,207 @@
+package srt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/flv"
+	"github.com/lkmio/avformat/mpegts"
+	"github.com/lkmio/avformat/utils"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHandshake(t *testing.T) {
+	h := &handshake{
+		version:       5,
+		extension:     extensionHSREQ | extensionConfig,
+		initialSeq:    100,
+		handshakeType: handshakeConclusion,
+		socketID:      1,
+		cookie:        2,
+		peerIP:        net.IPv4(127, 0, 0, 1),
+		srtExtension:  extensionTypeHSREQ,
+		srtVersion:    srtVersion,
+		srtFlags:      defaultSRTFlags,
+		recvDelay:     120,
+		sendDelay:     200,
+		streamID:      "#!::r=live/test,m=publish",
+	}
+
+	data := h.marshal()
+	// 127.0.0.1按照主机字节序, stream id每4个字节反转
+	utils.Assert(bytes.Equal(data[32:36], []byte{1, 0, 0, 127}))
+	utils.Assert(bytes.Contains(data, []byte("::!#")))
+
+	parsed, err := parseHandshake(data)
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(parsed.streamID == h.streamID && parsed.recvDelay == 120 && parsed.sendDelay == 200 && parsed.peerIP.Equal(h.peerIP))
+
+	// 连续的序号压缩为范围
+	losses := []uint32{1, 2, 3, 5, seqMask, 0}
+	list := appendLossList(nil, losses)
+	utils.Assert(len(list) == 20 && binary.BigEndian.Uint32(list) == 0x80000001 && binary.BigEndian.Uint32(list[8:]) == 5)
+	parsedLosses := parseLossList(list)
+	utils.Assert(len(parsedLosses) == len(losses) && parsedLosses[4] == seqMask && parsedLosses[5] == 0)
+	utils.Assert(seqDiff(0, seqMask) == 1 && seqDiff(seqMask, 0) == -1)
+}
+
+// 建立连接, 呼叫端每个包的第一次发送按照drop丢弃, 重传的包必须保持原时间戳
+func dialTest(streamID string, drop func(seq uint32) bool) (*Listener, *Conn, *Conn) {
+	listener, err := Listen("127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	caller, err := Dial(listener.Addr().String(), streamID, 80*time.Millisecond)
+	if err != nil {
+		panic(err)
+	}
+
+	accepted, err := listener.Accept()
+	if err != nil {
+		panic(err)
+	}
+
+	sent := make(map[uint32]uint32)
+	caller.dropOutgoing = func(p *packet) bool {
+		timestamp, ok := sent[p.seq]
+		if ok {
+			utils.Assert(timestamp == p.timestamp && p.msg&msgRetransmitted != 0)
+			return false
+		}
+
+		sent[p.seq] = p.timestamp
+		return drop(p.seq)
+	}
+
+	return listener, caller, accepted
+}
+
+// 未被确认的包数量
+func (c *Conn) unacknowledged() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.sendBuffer)
+}
+
+func TestConn(t *testing.T) {
+	listener, caller, accepted := dialTest("live/test", func(seq uint32) bool {
+		return seq%5 == 0
+	})
+	defer listener.Close()
+
+	// 延迟取双方最大值
+	utils.Assert(accepted.StreamID() == "live/test" && accepted.Latency() == DefaultLatency && caller.Latency() == DefaultLatency)
+
+	go func() {
+		for i := 0; i < 200; i++ {
+			payload := bytes.Repeat([]byte{byte(i)}, PayloadSize)
+			binary.BigEndian.PutUint32(payload, uint32(i))
+			_, _ = caller.Write(payload)
+			time.Sleep(time.Millisecond)
+		}
+
+		// 等待重传完成后关闭
+		time.Sleep(300 * time.Millisecond)
+		_ = caller.Close()
+	}()
+
+	buffer := make([]byte, PayloadSize)
+	for i := 0; i < 200; i++ {
+		if _, err := io.ReadFull(accepted, buffer); err != nil {
+			panic(err)
+		}
+		utils.Assert(binary.BigEndian.Uint32(buffer) == uint32(i) && buffer[PayloadSize-1] == byte(i))
+	}
+
+	_, err := accepted.Read(buffer)
+	utils.Assert(err == io.EOF)
+	utils.Assert(caller.retransmits >= 40 && accepted.dropped == 0)
+}
+
+type testHandler struct {
+	mutex    sync.Mutex
+	tracks   []avformat.Track
+	packets  int
+	keyCount int
+}
+
+func (h *testHandler) OnNewTrack(track avformat.Track) {
+	h.tracks = append(h.tracks, track)
+}
+
+func (h *testHandler) OnTrackComplete() {
+}
+
+func (h *testHandler) OnTrackNotFind() {
+}
+
+func (h *testHandler) OnPacket(packet *avformat.AVPacket) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.packets++
+	if packet.Key {
+		h.keyCount++
+	}
+}
+
+func TestTS(t *testing.T) {
+	listener, caller, accepted := dialTest("", func(seq uint32) bool {
+		return seq%7 == 3
+	})
+	defer listener.Close()
+
+	handler := &testHandler{}
+	demuxer := mpegts.NewDemuxer()
+	demuxer.SetHandler(handler)
+	done := make(chan error, 1)
+	go func() {
+		done <- Demux(accepted, demuxer)
+	}()
+
+	// flv -> ts -> srt
+	record, _ := hex.DecodeString("0142c01effe100186742c01eda01e0089f961000000300100000030320f162ea01000568ce0f2c80")
+	writer := NewTSWriter(caller)
+	source := flv.NewDemuxer()
+	source.SetHandler(writer)
+	_ = source.InputVideo(append([]byte{0x17, 0, 0, 0, 0}, record...), 0)
+	for i := 0; i < 100; i++ {
+		frame := append([]byte{0x27, 1, 0, 0, 0, 0, 0, 1, 0, 0x41}, make([]byte, 255)...)
+		if i%25 == 0 {
+			frame = append([]byte{0x17, 1, 0, 0, 0, 0, 0, 1, 0, 0x65}, make([]byte, 255)...)
+		}
+
+		if err := source.InputVideo(frame, uint32(i*40)); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		panic(err)
+	}
+
+	// 等待所有包被确认后关闭, 接收端关闭时交付已经收到的包
+	deadline := time.Now().Add(5 * time.Second)
+	for caller.unacknowledged() > 0 {
+		if time.Now().After(deadline) {
+			panic("wait for srt ack timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_ = caller.Close()
+	if err := <-done; err != nil {
+		panic(err)
+	}
+
+	// 源和TS的demuxer各缓存最后一帧
+	utils.Assert(len(handler.tracks) == 1 && handler.tracks[0].GetStream().CodecID == utils.AVCodecIdH264)
+	utils.Assert(handler.packets == 98 && handler.keyCount == 4)
+}
@@ -0,0 +1,107 @@
+package srt
+
+import (
+	"github.com/lkmio/avformat"
+	"github.com/lkmio/avformat/mpegts"
+	"github.com/lkmio/avformat/utils"
+	"io"
+)
+
+// Demux 从连接中读取TS流输入到demuxer, 直到连接断开, 对端正常关闭时返回nil
+func Demux(conn *Conn, demuxer *mpegts.Demuxer) error {
+	buffer := make([]byte, PayloadSize*8)
+	var size int
+	for {
+		n, err := conn.Read(buffer[size:])
+		if err == io.EOF {
+			demuxer.Flush()
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		size += n
+		consumed, err := demuxer.Input(buffer[:size])
+		if err != nil {
+			return err
+		}
+
+		size = copy(buffer, buffer[consumed:size])
+	}
+}
+
+// TSWriter 实现OnUnpackStreamHandler, 封装为TS后通过SRT发送, 每个关键帧前重复PAT/PMT
+type TSWriter struct {
+	conn     *Conn
+	muxer    *mpegts.Muxer
+	tracks   avformat.TrackManager
+	indexes  []int // track索引对应的muxer索引, 不支持的编码为-1
+	buffer   []byte
+	complete bool
+}
+
+func (w *TSWriter) OnNewTrack(track avformat.Track) {
+	if !w.complete {
+		w.tracks.Add(track)
+	}
+}
+
+func (w *TSWriter) OnTrackComplete() {
+	w.complete = true
+	for _, track := range w.tracks.Tracks {
+		index, err := w.muxer.AddTrack(track.GetStream())
+		if err != nil {
+			println(err.Error())
+			index = -1
+		}
+
+		w.indexes = append(w.indexes, index)
+	}
+
+	w.buffer = w.muxer.AppendHeader(w.buffer)
+}
+
+func (w *TSWriter) OnTrackNotFind() {
+	_ = w.conn.Close()
+}
+
+func (w *TSWriter) OnPacket(packet *avformat.AVPacket) {
+	if !w.complete || packet.Index >= len(w.indexes) || w.indexes[packet.Index] < 0 {
+		return
+	}
+
+	if utils.AVMediaTypeVideo == packet.MediaType && packet.Key {
+		w.buffer = w.muxer.AppendHeader(w.buffer)
+	}
+
+	var err error
+	if w.buffer, err = w.muxer.AppendAVPacket(w.buffer, w.indexes[packet.Index], packet); err != nil {
+		println(err.Error())
+		return
+	}
+
+	// 只发送完整的数据包, 剩余的和下一帧一起发送
+	n := len(w.buffer) / PayloadSize * PayloadSize
+	if n == 0 {
+		return
+	} else if _, err = w.conn.Write(w.buffer[:n]); err != nil {
+		println(err.Error())
+	}
+
+	w.buffer = w.buffer[:copy(w.buffer, w.buffer[n:])]
+}
+
+// Flush 发送剩余的TS包
+func (w *TSWriter) Flush() error {
+	if len(w.buffer) == 0 {
+		return nil
+	}
+
+	_, err := w.conn.Write(w.buffer)
+	w.buffer = w.buffer[:0]
+	return err
+}
+
+func NewTSWriter(conn *Conn) *TSWriter {
+	return &TSWriter{conn: conn, muxer: mpegts.NewMuxer()}
+}
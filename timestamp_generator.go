@@ -0,0 +1,283 @@
+package avformat
+
+import (
+	"fmt"
+	"github.com/lkmio/avformat/avc"
+	"github.com/lkmio/avformat/bufio"
+	"github.com/lkmio/avformat/hevc"
+	"github.com/lkmio/avformat/utils"
+)
+
+// TimestampGenerator 为只携带一个时间戳或没有时间戳的H264/H265帧生成DTS和PTS.
+// 根据POC计算显示顺序, 根据SPS的最大重排序帧数计算DTS和PTS的差值.
+// H264的SPS没有bitstream_restriction时, 推导出的重排序帧数不可靠, 根据实际的POC顺序推导.
+type TimestampGenerator struct {
+	timebase   int
+	lengthSize int // AVCC打包时NALU长度前缀的字节数
+
+	avcSets  *avc.ParameterSets
+	hevcSets *hevc.ParameterSets
+	avcPoc   avc.PicOrderCounter
+	hevcPoc  hevc.PicOrderCounter
+
+	pocStep  int   // 相邻帧的POC间隔, H264一般为2
+	basePoc  int   // 最近一次POC重置时的POC
+	count    int   // POC重置后的解码顺序
+	duration int64 // 根据输入时间戳估算的帧间隔, SPS携带帧率时优先使用帧率
+	prevTs   int64 // 上一帧输入的时间戳
+	prevDts  int64
+	nextDts  int64 // 没有时间戳时, 下一帧的DTS
+	started  bool
+
+	pocs    []int // POC重置后最近解码的POC, 用于推导重排序深度
+	reorder int   // 根据POC顺序推导出的重排序深度
+}
+
+// 推导重排序深度时保留的POC数量, 大于最大DPB容量即可
+const maxObservedPocs = 32
+
+// frameInfo 从帧中解析出的图像信息
+type frameInfo struct {
+	poc       int
+	reset     bool // POC是否重置
+	reorder   int  // 小于0表示SPS没有给出可靠的值
+	frameRate float64
+}
+
+func NewTimestampGenerator(codecId utils.AVCodecID, timebase int) *TimestampGenerator {
+	g := &TimestampGenerator{timebase: timebase, lengthSize: 4, pocStep: 1}
+	if utils.AVCodecIdH264 == codecId {
+		g.avcSets = avc.NewParameterSets()
+		g.pocStep = 2
+	} else {
+		g.hevcSets = hevc.NewParameterSets()
+	}
+	return g
+}
+
+// SetLengthSize 设置AVCC打包时NALU长度前缀的字节数, 来自avcC/hvcC的lengthSizeMinusOne
+func (g *TimestampGenerator) SetLengthSize(size int) {
+	if size >= 1 && size <= 4 {
+		g.lengthSize = size
+	}
+}
+
+// SetExtraData 输入AnnexB格式的参数集
+func (g *TimestampGenerator) SetExtraData(data []byte) error {
+	var err error
+	avc.SplitNalU(data, func(nalu []byte) {
+		if err != nil {
+			return
+		} else if g.avcSets != nil {
+			err = g.avcSets.Input(nalu)
+		} else {
+			err = g.hevcSets.Input(nalu)
+		}
+	})
+	return err
+}
+
+// Generate 生成packet的DTS和PTS, 值为-1的时间戳视为缺失.
+// 只有PTS时, DTS = PTS - CTS; 只有DTS时, PTS = DTS + CTS; 都没有时按照帧率生成DTS.
+func (g *TimestampGenerator) Generate(packet *AVPacket) error {
+	info, err := g.parse(packet)
+	if err != nil {
+		return err
+	}
+
+	hasDts := packet.Dts != int64(DTSUndefined)
+	hasPts := packet.Pts != int64(PTSUndefined)
+	if hasDts && hasPts {
+		return nil
+	}
+
+	// 估算帧间隔, DTS的差值即为帧间隔, PTS取最小的正差值
+	if hasDts && g.started && packet.Dts > g.prevTs {
+		g.duration = packet.Dts - g.prevTs
+	} else if hasPts && g.started && packet.Pts > g.prevTs && (g.duration == 0 || packet.Pts-g.prevTs < g.duration) {
+		g.duration = packet.Pts - g.prevTs
+	}
+
+	duration := g.duration
+	if info.frameRate > 0 {
+		duration = int64(float64(g.timebase)/info.frameRate + 0.5)
+	} else if duration == 0 {
+		duration = int64(g.timebase / 25)
+	}
+
+	// 从非IDR开始时, 以第一帧的POC为起点
+	if info.reset || !g.started {
+		g.basePoc = info.poc
+		g.count = 0
+		g.pocs = g.pocs[:0]
+	}
+	poc := info.poc - g.basePoc
+	if poc%g.pocStep != 0 {
+		g.pocStep = 1
+	}
+
+	reorder := info.reorder
+	if reorder < 0 {
+		reorder = g.observeReorder(poc)
+	}
+
+	// 显示顺序和解码顺序的差值加上重排序深度, 即为CTS包含的帧数.
+	// 没有重排序时DTS等于PTS, 避免丢帧导致POC不连续时产生CTS
+	var offset int
+	if reorder > 0 {
+		offset = bufio.MaxInt(poc/g.pocStep-g.count+reorder, 0)
+	}
+	cts := int64(offset) * duration
+
+	if hasPts {
+		g.prevTs = packet.Pts
+		packet.Dts = packet.Pts - cts
+	} else if hasDts {
+		g.prevTs = packet.Dts
+		packet.Pts = packet.Dts + cts
+	} else {
+		packet.Dts = g.nextDts
+		packet.Pts = packet.Dts + cts
+	}
+
+	// 保证DTS单调递增, 不为负, 并且不大于PTS.
+	// 只有PTS时, 推导出的重排序深度变大会使两者冲突, 优先保证DTS不大于PTS
+	if g.started && packet.Dts <= g.prevDts {
+		packet.Dts = g.prevDts + 1
+	}
+	if packet.Dts < 0 {
+		packet.Dts = 0
+	}
+	if hasPts && packet.Dts > packet.Pts {
+		packet.Dts = packet.Pts
+	} else if packet.Pts < packet.Dts {
+		packet.Pts = packet.Dts
+	}
+
+	g.started = true
+	g.prevDts = packet.Dts
+	g.nextDts = packet.Dts + duration
+	g.count++
+	return nil
+}
+
+// observeReorder 统计解码顺序在前, 显示顺序在后的帧数, 返回观察到的最大值
+func (g *TimestampGenerator) observeReorder(poc int) int {
+	var depth int
+	for _, p := range g.pocs {
+		if p > poc {
+			depth++
+		}
+	}
+
+	if len(g.pocs) == maxObservedPocs {
+		g.pocs = append(g.pocs[:0], g.pocs[1:]...)
+	}
+	g.pocs = append(g.pocs, poc)
+	g.reorder = bufio.MaxInt(g.reorder, depth)
+	return g.reorder
+}
+
+// parse 输入帧内的参数集, 并根据第一个slice计算POC
+func (g *TimestampGenerator) parse(packet *AVPacket) (frameInfo, error) {
+	var info frameInfo
+	var found bool
+	var err error
+
+	forEachNalU(packet, g.lengthSize, func(nalu []byte) bool {
+		nalu = avc.RemoveStartCode(nalu)
+		if len(nalu) == 0 {
+			return true
+		}
+
+		if g.avcSets != nil {
+			found, err = g.parseAVC(nalu, &info)
+		} else {
+			found, err = g.parseHEVC(nalu, &info)
+		}
+		return err == nil && !found
+	})
+
+	if err != nil {
+		return info, err
+	} else if !found {
+		return info, fmt.Errorf("no slice found")
+	}
+	return info, nil
+}
+
+func (g *TimestampGenerator) parseAVC(nalu []byte, info *frameInfo) (bool, error) {
+	switch nalu[0] & 0x1F {
+	case avc.H264NalSPS, avc.H264NalPPS:
+		return false, g.avcSets.Input(nalu)
+	case avc.H264NalSlice, avc.H264NalIDRSlice:
+		break
+	default:
+		return false, nil
+	}
+
+	header, sps, err := avc.ParseSliceHeader(nalu, g.avcSets)
+	if err != nil {
+		return false, err
+	}
+
+	info.poc = g.avcPoc.Calculate(header, sps)
+	info.reset = header.IsIDR()
+	// 缺省推导出的值为MaxDpbFrames, 远大于实际的重排序深度
+	info.reorder = -1
+	if sps.VUI.BitstreamRestrictionFlag != 0 {
+		info.reorder = int(sps.VUI.MaxNumReorderFrames)
+	}
+	info.frameRate = sps.FrameRate
+	return true, nil
+}
+
+func (g *TimestampGenerator) parseHEVC(nalu []byte, info *frameInfo) (bool, error) {
+	t := hevc.HEVCNALUnitType(nalu[0] >> 1 & 0x3F)
+	if hevc.HevcNalVPS == t || hevc.HevcNalSPS == t || hevc.HevcNalPPS == t {
+		return false, g.hevcSets.Input(nalu)
+	} else if t > hevc.HevcNalRsvIRAPVCL23 {
+		return false, nil
+	}
+
+	header, sps, err := hevc.ParseSliceHeader(nalu, g.hevcSets)
+	if err != nil {
+		return false, err
+	}
+
+	info.poc = g.hevcPoc.Calculate(header, sps)
+	info.reset = header.IsIDR() || header.IsBLA()
+	if n := len(sps.MaxNumReorderPics); n > 0 {
+		info.reorder = int(sps.MaxNumReorderPics[n-1])
+	}
+	info.frameRate = sps.FrameRate
+	return true, nil
+}
+
+// forEachNalU 遍历AnnexB或lengthSize字节长度前缀的NALU, 回调返回false时停止
+func forEachNalU(packet *AVPacket, lengthSize int, cb func(nalu []byte) bool) {
+	data := packet.Data
+	if PacketTypeAVCC != packet.PacketType {
+		stopped := false
+		avc.SplitNalU(data, func(nalu []byte) {
+			if !stopped {
+				stopped = !cb(nalu)
+			}
+		})
+		return
+	}
+
+	for len(data) > lengthSize {
+		var size int
+		for _, b := range data[:lengthSize] {
+			size = size<<8 | int(b)
+		}
+
+		if size > len(data)-lengthSize {
+			return
+		} else if !cb(data[lengthSize : lengthSize+size]) {
+			return
+		}
+		data = data[lengthSize+size:]
+	}
+}
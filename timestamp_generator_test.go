@@ -0,0 +1,263 @@
+package avformat
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/lkmio/avformat/bufio"
+	"github.com/lkmio/avformat/hevc"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+func writeUE(w *bufio.BitsWriter, v uint64) {
+	length := 0
+	for tmp := v + 1; tmp > 1; tmp >>= 1 {
+		length++
+	}
+	w.Write(length, 0)
+	w.Write(length+1, v+1)
+}
+
+// 构造HEVC slice, 只包含slice segment头
+func newHEVCSlice(nalType hevc.HEVCNALUnitType, sliceType hevc.HEVCSliceType, poc uint64) []byte {
+	w := &bufio.BitsWriter{Data: make([]byte, 32)}
+	w.Write(32, 1)
+	w.Write(16, uint64(nalType)<<9|1)
+	w.Write(1, 1) // first_slice_segment_in_pic_flag
+	if hevc.HevcNalIdrWRADL == nalType {
+		w.Write(1, 0)
+	}
+	writeUE(w, 0)
+	writeUE(w, uint64(sliceType))
+	if hevc.HevcNalIdrWRADL != nalType {
+		w.Write(8, poc)
+		w.Write(1, 0)
+		writeUE(w, 1)
+		writeUE(w, 0)
+		writeUE(w, 0)
+		w.Write(2, 3)
+	}
+	w.Write(16, 0xFFFF)
+	return w.Data[:(w.Offset+7)/8]
+}
+
+func TestTimestampGenerator(t *testing.T) {
+	extra, _ := hex.DecodeString("0000000140010c01ffff01600000030090000003000003005d9998090000000142010101600000030090000003000003005da00280802d165999a4932b9a808080820000030002000003003210000000014401c172b46240")
+
+	// 解码顺序I P B B P B B, SPS的sps_max_num_reorder_pics为2, 帧率25
+	pocs := []uint64{0, 3, 1, 2, 6, 4, 5}
+	frames := make([][]byte, len(pocs))
+	for i, poc := range pocs {
+		if i == 0 {
+			frames[i] = newHEVCSlice(hevc.HevcNalIdrWRADL, hevc.HevcSliceI, 0)
+		} else if poc%3 == 0 {
+			frames[i] = newHEVCSlice(hevc.HevcNalTrailR, hevc.HevcSliceP, poc)
+		} else {
+			frames[i] = newHEVCSlice(hevc.HevcNalTrailN, hevc.HevcSliceB, poc)
+		}
+	}
+	cts := []int64{2, 4, 1, 1, 4, 1, 1}
+
+	// 只有PTS
+	generator := NewTimestampGenerator(utils.AVCodecIdH265, 90000)
+	if err := generator.SetExtraData(extra); err != nil {
+		panic(err)
+	}
+	for i, frame := range frames {
+		packet := &AVPacket{Data: frame, PacketType: PacketTypeAnnexB, Dts: int64(DTSUndefined), Pts: 10000 + int64(pocs[i])*3600}
+		if err := generator.Generate(packet); err != nil {
+			panic(err)
+		}
+		utils.Assert(packet.Pts-packet.Dts == cts[i]*3600 && packet.Dts == 2800+int64(i)*3600)
+	}
+
+	// 只有DTS, 参数集在帧内, AVCC打包
+	generator = NewTimestampGenerator(utils.AVCodecIdH265, 90000)
+	for i, frame := range frames {
+		if i == 0 {
+			frame = append(append([]byte{}, extra...), frame...)
+		}
+
+		var data []byte
+		splitAnnexB(frame, func(nalu []byte) {
+			data = binary.BigEndian.AppendUint32(data, uint32(len(nalu)))
+			data = append(data, nalu...)
+		})
+
+		packet := &AVPacket{Data: data, PacketType: PacketTypeAVCC, Dts: int64(i) * 3600, Pts: int64(PTSUndefined)}
+		if err := generator.Generate(packet); err != nil {
+			panic(err)
+		}
+		utils.Assert(packet.Pts-packet.Dts == cts[i]*3600 && packet.Dts == int64(i)*3600)
+	}
+
+	// hvcC的lengthSizeMinusOne为1, 2字节长度前缀
+	generator = NewTimestampGenerator(utils.AVCodecIdH265, 90000)
+	generator.SetLengthSize(2)
+	_ = generator.SetExtraData(extra)
+	for i, frame := range frames {
+		var data []byte
+		splitAnnexB(frame, func(nalu []byte) {
+			data = binary.BigEndian.AppendUint16(data, uint16(len(nalu)))
+			data = append(data, nalu...)
+		})
+
+		packet := &AVPacket{Data: data, PacketType: PacketTypeAVCC, Dts: int64(i) * 3600, Pts: int64(PTSUndefined)}
+		if err := generator.Generate(packet); err != nil {
+			panic(err)
+		}
+		utils.Assert(packet.Pts-packet.Dts == cts[i]*3600)
+	}
+
+	// 没有时间戳, 按照SPS帧率生成
+	generator = NewTimestampGenerator(utils.AVCodecIdH265, 1000)
+	_ = generator.SetExtraData(extra)
+	for i, frame := range frames {
+		packet := &AVPacket{Data: frame, PacketType: PacketTypeAnnexB, Dts: int64(DTSUndefined), Pts: int64(PTSUndefined)}
+		if err := generator.Generate(packet); err != nil {
+			panic(err)
+		}
+		utils.Assert(packet.Dts == int64(i)*40 && packet.Pts == packet.Dts+cts[i]*40)
+	}
+
+	// 缺少参数集
+	generator = NewTimestampGenerator(utils.AVCodecIdH265, 90000)
+	utils.Assert(generator.Generate(&AVPacket{Data: frames[0], PacketType: PacketTypeAnnexB, Dts: int64(DTSUndefined), Pts: 0}) != nil)
+}
+
+// 构造High Profile的SPS和PPS, 没有VUI, frame_num和pic_order_cnt_lsb都为8位
+func newAVCHighParameterSets() []byte {
+	w := &bufio.BitsWriter{Data: make([]byte, 64)}
+	w.Write(32, 1)
+	w.Write(8, 0x67)
+	w.Write(8, 100)
+	w.Write(8, 0)
+	w.Write(8, 30)
+	writeUE(w, 0) // seq_parameter_set_id
+	writeUE(w, 1) // chroma_format_idc
+	writeUE(w, 0) // bit_depth_luma_minus8
+	writeUE(w, 0) // bit_depth_chroma_minus8
+	w.Write(2, 0) // qpprime_y_zero_transform_bypass_flag, seq_scaling_matrix_present_flag
+	writeUE(w, 4) // log2_max_frame_num_minus4
+	writeUE(w, 0) // pic_order_cnt_type
+	writeUE(w, 4) // log2_max_pic_order_cnt_lsb_minus4
+	writeUE(w, 4)
+	w.Write(1, 0)
+	writeUE(w, 19)
+	writeUE(w, 14)
+	w.Write(3, 6)
+	w.Write(1, 0) // vui_parameters_present_flag
+	w.Write(1, 1)
+	w.Offset = (w.Offset + 7) / 8 * 8
+
+	w.Write(32, 1)
+	w.Write(8, 0x68)
+	writeUE(w, 0)
+	writeUE(w, 0)
+	w.Write(2, 0)
+	writeUE(w, 0)
+	writeUE(w, 0)
+	writeUE(w, 0)
+	w.Write(3, 0)
+	writeUE(w, 0) // pic_init_qp_minus26, pic_init_qs_minus26, chroma_qp_index_offset
+	writeUE(w, 0)
+	writeUE(w, 0)
+	w.Write(3, 4)
+	w.Write(1, 1)
+	return w.Data[:(w.Offset+7)/8]
+}
+
+// 构造H264 slice, 只包含slice头
+func newAVCSlice(sliceType, frameNum, pocLsb uint64) []byte {
+	w := &bufio.BitsWriter{Data: make([]byte, 32)}
+	idr := sliceType == 7
+	refIdc, nalType := uint64(2), uint64(1)
+	if idr {
+		nalType = 5
+	} else if sliceType == 6 {
+		refIdc = 0
+	}
+
+	w.Write(32, 1)
+	w.Write(8, refIdc<<5|nalType)
+	writeUE(w, 0)
+	writeUE(w, sliceType)
+	writeUE(w, 0)
+	w.Write(8, frameNum)
+	if idr {
+		writeUE(w, 0) // idr_pic_id
+	}
+	w.Write(8, pocLsb)
+	if sliceType == 6 {
+		w.Write(1, 0) // direct_spatial_mv_pred_flag
+	}
+	if sliceType != 7 {
+		w.Write(2, 0) // num_ref_idx_active_override_flag, ref_pic_list_modification_flag_l0
+	}
+	if sliceType == 6 {
+		w.Write(1, 0)
+	}
+	if idr {
+		w.Write(2, 0)
+	} else if refIdc != 0 {
+		w.Write(1, 0)
+	}
+	w.Write(16, 0xFFFF)
+	return w.Data[:(w.Offset+7)/8]
+}
+
+func TestTimestampGeneratorWithoutBitstreamRestriction(t *testing.T) {
+	extra := newAVCHighParameterSets()
+
+	// 只有P帧, DTS等于PTS. 推导出的max_num_reorder_frames为MaxDpbFrames, 不能使用
+	generator := NewTimestampGenerator(utils.AVCodecIdH264, 90000)
+	if err := generator.SetExtraData(extra); err != nil {
+		panic(err)
+	}
+	for i := 0; i < 40; i++ {
+		frame := newAVCSlice(5, uint64(i), uint64(i*2))
+		if i == 0 {
+			frame = newAVCSlice(7, 0, 0)
+		}
+
+		packet := &AVPacket{Data: frame, PacketType: PacketTypeAnnexB, Dts: int64(DTSUndefined), Pts: int64(i) * 3600}
+		if err := generator.Generate(packet); err != nil {
+			panic(err)
+		}
+		utils.Assert(packet.Dts == packet.Pts)
+	}
+
+	// 解码顺序I P B B P B B, 根据POC顺序推导出重排序深度为1
+	pocs := []uint64{0, 3, 1, 2, 6, 4, 5, 9, 7, 8}
+	generator = NewTimestampGenerator(utils.AVCodecIdH264, 90000)
+	_ = generator.SetExtraData(extra)
+	prevDts := int64(-1)
+	for i, poc := range pocs {
+		frame := newAVCSlice(6, uint64(i), poc*2)
+		if i == 0 {
+			frame = newAVCSlice(7, 0, 0)
+		} else if poc%3 == 0 {
+			frame = newAVCSlice(5, uint64(i), poc*2)
+		}
+
+		packet := &AVPacket{Data: frame, PacketType: PacketTypeAnnexB, Dts: int64(DTSUndefined), Pts: int64(poc) * 3600}
+		if err := generator.Generate(packet); err != nil {
+			panic(err)
+		}
+		utils.Assert(packet.Dts >= 0 && packet.Dts <= packet.Pts)
+
+		// 观察到B帧后, DTS单调递增, 并且CTS只包含一帧的延迟
+		if i > 2 {
+			utils.Assert(packet.Dts > prevDts && packet.Dts == int64(i-1)*3600)
+		}
+		prevDts = packet.Dts
+	}
+}
+
+// splitAnnexB 拆分AnnexB, 回调的NALU不包含起始码
+func splitAnnexB(data []byte, cb func(nalu []byte)) {
+	forEachNalU(&AVPacket{Data: data, PacketType: PacketTypeAnnexB}, 4, func(nalu []byte) bool {
+		cb(nalu[4:])
+		return true
+	})
+}
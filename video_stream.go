@@ -3,9 +3,13 @@ package avformat
 import (
 	"encoding/hex"
 	"fmt"
+	"github.com/lkmio/avformat/ac3"
+	"github.com/lkmio/avformat/av1"
 	"github.com/lkmio/avformat/avc"
 	"github.com/lkmio/avformat/hevc"
+	"github.com/lkmio/avformat/opus"
 	"github.com/lkmio/avformat/utils"
+	"github.com/lkmio/avformat/vvc"
 )
 
 // CreateHevcStreamFromKeyFrame 从关键帧中提取sps和pps创建AVStream
@@ -55,6 +59,26 @@ func ExtractVideoExtraDataFromKeyFrame(codec utils.AVCodecID, data []byte) ([]by
 		}
 
 		return append(append(vps, sps...), pps...), nil
+	} else if utils.AVCodecIdH266 == codec {
+		vps, sps, pps, err := vvc.ParseExtraDataFromKeyNALU(data)
+		if err != nil {
+			fmt.Printf("从关键帧中解析vps sps pps失败  data:%s \r\n", hex.EncodeToString(data))
+			return nil, err
+		}
+
+		return append(append(vps, sps...), pps...), nil
+	} else if utils.AVCodecIdAV1 == codec {
+		if sequenceHeader := av1.FindSequenceHeader(data); sequenceHeader != nil {
+			return sequenceHeader, nil
+		}
+		return nil, fmt.Errorf("sequence header not found")
+	} else if utils.AVCodecIdVP8 == codec || utils.AVCodecIdVP9 == codec {
+		// 没有参数集, 使用关键帧生成的vpcC
+		codecData, err := NewVPCodecData(codec, data)
+		if err != nil {
+			return nil, err
+		}
+		return codecData.MP4ExtraData(), nil
 	}
 
 	return nil, nil
@@ -94,6 +118,50 @@ func ExtractAudioExtraData(codec utils.AVCodecID, data []byte) ([]byte, int, Aud
 			Channels:      header.Channel(),
 			HasADTSHeader: true,
 		}, nil
+	} else if utils.AVCodecIdOPUS == codec {
+		// 携带OpusHead时直接使用, 否则根据TOC生成
+		head, err := opus.ParseOpusHead(data)
+		if err != nil {
+			if len(data) < 1 {
+				return nil, -1, AudioConfig{}, fmt.Errorf("need more data")
+			}
+
+			channels := 1
+			if opus.ParseTOC(data[0]).Stereo {
+				channels = 2
+			}
+			head = opus.NewOpusHead(channels, opus.SampleRate)
+		}
+
+		return head.Marshal(), 0, AudioConfig{
+			SampleRate: opus.SampleRate,
+			SampleSize: 16,
+			Channels:   int(head.Channels),
+		}, nil
+	} else if utils.AVCodecIdAC3 == codec {
+		header, err := ac3.ParseHeader(data)
+		if err != nil {
+			return nil, -1, AudioConfig{}, err
+		}
+
+		return ac3.NewAC3SpecificBox(&header).Marshal(), 0, AudioConfig{
+			SampleRate: header.SampleRate,
+			SampleSize: 16,
+			Channels:   header.Channels(),
+		}, nil
+	} else if utils.AVCodecIdEAC3 == codec {
+		// 声道数包含依赖子流扩展的声道
+		box, err := ac3.NewEC3SpecificBox(data)
+		if err != nil {
+			return nil, -1, AudioConfig{}, err
+		}
+
+		header, _ := ac3.ParseHeader(data)
+		return box.Marshal(), 0, AudioConfig{
+			SampleRate: header.SampleRate,
+			SampleSize: 16,
+			Channels:   box.Channels(),
+		}, nil
 	} else if utils.AVCodecIdPCMALAW == codec || utils.AVCodecIdPCMMULAW == codec {
 
 	}
@@ -171,6 +239,18 @@ func ExtractAudioPacket(codec utils.AVCodecID, data []byte, ts int64, index, tim
 		//}
 
 		packet = NewAudioPacket(data[skip:], ts, codec, index, timebase)
+	} else if utils.AVCodecIdOPUS == codec {
+		packet = NewAudioPacket(data, ts, codec, index, timebase)
+		// 根据TOC计算时长, 无需等待下一个包
+		if duration, err := opus.PacketDuration(data, timebase); err == nil {
+			packet.Duration = duration
+		}
+	} else if utils.AVCodecIdAC3 == codec || utils.AVCodecIdEAC3 == codec {
+		packet = NewAudioPacket(data, ts, codec, index, timebase)
+		// 采样数固定, 由帧头计算时长
+		if header, err := ac3.ParseHeader(data); err == nil {
+			packet.Duration = header.Duration(timebase)
+		}
 	} else /*if utils.AVCodecIdPCMALAW == codec || utils.AVCodecIdPCMMULAW == codec*/ {
 
 		packet = NewAudioPacket(data, ts, codec, index, timebase)
@@ -180,12 +260,19 @@ func ExtractAudioPacket(codec utils.AVCodecID, data []byte, ts int64, index, tim
 }
 
 func generateVideoCodecData(packType PacketType, id utils.AVCodecID, data []byte) (CodecData, error) {
-	if packType == PacketTypeAVCC {
+	if utils.AVCodecIdVP8 == id || utils.AVCodecIdVP9 == id {
+		// 两种打包方式都使用vpcC
+		return ParseVPCodecConfigurationRecord(data)
+	} else if packType == PacketTypeAVCC {
 		switch id {
 		case utils.AVCodecIdH264:
 			return ParseAVCDecoderConfigurationRecord(data)
 		case utils.AVCodecIdH265:
 			return ParseHEVCDecoderConfigurationRecord(data)
+		case utils.AVCodecIdH266:
+			return ParseVVCDecoderConfigurationRecord(data)
+		case utils.AVCodecIdAV1:
+			return ParseAV1CodecConfigurationRecord(data)
 		}
 	} else {
 		switch id {
@@ -201,6 +288,14 @@ func generateVideoCodecData(packType PacketType, id utils.AVCodecID, data []byte
 				return nil, err
 			}
 			return NewHEVCCodecData(vps, sps, pps)
+		case utils.AVCodecIdH266:
+			vps, sps, pps, err := vvc.ParseExtraDataFromKeyNALU(data)
+			if err != nil {
+				return nil, err
+			}
+			return NewVVCCodecData(vps, sps, pps)
+		case utils.AVCodecIdAV1:
+			return NewAV1CodecData(av1.FindSequenceHeader(data))
 		}
 	}
 
@@ -0,0 +1,52 @@
+package vp8
+
+import (
+	"fmt"
+)
+
+// FrameHeader 帧头, 参考RFC 6386 9.1. 只有关键帧携带宽高
+type FrameHeader struct {
+	KeyFrame        bool
+	Version         byte
+	ShowFrame       bool
+	FirstPartSize   int
+	Width           int
+	Height          int
+	HorizontalScale byte
+	VerticalScale   byte
+}
+
+// ParseFrameHeader 解析frame tag, 关键帧继续解析start code和宽高
+func ParseFrameHeader(data []byte) (FrameHeader, error) {
+	var h FrameHeader
+	if len(data) < 3 {
+		return h, fmt.Errorf("invalid vp8 frame size %d", len(data))
+	}
+
+	tag := int(data[0]) | int(data[1])<<8 | int(data[2])<<16
+	h.KeyFrame = tag&0x1 == 0
+	h.Version = byte(tag >> 1 & 0x7)
+	h.ShowFrame = tag>>4&0x1 != 0
+	h.FirstPartSize = tag >> 5 & 0x7FFFF
+	if !h.KeyFrame {
+		return h, nil
+	}
+
+	if len(data) < 10 {
+		return h, fmt.Errorf("invalid vp8 key frame size %d", len(data))
+	} else if data[3] != 0x9D || data[4] != 0x01 || data[5] != 0x2A {
+		return h, fmt.Errorf("invalid vp8 start code %x", data[3:6])
+	}
+
+	width := int(data[6]) | int(data[7])<<8
+	height := int(data[8]) | int(data[9])<<8
+	h.Width, h.HorizontalScale = width&0x3FFF, byte(width>>14)
+	h.Height, h.VerticalScale = height&0x3FFF, byte(height>>14)
+	return h, nil
+}
+
+// IsKeyFrame frame tag的第一位为0并且携带start code
+func IsKeyFrame(data []byte) bool {
+	header, err := ParseFrameHeader(data)
+	return err == nil && header.KeyFrame
+}
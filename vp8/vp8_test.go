@@ -0,0 +1,22 @@
+package vp8
+
+import (
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+func TestFrameHeader(t *testing.T) {
+	// 640x480, 水平缩放1
+	key := []byte{0x50, 0x42, 0x00, 0x9D, 0x01, 0x2A, 0x80, 0x42, 0xE0, 0x01}
+	header, err := ParseFrameHeader(key)
+	if err != nil {
+		panic(err)
+	}
+
+	utils.Assert(header.KeyFrame && header.ShowFrame && header.Version == 0 && header.FirstPartSize == 0x212)
+	utils.Assert(header.Width == 640 && header.Height == 480 && header.HorizontalScale == 1 && header.VerticalScale == 0)
+
+	utils.Assert(IsKeyFrame(key) && !IsKeyFrame([]byte{0x51, 0x42, 0x00}))
+	_, err = ParseFrameHeader([]byte{0x50, 0x42, 0x00, 0x00, 0x00, 0x00, 0x80, 0x02, 0xE0, 0x01})
+	utils.Assert(err != nil)
+}
@@ -0,0 +1,144 @@
+package vp9
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+aligned (8) class VPCodecConfigurationRecord {
+unsigned int(8) profile;
+unsigned int(8) level;
+unsigned int(4) bitDepth;
+unsigned int(3) chromaSubsampling;
+unsigned int(1) videoFullRangeFlag;
+unsigned int(8) colourPrimaries;
+unsigned int(8) transferCharacteristics;
+unsigned int(8) matrixCoefficients;
+unsigned int(16) codecIntializationDataSize;
+unsigned int(8)[] codecIntializationData;
+}
+*/
+
+const (
+	ChromaSubsampling420Vertical  = 0
+	ChromaSubsampling420Colocated = 1
+	ChromaSubsampling422          = 2
+	ChromaSubsampling444          = 3
+)
+
+// 各等级允许的最大亮度采样数, 参考VP9 Levels and Decoder Testing
+var levels = []struct {
+	level       byte
+	pictureSize int
+}{
+	{10, 36864},
+	{11, 73728},
+	{20, 122880},
+	{21, 245760},
+	{30, 552960},
+	{31, 983040},
+	{40, 2228224},
+	{50, 8912896},
+	{60, 35651584},
+}
+
+// VPCodecConfigurationRecord vpcC, VP8和VP9共用. 序列化时包含FullBox的version和flags
+type VPCodecConfigurationRecord struct {
+	Version                 byte
+	Profile                 byte
+	Level                   byte
+	BitDepth                byte
+	ChromaSubsampling       byte
+	VideoFullRangeFlag      byte
+	ColourPrimaries         byte
+	TransferCharacteristics byte
+	MatrixCoefficients      byte
+	CodecInitializationData []byte
+}
+
+func (v *VPCodecConfigurationRecord) Marshal() []byte {
+	data := make([]byte, 12, 12+len(v.CodecInitializationData))
+	data[0] = 1
+	data[4] = v.Profile
+	data[5] = v.Level
+	data[6] = v.BitDepth<<4 | (v.ChromaSubsampling&0x7)<<1 | v.VideoFullRangeFlag&0x1
+	data[7] = v.ColourPrimaries
+	data[8] = v.TransferCharacteristics
+	data[9] = v.MatrixCoefficients
+	binary.BigEndian.PutUint16(data[10:], uint16(len(v.CodecInitializationData)))
+	return append(data, v.CodecInitializationData...)
+}
+
+func (v *VPCodecConfigurationRecord) Unmarshal(data []byte) error {
+	if len(data) < 12 {
+		return fmt.Errorf("invalid vpcC size %d", len(data))
+	}
+
+	v.Version = data[0]
+	if v.Version != 1 {
+		return fmt.Errorf("unsupported vpcC version %d", v.Version)
+	}
+
+	v.Profile = data[4]
+	v.Level = data[5]
+	v.BitDepth = data[6] >> 4
+	v.ChromaSubsampling = data[6] >> 1 & 0x7
+	v.VideoFullRangeFlag = data[6] & 0x1
+	v.ColourPrimaries = data[7]
+	v.TransferCharacteristics = data[8]
+	v.MatrixCoefficients = data[9]
+
+	size := int(binary.BigEndian.Uint16(data[10:]))
+	if size > len(data)-12 {
+		return fmt.Errorf("invalid codecIntializationDataSize %d", size)
+	}
+	v.CodecInitializationData = data[12 : 12+size]
+	return nil
+}
+
+// UpdateFromFrameHeader 根据关键帧头更新配置, level根据分辨率估算
+func (v *VPCodecConfigurationRecord) UpdateFromFrameHeader(header *FrameHeader) {
+	v.Version = 1
+	v.Profile = byte(header.Profile)
+	v.Level = Level(header.Width, header.Height)
+	v.BitDepth = byte(header.BitDepth)
+	v.VideoFullRangeFlag = byte(header.ColorRange)
+
+	switch {
+	case header.SubsamplingX == 0 && header.SubsamplingY == 0:
+		v.ChromaSubsampling = ChromaSubsampling444
+	case header.SubsamplingX == 1 && header.SubsamplingY == 0:
+		v.ChromaSubsampling = ChromaSubsampling422
+	default:
+		v.ChromaSubsampling = ChromaSubsampling420Vertical
+	}
+
+	// 映射到ISO/IEC 23001-8的颜色参数, 2为未指定
+	v.ColourPrimaries, v.TransferCharacteristics, v.MatrixCoefficients = 2, 2, 2
+	switch header.ColorSpace {
+	case ColorSpaceBT601:
+		v.ColourPrimaries, v.TransferCharacteristics, v.MatrixCoefficients = 5, 6, 5
+	case ColorSpaceBT709:
+		v.ColourPrimaries, v.TransferCharacteristics, v.MatrixCoefficients = 1, 1, 1
+	case ColorSpaceSMPTE170:
+		v.ColourPrimaries, v.TransferCharacteristics, v.MatrixCoefficients = 6, 6, 6
+	case ColorSpaceSMPTE240:
+		v.ColourPrimaries, v.TransferCharacteristics, v.MatrixCoefficients = 7, 7, 7
+	case ColorSpaceBT2020:
+		v.ColourPrimaries, v.TransferCharacteristics, v.MatrixCoefficients = 9, 14, 9
+	case ColorSpaceRGB:
+		v.ColourPrimaries, v.TransferCharacteristics, v.MatrixCoefficients = 1, 13, 0
+	}
+}
+
+// Level 根据分辨率返回满足条件的最低等级
+func Level(width, height int) byte {
+	size := width * height
+	for _, l := range levels {
+		if size <= l.pictureSize {
+			return l.level
+		}
+	}
+	return levels[len(levels)-1].level
+}
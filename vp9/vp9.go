@@ -0,0 +1,271 @@
+package vp9
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/lkmio/avformat/bufio"
+)
+
+const (
+	FrameTypeKey    = 0
+	FrameTypeNonKey = 1
+
+	ColorSpaceUnknown  = 0
+	ColorSpaceBT601    = 1
+	ColorSpaceBT709    = 2
+	ColorSpaceSMPTE170 = 3
+	ColorSpaceSMPTE240 = 4
+	ColorSpaceBT2020   = 5
+	ColorSpaceReserved = 6
+	ColorSpaceRGB      = 7
+)
+
+// FrameHeader uncompressed_header, 参考VP9 Bitstream 6.2. 非帧内帧不解析宽高
+type FrameHeader struct {
+	Profile            uint
+	ShowExistingFrame  uint
+	FrameToShowMapIdx  uint
+	FrameType          uint
+	ShowFrame          uint
+	ErrorResilientMode uint
+	IntraOnly          uint
+
+	BitDepth     uint
+	ColorSpace   uint
+	ColorRange   uint
+	SubsamplingX uint
+	SubsamplingY uint
+
+	Width  int
+	Height int
+}
+
+func (h *FrameHeader) IsKeyFrame() bool {
+	return h.ShowExistingFrame == 0 && FrameTypeKey == h.FrameType
+}
+
+// ParseFrameHeader 解析一帧的uncompressed_header, 超级帧需要先拆分
+func ParseFrameHeader(data []byte) (h FrameHeader, err error) {
+	r := &bufio.GolombBitReader{R: bytes.NewReader(data)}
+	var frame_marker uint
+	if frame_marker, err = r.ReadBits(2); err != nil {
+		return
+	} else if frame_marker != 2 {
+		err = fmt.Errorf("invalid vp9 frame_marker %d", frame_marker)
+		return
+	}
+
+	var profile_low_bit, profile_high_bit uint
+	if profile_low_bit, err = r.ReadBit(); err != nil {
+		return
+	}
+	if profile_high_bit, err = r.ReadBit(); err != nil {
+		return
+	}
+	h.Profile = profile_high_bit<<1 | profile_low_bit
+	if h.Profile == 3 {
+		// reserved_zero
+		if _, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	if h.ShowExistingFrame, err = r.ReadBit(); err != nil {
+		return
+	} else if h.ShowExistingFrame != 0 {
+		h.FrameToShowMapIdx, err = r.ReadBits(3)
+		return
+	}
+
+	if h.FrameType, err = r.ReadBit(); err != nil {
+		return
+	}
+	if h.ShowFrame, err = r.ReadBit(); err != nil {
+		return
+	}
+	if h.ErrorResilientMode, err = r.ReadBit(); err != nil {
+		return
+	}
+
+	if FrameTypeKey == h.FrameType {
+		if err = readSyncCode(r); err != nil {
+			return
+		}
+		if err = h.readColorConfig(r); err != nil {
+			return
+		}
+		err = h.readFrameSize(r)
+		return
+	}
+
+	if h.ShowFrame == 0 {
+		if h.IntraOnly, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+	if h.ErrorResilientMode == 0 {
+		// reset_frame_context
+		if _, err = r.ReadBits(2); err != nil {
+			return
+		}
+	}
+	if h.IntraOnly == 0 {
+		return
+	}
+
+	if err = readSyncCode(r); err != nil {
+		return
+	}
+	if h.Profile > 0 {
+		if err = h.readColorConfig(r); err != nil {
+			return
+		}
+	} else {
+		h.BitDepth, h.ColorSpace, h.SubsamplingX, h.SubsamplingY = 8, ColorSpaceBT601, 1, 1
+	}
+
+	// refresh_frame_flags
+	if _, err = r.ReadBits(8); err != nil {
+		return
+	}
+	err = h.readFrameSize(r)
+	return
+}
+
+func readSyncCode(r *bufio.GolombBitReader) error {
+	code, err := r.ReadBits(24)
+	if err != nil {
+		return err
+	} else if code != 0x498342 {
+		return fmt.Errorf("invalid vp9 frame_sync_code %x", code)
+	}
+	return nil
+}
+
+func (h *FrameHeader) readColorConfig(r *bufio.GolombBitReader) (err error) {
+	h.BitDepth = 8
+	if h.Profile >= 2 {
+		var ten_or_twelve_bit uint
+		if ten_or_twelve_bit, err = r.ReadBit(); err != nil {
+			return
+		}
+		h.BitDepth = 10 + 2*ten_or_twelve_bit
+	}
+
+	if h.ColorSpace, err = r.ReadBits(3); err != nil {
+		return
+	}
+
+	if ColorSpaceRGB != h.ColorSpace {
+		if h.ColorRange, err = r.ReadBit(); err != nil {
+			return
+		}
+		if h.Profile == 1 || h.Profile == 3 {
+			if h.SubsamplingX, err = r.ReadBit(); err != nil {
+				return
+			}
+			if h.SubsamplingY, err = r.ReadBit(); err != nil {
+				return
+			}
+			_, err = r.ReadBit()
+		} else {
+			h.SubsamplingX, h.SubsamplingY = 1, 1
+		}
+	} else {
+		h.ColorRange = 1
+		if h.Profile == 1 || h.Profile == 3 {
+			_, err = r.ReadBit()
+		}
+	}
+	return
+}
+
+func (h *FrameHeader) readFrameSize(r *bufio.GolombBitReader) error {
+	width, err := r.ReadBits(16)
+	if err != nil {
+		return err
+	}
+	height, err := r.ReadBits(16)
+	if err != nil {
+		return err
+	}
+
+	h.Width, h.Height = int(width)+1, int(height)+1
+	return nil
+}
+
+// SplitSuperframe 根据superframe index拆分超级帧, 不是超级帧时返回原始数据, 参考附录B
+func SplitSuperframe(data []byte) ([][]byte, error) {
+	if len(data) < 1 {
+		return nil, fmt.Errorf("empty vp9 frame")
+	}
+
+	marker := data[len(data)-1]
+	if marker&0xE0 != 0xC0 {
+		return [][]byte{data}, nil
+	}
+
+	frames := int(marker&0x7) + 1
+	size := int(marker>>3&0x3) + 1
+	indexSize := 2 + size*frames
+	if len(data) < indexSize || data[len(data)-indexSize] != marker {
+		return [][]byte{data}, nil
+	}
+
+	index := data[len(data)-indexSize+1:]
+	payload := data[:len(data)-indexSize]
+	result := make([][]byte, 0, frames)
+	for i := 0; i < frames; i++ {
+		var frameSize int
+		for j := 0; j < size; j++ {
+			frameSize |= int(index[i*size+j]) << (8 * j)
+		}
+
+		if frameSize > len(payload) {
+			return nil, fmt.Errorf("invalid vp9 superframe size %d", frameSize)
+		}
+		result = append(result, payload[:frameSize])
+		payload = payload[frameSize:]
+	}
+
+	return result, nil
+}
+
+// AppendSuperframe 将多帧合并为超级帧
+func AppendSuperframe(dst []byte, frames ...[]byte) []byte {
+	maxSize := 0
+	for _, frame := range frames {
+		dst = append(dst, frame...)
+		maxSize = bufio.MaxInt(maxSize, len(frame))
+	}
+
+	size := 1
+	for maxSize >= 1<<(8*size) {
+		size++
+	}
+
+	marker := byte(0xC0 | (size-1)<<3 | (len(frames) - 1))
+	dst = append(dst, marker)
+	for _, frame := range frames {
+		for j := 0; j < size; j++ {
+			dst = append(dst, byte(len(frame)>>(8*j)))
+		}
+	}
+	return append(dst, marker)
+}
+
+// ParseKeyFrameHeader 返回超级帧中第一个帧的帧头
+func ParseKeyFrameHeader(data []byte) (FrameHeader, error) {
+	frames, err := SplitSuperframe(data)
+	if err != nil {
+		return FrameHeader{}, err
+	}
+
+	return ParseFrameHeader(frames[0])
+}
+
+// IsKeyFrame 超级帧的第一帧是否为关键帧
+func IsKeyFrame(data []byte) bool {
+	header, err := ParseKeyFrameHeader(data)
+	return err == nil && header.IsKeyFrame()
+}
@@ -0,0 +1,72 @@
+package vp9
+
+import (
+	"bytes"
+	"github.com/lkmio/avformat/bufio"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+// 构造1080p Profile 2 10bit BT.2020的关键帧头
+func newKeyFrame() []byte {
+	w := &bufio.BitsWriter{Data: make([]byte, 16)}
+	w.Write(2, 2)         // frame_marker
+	w.Write(2, 1)         // profile_low_bit, profile_high_bit
+	w.Write(1, 0)         // show_existing_frame
+	w.Write(3, 2)         // frame_type, show_frame, error_resilient_mode
+	w.Write(24, 0x498342) // frame_sync_code
+	w.Write(1, 0)         // ten_or_twelve_bit
+	w.Write(3, ColorSpaceBT2020)
+	w.Write(1, 0) // color_range
+	w.Write(16, 1919)
+	w.Write(16, 1079)
+	return w.Data[:(w.Offset+7)/8]
+}
+
+func TestFrameHeader(t *testing.T) {
+	key := newKeyFrame()
+	header, err := ParseFrameHeader(key)
+	if err != nil {
+		panic(err)
+	}
+
+	utils.Assert(header.IsKeyFrame() && header.Profile == 2 && header.BitDepth == 10 && header.ColorSpace == ColorSpaceBT2020)
+	utils.Assert(header.SubsamplingX == 1 && header.SubsamplingY == 1 && header.Width == 1920 && header.Height == 1080)
+
+	// 隐藏的非关键帧和show_existing_frame组成超级帧
+	inter := []byte{0x86, 0x00, 0x00}
+	showExisting := []byte{0x88}
+	superframe := AppendSuperframe(nil, key, inter, showExisting)
+	frames, err := SplitSuperframe(superframe)
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(len(frames) == 3 && bytes.Equal(frames[0], key) && bytes.Equal(frames[2], showExisting))
+	utils.Assert(IsKeyFrame(superframe) && !IsKeyFrame(AppendSuperframe(nil, inter, showExisting)))
+
+	header, err = ParseFrameHeader(showExisting)
+	utils.Assert(err == nil && header.ShowExistingFrame == 1 && !header.IsKeyFrame())
+	_, err = ParseFrameHeader([]byte{0x00})
+	utils.Assert(err != nil)
+}
+
+func TestVPCodecConfigurationRecord(t *testing.T) {
+	header, err := ParseFrameHeader(newKeyFrame())
+	if err != nil {
+		panic(err)
+	}
+
+	record := VPCodecConfigurationRecord{}
+	record.UpdateFromFrameHeader(&header)
+	utils.Assert(record.Profile == 2 && record.Level == 40 && record.BitDepth == 10 && record.ColourPrimaries == 9 && record.MatrixCoefficients == 9)
+
+	data := record.Marshal()
+	utils.Assert(len(data) == 12 && data[0] == 1 && data[6] == 0xA0)
+
+	other := VPCodecConfigurationRecord{}
+	if err = other.Unmarshal(data); err != nil {
+		panic(err)
+	}
+	utils.Assert(bytes.Equal(other.Marshal(), data) && other.Level == 40)
+	utils.Assert(other.Unmarshal(data[:8]) != nil && Level(640, 360) == 21)
+}
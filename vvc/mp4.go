@@ -0,0 +1,351 @@
+package vvc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/lkmio/avformat/avc"
+	"github.com/lkmio/avformat/bufio"
+)
+
+/*
+ISO/IEC 14496-15:2022
+11.2.4.2.2   Syntax
+aligned(8) class VvcDecoderConfigurationRecord {
+bit(5) reserved = '11111'b;
+unsigned int(2) LengthSizeMinusOne;
+unsigned int(1) ptl_present_flag;
+if (ptl_present_flag) {
+unsigned int(9) ols_idx;
+unsigned int(3) num_sublayers;
+unsigned int(2) constant_frame_rate;
+unsigned int(2) chroma_format_idc;
+unsigned int(3) bit_depth_minus8;
+bit(5) reserved = '11111'b;
+VvcPTLRecord(num_sublayers) native_ptl;
+unsigned_int(16) max_picture_width;
+unsigned_int(16) max_picture_height;
+unsigned int(16) avg_frame_rate;
+}
+unsigned int(8) num_of_arrays;
+for (j=0; j < num_of_arrays; j++) {
+unsigned int(1) array_completeness;
+bit(2) reserved = 0;
+unsigned int(5) NAL_unit_type;
+if (NAL_unit_type != DCI_NUT && NAL_unit_type != OPI_NUT)
+unsigned int(16) num_nalus;
+for (i=0; i< num_nalus; i++) {
+unsigned int(16) nal_unit_length;
+bit(8*nal_unit_length) nal_unit;
+}
+}
+}
+
+aligned(8) class VvcPTLRecord(num_sublayers) {
+bit(2) reserved = 0;
+unsigned int(6) num_bytes_constraint_info;
+unsigned int(7) general_profile_idc;
+unsigned int(1) general_tier_flag;
+unsigned int(8) general_level_idc;
+unsigned int(1) ptl_frame_only_constraint_flag;
+unsigned int(1) ptl_multilayer_enabled_flag;
+unsigned int(8*num_bytes_constraint_info - 2) general_constraint_info;
+for (i=num_sublayers - 2; i >= 0; i--)
+unsigned int(1) ptl_sublayer_level_present_flag[i];
+for (j=num_sublayers; j<=8 && num_sublayers > 1; j++)
+bit(1) ptl_reserved_zero_bit = 0;
+for (i=num_sublayers-2; i >= 0; i--)
+if (ptl_sublayer_level_present_flag[i])
+unsigned int(8) sublayer_level_idc[i];
+unsigned int(8) ptl_num_sub_profiles;
+for (j=0; j < ptl_num_sub_profiles; j++)
+unsigned int(32) general_sub_profile_idc[j];
+}
+*/
+
+// VVCDecoderConfigurationRecord vvcC, 不包含FullBox的version和flags. 参数集携带start code
+type VVCDecoderConfigurationRecord struct {
+	LengthSizeMinusOne byte
+	PtlPresentFlag     byte
+	OlsIdx             uint16
+	NumSublayers       byte
+	ConstantFrameRate  byte
+	ChromaFormatIdc    byte
+	BitDepthMinus8     byte
+	NativePTL          ProfileTierLevel
+	MaxPictureWidth    uint16
+	MaxPictureHeight   uint16
+	AvgFrameRate       uint16
+
+	VPSList [][]byte
+	SPSList [][]byte
+	PPSList [][]byte
+}
+
+func (r *VVCDecoderConfigurationRecord) Marshal(vpsList, spsList, ppsList [][]byte) ([]byte, error) {
+	if len(spsList) == 0 {
+		return nil, fmt.Errorf("sps cannot be null")
+	}
+	if len(ppsList) == 0 {
+		return nil, fmt.Errorf("pps cannot be null")
+	}
+
+	data := []byte{0xF8 | (r.LengthSizeMinusOne&0x3)<<1 | r.PtlPresentFlag&0x1}
+	if r.PtlPresentFlag != 0 {
+		data = binary.BigEndian.AppendUint16(data, (r.OlsIdx&0x1FF)<<7|uint16(r.NumSublayers&0x7)<<4|uint16(r.ConstantFrameRate&0x3)<<2|uint16(r.ChromaFormatIdc&0x3))
+		data = append(data, r.BitDepthMinus8<<5|0x1F)
+		data = r.appendPTL(data)
+		data = binary.BigEndian.AppendUint16(data, r.MaxPictureWidth)
+		data = binary.BigEndian.AppendUint16(data, r.MaxPictureHeight)
+		data = binary.BigEndian.AppendUint16(data, r.AvgFrameRate)
+	}
+
+	var arrays byte
+	for _, list := range [][][]byte{vpsList, spsList, ppsList} {
+		if len(list) > 0 {
+			arrays++
+		}
+	}
+	data = append(data, arrays)
+
+	for i, list := range [][][]byte{vpsList, spsList, ppsList} {
+		if len(list) == 0 {
+			continue
+		}
+
+		data = append(data, 0x80|byte(VvcNalVPS+VVCNALUnitType(i)))
+		data = binary.BigEndian.AppendUint16(data, uint16(len(list)))
+		for _, nalu := range list {
+			nalu = avc.RemoveStartCode(nalu)
+			data = binary.BigEndian.AppendUint16(data, uint16(len(nalu)))
+			data = append(data, nalu...)
+		}
+	}
+
+	return data, nil
+}
+
+func (r *VVCDecoderConfigurationRecord) appendPTL(data []byte) []byte {
+	ptl := &r.NativePTL
+	constraintInfo := ptl.ConstraintInfo
+	if len(constraintInfo) == 0 {
+		constraintInfo = []byte{byte(ptl.FrameOnlyConstraintFlag)<<7 | byte(ptl.MultilayerEnabledFlag)<<6}
+	}
+
+	data = append(data, byte(len(constraintInfo))&0x3F, byte(ptl.GeneralProfileIdc)<<1|byte(ptl.GeneralTierFlag), byte(ptl.GeneralLevelIdc))
+	data = append(data, constraintInfo...)
+
+	if r.NumSublayers > 1 {
+		var flags byte
+		for i := int(r.NumSublayers) - 2; i >= 0; i-- {
+			flags = flags<<1 | byte(r.sublayerLevelPresentFlag(i))
+		}
+		data = append(data, flags<<(9-r.NumSublayers))
+
+		for i := int(r.NumSublayers) - 2; i >= 0; i-- {
+			if r.sublayerLevelPresentFlag(i) != 0 {
+				data = append(data, byte(ptl.SublayerLevelIdc[i]))
+			}
+		}
+	}
+
+	data = append(data, byte(len(ptl.GeneralSubProfileIdc)))
+	for _, idc := range ptl.GeneralSubProfileIdc {
+		data = binary.BigEndian.AppendUint32(data, idc)
+	}
+	return data
+}
+
+func (r *VVCDecoderConfigurationRecord) sublayerLevelPresentFlag(i int) uint {
+	if i < len(r.NativePTL.SublayerLevelPresentFlag) && i < len(r.NativePTL.SublayerLevelIdc) {
+		return r.NativePTL.SublayerLevelPresentFlag[i]
+	}
+	return 0
+}
+
+// UpdateFromSPS 根据SPS更新PTL、色度格式、位深和最大宽高, 长度字段固定为4个字节
+func (r *VVCDecoderConfigurationRecord) UpdateFromSPS(sps []byte) error {
+	spsInfo, err := ParseSPS(sps)
+	if err != nil {
+		return err
+	}
+
+	r.LengthSizeMinusOne = 3
+	r.PtlPresentFlag = byte(spsInfo.PtlDpbHrdParamsPresentFlag)
+	r.OlsIdx = 0
+	r.NumSublayers = byte(spsInfo.MaxSublayersMinus1 + 1)
+	r.ChromaFormatIdc = byte(spsInfo.ChromaFormatIdc)
+	r.BitDepthMinus8 = byte(spsInfo.BitDepthMinus8)
+	r.NativePTL = spsInfo.PTL
+	r.MaxPictureWidth = uint16(spsInfo.PicWidthMaxInLumaSamples)
+	r.MaxPictureHeight = uint16(spsInfo.PicHeightMaxInLumaSamples)
+	return nil
+}
+
+func (r *VVCDecoderConfigurationRecord) Unmarshal(data []byte) error {
+	reader := bufio.NewBytesReader(data)
+	b, err := reader.ReadUint8()
+	if err != nil {
+		return err
+	}
+
+	r.LengthSizeMinusOne = b >> 1 & 0x3
+	r.PtlPresentFlag = b & 0x1
+	if r.PtlPresentFlag != 0 {
+		if err = r.unmarshalPTL(reader); err != nil {
+			return err
+		}
+	}
+
+	arrays, err := reader.ReadUint8()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < int(arrays); i++ {
+		header, err := reader.ReadUint8()
+		if err != nil {
+			return err
+		}
+
+		naluType := VVCNALUnitType(header & 0x1F)
+		naluCount := uint16(1)
+		if VvcNalDCI != naluType && VvcNalOPI != naluType {
+			if naluCount, err = reader.ReadUint16(); err != nil {
+				return err
+			}
+		}
+
+		for j := 0; j < int(naluCount); j++ {
+			naluLength, err := reader.ReadUint16()
+			if err != nil {
+				return err
+			}
+
+			bytes, err := reader.ReadBytes(int(naluLength))
+			if err != nil {
+				return err
+			}
+
+			// 添加start code
+			nalu := make([]byte, len(bytes)+4)
+			binary.BigEndian.PutUint32(nalu, 0x1)
+			copy(nalu[4:], bytes)
+
+			if VvcNalVPS == naluType {
+				r.VPSList = append(r.VPSList, nalu)
+			} else if VvcNalSPS == naluType {
+				r.SPSList = append(r.SPSList, nalu)
+			} else if VvcNalPPS == naluType {
+				r.PPSList = append(r.PPSList, nalu)
+			}
+		}
+	}
+
+	if len(r.SPSList) == 0 {
+		return fmt.Errorf("h266parser: no SPS found in VVCDecoderConfRecord")
+	}
+	if len(r.PPSList) == 0 {
+		return fmt.Errorf("h266parser: no PPS found in VVCDecoderConfRecord")
+	}
+	return nil
+}
+
+func (r *VVCDecoderConfigurationRecord) unmarshalPTL(reader bufio.BytesReader) error {
+	value, err := reader.ReadUint16()
+	if err != nil {
+		return err
+	}
+
+	r.OlsIdx = value >> 7
+	r.NumSublayers = byte(value >> 4 & 0x7)
+	r.ConstantFrameRate = byte(value >> 2 & 0x3)
+	r.ChromaFormatIdc = byte(value & 0x3)
+
+	b, err := reader.ReadUint8()
+	if err != nil {
+		return err
+	}
+	r.BitDepthMinus8 = b >> 5
+
+	header, err := reader.ReadBytes(3)
+	if err != nil {
+		return err
+	}
+
+	ptl := ProfileTierLevel{
+		GeneralProfileIdc: uint(header[1] >> 1),
+		GeneralTierFlag:   uint(header[1] & 0x1),
+		GeneralLevelIdc:   uint(header[2]),
+	}
+
+	if header[0]&0x3F == 0 {
+		return fmt.Errorf("invalid num_bytes_constraint_info")
+	} else if ptl.ConstraintInfo, err = reader.ReadBytes(int(header[0] & 0x3F)); err != nil {
+		return err
+	}
+	ptl.FrameOnlyConstraintFlag = uint(ptl.ConstraintInfo[0] >> 7)
+	ptl.MultilayerEnabledFlag = uint(ptl.ConstraintInfo[0] >> 6 & 0x1)
+
+	if r.NumSublayers > 1 {
+		flags, err := reader.ReadUint8()
+		if err != nil {
+			return err
+		}
+
+		n := int(r.NumSublayers) - 1
+		ptl.SublayerLevelPresentFlag = make([]uint, n)
+		ptl.SublayerLevelIdc = make([]uint, n)
+		for i := n - 1; i >= 0; i-- {
+			ptl.SublayerLevelPresentFlag[i] = uint(flags >> 7 & 0x1)
+			flags <<= 1
+		}
+		for i := n - 1; i >= 0; i-- {
+			if ptl.SublayerLevelPresentFlag[i] == 0 {
+				continue
+			}
+
+			idc, err := reader.ReadUint8()
+			if err != nil {
+				return err
+			}
+			ptl.SublayerLevelIdc[i] = uint(idc)
+		}
+	}
+
+	subProfiles, err := reader.ReadUint8()
+	if err != nil {
+		return err
+	}
+	ptl.GeneralSubProfileIdc = make([]uint32, subProfiles)
+	for i := range ptl.GeneralSubProfileIdc {
+		if ptl.GeneralSubProfileIdc[i], err = reader.ReadUint32(); err != nil {
+			return err
+		}
+	}
+
+	r.NativePTL = ptl
+	if r.MaxPictureWidth, err = reader.ReadUint16(); err != nil {
+		return err
+	}
+	if r.MaxPictureHeight, err = reader.ReadUint16(); err != nil {
+		return err
+	}
+	r.AvgFrameRate, err = reader.ReadUint16()
+	return err
+}
+
+// ExtraDataToAnnexB vvcC转为AnnexB格式的参数集
+func ExtraDataToAnnexB(data []byte) ([]byte, error) {
+	record := VVCDecoderConfigurationRecord{}
+	if err := record.Unmarshal(data); err != nil {
+		return nil, err
+	}
+
+	var annexB []byte
+	for _, list := range [][][]byte{record.VPSList, record.SPSList, record.PPSList} {
+		for _, nalu := range list {
+			annexB = append(annexB, nalu...)
+		}
+	}
+	return annexB, nil
+}
@@ -0,0 +1,101 @@
+package vvc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/lkmio/avformat/avc"
+	"github.com/lkmio/avformat/bufio"
+)
+
+// PPS pic_parameter_set_rbsp, 参考H.266 7.3.2.5. 只解析到pps_no_pic_partition_flag
+type PPS struct {
+	Id                              uint
+	SPSId                           uint
+	MixedNaluTypesInPicFlag         uint
+	PicWidthInLumaSamples           uint
+	PicHeightInLumaSamples          uint
+	ConfWinLeftOffset               uint
+	ConfWinRightOffset              uint
+	ConfWinTopOffset                uint
+	ConfWinBottomOffset             uint
+	ScalingWindowExplicitSignalling uint
+	OutputFlagPresentFlag           uint
+	NoPicPartitionFlag              uint
+}
+
+// Size 根据SPS的色度格式计算裁剪后的宽高
+func (p *PPS) Size(sps *SPS) (int, int) {
+	subWidthC, subHeightC := subWidthHeight(sps.ChromaFormatIdc)
+	width := int(p.PicWidthInLumaSamples) - subWidthC*int(p.ConfWinLeftOffset+p.ConfWinRightOffset)
+	height := int(p.PicHeightInLumaSamples) - subHeightC*int(p.ConfWinTopOffset+p.ConfWinBottomOffset)
+	return width, height
+}
+
+func ParsePPS(pps []byte) (p PPS, err error) {
+	pps = avc.RemoveStartCode(pps)
+	if len(pps) < 2 {
+		err = errors.New("incorrect Unit Size")
+		return
+	}
+
+	br := &bufio.GolombBitReader{R: bytes.NewReader(nal2rbsp(pps[2:]))}
+	if p.Id, err = br.ReadBits(6); err != nil {
+		return
+	}
+	if p.SPSId, err = br.ReadBits(4); err != nil {
+		return
+	}
+	if p.MixedNaluTypesInPicFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if p.PicWidthInLumaSamples, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if p.PicHeightInLumaSamples, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+
+	var pps_conformance_window_flag uint
+	if pps_conformance_window_flag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if pps_conformance_window_flag != 0 {
+		if p.ConfWinLeftOffset, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if p.ConfWinRightOffset, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if p.ConfWinTopOffset, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if p.ConfWinBottomOffset, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+
+	if p.ScalingWindowExplicitSignalling, err = br.ReadBit(); err != nil {
+		return
+	}
+	if p.ScalingWindowExplicitSignalling != 0 {
+		// pps_scaling_win_left/right/top/bottom_offset
+		for i := 0; i < 4; i++ {
+			if _, err = br.ReadSE(); err != nil {
+				return
+			}
+		}
+	}
+
+	if p.OutputFlagPresentFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if p.NoPicPartitionFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+
+	if p.PicWidthInLumaSamples == 0 || p.PicHeightInLumaSamples == 0 {
+		err = fmt.Errorf("invalid pps picture size %dx%d", p.PicWidthInLumaSamples, p.PicHeightInLumaSamples)
+	}
+	return
+}
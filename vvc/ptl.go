@@ -0,0 +1,124 @@
+package vvc
+
+import (
+	"github.com/lkmio/avformat/bufio"
+)
+
+// general_constraints_info中gci_present_flag之后, gci_num_additional_bits之前的固定长度
+const gciFixedBits = 71
+
+// ProfileTierLevel profile_tier_level, 参考H.266 7.3.3.1
+type ProfileTierLevel struct {
+	GeneralProfileIdc        uint
+	GeneralTierFlag          uint
+	GeneralLevelIdc          uint
+	FrameOnlyConstraintFlag  uint
+	MultilayerEnabledFlag    uint
+	ConstraintInfo           []byte // ptl_frame_only_constraint_flag开始到general_constraints_info字节对齐, 与vvcC的布局一致
+	SublayerLevelPresentFlag []uint
+	SublayerLevelIdc         []uint
+	GeneralSubProfileIdc     []uint32
+}
+
+// parsePTL 调用前必须字节对齐
+func parsePTL(br *bufio.GolombBitReader, profileTierPresentFlag uint, maxNumSubLayersMinus1 uint) (ptl ProfileTierLevel, err error) {
+	if profileTierPresentFlag != 0 {
+		if ptl.GeneralProfileIdc, err = br.ReadBits(7); err != nil {
+			return
+		}
+		if ptl.GeneralTierFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+	}
+	if ptl.GeneralLevelIdc, err = br.ReadBits(8); err != nil {
+		return
+	}
+
+	// 记录原始bit, 写入vvcC. 最多2+1+71+8+255位再加对齐
+	w := &bufio.BitsWriter{Data: make([]byte, 48)}
+	read := func(n int) (uint, error) {
+		v, err := br.ReadBits(n)
+		if err == nil {
+			w.Write(n, uint64(v))
+		}
+		return v, err
+	}
+
+	if ptl.FrameOnlyConstraintFlag, err = read(1); err != nil {
+		return
+	}
+	if ptl.MultilayerEnabledFlag, err = read(1); err != nil {
+		return
+	}
+
+	if profileTierPresentFlag != 0 {
+		var gci_present_flag uint
+		if gci_present_flag, err = read(1); err != nil {
+			return
+		}
+		if gci_present_flag != 0 {
+			for i := 0; i < gciFixedBits; i += 8 {
+				if _, err = read(bufio.MinInt(8, gciFixedBits-i)); err != nil {
+					return
+				}
+			}
+
+			var gci_num_additional_bits uint
+			if gci_num_additional_bits, err = read(8); err != nil {
+				return
+			}
+			for i := 0; i < int(gci_num_additional_bits); i++ {
+				if _, err = read(1); err != nil {
+					return
+				}
+			}
+		}
+
+		// gci_alignment_zero_bit
+		for br.BufferedBits() != 0 {
+			if _, err = read(1); err != nil {
+				return
+			}
+		}
+		ptl.ConstraintInfo = w.Data[:w.Offset/8]
+	}
+
+	ptl.SublayerLevelPresentFlag = make([]uint, maxNumSubLayersMinus1)
+	ptl.SublayerLevelIdc = make([]uint, maxNumSubLayersMinus1)
+	for i := int(maxNumSubLayersMinus1) - 1; i >= 0; i-- {
+		if ptl.SublayerLevelPresentFlag[i], err = br.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	// ptl_reserved_zero_bit
+	for br.BufferedBits() != 0 {
+		if _, err = br.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	for i := int(maxNumSubLayersMinus1) - 1; i >= 0; i-- {
+		if ptl.SublayerLevelPresentFlag[i] != 0 {
+			if ptl.SublayerLevelIdc[i], err = br.ReadBits(8); err != nil {
+				return
+			}
+		}
+	}
+
+	if profileTierPresentFlag != 0 {
+		var ptl_num_sub_profiles uint
+		if ptl_num_sub_profiles, err = br.ReadBits(8); err != nil {
+			return
+		}
+
+		ptl.GeneralSubProfileIdc = make([]uint32, ptl_num_sub_profiles)
+		for i := range ptl.GeneralSubProfileIdc {
+			if ptl.GeneralSubProfileIdc[i], err = br.ReadBits32(32); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
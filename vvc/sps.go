@@ -0,0 +1,309 @@
+package vvc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/lkmio/avformat/avc"
+	"github.com/lkmio/avformat/bufio"
+)
+
+// SPS seq_parameter_set_rbsp, 参考H.266 7.3.2.4. 只解析到dpb_parameters
+type SPS struct {
+	Id                          uint
+	VPSId                       uint
+	MaxSublayersMinus1          uint
+	ChromaFormatIdc             uint
+	Log2CtuSizeMinus5           uint
+	PtlDpbHrdParamsPresentFlag  uint
+	PTL                         ProfileTierLevel
+	GdrEnabledFlag              uint
+	RefPicResamplingEnabledFlag uint
+	ResChangeInClvsAllowedFlag  uint
+	PicWidthMaxInLumaSamples    uint
+	PicHeightMaxInLumaSamples   uint
+	ConfWinLeftOffset           uint
+	ConfWinRightOffset          uint
+	ConfWinTopOffset            uint
+	ConfWinBottomOffset         uint
+	SubpicInfoPresentFlag       uint
+	NumSubpicsMinus1            uint
+	BitDepthMinus8              uint
+	Log2MaxPicOrderCntLsbMinus4 uint
+	SublayerDpbParamsFlag       uint
+	MaxDecPicBufferingMinus1    []uint
+	MaxNumReorderPics           []uint
+	MaxLatencyIncreasePlus1     []uint
+
+	// 裁剪后的宽高
+	Width  int
+	Height int
+}
+
+func (s *SPS) BitDepth() int {
+	return int(s.BitDepthMinus8) + 8
+}
+
+func (s *SPS) CtbSizeY() uint {
+	return 1 << (s.Log2CtuSizeMinus5 + 5)
+}
+
+// subWidthHeight 色度采样在水平和垂直方向的比例, 参考表2
+func subWidthHeight(chromaFormatIdc uint) (int, int) {
+	switch chromaFormatIdc {
+	case 1:
+		return 2, 2
+	case 2:
+		return 2, 1
+	default:
+		return 1, 1
+	}
+}
+
+func nal2rbsp(nal []byte) []byte {
+	return bytes.Replace(nal, []byte{0x0, 0x0, 0x3}, []byte{0x0, 0x0}, -1)
+}
+
+func ceilLog2(n uint) int {
+	var bits int
+	for (uint(1) << bits) < n {
+		bits++
+	}
+	return bits
+}
+
+func ParseSPS(sps []byte) (s SPS, err error) {
+	sps = avc.RemoveStartCode(sps)
+	if len(sps) < 2 {
+		err = errors.New("incorrect Unit Size")
+		return
+	}
+
+	br := &bufio.GolombBitReader{R: bytes.NewReader(nal2rbsp(sps[2:]))}
+	if s.Id, err = br.ReadBits(4); err != nil {
+		return
+	}
+	if s.VPSId, err = br.ReadBits(4); err != nil {
+		return
+	}
+	if s.MaxSublayersMinus1, err = br.ReadBits(3); err != nil {
+		return
+	} else if s.MaxSublayersMinus1 > 6 {
+		err = fmt.Errorf("invalid sps_max_sublayers_minus1 %d", s.MaxSublayersMinus1)
+		return
+	}
+	if s.ChromaFormatIdc, err = br.ReadBits(2); err != nil {
+		return
+	}
+	if s.Log2CtuSizeMinus5, err = br.ReadBits(2); err != nil {
+		return
+	}
+	if s.PtlDpbHrdParamsPresentFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if s.PtlDpbHrdParamsPresentFlag != 0 {
+		if s.PTL, err = parsePTL(br, 1, s.MaxSublayersMinus1); err != nil {
+			return
+		}
+	}
+
+	if s.GdrEnabledFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if s.RefPicResamplingEnabledFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if s.RefPicResamplingEnabledFlag != 0 {
+		if s.ResChangeInClvsAllowedFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+	}
+	if s.PicWidthMaxInLumaSamples, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if s.PicHeightMaxInLumaSamples, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+
+	var sps_conformance_window_flag uint
+	if sps_conformance_window_flag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if sps_conformance_window_flag != 0 {
+		if s.ConfWinLeftOffset, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if s.ConfWinRightOffset, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if s.ConfWinTopOffset, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if s.ConfWinBottomOffset, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+
+	subWidthC, subHeightC := subWidthHeight(s.ChromaFormatIdc)
+	s.Width = int(s.PicWidthMaxInLumaSamples) - subWidthC*int(s.ConfWinLeftOffset+s.ConfWinRightOffset)
+	s.Height = int(s.PicHeightMaxInLumaSamples) - subHeightC*int(s.ConfWinTopOffset+s.ConfWinBottomOffset)
+
+	if s.SubpicInfoPresentFlag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if s.SubpicInfoPresentFlag != 0 {
+		if err = s.skipSubpicInfo(br); err != nil {
+			return
+		}
+	}
+
+	if s.BitDepthMinus8, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	} else if s.BitDepthMinus8 > 8 {
+		err = fmt.Errorf("invalid sps_bitdepth_minus8 %d", s.BitDepthMinus8)
+		return
+	}
+
+	// sps_entropy_coding_sync_enabled_flag, sps_entry_point_offsets_present_flag
+	if _, err = br.ReadBits(2); err != nil {
+		return
+	}
+	if s.Log2MaxPicOrderCntLsbMinus4, err = br.ReadBits(4); err != nil {
+		return
+	}
+
+	var sps_poc_msb_cycle_flag uint
+	if sps_poc_msb_cycle_flag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if sps_poc_msb_cycle_flag != 0 {
+		if _, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+
+	// sps_num_extra_ph_bytes, sps_num_extra_sh_bytes
+	for i := 0; i < 2; i++ {
+		var extraBytes uint
+		if extraBytes, err = br.ReadBits(2); err != nil {
+			return
+		}
+		if _, err = br.ReadBits(int(extraBytes) * 8); err != nil {
+			return
+		}
+	}
+
+	if s.PtlDpbHrdParamsPresentFlag != 0 {
+		if s.MaxSublayersMinus1 > 0 {
+			if s.SublayerDpbParamsFlag, err = br.ReadBit(); err != nil {
+				return
+			}
+		}
+		err = s.parseDpbParameters(br)
+	}
+	return
+}
+
+// skipSubpicInfo 跳过子图像信息, 参考7.3.2.4
+func (s *SPS) skipSubpicInfo(br *bufio.GolombBitReader) (err error) {
+	if s.NumSubpicsMinus1, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+
+	sps_independent_subpics_flag, sps_subpic_same_size_flag := uint(1), uint(0)
+	if s.NumSubpicsMinus1 > 0 {
+		if sps_independent_subpics_flag, err = br.ReadBit(); err != nil {
+			return
+		}
+		if sps_subpic_same_size_flag, err = br.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	ctbSizeY := s.CtbSizeY()
+	widthBits := ceilLog2((s.PicWidthMaxInLumaSamples + ctbSizeY - 1) / ctbSizeY)
+	heightBits := ceilLog2((s.PicHeightMaxInLumaSamples + ctbSizeY - 1) / ctbSizeY)
+	for i := uint(0); s.NumSubpicsMinus1 > 0 && i <= s.NumSubpicsMinus1; i++ {
+		if sps_subpic_same_size_flag == 0 || i == 0 {
+			if i > 0 && s.PicWidthMaxInLumaSamples > ctbSizeY {
+				if _, err = br.ReadBits(widthBits); err != nil {
+					return
+				}
+			}
+			if i > 0 && s.PicHeightMaxInLumaSamples > ctbSizeY {
+				if _, err = br.ReadBits(heightBits); err != nil {
+					return
+				}
+			}
+			if i < s.NumSubpicsMinus1 && s.PicWidthMaxInLumaSamples > ctbSizeY {
+				if _, err = br.ReadBits(widthBits); err != nil {
+					return
+				}
+			}
+			if i < s.NumSubpicsMinus1 && s.PicHeightMaxInLumaSamples > ctbSizeY {
+				if _, err = br.ReadBits(heightBits); err != nil {
+					return
+				}
+			}
+		}
+		if sps_independent_subpics_flag == 0 {
+			// sps_subpic_treated_as_pic_flag, sps_loop_filter_across_subpic_enabled_flag
+			if _, err = br.ReadBits(2); err != nil {
+				return
+			}
+		}
+	}
+
+	var sps_subpic_id_len_minus1, sps_subpic_id_mapping_explicitly_signalled_flag uint
+	if sps_subpic_id_len_minus1, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if sps_subpic_id_mapping_explicitly_signalled_flag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if sps_subpic_id_mapping_explicitly_signalled_flag != 0 {
+		var sps_subpic_id_mapping_present_flag uint
+		if sps_subpic_id_mapping_present_flag, err = br.ReadBit(); err != nil {
+			return
+		}
+		if sps_subpic_id_mapping_present_flag != 0 {
+			for i := uint(0); i <= s.NumSubpicsMinus1; i++ {
+				if _, err = br.ReadBits(int(sps_subpic_id_len_minus1) + 1); err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// parseDpbParameters dpb_parameters, 参考7.3.4. 未携带的子层使用最高子层的值
+func (s *SPS) parseDpbParameters(br *bufio.GolombBitReader) (err error) {
+	n := s.MaxSublayersMinus1 + 1
+	s.MaxDecPicBufferingMinus1 = make([]uint, n)
+	s.MaxNumReorderPics = make([]uint, n)
+	s.MaxLatencyIncreasePlus1 = make([]uint, n)
+
+	start := s.MaxSublayersMinus1
+	if s.SublayerDpbParamsFlag != 0 {
+		start = 0
+	}
+	for i := start; i <= s.MaxSublayersMinus1; i++ {
+		if s.MaxDecPicBufferingMinus1[i], err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if s.MaxNumReorderPics[i], err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if s.MaxLatencyIncreasePlus1[i], err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+
+	for i := uint(0); i < start; i++ {
+		s.MaxDecPicBufferingMinus1[i] = s.MaxDecPicBufferingMinus1[start]
+		s.MaxNumReorderPics[i] = s.MaxNumReorderPics[start]
+		s.MaxLatencyIncreasePlus1[i] = s.MaxLatencyIncreasePlus1[start]
+	}
+	return
+}
@@ -0,0 +1,122 @@
+package vvc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/lkmio/avformat/avc"
+)
+
+var (
+	StartCode4 = []byte{0x00, 0x00, 0x00, 0x01}
+)
+
+// ParseExtraDataFromKeyNALU 从关键帧中解析出vps/sps/pps, vps可以不存在
+func ParseExtraDataFromKeyNALU(data []byte) ([]byte, []byte, []byte, error) {
+	var vps []byte
+	var sps []byte
+	var pps []byte
+
+	avc.SplitNalU(data, func(nalu []byte) {
+		// NALU header为2字节
+		noStartCodeNALU := avc.RemoveStartCode(nalu)
+		if len(noStartCodeNALU) < 2 {
+			return
+		}
+
+		var dst *[]byte
+		switch NALUnitType(noStartCodeNALU) {
+		case VvcNalVPS:
+			dst = &vps
+		case VvcNalSPS:
+			dst = &sps
+		case VvcNalPPS:
+			dst = &pps
+		default:
+			return
+		}
+
+		*dst = make([]byte, 4+len(noStartCodeNALU))
+		binary.BigEndian.PutUint32(*dst, 0x1)
+		copy((*dst)[4:], noStartCodeNALU)
+	})
+
+	if sps == nil || pps == nil {
+		return nil, nil, nil, fmt.Errorf("not find extra data for H266")
+	}
+	return vps, sps, pps, nil
+}
+
+// IsKeyFrame 跳过参数集、SEI和AUD等非VCL NALU, 第一个VCL NALU是否为IRAP
+func IsKeyFrame(p []byte) bool {
+	var key, vcl bool
+	avc.SplitNalU(p, func(nalu []byte) {
+		if vcl {
+			return
+		}
+
+		nalu = avc.RemoveStartCode(nalu)
+		if len(nalu) < 2 {
+			return
+		}
+
+		t := NALUnitType(nalu)
+		vcl = t.IsVCL()
+		key = t.IsIRAP()
+	})
+	return key
+}
+
+// AnnexB2LengthPrefixed AnnexB转为lengthSize字节长度前缀的格式
+func AnnexB2LengthPrefixed(annexB []byte, lengthSize int) []byte {
+	dst := make([]byte, 0, len(annexB)+16)
+	avc.SplitNalU(annexB, func(nalu []byte) {
+		if len(nalu) < 4 {
+			return
+		}
+
+		nalu = avc.RemoveStartCode(nalu)
+		for i := lengthSize - 1; i >= 0; i-- {
+			dst = append(dst, byte(len(nalu)>>(8*i)))
+		}
+		dst = append(dst, nalu...)
+	})
+	return dst
+}
+
+// Mp4ToAnnexB 长度前缀格式转为AnnexB, 在第一个IRAP前添加extra参数集
+func Mp4ToAnnexB(dst []byte, data, extra []byte, lengthSize int) (int, error) {
+	var n int
+	length, index := len(data), 0
+	gotIRAP := false
+
+	for index < length {
+		if length-index < lengthSize {
+			return -1, fmt.Errorf("invalid data")
+		}
+
+		var nalUnitSize int
+		for i := 0; i < lengthSize; i++ {
+			nalUnitSize = (nalUnitSize << 8) | int(data[index])
+			index++
+		}
+
+		if nalUnitSize < 2 || nalUnitSize > length-index {
+			return -1, fmt.Errorf("invalid data")
+		}
+
+		if NALUnitType(data[index:]).IsIRAP() && !gotIRAP {
+			gotIRAP = true
+			copy(dst[n:], extra)
+			n += len(extra)
+		}
+
+		copy(dst[n:], StartCode4)
+		n += 4
+
+		copy(dst[n:], data[index:index+nalUnitSize])
+		n += nalUnitSize
+		index += nalUnitSize
+	}
+
+	return n, nil
+}
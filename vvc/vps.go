@@ -0,0 +1,157 @@
+package vvc
+
+import (
+	"bytes"
+	"errors"
+	"github.com/lkmio/avformat/avc"
+	"github.com/lkmio/avformat/bufio"
+)
+
+// VPS video_parameter_set_rbsp, 参考H.266 7.3.2.3. 只解析到profile_tier_level
+type VPS struct {
+	Id                         uint
+	MaxLayersMinus1            uint
+	MaxSublayersMinus1         uint
+	DefaultPtlDpbHrdMaxTidFlag uint
+	AllIndependentLayersFlag   uint
+	LayerId                    []uint
+	EachLayerIsAnOlsFlag       uint
+	OlsModeIdc                 uint
+	NumPtlsMinus1              uint
+	PtPresentFlag              []uint
+	PtlMaxTid                  []uint
+	PTLs                       []ProfileTierLevel
+}
+
+func ParseVPS(vps []byte) (v VPS, err error) {
+	vps = avc.RemoveStartCode(vps)
+	if len(vps) < 2 {
+		err = errors.New("incorrect Unit Size")
+		return
+	}
+
+	br := &bufio.GolombBitReader{R: bytes.NewReader(nal2rbsp(vps[2:]))}
+	if v.Id, err = br.ReadBits(4); err != nil {
+		return
+	}
+	if v.MaxLayersMinus1, err = br.ReadBits(6); err != nil {
+		return
+	}
+	if v.MaxSublayersMinus1, err = br.ReadBits(3); err != nil {
+		return
+	}
+
+	v.DefaultPtlDpbHrdMaxTidFlag, v.AllIndependentLayersFlag = 1, 1
+	if v.MaxLayersMinus1 > 0 && v.MaxSublayersMinus1 > 0 {
+		if v.DefaultPtlDpbHrdMaxTidFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+	}
+	if v.MaxLayersMinus1 > 0 {
+		if v.AllIndependentLayersFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	v.LayerId = make([]uint, v.MaxLayersMinus1+1)
+	for i := range v.LayerId {
+		if v.LayerId[i], err = br.ReadBits(6); err != nil {
+			return
+		}
+		if i == 0 || v.AllIndependentLayersFlag != 0 {
+			continue
+		}
+
+		var vps_independent_layer_flag uint
+		if vps_independent_layer_flag, err = br.ReadBit(); err != nil {
+			return
+		} else if vps_independent_layer_flag != 0 {
+			continue
+		}
+
+		var vps_max_tid_ref_present_flag uint
+		if vps_max_tid_ref_present_flag, err = br.ReadBit(); err != nil {
+			return
+		}
+		for j := 0; j < i; j++ {
+			var vps_direct_ref_layer_flag uint
+			if vps_direct_ref_layer_flag, err = br.ReadBit(); err != nil {
+				return
+			}
+			if vps_max_tid_ref_present_flag != 0 && vps_direct_ref_layer_flag != 0 {
+				// vps_max_tid_il_ref_pics_plus1
+				if _, err = br.ReadBits(3); err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	v.EachLayerIsAnOlsFlag, v.OlsModeIdc = 1, 2
+	if v.MaxLayersMinus1 > 0 {
+		if v.AllIndependentLayersFlag != 0 {
+			if v.EachLayerIsAnOlsFlag, err = br.ReadBit(); err != nil {
+				return
+			}
+		} else {
+			v.EachLayerIsAnOlsFlag = 0
+		}
+
+		if v.EachLayerIsAnOlsFlag == 0 {
+			if v.AllIndependentLayersFlag == 0 {
+				if v.OlsModeIdc, err = br.ReadBits(2); err != nil {
+					return
+				}
+			}
+			if v.OlsModeIdc == 2 {
+				var vps_num_output_layer_sets_minus2 uint
+				if vps_num_output_layer_sets_minus2, err = br.ReadBits(8); err != nil {
+					return
+				}
+				// vps_ols_output_layer_flag
+				for i := uint(1); i <= vps_num_output_layer_sets_minus2+1; i++ {
+					if _, err = br.ReadBits(int(v.MaxLayersMinus1) + 1); err != nil {
+						return
+					}
+				}
+			}
+		}
+
+		if v.NumPtlsMinus1, err = br.ReadBits(8); err != nil {
+			return
+		}
+	}
+
+	v.PtPresentFlag = make([]uint, v.NumPtlsMinus1+1)
+	v.PtlMaxTid = make([]uint, v.NumPtlsMinus1+1)
+	for i := range v.PtPresentFlag {
+		v.PtPresentFlag[i] = 1
+		if i > 0 {
+			if v.PtPresentFlag[i], err = br.ReadBit(); err != nil {
+				return
+			}
+		}
+
+		v.PtlMaxTid[i] = v.MaxSublayersMinus1
+		if v.DefaultPtlDpbHrdMaxTidFlag == 0 {
+			if v.PtlMaxTid[i], err = br.ReadBits(3); err != nil {
+				return
+			}
+		}
+	}
+
+	// vps_ptl_alignment_zero_bit
+	for br.BufferedBits() != 0 {
+		if _, err = br.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	v.PTLs = make([]ProfileTierLevel, v.NumPtlsMinus1+1)
+	for i := range v.PTLs {
+		if v.PTLs[i], err = parsePTL(br, v.PtPresentFlag[i], v.PtlMaxTid[i]); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,52 @@
+package vvc
+
+type VVCNALUnitType int
+
+const (
+	VvcNalTrailNUT  = VVCNALUnitType(0)
+	VvcNalSTSANUT   = VVCNALUnitType(1)
+	VvcNalRADLNUT   = VVCNALUnitType(2)
+	VvcNalRASLNUT   = VVCNALUnitType(3)
+	VvcNalRsvVCL4   = VVCNALUnitType(4)
+	VvcNalRsvVCL5   = VVCNALUnitType(5)
+	VvcNalRsvVCL6   = VVCNALUnitType(6)
+	VvcNalIdrWRADL  = VVCNALUnitType(7)
+	VvcNalIdrNLP    = VVCNALUnitType(8)
+	VvcNalCraNUT    = VVCNALUnitType(9)
+	VvcNalGdrNUT    = VVCNALUnitType(10)
+	VvcNalRsvIRAP11 = VVCNALUnitType(11)
+	VvcNalOPI       = VVCNALUnitType(12)
+	VvcNalDCI       = VVCNALUnitType(13)
+	VvcNalVPS       = VVCNALUnitType(14)
+	VvcNalSPS       = VVCNALUnitType(15)
+	VvcNalPPS       = VVCNALUnitType(16)
+	VvcNalPrefixAPS = VVCNALUnitType(17)
+	VvcNalSuffixAPS = VVCNALUnitType(18)
+	VvcNalPH        = VVCNALUnitType(19)
+	VvcNalAUD       = VVCNALUnitType(20)
+	VvcNalEOSNUT    = VVCNALUnitType(21)
+	VvcNalEOBNUT    = VVCNALUnitType(22)
+	VvcNalPrefixSEI = VVCNALUnitType(23)
+	VvcNalSuffixSEI = VVCNALUnitType(24)
+	VvcNalFDNUT     = VVCNALUnitType(25)
+	VvcNalRsvNVCL26 = VVCNALUnitType(26)
+	VvcNalRsvNVCL27 = VVCNALUnitType(27)
+	VvcNalUnspec28  = VVCNALUnitType(28)
+	VvcNalUnspec29  = VVCNALUnitType(29)
+	VvcNalUnspec30  = VVCNALUnitType(30)
+	VvcNalUnspec31  = VVCNALUnitType(31)
+)
+
+// NALUnitType 读取NAL头中的类型, NAL头为2个字节, 类型位于第二个字节的高5位
+func NALUnitType(header []byte) VVCNALUnitType {
+	return VVCNALUnitType(header[1] >> 3)
+}
+
+// IsIRAP IDR_W_RADL到RSV_IRAP_11
+func (t VVCNALUnitType) IsIRAP() bool {
+	return t >= VvcNalIdrWRADL && t <= VvcNalRsvIRAP11
+}
+
+func (t VVCNALUnitType) IsVCL() bool {
+	return t <= VvcNalRsvIRAP11
+}
@@ -0,0 +1,179 @@
+package vvc
+
+import (
+	"bytes"
+	"github.com/lkmio/avformat/bufio"
+	"github.com/lkmio/avformat/utils"
+	"testing"
+)
+
+func writeUE(w *bufio.BitsWriter, v uint64) {
+	length := 0
+	for tmp := v + 1; tmp > 1; tmp >>= 1 {
+		length++
+	}
+	w.Write(length, 0)
+	w.Write(length+1, v+1)
+}
+
+// Main 10, level 5.1, 2个子层
+func writePTL(w *bufio.BitsWriter, gci bool) {
+	w.Write(7, 1)  // general_profile_idc
+	w.Write(1, 0)  // general_tier_flag
+	w.Write(8, 83) // general_level_idc
+	w.Write(1, 1)  // ptl_frame_only_constraint_flag
+	w.Write(1, 0)  // ptl_multilayer_enabled_flag
+	if gci {
+		w.Write(1, 1)
+		w.Write(71, 0)
+		w.Write(8, 3) // gci_num_additional_bits
+		w.Write(3, 5)
+		w.Write(3, 0) // gci_alignment_zero_bit
+	} else {
+		w.Write(6, 0)
+	}
+	w.Write(8, 0x80) // ptl_sublayer_level_present_flag[0], ptl_reserved_zero_bit
+	w.Write(8, 80)   // sublayer_level_idc[0]
+	w.Write(8, 0)    // ptl_num_sub_profiles
+}
+
+// 构造1080p 10bit 4:2:0的SPS
+func newSPS(gci bool) []byte {
+	w := &bufio.BitsWriter{Data: make([]byte, 64)}
+	w.Write(16, 0x0079)
+	w.Write(4, 0) // sps_seq_parameter_set_id
+	w.Write(4, 1) // sps_video_parameter_set_id
+	w.Write(3, 1) // sps_max_sublayers_minus1
+	w.Write(2, 1) // sps_chroma_format_idc
+	w.Write(2, 2) // sps_log2_ctu_size_minus5
+	w.Write(1, 1) // sps_ptl_dpb_hrd_params_present_flag
+	writePTL(w, gci)
+	w.Write(2, 0) // sps_gdr_enabled_flag, sps_ref_pic_resampling_enabled_flag
+	writeUE(w, 1920)
+	writeUE(w, 1088)
+	w.Write(1, 1) // sps_conformance_window_flag
+	writeUE(w, 0)
+	writeUE(w, 0)
+	writeUE(w, 0)
+	writeUE(w, 4)
+	w.Write(1, 0) // sps_subpic_info_present_flag
+	writeUE(w, 2) // sps_bitdepth_minus8
+	w.Write(2, 0)
+	w.Write(4, 4) // sps_log2_max_pic_order_cnt_lsb_minus4
+	w.Write(1, 0) // sps_poc_msb_cycle_flag
+	w.Write(4, 0) // sps_num_extra_ph_bytes, sps_num_extra_sh_bytes
+	w.Write(1, 0) // sps_sublayer_dpb_params_flag
+	writeUE(w, 4)
+	writeUE(w, 2)
+	writeUE(w, 0)
+	w.Write(1, 1)
+	return w.Data[:(w.Offset+7)/8]
+}
+
+func newVPS() []byte {
+	w := &bufio.BitsWriter{Data: make([]byte, 64)}
+	w.Write(16, 0x0071)
+	w.Write(4, 1) // vps_video_parameter_set_id
+	w.Write(6, 0) // vps_max_layers_minus1
+	w.Write(3, 1) // vps_max_sublayers_minus1
+	w.Write(6, 0) // vps_layer_id[0]
+	w.Write(5, 0) // vps_ptl_alignment_zero_bit
+	writePTL(w, false)
+	w.Write(1, 1)
+	return w.Data[:(w.Offset+7)/8]
+}
+
+func newPPS() []byte {
+	w := &bufio.BitsWriter{Data: make([]byte, 64)}
+	w.Write(16, 0x0081)
+	w.Write(6, 0) // pps_pic_parameter_set_id
+	w.Write(4, 0) // pps_seq_parameter_set_id
+	w.Write(1, 0) // pps_mixed_nalu_types_in_pic_flag
+	writeUE(w, 1920)
+	writeUE(w, 1088)
+	w.Write(1, 1)
+	writeUE(w, 0)
+	writeUE(w, 0)
+	writeUE(w, 0)
+	writeUE(w, 4)
+	w.Write(3, 1) // pps_scaling_window_explicit_signalling_flag, pps_output_flag_present_flag, pps_no_pic_partition_flag
+	w.Write(1, 1)
+	return w.Data[:(w.Offset+7)/8]
+}
+
+func TestParameterSets(t *testing.T) {
+	sps, err := ParseSPS(newSPS(false))
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(sps.Width == 1920 && sps.Height == 1080 && sps.BitDepth() == 10 && sps.ChromaFormatIdc == 1 && sps.VPSId == 1)
+	utils.Assert(sps.PTL.GeneralProfileIdc == 1 && sps.PTL.GeneralLevelIdc == 83 && sps.PTL.FrameOnlyConstraintFlag == 1 && sps.PTL.SublayerLevelIdc[0] == 80)
+	utils.Assert(bytes.Equal(sps.PTL.ConstraintInfo, []byte{0x80}) && sps.MaxNumReorderPics[0] == 2 && sps.MaxNumReorderPics[1] == 2)
+
+	sps, err = ParseSPS(newSPS(true))
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(sps.Width == 1920 && sps.Height == 1080 && sps.BitDepth() == 10 && len(sps.PTL.ConstraintInfo) == 11)
+
+	vps, err := ParseVPS(newVPS())
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(vps.Id == 1 && vps.MaxSublayersMinus1 == 1 && len(vps.PTLs) == 1 && vps.PTLs[0].GeneralLevelIdc == 83 && vps.PTLs[0].SublayerLevelIdc[0] == 80)
+
+	pps, err := ParsePPS(newPPS())
+	if err != nil {
+		panic(err)
+	}
+	width, height := pps.Size(&sps)
+	utils.Assert(pps.Id == 0 && pps.NoPicPartitionFlag == 1 && width == 1920 && height == 1080)
+}
+
+func TestVVCDecoderConfigurationRecord(t *testing.T) {
+	start := []byte{0, 0, 0, 1}
+	vps := append(append([]byte{}, start...), newVPS()...)
+	sps := append(append([]byte{}, start...), newSPS(true)...)
+	pps := append(append([]byte{}, start...), newPPS()...)
+
+	record := VVCDecoderConfigurationRecord{}
+	if err := record.UpdateFromSPS(sps); err != nil {
+		panic(err)
+	}
+	data, err := record.Marshal([][]byte{vps}, [][]byte{sps}, [][]byte{pps})
+	if err != nil {
+		panic(err)
+	}
+	utils.Assert(data[0] == 0xFF && record.NumSublayers == 2 && record.MaxPictureHeight == 1088)
+
+	other := VVCDecoderConfigurationRecord{}
+	if err = other.Unmarshal(data); err != nil {
+		panic(err)
+	}
+	utils.Assert(other.BitDepthMinus8 == 2 && other.ChromaFormatIdc == 1 && other.NativePTL.GeneralLevelIdc == 83 && other.NativePTL.SublayerLevelIdc[0] == 80)
+	utils.Assert(bytes.Equal(other.NativePTL.ConstraintInfo, record.NativePTL.ConstraintInfo) && bytes.Equal(other.SPSList[0], sps))
+
+	marshal, _ := other.Marshal(other.VPSList, other.SPSList, other.PPSList)
+	utils.Assert(bytes.Equal(marshal, data))
+
+	annexB, err := ExtraDataToAnnexB(data)
+	utils.Assert(err == nil && bytes.Equal(annexB, append(append(append([]byte{}, vps...), sps...), pps...)))
+
+	// 关键帧和长度前缀格式互转
+	idr := append(append([]byte{}, start...), 0x00, byte(VvcNalIdrNLP)<<3|1, 0xAA, 0xBB)
+	trail := append(append([]byte{}, start...), 0x00, byte(VvcNalTrailNUT)<<3|1, 0xCC, 0xDD)
+	key := append(append([]byte{}, annexB...), idr...)
+	utils.Assert(IsKeyFrame(key) && !IsKeyFrame(trail))
+	// 只有1字节的NALU不能读取header
+	utils.Assert(!IsKeyFrame([]byte{0, 0, 1, 0x30}) && IsKeyFrame(append([]byte{0, 0, 1, 0x30}, idr...)))
+	_, _, _, err = ParseExtraDataFromKeyNALU([]byte{0, 0, 1, 0x30})
+	utils.Assert(err != nil)
+
+	_, s, p, err := ParseExtraDataFromKeyNALU(key)
+	utils.Assert(err == nil && bytes.Equal(s, sps) && bytes.Equal(p, pps))
+
+	lengthPrefixed := AnnexB2LengthPrefixed(append(idr, trail...), 4)
+	dst := make([]byte, 256)
+	n, err := Mp4ToAnnexB(dst, lengthPrefixed, annexB, 4)
+	utils.Assert(err == nil && bytes.Equal(dst[:n], append(append(append([]byte{}, annexB...), idr...), trail...)))
+}